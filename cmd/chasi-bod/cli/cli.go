@@ -12,13 +12,16 @@ import (
 	"github.com/spf13/cobra"                        // Using Cobra for CLI structure / 使用 Cobra 构建 CLI 结构
 	"github.com/turtacn/chasi-bod/common/constants" // Assuming constants are here // 假设常量在这里
 	"github.com/turtacn/chasi-bod/common/errors"    // Assuming custom errors are here // 假设自定义错误在这里
-	"github.com/turtacn/chasi-bod/common/utils"     // Assuming logger is here // 假设日志记录器在这里
-	"github.com/turtacn/chasi-bod/pkg/application"  // Import application package // 导入应用程序包
-	// "github.com/turtacn/chasi-bod/pkg/builder" // Assuming builder package exists // 假设 builder 包存在
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"                        // Assuming logger is here // 假设日志记录器在这里
+	"github.com/turtacn/chasi-bod/pkg/application"                     // Import application package // 导入应用程序包
+	"github.com/turtacn/chasi-bod/pkg/builder"                         // Build orchestrator // 构建协调器
 	"github.com/turtacn/chasi-bod/pkg/config/loader"                   // Assuming config loader exists // 假设配置加载器存在
 	"github.com/turtacn/chasi-bod/pkg/config/model"                    // Import config model // 导入配置模型
 	"github.com/turtacn/chasi-bod/pkg/config/validator"                // Assuming config validator exists // 假设配置校验器存在
 	"github.com/turtacn/chasi-bod/pkg/deployer"                        // Assuming deployer package exists // 假设 deployer 包存在
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"               // For commands that inspect a single node // 用于检查单个节点的命令
+	"github.com/turtacn/chasi-bod/pkg/deployer/phases"                 // For the exported kubeconfig path and sysctl helpers // 用于导出的 kubeconfig 路径和 sysctl 辅助函数
 	"github.com/turtacn/chasi-bod/pkg/dfx/healthz"                     // Import healthz package // 导入 healthz 包
 	"github.com/turtacn/chasi-bod/pkg/lifecycle"                       // Assuming lifecycle package exists // 假设生命周期包存在
 	vcluster_mgr "github.com/turtacn/chasi-bod/pkg/vcluster"           // Alias for vcluster manager // vcluster manager 别名
@@ -74,13 +77,79 @@ func init() {
 	// 添加子命令
 	RootCmd.AddCommand(buildCmd)
 	RootCmd.AddCommand(deployCmd)
+	RootCmd.AddCommand(preflightCmd)
 	RootCmd.AddCommand(upgradeCmd)
+	RootCmd.AddCommand(applyCmd)
 	RootCmd.AddCommand(scaleCmd)
+	RootCmd.AddCommand(nodeCmd) // Base command for single-node operations // 单节点操作的基本命令
 	RootCmd.AddCommand(backupCmd)
 	RootCmd.AddCommand(restoreCmd)
 	RootCmd.AddCommand(healthzCmd)     // Add healthz command // 添加 healthz 命令
 	RootCmd.AddCommand(vclusterCmd)    // Base command for vcluster operations // vcluster 操作的基本命令
 	RootCmd.AddCommand(applicationCmd) // Base command for application operations // 应用程序操作的基本命令
+
+	// Commands that operate on many nodes share the parallelism flags
+	// 操作多个节点的命令共享并行度标志
+	for _, cmd := range []*cobra.Command{deployCmd, upgradeCmd, applyCmd, scaleCmd} {
+		addParallelFlags(cmd)
+	}
+
+	// Plan and safety flags for apply (and its deprecated alias scale)
+	// apply（及其已弃用的别名 scale）的计划和安全标志
+	for _, cmd := range []*cobra.Command{applyCmd, scaleCmd} {
+		cmd.Flags().Bool("dry-run", false, "Print the plan without changing the cluster")
+		cmd.Flags().StringP("output", "o", "table", "Output format of the plan (table or json)")
+		cmd.Flags().Bool("allow-master-removal", false, "Permit removing masters; the etcd quorum checks still apply")
+		cmd.Flags().Duration("drain-timeout", constants.DefaultDrainTimeout, "How long PodDisruptionBudgets may block the drain of a removed node")
+		cmd.Flags().Bool("force-drain", false, "Delete pods without a controller or still blocked after --drain-timeout, and ignore cleanup failures of removed nodes")
+	}
+
+	// Output format of the node sysctl diff
+	// node sysctl diff 的输出格式
+	nodeSysctlDiffCmd.Flags().StringP("output", "o", "table", "Output format of the diff (table or json)")
+
+	// Checkpoint and phase selection flags for deploy
+	// deploy 的检查点和阶段选择标志
+	phaseNames := strings.Join(deployer.PhaseOrder, ", ")
+	deployCmd.Flags().Bool("resume", false, "Resume the last deployment, skipping steps the checkpoint records as completed")
+	deployCmd.Flags().Bool("force", false, "With --resume, continue even if the configuration changed since the checkpoint was written")
+	deployCmd.Flags().String("from-phase", "", "Start the deployment at this phase (built-in: "+phaseNames+", or a custom phase name)")
+	deployCmd.Flags().String("only-phase", "", "Run only this phase (built-in: "+phaseNames+", or a custom phase name)")
+	deployCmd.Flags().Bool("skip-preflight", false, "Do not run the preflight checks before the initialization phase")
+
+	// Workspace flags for build
+	// build 的工作区标志
+	buildCmd.Flags().String("workdir", "", "Directory the build workspace is created in (defaults to the system temporary directory)")
+	buildCmd.Flags().Bool("keep-workdir", false, "Keep the build workspace after the build, successful or not, for debugging")
+
+	// Output format for the preflight report
+	// 预检报告的输出格式
+	preflightCmd.Flags().StringP("output", "o", "table", "Output format of the preflight report (table or json)")
+
+	// Dry-run flags for deploy
+	// deploy 的试运行标志
+	deployCmd.Flags().Bool("dry-run", false, "Print the plan of every remote action instead of deploying")
+	deployCmd.Flags().StringP("output", "o", "yaml", "Output format of the dry-run plan (yaml or json)")
+	deployCmd.Flags().Bool("diff", true, "With --dry-run, read the current files on the nodes and include diffs (--diff=false plans without contacting the nodes)")
+}
+
+// addParallelFlags registers the flags controlling how node operations fan out.
+// addParallelFlags 注册控制节点操作如何并行展开的标志。
+func addParallelFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("max-parallel", constants.DefaultMaxParallel, "Maximum number of nodes to operate on at the same time")
+	cmd.Flags().String("failure-policy", string(enum.FailurePolicyFailFast), "What to do when a node fails: 'fail-fast' cancels the other nodes, 'continue' lets them finish and reports all failures")
+}
+
+// parallelOptionsFromFlags reads the parallelism flags registered by addParallelFlags.
+// parallelOptionsFromFlags 读取由 addParallelFlags 注册的并行度标志。
+func parallelOptionsFromFlags(cmd *cobra.Command) (deployer.ParallelOptions, error) {
+	maxParallel, _ := cmd.Flags().GetInt("max-parallel")
+	failurePolicy, _ := cmd.Flags().GetString("failure-policy")
+	opts := deployer.ParallelOptions{MaxParallel: maxParallel, FailurePolicy: enum.FailurePolicy(failurePolicy)}
+	if err := opts.Validate(); err != nil {
+		return opts, fmt.Errorf("invalid parallelism flags: %w", err)
+	}
+	return opts, nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -100,9 +169,6 @@ var buildCmd = &cobra.Command{
 	Short: "Build the chasi-bod platform image",
 	Long:  `Builds the reproducible chasi-bod platform image based on the configuration.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// ctx, cancel := context.WithTimeout(cmd.Context(), constants.DefaultTimeout*2) // Example timeout for build // 示例构建超时时间
-		// defer cancel()
-
 		// Load configuration
 		// 加载配置
 		config, err := loader.LoadConfig(configFilePath)
@@ -118,20 +184,23 @@ var buildCmd = &cobra.Command{
 
 		// Create a new builder orchestrator
 		// 创建一个新的 builder 协调器
-		// bldr, err := builder.NewBuilder() // Assuming NewBuilder exists // 假设 NewBuilder 存在
-		// if err != nil {
-		// 	return fmt.Errorf("failed to create builder: %w", err)
-		// }
+		workDir, _ := cmd.Flags().GetString("workdir")
+		keepWorkDir, _ := cmd.Flags().GetBool("keep-workdir")
+		bldr, err := builder.NewBuilder(builder.WithWorkDir(workDir), builder.WithKeepWorkDir(keepWorkDir))
+		if err != nil {
+			return fmt.Errorf("failed to create builder: %w", err)
+		}
 
-		// Run the build process
-		// 运行构建过程
+		// Run the build process; it can take long, so only the command context bounds it
+		// 运行构建过程；构建可能耗时较长，因此仅由命令上下文限制
 		utils.GetLogger().Println("Starting platform image build...")
-		// outputPath, err := bldr.Build(ctx, config) // Assuming Build method exists // 假设 Build 方法存在
-		// if err != nil {
-		// 	return fmt.Errorf("platform image build failed: %w", err)
-		// }
+		result, err := bldr.Build(cmd.Context(), config)
+		if err != nil {
+			return fmt.Errorf("platform image build failed: %w", err)
+		}
 
-		utils.GetLogger().Printf("Platform image built successfully at: %s", "placeholder")
+		fmt.Fprint(cmd.OutOrStdout(), result.Summary())
+		utils.GetLogger().Printf("Platform image built successfully at: %s", result.ArtifactPath)
 		return nil
 	},
 }
@@ -159,13 +228,43 @@ var deployCmd = &cobra.Command{
 			return fmt.Errorf("config validation failed: %w", err)
 		}
 
+		parallelOpts, err := parallelOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		resume, _ := cmd.Flags().GetBool("resume")
+		force, _ := cmd.Flags().GetBool("force")
+		fromPhase, _ := cmd.Flags().GetString("from-phase")
+		onlyPhase, _ := cmd.Flags().GetString("only-phase")
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+		runOpts := deployer.RunOptions{Resume: resume, Force: force, FromPhase: fromPhase, OnlyPhase: onlyPhase, SkipPreflight: skipPreflight}
+
 		// Create a new deployer orchestrator
 		// 创建一个新的 deployer 协调器
-		dplr, err := deployer.NewDeployer() // Assuming NewDeployer exists // 假设 NewDeployer 存在
+		dplr, err := deployer.NewDeployer(deployer.WithParallelOptions(parallelOpts), deployer.WithRunOptions(runOpts))
 		if err != nil {
 			return fmt.Errorf("failed to create deployer: %w", err)
 		}
 
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			output, _ := cmd.Flags().GetString("output")
+			diff, _ := cmd.Flags().GetBool("diff")
+			// Keep standard output for the plan itself so it can be redirected into a file and reviewed.
+			// 标准输出只保留计划本身，以便可以重定向到文件中进行审查。
+			utils.GetLogger().SetOutput(os.Stderr)
+			deployPlan, err := dplr.Plan(ctx, config, deployer.PlanOptions{Diff: diff})
+			if err != nil {
+				return fmt.Errorf("failed to plan deployment: %w", err)
+			}
+			rendered, err := deployPlan.Render(output)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(rendered)
+			return err
+		}
+
 		// Run the deployment process
 		// 运行部署过程
 		utils.GetLogger().Println("Starting platform deployment...")
@@ -178,6 +277,47 @@ var deployCmd = &cobra.Command{
 	},
 }
 
+// preflightCmd represents the preflight command.
+// preflightCmd 表示 preflight 命令。
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check that the target nodes meet the platform requirements",
+	Long: `Runs the read-only preflight checks of the deployment against every node in the configuration:
+SSH reachability, OS and kernel version, swap, kernel modules, free ports, CPU, memory and disk,
+hostname uniqueness, clock drift and cgroup version. Exits with an error if any check fails.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(cmd.Context(), constants.DefaultTimeout)
+		defer cancel()
+
+		config, err := loader.LoadConfig(configFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if err := validator.ValidateConfig(config); err != nil {
+			return fmt.Errorf("config validation failed: %w", err)
+		}
+
+		dplr, err := deployer.NewDeployer()
+		if err != nil {
+			return fmt.Errorf("failed to create deployer: %w", err)
+		}
+
+		// Keep standard output for the report so that it can be piped into other tools.
+		// 标准输出只保留报告，以便可以通过管道传给其他工具。
+		utils.GetLogger().SetOutput(os.Stderr)
+		report := dplr.Preflight(ctx, config)
+		output, _ := cmd.Flags().GetString("output")
+		rendered, err := report.Render(output)
+		if err != nil {
+			return err
+		}
+		if _, err := cmd.OutOrStdout().Write(rendered); err != nil {
+			return err
+		}
+		return report.Err()
+	},
+}
+
 // upgradeCmd represents the upgrade command.
 // upgradeCmd 表示 upgrade 命令。
 var upgradeCmd = &cobra.Command{
@@ -216,9 +356,14 @@ var upgradeCmd = &cobra.Command{
 			return fmt.Errorf("new config validation failed: %w", err)
 		}
 
+		parallelOpts, err := parallelOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
 		// Create necessary managers
 		// 创建必要的管理器
-		dplr, err := deployer.NewDeployer()
+		dplr, err := deployer.NewDeployer(deployer.WithParallelOptions(parallelOpts))
 		if err != nil {
 			return fmt.Errorf("failed to create deployer: %w", err)
 		}
@@ -238,7 +383,7 @@ var upgradeCmd = &cobra.Command{
 
 		// Create a new lifecycle manager
 		// 创建一个新的生命周期管理器
-		lifecycleMgr := lifecycle.NewManager(dplr, vclusterMgr) // Pass all dependencies
+		lifecycleMgr := lifecycle.NewManager(dplr, vclusterMgr, lifecycle.WithParallelOptions(parallelOpts)) // Pass all dependencies
 
 		// Run the upgrade process
 		// 运行升级过程
@@ -257,77 +402,226 @@ var upgradeCmd = &cobra.Command{
 	},
 }
 
+// applyCmd represents the apply command.
+// applyCmd 表示 apply 命令。
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Make the Host Cluster nodes match the configuration",
+	Long: `Compares the nodes in the configuration with the nodes registered in the Host Cluster and the
+node inventory recorded by the last deploy or apply. Declared nodes that are missing are added,
+recorded nodes that are no longer declared are removed, and nodes whose labels, annotations or
+taints differ are updated. The plan is printed first; changes to the masters are checked against
+the etcd quorum, and removing masters requires --allow-master-removal.`,
+	RunE: runApply,
+}
+
 // scaleCmd represents the scale command.
 // scaleCmd 表示 scale 命令。
 var scaleCmd = &cobra.Command{
-	Use:   "scale",
-	Short: "Scale the chasi-bod Host Cluster",
-	Long:  `Scales the underlying Host Kubernetes cluster by adding or removing nodes based on the configuration.`,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx, cancel := context.WithTimeout(cmd.Context(), constants.DefaultTimeout*5) // Example timeout for scale // 示例扩缩容超时时间
-		defer cancel()
+	Use:        "scale",
+	Short:      "Scale the chasi-bod Host Cluster",
+	Long:       `Scales the underlying Host Kubernetes cluster by adding or removing nodes based on the configuration.`,
+	Deprecated: "use 'chasi-bod apply' instead.",
+	RunE:       runApply,
+}
 
-		// Load configuration (represents the desired state after scaling)
-		// 加载配置（表示扩缩容后的期望状态）
-		newConfig, err := loader.LoadConfig(configFilePath)
-		if err != nil {
-			return fmt.Errorf("failed to load new config from %s: %w", configFilePath, err)
-		}
+// runApply computes the scale plan from the configuration, prints it and applies it.
+// runApply 根据配置计算扩缩容计划，打印并应用它。
+func runApply(cmd *cobra.Command, args []string) error {
+	ctx, cancel := context.WithTimeout(cmd.Context(), constants.DefaultTimeout*5) // Example timeout for scale // 示例扩缩容超时时间
+	defer cancel()
 
-		// Validate configuration
-		// 校验配置
-		if err := validator.ValidateConfig(newConfig); err != nil {
-			return fmt.Errorf("new config validation failed: %w", err)
-		}
+	// Load configuration (represents the desired state after scaling)
+	// 加载配置（表示扩缩容后的期望状态）
+	newConfig, err := loader.LoadConfig(configFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to load new config from %s: %w", configFilePath, err)
+	}
 
-		// TODO: Load current cluster state to determine nodesToAdd/nodesToRemove
-		// This is complex, might involve querying the cluster directly or reading state file.
-		// For simplicity, let's assume the user provides nodesToAdd/nodesToRemove via flags or a separate file.
-		// TODO: 加载当前集群状态以确定要添加/移除的节点
-		// 这很复杂，可能涉及直接查询集群或读取状态文件。
-		// 为简单起见，我们假设用户通过标志或单独的文件提供要添加/移除的节点。
-		var nodesToAdd []model.NodeConfig    // Placeholder - populate from flags or config comparison // 占位符 - 从标志或配置比较中填充
-		var nodesToRemove []model.NodeConfig // Placeholder - populate from flags or config comparison // 占位符 - 从标志或配置比较中填充
+	// Validate configuration
+	// 校验配置
+	if err := validator.ValidateConfig(newConfig); err != nil {
+		return fmt.Errorf("new config validation failed: %w", err)
+	}
 
-		utils.GetLogger().Println("Placeholder: Determining nodes to add/remove (needs current state logic or input).")
+	parallelOpts, err := parallelOptionsFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	output, _ := cmd.Flags().GetString("output")
+	allowMasterRemoval, _ := cmd.Flags().GetBool("allow-master-removal")
+	drainTimeout, _ := cmd.Flags().GetDuration("drain-timeout")
+	forceDrain, _ := cmd.Flags().GetBool("force-drain")
 
-		// Create necessary managers (deployer, builder, vcluster manager)
-		// 创建必要的管理器（deployer, builder, vcluster manager）
-		dplr, err := deployer.NewDeployer()
+	// Keep standard output for the plan so that it can be reviewed or piped into other tools.
+	// 标准输出只保留计划，以便可以审查或通过管道传给其他工具。
+	utils.GetLogger().SetOutput(os.Stderr)
+
+	hostK8sClient, err := hostK8sClientFor(newConfig)
+	if err != nil {
+		return fmt.Errorf("failed to get host K8s client: %w", err)
+	}
+
+	// The recorded inventory tells which nodes chasi-bod manages, and keeps the SSH settings of removed nodes.
+	// 记录的清单说明 chasi-bod 管理哪些节点，并保存已移除节点的 SSH 设置。
+	var recorded []model.NodeConfig
+	inventoryPath := deployer.InventoryPath(deployer.DefaultInventoryDir, newConfig.Metadata.Name)
+	inventory, err := deployer.LoadInventory(inventoryPath)
+	switch {
+	case err == nil:
+		recorded = inventory.Nodes
+	case errors.IsChasiBodError(err, errors.ErrTypeNotFound):
+		utils.GetLogger().Printf("No node inventory at %s; no nodes will be removed.", inventoryPath)
+	default:
+		return err
+	}
+
+	liveNodes, err := lifecycle.ListHostNodes(ctx, hostK8sClient)
+	if err != nil {
+		return err
+	}
+	scalePlan, err := lifecycle.ComputeScalePlan(newConfig, recorded, liveNodes)
+	if err != nil {
+		return err
+	}
+	quorumErr := scalePlan.CheckQuorum(lifecycle.QuorumOptions{AllowMasterRemoval: allowMasterRemoval})
+	rendered, err := scalePlan.Render(output)
+	if err != nil {
+		return err
+	}
+	if _, err := cmd.OutOrStdout().Write(rendered); err != nil {
+		return err
+	}
+	if quorumErr != nil {
+		return quorumErr
+	}
+	if dryRun {
+		return nil
+	}
+	if !scalePlan.HasChanges() {
+		utils.GetLogger().Println("Host Cluster already matches the configuration.")
+		return nil
+	}
+
+	// Create necessary managers (deployer, vcluster manager)
+	// 创建必要的管理器（deployer, vcluster manager）
+	dplr, err := deployer.NewDeployer(deployer.WithParallelOptions(parallelOpts), deployer.WithHostK8sClient(hostK8sClient),
+		deployer.WithDrainOptions(deployer.DrainOptions{Timeout: drainTimeout, Force: forceDrain}))
+	if err != nil {
+		return fmt.Errorf("failed to create deployer: %w", err)
+	}
+	vclusterMgr := vcluster_mgr.NewManager(hostK8sClient, "pkg/vcluster/chart/vcluster")
+	lifecycleMgr := lifecycle.NewManager(dplr, vclusterMgr, lifecycle.WithParallelOptions(parallelOpts))
+
+	utils.GetLogger().Println("Applying the configuration to the Host Cluster...")
+	if err := lifecycleMgr.ApplyScalePlan(ctx, newConfig, scalePlan, hostK8sClient); err != nil {
+		return fmt.Errorf("host cluster apply failed: %w", err)
+	}
+	return nil
+}
+
+// nodeCmd is the base command for operations on a single node of the configuration.
+// nodeCmd 是对配置中单个节点进行操作的基本命令。
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Inspect and manage a single node of the Host Cluster",
+}
+
+// nodeSysctlCmd groups the sysctl commands of a node.
+// nodeSysctlCmd 汇总节点的 sysctl 命令。
+var nodeSysctlCmd = &cobra.Command{
+	Use:   "sysctl",
+	Short: "Compare or roll back the kernel parameters chasi-bod manages on a node",
+}
+
+func init() {
+	nodeCmd.AddCommand(nodeSysctlCmd)
+	nodeSysctlCmd.AddCommand(nodeSysctlDiffCmd)
+	nodeSysctlCmd.AddCommand(nodeSysctlRollbackCmd)
+}
+
+// nodeSysctlDiffCmd shows the sysctl drift of a node.
+// nodeSysctlDiffCmd 显示节点的 sysctl 漂移。
+var nodeSysctlDiffCmd = &cobra.Command{
+	Use:   "diff <node>",
+	Short: "Show how the kernel parameters of a node differ from the configuration",
+	Long: `Merges the sysctl settings of the base OS, the platform and the node (the node wins over the
+platform, which wins over the base OS) and compares them with the runtime values on the node and
+the values persisted in /etc/sysctl.d/99-chasi-bod.conf. Nothing on the node is changed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(cmd.Context(), constants.DefaultTimeout)
+		defer cancel()
+		config, nodeCfg, err := loadConfigNode(args[0])
 		if err != nil {
-			return fmt.Errorf("failed to create deployer: %w", err)
+			return err
 		}
-		// bldr, err := builder.NewBuilder() // Assuming Builder orchestrator exists
-		// if err != nil {
-		// 	return fmt.Errorf("failed to create builder: %w", err)
-		// }
-		// Need Host K8s client
-		// 需要 Host K8s 客户端
-		hostK8sClient, err := getHostK8sClient() // Needs helper function to load kubeconfig
+		utils.GetLogger().SetOutput(os.Stderr)
+		exec, err := executor.NewSSHExecutor(ctx, nodeCfg)
 		if err != nil {
-			return fmt.Errorf("failed to get host K8s client: %w", err)
+			return fmt.Errorf("failed to connect to node %s: %w", nodeCfg.Address, err)
 		}
-		vclusterMgr := vcluster_mgr.NewManager(hostK8sClient, "pkg/vcluster/chart/vcluster")
+		defer exec.Close()
 
-		// Create a new lifecycle manager
-		// 创建一个新的生命周期管理器
-		lifecycleMgr := lifecycle.NewManager(dplr, vclusterMgr)
-
-		// Run the scaling process
-		// 运行扩缩容过程
-		utils.GetLogger().Println("Starting Host Cluster scaling...")
-		if err := lifecycleMgr.ScaleHostCluster(ctx, newConfig, nodesToAdd, nodesToRemove, hostK8sClient); err != nil { // Pass hostK8sClient
-			return fmt.Errorf("host cluster scaling failed: %w", err)
+		diffs, err := phases.DiffSysctl(ctx, exec, phases.EffectiveSysctl(config, nodeCfg))
+		if err != nil {
+			return err
 		}
+		output, _ := cmd.Flags().GetString("output")
+		rendered, err := phases.RenderSysctlDiff(diffs, output)
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(rendered)
+		return err
+	},
+}
 
-		utils.GetLogger().Println("Host Cluster scaling completed successfully.")
-		// TODO: Optionally, save the newConfig as the current config after successful scaling
-		// TODO: 可选地，在扩缩容成功后将新配置保存为当前配置
-
+// nodeSysctlRollbackCmd restores the kernel parameters recorded before chasi-bod changed them.
+// nodeSysctlRollbackCmd 恢复 chasi-bod 更改之前记录的内核参数。
+var nodeSysctlRollbackCmd = &cobra.Command{
+	Use:   "rollback <node>",
+	Short: "Restore the kernel parameters a node had before chasi-bod changed them",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(cmd.Context(), constants.DefaultTimeout)
+		defer cancel()
+		_, nodeCfg, err := loadConfigNode(args[0])
+		if err != nil {
+			return err
+		}
+		exec, err := executor.NewSSHExecutor(ctx, nodeCfg)
+		if err != nil {
+			return fmt.Errorf("failed to connect to node %s: %w", nodeCfg.Address, err)
+		}
+		defer exec.Close()
+		if err := phases.RollbackSysctl(ctx, exec, nodeCfg.Address); err != nil {
+			return err
+		}
+		utils.GetLogger().Printf("Sysctl values of node %s restored.", nodeCfg.Address)
 		return nil
 	},
 }
 
+// loadConfigNode loads and validates the configuration and returns the node with the given address.
+// loadConfigNode 加载并校验配置，并返回具有给定地址的节点。
+func loadConfigNode(address string) (*model.PlatformConfig, *model.NodeConfig, error) {
+	config, err := loader.LoadConfig(configFilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := validator.ValidateConfig(config); err != nil {
+		return nil, nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	for i := range config.Cluster.Nodes {
+		if config.Cluster.Nodes[i].Address == address {
+			return config, &config.Cluster.Nodes[i], nil
+		}
+	}
+	return nil, nil, errors.New(errors.ErrTypeNotFound, fmt.Sprintf("node %s is not in the configuration", address))
+}
+
 // backupCmd represents the backup command.
 // backupCmd 表示 backup 命令。
 var backupCmd = &cobra.Command{
@@ -1014,6 +1308,20 @@ func getHostK8sClient() (kubernetes.Interface, error) {
 	return clientset, nil
 }
 
+// hostK8sClientFor returns a client for the Host Cluster of a platform config.
+// hostK8sClientFor 返回平台配置对应的 Host 集群的客户端。
+// Without --kubeconfig or KUBECONFIG, the admin kubeconfig exported by deploy is preferred over ~/.kube/config.
+// 未指定 --kubeconfig 或 KUBECONFIG 时，优先使用 deploy 导出的管理员 kubeconfig，而不是 ~/.kube/config。
+func hostK8sClientFor(config *model.PlatformConfig) (kubernetes.Interface, error) {
+	exported := phases.KubeconfigPath(phases.DefaultKubeconfigDir, config.Cluster.Name)
+	if kubeconfigPath == "" && os.Getenv("KUBECONFIG") == "" {
+		if exists, _ := utils.PathExists(exported); exists {
+			kubeconfigPath = exported
+		}
+	}
+	return getHostK8sClient()
+}
+
 // TODO: Implement helper functions for application status checks and waiting for rollout/deletion
 // TODO: 实现用于应用程序状态检查和等待 rollout/删除的辅助函数
 // func WaitForRollout(ctx context.Context, vclusterClient kubernetes.Interface, appConfig *model.ApplicationConfig) error { ... }
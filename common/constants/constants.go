@@ -58,6 +58,14 @@ const DefaultSSHPort = 22
 // DefaultContainerRuntimeEndpoint 定义了容器运行时的默认端点。
 const DefaultContainerRuntimeEndpoint = "unix:///var/run/containerd/containerd.sock"
 
+// DefaultMaxParallel defines how many nodes a node-specific phase runs on at the same time.
+// DefaultMaxParallel 定义节点特定阶段同时在多少个节点上运行。
+const DefaultMaxParallel = 10
+
+// DefaultDrainTimeout defines how long pods are evicted from a node being removed before giving up (or forcing).
+// DefaultDrainTimeout 定义从正在移除的节点驱逐 Pod 的最长时间，超时后放弃（或强制删除）。
+const DefaultDrainTimeout = 5 * time.Minute
+
 // ExitCodeSuccess represents a successful exit code.
 // ExitCodeSuccess 表示成功的退出码。
 const ExitCodeSuccess = 0
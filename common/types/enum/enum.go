@@ -59,8 +59,82 @@ const (
 	// OutputFormatQCOW2 indicates a QCOW2 (QEMU Copy On Write) image.
 	// OutputFormatQCOW2 表示 QCOW2 (QEMU 写时复制) 镜像。
 	OutputFormatQCOW2 BuilderOutputFormat = "qcow2"
+	// OutputFormatRaw indicates an uncompressed raw disk image.
+	// OutputFormatRaw 表示未压缩的原始磁盘镜像。
+	OutputFormatRaw BuilderOutputFormat = "raw"
 	// OutputFormatAMI indicates an AMI (Amazon Machine Image).
 	// OutputFormatAMI BuilderOutputFormat = "ami" // Uncomment if supporting AWS
 	// Add other formats as needed
 	// 根据需要添加其他格式
 )
+
+// FailurePolicy controls what happens to the remaining nodes when a node fails during a parallel phase.
+// FailurePolicy 控制并行阶段中某个节点失败时其余节点的处理方式。
+type FailurePolicy string
+
+const (
+	// FailurePolicyFailFast cancels the remaining nodes as soon as one node fails.
+	// FailurePolicyFailFast 在任一节点失败时立即取消其余节点。
+	FailurePolicyFailFast FailurePolicy = "fail-fast"
+	// FailurePolicyContinue lets the remaining nodes finish and reports all failures at the end.
+	// FailurePolicyContinue 让其余节点继续执行，并在最后报告所有失败。
+	FailurePolicyContinue FailurePolicy = "continue"
+)
+
+// PhaseScope describes whether a deployment phase runs once per node or once for the whole cluster.
+// PhaseScope 描述部署阶段是每个节点运行一次还是整个集群运行一次。
+type PhaseScope string
+
+const (
+	// PhaseScopeNode indicates a phase that runs on every applicable node.
+	// PhaseScopeNode 表示在每个适用节点上运行的阶段。
+	PhaseScopeNode PhaseScope = "node"
+	// PhaseScopeCluster indicates a phase that runs once for the whole cluster.
+	// PhaseScopeCluster 表示整个集群只运行一次的阶段。
+	PhaseScopeCluster PhaseScope = "cluster"
+)
+
+// ControlPlaneEndpointMode selects how a stable API server endpoint is provided for a multi-master Host Cluster.
+// ControlPlaneEndpointMode 选择如何为多主节点 Host 集群提供稳定的 API 服务器端点。
+type ControlPlaneEndpointMode string
+
+const (
+	// EndpointModeKubeVIP announces a virtual IP from kube-vip static pods on the masters.
+	// EndpointModeKubeVIP 由主节点上的 kube-vip 静态 Pod 通告虚拟 IP。
+	EndpointModeKubeVIP ControlPlaneEndpointMode = "kube-vip"
+	// EndpointModeKeepalived moves a virtual IP between the masters with keepalived and balances it with haproxy.
+	// EndpointModeKeepalived 使用 keepalived 在主节点之间漂移虚拟 IP，并使用 haproxy 进行负载均衡。
+	EndpointModeKeepalived ControlPlaneEndpointMode = "keepalived"
+	// EndpointModeExternal uses a load balancer managed outside chasi-bod.
+	// EndpointModeExternal 使用在 chasi-bod 之外管理的负载均衡器。
+	EndpointModeExternal ControlPlaneEndpointMode = "external"
+)
+
+// NetworkRenderer selects the network stack whose configuration files describe the host interfaces of a node.
+// NetworkRenderer 选择用其配置文件描述节点主机接口的网络栈。
+type NetworkRenderer string
+
+const (
+	// NetworkRendererNetplan writes netplan YAML, as on Ubuntu.
+	// NetworkRendererNetplan 写入 netplan YAML，用于 Ubuntu。
+	NetworkRendererNetplan NetworkRenderer = "netplan"
+	// NetworkRendererNetworkManager writes NetworkManager keyfiles, as on RHEL 9 and Fedora.
+	// NetworkRendererNetworkManager 写入 NetworkManager keyfile，用于 RHEL 9 和 Fedora。
+	NetworkRendererNetworkManager NetworkRenderer = "networkmanager"
+	// NetworkRendererIfcfg writes ifcfg files, as on RHEL 7 and 8.
+	// NetworkRendererIfcfg 写入 ifcfg 文件，用于 RHEL 7 和 8。
+	NetworkRendererIfcfg NetworkRenderer = "ifcfg"
+)
+
+// FirewallBackend selects the packet filter that enforces the host firewall.
+// FirewallBackend 选择执行主机防火墙的包过滤器。
+type FirewallBackend string
+
+const (
+	// FirewallBackendNFTables loads the rules as an nftables table.
+	// FirewallBackendNFTables 将规则加载为 nftables 表。
+	FirewallBackendNFTables FirewallBackend = "nftables"
+	// FirewallBackendIPTables loads the rules into an iptables and ip6tables chain.
+	// FirewallBackendIPTables 将规则加载到 iptables 和 ip6tables 链中。
+	FirewallBackendIPTables FirewallBackend = "iptables"
+)
@@ -39,6 +39,21 @@ type NetworkConfig struct {
 	ServiceCIDR  string            `json:"serviceCIDR"`  // Service network CIDR / Service 网络 CIDR
 	DNSServiceIP string            `json:"dnsServiceIP"` // DNS Service IP / DNS Service IP
 	Interfaces   []InterfaceConfig `json:"interfaces"`   // Network interface configurations / 网络接口配置
+	// DNS is the resolver configuration of the hosts, written together with the interfaces.
+	// DNS 是主机的解析器配置，与接口一起写入。
+	DNS *DNSConfig `json:"dns,omitempty" yaml:"dns,omitempty"`
+	// Renderer forces the network stack used for the interfaces; empty detects it from each node's OS.
+	// Renderer 强制指定接口使用的网络栈；为空时根据每个节点的操作系统检测。
+	Renderer enum.NetworkRenderer `json:"renderer,omitempty" yaml:"renderer,omitempty"`
+	// MTU of the pod network; 0 derives it from the MTU of Interface, or lets the CNI plugin detect it.
+	// MTU 是 Pod 网络的 MTU；0 表示根据 Interface 的 MTU 推导，或由 CNI 插件自动检测。
+	MTU int `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+	// Interface is the host interface carrying pod traffic between nodes; empty uses the first declared interface.
+	// Interface 是在节点之间承载 Pod 流量的主机接口；为空时使用第一个声明的接口。
+	Interface string `json:"interface,omitempty" yaml:"interface,omitempty"`
+	// PluginManifest is a path or URL replacing the pinned manifest (or Helm chart for Cilium) of a built-in CNI plugin.
+	// PluginManifest 是替换内置 CNI 插件固定版本清单（Cilium 为 Helm chart）的路径或 URL。
+	PluginManifest string `json:"pluginManifest,omitempty" yaml:"pluginManifest,omitempty"`
 	// Add more network specific configurations as needed
 	// 根据需要添加更多网络特定配置
 }
@@ -46,11 +61,27 @@ type NetworkConfig struct {
 // InterfaceConfig represents the configuration of a network interface.
 // InterfaceConfig 表示网络接口的配置。
 type InterfaceConfig struct {
-	Name    string   `json:"name"`    // Interface name (e.g., eth0) / 接口名称（例如，eth0）
-	IPAddrs []string `json:"ipAddrs"` // IP addresses (e.g., ["192.168.1.10/24"]) / IP 地址（例如，["192.168.1.10/24"]）
-	Gateway string   `json:"gateway"` // Default gateway / 默认网关
-	// Add more interface specific configurations
-	// 添加更多接口特定配置
+	Name    string        `json:"name" yaml:"name"`                         // Interface name (e.g., eth0) / 接口名称（例如，eth0）
+	IPAddrs []string      `json:"ipAddrs" yaml:"ipAddrs"`                   // IP addresses (e.g., ["192.168.1.10/24"]) / IP 地址（例如，["192.168.1.10/24"]）
+	Gateway string        `json:"gateway" yaml:"gateway"`                   // Default gateway / 默认网关
+	DHCP    bool          `json:"dhcp,omitempty" yaml:"dhcp,omitempty"`     // Obtain IPv4 addresses by DHCP / 通过 DHCP 获取 IPv4 地址
+	MTU     int           `json:"mtu,omitempty" yaml:"mtu,omitempty"`       // MTU; 0 keeps the default / MTU；0 表示保持默认值
+	Routes  []RouteConfig `json:"routes,omitempty" yaml:"routes,omitempty"` // Static routes through this interface / 经过此接口的静态路由
+}
+
+// RouteConfig represents a static route.
+// RouteConfig 表示静态路由。
+type RouteConfig struct {
+	To     string `json:"to" yaml:"to"`                             // Destination CIDR (e.g., "10.20.0.0/16") / 目标 CIDR（例如，"10.20.0.0/16"）
+	Via    string `json:"via" yaml:"via"`                           // Next hop address / 下一跳地址
+	Metric int    `json:"metric,omitempty" yaml:"metric,omitempty"` // Route metric; 0 keeps the default / 路由度量；0 表示保持默认值
+}
+
+// DNSConfig represents the DNS resolver configuration of a host.
+// DNSConfig 表示主机的 DNS 解析器配置。
+type DNSConfig struct {
+	Nameservers []string `json:"nameservers" yaml:"nameservers"`           // Resolver addresses / 解析器地址
+	Search      []string `json:"search,omitempty" yaml:"search,omitempty"` // Search domains / 搜索域
 }
 
 // StorageConfig represents the storage configuration for a cluster or vcluster.
@@ -59,6 +90,9 @@ type StorageConfig struct {
 	DefaultStorageClass string               `json:"defaultStorageClass"` // Default StorageClass name / 默认 StorageClass 名称
 	StorageClasses      []StorageClassConfig `json:"storageClasses"`      // List of StorageClass configurations / StorageClass 配置列表
 	PVConfigs           []PVConfig           `json:"pvConfigs"`           // List of predefined PV configurations (if any) / 预定义的 PV 配置列表（如果有）
+	// LocalPathProvisioner deploys the local-path provisioner for clusters without a CSI driver.
+	// LocalPathProvisioner 为没有 CSI 驱动的集群部署 local-path provisioner。
+	LocalPathProvisioner *LocalPathProvisionerConfig `json:"localPathProvisioner,omitempty" yaml:"localPathProvisioner,omitempty"`
 	// Add more storage specific configurations as needed
 	// 根据需要添加更多存储特定配置
 }
@@ -69,6 +103,15 @@ type StorageClassConfig struct {
 	Name        string            `json:"name"`        // StorageClass name / StorageClass 名称
 	Provisioner string            `json:"provisioner"` // Provisioner name / Provisioner 名称
 	Parameters  map[string]string `json:"parameters"`  // Provisioner parameters / Provisioner 参数
+	// ReclaimPolicy of the dynamically provisioned volumes, "Delete" (default) or "Retain".
+	// ReclaimPolicy 是动态供应卷的回收策略，"Delete"（默认）或 "Retain"。
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty" yaml:"reclaimPolicy,omitempty"`
+	// VolumeBindingMode is "Immediate" (default) or "WaitForFirstConsumer".
+	// VolumeBindingMode 为 "Immediate"（默认）或 "WaitForFirstConsumer"。
+	VolumeBindingMode string `json:"volumeBindingMode,omitempty" yaml:"volumeBindingMode,omitempty"`
+	// AllowVolumeExpansion lets claims of the class be resized.
+	// AllowVolumeExpansion 允许调整该类声明的大小。
+	AllowVolumeExpansion bool `json:"allowVolumeExpansion,omitempty" yaml:"allowVolumeExpansion,omitempty"`
 	// Add more StorageClass specific configurations
 	// 添加更多 StorageClass 特定配置
 }
@@ -80,6 +123,15 @@ type PVConfig struct {
 	Capacity               string                 `json:"capacity"`               // PV capacity (e.g., "10Gi") / PV 容量（例如，“10Gi”）
 	AccessModes            []string               `json:"accessModes"`            // Access modes (e.g., ["ReadWriteOnce"]) / 访问模式（例如，["ReadWriteOnce"]）
 	PersistentVolumeSource PersistentVolumeSource `json:"persistentVolumeSource"` // PV source configuration / PV 源配置
+	// StorageClassName binds the PV to claims of that class; empty binds it to claims without a class.
+	// StorageClassName 将 PV 绑定到该类的声明；为空时绑定到没有类的声明。
+	StorageClassName string `json:"storageClassName,omitempty" yaml:"storageClassName,omitempty"`
+	// ReclaimPolicy is "Retain" (default), "Delete" or "Recycle".
+	// ReclaimPolicy 为 "Retain"（默认）、"Delete" 或 "Recycle"。
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty" yaml:"reclaimPolicy,omitempty"`
+	// Node is the address of the node holding a HostPath volume; pods using the PV are scheduled there.
+	// Node 是存放 HostPath 卷的节点地址；使用该 PV 的 Pod 会被调度到该节点。
+	Node string `json:"node,omitempty" yaml:"node,omitempty"`
 	// Add other PV source types as needed (NFS, iSCSI, etc.)
 	// 根据需要添加其他 PV 源类型（NFS、iSCSI 等）
 }
@@ -99,6 +151,14 @@ type HostPathVolumeSource struct {
 	Type string `json:"type"` // Host path type / 主机路径类型
 }
 
+// LocalPathProvisionerConfig configures the local-path provisioner, which provisions volumes from a directory of each node.
+// LocalPathProvisionerConfig 配置 local-path provisioner，它从每个节点的目录供应卷。
+type LocalPathProvisionerConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`                 // Deploy the provisioner and its "local-path" StorageClass / 部署 provisioner 及其 "local-path" StorageClass
+	Path    string `json:"path,omitempty" yaml:"path,omitempty"`   // Node directory volumes are created in; /opt/local-path-provisioner by default / 创建卷的节点目录；默认为 /opt/local-path-provisioner
+	Image   string `json:"image,omitempty" yaml:"image,omitempty"` // Provisioner image; a pinned default is used when empty / Provisioner 镜像；为空时使用固定的默认版本
+}
+
 // SysctlConfig represents kernel parameter configuration.
 // SysctlConfig 表示内核参数配置。
 type SysctlConfig map[string]string // Map of sysctl parameters and their desired values / sysctl 参数及其期望值的映射
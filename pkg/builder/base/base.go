@@ -5,10 +5,12 @@ package base
 import (
 	"context"
 	"fmt"
-	"strings" // Added for string operations // 添加用于字符串操作
 
 	"github.com/turtacn/chasi-bod/common/errors"
 	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/phases"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
 )
 
 // OSBuilder defines the interface for building the base operating system image.
@@ -72,44 +74,35 @@ type OSBuilder interface {
 
 // NewOSBuilder creates a new OSBuilder implementation based on the configuration.
 // NewOSBuilder 根据配置创建一个新的 OSBuilder 实现。
+// The distribution is detected from the /etc/os-release of the unpacked image, which selects apt or dnf/yum.
+// 发行版根据解包后镜像的 /etc/os-release 检测，并据此选择 apt 或 dnf/yum。
 // config: The base OS configuration. / 基础操作系统配置。
-// Returns an OSBuilder implementation or an error if the OS type is unsupported.
-// 返回 OSBuilder 实现，如果操作系统类型不受支持则返回错误。
+// Returns an OSBuilder implementation or an error if the configuration cannot be built.
+// 返回 OSBuilder 实现，如果配置无法构建则返回错误。
 func NewOSBuilder(config *model.BaseOSConfig) (OSBuilder, error) {
-	// Determine the OS type from config.Image or a dedicated field
-	// 从 config.Image 或专用字段确定操作系统类型
-	// For now, let's do a basic check
-	// 现在，让我们做一个基本检查
-	imageLower := strings.ToLower(config.Image)
-	if strings.Contains(imageLower, "ubuntu") || strings.Contains(imageLower, "debian") {
-		// return NewDebianOSBuilder() // Assuming a Debian/Ubuntu builder exists
-		return nil, errors.New(errors.ErrTypeNotImplemented, "Debian/Ubuntu OS builder not implemented yet")
-	} else if strings.Contains(imageLower, "centos") || strings.Contains(imageLower, "rhel") || strings.Contains(imageLower, "rockylinux") || strings.Contains(imageLower, "almalinux") {
-		// return NewRedhatOSBuilder() // Assuming a RedHat-based builder exists
-		return nil, errors.New(errors.ErrTypeNotImplemented, "RedHat-based OS builder not implemented yet")
-	} else {
-		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported base OS image '%s'", config.Image))
+	// The root filesystem is unpacked from a local archive, so builds never pull the image
+	// 根文件系统从本地归档解包，因此构建从不拉取镜像
+	if config.ImageArchive == "" {
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.imageArchive is required to build from base OS image '%s'", config.Image))
 	}
+	return newChrootOSBuilder(), nil
 }
 
-// Example implementation structure (not fully functional)
-// 示例实现结构（不完全功能）
-// type DebianOSBuilder struct{}
-//
-// func (b *DebianOSBuilder) PrepareBaseImage(ctx context.Context, config *model.BaseOSConfig, buildDir string) (string, error) {
-// 	utils.GetLogger().Printf("Placeholder: Preparing Debian base image %s into %s", config.Image, buildDir)
-// 	// Use debootstrap or docker export to get a base filesystem
-// 	rootFSPath := filepath.Join(buildDir, "rootfs")
-// 	// Create dummy rootfs dir
-// 	if err := utils.MkdirAll(rootFSPath, 0755); err != nil {
-// 		return "", err
-// 	}
-// 	return rootFSPath, errors.New(errors.ErrTypeNotImplemented, "Debian base image preparation not implemented")
-// }
-//
-// func (b *DebianOSBuilder) InstallPackages(ctx context.Context, config *model.BaseOSConfig, rootFS string) error {
-// 	utils.GetLogger().Printf("Placeholder: Installing packages into %s", rootFS)
-// 	// Use chroot and apt-get to install packages
-// 	return errors.New(errors.ErrTypeNotImplemented, "Debian package installation not implemented")
-// }
-// // ... implement other methods
+// ConfigureUsers creates the users and groups of the configuration in the root filesystem and installs their sudoers
+// drop-ins and SSH keys. It applies the same actions as the OS configuration phase on live nodes, through chroot.
+// ConfigureUsers 在根文件系统中创建配置的用户和组，并安装其 sudoers 附加配置和 SSH 密钥。
+// 它通过 chroot 应用与在线节点上操作系统配置阶段相同的操作。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// config: The base OS configuration. / 基础操作系统配置。
+// rootFS: The path to the root filesystem of the image being built. / 正在构建的镜像的根文件系统路径。
+// Returns an error if an action fails.
+// 如果操作失败则返回错误。
+func ConfigureUsers(ctx context.Context, config *model.BaseOSConfig, rootFS string) error {
+	actions := phases.UserActions(config)
+	if len(actions) == 0 {
+		return nil
+	}
+	exec := executor.NewChrootExecutor(rootFS)
+	defer exec.Close()
+	return plan.Apply(ctx, exec, actions)
+}
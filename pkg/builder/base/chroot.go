@@ -0,0 +1,393 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/phases"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+const (
+	// buildStateDir holds, inside the root filesystem, what the build moves aside or mounts; Cleanup removes it.
+	// buildStateDir 在根文件系统内保存构建移开或挂载的内容；Cleanup 会删除它。
+	buildStateDir = "/var/lib/chasi-bod/build"
+	// savedDir keeps the files of the image replaced during the build, at their path below it.
+	// savedDir 以其下的原路径保存构建期间被替换的镜像文件。
+	savedDir = buildStateDir + "/saved"
+	// mirrorDir is where the file:// repositories of the build machine are mounted.
+	// mirrorDir 是构建机器的 file:// 仓库的挂载位置。
+	mirrorDir = buildStateDir + "/repos"
+	// resetMachineIDCommand empties the machine ID so every node installed from the image generates its own.
+	// resetMachineIDCommand 清空机器 ID，使从镜像安装的每个节点生成自己的机器 ID。
+	resetMachineIDCommand = `if [ -e /etc/machine-id ]; then : > /etc/machine-id; fi; ` +
+		`if [ -f /var/lib/dbus/machine-id ] && [ ! -L /var/lib/dbus/machine-id ]; then rm -f /var/lib/dbus/machine-id && ln -s /etc/machine-id /var/lib/dbus/machine-id; fi`
+)
+
+// hostResolvConf is copied into the root filesystem during the build so package downloads resolve names.
+// hostResolvConf 在构建期间被复制到根文件系统中，使软件包下载能够解析名称。
+var hostResolvConf = "/etc/resolv.conf"
+
+// chrootMount is a file system mounted into the root filesystem during the build.
+// chrootMount 是构建期间挂载到根文件系统中的文件系统。
+type chrootMount struct {
+	source   string
+	target   string // Path inside the root filesystem / 根文件系统内的路径
+	fstype   string
+	bind     bool
+	readOnly bool
+}
+
+// chrootMounts are the kernel file systems package scripts and build commands expect.
+// chrootMounts 是软件包脚本和构建命令所需的内核文件系统。
+var chrootMounts = []chrootMount{
+	{source: "proc", target: "/proc", fstype: "proc"},
+	{source: "/sys", target: "/sys", bind: true},
+	{source: "/dev", target: "/dev", bind: true},
+}
+
+// chrootOSBuilder unpacks the base image and customizes it through chroot. The package manager is selected from the
+// /etc/os-release of the image. Mounts, DNS and package sources are set up the first time packages are installed or
+// commands run, and undone by Cleanup.
+// chrootOSBuilder 解包基础镜像并通过 chroot 对其进行定制。软件包管理器根据镜像的 /etc/os-release 选择。
+// 挂载、DNS 和软件包源在第一次安装软件包或运行命令时设置，并由 Cleanup 撤销。
+type chrootOSBuilder struct {
+	// newExecutor, mount and unmount are replaced in tests, which cannot chroot or mount
+	// newExecutor、mount 和 unmount 在无法 chroot 或挂载的测试中被替换
+	newExecutor func(rootFS string) executor.NodeExecutor
+	mount       func(m chrootMount, hostTarget string) error
+	unmount     func(hostTarget string) error
+
+	exec    executor.NodeExecutor
+	release map[string]string
+	pm      packageManager
+	// prepared is set once the chroot setup started, so Cleanup undoes even a partial setup
+	// prepared 在 chroot 设置开始后设置，使 Cleanup 即使在部分设置后也能撤销
+	prepared bool
+	// mounted lists the host paths mounted into the root filesystem, in mount order
+	// mounted 按挂载顺序列出挂载到根文件系统中的主机路径
+	mounted []string
+	// overridden lists the files written over the moved-aside files of the image
+	// overridden 列出覆盖在被移开的镜像文件之上写入的文件
+	overridden []string
+}
+
+// newChrootOSBuilder returns an OSBuilder running commands with chroot and mounting with the mount system call.
+// newChrootOSBuilder 返回一个使用 chroot 运行命令并使用 mount 系统调用进行挂载的 OSBuilder。
+func newChrootOSBuilder() *chrootOSBuilder {
+	return &chrootOSBuilder{newExecutor: executor.NewChrootExecutor, mount: mountChroot, unmount: unmountChroot}
+}
+
+// PrepareBaseImage unpacks the base image into <buildDir>/rootfs.
+// PrepareBaseImage 将基础镜像解包到 <buildDir>/rootfs。
+func (b *chrootOSBuilder) PrepareBaseImage(ctx context.Context, config *model.BaseOSConfig, buildDir string) (string, error) {
+	rootFS := filepath.Join(buildDir, "rootfs")
+	if err := UnpackImage(ctx, config.ImageArchive, config.Image, rootFS); err != nil {
+		return "", err
+	}
+	return rootFS, nil
+}
+
+// InstallPackages installs config.Packages with the package manager of the image, from the declared repositories
+// when there are some.
+// InstallPackages 使用镜像的软件包管理器安装 config.Packages，如果声明了仓库则从这些仓库安装。
+func (b *chrootOSBuilder) InstallPackages(ctx context.Context, config *model.BaseOSConfig, rootFS string) error {
+	if len(config.Packages) == 0 {
+		return nil
+	}
+	if err := b.prepare(ctx, config, rootFS); err != nil {
+		return err
+	}
+	utils.GetLogger().Printf("Installing %d packages into %s with %s...", len(config.Packages), rootFS, b.pm.Name())
+	if _, err := executor.RunCommand(ctx, b.exec, b.pm.InstallCommand(config.Packages), executor.WithLogging(rootFS)); err != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to install packages with %s", b.pm.Name()), err)
+	}
+	return nil
+}
+
+// ConfigureSystem persists the base OS sysctl settings and creates the declared users, groups, sudoers drop-ins and
+// SSH keys, with the same actions as the OS configuration phase on live nodes.
+// ConfigureSystem 持久化基础操作系统的 sysctl 设置，并创建声明的用户、组、sudoers 附加配置和 SSH 密钥，
+// 使用与在线节点上操作系统配置阶段相同的操作。
+func (b *chrootOSBuilder) ConfigureSystem(ctx context.Context, config *model.BaseOSConfig, rootFS string) error {
+	var actions []plan.Action
+	if len(config.SysctlConfig) > 0 {
+		actions = append(actions, phases.PersistSysctlAction(config.SysctlConfig))
+	}
+	actions = append(actions, phases.UserActions(config)...)
+	if len(actions) == 0 {
+		return nil
+	}
+	return plan.Apply(ctx, b.executor(rootFS), actions)
+}
+
+// CustomizeFiles copies config.Files into the root filesystem. Files keep their permissions unless a mode is declared.
+// CustomizeFiles 将 config.Files 复制到根文件系统中。除非声明了权限模式，否则文件保留其权限。
+func (b *chrootOSBuilder) CustomizeFiles(ctx context.Context, config *model.BaseOSConfig, rootFS string) error {
+	for _, fileCfg := range config.Files {
+		if err := b.copyFile(ctx, fileCfg, rootFS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies one declared file into the root filesystem.
+// copyFile 将一个声明的文件复制到根文件系统中。
+func (b *chrootOSBuilder) copyFile(ctx context.Context, fileCfg model.FileConfig, rootFS string) error {
+	f, err := os.Open(fileCfg.Source)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to open %s", fileCfg.Source), err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to stat %s", fileCfg.Source), err)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s is not a regular file", fileCfg.Source))
+	}
+	mode := info.Mode().Perm()
+	if fileCfg.Mode != "" {
+		declared, err := strconv.ParseUint(fileCfg.Mode, 8, 32)
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("invalid mode '%s' for %s", fileCfg.Mode, fileCfg.Dest), err)
+		}
+		mode = os.FileMode(declared)
+	}
+	utils.GetLogger().Printf("Copying %s to %s in %s", fileCfg.Source, fileCfg.Dest, rootFS)
+	return b.executor(rootFS).Upload(ctx, f, fileCfg.Dest, mode)
+}
+
+// RunCommands runs config.Commands one by one in the root filesystem, logging their output. The output of a failing
+// command is part of the error.
+// RunCommands 在根文件系统中逐个运行 config.Commands，并记录其输出。失败命令的输出包含在错误中。
+func (b *chrootOSBuilder) RunCommands(ctx context.Context, config *model.BaseOSConfig, rootFS string) error {
+	if len(config.Commands) == 0 {
+		return nil
+	}
+	if err := b.prepare(ctx, config, rootFS); err != nil {
+		return err
+	}
+	for i, cmd := range config.Commands {
+		utils.GetLogger().Printf("Running build command %d/%d in %s", i+1, len(config.Commands), rootFS)
+		if _, err := executor.RunCommand(ctx, b.exec, cmd, executor.WithLogging(rootFS)); err != nil {
+			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("build command %d failed", i+1), err)
+		}
+	}
+	return nil
+}
+
+// Cleanup restores the package sources and DNS configuration of the image, cleans the package caches, resets the
+// machine ID and unmounts what the build mounted. It carries on after a failure and returns the first error.
+// Cleanup 恢复镜像的软件包源和 DNS 配置，清理软件包缓存，重置机器 ID，并卸载构建挂载的内容。
+// 它在失败后继续执行，并返回第一个错误。
+func (b *chrootOSBuilder) Cleanup(ctx context.Context, rootFS string) error {
+	var firstErr error
+	keep := func(err error) {
+		if err == nil {
+			return
+		}
+		utils.GetLogger().Printf("Warning: cleaning up %s: %v", rootFS, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	exec := b.executor(rootFS)
+
+	if b.prepared {
+		if _, err := executor.RunCommand(ctx, exec, restoreCommand(b.overridden)); err != nil {
+			keep(errors.NewWithCause(errors.ErrTypeSystem, "failed to restore the files replaced during the build", err))
+		}
+	}
+	if pm, err := b.packageManager(ctx, rootFS); err != nil {
+		utils.GetLogger().Printf("Warning: not cleaning the package caches of %s: %v", rootFS, err)
+	} else if _, err := executor.RunCommand(ctx, exec, pm.CleanCommand()); err != nil {
+		keep(errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to clean the %s caches", pm.Name()), err))
+	}
+	if _, err := executor.RunCommand(ctx, exec, resetMachineIDCommand); err != nil {
+		keep(errors.NewWithCause(errors.ErrTypeSystem, "failed to reset the machine ID", err))
+	}
+
+	for i := len(b.mounted) - 1; i >= 0; i-- {
+		if err := b.unmount(b.mounted[i]); err != nil {
+			keep(errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to unmount %s", b.mounted[i]), err))
+		}
+	}
+	if b.prepared {
+		// rmdir never removes the content of a mount point that could not be unmounted. /dev is unmounted by now, so the
+		// command does not redirect to /dev/null.
+		// rmdir 永远不会删除无法卸载的挂载点的内容。此时 /dev 已卸载，因此命令不重定向到 /dev/null。
+		executor.RunCommand(ctx, exec, fmt.Sprintf(`for d in %s/* %s %s /var/lib/chasi-bod; do if [ -d "$d" ]; then rmdir "$d" || true; fi; done`, mirrorDir, mirrorDir, buildStateDir))
+	}
+	b.prepared, b.mounted, b.overridden = false, nil, nil
+	return firstErr
+}
+
+// executor returns the chroot executor of rootFS.
+// executor 返回 rootFS 的 chroot 执行器。
+func (b *chrootOSBuilder) executor(rootFS string) executor.NodeExecutor {
+	if b.exec == nil {
+		b.exec = b.newExecutor(rootFS)
+	}
+	return b.exec
+}
+
+// packageManager detects the package manager from the /etc/os-release of the image.
+// packageManager 根据镜像的 /etc/os-release 检测软件包管理器。
+func (b *chrootOSBuilder) packageManager(ctx context.Context, rootFS string) (packageManager, error) {
+	if b.pm != nil {
+		return b.pm, nil
+	}
+	out, err := executor.RunCommand(ctx, b.executor(rootFS), "if [ -e /etc/os-release ]; then cat /etc/os-release; else cat /usr/lib/os-release; fi")
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeNotFound, fmt.Sprintf("failed to read /etc/os-release in %s", rootFS), err)
+	}
+	release := executor.ParseOSRelease(out)
+	pm, err := detectPackageManager(release)
+	if err != nil {
+		return nil, err
+	}
+	b.release, b.pm = release, pm
+	return pm, nil
+}
+
+// prepare mounts the kernel file systems and the file:// repositories into the root filesystem, and replaces its DNS
+// configuration and, when repositories are declared, its package sources. The replaced files are moved aside and
+// restored by Cleanup.
+// prepare 将内核文件系统和 file:// 仓库挂载到根文件系统中，并替换其 DNS 配置，以及在声明了仓库时替换其软件包源。
+// 被替换的文件会被移开，并由 Cleanup 恢复。
+func (b *chrootOSBuilder) prepare(ctx context.Context, config *model.BaseOSConfig, rootFS string) error {
+	if b.prepared {
+		return nil
+	}
+	pm, err := b.packageManager(ctx, rootFS)
+	if err != nil {
+		return err
+	}
+	b.prepared = true
+
+	for _, m := range chrootMounts {
+		if err := b.mountAt(rootFS, m); err != nil {
+			return err
+		}
+	}
+	repos, files, err := b.repositories(config.PackageRepositories, rootFS)
+	if err != nil {
+		return err
+	}
+	var moved []string
+	if len(repos) > 0 {
+		sources, err := pm.SourceFiles(repos, b.release)
+		if err != nil {
+			return err
+		}
+		files = append(files, sources...)
+		moved = append(moved, pm.SourcePaths()...)
+	}
+	if resolvConf, err := os.ReadFile(hostResolvConf); err == nil {
+		files = append(files, overrideFile{path: "/etc/resolv.conf", content: string(resolvConf), mode: 0644})
+	} else {
+		utils.GetLogger().Printf("Warning: keeping the DNS configuration of the image: %v", err)
+	}
+	files = append(files, pm.BuildFiles()...)
+	for _, file := range files {
+		moved = append(moved, file.path)
+		b.overridden = append(b.overridden, file.path)
+	}
+
+	if _, err := executor.RunCommand(ctx, b.exec, moveAsideCommand(moved)); err != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, "failed to move aside the files replaced during the build", err)
+	}
+	for _, file := range files {
+		if err := b.exec.Upload(ctx, strings.NewReader(file.content), file.path, file.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repositories mounts the file:// repositories into the root filesystem and reads the signing keys, returning the
+// repositories as seen from inside the chroot and their key files.
+// repositories 将 file:// 仓库挂载到根文件系统中并读取签名密钥，返回从 chroot 内部看到的仓库及其密钥文件。
+func (b *chrootOSBuilder) repositories(configs []model.PackageRepositoryConfig, rootFS string) ([]repository, []overrideFile, error) {
+	var repos []repository
+	var keys []overrideFile
+	for _, repoCfg := range configs {
+		repo := repository{PackageRepositoryConfig: repoCfg}
+		u, err := url.Parse(repoCfg.URL)
+		if err != nil {
+			return nil, nil, errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("invalid url for package repository '%s'", repoCfg.Name), err)
+		}
+		if u.Scheme == "file" {
+			target := path.Join(mirrorDir, repoCfg.Name)
+			if err := b.mountAt(rootFS, chrootMount{source: u.Path, target: target, bind: true, readOnly: true}); err != nil {
+				return nil, nil, err
+			}
+			repo.URL = "file://" + target
+		}
+		if repoCfg.GPGKey != "" {
+			key, err := os.ReadFile(repoCfg.GPGKey)
+			if err != nil {
+				return nil, nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read the signing key of package repository '%s'", repoCfg.Name), err)
+			}
+			armored := bytes.HasPrefix(bytes.TrimSpace(key), []byte("-----BEGIN PGP"))
+			repo.keyPath = b.pm.KeyPath(repoCfg.Name, armored)
+			keys = append(keys, overrideFile{path: repo.keyPath, content: string(key), mode: 0644})
+		}
+		repos = append(repos, repo)
+	}
+	return repos, keys, nil
+}
+
+// mountAt mounts m into the root filesystem. The target is resolved like image layers and must be a directory, so
+// a symlink of the image never points the mount at the build machine.
+// mountAt 将 m 挂载到根文件系统中。目标的解析方式与镜像层相同且必须是目录，因此镜像中的符号链接永远不会使挂载指向构建机器。
+func (b *chrootOSBuilder) mountAt(rootFS string, m chrootMount) error {
+	hostTarget, err := newLayerApplier(rootFS).resolve(m.target)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to resolve %s in %s", m.target, rootFS), err)
+	}
+	if err := os.MkdirAll(hostTarget, 0755); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create %s in %s", m.target, rootFS), err)
+	}
+	if info, err := os.Lstat(hostTarget); err != nil || !info.IsDir() {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s in %s is not a directory", m.target, rootFS))
+	}
+	if err := b.mount(m, hostTarget); err != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to mount %s on %s in %s", m.source, m.target, rootFS), err)
+	}
+	b.mounted = append(b.mounted, hostTarget)
+	return nil
+}
+
+// moveAsideCommand moves the files matching the globs into savedDir, keeping their path below it. The globs are
+// built from constants and validated repository names, so they are used unquoted to be expanded.
+// moveAsideCommand 将匹配通配符的文件移到 savedDir 中，并在其下保留原路径。通配符由常量和已校验的仓库名称构建，
+// 因此不加引号使用以便展开。
+func moveAsideCommand(globs []string) string {
+	return fmt.Sprintf(`set -e; for f in %s; do if [ -e "$f" ] || [ -L "$f" ]; then mkdir -p "%s$(dirname "$f")"; mv "$f" "%s$f"; fi; done`,
+		strings.Join(globs, " "), savedDir, savedDir)
+}
+
+// restoreCommand removes the files written during the build and moves the saved files of the image back.
+// restoreCommand 删除构建期间写入的文件，并将保存的镜像文件移回原处。
+func restoreCommand(overridden []string) string {
+	cmd := fmt.Sprintf(`if [ -d %s ]; then (cd %s && find . ! -type d) | while read -r f; do mv -f "%s/${f#./}" "/${f#./}"; done && rm -rf %s; fi`,
+		savedDir, savedDir, savedDir, savedDir)
+	if len(overridden) == 0 {
+		return cmd
+	}
+	return "rm -f " + quoteAll(overridden) + " && " + cmd
+}
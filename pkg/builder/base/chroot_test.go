@@ -0,0 +1,177 @@
+package base
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+)
+
+// recordingExecutor records the commands and uploads of a build instead of running them in a chroot.
+type recordingExecutor struct {
+	osRelease string
+	failing   string // Commands containing it exit with status 1
+	commands  []string
+	uploads   map[string]string
+	modes     map[string]os.FileMode
+}
+
+func (e *recordingExecutor) Run(ctx context.Context, cmd string, opts ...executor.RunOption) (*executor.CommandResult, error) {
+	e.commands = append(e.commands, cmd)
+	result := &executor.CommandResult{Node: "rootfs", Command: cmd}
+	switch {
+	case strings.Contains(cmd, "cat /etc/os-release"):
+		result.Stdout = e.osRelease
+	case e.failing != "" && strings.Contains(cmd, e.failing):
+		result.ExitCode, result.Stderr = 1, "boom"
+	}
+	return result, nil
+}
+
+func (e *recordingExecutor) Upload(ctx context.Context, content io.Reader, remotePath string, mode os.FileMode) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	e.uploads[remotePath], e.modes[remotePath] = string(data), mode
+	return nil
+}
+
+func (e *recordingExecutor) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	return chasierrors.New(chasierrors.ErrTypeNotImplemented, "not recorded")
+}
+
+func (e *recordingExecutor) Address() string { return "rootfs" }
+
+func (e *recordingExecutor) Close() error { return nil }
+
+// newTestOSBuilder returns a builder using exec and recording its mounts and unmounts.
+func newTestOSBuilder(t *testing.T, exec *recordingExecutor) (*chrootOSBuilder, *[]string, *[]string) {
+	t.Helper()
+	utils.InitLogger("info", 0)
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(resolvConf, []byte("nameserver 10.0.0.53\n"), 0644))
+	previous := hostResolvConf
+	hostResolvConf = resolvConf
+	t.Cleanup(func() { hostResolvConf = previous })
+
+	exec.uploads, exec.modes = map[string]string{}, map[string]os.FileMode{}
+	var mounts, unmounts []string
+	b := &chrootOSBuilder{
+		newExecutor: func(string) executor.NodeExecutor { return exec },
+		mount: func(m chrootMount, hostTarget string) error {
+			mounts = append(mounts, m.source+" "+hostTarget)
+			return nil
+		},
+		unmount: func(hostTarget string) error {
+			unmounts = append(unmounts, hostTarget)
+			return nil
+		},
+	}
+	return b, &mounts, &unmounts
+}
+
+func TestChrootOSBuilder_InstallPackagesAndCleanup(t *testing.T) {
+	exec := &recordingExecutor{osRelease: "ID=ubuntu\nID_LIKE=debian\nVERSION_CODENAME=jammy\n"}
+	b, mounts, unmounts := newTestOSBuilder(t, exec)
+	rootFS, mirror := t.TempDir(), t.TempDir()
+	key := filepath.Join(t.TempDir(), "mirror.asc")
+	require.NoError(t, os.WriteFile(key, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n"), 0644))
+	config := &model.BaseOSConfig{
+		Packages: []string{"curl", "ca-certificates"},
+		PackageRepositories: []model.PackageRepositoryConfig{
+			{Name: "local", URL: "file://" + mirror, Suite: "./", Trusted: true},
+			{Name: "mirror", URL: "http://mirror.example.com/ubuntu", GPGKey: key},
+		},
+	}
+
+	require.NoError(t, b.InstallPackages(context.Background(), config, rootFS))
+	assert.Equal(t, []string{
+		"proc " + filepath.Join(rootFS, "proc"),
+		"/sys " + filepath.Join(rootFS, "sys"),
+		"/dev " + filepath.Join(rootFS, "dev"),
+		mirror + " " + filepath.Join(rootFS, "var/lib/chasi-bod/build/repos/local"),
+	}, *mounts)
+	assert.Equal(t, `# Written by chasi-bod for the image build.
+deb [trusted=yes] file:///var/lib/chasi-bod/build/repos/local ./
+deb [signed-by=/etc/apt/keyrings/chasi-bod-mirror.asc] http://mirror.example.com/ubuntu jammy main
+`, exec.uploads[aptSourcesPath])
+	assert.Equal(t, "-----BEGIN PGP PUBLIC KEY BLOCK-----\n", exec.uploads["/etc/apt/keyrings/chasi-bod-mirror.asc"])
+	assert.Equal(t, "nameserver 10.0.0.53\n", exec.uploads["/etc/resolv.conf"])
+	assert.Equal(t, os.FileMode(0755), exec.modes["/usr/sbin/policy-rc.d"])
+	assert.Contains(t, exec.commands[1], "/etc/apt/sources.list /etc/apt/sources.list.d/* ", "the sources of the image are moved aside")
+	assert.Equal(t, aptPackageManager{}.InstallCommand(config.Packages), exec.commands[len(exec.commands)-1])
+
+	installed := len(exec.commands)
+	require.NoError(t, b.Cleanup(context.Background(), rootFS))
+	cleanup := exec.commands[installed:]
+	require.Len(t, cleanup, 4)
+	assert.Contains(t, cleanup[0], "rm -f /etc/apt/keyrings/chasi-bod-mirror.asc /etc/apt/sources.list.d/chasi-bod.list /etc/resolv.conf /usr/sbin/policy-rc.d && ")
+	assert.Equal(t, aptPackageManager{}.CleanCommand(), cleanup[1])
+	assert.Equal(t, resetMachineIDCommand, cleanup[2])
+	assert.Equal(t, []string{
+		filepath.Join(rootFS, "var/lib/chasi-bod/build/repos/local"),
+		filepath.Join(rootFS, "dev"),
+		filepath.Join(rootFS, "sys"),
+		filepath.Join(rootFS, "proc"),
+	}, *unmounts, "mounts are undone in reverse order")
+}
+
+func TestChrootOSBuilder_RunCommandsReportsOutput(t *testing.T) {
+	exec := &recordingExecutor{osRelease: `ID="rocky"` + "\n" + `ID_LIKE="rhel centos fedora"` + "\n", failing: "systemctl enable"}
+	b, _, _ := newTestOSBuilder(t, exec)
+	rootFS := t.TempDir()
+	config := &model.BaseOSConfig{Commands: []string{"echo built > /etc/motd", "systemctl enable chronyd"}}
+
+	err := b.RunCommands(context.Background(), config, rootFS)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeSystem))
+	assert.ErrorContains(t, err, "build command 2 failed")
+	assert.ErrorContains(t, err, "boom")
+	assert.Equal(t, []string{"/etc/resolv.conf"}, b.overridden, "the sources of the image are kept when no repository is declared")
+
+	require.NoError(t, b.Cleanup(context.Background(), rootFS))
+	assert.Contains(t, exec.commands, dnfPackageManager{}.CleanCommand())
+}
+
+func TestChrootOSBuilder_UnsupportedDistribution(t *testing.T) {
+	exec := &recordingExecutor{osRelease: "ID=alpine\n"}
+	b, mounts, _ := newTestOSBuilder(t, exec)
+	rootFS := t.TempDir()
+
+	err := b.InstallPackages(context.Background(), &model.BaseOSConfig{Packages: []string{"curl"}}, rootFS)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+	assert.Empty(t, *mounts)
+
+	require.NoError(t, b.Cleanup(context.Background(), rootFS))
+	assert.Contains(t, exec.commands, resetMachineIDCommand)
+}
+
+func TestChrootOSBuilder_CustomizeFiles(t *testing.T) {
+	exec := &recordingExecutor{}
+	b, _, _ := newTestOSBuilder(t, exec)
+	dir := t.TempDir()
+	script := filepath.Join(dir, "setup.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n"), 0755))
+	config := &model.BaseOSConfig{Files: []model.FileConfig{
+		{Source: script, Dest: "/usr/local/bin/setup.sh"},
+		{Source: script, Dest: "/etc/chasi-bod/setup.sh", Mode: "0600"},
+	}}
+
+	require.NoError(t, b.CustomizeFiles(context.Background(), config, t.TempDir()))
+	assert.Equal(t, "#!/bin/sh\n", exec.uploads["/usr/local/bin/setup.sh"])
+	assert.Equal(t, os.FileMode(0755), exec.modes["/usr/local/bin/setup.sh"])
+	assert.Equal(t, os.FileMode(0600), exec.modes["/etc/chasi-bod/setup.sh"])
+
+	config.Files = []model.FileConfig{{Source: dir, Dest: "/etc/chasi-bod"}}
+	assert.True(t, chasierrors.IsChasiBodError(b.CustomizeFiles(context.Background(), config, t.TempDir()), chasierrors.ErrTypeValidation))
+}
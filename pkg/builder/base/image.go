@@ -0,0 +1,354 @@
+package base
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+)
+
+const (
+	// dockerManifestList and dockerManifest are the Docker media types that OCI layouts written by Docker may contain.
+	// dockerManifestList 和 dockerManifest 是 Docker 写入的 OCI 布局中可能包含的 Docker 媒体类型。
+	dockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	// containerdImageName is the annotation Docker and containerd use for the full image name in an OCI layout.
+	// containerdImageName 是 Docker 和 containerd 在 OCI 布局中用于完整镜像名称的注解。
+	containerdImageName = "io.containerd.image.name"
+	// dockerManifestFile is the manifest of a "docker save" archive.
+	// dockerManifestFile 是 "docker save" 归档的清单。
+	dockerManifestFile = "manifest.json"
+)
+
+// imageLayer is a layer blob of an unpacked image archive.
+// imageLayer 是已解包镜像归档中的一个层 blob。
+type imageLayer struct {
+	path   string        // Path of the layer blob / 层 blob 的路径
+	digest digest.Digest // Expected digest, empty when the archive does not record it / 预期摘要，归档未记录时为空
+}
+
+// UnpackImage builds a root filesystem from the image ref stored in archive, which is an OCI image layout directory,
+// or a tarball of an OCI image layout or of "docker save". Layers are applied in order with their whiteouts,
+// and file ownership, modes and extended attributes are preserved. Nothing is fetched from the network.
+// UnpackImage 从存储在 archive 中的镜像 ref 构建根文件系统，archive 是 OCI 镜像布局目录，或 OCI 镜像布局或 "docker save" 的 tar 包。
+// 按顺序应用各层及其 whiteout，并保留文件属主、权限模式和扩展属性。不会从网络获取任何内容。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// archive: Path of the image archive. / 镜像归档的路径。
+// ref: Image reference to unpack, e.g. "ubuntu:22.04"; may be empty when the archive holds a single image. / 要解包的镜像引用，例如 "ubuntu:22.04"；归档只包含一个镜像时可为空。
+// rootFS: Directory to unpack into; a tarball is extracted next to it while unpacking. / 解包到的目录；解包期间 tar 包会被解压到其旁边。
+// Returns an error if the image cannot be found or a layer cannot be applied.
+// 如果找不到镜像或无法应用某个层则返回错误。
+func UnpackImage(ctx context.Context, archive, ref, rootFS string) error {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeNotFound, fmt.Sprintf("base image archive %s not found", archive), err)
+	}
+	if err := utils.MkdirAll(rootFS, 0755); err != nil {
+		return err
+	}
+	layoutDir := archive
+	if !info.IsDir() {
+		scratch, err := os.MkdirTemp(filepath.Dir(rootFS), "image-")
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeIO, "failed to create a directory to extract the base image archive", err)
+		}
+		defer os.RemoveAll(scratch)
+		if err := extractArchive(ctx, archive, scratch); err != nil {
+			return err
+		}
+		layoutDir = scratch
+	}
+
+	layers, err := imageLayers(layoutDir, ref)
+	if err != nil {
+		return err
+	}
+	applier := newLayerApplier(rootFS)
+	if !applier.privileged {
+		utils.GetLogger().Printf("Warning: not running as root; file ownership, device nodes and privileged extended attributes of %s are not preserved", ref)
+	}
+	for i, layer := range layers {
+		utils.GetLogger().Printf("Applying layer %d/%d of %s", i+1, len(layers), ref)
+		if err := applier.apply(ctx, layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractArchive extracts the files of an image archive tarball into dir. Only the files, directories and
+// symlinks that stay inside dir are extracted.
+// extractArchive 将镜像归档 tar 包中的文件解压到 dir。仅解压保留在 dir 内的文件、目录和符号链接。
+func extractArchive(ctx context.Context, archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to open base image archive %s", archive), err)
+	}
+	defer f.Close()
+	r, closeReader, err := decompress(f)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read base image archive %s", archive), err)
+	}
+	defer closeReader()
+
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return errors.NewWithCause(errors.ErrTypeTimeout, "extracting the base image archive was interrupted", err)
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read base image archive %s", archive), err)
+		}
+		name := path.Clean(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("base image archive %s contains the unsafe path %q", archive, hdr.Name))
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeArchiveFile(target, tr)
+		case tar.TypeSymlink:
+			// Older "docker save" archives link layers shared by several images.
+			// 较旧的 "docker save" 归档会链接多个镜像共享的层。
+			if !filepath.IsLocal(path.Join(path.Dir(name), hdr.Linkname)) {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("base image archive %s links %q outside the archive", archive, hdr.Name))
+			}
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+				err = os.Symlink(hdr.Linkname, target)
+			}
+		}
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to extract %s from base image archive %s", hdr.Name, archive), err)
+		}
+	}
+}
+
+// writeArchiveFile writes the current entry of an archive to path.
+// writeArchiveFile 将归档的当前条目写入 path。
+func writeArchiveFile(target string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// imageLayers returns the layers of the image ref in an extracted image archive, lowest first.
+// imageLayers 返回已解压镜像归档中镜像 ref 的各层，最底层在前。
+func imageLayers(dir, ref string) ([]imageLayer, error) {
+	if _, err := os.Stat(filepath.Join(dir, v1.ImageLayoutFile)); err == nil {
+		var index v1.Index
+		if err := readJSONFile(filepath.Join(dir, "index.json"), &index); err != nil {
+			return nil, err
+		}
+		desc, err := selectManifest(index.Manifests, ref)
+		if err != nil {
+			return nil, err
+		}
+		return ociLayers(dir, desc)
+	}
+	if _, err := os.Stat(filepath.Join(dir, dockerManifestFile)); err == nil {
+		return dockerLayers(dir, ref)
+	}
+	return nil, errors.New(errors.ErrTypeValidation, "the base image archive is neither an OCI image layout nor a docker save archive")
+}
+
+// selectManifest returns the descriptor of the index tagged ref. An index holding a single image matches any reference.
+// selectManifest 返回索引中标记为 ref 的描述符。只包含一个镜像的索引匹配任何引用。
+func selectManifest(manifests []v1.Descriptor, ref string) (v1.Descriptor, error) {
+	want, tag := normalizeReference(ref), referenceTag(ref)
+	var available []string
+	for _, desc := range manifests {
+		for _, name := range []string{desc.Annotations[containerdImageName], desc.Annotations[v1.AnnotationRefName]} {
+			if name == "" {
+				continue
+			}
+			// OCI layouts often only record the tag as the reference name.
+			// OCI 布局通常只将标签记录为引用名称。
+			if normalizeReference(name) == want || name == tag {
+				return desc, nil
+			}
+			available = append(available, name)
+		}
+	}
+	if len(manifests) == 1 {
+		return manifests[0], nil
+	}
+	return v1.Descriptor{}, errors.New(errors.ErrTypeNotFound, fmt.Sprintf("image %s not found in the base image archive (available: %s)", ref, strings.Join(available, ", ")))
+}
+
+// ociLayers returns the layers of a manifest or, for an index, of the manifest for the build machine's architecture.
+// ociLayers 返回清单的各层；对于索引，返回适用于构建机器架构的清单的各层。
+func ociLayers(dir string, desc v1.Descriptor) ([]imageLayer, error) {
+	switch desc.MediaType {
+	case v1.MediaTypeImageIndex, dockerManifestList:
+		var index v1.Index
+		if err := readBlobJSON(dir, desc, &index); err != nil {
+			return nil, err
+		}
+		for _, manifest := range index.Manifests {
+			if manifest.Platform != nil && manifest.Platform.OS == "linux" && manifest.Platform.Architecture == goruntime.GOARCH {
+				return ociLayers(dir, manifest)
+			}
+		}
+		return nil, errors.New(errors.ErrTypeNotFound, fmt.Sprintf("the base image has no linux/%s variant", goruntime.GOARCH))
+	case v1.MediaTypeImageManifest, dockerManifest:
+		var manifest v1.Manifest
+		if err := readBlobJSON(dir, desc, &manifest); err != nil {
+			return nil, err
+		}
+		layers := make([]imageLayer, 0, len(manifest.Layers))
+		for _, layer := range manifest.Layers {
+			blob, err := blobPath(dir, layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, imageLayer{path: blob, digest: layer.Digest})
+		}
+		return layers, nil
+	default:
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported manifest media type %q in the base image archive", desc.MediaType))
+	}
+}
+
+// dockerLayers returns the layers of the image ref in a "docker save" archive.
+// dockerLayers 返回 "docker save" 归档中镜像 ref 的各层。
+func dockerLayers(dir, ref string) ([]imageLayer, error) {
+	var manifests []struct {
+		Config   string   `json:"Config"`
+		RepoTags []string `json:"RepoTags"`
+		Layers   []string `json:"Layers"`
+	}
+	if err := readJSONFile(filepath.Join(dir, dockerManifestFile), &manifests); err != nil {
+		return nil, err
+	}
+	want := normalizeReference(ref)
+	var available []string
+	index := -1
+	for i, manifest := range manifests {
+		for _, tag := range manifest.RepoTags {
+			if normalizeReference(tag) == want && index < 0 {
+				index = i
+			}
+			available = append(available, tag)
+		}
+	}
+	if index < 0 && len(manifests) == 1 {
+		index = 0
+	}
+	if index < 0 {
+		return nil, errors.New(errors.ErrTypeNotFound, fmt.Sprintf("image %s not found in the base image archive (available: %s)", ref, strings.Join(available, ", ")))
+	}
+
+	var layers []imageLayer
+	for _, name := range manifests[index].Layers {
+		name = path.Clean(name)
+		if !filepath.IsLocal(name) {
+			return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("the base image archive references the unsafe layer path %q", name))
+		}
+		layer := imageLayer{path: filepath.Join(dir, filepath.FromSlash(name))}
+		// Docker 25 and later store layers as content-addressed blobs, which can be verified.
+		// Docker 25 及更高版本将层存储为内容寻址的 blob，可以对其进行校验。
+		if alg, encoded, ok := strings.Cut(strings.TrimPrefix(name, "blobs/"), "/"); ok && strings.HasPrefix(name, "blobs/") {
+			if d := digest.NewDigestFromEncoded(digest.Algorithm(alg), encoded); d.Validate() == nil {
+				layer.digest = d
+			}
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// blobPath returns the path of a blob in an OCI image layout.
+// blobPath 返回 OCI 镜像布局中 blob 的路径。
+func blobPath(dir string, d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("invalid digest %q in the base image archive", d), err)
+	}
+	return filepath.Join(dir, "blobs", d.Algorithm().String(), d.Encoded()), nil
+}
+
+// readBlobJSON reads a JSON blob of an OCI image layout after checking it against its digest.
+// readBlobJSON 在根据摘要校验后读取 OCI 镜像布局中的 JSON blob。
+func readBlobJSON(dir string, desc v1.Descriptor, v interface{}) error {
+	blob, err := blobPath(dir, desc.Digest)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(blob)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeNotFound, fmt.Sprintf("blob %s missing from the base image archive", desc.Digest), err)
+	}
+	if desc.Digest.Algorithm().FromBytes(data) != desc.Digest {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("blob %s of the base image archive does not match its digest", desc.Digest))
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("failed to parse blob %s of the base image archive", desc.Digest), err)
+	}
+	return nil
+}
+
+// readJSONFile reads a JSON file of an image archive.
+// readJSONFile 读取镜像归档中的 JSON 文件。
+func readJSONFile(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read %s of the base image archive", filepath.Base(file)), err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("failed to parse %s of the base image archive", filepath.Base(file)), err)
+	}
+	return nil
+}
+
+// normalizeReference returns ref with the Docker Hub defaults spelled out the same way, so "ubuntu",
+// "library/ubuntu:latest" and "docker.io/library/ubuntu:latest" compare equal.
+// normalizeReference 以统一的方式补全 Docker Hub 默认值，使 "ubuntu"、"library/ubuntu:latest" 和
+// "docker.io/library/ubuntu:latest" 比较时相等。
+func normalizeReference(ref string) string {
+	name, suffix := ref, ":latest"
+	if i := strings.Index(ref, "@"); i >= 0 {
+		name, suffix = ref[:i], ref[i:]
+	} else if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, suffix = ref[:i], ref[i:]
+	}
+	for _, registry := range []string{"docker.io/", "index.docker.io/"} {
+		name = strings.TrimPrefix(name, registry)
+	}
+	return strings.TrimPrefix(name, "library/") + suffix
+}
+
+// referenceTag returns the tag of ref, "latest" when it has none.
+// referenceTag 返回 ref 的标签，没有标签时为 "latest"。
+func referenceTag(ref string) string {
+	normalized := normalizeReference(ref)
+	if strings.Contains(normalized, "@") {
+		return ""
+	}
+	return normalized[strings.LastIndex(normalized, ":")+1:]
+}
@@ -0,0 +1,289 @@
+package base
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+)
+
+// testEntry is an entry of a synthetic layer.
+// testEntry 是合成层中的一个条目。
+type testEntry struct {
+	name   string
+	typ    byte
+	body   string
+	mode   int64
+	link   string
+	xattrs map[string]string
+}
+
+// testLayer returns a tar layer holding entries, owned by 1000:1000.
+// testLayer 返回一个包含 entries 的 tar 层，属主为 1000:1000。
+func testLayer(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: e.mode, Linkname: e.link, Uid: 1000, Gid: 1000, ModTime: time.Unix(1700000000, 0)}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		for attr, value := range e.xattrs {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = map[string]string{}
+			}
+			hdr.PAXRecords[paxXattrPrefix+attr] = value
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func zstded(t *testing.T, data []byte) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	return enc.EncodeAll(data, nil)
+}
+
+// writeBlob stores a blob in an OCI image layout and returns its descriptor.
+// writeBlob 在 OCI 镜像布局中存储一个 blob 并返回其描述符。
+func writeBlob(t *testing.T, dir, mediaType string, data []byte) v1.Descriptor {
+	t.Helper()
+	d := digest.FromBytes(data)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blobs", "sha256", d.Encoded()), data, 0644))
+	return v1.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+func writeJSONBlob(t *testing.T, dir, mediaType string, v interface{}) v1.Descriptor {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return writeBlob(t, dir, mediaType, data)
+}
+
+// testManifest stores an image manifest with the given layers and returns its descriptor.
+// testManifest 存储一个包含给定层的镜像清单并返回其描述符。
+func testManifest(t *testing.T, dir string, layers ...v1.Descriptor) v1.Descriptor {
+	t.Helper()
+	config := writeJSONBlob(t, dir, v1.MediaTypeImageConfig, v1.Image{Platform: v1.Platform{OS: "linux", Architecture: goruntime.GOARCH}})
+	return writeJSONBlob(t, dir, v1.MediaTypeImageManifest, v1.Manifest{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: v1.MediaTypeImageManifest, Config: config, Layers: layers})
+}
+
+// writeLayout writes the index of an OCI image layout.
+// writeLayout 写入 OCI 镜像布局的索引。
+func writeLayout(t *testing.T, dir string, manifests ...v1.Descriptor) {
+	t.Helper()
+	data, err := json.Marshal(v1.Index{Versioned: specs.Versioned{SchemaVersion: 2}, Manifests: manifests})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), data, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, v1.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+}
+
+// tarDir returns a tarball of the files in dir.
+// tarDir 返回 dir 中文件的 tar 包。
+func tarDir(t *testing.T, dir string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		require.NoError(t, err)
+		rel, _ := filepath.Rel(dir, p)
+		if rel == "." {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		require.NoError(t, err)
+		hdr.Name = filepath.ToSlash(rel)
+		require.NoError(t, tw.WriteHeader(hdr))
+		if info.Mode().IsRegular() {
+			data, err := os.ReadFile(p)
+			require.NoError(t, err)
+			_, err = tw.Write(data)
+			require.NoError(t, err)
+		}
+		return nil
+	}))
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// testOCILayout writes a three-layer image tagged docker.io/library/ubuntu:22.04 behind a multi-platform index.
+// testOCILayout 写入一个三层镜像，标记为 docker.io/library/ubuntu:22.04，位于多平台索引之后。
+func testOCILayout(t *testing.T) string {
+	dir := t.TempDir()
+	base := writeBlob(t, dir, v1.MediaTypeImageLayer, testLayer(t,
+		testEntry{name: "etc/", typ: tar.TypeDir, mode: 0755},
+		testEntry{name: "etc/os-release", body: "ID=ubuntu\n"},
+		testEntry{name: "etc/old.conf", body: "old\n"},
+		testEntry{name: "usr/bin/", typ: tar.TypeDir, mode: 0755},
+		testEntry{name: "usr/bin/tool", body: "#!/bin/sh\n", mode: 04755},
+		testEntry{name: "usr/bin/tool-link", typ: tar.TypeLink, link: "usr/bin/tool"},
+		testEntry{name: "bin", typ: tar.TypeSymlink, link: "usr/bin"},
+		testEntry{name: "opt/app/stale", body: "stale\n"},
+		testEntry{name: "opt/app/data/stale", body: "stale\n"},
+	))
+	update := writeBlob(t, dir, v1.MediaTypeImageLayerGzip, gzipped(t, testLayer(t,
+		testEntry{name: "etc/.wh.old.conf", body: ""},
+		testEntry{name: "etc/os-release", body: "ID=ubuntu\nVERSION_ID=\"22.04\"\n", mode: 0600, xattrs: map[string]string{"user.chasi-bod": "base"}},
+		testEntry{name: "opt/app/data/fresh", body: "fresh\n"},
+		testEntry{name: "opt/app/.wh..wh..opq", body: ""},
+		testEntry{name: "bin/added", body: "added\n", mode: 0755},
+	)))
+	hostile := writeBlob(t, dir, v1.MediaTypeImageLayerZstd, zstded(t, testLayer(t,
+		testEntry{name: "escape", typ: tar.TypeSymlink, link: "/../../.."},
+		testEntry{name: "escape/planted", body: "planted\n"},
+	)))
+	image := testManifest(t, dir, base, update, hostile)
+	image.Platform = &v1.Platform{OS: "linux", Architecture: goruntime.GOARCH}
+	other := testManifest(t, dir)
+	other.Platform = &v1.Platform{OS: "linux", Architecture: "chasi-bod-test"}
+	index := writeJSONBlob(t, dir, v1.MediaTypeImageIndex, v1.Index{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: v1.MediaTypeImageIndex, Manifests: []v1.Descriptor{other, image}})
+	index.Annotations = map[string]string{containerdImageName: "docker.io/library/ubuntu:22.04", v1.AnnotationRefName: "22.04"}
+	decoy := testManifest(t, dir)
+	decoy.Annotations = map[string]string{v1.AnnotationRefName: "20.04"}
+	writeLayout(t, dir, decoy, index)
+	return dir
+}
+
+func TestUnpackImage_OCILayout(t *testing.T) {
+	utils.InitLogger("info", 0)
+	layout := testOCILayout(t)
+	archive := filepath.Join(t.TempDir(), "ubuntu.tar")
+	require.NoError(t, os.WriteFile(archive, tarDir(t, layout), 0644))
+
+	for name, source := range map[string]string{"directory": layout, "tarball": archive} {
+		t.Run(name, func(t *testing.T) {
+			buildDir := t.TempDir()
+			rootFS := filepath.Join(buildDir, "rootfs")
+			require.NoError(t, UnpackImage(context.Background(), source, "ubuntu:22.04", rootFS))
+
+			assert.Equal(t, "ID=ubuntu\nVERSION_ID=\"22.04\"\n", readTestFile(t, rootFS, "etc/os-release"))
+			assert.NoFileExists(t, filepath.Join(rootFS, "etc/old.conf"), "the whiteout removes the lower file")
+			assert.NoFileExists(t, filepath.Join(rootFS, "opt/app/stale"), "the opaque directory hides the lower content")
+			assert.NoFileExists(t, filepath.Join(rootFS, "opt/app/data/stale"))
+			assert.Equal(t, "fresh\n", readTestFile(t, rootFS, "opt/app/data/fresh"), "entries of the same layer survive the opaque whiteout")
+			assert.Equal(t, "added\n", readTestFile(t, rootFS, "usr/bin/added"), "writes follow the merged-usr symlink")
+			assert.Equal(t, "planted\n", readTestFile(t, rootFS, "planted"), "symlinks cannot lead outside the root filesystem")
+
+			info, err := os.Stat(filepath.Join(rootFS, "usr/bin/tool"))
+			require.NoError(t, err)
+			assert.Equal(t, os.ModeSetuid|0755, info.Mode()&(os.ModePerm|os.ModeSetuid))
+			link, err := os.Stat(filepath.Join(rootFS, "usr/bin/tool-link"))
+			require.NoError(t, err)
+			assert.True(t, os.SameFile(info, link), "hard links share the inode")
+			info, err = os.Stat(filepath.Join(rootFS, "etc/os-release"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+			assert.Equal(t, time.Unix(1700000000, 0), info.ModTime())
+			target, err := os.Readlink(filepath.Join(rootFS, "bin"))
+			require.NoError(t, err)
+			assert.Equal(t, "usr/bin", target)
+
+			entries, err := os.ReadDir(buildDir)
+			require.NoError(t, err)
+			assert.Len(t, entries, 1, "the extracted archive is removed")
+		})
+	}
+}
+
+func TestUnpackImage_DockerSave(t *testing.T) {
+	utils.InitLogger("info", 0)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	addFile := func(name string, data []byte) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	rocky := testLayer(t, testEntry{name: "etc/os-release", body: "ID=rocky\n"})
+	rockyDigest := digest.FromBytes(rocky)
+	addFile("blobs/sha256/"+rockyDigest.Encoded(), rocky)
+	addFile("debian/layer.tar", testLayer(t, testEntry{name: "etc/os-release", body: "ID=debian\n"}))
+	addFile(dockerManifestFile, []byte(`[
+		{"Config": "debian.json", "RepoTags": ["debian:12"], "Layers": ["debian/layer.tar"]},
+		{"Config": "rocky.json", "RepoTags": ["rockylinux:9"], "Layers": ["blobs/sha256/`+rockyDigest.Encoded()+`"]}
+	]`))
+	require.NoError(t, tw.Close())
+	archive := filepath.Join(t.TempDir(), "images.tar.gz")
+	require.NoError(t, os.WriteFile(archive, gzipped(t, buf.Bytes()), 0644))
+
+	rootFS := filepath.Join(t.TempDir(), "rootfs")
+	require.NoError(t, UnpackImage(context.Background(), archive, "docker.io/library/rockylinux:9", rootFS))
+	assert.Equal(t, "ID=rocky\n", readTestFile(t, rootFS, "etc/os-release"))
+
+	err := UnpackImage(context.Background(), archive, "ubuntu:22.04", filepath.Join(t.TempDir(), "rootfs"))
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeNotFound))
+	assert.Contains(t, err.Error(), "debian:12, rockylinux:9")
+}
+
+func TestUnpackImage_RejectsCorruptLayers(t *testing.T) {
+	utils.InitLogger("info", 0)
+	dir := t.TempDir()
+	layer := writeBlob(t, dir, v1.MediaTypeImageLayer, testLayer(t, testEntry{name: "etc/hostname", body: "image\n"}))
+	writeLayout(t, dir, testManifest(t, dir, layer))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blobs", "sha256", layer.Digest.Encoded()), testLayer(t, testEntry{name: "etc/hostname", body: "tampered\n"}), 0644))
+
+	err := UnpackImage(context.Background(), dir, "", filepath.Join(t.TempDir(), "rootfs"))
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+	assert.Contains(t, err.Error(), "does not match its digest")
+}
+
+func TestNormalizeReference(t *testing.T) {
+	for ref, want := range map[string]string{
+		"ubuntu":                           "ubuntu:latest",
+		"ubuntu:22.04":                     "ubuntu:22.04",
+		"docker.io/library/ubuntu:22.04":   "ubuntu:22.04",
+		"library/ubuntu":                   "ubuntu:latest",
+		"registry.local:5000/os/rocky:9":   "registry.local:5000/os/rocky:9",
+		"registry.local:5000/os/rocky":     "registry.local:5000/os/rocky:latest",
+		"ubuntu@sha256:0123456789abcdef00": "ubuntu@sha256:0123456789abcdef00",
+	} {
+		assert.Equal(t, want, normalizeReference(ref), ref)
+	}
+	assert.Equal(t, "22.04", referenceTag("docker.io/library/ubuntu:22.04"))
+}
+
+func readTestFile(t *testing.T, rootFS, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(rootFS, name))
+	require.NoError(t, err)
+	return string(data)
+}
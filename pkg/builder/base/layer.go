@@ -0,0 +1,324 @@
+package base
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+)
+
+const (
+	// whiteoutPrefix marks a file deleting the lower-layer entry of the same name.
+	// whiteoutPrefix 标记一个删除下层同名条目的文件。
+	whiteoutPrefix = ".wh."
+	// opaqueWhiteout marks a directory whose lower-layer content is hidden.
+	// opaqueWhiteout 标记一个隐藏其下层内容的目录。
+	opaqueWhiteout = ".wh..wh..opq"
+	// paxXattrPrefix prefixes the PAX records holding extended attributes.
+	// paxXattrPrefix 是保存扩展属性的 PAX 记录的前缀。
+	paxXattrPrefix = "SCHILY.xattr."
+	// maxSymlinks bounds the symlinks followed while resolving one path, like the kernel's limit.
+	// maxSymlinks 限制解析一个路径时跟随的符号链接数量，类似于内核的限制。
+	maxSymlinks = 255
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress returns a reader of the tar stream in r, which may be gzip or zstd compressed.
+// decompress 返回 r 中 tar 流的读取器，r 可能经过 gzip 或 zstd 压缩。
+func decompress(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() { gz.Close() }, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	default:
+		return br, func() {}, nil
+	}
+}
+
+// layerApplier applies image layers to a root filesystem.
+// layerApplier 将镜像层应用到根文件系统。
+type layerApplier struct {
+	rootFS string
+	// privileged is set when running as root; otherwise ownership, device nodes and privileged xattrs cannot be restored
+	// privileged 在以 root 身份运行时设置；否则无法恢复属主、设备节点和特权扩展属性
+	privileged bool
+}
+
+// newLayerApplier returns an applier writing into rootFS.
+// newLayerApplier 返回一个写入 rootFS 的应用器。
+func newLayerApplier(rootFS string) *layerApplier {
+	return &layerApplier{rootFS: rootFS, privileged: os.Geteuid() == 0}
+}
+
+// apply applies one layer and checks it against its digest.
+// apply 应用一个层并根据其摘要进行校验。
+func (a *layerApplier) apply(ctx context.Context, layer imageLayer) error {
+	f, err := os.Open(layer.path)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeNotFound, fmt.Sprintf("layer %s missing from the base image archive", filepath.Base(layer.path)), err)
+	}
+	defer f.Close()
+
+	var raw io.Reader = f
+	var verifier interface{ Verified() bool }
+	if layer.digest != "" {
+		v := layer.digest.Verifier()
+		raw, verifier = io.TeeReader(f, v), v
+	}
+	r, closeReader, err := decompress(raw)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read layer %s", filepath.Base(layer.path)), err)
+	}
+	defer closeReader()
+	if err := a.applyTar(ctx, tar.NewReader(r)); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to apply layer %s", filepath.Base(layer.path)), err)
+	}
+	if verifier != nil {
+		// The tar reader stops at the end-of-archive marker; the rest of the blob still counts towards the digest.
+		// tar 读取器在归档结束标记处停止；blob 的其余部分仍计入摘要。
+		if _, err := io.Copy(io.Discard, raw); err != nil {
+			return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read layer %s", filepath.Base(layer.path)), err)
+		}
+		if !verifier.Verified() {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("layer %s does not match its digest", layer.digest))
+		}
+	}
+	return nil
+}
+
+// applyTar applies the entries of a layer. added records the entries of this layer, so an opaque whiteout
+// only removes lower-layer content whatever the order of the entries.
+// applyTar 应用一个层的条目。added 记录本层的条目，因此无论条目顺序如何，不透明 whiteout 只删除下层内容。
+func (a *layerApplier) applyTar(ctx context.Context, tr *tar.Reader) error {
+	added := map[string]bool{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		dir, base := path.Split(name)
+		switch {
+		case base == opaqueWhiteout:
+			if err := a.removeLower(path.Clean("/"+dir), added); err != nil {
+				return err
+			}
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target, err := a.resolve(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		target, err := a.resolve(name)
+		if err != nil {
+			return err
+		}
+		if err := a.writeEntry(tr, hdr, target); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for p := name; p != "." && !added[p]; p = path.Dir(p) {
+			added[p] = true
+		}
+	}
+}
+
+// removeLower removes the content of dir that the current layer did not add.
+// removeLower 删除 dir 中当前层未添加的内容。
+func (a *layerApplier) removeLower(dir string, added map[string]bool) error {
+	target, err := a.resolve(dir)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := strings.TrimPrefix(path.Join(dir, entry.Name()), "/")
+		switch {
+		case !added[child]:
+			if err := os.RemoveAll(filepath.Join(target, entry.Name())); err != nil {
+				return err
+			}
+		case entry.IsDir():
+			if err := a.removeLower("/"+child, added); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the path of name in the root filesystem. Symlinks in its parent directories are followed as if
+// the root filesystem were /, so a layer cannot write outside it; the last component is never followed.
+// resolve 返回 name 在根文件系统中的路径。其父目录中的符号链接按根文件系统为 / 的方式跟随，
+// 因此层无法写到根文件系统之外；最后一个组成部分永远不会被跟随。
+func (a *layerApplier) resolve(name string) (string, error) {
+	dir, base := path.Split(path.Clean("/" + name))
+	resolved := "/"
+	components := strings.Split(dir, "/")
+	for links := 0; len(components) > 0; {
+		component := components[0]
+		components = components[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, component)
+		hostPath := filepath.Join(a.rootFS, filepath.FromSlash(next))
+		info, err := os.Lstat(hostPath)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", errors.New(errors.ErrTypeValidation, fmt.Sprintf("too many levels of symbolic links resolving %s", name))
+		}
+		target, err := os.Readlink(hostPath)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		components = append(strings.Split(target, "/"), components...)
+	}
+	return filepath.Join(a.rootFS, filepath.FromSlash(path.Join(resolved, base))), nil
+}
+
+// writeEntry creates the entry of hdr at target, replacing what a lower layer left there except a directory
+// being redeclared, whose content is kept.
+// writeEntry 在 target 处创建 hdr 的条目，替换下层留下的内容，但重新声明的目录除外，其内容会被保留。
+func (a *layerApplier) writeEntry(tr *tar.Reader, hdr *tar.Header, target string) error {
+	if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	// Layers may omit the entries of parent directories.
+	// 层可能省略父目录的条目。
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		// A hard link shares the metadata of its source, which is already set.
+		// 硬链接与其源共享元数据，源的元数据已设置。
+		source, err := a.resolve(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		return os.Link(source, target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if err := mknod(target, hdr); err != nil {
+			if !a.privileged {
+				utils.GetLogger().Printf("Warning: skipping device node /%s: %v", strings.TrimPrefix(path.Clean("/"+hdr.Name), "/"), err)
+				return nil
+			}
+			return err
+		}
+	default:
+		utils.GetLogger().Printf("Warning: skipping %s of unsupported tar type %q", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+	return a.setMetadata(target, hdr)
+}
+
+// setMetadata restores the ownership, mode, extended attributes and modification time of an entry.
+// The owner is set first because chown clears setuid bits and file capabilities.
+// setMetadata 恢复条目的属主、权限模式、扩展属性和修改时间。首先设置属主，因为 chown 会清除 setuid 位和文件能力。
+func (a *layerApplier) setMetadata(target string, hdr *tar.Header) error {
+	if a.privileged {
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		mode := hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+	}
+	for key, value := range hdr.PAXRecords {
+		attr, ok := strings.CutPrefix(key, paxXattrPrefix)
+		if !ok {
+			continue
+		}
+		if err := setXattr(target, attr, value); err != nil && a.privileged {
+			return fmt.Errorf("failed to set extended attribute %s: %w", attr, err)
+		}
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	}
+	return nil
+}
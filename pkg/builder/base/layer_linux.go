@@ -0,0 +1,28 @@
+package base
+
+import (
+	"archive/tar"
+
+	"golang.org/x/sys/unix"
+)
+
+// setXattr sets an extended attribute of path without following symlinks.
+// setXattr 在不跟随符号链接的情况下设置 path 的扩展属性。
+func setXattr(path, attr, value string) error {
+	return unix.Lsetxattr(path, attr, []byte(value), 0)
+}
+
+// mknod creates the device node or FIFO described by hdr.
+// mknod 创建 hdr 描述的设备节点或 FIFO。
+func mknod(path string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	default:
+		mode |= unix.S_IFIFO
+	}
+	return unix.Mknod(path, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+}
@@ -0,0 +1,38 @@
+package base
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/turtacn/chasi-bod/common/utils"
+)
+
+func TestUnpackImage_PreservesOwnershipAndXattrs(t *testing.T) {
+	utils.InitLogger("info", 0)
+	rootFS := filepath.Join(t.TempDir(), "rootfs")
+	require.NoError(t, UnpackImage(context.Background(), testOCILayout(t), "ubuntu:22.04", rootFS))
+	osRelease := filepath.Join(rootFS, "etc/os-release")
+
+	if os.Geteuid() == 0 {
+		info, err := os.Lstat(osRelease)
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(1000), stat.Uid)
+		assert.Equal(t, uint32(1000), stat.Gid)
+	}
+
+	value := make([]byte, 64)
+	n, err := unix.Lgetxattr(osRelease, "user.chasi-bod", value)
+	if err == unix.ENOTSUP || (err == unix.ENODATA && os.Geteuid() != 0) {
+		t.Skipf("the filesystem of %s does not support user extended attributes", rootFS)
+	}
+	require.NoError(t, err)
+	assert.Equal(t, "base", string(value[:n]))
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package base
+
+import (
+	"archive/tar"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+// setXattr is only supported on Linux, where images are built.
+// setXattr 仅在构建镜像的 Linux 上受支持。
+func setXattr(path, attr, value string) error {
+	return errors.New(errors.ErrTypeNotImplemented, "extended attributes are only supported on Linux")
+}
+
+// mknod is only supported on Linux, where images are built.
+// mknod 仅在构建镜像的 Linux 上受支持。
+func mknod(path string, hdr *tar.Header) error {
+	return errors.New(errors.ErrTypeNotImplemented, "device nodes are only supported on Linux")
+}
@@ -0,0 +1,35 @@
+package base
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// mountChroot mounts m at hostTarget. Bind mounts are recursive and made slaves, so unmounting them never propagates
+// back to the mounts of the build machine; a read-only bind mount needs a remount.
+// mountChroot 将 m 挂载到 hostTarget。绑定挂载是递归的并被设为从属，因此卸载它们永远不会传播回构建机器的挂载；
+// 只读绑定挂载需要重新挂载。
+func mountChroot(m chrootMount, hostTarget string) error {
+	if !m.bind {
+		return unix.Mount(m.source, hostTarget, m.fstype, unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+	}
+	if err := unix.Mount(m.source, hostTarget, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+	if err := unix.Mount("", hostTarget, "", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+		unix.Unmount(hostTarget, unix.MNT_DETACH)
+		return err
+	}
+	if m.readOnly {
+		if err := unix.Mount("", hostTarget, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+			unix.Unmount(hostTarget, unix.MNT_DETACH)
+			return err
+		}
+	}
+	return nil
+}
+
+// unmountChroot lazily unmounts hostTarget and everything mounted below it.
+// unmountChroot 延迟卸载 hostTarget 及其下挂载的所有内容。
+func unmountChroot(hostTarget string) error {
+	return unix.Unmount(hostTarget, unix.MNT_DETACH)
+}
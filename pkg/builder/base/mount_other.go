@@ -0,0 +1,19 @@
+//go:build !linux
+
+package base
+
+import (
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+// mountChroot is only supported on Linux, where images are built.
+// mountChroot 仅在构建镜像的 Linux 上受支持。
+func mountChroot(m chrootMount, hostTarget string) error {
+	return errors.New(errors.ErrTypeNotImplemented, "mounting file systems into the chroot is only supported on Linux")
+}
+
+// unmountChroot is only supported on Linux, where images are built.
+// unmountChroot 仅在构建镜像的 Linux 上受支持。
+func unmountChroot(hostTarget string) error {
+	return errors.New(errors.ErrTypeNotImplemented, "mounting file systems into the chroot is only supported on Linux")
+}
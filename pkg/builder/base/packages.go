@@ -0,0 +1,236 @@
+package base
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+)
+
+// overrideFile is a file written into the root filesystem for the duration of the build only.
+// overrideFile 是仅在构建期间写入根文件系统的文件。
+type overrideFile struct {
+	path    string
+	content string
+	mode    os.FileMode
+}
+
+// repository is a declared package repository as seen from inside the chroot.
+// repository 是从 chroot 内部看到的已声明软件包仓库。
+type repository struct {
+	model.PackageRepositoryConfig
+	// keyPath is the path of the signing key inside the chroot, if the repository has one
+	// keyPath 是签名密钥在 chroot 内的路径（如果仓库有签名密钥）
+	keyPath string
+}
+
+// packageManager is the package tooling of a distribution family, run inside the chroot.
+// packageManager 是在 chroot 内运行的发行版系列的软件包工具。
+type packageManager interface {
+	// Name names the package manager in logs and errors.
+	// Name 在日志和错误中命名软件包管理器。
+	Name() string
+	// InstallCommand returns the command installing packages from the configured sources.
+	// InstallCommand 返回从已配置的源安装软件包的命令。
+	InstallCommand(packages []string) string
+	// CleanCommand returns the command removing package caches and downloaded indexes.
+	// CleanCommand 返回删除软件包缓存和已下载索引的命令。
+	CleanCommand() string
+	// SourcePaths returns the shell globs matching the package sources of the image.
+	// SourcePaths 返回匹配镜像软件包源的 shell 通配符。
+	SourcePaths() []string
+	// KeyPath returns where the signing key of a repository is installed; armored tells an ASCII-armored key.
+	// KeyPath 返回仓库签名密钥的安装位置；armored 表示 ASCII 封装的密钥。
+	KeyPath(name string, armored bool) string
+	// SourceFiles renders the package sources of the repositories.
+	// SourceFiles 渲染仓库的软件包源。
+	SourceFiles(repos []repository, release map[string]string) ([]overrideFile, error)
+	// BuildFiles returns the files the package manager needs while running in a chroot.
+	// BuildFiles 返回软件包管理器在 chroot 中运行时需要的文件。
+	BuildFiles() []overrideFile
+}
+
+// detectPackageManager selects the package manager from the ID and ID_LIKE fields of /etc/os-release.
+// detectPackageManager 根据 /etc/os-release 的 ID 和 ID_LIKE 字段选择软件包管理器。
+func detectPackageManager(release map[string]string) (packageManager, error) {
+	family := strings.Fields(release["ID"] + " " + release["ID_LIKE"])
+	has := func(ids ...string) bool {
+		for _, id := range family {
+			for _, want := range ids {
+				if id == want {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case has("debian", "ubuntu"):
+		return aptPackageManager{}, nil
+	case has("rhel", "centos", "fedora"):
+		return dnfPackageManager{}, nil
+	default:
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported base OS %q: only apt-based and dnf/yum-based distributions can be built", release["ID"]))
+	}
+}
+
+// quoteAll shell-quotes every word.
+// quoteAll 对每个单词加 shell 引号。
+func quoteAll(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = executor.ShellQuote(word)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// aptPackageManager drives apt on Debian, Ubuntu and their derivatives.
+// aptPackageManager 在 Debian、Ubuntu 及其衍生版上驱动 apt。
+type aptPackageManager struct{}
+
+// aptSourcesPath holds the sources of the declared repositories during the build.
+// aptSourcesPath 在构建期间保存已声明仓库的源。
+const aptSourcesPath = "/etc/apt/sources.list.d/chasi-bod.list"
+
+// Name implements packageManager.
+// Name 实现 packageManager。
+func (aptPackageManager) Name() string { return "apt" }
+
+// InstallCommand implements packageManager.
+// InstallCommand 实现 packageManager。
+func (aptPackageManager) InstallCommand(packages []string) string {
+	return "export DEBIAN_FRONTEND=noninteractive && apt-get update && apt-get install -y --no-install-recommends " + quoteAll(packages)
+}
+
+// CleanCommand implements packageManager.
+// CleanCommand 实现 packageManager。
+func (aptPackageManager) CleanCommand() string {
+	return "apt-get clean && rm -rf /var/lib/apt/lists/*"
+}
+
+// SourcePaths implements packageManager.
+// SourcePaths 实现 packageManager。
+func (aptPackageManager) SourcePaths() []string {
+	return []string{"/etc/apt/sources.list", "/etc/apt/sources.list.d/*"}
+}
+
+// KeyPath implements packageManager.
+// KeyPath 实现 packageManager。
+func (aptPackageManager) KeyPath(name string, armored bool) string {
+	// apt tells the key format from the extension
+	// apt 根据扩展名判断密钥格式
+	if armored {
+		return "/etc/apt/keyrings/chasi-bod-" + name + ".asc"
+	}
+	return "/etc/apt/keyrings/chasi-bod-" + name + ".gpg"
+}
+
+// SourceFiles renders one-line apt sources. The suite defaults to the codename of the image; a suite ending in "/"
+// names a flat repository, which has no components.
+// SourceFiles 渲染单行 apt 源。套件默认为镜像的代号；以 "/" 结尾的套件表示平面仓库，它没有组件。
+func (aptPackageManager) SourceFiles(repos []repository, release map[string]string) ([]overrideFile, error) {
+	var b strings.Builder
+	b.WriteString("# Written by chasi-bod for the image build.\n")
+	for _, repo := range repos {
+		suite := repo.Suite
+		if suite == "" {
+			if suite = release["VERSION_CODENAME"]; suite == "" {
+				return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("package repository '%s' needs a suite: the base OS image has no VERSION_CODENAME", repo.Name))
+			}
+		}
+		var options []string
+		if repo.Trusted {
+			options = append(options, "trusted=yes")
+		}
+		if repo.keyPath != "" {
+			options = append(options, "signed-by="+repo.keyPath)
+		}
+		line := "deb "
+		if len(options) > 0 {
+			line += "[" + strings.Join(options, " ") + "] "
+		}
+		line += repo.URL + " " + suite
+		if !strings.HasSuffix(suite, "/") {
+			components := repo.Components
+			if len(components) == 0 {
+				components = []string{"main"}
+			}
+			line += " " + strings.Join(components, " ")
+		}
+		b.WriteString(line + "\n")
+	}
+	return []overrideFile{{path: aptSourcesPath, content: b.String(), mode: 0644}}, nil
+}
+
+// BuildFiles keeps package scripts from starting services in the chroot.
+// BuildFiles 阻止软件包脚本在 chroot 中启动服务。
+func (aptPackageManager) BuildFiles() []overrideFile {
+	return []overrideFile{{path: "/usr/sbin/policy-rc.d", content: "#!/bin/sh\nexit 101\n", mode: 0755}}
+}
+
+// dnfPackageManager drives dnf, or yum on releases without dnf, on RHEL, CentOS, Fedora and their rebuilds.
+// Its commands avoid redirections to /dev/null, which is missing until /dev is mounted.
+// dnfPackageManager 在 RHEL、CentOS、Fedora 及其重建版上驱动 dnf，在没有 dnf 的版本上驱动 yum。
+// 其命令避免重定向到 /dev/null，因为在挂载 /dev 之前它并不存在。
+type dnfPackageManager struct{}
+
+// dnfSourcesPath holds the sources of the declared repositories during the build.
+// dnfSourcesPath 在构建期间保存已声明仓库的源。
+const dnfSourcesPath = "/etc/yum.repos.d/chasi-bod.repo"
+
+// Name implements packageManager.
+// Name 实现 packageManager。
+func (dnfPackageManager) Name() string { return "dnf" }
+
+// InstallCommand implements packageManager.
+// InstallCommand 实现 packageManager。
+func (dnfPackageManager) InstallCommand(packages []string) string {
+	pkgs := quoteAll(packages)
+	return fmt.Sprintf("if [ -x /usr/bin/dnf ]; then dnf install -y --setopt=install_weak_deps=False %s; else yum install -y %s; fi", pkgs, pkgs)
+}
+
+// CleanCommand implements packageManager.
+// CleanCommand 实现 packageManager。
+func (dnfPackageManager) CleanCommand() string {
+	return "if [ -x /usr/bin/dnf ]; then dnf clean all; else yum clean all; fi && rm -rf /var/cache/dnf /var/cache/yum"
+}
+
+// SourcePaths implements packageManager.
+// SourcePaths 实现 packageManager。
+func (dnfPackageManager) SourcePaths() []string {
+	return []string{"/etc/yum.repos.d/*"}
+}
+
+// KeyPath implements packageManager.
+// KeyPath 实现 packageManager。
+func (dnfPackageManager) KeyPath(name string, armored bool) string {
+	return "/etc/pki/rpm-gpg/chasi-bod-" + name
+}
+
+// SourceFiles renders one yum repository section per repository; suites and components only apply to apt.
+// SourceFiles 为每个仓库渲染一个 yum 仓库节；套件和组件仅适用于 apt。
+func (dnfPackageManager) SourceFiles(repos []repository, release map[string]string) ([]overrideFile, error) {
+	var b strings.Builder
+	b.WriteString("# Written by chasi-bod for the image build.\n")
+	for _, repo := range repos {
+		fmt.Fprintf(&b, "\n[chasi-bod-%s]\nname=%s\nbaseurl=%s\nenabled=1\nskip_if_unavailable=False\n", repo.Name, repo.Name, repo.URL)
+		switch {
+		case repo.Trusted:
+			b.WriteString("gpgcheck=0\nrepo_gpgcheck=0\n")
+		case repo.keyPath != "":
+			fmt.Fprintf(&b, "gpgcheck=1\ngpgkey=file://%s\n", repo.keyPath)
+		default:
+			b.WriteString("gpgcheck=1\n")
+		}
+	}
+	return []overrideFile{{path: dnfSourcesPath, content: b.String(), mode: 0644}}, nil
+}
+
+// BuildFiles returns nothing: rpm scriptlets do not start services in a chroot.
+// BuildFiles 不返回任何内容：rpm 脚本不会在 chroot 中启动服务。
+func (dnfPackageManager) BuildFiles() []overrideFile {
+	return nil
+}
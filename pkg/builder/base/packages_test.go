@@ -0,0 +1,80 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+func TestDetectPackageManager(t *testing.T) {
+	for _, tc := range []struct {
+		release map[string]string
+		want    string
+	}{
+		{map[string]string{"ID": "ubuntu", "ID_LIKE": "debian"}, "apt"},
+		{map[string]string{"ID": "debian"}, "apt"},
+		{map[string]string{"ID": "rocky", "ID_LIKE": "rhel centos fedora"}, "dnf"},
+		{map[string]string{"ID": "centos", "ID_LIKE": "rhel fedora"}, "dnf"},
+		{map[string]string{"ID": "fedora"}, "dnf"},
+	} {
+		pm, err := detectPackageManager(tc.release)
+		require.NoError(t, err, tc.release["ID"])
+		assert.Equal(t, tc.want, pm.Name(), tc.release["ID"])
+	}
+
+	_, err := detectPackageManager(map[string]string{"ID": "alpine"})
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+}
+
+func TestAptSourceFiles(t *testing.T) {
+	repos := []repository{
+		{PackageRepositoryConfig: model.PackageRepositoryConfig{Name: "local", URL: "file:///var/lib/chasi-bod/build/repos/local", Suite: "./", Trusted: true}},
+		{PackageRepositoryConfig: model.PackageRepositoryConfig{Name: "mirror", URL: "http://mirror.example.com/ubuntu", Components: []string{"main", "universe"}}, keyPath: "/etc/apt/keyrings/chasi-bod-mirror.asc"},
+		{PackageRepositoryConfig: model.PackageRepositoryConfig{Name: "updates", URL: "http://mirror.example.com/ubuntu", Suite: "jammy-updates"}},
+	}
+	files, err := aptPackageManager{}.SourceFiles(repos, map[string]string{"VERSION_CODENAME": "jammy"})
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, aptSourcesPath, files[0].path)
+	assert.Equal(t, `# Written by chasi-bod for the image build.
+deb [trusted=yes] file:///var/lib/chasi-bod/build/repos/local ./
+deb [signed-by=/etc/apt/keyrings/chasi-bod-mirror.asc] http://mirror.example.com/ubuntu jammy main universe
+deb http://mirror.example.com/ubuntu jammy-updates main
+`, files[0].content)
+
+	_, err = aptPackageManager{}.SourceFiles(repos[1:2], map[string]string{"ID": "debian"})
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation), "no suite and no codename")
+}
+
+func TestDnfSourceFiles(t *testing.T) {
+	repos := []repository{
+		{PackageRepositoryConfig: model.PackageRepositoryConfig{Name: "local", URL: "file:///var/lib/chasi-bod/build/repos/local", Trusted: true}},
+		{PackageRepositoryConfig: model.PackageRepositoryConfig{Name: "baseos", URL: "https://mirror.example.com/rocky/9/BaseOS/x86_64/os"}, keyPath: "/etc/pki/rpm-gpg/chasi-bod-baseos"},
+	}
+	files, err := dnfPackageManager{}.SourceFiles(repos, nil)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, dnfSourcesPath, files[0].path)
+	assert.Equal(t, `# Written by chasi-bod for the image build.
+
+[chasi-bod-local]
+name=local
+baseurl=file:///var/lib/chasi-bod/build/repos/local
+enabled=1
+skip_if_unavailable=False
+gpgcheck=0
+repo_gpgcheck=0
+
+[chasi-bod-baseos]
+name=baseos
+baseurl=https://mirror.example.com/rocky/9/BaseOS/x86_64/os
+enabled=1
+skip_if_unavailable=False
+gpgcheck=1
+gpgkey=file:///etc/pki/rpm-gpg/chasi-bod-baseos
+`, files[0].content)
+}
@@ -0,0 +1,318 @@
+// Package builder orchestrates the build of the chasi-bod platform image.
+// 包 builder 协调 chasi-bod 平台镜像的构建。
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/builder/base"
+	"github.com/turtacn/chasi-bod/pkg/builder/k8s"
+	"github.com/turtacn/chasi-bod/pkg/builder/packer"
+	"github.com/turtacn/chasi-bod/pkg/builder/runtime"
+	"github.com/turtacn/chasi-bod/pkg/builder/vcluster"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// DefaultVClusterCLIVersion is the vcluster CLI version installed into the image, matching the bundled vcluster chart.
+// DefaultVClusterCLIVersion 是安装到镜像中的 vcluster CLI 版本，与内置的 vcluster chart 一致。
+const DefaultVClusterCLIVersion = "0.28.0"
+
+// Builder defines the interface for the platform image build orchestrator.
+// Builder 定义了平台镜像构建协调器的接口。
+type Builder interface {
+	// Build prepares the base OS, installs the container runtime, Kubernetes and vcluster into it and packages the result.
+	// Build 准备基础操作系统，在其中安装容器运行时、Kubernetes 和 vcluster，并打包结果。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// config: The platform configuration. / 平台配置。
+	// Returns the build result, or an error naming the failed step.
+	// 返回构建结果，或指明失败步骤的错误。
+	Build(ctx context.Context, config *model.PlatformConfig) (*Result, error)
+}
+
+// StepResult records how long a build step took.
+// StepResult 记录构建步骤的耗时。
+type StepResult struct {
+	Name     string        `json:"name"`     // Step name / 步骤名称
+	Duration time.Duration `json:"duration"` // Time spent in the step / 步骤耗时
+}
+
+// Result describes a completed build.
+// Result 描述一次完成的构建。
+type Result struct {
+	ArtifactPath string        `json:"artifactPath"`      // Path of the packaged image / 打包后镜像的路径
+	WorkDir      string        `json:"workDir,omitempty"` // Build workspace, only set when it was kept / 构建工作区，仅在保留时设置
+	Steps        []StepResult  `json:"steps"`             // Steps in the order they ran / 按运行顺序排列的步骤
+	Duration     time.Duration `json:"duration"`          // Total build time / 构建总耗时
+}
+
+// Summary renders the result for the console, one step per line followed by the artifact.
+// Summary 为控制台渲染结果，每行一个步骤，随后是制品。
+func (r *Result) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Build summary: %d steps in %s\n", len(r.Steps), r.Duration.Round(time.Millisecond))
+	for _, step := range r.Steps {
+		fmt.Fprintf(&b, "  %-40s %s\n", step.Name, step.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(&b, "Artifact: %s", r.ArtifactPath)
+	if info, err := os.Stat(r.ArtifactPath); err == nil {
+		fmt.Fprintf(&b, " (%d bytes)", info.Size())
+	}
+	b.WriteString("\n")
+	if r.WorkDir != "" {
+		fmt.Fprintf(&b, "Workspace kept at: %s\n", r.WorkDir)
+	}
+	return b.String()
+}
+
+// defaultBuilder is the default implementation of the Builder interface.
+// defaultBuilder 是 Builder 接口的默认实现。
+type defaultBuilder struct {
+	// workDir is the directory the build workspace is created in; empty means the system temporary directory
+	// workDir 是创建构建工作区的目录；为空表示系统临时目录
+	workDir string
+	// keepWorkDir keeps the workspace after the build, successful or not, for debugging
+	// keepWorkDir 在构建后（无论成功与否）保留工作区以便调试
+	keepWorkDir bool
+
+	// The components default to the implementations selected from the configuration
+	// 组件默认使用根据配置选择的实现
+	osBuilder          base.OSBuilder
+	runtimeInstaller   runtime.RuntimeInstaller
+	k8sInstaller       k8s.K8sInstaller
+	vclusterIntegrator vcluster.VClusterIntegrator
+	imagePacker        packer.ImagePacker
+}
+
+// Option configures the default Builder.
+// Option 配置默认的 Builder。
+type Option func(*defaultBuilder)
+
+// WithWorkDir sets the directory the build workspace is created in (the system temporary directory by default).
+// WithWorkDir 设置创建构建工作区的目录（默认为系统临时目录）。
+// dir: Parent directory of the workspace. / 工作区的父目录。
+func WithWorkDir(dir string) Option {
+	return func(b *defaultBuilder) {
+		b.workDir = dir
+	}
+}
+
+// WithKeepWorkDir keeps the build workspace instead of removing it when the build ends.
+// WithKeepWorkDir 在构建结束时保留构建工作区而不是将其删除。
+// keep: Whether to keep the workspace. / 是否保留工作区。
+func WithKeepWorkDir(keep bool) Option {
+	return func(b *defaultBuilder) {
+		b.keepWorkDir = keep
+	}
+}
+
+// WithOSBuilder overrides the OS builder selected from the base OS image.
+// WithOSBuilder 覆盖根据基础操作系统镜像选择的 OS builder。
+// osBuilder: The OS builder to use. / 要使用的 OS builder。
+func WithOSBuilder(osBuilder base.OSBuilder) Option {
+	return func(b *defaultBuilder) {
+		b.osBuilder = osBuilder
+	}
+}
+
+// WithRuntimeInstaller overrides the installer selected from the container runtime.
+// WithRuntimeInstaller 覆盖根据容器运行时选择的安装程序。
+// installer: The runtime installer to use. / 要使用的运行时安装程序。
+func WithRuntimeInstaller(installer runtime.RuntimeInstaller) Option {
+	return func(b *defaultBuilder) {
+		b.runtimeInstaller = installer
+	}
+}
+
+// WithK8sInstaller overrides the installer selected from the Kubernetes version.
+// WithK8sInstaller 覆盖根据 Kubernetes 版本选择的安装程序。
+// installer: The Kubernetes installer to use. / 要使用的 Kubernetes 安装程序。
+func WithK8sInstaller(installer k8s.K8sInstaller) Option {
+	return func(b *defaultBuilder) {
+		b.k8sInstaller = installer
+	}
+}
+
+// WithVClusterIntegrator overrides the default vcluster integrator.
+// WithVClusterIntegrator 覆盖默认的 vcluster 集成器。
+// integrator: The vcluster integrator to use. / 要使用的 vcluster 集成器。
+func WithVClusterIntegrator(integrator vcluster.VClusterIntegrator) Option {
+	return func(b *defaultBuilder) {
+		b.vclusterIntegrator = integrator
+	}
+}
+
+// WithImagePacker overrides the packer selected from the output format.
+// WithImagePacker 覆盖根据输出格式选择的打包器。
+// imagePacker: The image packer to use. / 要使用的镜像打包器。
+func WithImagePacker(imagePacker packer.ImagePacker) Option {
+	return func(b *defaultBuilder) {
+		b.imagePacker = imagePacker
+	}
+}
+
+// NewBuilder creates a new Builder instance.
+// NewBuilder 创建一个新的 Builder 实例。
+// opts: Optional settings such as WithKeepWorkDir. / 可选设置，例如 WithKeepWorkDir。
+// Returns a Builder implementation.
+// 返回 Builder 实现。
+func NewBuilder(opts ...Option) (Builder, error) {
+	b := &defaultBuilder{}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b, nil
+}
+
+// components returns the components of a build, selecting the ones not overridden from the configuration.
+// They are resolved before the workspace is created, so an unsupported configuration fails without leaving anything behind.
+// components 返回构建的组件，未被覆盖的组件根据配置选择。
+// 它们在创建工作区之前解析，因此不受支持的配置会直接失败而不会留下任何内容。
+func (b *defaultBuilder) components(config *model.PlatformConfig) (*defaultBuilder, error) {
+	c := *b
+	var err error
+	if c.osBuilder == nil {
+		if c.osBuilder, err = base.NewOSBuilder(&config.Cluster.BaseOS); err != nil {
+			return nil, err
+		}
+	}
+	if c.runtimeInstaller == nil {
+		if c.runtimeInstaller, err = runtime.NewRuntimeInstaller(&config.Cluster); err != nil {
+			return nil, err
+		}
+	}
+	if c.k8sInstaller == nil {
+		if c.k8sInstaller, err = k8s.NewK8sInstaller(config.Cluster.KubernetesVersion); err != nil {
+			return nil, err
+		}
+	}
+	if c.vclusterIntegrator == nil {
+		if c.vclusterIntegrator, err = vcluster.NewVClusterIntegrator(); err != nil {
+			return nil, err
+		}
+	}
+	if c.imagePacker == nil {
+		if c.imagePacker, err = packer.NewImagePacker(config.Output.Format, config.Cluster.BaseOS.KernelArgs); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// buildStep is one named call of the build.
+// buildStep 是构建中一个命名的调用。
+type buildStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Build runs the build steps in a fresh workspace and packages the root filesystem into config.Output.
+// Build 在新的工作区中运行构建步骤，并将根文件系统打包到 config.Output。
+func (b *defaultBuilder) Build(ctx context.Context, config *model.PlatformConfig) (result *Result, err error) {
+	c, err := b.components(config)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	workspace, err := os.MkdirTemp(b.workDir, "chasi-bod-build-")
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, "failed to create the build workspace", err)
+	}
+	utils.GetLogger().Printf("Building platform image %s in workspace %s", config.Output.ImageName, workspace)
+	result = &Result{}
+
+	// The OS builder is cleaned up once, after the last step using the root filesystem or when the build fails;
+	// the workspace goes with it unless it is kept for debugging.
+	// OS builder 只清理一次，在最后一个使用根文件系统的步骤之后或构建失败时；除非为调试而保留，否则工作区会随之删除。
+	var rootFS string
+	cleanedUp := false
+	cleanup := func() error {
+		if cleanedUp || rootFS == "" {
+			return nil
+		}
+		cleanedUp = true
+		return c.osBuilder.Cleanup(context.WithoutCancel(ctx), rootFS)
+	}
+	defer func() {
+		if err != nil {
+			if cleanupErr := cleanup(); cleanupErr != nil {
+				utils.GetLogger().Printf("Warning: failed to clean up the root filesystem %s: %v", rootFS, cleanupErr)
+			}
+		}
+		if b.keepWorkDir {
+			utils.GetLogger().Printf("Keeping the build workspace %s", workspace)
+			if result != nil {
+				result.WorkDir = workspace
+			}
+			return
+		}
+		if removeErr := os.RemoveAll(workspace); removeErr != nil {
+			utils.GetLogger().Printf("Warning: failed to remove the build workspace %s: %v", workspace, removeErr)
+		}
+	}()
+
+	baseOS := &config.Cluster.BaseOS
+	clusterCfg := &config.Cluster
+	steps := []buildStep{
+		{"Prepare the base image", func(ctx context.Context) (err error) {
+			rootFS, err = c.osBuilder.PrepareBaseImage(ctx, baseOS, workspace)
+			return err
+		}},
+		{"Install OS packages", func(ctx context.Context) error { return c.osBuilder.InstallPackages(ctx, baseOS, rootFS) }},
+		{"Configure the system", func(ctx context.Context) error { return c.osBuilder.ConfigureSystem(ctx, baseOS, rootFS) }},
+		{"Copy custom files", func(ctx context.Context) error { return c.osBuilder.CustomizeFiles(ctx, baseOS, rootFS) }},
+		{"Run custom commands", func(ctx context.Context) error { return c.osBuilder.RunCommands(ctx, baseOS, rootFS) }},
+		{"Install the container runtime", func(ctx context.Context) error { return c.runtimeInstaller.Install(ctx, clusterCfg, rootFS) }},
+		{"Configure the container runtime", func(ctx context.Context) error { return c.runtimeInstaller.Configure(ctx, clusterCfg, rootFS) }},
+		{"Enable the container runtime", func(ctx context.Context) error { return c.runtimeInstaller.EnableService(ctx, clusterCfg, rootFS) }},
+		{"Preload runtime images", func(ctx context.Context) error { return c.runtimeInstaller.PreloadImages(ctx, clusterCfg, rootFS) }},
+		{"Install Kubernetes binaries", func(ctx context.Context) error {
+			return c.k8sInstaller.InstallBinaries(ctx, clusterCfg.KubernetesVersion, rootFS)
+		}},
+		{"Configure the kubelet", func(ctx context.Context) error { return c.k8sInstaller.ConfigureKubelet(ctx, clusterCfg, rootFS) }},
+		{"Enable Kubernetes services", func(ctx context.Context) error { return c.k8sInstaller.EnableServices(ctx, rootFS) }},
+		{"Preload Kubernetes images", func(ctx context.Context) error { return c.k8sInstaller.PreloadImages(ctx, clusterCfg, rootFS) }},
+		{"Install the CNI plugin", func(ctx context.Context) error { return c.k8sInstaller.InstallCNI(ctx, clusterCfg, rootFS) }},
+		{"Install the CSI plugin", func(ctx context.Context) error { return c.k8sInstaller.InstallCSI(ctx, clusterCfg, rootFS) }},
+		{"Install the vcluster CLI", func(ctx context.Context) error {
+			return c.vclusterIntegrator.InstallCLI(ctx, DefaultVClusterCLIVersion, rootFS)
+		}},
+		{"Preload vcluster images", func(ctx context.Context) error { return c.vclusterIntegrator.PreloadImages(ctx, config, rootFS) }},
+		{"Place vcluster templates", func(ctx context.Context) error { return c.vclusterIntegrator.PlaceTemplates(ctx, config, rootFS) }},
+		{"Clean up the root filesystem", func(ctx context.Context) error { return cleanup() }},
+		{"Package the image", func(ctx context.Context) error {
+			if err := utils.MkdirAll(config.Output.OutputDir, 0755); err != nil {
+				return err
+			}
+			artifactPath, err := c.imagePacker.Package(ctx, rootFS, &config.Output)
+			result.ArtifactPath = artifactPath
+			return err
+		}},
+	}
+	// A failed build returns no result, so the error names the workspace kept for debugging.
+	// 失败的构建不返回结果，因此错误中会指明为调试而保留的工作区。
+	kept := ""
+	if b.keepWorkDir {
+		kept = fmt.Sprintf(" (workspace kept at %s)", workspace)
+	}
+	for i, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeTimeout, fmt.Sprintf("build interrupted before step %q%s", step.name, kept), err)
+		}
+		utils.GetLogger().Printf("[%d/%d] %s...", i+1, len(steps), step.name)
+		stepStart := time.Now()
+		if err := step.run(ctx); err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("build step %q failed%s", step.name, kept), err)
+		}
+		result.Steps = append(result.Steps, StepResult{Name: step.name, Duration: time.Since(stepStart)})
+		utils.GetLogger().Printf("[%d/%d] %s done in %s", i+1, len(steps), step.name, time.Since(stepStart).Round(time.Millisecond))
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
@@ -0,0 +1,207 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// fakeComponents implements every build component, recording the calls and failing the one named in failOn.
+// fakeComponents 实现所有构建组件，记录调用并使 failOn 指定的调用失败。
+type fakeComponents struct {
+	calls  []string
+	failOn string
+	rootFS string
+}
+
+func (f *fakeComponents) record(call string) error {
+	f.calls = append(f.calls, call)
+	if call == f.failOn {
+		return chasierrors.New(chasierrors.ErrTypeSystem, call+" failed")
+	}
+	return nil
+}
+
+func (f *fakeComponents) PrepareBaseImage(_ context.Context, _ *model.BaseOSConfig, buildDir string) (string, error) {
+	f.rootFS = filepath.Join(buildDir, "rootfs")
+	if err := os.MkdirAll(f.rootFS, 0755); err != nil {
+		return "", err
+	}
+	return f.rootFS, f.record("PrepareBaseImage")
+}
+func (f *fakeComponents) InstallPackages(context.Context, *model.BaseOSConfig, string) error {
+	return f.record("InstallPackages")
+}
+func (f *fakeComponents) ConfigureSystem(context.Context, *model.BaseOSConfig, string) error {
+	return f.record("ConfigureSystem")
+}
+func (f *fakeComponents) CustomizeFiles(context.Context, *model.BaseOSConfig, string) error {
+	return f.record("CustomizeFiles")
+}
+func (f *fakeComponents) RunCommands(context.Context, *model.BaseOSConfig, string) error {
+	return f.record("RunCommands")
+}
+func (f *fakeComponents) Cleanup(context.Context, string) error { return f.record("Cleanup") }
+func (f *fakeComponents) Install(context.Context, *model.ClusterConfig, string) error {
+	return f.record("runtime.Install")
+}
+func (f *fakeComponents) Configure(context.Context, *model.ClusterConfig, string) error {
+	return f.record("runtime.Configure")
+}
+func (f *fakeComponents) EnableService(context.Context, *model.ClusterConfig, string) error {
+	return f.record("runtime.EnableService")
+}
+func (f *fakeComponents) InstallBinaries(context.Context, string, string) error {
+	return f.record("k8s.InstallBinaries")
+}
+func (f *fakeComponents) ConfigureKubelet(context.Context, *model.ClusterConfig, string) error {
+	return f.record("k8s.ConfigureKubelet")
+}
+func (f *fakeComponents) EnableServices(context.Context, string) error {
+	return f.record("k8s.EnableServices")
+}
+func (f *fakeComponents) InstallCNI(context.Context, *model.ClusterConfig, string) error {
+	return f.record("k8s.InstallCNI")
+}
+func (f *fakeComponents) InstallCSI(context.Context, *model.ClusterConfig, string) error {
+	return f.record("k8s.InstallCSI")
+}
+func (f *fakeComponents) InstallCLI(context.Context, string, string) error {
+	return f.record("vcluster.InstallCLI")
+}
+func (f *fakeComponents) PlaceTemplates(context.Context, *model.PlatformConfig, string) error {
+	return f.record("vcluster.PlaceTemplates")
+}
+func (f *fakeComponents) Package(_ context.Context, rootFS string, config *model.OutputConfig) (string, error) {
+	if err := f.record("Package"); err != nil {
+		return "", err
+	}
+	path := filepath.Join(config.OutputDir, config.ImageName+".img")
+	return path, os.WriteFile(path, []byte(rootFS), 0644)
+}
+
+// fakeRuntime, fakeK8s and fakeVCluster tell apart the PreloadImages methods of the installers.
+// fakeRuntime、fakeK8s 和 fakeVCluster 区分各安装程序的 PreloadImages 方法。
+type fakeRuntime struct{ *fakeComponents }
+
+func (f fakeRuntime) PreloadImages(context.Context, *model.ClusterConfig, string) error {
+	return f.record("runtime.PreloadImages")
+}
+
+type fakeK8s struct{ *fakeComponents }
+
+func (f fakeK8s) PreloadImages(context.Context, *model.ClusterConfig, string) error {
+	return f.record("k8s.PreloadImages")
+}
+
+type fakeVCluster struct{ *fakeComponents }
+
+func (f fakeVCluster) PreloadImages(context.Context, *model.PlatformConfig, string) error {
+	return f.record("vcluster.PreloadImages")
+}
+
+// newFakeBuilder returns a builder whose components are all backed by fake.
+// newFakeBuilder 返回一个所有组件都由 fake 支持的 builder。
+func newFakeBuilder(t *testing.T, fake *fakeComponents, opts ...Option) Builder {
+	t.Helper()
+	utils.InitLogger("info", 0)
+	opts = append([]Option{
+		WithWorkDir(t.TempDir()),
+		WithOSBuilder(fake),
+		WithRuntimeInstaller(fakeRuntime{fake}),
+		WithK8sInstaller(fakeK8s{fake}),
+		WithVClusterIntegrator(fakeVCluster{fake}),
+		WithImagePacker(fake),
+	}, opts...)
+	b, err := NewBuilder(opts...)
+	require.NoError(t, err)
+	return b
+}
+
+func testBuildConfig(t *testing.T) *model.PlatformConfig {
+	return &model.PlatformConfig{
+		Cluster: model.ClusterConfig{KubernetesVersion: "v1.30.2", ContainerRuntime: "containerd", BaseOS: model.BaseOSConfig{Image: "ubuntu:22.04"}},
+		Output:  model.OutputConfig{Format: enum.OutputFormatQCOW2, OutputDir: filepath.Join(t.TempDir(), "output"), ImageName: "platform"},
+	}
+}
+
+func TestBuild_RunsTheStepsInOrder(t *testing.T) {
+	fake := &fakeComponents{}
+	config := testBuildConfig(t)
+
+	result, err := newFakeBuilder(t, fake).Build(context.Background(), config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"PrepareBaseImage", "InstallPackages", "ConfigureSystem", "CustomizeFiles", "RunCommands",
+		"runtime.Install", "runtime.Configure", "runtime.EnableService", "runtime.PreloadImages",
+		"k8s.InstallBinaries", "k8s.ConfigureKubelet", "k8s.EnableServices", "k8s.PreloadImages", "k8s.InstallCNI", "k8s.InstallCSI",
+		"vcluster.InstallCLI", "vcluster.PreloadImages", "vcluster.PlaceTemplates",
+		"Cleanup", "Package",
+	}, fake.calls)
+
+	assert.Equal(t, filepath.Join(config.Output.OutputDir, "platform.img"), result.ArtifactPath)
+	assert.Len(t, result.Steps, len(fake.calls))
+	assert.Empty(t, result.WorkDir)
+	assert.NoDirExists(t, filepath.Dir(fake.rootFS), "the workspace is removed after the build")
+	assert.Contains(t, result.Summary(), "Artifact: "+result.ArtifactPath)
+}
+
+func TestBuild_CleansUpOnFailure(t *testing.T) {
+	fake := &fakeComponents{failOn: "k8s.InstallBinaries"}
+	_, err := newFakeBuilder(t, fake).Build(context.Background(), testBuildConfig(t))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `build step "Install Kubernetes binaries" failed`)
+	assert.Equal(t, []string{"k8s.InstallBinaries", "Cleanup"}, fake.calls[len(fake.calls)-2:])
+	assert.NoDirExists(t, filepath.Dir(fake.rootFS))
+}
+
+func TestBuild_KeepWorkDir(t *testing.T) {
+	fake := &fakeComponents{failOn: "Package"}
+	_, err := newFakeBuilder(t, fake, WithKeepWorkDir(true)).Build(context.Background(), testBuildConfig(t))
+	require.Error(t, err)
+	assert.Equal(t, 1, countCalls(fake.calls, "Cleanup"), "the root filesystem is cleaned up once")
+	assert.DirExists(t, fake.rootFS, "the workspace is kept for debugging")
+	assert.Contains(t, err.Error(), fmt.Sprintf(`build step "Package the image" failed (workspace kept at %s)`, filepath.Dir(fake.rootFS)))
+
+	fake = &fakeComponents{}
+	result, err := newFakeBuilder(t, fake, WithKeepWorkDir(true)).Build(context.Background(), testBuildConfig(t))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Dir(fake.rootFS), result.WorkDir)
+	assert.Contains(t, result.Summary(), "Workspace kept at: "+result.WorkDir)
+}
+
+func TestBuild_UnsupportedComponent(t *testing.T) {
+	utils.InitLogger("info", 0)
+	workDir := t.TempDir()
+	b, err := NewBuilder(WithWorkDir(workDir))
+	require.NoError(t, err)
+	config := testBuildConfig(t)
+	config.Cluster.BaseOS.ImageArchive = filepath.Join(workDir, "ubuntu.tar")
+	config.Cluster.ContainerRuntime = "rkt"
+
+	_, err = b.Build(context.Background(), config)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+	entries, err := os.ReadDir(workDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no workspace is created for an unsupported configuration")
+}
+
+func countCalls(calls []string, name string) int {
+	n := 0
+	for _, call := range calls {
+		if call == name {
+			n++
+		}
+	}
+	return n
+}
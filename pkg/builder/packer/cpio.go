@@ -0,0 +1,44 @@
+package packer
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// cpioEntry is a file or directory of a cpio archive, owned by root.
+// cpioEntry 是 cpio 归档中属于 root 的文件或目录。
+type cpioEntry struct {
+	name string
+	mode uint32 // Type and permission bits, e.g. 0100755 / 类型和权限位，例如 0100755
+	data []byte
+}
+
+// writeCPIO returns the newc cpio archive of entries, the format the kernel unpacks initramfs images from.
+// writeCPIO 返回 entries 的 newc cpio 归档，即内核解包 initramfs 镜像所用的格式。
+func writeCPIO(entries []cpioEntry, modTime time.Time) []byte {
+	var buf bytes.Buffer
+	pad := func() {
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	add := func(ino int, e cpioEntry) {
+		nlink := 1
+		if e.mode&0170000 == 0040000 {
+			nlink = 2
+		}
+		fmt.Fprintf(&buf, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+			ino, e.mode, 0, 0, nlink, modTime.Unix(), len(e.data), 0, 0, 0, 0, len(e.name)+1, 0)
+		buf.WriteString(e.name)
+		buf.WriteByte(0)
+		pad()
+		buf.Write(e.data)
+		pad()
+	}
+	for i, e := range entries {
+		add(i+1, e)
+	}
+	add(0, cpioEntry{name: "TRAILER!!!"})
+	return buf.Bytes()
+}
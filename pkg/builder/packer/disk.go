@@ -0,0 +1,295 @@
+package packer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+const (
+	// mib is the alignment of the partitions and the granularity of the disk size.
+	// mib 是分区的对齐单位和磁盘大小的粒度。
+	mib = 1 << 20
+	// espSize is the size of the EFI system partition.
+	// espSize 是 EFI 系统分区的大小。
+	espSize = 256 * mib
+	// rootSlack is added to the content of the root file system when no disk size is configured.
+	// rootSlack 在未配置磁盘大小时加到根文件系统的内容上。
+	rootSlack = 512 * mib
+	// grubPrefix is the directory of the EFI system partition GRUB reads grub.cfg from; it is also the removable-media
+	// boot path, so the image boots without NVRAM boot entries.
+	// grubPrefix 是 GRUB 读取 grub.cfg 的 EFI 系统分区目录；它也是可移动介质引导路径，因此镜像无需 NVRAM 引导项即可引导。
+	grubPrefix = "/EFI/BOOT"
+)
+
+// diskPacker packages the root filesystem as a bootable GPT disk: an EFI system partition holding GRUB and an ext4
+// root partition, written as a raw image or converted to qcow2.
+// diskPacker 将根文件系统打包为可引导的 GPT 磁盘：包含 GRUB 的 EFI 系统分区和 ext4 根分区，写为原始镜像或转换为 qcow2。
+type diskPacker struct {
+	format     enum.BuilderOutputFormat
+	kernelArgs []string
+}
+
+// Package builds the disk image of rootFS in config.OutputDir and returns its path.
+// Package 在 config.OutputDir 中构建 rootFS 的磁盘镜像并返回其路径。
+func (p *diskPacker) Package(ctx context.Context, rootFS string, config *model.OutputConfig) (string, error) {
+	kernel, err := findKernel(rootFS)
+	if err != nil {
+		return "", err
+	}
+	target, err := currentEFITarget()
+	if err != nil {
+		return "", err
+	}
+	totalSize, err := diskSize(rootFS, config.DiskSize)
+	if err != nil {
+		return "", err
+	}
+	rootUUID := uuid.New().String()
+	espVolumeID, err := randomVolumeID()
+	if err != nil {
+		return "", err
+	}
+	if err := writeFstab(rootFS, rootUUID, espVolumeID); err != nil {
+		return "", err
+	}
+	grubEFI, err := buildGrubImage(ctx, rootFS, target.platform, grubPrefix, grubModules)
+	if err != nil {
+		return "", err
+	}
+	utils.GetLogger().Printf("Packaging %s into a %s disk of %d MiB booting kernel %s", rootFS, p.format, totalSize/mib, kernel.version)
+
+	raw, err := os.CreateTemp(config.OutputDir, "."+config.ImageName+"-*.raw")
+	if err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create the disk image in %s", config.OutputDir), err)
+	}
+	rawPath := raw.Name()
+	defer os.Remove(rawPath) // A no-op once renamed / 重命名后不执行任何操作
+	if err := raw.Chmod(0644); err != nil {
+		raw.Close()
+		return "", errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to set the mode of %s", rawPath), err)
+	}
+	if err := p.writeDisk(raw, totalSize, kernel, target, grubEFI, rootUUID, espVolumeID); err != nil {
+		raw.Close()
+		return "", err
+	}
+	if err := raw.Close(); err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", rawPath), err)
+	}
+
+	rootStart := int64(espSize + mib)
+	if err := makeRootFilesystem(ctx, rootFS, rawPath, rootUUID, rootStart, totalSize-rootStart-mib); err != nil {
+		return "", err
+	}
+
+	outputPath := filepath.Join(config.OutputDir, config.ImageName+"."+string(p.format))
+	if p.format == enum.OutputFormatQCOW2 {
+		if err := convertQCOW2(ctx, rawPath, outputPath); err != nil {
+			os.Remove(outputPath)
+			return "", err
+		}
+	} else if err := os.Rename(rawPath, outputPath); err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to move the disk image to %s", outputPath), err)
+	}
+	utils.GetLogger().Printf("Disk image written to %s", outputPath)
+	return outputPath, nil
+}
+
+// writeDisk sizes raw to totalSize and writes its partition table and EFI system partition. The EFI system partition
+// starts at 1 MiB, the root partition follows it and ends 1 MiB before the end of the disk.
+// writeDisk 将 raw 调整为 totalSize 并写入其分区表和 EFI 系统分区。EFI 系统分区从 1 MiB 开始，根分区紧随其后，到磁盘末尾之前 1 MiB 结束。
+func (p *diskPacker) writeDisk(raw *os.File, totalSize int64, kernel *kernelImage, target efiTarget, grubEFI []byte, rootUUID string, espVolumeID uint32) error {
+	writeErr := func(err error) error {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", raw.Name()), err)
+	}
+	if err := raw.Truncate(totalSize); err != nil {
+		return writeErr(err)
+	}
+	const espFirstLBA = mib / sectorSize
+	const espLastLBA = espFirstLBA + espSize/sectorSize - 1
+	totalSectors := uint64(totalSize / sectorSize)
+	partitions := []gptPartition{
+		{typeGUID: espTypeGUID, guid: uuid.New(), name: "EFI System Partition", firstLBA: espFirstLBA, lastLBA: espLastLBA},
+		{typeGUID: linuxFilesystemTypeGUID, guid: uuid.New(), name: "root", firstLBA: espLastLBA + 1, lastLBA: totalSectors - mib/sectorSize - 1},
+	}
+	if err := writeGPT(raw, totalSectors, uuid.New(), partitions); err != nil {
+		return writeErr(err)
+	}
+	files := espFiles(kernel, target, grubEFI, rootUUID, p.kernelArgs)
+	if err := writeFAT(raw, mib, espSize, espFirstLBA, espVolumeID, "EFI", time.Now(), files); err != nil {
+		return writeErr(err)
+	}
+	return nil
+}
+
+// espFiles returns the files of the EFI system partition: GRUB and its configuration booting kernel from the root
+// file system with UUID rootUUID.
+// espFiles 返回 EFI 系统分区的文件：GRUB 及其从 UUID 为 rootUUID 的根文件系统引导 kernel 的配置。
+func espFiles(kernel *kernelImage, target efiTarget, grubEFI []byte, rootUUID string, kernelArgs []string) []fatFile {
+	dir := strings.TrimPrefix(grubPrefix, "/")
+	return []fatFile{
+		{path: dir + "/" + target.bootFile, data: grubEFI},
+		{path: dir + "/GRUB.CFG", data: []byte(renderGrubConfig(kernel, rootUUID, kernelArgs))},
+	}
+}
+
+// diskSize returns the size of the disk image: the configured size, or room for the content of rootFS plus a quarter
+// and rootSlack, the EFI system partition and the alignment gaps. It is rounded up to whole MiB.
+// diskSize 返回磁盘镜像的大小：配置的大小，或者 rootFS 内容加四分之一再加 rootSlack、EFI 系统分区及对齐间隙的空间。结果向上取整到整 MiB。
+func diskSize(rootFS, configured string) (int64, error) {
+	const overhead = espSize + 2*mib // Gaps before and after the partitions / 分区之前和之后的间隙
+	var size int64
+	if configured != "" {
+		quantity, err := resource.ParseQuantity(configured)
+		if err != nil {
+			return 0, errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("invalid output.diskSize '%s'", configured), err)
+		}
+		size = quantity.Value()
+		if size < overhead+64*mib {
+			return 0, errors.New(errors.ErrTypeValidation, fmt.Sprintf("output.diskSize '%s' leaves no room for the root file system", configured))
+		}
+	} else {
+		used, err := contentSize(rootFS)
+		if err != nil {
+			return 0, err
+		}
+		size = used + used/4 + rootSlack + overhead
+	}
+	return (size + mib - 1) / mib * mib, nil
+}
+
+// contentSize estimates the space the files of rootFS take on ext4: their size in 4 KiB blocks plus a block per file.
+// contentSize 估算 rootFS 中的文件在 ext4 上占用的空间：以 4 KiB 块计的大小，再为每个文件加一个块。
+func contentSize(rootFS string) (int64, error) {
+	const block = 4096
+	var used int64
+	err := filepath.WalkDir(rootFS, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		used += block
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			used += (info.Size() + block - 1) / block * block
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to measure the root filesystem %s", rootFS), err)
+	}
+	return used, nil
+}
+
+// randomVolumeID returns a random FAT volume ID.
+// randomVolumeID 返回随机的 FAT 卷 ID。
+func randomVolumeID() (uint32, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, errors.NewWithCause(errors.ErrTypeInternal, "failed to generate the EFI system partition volume ID", err)
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+// writeFstab mounts the root and EFI system partitions in /etc/fstab of rootFS, replacing the entries of / and
+// /boot/efi and keeping the others, and creates the /boot/efi mount point. Symbolic links are refused, as they would
+// resolve on the build machine.
+// writeFstab 在 rootFS 的 /etc/fstab 中挂载根分区和 EFI 系统分区，替换 / 和 /boot/efi 的条目并保留其他条目，同时创建 /boot/efi 挂载点。
+// 拒绝符号链接，因为它们会在构建机器上解析。
+func writeFstab(rootFS, rootUUID string, espVolumeID uint32) error {
+	for _, dir := range []string{"etc", "boot", "boot/efi"} {
+		path := filepath.Join(rootFS, dir)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(path, 0755); err != nil {
+				return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create /%s in the image", dir), err)
+			}
+			continue
+		}
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to inspect /%s in the image", dir), err)
+		}
+		if !info.IsDir() {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("/%s in the image is not a directory", dir))
+		}
+	}
+
+	path := filepath.Join(rootFS, "etc", "fstab")
+	var kept []string
+	if info, err := os.Lstat(path); err == nil {
+		if !info.Mode().IsRegular() {
+			return errors.New(errors.ErrTypeValidation, "/etc/fstab in the image is not a regular file")
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeIO, "failed to read /etc/fstab of the image", err)
+		}
+		for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && !strings.HasPrefix(fields[0], "#") && (fields[1] == "/" || fields[1] == "/boot/efi") {
+				continue
+			}
+			if line != "" || len(kept) > 0 {
+				kept = append(kept, line)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.NewWithCause(errors.ErrTypeIO, "failed to inspect /etc/fstab of the image", err)
+	}
+
+	var buf bytes.Buffer
+	for _, line := range kept {
+		buf.WriteString(line + "\n")
+	}
+	fmt.Fprintf(&buf, "UUID=%s / ext4 defaults 0 1\n", rootUUID)
+	fmt.Fprintf(&buf, "UUID=%04X-%04X /boot/efi vfat umask=0077 0 2\n", espVolumeID>>16, espVolumeID&0xFFFF)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, "failed to write /etc/fstab of the image", err)
+	}
+	return nil
+}
+
+// makeRootFilesystem formats size bytes of the disk image at offset as ext4 with the content of rootFS. The mke2fs.conf
+// of the image is used when it has one, so the file system has the features its e2fsck knows.
+// makeRootFilesystem 将磁盘镜像中 offset 处的 size 字节格式化为包含 rootFS 内容的 ext4。镜像有 mke2fs.conf 时使用它，
+// 使文件系统具有其 e2fsck 所支持的特性。
+func makeRootFilesystem(ctx context.Context, rootFS, diskPath, rootUUID string, offset, size int64) error {
+	cmd := exec.CommandContext(ctx, "mke2fs", "-q", "-F", "-t", "ext4", "-L", "root", "-U", rootUUID,
+		"-E", fmt.Sprintf("offset=%d", offset), "-d", rootFS, diskPath, fmt.Sprintf("%dk", size/1024))
+	if conf := filepath.Join(rootFS, "etc", "mke2fs.conf"); isRegularFile(conf) {
+		cmd.Env = append(os.Environ(), "MKE2FS_CONFIG="+conf)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return errors.NewWithCause(errors.ErrTypeTimeout, "creating the root file system was interrupted", ctx.Err())
+		}
+		return errors.NewWithCause(errors.ErrTypeSystem,
+			fmt.Sprintf("failed to create the root file system: %s", strings.TrimSpace(string(output))), err)
+	}
+	return nil
+}
+
+// isRegularFile reports whether path is a regular file, without following a symbolic link.
+// isRegularFile 报告 path 是否为常规文件，不跟随符号链接。
+func isRegularFile(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,270 @@
+package packer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// fakeGrubImage replaces the GRUB build, which needs the GRUB modules in the image.
+func fakeGrubImage(t *testing.T) {
+	t.Helper()
+	previous := buildGrubImage
+	buildGrubImage = func(ctx context.Context, rootFS, format, prefix string, modules []string) ([]byte, error) {
+		image := []byte("MZ grub " + format + " " + prefix)
+		if strings.HasSuffix(format, "-eltorito") {
+			// Real El Torito images extend past the locations patched when they are laid out.
+			image = append(image, make([]byte, 4096-len(image))...)
+		}
+		return image, nil
+	}
+	t.Cleanup(func() { buildGrubImage = previous })
+}
+
+// newTestRootFS returns a root filesystem with two kernels and an fstab with a swap entry.
+func newTestRootFS(t *testing.T) string {
+	t.Helper()
+	rootFS := t.TempDir()
+	for path, content := range map[string]string{
+		"boot/vmlinuz-6.8.0-9-generic":     "old kernel",
+		"boot/initrd.img-6.8.0-9-generic":  "old initrd",
+		"boot/vmlinuz-6.8.0-45-generic":    "kernel",
+		"boot/initrd.img-6.8.0-45-generic": "initrd",
+		"boot/vmlinuz-6.9.0-1-generic":     "kernel without initrd",
+		"etc/fstab":                        "# UNCONFIGURED FSTAB\nLABEL=old / ext4 defaults 0 1\n/swap.img none swap sw 0 0\n",
+		"etc/hostname":                     "node\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(rootFS, path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(rootFS, path), []byte(content), 0644))
+	}
+	return rootFS
+}
+
+// gptEntries checks the primary and backup GPT headers of the disk and returns its partition entries.
+func gptEntries(t *testing.T, disk io.ReaderAt, size int64) [][]byte {
+	t.Helper()
+	mbr := make([]byte, sectorSize)
+	_, err := disk.ReadAt(mbr, 0)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0xEE), mbr[446+4], "protective MBR")
+	assert.Equal(t, []byte{0x55, 0xAA}, mbr[510:])
+
+	var entries []byte
+	for _, lba := range []int64{1, size/sectorSize - 1} {
+		header := make([]byte, sectorSize)
+		_, err := disk.ReadAt(header, lba*sectorSize)
+		require.NoError(t, err)
+		require.Equal(t, "EFI PART", string(header[0:8]), "GPT header at LBA %d", lba)
+		crc := binary.LittleEndian.Uint32(header[16:])
+		binary.LittleEndian.PutUint32(header[16:], 0)
+		assert.Equal(t, crc32.ChecksumIEEE(header[:92]), crc, "header CRC at LBA %d", lba)
+		assert.Equal(t, uint64(lba), binary.LittleEndian.Uint64(header[24:]))
+
+		array := make([]byte, gptEntryCount*gptEntrySize)
+		_, err = disk.ReadAt(array, int64(binary.LittleEndian.Uint64(header[72:]))*sectorSize)
+		require.NoError(t, err)
+		assert.Equal(t, crc32.ChecksumIEEE(array), binary.LittleEndian.Uint32(header[88:]), "entries CRC at LBA %d", lba)
+		entries = array
+	}
+	var partitions [][]byte
+	for i := 0; i < gptEntryCount; i++ {
+		if entry := entries[i*gptEntrySize : (i+1)*gptEntrySize]; !bytes.Equal(entry[:16], make([]byte, 16)) {
+			partitions = append(partitions, entry)
+		}
+	}
+	return partitions
+}
+
+// readFATFile reads the file at the slash-separated path of 8.3 names from the FAT16 or FAT32 file system at offset.
+func readFATFile(t *testing.T, disk io.ReaderAt, offset int64, path string) []byte {
+	t.Helper()
+	boot := make([]byte, sectorSize)
+	_, err := disk.ReadAt(boot, offset)
+	require.NoError(t, err)
+	fat32 := string(boot[82:90]) == "FAT32   "
+	require.True(t, fat32 || string(boot[54:62]) == "FAT16   ", "FAT16 or FAT32 boot sector")
+	bytesPerSector := int64(binary.LittleEndian.Uint16(boot[11:]))
+	clusterSize := bytesPerSector * int64(boot[13])
+	fatStart := offset + int64(binary.LittleEndian.Uint16(boot[14:]))*bytesPerSector
+	fatSectors := int64(binary.LittleEndian.Uint16(boot[22:]))
+	if fat32 {
+		fatSectors = int64(binary.LittleEndian.Uint32(boot[36:]))
+	}
+	rootStart := fatStart + int64(boot[16])*fatSectors*bytesPerSector
+	rootSize := int64(binary.LittleEndian.Uint16(boot[17:])) * 32
+	dataStart := rootStart + rootSize
+
+	readChain := func(cluster uint32) []byte {
+		var data []byte
+		for cluster >= 2 && (fat32 && cluster < 0x0FFFFFF8 || !fat32 && cluster < 0xFFF8) {
+			buf := make([]byte, clusterSize)
+			_, err := disk.ReadAt(buf, dataStart+int64(cluster-2)*clusterSize)
+			require.NoError(t, err)
+			data = append(data, buf...)
+			if fat32 {
+				var next [4]byte
+				_, err = disk.ReadAt(next[:], fatStart+int64(cluster)*4)
+				require.NoError(t, err)
+				cluster = binary.LittleEndian.Uint32(next[:]) & 0x0FFFFFFF
+			} else {
+				var next [2]byte
+				_, err = disk.ReadAt(next[:], fatStart+int64(cluster)*2)
+				require.NoError(t, err)
+				cluster = uint32(binary.LittleEndian.Uint16(next[:]))
+			}
+		}
+		return data
+	}
+
+	var dir []byte
+	if fat32 {
+		dir = readChain(binary.LittleEndian.Uint32(boot[44:]))
+	} else {
+		dir = make([]byte, rootSize)
+		_, err := disk.ReadAt(dir, rootStart)
+		require.NoError(t, err)
+	}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		name, err := fatShortName(part)
+		require.NoError(t, err)
+		var found []byte
+		for e := 0; e+32 <= len(dir) && dir[e] != 0; e += 32 {
+			if dir[e+11]&fatAttrVolumeID == 0 && bytes.Equal(dir[e:e+11], name[:]) {
+				found = dir[e : e+32]
+				break
+			}
+		}
+		require.NotNil(t, found, "%s is not in the file system", path)
+		cluster := uint32(binary.LittleEndian.Uint16(found[20:]))<<16 | uint32(binary.LittleEndian.Uint16(found[26:]))
+		if i < len(parts)-1 {
+			require.NotZero(t, found[11]&fatAttrDirectory, "%s is not a directory", part)
+			dir = readChain(cluster)
+			continue
+		}
+		return readChain(cluster)[:binary.LittleEndian.Uint32(found[28:])]
+	}
+	return nil
+}
+
+func TestDiskPacker_Package(t *testing.T) {
+	if _, err := exec.LookPath("mke2fs"); err != nil {
+		t.Skip("mke2fs is not installed")
+	}
+	target, err := currentEFITarget()
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	utils.InitLogger("info", 0)
+	fakeGrubImage(t)
+
+	for _, format := range []enum.BuilderOutputFormat{enum.OutputFormatRaw, enum.OutputFormatQCOW2} {
+		t.Run(string(format), func(t *testing.T) {
+			rootFS := newTestRootFS(t)
+			p, err := NewImagePacker(format, []string{"console=ttyS0,115200", "net.ifnames=0"})
+			require.NoError(t, err)
+			config := &model.OutputConfig{Format: format, OutputDir: t.TempDir(), ImageName: "platform"}
+
+			path, err := p.Package(context.Background(), rootFS, config)
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(config.OutputDir, "platform."+string(format)), path)
+			leftovers, err := filepath.Glob(filepath.Join(config.OutputDir, "*"))
+			require.NoError(t, err)
+			assert.Equal(t, []string{path}, leftovers, "the temporary raw disk is removed")
+
+			var disk io.ReaderAt
+			var size int64
+			if format == enum.OutputFormatQCOW2 {
+				r := openQCOW2(t, path)
+				disk, size = r, r.size
+			} else {
+				f, err := os.Open(path)
+				require.NoError(t, err)
+				defer f.Close()
+				info, err := f.Stat()
+				require.NoError(t, err)
+				disk, size = f, info.Size()
+			}
+			assert.Zero(t, size%mib)
+			assert.Greater(t, size, int64(espSize+rootSlack))
+
+			partitions := gptEntries(t, disk, size)
+			require.Len(t, partitions, 2)
+			assert.Equal(t, guidBytes(espTypeGUID), partitions[0][0:16], "EFI system partition type")
+			assert.Equal(t, uint64(2048), binary.LittleEndian.Uint64(partitions[0][32:]))
+			assert.Equal(t, guidBytes(linuxFilesystemTypeGUID), partitions[1][0:16], "Linux file system type")
+			rootStart := int64(binary.LittleEndian.Uint64(partitions[1][32:])) * sectorSize
+			assert.Equal(t, int64(espSize+mib), rootStart)
+			assert.Equal(t, uint64(size/sectorSize-2049), binary.LittleEndian.Uint64(partitions[1][40:]))
+
+			assert.Equal(t, "MZ grub "+target.platform+" /EFI/BOOT", string(readFATFile(t, disk, mib, "EFI/BOOT/"+target.bootFile)))
+			grubCfg := string(readFATFile(t, disk, mib, "EFI/BOOT/GRUB.CFG"))
+			match := regexp.MustCompile(`(?m)^search --no-floppy --fs-uuid --set=root ([0-9a-f-]{36})$`).FindStringSubmatch(grubCfg)
+			require.NotNil(t, match, grubCfg)
+			rootUUID := match[1]
+			assert.Contains(t, grubCfg, "\tlinux /boot/vmlinuz-6.8.0-45-generic root=UUID="+rootUUID+" ro console=ttyS0,115200 net.ifnames=0\n")
+			assert.Contains(t, grubCfg, "\tinitrd /boot/initrd.img-6.8.0-45-generic\n")
+
+			fstab, err := os.ReadFile(filepath.Join(rootFS, "etc", "fstab"))
+			require.NoError(t, err)
+			assert.Regexp(t, `^# UNCONFIGURED FSTAB
+/swap.img none swap sw 0 0
+UUID=`+rootUUID+` / ext4 defaults 0 1
+UUID=[0-9A-F]{4}-[0-9A-F]{4} /boot/efi vfat umask=0077 0 2
+$`, string(fstab))
+			assert.DirExists(t, filepath.Join(rootFS, "boot", "efi"))
+
+			// The root file system is checked with e2fsprogs, which read the raw disk at an offset.
+			if _, err := exec.LookPath("debugfs"); err != nil || format != enum.OutputFormatRaw {
+				return
+			}
+			device := path + "?offset=" + strconv.FormatInt(rootStart, 10)
+			output, err := exec.Command("e2fsck", "-fn", device).CombinedOutput()
+			assert.NoError(t, err, string(output))
+			output, err = exec.Command("debugfs", "-R", "cat /etc/fstab", device).Output()
+			require.NoError(t, err)
+			assert.Equal(t, string(fstab), string(output))
+		})
+	}
+}
+
+func TestDiskPacker_MissingKernel(t *testing.T) {
+	fakeGrubImage(t)
+	p, err := NewImagePacker(enum.OutputFormatQCOW2, nil)
+	require.NoError(t, err)
+	_, err = p.Package(context.Background(), t.TempDir(), &model.OutputConfig{OutputDir: t.TempDir(), ImageName: "platform"})
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+	assert.ErrorContains(t, err, "kernel package")
+}
+
+func TestFindKernel(t *testing.T) {
+	rootFS := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootFS, "boot"), 0755))
+	for _, name := range []string{"vmlinuz-5.14.0-427.el9.x86_64", "initramfs-5.14.0-427.el9.x86_64.img", "vmlinuz-5.14.0-70.el9.x86_64", "initramfs-5.14.0-70.el9.x86_64.img"} {
+		require.NoError(t, os.WriteFile(filepath.Join(rootFS, "boot", name), nil, 0644))
+	}
+	kernel, err := findKernel(rootFS)
+	require.NoError(t, err)
+	assert.Equal(t, &kernelImage{
+		version: "5.14.0-427.el9.x86_64",
+		kernel:  "/boot/vmlinuz-5.14.0-427.el9.x86_64",
+		initrd:  "/boot/initramfs-5.14.0-427.el9.x86_64.img",
+	}, kernel)
+}
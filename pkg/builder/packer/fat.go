@@ -0,0 +1,396 @@
+package packer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+const (
+	fatCount = 2
+	// fatClusterSize is one sector, so small file systems still have the cluster count of their FAT type.
+	// fatClusterSize 为一个扇区，使较小的文件系统也具有其 FAT 类型所需的簇数。
+	fatClusterSize = sectorSize
+	// fat16MinClusters and fat32MinClusters are the smallest cluster counts of FAT16 and FAT32; the FAT type of a file
+	// system is determined by its cluster count alone.
+	// fat16MinClusters 和 fat32MinClusters 是 FAT16 和 FAT32 的最小簇数；文件系统的 FAT 类型仅由其簇数决定。
+	fat16MinClusters = 4085
+	fat32MinClusters = 65525
+	// fat32ReservedSectors holds the boot sector, the FSInfo sector and their backups.
+	// fat32ReservedSectors 保存引导扇区、FSInfo 扇区及其备份。
+	fat32ReservedSectors = 32
+	// fat16RootEntries is the size of the fixed root directory of FAT16.
+	// fat16RootEntries 是 FAT16 固定根目录的大小。
+	fat16RootEntries = 512
+	fatAttrDirectory = 0x10
+	fatAttrArchive   = 0x20
+	fatAttrVolumeID  = 0x08
+)
+
+// fatFile is a file stored in a FAT file system, at a slash-separated path of 8.3 names.
+// fatFile 是存储在 FAT 文件系统中的文件，其路径由斜杠分隔的 8.3 名称组成。
+type fatFile struct {
+	path string
+	data []byte
+}
+
+// fatNode is a file or directory of the file system being written.
+// fatNode 是正在写入的文件系统中的文件或目录。
+type fatNode struct {
+	name     [11]byte
+	dir      bool
+	data     []byte
+	children []*fatNode
+	cluster  uint32
+	clusters uint32
+}
+
+// fatLayout is the geometry of a FAT16 or FAT32 file system.
+// fatLayout 是 FAT16 或 FAT32 文件系统的几何结构。
+type fatLayout struct {
+	fat32           bool
+	totalSectors    uint32
+	reservedSectors uint32
+	fatSectors      uint32
+	rootDirSectors  uint32 // The fixed root directory of FAT16 / FAT16 的固定根目录
+	clusterCount    uint32
+}
+
+// newFATLayout returns the layout of a file system of totalSectors sectors: FAT32 when it has the clusters for it,
+// FAT16 otherwise.
+// newFATLayout 返回 totalSectors 个扇区的文件系统布局：簇数足够时为 FAT32，否则为 FAT16。
+func newFATLayout(totalSectors uint32) (*fatLayout, error) {
+	for _, l := range []*fatLayout{
+		{fat32: true, totalSectors: totalSectors, reservedSectors: fat32ReservedSectors},
+		{totalSectors: totalSectors, reservedSectors: 1, rootDirSectors: fat16RootEntries * 32 / sectorSize},
+	} {
+		entrySize := uint32(2)
+		if l.fat32 {
+			entrySize = 4
+		}
+		l.fatSectors = 1
+		for {
+			overhead := l.reservedSectors + fatCount*l.fatSectors + l.rootDirSectors
+			if overhead >= totalSectors {
+				break
+			}
+			l.clusterCount = (totalSectors - overhead) * sectorSize / fatClusterSize
+			need := ((l.clusterCount+2)*entrySize + sectorSize - 1) / sectorSize
+			if need <= l.fatSectors {
+				break
+			}
+			l.fatSectors = need
+		}
+		if l.fat32 && l.clusterCount >= fat32MinClusters || !l.fat32 && l.clusterCount >= fat16MinClusters && l.clusterCount < fat32MinClusters {
+			return l, nil
+		}
+	}
+	return nil, errors.New(errors.ErrTypeInternal, fmt.Sprintf("%d bytes are too few for a FAT file system", int64(totalSectors)*sectorSize))
+}
+
+// dataStart returns the first sector of the cluster area.
+// dataStart 返回簇区域的第一个扇区。
+func (l *fatLayout) dataStart() uint32 {
+	return l.reservedSectors + fatCount*l.fatSectors + l.rootDirSectors
+}
+
+// writeFAT formats size bytes of w at offset as a FAT file system holding files; the region must read as zeros.
+// The file system is FAT32 when it is large enough and FAT16 otherwise. Only 8.3 names are written, which UEFI
+// firmware and GRUB match without regard to case. hiddenSectors is the first sector of the partition on its disk.
+// writeFAT 将 w 中 offset 处的 size 字节格式化为包含 files 的 FAT 文件系统；该区域必须读取为零。
+// 足够大时文件系统为 FAT32，否则为 FAT16。只写入 8.3 名称，UEFI 固件和 GRUB 匹配时不区分大小写。
+// hiddenSectors 是分区在其磁盘上的第一个扇区。
+func writeFAT(w io.WriterAt, offset, size int64, hiddenSectors uint32, volumeID uint32, label string, modTime time.Time, files []fatFile) error {
+	layout, err := newFATLayout(uint32(size / sectorSize))
+	if err != nil {
+		return err
+	}
+	root, err := fatTree(files)
+	if err != nil {
+		return err
+	}
+	next := uint32(2)
+	var allocate func(n *fatNode, isRoot bool)
+	allocate = func(n *fatNode, isRoot bool) {
+		length := len(n.data)
+		if n.dir {
+			entries := len(n.children) + 2 // "." and ".." / "." 和 ".."
+			if isRoot {
+				entries = len(n.children) + 1 // Volume label / 卷标
+			}
+			length = entries * 32
+		}
+		// The FAT32 root directory gets the first cluster; the FAT16 one has its own region.
+		// FAT32 根目录获得第一个簇；FAT16 根目录有自己的区域。
+		if !isRoot || layout.fat32 {
+			n.clusters = uint32((length + fatClusterSize - 1) / fatClusterSize)
+		}
+		if n.clusters > 0 {
+			n.cluster = next
+			next += n.clusters
+		}
+		for _, child := range n.children {
+			allocate(child, false)
+		}
+	}
+	allocate(root, true)
+	if next-2 > layout.clusterCount || !layout.fat32 && len(root.children)+1 > fat16RootEntries {
+		return errors.New(errors.ErrTypeInternal, fmt.Sprintf("the files do not fit in a FAT file system of %d bytes", size))
+	}
+
+	entrySize, endOfChain := uint32(2), uint32(0xFFFF)
+	if layout.fat32 {
+		entrySize, endOfChain = 4, 0x0FFFFFFF
+	}
+	fat := make([]byte, next*entrySize)
+	putEntry := func(cluster, value uint32) {
+		if layout.fat32 {
+			binary.LittleEndian.PutUint32(fat[cluster*4:], value)
+		} else {
+			binary.LittleEndian.PutUint16(fat[cluster*2:], uint16(value))
+		}
+	}
+	putEntry(0, endOfChain&^0x7) // Media descriptor 0xF8 / 媒体描述符 0xF8
+	putEntry(1, endOfChain)
+	dataStart := offset + int64(layout.dataStart())*sectorSize
+	labelName := fatLabel(label)
+	var write func(n, parent *fatNode) error
+	write = func(n, parent *fatNode) error {
+		for c := n.cluster; c < n.cluster+n.clusters; c++ {
+			link := c + 1
+			if link == n.cluster+n.clusters {
+				link = endOfChain
+			}
+			putEntry(c, link)
+		}
+		content := n.data
+		if n.dir {
+			var buf []byte
+			switch parent {
+			case nil:
+				buf = append(buf, fatDirEntry(labelName, fatAttrVolumeID, 0, 0, modTime)...)
+			default:
+				parentCluster := parent.cluster
+				if parent == root {
+					parentCluster = 0 // ".." of a top-level directory names the root as cluster 0 / 顶级目录的 ".." 以簇 0 表示根目录
+				}
+				buf = append(buf, fatDirEntry(fatDotName("."), fatAttrDirectory, n.cluster, 0, modTime)...)
+				buf = append(buf, fatDirEntry(fatDotName(".."), fatAttrDirectory, parentCluster, 0, modTime)...)
+			}
+			for _, child := range n.children {
+				attr, size := byte(fatAttrArchive), uint32(len(child.data))
+				if child.dir {
+					attr, size = fatAttrDirectory, 0
+				}
+				buf = append(buf, fatDirEntry(child.name, attr, child.cluster, size, modTime)...)
+			}
+			content = buf
+		}
+		if len(content) == 0 {
+			return nil
+		}
+		at := dataStart + int64(n.cluster-2)*fatClusterSize
+		if n == root && !layout.fat32 {
+			at = offset + int64(layout.reservedSectors+fatCount*layout.fatSectors)*sectorSize
+		}
+		if _, err := w.WriteAt(content, at); err != nil {
+			return err
+		}
+		for _, child := range n.children {
+			if err := write(child, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := write(root, nil); err != nil {
+		return err
+	}
+
+	bootSector := fatBootSector(layout, hiddenSectors, volumeID, labelName)
+	sectors := []struct {
+		index uint32
+		data  []byte
+	}{{0, bootSector}}
+	if layout.fat32 {
+		fsInfo := fatFSInfo(layout.clusterCount-(next-2), next)
+		sectors = append(sectors, []struct {
+			index uint32
+			data  []byte
+		}{{1, fsInfo}, {6, bootSector}, {7, fsInfo}}...)
+	}
+	for _, sector := range sectors {
+		if _, err := w.WriteAt(sector.data, offset+int64(sector.index)*sectorSize); err != nil {
+			return err
+		}
+	}
+	for i := uint32(0); i < fatCount; i++ {
+		if _, err := w.WriteAt(fat, offset+int64(layout.reservedSectors+i*layout.fatSectors)*sectorSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fatTree builds the directory tree of the files, sorted by name.
+// fatTree 构建文件的目录树，按名称排序。
+func fatTree(files []fatFile) (*fatNode, error) {
+	root := &fatNode{dir: true}
+	for _, file := range files {
+		parts := strings.Split(strings.Trim(file.path, "/"), "/")
+		dir := root
+		for i, part := range parts {
+			name, err := fatShortName(part)
+			if err != nil {
+				return nil, err
+			}
+			var node *fatNode
+			for _, child := range dir.children {
+				if child.name == name {
+					node = child
+				}
+			}
+			last := i == len(parts)-1
+			switch {
+			case node == nil:
+				node = &fatNode{name: name, dir: !last}
+				if last {
+					node.data = file.data
+				}
+				dir.children = append(dir.children, node)
+			case last || !node.dir:
+				return nil, errors.New(errors.ErrTypeInternal, fmt.Sprintf("%s is declared twice in the FAT file system", file.path))
+			}
+			dir = node
+		}
+	}
+	var sortTree func(n *fatNode)
+	sortTree = func(n *fatNode) {
+		sort.Slice(n.children, func(i, j int) bool { return string(n.children[i].name[:]) < string(n.children[j].name[:]) })
+		for _, child := range n.children {
+			sortTree(child)
+		}
+	}
+	sortTree(root)
+	return root, nil
+}
+
+// fatShortName converts a file name to its space-padded 8.3 form.
+// fatShortName 将文件名转换为以空格填充的 8.3 形式。
+func fatShortName(name string) ([11]byte, error) {
+	var short [11]byte
+	for i := range short {
+		short[i] = ' '
+	}
+	base, ext, _ := strings.Cut(strings.ToUpper(name), ".")
+	if base == "" || len(base) > 8 || len(ext) > 3 || strings.Contains(ext, ".") {
+		return short, errors.New(errors.ErrTypeInternal, fmt.Sprintf("%q is not an 8.3 file name", name))
+	}
+	for _, c := range base + ext {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_-~!#$%&'(){}^@`", c)) {
+			return short, errors.New(errors.ErrTypeInternal, fmt.Sprintf("%q is not an 8.3 file name", name))
+		}
+	}
+	copy(short[0:8], base)
+	copy(short[8:11], ext)
+	return short, nil
+}
+
+// fatDotName returns the name of the "." or ".." entry.
+// fatDotName 返回 "." 或 ".." 条目的名称。
+func fatDotName(dots string) [11]byte {
+	var name [11]byte
+	copy(name[:], dots+strings.Repeat(" ", 11-len(dots)))
+	return name
+}
+
+// fatLabel returns the space-padded volume label.
+// fatLabel 返回以空格填充的卷标。
+func fatLabel(label string) [11]byte {
+	var name [11]byte
+	copy(name[:], strings.ToUpper(label)+strings.Repeat(" ", 11))
+	return name
+}
+
+// fatDirEntry renders a 32-byte directory entry.
+// fatDirEntry 渲染一个 32 字节的目录条目。
+func fatDirEntry(name [11]byte, attr byte, cluster, size uint32, modTime time.Time) []byte {
+	e := make([]byte, 32)
+	copy(e[0:11], name[:])
+	e[11] = attr
+	date := uint16(modTime.Year()-1980)<<9 | uint16(modTime.Month())<<5 | uint16(modTime.Day())
+	clock := uint16(modTime.Hour())<<11 | uint16(modTime.Minute())<<5 | uint16(modTime.Second()/2)
+	binary.LittleEndian.PutUint16(e[14:], clock)
+	binary.LittleEndian.PutUint16(e[16:], date)
+	binary.LittleEndian.PutUint16(e[18:], date)
+	binary.LittleEndian.PutUint16(e[20:], uint16(cluster>>16))
+	binary.LittleEndian.PutUint16(e[22:], clock)
+	binary.LittleEndian.PutUint16(e[24:], date)
+	binary.LittleEndian.PutUint16(e[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(e[28:], size)
+	return e
+}
+
+// fatBootSector renders the boot sector with the BIOS parameter block of the layout.
+// fatBootSector 渲染带有该布局 BIOS 参数块的引导扇区。
+func fatBootSector(l *fatLayout, hiddenSectors, volumeID uint32, label [11]byte) []byte {
+	bs := make([]byte, sectorSize)
+	copy(bs[3:11], "CHASIBOD")
+	binary.LittleEndian.PutUint16(bs[11:], sectorSize)
+	bs[13] = fatClusterSize / sectorSize
+	binary.LittleEndian.PutUint16(bs[14:], uint16(l.reservedSectors))
+	bs[16] = fatCount
+	bs[21] = 0xF8 // Fixed disk / 固定磁盘
+	binary.LittleEndian.PutUint16(bs[24:], 63)
+	binary.LittleEndian.PutUint16(bs[26:], 255)
+	binary.LittleEndian.PutUint32(bs[28:], hiddenSectors)
+	// The extended boot record follows the FAT32 fields, which FAT16 does not have.
+	// 扩展引导记录位于 FAT32 字段之后，FAT16 没有这些字段。
+	ebr := bs[36:]
+	if l.fat32 {
+		copy(bs[0:3], []byte{0xEB, 0x58, 0x90})
+		binary.LittleEndian.PutUint32(bs[32:], l.totalSectors)
+		binary.LittleEndian.PutUint32(bs[36:], l.fatSectors)
+		binary.LittleEndian.PutUint32(bs[44:], 2) // Root directory cluster / 根目录簇
+		binary.LittleEndian.PutUint16(bs[48:], 1) // FSInfo sector / FSInfo 扇区
+		binary.LittleEndian.PutUint16(bs[50:], 6) // Backup boot sector / 备份引导扇区
+		ebr = bs[64:]
+	} else {
+		copy(bs[0:3], []byte{0xEB, 0x3C, 0x90})
+		binary.LittleEndian.PutUint16(bs[17:], fat16RootEntries)
+		if l.totalSectors < 1<<16 {
+			binary.LittleEndian.PutUint16(bs[19:], uint16(l.totalSectors))
+		} else {
+			binary.LittleEndian.PutUint32(bs[32:], l.totalSectors)
+		}
+		binary.LittleEndian.PutUint16(bs[22:], uint16(l.fatSectors))
+	}
+	ebr[0] = 0x80
+	ebr[2] = 0x29
+	binary.LittleEndian.PutUint32(ebr[3:], volumeID)
+	copy(ebr[7:18], label[:])
+	if l.fat32 {
+		copy(ebr[18:26], "FAT32   ")
+	} else {
+		copy(ebr[18:26], "FAT16   ")
+	}
+	bs[510], bs[511] = 0x55, 0xAA
+	return bs
+}
+
+// fatFSInfo renders the FSInfo sector holding the free cluster hints.
+// fatFSInfo 渲染保存空闲簇提示的 FSInfo 扇区。
+func fatFSInfo(freeClusters, nextFree uint32) []byte {
+	info := make([]byte, sectorSize)
+	binary.LittleEndian.PutUint32(info[0:], 0x41615252)
+	binary.LittleEndian.PutUint32(info[484:], 0x61417272)
+	binary.LittleEndian.PutUint32(info[488:], freeClusters)
+	binary.LittleEndian.PutUint32(info[492:], nextFree)
+	binary.LittleEndian.PutUint32(info[508:], 0xAA550000)
+	return info
+}
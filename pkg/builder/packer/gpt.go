@@ -0,0 +1,130 @@
+package packer
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"unicode/utf16"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// sectorSize is the logical sector size of the disk images.
+	// sectorSize 是磁盘镜像的逻辑扇区大小。
+	sectorSize = 512
+	// gptEntryCount and gptEntrySize are the usual size of the partition entry array, 32 sectors.
+	// gptEntryCount 和 gptEntrySize 是分区条目数组的常用大小，即 32 个扇区。
+	gptEntryCount = 128
+	gptEntrySize  = 128
+	// gptEntrySectors is the number of sectors of the partition entry array.
+	// gptEntrySectors 是分区条目数组的扇区数。
+	gptEntrySectors = gptEntryCount * gptEntrySize / sectorSize
+)
+
+var (
+	// espTypeGUID is the partition type of an EFI system partition.
+	// espTypeGUID 是 EFI 系统分区的分区类型。
+	espTypeGUID = uuid.MustParse("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+	// linuxFilesystemTypeGUID is the partition type of a Linux file system.
+	// linuxFilesystemTypeGUID 是 Linux 文件系统的分区类型。
+	linuxFilesystemTypeGUID = uuid.MustParse("0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+)
+
+// gptPartition is one entry of a GUID partition table.
+// gptPartition 是 GUID 分区表的一个条目。
+type gptPartition struct {
+	typeGUID uuid.UUID
+	guid     uuid.UUID
+	name     string
+	firstLBA uint64
+	lastLBA  uint64 // Inclusive / 包含
+}
+
+// writeGPT writes a protective MBR and the primary and backup GUID partition tables of a disk of totalSectors sectors.
+// writeGPT 为 totalSectors 个扇区的磁盘写入保护性 MBR 以及主、备 GUID 分区表。
+func writeGPT(w io.WriterAt, totalSectors uint64, diskGUID uuid.UUID, partitions []gptPartition) error {
+	entries := make([]byte, gptEntryCount*gptEntrySize)
+	for i, part := range partitions {
+		entry := entries[i*gptEntrySize : (i+1)*gptEntrySize]
+		copy(entry[0:16], guidBytes(part.typeGUID))
+		copy(entry[16:32], guidBytes(part.guid))
+		binary.LittleEndian.PutUint64(entry[32:], part.firstLBA)
+		binary.LittleEndian.PutUint64(entry[40:], part.lastLBA)
+		for j, c := range utf16.Encode([]rune(part.name)) {
+			if 56+2*j+2 > gptEntrySize {
+				break
+			}
+			binary.LittleEndian.PutUint16(entry[56+2*j:], c)
+		}
+	}
+	entriesCRC := crc32.ChecksumIEEE(entries)
+	lastLBA := totalSectors - 1
+	backupEntriesLBA := lastLBA - gptEntrySectors
+
+	writes := []struct {
+		lba  uint64
+		data []byte
+	}{
+		{0, protectiveMBR(totalSectors)},
+		{1, gptHeader(1, lastLBA, 2, totalSectors, diskGUID, entriesCRC)},
+		{2, entries},
+		{backupEntriesLBA, entries},
+		{lastLBA, gptHeader(lastLBA, 1, backupEntriesLBA, totalSectors, diskGUID, entriesCRC)},
+	}
+	for _, write := range writes {
+		if _, err := w.WriteAt(write.data, int64(write.lba)*sectorSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gptHeader renders the GPT header stored at currentLBA.
+// gptHeader 渲染存储在 currentLBA 处的 GPT 头。
+func gptHeader(currentLBA, backupLBA, entriesLBA, totalSectors uint64, diskGUID uuid.UUID, entriesCRC uint32) []byte {
+	h := make([]byte, sectorSize)
+	copy(h[0:8], "EFI PART")
+	binary.LittleEndian.PutUint32(h[8:], 0x00010000) // Revision 1.0 / 版本 1.0
+	binary.LittleEndian.PutUint32(h[12:], 92)        // Header size / 头大小
+	binary.LittleEndian.PutUint64(h[24:], currentLBA)
+	binary.LittleEndian.PutUint64(h[32:], backupLBA)
+	binary.LittleEndian.PutUint64(h[40:], 2+gptEntrySectors)              // First usable LBA / 第一个可用 LBA
+	binary.LittleEndian.PutUint64(h[48:], totalSectors-2-gptEntrySectors) // Last usable LBA / 最后一个可用 LBA
+	copy(h[56:72], guidBytes(diskGUID))
+	binary.LittleEndian.PutUint64(h[72:], entriesLBA)
+	binary.LittleEndian.PutUint32(h[80:], gptEntryCount)
+	binary.LittleEndian.PutUint32(h[84:], gptEntrySize)
+	binary.LittleEndian.PutUint32(h[88:], entriesCRC)
+	binary.LittleEndian.PutUint32(h[16:], crc32.ChecksumIEEE(h[:92]))
+	return h
+}
+
+// protectiveMBR renders an MBR with a single 0xEE partition covering the disk, so MBR-only tools leave it alone.
+// protectiveMBR 渲染一个仅有覆盖整个磁盘的 0xEE 分区的 MBR，使仅支持 MBR 的工具不会改动磁盘。
+func protectiveMBR(totalSectors uint64) []byte {
+	mbr := make([]byte, sectorSize)
+	entry := mbr[446:462]
+	copy(entry[1:4], []byte{0x00, 0x02, 0x00}) // CHS of LBA 1 / LBA 1 的 CHS
+	entry[4] = 0xEE
+	copy(entry[5:8], []byte{0xFF, 0xFF, 0xFF})
+	binary.LittleEndian.PutUint32(entry[8:], 1)
+	size := totalSectors - 1
+	if size > 0xFFFFFFFF {
+		size = 0xFFFFFFFF
+	}
+	binary.LittleEndian.PutUint32(entry[12:], uint32(size))
+	mbr[510], mbr[511] = 0x55, 0xAA
+	return mbr
+}
+
+// guidBytes encodes a GUID the way GPT stores it: the first three fields are little-endian.
+// guidBytes 按 GPT 的存储方式编码 GUID：前三个字段为小端序。
+func guidBytes(u uuid.UUID) []byte {
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint32(b[0:], binary.BigEndian.Uint32(u[0:4]))
+	binary.LittleEndian.PutUint16(b[4:], binary.BigEndian.Uint16(u[4:6]))
+	binary.LittleEndian.PutUint16(b[6:], binary.BigEndian.Uint16(u[6:8]))
+	copy(b[8:], u[8:])
+	return b
+}
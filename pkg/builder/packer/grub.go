@@ -0,0 +1,172 @@
+package packer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+)
+
+// grubModules are built into the GRUB EFI binary, so it needs no module directory on the EFI system partition;
+// iso9660 lets it read the configuration of the ISO installer.
+// grubModules 被构建进 GRUB EFI 二进制文件，因此 EFI 系统分区上不需要模块目录；iso9660 使其能够读取 ISO 安装程序的配置。
+var grubModules = []string{
+	"part_gpt", "part_msdos", "fat", "ext2", "iso9660", "normal", "linux", "search", "search_fs_uuid", "configfile",
+	"echo", "test", "gzio", "all_video", "efi_gop", "boot", "minicmd",
+}
+
+// biosGrubModules are built into the GRUB El Torito image that boots the ISO installer on BIOS machines.
+// biosGrubModules 被构建进在 BIOS 机器上引导 ISO 安装程序的 GRUB El Torito 镜像。
+var biosGrubModules = []string{
+	"biosdisk", "part_msdos", "part_gpt", "iso9660", "normal", "linux", "search", "search_fs_uuid", "configfile",
+	"echo", "test", "gzio", "all_video", "boot", "minicmd",
+}
+
+// grubPackages names the packages providing the GRUB modules of a platform, on Debian and Ubuntu and on RHEL.
+// grubPackages 列出在 Debian、Ubuntu 和 RHEL 上提供某一平台 GRUB 模块的软件包。
+var grubPackages = map[string]string{
+	"x86_64-efi": "grub-efi-amd64-bin (Debian, Ubuntu) or grub2-efi-x64-modules (RHEL)",
+	"arm64-efi":  "grub-efi-arm64-bin (Debian, Ubuntu) or grub2-efi-aa64-modules (RHEL)",
+	"i386-pc":    "grub-pc-bin (Debian, Ubuntu) or grub2-pc-modules (RHEL)",
+}
+
+// efiTarget is the GRUB platform and the removable-media boot file name of an architecture.
+// efiTarget 是某一架构的 GRUB 平台及可移动介质引导文件名。
+type efiTarget struct {
+	platform string
+	bootFile string
+}
+
+// efiTargets maps GOARCH to the EFI target of the images built for it.
+// efiTargets 将 GOARCH 映射到为其构建的镜像的 EFI 目标。
+var efiTargets = map[string]efiTarget{
+	"amd64": {platform: "x86_64-efi", bootFile: "BOOTX64.EFI"},
+	"arm64": {platform: "arm64-efi", bootFile: "BOOTAA64.EFI"},
+}
+
+// currentEFITarget returns the EFI target of the build machine's architecture, which is the architecture of the image.
+// currentEFITarget 返回构建机器架构的 EFI 目标，即镜像的架构。
+func currentEFITarget() (efiTarget, error) {
+	target, ok := efiTargets[runtime.GOARCH]
+	if !ok {
+		return efiTarget{}, errors.New(errors.ErrTypeNotImplemented, fmt.Sprintf("EFI boot is not supported on %s", runtime.GOARCH))
+	}
+	return target, nil
+}
+
+// buildGrubImage builds a standalone GRUB image of format (e.g. x86_64-efi or i386-pc-eltorito) with the GRUB of the
+// image in rootFS, reading its configuration from prefix on the device it was loaded from. Tests replace it, as it
+// needs the GRUB modules of the platform in the image.
+// buildGrubImage 使用 rootFS 中镜像的 GRUB 构建 format 格式（例如 x86_64-efi 或 i386-pc-eltorito）的独立 GRUB 镜像，
+// 它从加载它的设备上的 prefix 读取配置。测试会替换它，因为它需要镜像中该平台的 GRUB 模块。
+var buildGrubImage = func(ctx context.Context, rootFS, format, prefix string, modules []string) ([]byte, error) {
+	platform := strings.TrimSuffix(format, "-eltorito")
+	cmd := fmt.Sprintf("[ -d /usr/lib/grub/%s ] || exit 126; for tool in grub-mkimage grub2-mkimage; do if [ -x /usr/bin/$tool ]; then exec $tool -O %s -p %s %s; fi; done; exit 127",
+		platform, format, executor.ShellQuote(prefix), strings.Join(modules, " "))
+	result, err := executor.NewChrootExecutor(rootFS).Run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	switch result.ExitCode {
+	case 126:
+		return nil, errors.New(errors.ErrTypeValidation,
+			fmt.Sprintf("the image has no GRUB %s modules; add %s to baseOS.packages", platform, grubPackages[platform]))
+	case 127:
+		return nil, errors.New(errors.ErrTypeValidation,
+			fmt.Sprintf("the image has no grub-mkimage; add %s to baseOS.packages", grubPackages[platform]))
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	return []byte(result.Stdout), nil
+}
+
+// kernelImage is an installed kernel and its initial ramdisk, as paths in the image.
+// kernelImage 是已安装的内核及其初始内存盘，以镜像中的路径表示。
+type kernelImage struct {
+	version string
+	kernel  string
+	initrd  string
+}
+
+// findKernel returns the newest kernel installed in /boot of rootFS that has an initial ramdisk.
+// findKernel 返回 rootFS 的 /boot 中安装的、具有初始内存盘的最新内核。
+func findKernel(rootFS string) (*kernelImage, error) {
+	kernels, err := filepath.Glob(filepath.Join(rootFS, "boot", "vmlinuz-*"))
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, "failed to list the kernels of the image", err)
+	}
+	var found []kernelImage
+	for _, kernel := range kernels {
+		version := strings.TrimPrefix(filepath.Base(kernel), "vmlinuz-")
+		for _, initrd := range []string{"initrd.img-" + version, "initramfs-" + version + ".img"} {
+			if info, err := os.Stat(filepath.Join(rootFS, "boot", initrd)); err == nil && info.Mode().IsRegular() {
+				found = append(found, kernelImage{version: version, kernel: "/boot/vmlinuz-" + version, initrd: "/boot/" + initrd})
+				break
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil, errors.New(errors.ErrTypeValidation,
+			"the image has no kernel with an initial ramdisk in /boot; add a kernel package (e.g. linux-image-generic or kernel) to baseOS.packages")
+	}
+	sort.Slice(found, func(i, j int) bool { return compareKernelVersions(found[i].version, found[j].version) < 0 })
+	return &found[len(found)-1], nil
+}
+
+// compareKernelVersions compares kernel versions such as 6.8.0-45-generic, comparing runs of digits numerically.
+// compareKernelVersions 比较 6.8.0-45-generic 之类的内核版本，数字串按数值比较。
+func compareKernelVersions(a, b string) int {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		switch {
+		case da != "" && db != "":
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) - len(nb)
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[len(da):], b[len(db):]
+		case a[0] != b[0]:
+			return int(a[0]) - int(b[0])
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return len(a) - len(b)
+}
+
+// leadingDigits returns the run of ASCII digits s starts with.
+// leadingDigits 返回 s 开头的 ASCII 数字串。
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// renderGrubConfig renders the GRUB configuration booting kernel from the file system with UUID rootUUID, which is
+// also the root file system of the running system.
+// renderGrubConfig 渲染从 UUID 为 rootUUID 的文件系统引导 kernel 的 GRUB 配置，该文件系统也是运行系统的根文件系统。
+func renderGrubConfig(kernel *kernelImage, rootUUID string, kernelArgs []string) string {
+	cmdline := strings.Join(append([]string{"root=UUID=" + rootUUID, "ro"}, kernelArgs...), " ")
+	return fmt.Sprintf(`# Written by chasi-bod.
+set timeout=3
+set default=0
+search --no-floppy --fs-uuid --set=root %s
+
+menuentry 'chasi-bod (%s)' {
+	linux %s %s
+	initrd %s
+}
+`, rootUUID, kernel.version, kernel.kernel, cmdline, kernel.initrd)
+}
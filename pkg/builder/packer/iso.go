@@ -0,0 +1,589 @@
+package packer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+const (
+	// isoGrubPrefix is the directory of the ISO both GRUB images read grub.cfg from.
+	// isoGrubPrefix 是两个 GRUB 镜像读取 grub.cfg 的 ISO 目录。
+	isoGrubPrefix = "/boot/grub"
+	// eltoritoLoadSectors is the part of the BIOS GRUB image the firmware loads, its El Torito boot sector; the image
+	// loads the rest itself.
+	// eltoritoLoadSectors 是固件加载的 BIOS GRUB 镜像部分，即其 El Torito 引导扇区；镜像自行加载其余部分。
+	eltoritoLoadSectors = 4
+	// installParam is the kernel parameter naming the disk the installer writes the platform to.
+	// installParam 是指定安装程序写入平台的磁盘的内核参数。
+	installParam = "chasi-bod.install"
+	// hybridMBRPath is the MBR code of GRUB that boots the El Torito image of an ISO written to a USB stick.
+	// hybridMBRPath 是 GRUB 的 MBR 代码，用于引导写入 U 盘的 ISO 的 El Torito 镜像。
+	hybridMBRPath = "usr/lib/grub/i386-pc/boot_hybrid.img"
+)
+
+// installerTools are run by the installer from the platform image, with the packages providing them.
+// installerTools 由安装程序从平台镜像中运行，并附有提供它们的软件包。
+var installerTools = []struct{ name, packages string }{
+	{"sfdisk", "fdisk (Debian, Ubuntu) or util-linux (RHEL)"},
+	{"mkfs.ext4", "e2fsprogs"},
+	{"gzip", "gzip"},
+}
+
+// installerModules are loaded by the installer's init before it looks for the installer medium, in case the
+// initramfs of the image does not load them; those built into the kernel are skipped.
+// installerModules 在安装程序的 init 查找安装介质之前加载，以防镜像的 initramfs 不加载它们；内置于内核的模块被跳过。
+var installerModules = []string{
+	"loop", "squashfs", "isofs", "cdrom", "sr_mod", "sd_mod", "usb_storage", "uas", "xhci_pci", "ehci_pci",
+	"ahci", "nvme", "virtio_pci", "virtio_blk", "virtio_scsi",
+}
+
+// isoPacker packages the root filesystem as a hybrid ISO installer booting with BIOS and UEFI from optical media and
+// USB sticks. It holds the platform as a squashfs image and an installer that writes it to the disk named by the
+// chasi-bod.install kernel parameter, as the disk image packers lay it out. The installer's init runs from the
+// initramfs of the image, which needs a shell, mount with loop device support and switch_root: dracut and Ubuntu
+// images have them, Debian images need busybox.
+// isoPacker 将根文件系统打包为可从光盘和 U 盘以 BIOS 和 UEFI 方式引导的混合 ISO 安装程序。它包含 squashfs 形式的平台和一个安装程序，
+// 后者按磁盘镜像打包器的布局将平台写入 chasi-bod.install 内核参数指定的磁盘。安装程序的 init 运行于镜像的 initramfs 中，
+// 它需要 shell、支持环回设备的 mount 和 switch_root：dracut 和 Ubuntu 镜像具备这些，Debian 镜像需要 busybox。
+type isoPacker struct {
+	kernelArgs []string
+}
+
+// isoBuild is the state shared by the steps of an ISO build.
+// isoBuild 是 ISO 构建各步骤共享的状态。
+type isoBuild struct {
+	rootFS      string
+	tmpDir      string
+	kernel      *kernelImage
+	target      efiTarget
+	bios        bool
+	created     time.Time
+	buildID     string
+	rootUUID    string
+	espVolumeID uint32
+	grubEFI     []byte
+	kernelArgs  []string
+}
+
+// Package builds the ISO installer of rootFS in config.OutputDir and returns its path.
+// Package 在 config.OutputDir 中构建 rootFS 的 ISO 安装程序并返回其路径。
+func (p *isoPacker) Package(ctx context.Context, rootFS string, config *model.OutputConfig) (string, error) {
+	b := &isoBuild{
+		rootFS:     rootFS,
+		bios:       runtime.GOARCH == "amd64",
+		buildID:    uuid.New().String(),
+		rootUUID:   uuid.New().String(),
+		kernelArgs: p.kernelArgs,
+	}
+	var err error
+	if b.kernel, err = findKernel(rootFS); err != nil {
+		return "", err
+	}
+	if b.target, err = currentEFITarget(); err != nil {
+		return "", err
+	}
+	if err := checkInstallerTools(rootFS); err != nil {
+		return "", err
+	}
+	var hybridMBR []byte
+	if b.bios {
+		if hybridMBR, err = os.ReadFile(filepath.Join(rootFS, hybridMBRPath)); err != nil || len(hybridMBR) < sectorSize {
+			return "", errors.New(errors.ErrTypeValidation,
+				fmt.Sprintf("the image has no GRUB hybrid MBR /%s; add %s to baseOS.packages", hybridMBRPath, grubPackages["i386-pc"]))
+		}
+	}
+	if b.espVolumeID, err = randomVolumeID(); err != nil {
+		return "", err
+	}
+	if err := writeFstab(rootFS, b.rootUUID, b.espVolumeID); err != nil {
+		return "", err
+	}
+	if b.grubEFI, err = buildGrubImage(ctx, rootFS, b.target.platform, grubPrefix, grubModules); err != nil {
+		return "", err
+	}
+	var grubBIOS []byte
+	if b.bios {
+		if grubBIOS, err = buildGrubImage(ctx, rootFS, "i386-pc-eltorito", isoGrubPrefix, biosGrubModules); err != nil {
+			return "", err
+		}
+	}
+	// The volume dates are the ISO's UUID, which has hundredths of a second.
+	// 卷日期即 ISO 的 UUID，精确到百分之一秒。
+	b.created = time.Now().UTC().Truncate(10 * time.Millisecond)
+	utils.GetLogger().Printf("Packaging %s into an ISO installer booting kernel %s", rootFS, b.kernel.version)
+
+	if b.tmpDir, err = os.MkdirTemp(config.OutputDir, "."+config.ImageName+"-*"); err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create a temporary directory in %s", config.OutputDir), err)
+	}
+	defer os.RemoveAll(b.tmpDir)
+	img, err := b.contents(ctx, b.grubConfig(config.InstallDisk), grubBIOS)
+	if err != nil {
+		return "", err
+	}
+	if err := img.layout(); err != nil {
+		return "", err
+	}
+	var systemArea []byte
+	if b.bios {
+		biosImage := img.boot[0].file
+		patchBootInfo(biosImage.data, biosImage.lba)
+		systemArea = hybridMBRSector(hybridMBR, biosImage.lba, img.boot[1].file, img.totalSectors)
+	}
+
+	tmpPath := filepath.Join(b.tmpDir, "image.iso")
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create %s", tmpPath), err)
+	}
+	if err := img.write(out, systemArea); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", tmpPath), err)
+	}
+	outputPath := filepath.Join(config.OutputDir, config.ImageName+".iso")
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to move the ISO image to %s", outputPath), err)
+	}
+	utils.GetLogger().Printf("ISO image written to %s", outputPath)
+	return outputPath, nil
+}
+
+// contents writes the large files of the ISO to the temporary directory and returns the image holding them:
+//
+//	/BOOT/GRUB/GRUB.CFG     the boot menu / 引导菜单
+//	/BOOT/BIOS.IMG          GRUB El Torito image for BIOS machines / 用于 BIOS 机器的 GRUB El Torito 镜像
+//	/BOOT/EFI.IMG           FAT image with GRUB for UEFI machines / 用于 UEFI 机器的包含 GRUB 的 FAT 镜像
+//	/LIVE/VMLINUZ           kernel and initramfs of the image / 镜像的内核和 initramfs
+//	/LIVE/INITRD.IMG
+//	/LIVE/INSTALL.IMG       initramfs overlay with the installer / 包含安装程序的 initramfs 叠加层
+//	/LIVE/ROOTFS.SFS        the platform / 平台
+//	/LIVE/ESP.GZ            the EFI system partition of the installed disk / 已安装磁盘的 EFI 系统分区
+//	/LIVE/BUILD.ID          identifies the medium to the installer / 向安装程序标识介质
+//
+// contents 将 ISO 的大文件写入临时目录，并返回包含它们的镜像（见上）。
+func (b *isoBuild) contents(ctx context.Context, grubCfg string, grubBIOS []byte) (*isoImage, error) {
+	img := &isoImage{volumeID: "CHASI_BOD", created: b.created}
+	if b.bios {
+		img.boot = append(img.boot, isoBootEntry{platform: eltoritoBIOS, file: img.add(&isoFile{path: "BOOT/BIOS.IMG", data: grubBIOS}), sectors: eltoritoLoadSectors})
+	}
+	efiImage, err := b.efiImage()
+	if err != nil {
+		return nil, err
+	}
+	img.boot = append(img.boot, isoBootEntry{platform: eltoritoEFI, file: img.add(efiImage), sectors: uint16(min(efiImage.size/sectorSize, 0xFFFF))})
+	img.add(&isoFile{path: "BOOT/GRUB/GRUB.CFG", data: []byte(grubCfg)})
+
+	for path, source := range map[string]string{"LIVE/VMLINUZ": b.kernel.kernel, "LIVE/INITRD.IMG": b.kernel.initrd} {
+		info, err := os.Stat(filepath.Join(b.rootFS, source))
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to inspect %s of the image", source), err)
+		}
+		img.add(&isoFile{path: path, source: filepath.Join(b.rootFS, source), size: info.Size()})
+	}
+	installImage, err := b.installImage()
+	if err != nil {
+		return nil, err
+	}
+	img.add(&isoFile{path: "LIVE/INSTALL.IMG", data: installImage})
+	esp, err := b.espImage()
+	if err != nil {
+		return nil, err
+	}
+	img.add(esp)
+	img.add(&isoFile{path: "LIVE/BUILD.ID", data: []byte(b.buildID + "\n")})
+
+	squashfsPath := filepath.Join(b.tmpDir, "rootfs.sfs")
+	utils.GetLogger().Printf("Compressing %s into a squashfs image", b.rootFS)
+	if err := writeSquashfs(ctx, b.rootFS, squashfsPath, b.created); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(squashfsPath)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to inspect %s", squashfsPath), err)
+	}
+	img.add(&isoFile{path: "LIVE/ROOTFS.SFS", source: squashfsPath, size: info.Size()})
+	return img, nil
+}
+
+// grubConfig renders the GRUB configuration of the ISO, booting the installer with the target disk when one is
+// configured.
+// grubConfig 渲染 ISO 的 GRUB 配置；配置了目标磁盘时，以该磁盘引导安装程序。
+func (b *isoBuild) grubConfig(installDisk string) string {
+	args := []string{"rdinit=/chasi-bod/init"}
+	title := fmt.Sprintf("Install chasi-bod (%s)", b.kernel.version)
+	if installDisk != "" {
+		args = append(args, installParam+"="+installDisk)
+		title += " to " + installDisk
+	}
+	return fmt.Sprintf(`# Written by chasi-bod.
+set timeout=10
+set default=0
+search --no-floppy --fs-uuid --set=root %s
+
+menuentry '%s' {
+	linux /live/vmlinuz %s
+	initrd /live/initrd.img /live/install.img
+}
+`, isoUUID(b.created), title, strings.Join(append(args, b.kernelArgs...), " "))
+}
+
+// efiImage writes the FAT image UEFI firmware boots the ISO from; its GRUB finds the ISO and reads its grub.cfg.
+// efiImage 写入 UEFI 固件用于引导 ISO 的 FAT 镜像；其中的 GRUB 找到 ISO 并读取其 grub.cfg。
+func (b *isoBuild) efiImage() (*isoFile, error) {
+	grubCfg := fmt.Sprintf(`# Written by chasi-bod.
+search --no-floppy --fs-uuid --set=root %s
+set prefix=($root)%s
+configfile %s/grub.cfg
+`, isoUUID(b.created), isoGrubPrefix, isoGrubPrefix)
+	dir := strings.TrimPrefix(grubPrefix, "/")
+	files := []fatFile{{path: dir + "/" + b.target.bootFile, data: b.grubEFI}, {path: dir + "/GRUB.CFG", data: []byte(grubCfg)}}
+	// One MiB covers the file system structures; the image is at least large enough for FAT16.
+	// 1 MiB 足以容纳文件系统结构；镜像至少大到足以使用 FAT16。
+	size := max((int64(len(b.grubEFI)+len(grubCfg))+2*mib-1)/mib*mib, 4*mib)
+	path := filepath.Join(b.tmpDir, "efi.img")
+	if err := writeFATImage(path, size, 0, b.espVolumeID, "EFI", b.created, files); err != nil {
+		return nil, err
+	}
+	return &isoFile{path: "BOOT/EFI.IMG", source: path, size: size}, nil
+}
+
+// espImage writes the gzip-compressed EFI system partition the installer writes to the target disk.
+// espImage 写入经 gzip 压缩的 EFI 系统分区，安装程序将其写入目标磁盘。
+func (b *isoBuild) espImage() (*isoFile, error) {
+	rawPath := filepath.Join(b.tmpDir, "esp.raw")
+	files := espFiles(b.kernel, b.target, b.grubEFI, b.rootUUID, b.kernelArgs)
+	if err := writeFATImage(rawPath, espSize, mib/sectorSize, b.espVolumeID, "EFI", b.created, files); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(b.tmpDir, "esp.gz")
+	if err := gzipFile(rawPath, path); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to inspect %s", path), err)
+	}
+	return &isoFile{path: "LIVE/ESP.GZ", source: path, size: info.Size()}, nil
+}
+
+// writeFATImage writes a FAT file system image of size bytes holding files at path.
+// writeFATImage 在 path 处写入大小为 size 字节、包含 files 的 FAT 文件系统镜像。
+func writeFATImage(path string, size int64, hiddenSectors, volumeID uint32, label string, modTime time.Time, files []fatFile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create %s", path), err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", path), err)
+	}
+	if err := writeFAT(f, 0, size, hiddenSectors, volumeID, label, modTime, files); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", path), err)
+	}
+	return nil
+}
+
+// gzipFile compresses the file at source into path.
+// gzipFile 将 source 处的文件压缩到 path。
+func gzipFile(source, path string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to open %s", source), err)
+	}
+	defer in.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create %s", path), err)
+	}
+	defer out.Close()
+	zw := gzip.NewWriter(out)
+	if _, err := bufio.NewReader(in).WriteTo(zw); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to compress %s", source), err)
+	}
+	if err := zw.Close(); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", path), err)
+	}
+	if err := out.Close(); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", path), err)
+	}
+	return nil
+}
+
+// installImage returns the initramfs overlay holding the init and installer scripts and the kernel modules they
+// need.
+// installImage 返回包含 init 和安装程序脚本及其所需内核模块的 initramfs 叠加层。
+func (b *isoBuild) installImage() ([]byte, error) {
+	modules, err := resolveModules(b.rootFS, b.kernel.version, installerModules)
+	if err != nil {
+		return nil, err
+	}
+	entries := []cpioEntry{
+		{name: "chasi-bod", mode: 0040755},
+		{name: "chasi-bod/modules", mode: 0040755},
+		{name: "chasi-bod/root", mode: 0040755},
+	}
+	var names []string
+	for _, module := range modules {
+		data, err := os.ReadFile(filepath.Join(b.rootFS, "lib", "modules", b.kernel.version, module))
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read the kernel module %s of the image", module), err)
+		}
+		names = append(names, filepath.Base(module))
+		entries = append(entries, cpioEntry{name: "chasi-bod/modules/" + filepath.Base(module), mode: 0100644, data: data})
+	}
+	entries = append(entries,
+		cpioEntry{name: "chasi-bod/init", mode: 0100755, data: []byte(fmt.Sprintf(initScript, b.buildID, strings.Join(names, " ")))},
+		cpioEntry{name: "chasi-bod/install", mode: 0100755, data: []byte(fmt.Sprintf(installScript, installParam, b.rootUUID,
+			mib/sectorSize, espSize/sectorSize, guidString(espTypeGUID), guidString(linuxFilesystemTypeGUID)))},
+	)
+	return writeCPIO(entries, b.created), nil
+}
+
+// guidString formats a partition type GUID the way sfdisk reads it.
+// guidString 按 sfdisk 读取的格式格式化分区类型 GUID。
+func guidString(u uuid.UUID) string {
+	return strings.ToUpper(u.String())
+}
+
+// resolveModules returns the files of modules and of the modules they depend on, relative to the module directory of
+// the kernel and in load order, according to its modules.dep. Modules built into the kernel or missing are skipped.
+// resolveModules 根据 modules.dep 返回 modules 及其依赖模块的文件，路径相对于内核的模块目录并按加载顺序排列。
+// 内置于内核或缺失的模块被跳过。
+func resolveModules(rootFS, version string, modules []string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(rootFS, "lib", "modules", version, "modules.dep"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, "failed to read modules.dep of the image", err)
+	}
+	deps := map[string][]string{}
+	byName := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		module, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		deps[module] = strings.Fields(rest)
+		name, _, _ := strings.Cut(filepath.Base(module), ".")
+		byName[strings.ReplaceAll(name, "-", "_")] = module
+	}
+	var order []string
+	seen := map[string]bool{}
+	var visit func(module string)
+	visit = func(module string) {
+		if seen[module] {
+			return
+		}
+		seen[module] = true
+		// modules.dep lists the dependencies a module needs loaded first last.
+		// modules.dep 将需要最先加载的依赖列在最后。
+		for i := len(deps[module]) - 1; i >= 0; i-- {
+			visit(deps[module][i])
+		}
+		order = append(order, module)
+	}
+	for _, name := range modules {
+		if module, ok := byName[name]; ok {
+			visit(module)
+		}
+	}
+	return order, nil
+}
+
+// checkInstallerTools checks that the image has the tools the installer runs.
+// checkInstallerTools 检查镜像是否具有安装程序运行的工具。
+func checkInstallerTools(rootFS string) error {
+	for _, tool := range installerTools {
+		found := false
+		for _, dir := range []string{"usr/sbin", "usr/bin", "sbin", "bin"} {
+			if _, err := os.Lstat(filepath.Join(rootFS, dir, tool.name)); err == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New(errors.ErrTypeValidation,
+				fmt.Sprintf("the image has no %s, which the ISO installer runs; add %s to baseOS.packages", tool.name, tool.packages))
+		}
+	}
+	return nil
+}
+
+// patchBootInfo fills the boot info table of a BIOS GRUB El Torito image at lba, which tells it where the rest of it
+// is, and the location of its second stage when it is booted from the hybrid MBR, the 512-byte sector after its El
+// Torito boot sector.
+// patchBootInfo 填写位于 lba 的 BIOS GRUB El Torito 镜像的引导信息表（告知其余部分的位置），以及从混合 MBR 引导时其第二阶段的位置，
+// 即其 El Torito 引导扇区之后的 512 字节扇区。
+func patchBootInfo(image []byte, lba uint32) {
+	le := binary.LittleEndian
+	le.PutUint32(image[8:], isoPVDSector)
+	le.PutUint32(image[12:], lba)
+	le.PutUint32(image[16:], uint32(len(image)))
+	var sum uint32
+	for i := 64; i+4 <= len(image); i += 4 {
+		sum += le.Uint32(image[i:])
+	}
+	le.PutUint32(image[20:], sum)
+	if len(image) >= 2556 {
+		le.PutUint64(image[2548:], uint64(lba)*4+eltoritoLoadSectors+1)
+	}
+}
+
+// hybridMBRSector renders the MBR that makes the ISO bootable from a USB stick: GRUB's hybrid MBR code loading the
+// El Torito image at biosLBA, a bootable partition covering the ISO and an EFI system partition covering efiImage.
+// hybridMBRSector 渲染使 ISO 可从 U 盘引导的 MBR：加载位于 biosLBA 的 El Torito 镜像的 GRUB 混合 MBR 代码、
+// 覆盖整个 ISO 的可引导分区以及覆盖 efiImage 的 EFI 系统分区。
+func hybridMBRSector(code []byte, biosLBA uint32, efiImage *isoFile, totalSectors uint32) []byte {
+	const codeSize = 0x1B0
+	mbr := make([]byte, sectorSize)
+	copy(mbr, code[:codeSize])
+	binary.LittleEndian.PutUint64(mbr[codeSize:], uint64(biosLBA)*4+eltoritoLoadSectors)
+	partition := func(entry []byte, boot, typ byte, start, size uint32) {
+		entry[0], entry[4] = boot, typ
+		copy(entry[1:4], []byte{0xFE, 0xFF, 0xFF}) // CHS beyond its range / 超出范围的 CHS
+		copy(entry[5:8], []byte{0xFE, 0xFF, 0xFF})
+		binary.LittleEndian.PutUint32(entry[8:], start)
+		binary.LittleEndian.PutUint32(entry[12:], size)
+	}
+	partition(mbr[446:462], 0x80, 0x00, 0, totalSectors*isoSectorSize/sectorSize)
+	partition(mbr[462:478], 0x00, 0xEF, efiImage.lba*isoSectorSize/sectorSize, uint32(efiImage.size/sectorSize))
+	mbr[510], mbr[511] = 0x55, 0xAA
+	return mbr
+}
+
+// initScript is the init of the installer's initramfs. It finds the medium with the build ID, mounts the platform
+// from it and runs the installer in it, with the medium under /run/chasi-bod/iso.
+// initScript 是安装程序 initramfs 的 init。它找到具有该构建 ID 的介质，从中挂载平台并在其中运行安装程序，介质位于 /run/chasi-bod/iso。
+const initScript = `#!/bin/sh
+# Written by chasi-bod.
+id=%s
+modules="%s"
+export PATH=/usr/sbin:/usr/bin:/sbin:/bin
+
+fail() {
+	echo "chasi-bod: $*" >&2
+	while :; do /bin/sh; done
+}
+
+# The mount of busybox supports loop devices where the one of klibc does not.
+mnt() {
+	if command -v busybox >/dev/null 2>&1; then busybox mount "$@"; else mount "$@"; fi
+}
+
+mkdir -p /proc /sys /dev /run
+mount -t proc proc /proc
+mount -t sysfs sysfs /sys
+mount -t devtmpfs devtmpfs /dev
+mount -t tmpfs -o mode=0755 tmpfs /run
+for module in $modules; do
+	insmod /chasi-bod/modules/$module 2>/dev/null
+done
+
+mkdir -p /run/chasi-bod/iso
+tries=0
+while :; do
+	for dev in /sys/class/block/*; do
+		dev=/dev/${dev##*/}
+		mnt -t iso9660 -o ro "$dev" /run/chasi-bod/iso 2>/dev/null || continue
+		if [ "$(cat /run/chasi-bod/iso/live/build.id 2>/dev/null)" = "$id" ]; then
+			echo "$dev" >/run/chasi-bod/source
+			break 2
+		fi
+		umount /run/chasi-bod/iso
+	done
+	tries=$((tries + 1))
+	[ $tries -lt 60 ] || fail "the installer medium was not found"
+	sleep 1
+done
+
+mnt -t squashfs -o loop,ro /run/chasi-bod/iso/live/rootfs.sfs /chasi-bod/root || fail "failed to mount the platform"
+cp /chasi-bod/install /run/chasi-bod/install
+for dir in dev proc sys run; do
+	mnt -o move /$dir /chasi-bod/root/$dir || fail "failed to move /$dir"
+done
+for tool in switch_root run-init; do
+	command -v $tool >/dev/null 2>&1 && exec $tool /chasi-bod/root /bin/sh /run/chasi-bod/install
+done
+command -v busybox >/dev/null 2>&1 && exec busybox switch_root /chasi-bod/root /bin/sh /run/chasi-bod/install
+fail "the initramfs has no switch_root"
+`
+
+// installScript installs the platform on the disk named by the kernel parameter: an EFI system partition written
+// from the medium and an ext4 root partition with the content of the platform, with the UUIDs its fstab and GRUB
+// configuration expect. It powers the machine off once done, so it does not boot the installer again.
+// installScript 将平台安装到内核参数指定的磁盘：从介质写入的 EFI 系统分区，以及包含平台内容的 ext4 根分区，
+// 其 UUID 与平台的 fstab 和 GRUB 配置一致。完成后关闭机器电源，使其不会再次引导安装程序。
+const installScript = `#!/bin/sh
+# Written by chasi-bod.
+param=%s
+root_uuid=%s
+
+fail() {
+	echo "chasi-bod: $*" >&2
+	echo "chasi-bod: the installation failed" >&2
+	while :; do /bin/sh; done
+}
+
+wait_block() {
+	tries=0
+	while [ ! -b "$1" ]; do
+		tries=$((tries + 1))
+		[ $tries -lt 30 ] || fail "$1 is not a block device"
+		sleep 1
+	done
+}
+
+disk=
+for arg in $(cat /proc/cmdline); do
+	case $arg in
+	$param=*) disk=${arg#$param=} ;;
+	esac
+done
+[ -n "$disk" ] || fail "no target disk; add $param=/dev/<disk> to the kernel command line"
+wait_block "$disk"
+case $(cat /run/chasi-bod/source) in
+"$disk" | "$disk"[0-9]* | "$disk"p[0-9]*) fail "$disk holds the installer" ;;
+esac
+[ -d /sys/firmware/efi ] || echo "chasi-bod: warning: booted with BIOS, the installed disk boots with UEFI only" >&2
+
+echo "chasi-bod: installing the platform to $disk"
+sfdisk --quiet --wipe always --wipe-partitions always "$disk" <<EOF || fail "failed to partition $disk"
+label: gpt
+start=%d, size=%d, type=%s, name="EFI System Partition"
+type=%s, name=root
+EOF
+case $disk in
+*[0-9]) part=${disk}p ;;
+*) part=$disk ;;
+esac
+wait_block "${part}1"
+wait_block "${part}2"
+gzip -dc /run/chasi-bod/iso/live/esp.gz >"${part}1" || fail "failed to write the EFI system partition"
+mkfs.ext4 -q -F -L root -U "$root_uuid" "${part}2" || fail "failed to create the root file system"
+mkdir -p /run/chasi-bod/target
+mount "${part}2" /run/chasi-bod/target || fail "failed to mount the root file system"
+cp -ax /. /run/chasi-bod/target/ || fail "failed to copy the platform"
+umount /run/chasi-bod/target || fail "failed to unmount the root file system"
+sync
+echo "chasi-bod: the platform is installed; powering off"
+echo o >/proc/sysrq-trigger
+sleep 10
+poweroff -f
+`
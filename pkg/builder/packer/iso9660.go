@@ -0,0 +1,432 @@
+package packer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+const (
+	isoSectorSize = 2048
+	// isoSystemAreaSectors precede the volume descriptors; hybrid images put their MBR there.
+	// isoSystemAreaSectors 位于卷描述符之前；混合镜像将其 MBR 放在这里。
+	isoSystemAreaSectors = 16
+	// isoMaxExtent is the largest extent of a directory record; larger files are split across several records.
+	// isoMaxExtent 是目录记录的最大数据区；更大的文件被拆分为多个记录。
+	isoMaxExtent = 0xFFFFF800
+	// isoPVDSector is the sector of the primary volume descriptor, which El Torito boot info tables point to.
+	// isoPVDSector 是主卷描述符所在扇区，El Torito 引导信息表指向它。
+	isoPVDSector       = isoSystemAreaSectors
+	isoFlagDirectory   = 0x02
+	isoFlagMultiExtent = 0x80
+	// El Torito platform IDs / El Torito 平台 ID
+	eltoritoBIOS = 0x00
+	eltoritoEFI  = 0xEF
+)
+
+// isoFile is a file of an ISO 9660 image, at a slash-separated path of uppercase 8.3 names. Its content is data, or
+// the host file source of size bytes.
+// isoFile 是 ISO 9660 镜像中的文件，其路径由斜杠分隔的大写 8.3 名称组成。其内容为 data，或大小为 size 字节的主机文件 source。
+type isoFile struct {
+	path   string
+	data   []byte
+	source string
+	size   int64
+	lba    uint32
+}
+
+// isoBootEntry is an El Torito no-emulation boot image, loading sectors 512-byte sectors of file.
+// isoBootEntry 是一个 El Torito 无仿真引导镜像，加载 file 的 sectors 个 512 字节扇区。
+type isoBootEntry struct {
+	platform byte
+	file     *isoFile
+	sectors  uint16
+}
+
+// isoDir is a directory of the image being written.
+// isoDir 是正在写入的镜像中的目录。
+type isoDir struct {
+	name   string
+	parent *isoDir
+	number uint16 // Position in the path table / 在路径表中的位置
+	dirs   []*isoDir
+	files  []*isoFile
+	lba    uint32
+	size   uint32
+}
+
+// isoImage lays out and writes an ISO 9660 image with El Torito boot images, without Rock Ridge or Joliet
+// extensions: the names are the uppercase 8.3 names Linux and GRUB show in lowercase.
+// isoImage 布局并写入带有 El Torito 引导镜像的 ISO 9660 镜像，不含 Rock Ridge 或 Joliet 扩展：名称为大写 8.3 名称，
+// Linux 和 GRUB 以小写显示它们。
+type isoImage struct {
+	volumeID string
+	created  time.Time
+	files    []*isoFile
+	boot     []isoBootEntry
+
+	dirs          []*isoDir // Breadth first, the path table order / 广度优先，即路径表顺序
+	catalogLBA    uint32
+	pathTableSize uint32
+	pathTableLBA  [2]uint32 // Little- and big-endian tables / 小端序和大端序路径表
+	totalSectors  uint32
+}
+
+// add adds a file to the image and returns it.
+// add 将文件添加到镜像并返回该文件。
+func (img *isoImage) add(f *isoFile) *isoFile {
+	if f.data != nil {
+		f.size = int64(len(f.data))
+	}
+	img.files = append(img.files, f)
+	return f
+}
+
+// layout builds the directory tree and assigns the sectors of the descriptors, tables, directories and files.
+// layout 构建目录树并分配描述符、表、目录和文件的扇区。
+func (img *isoImage) layout() error {
+	root := &isoDir{}
+	dirs := map[string]*isoDir{"": root}
+	for _, f := range img.files {
+		parts := strings.Split(f.path, "/")
+		for _, part := range parts {
+			if !isoValidName(part) {
+				return errors.New(errors.ErrTypeInternal, fmt.Sprintf("%q is not a valid ISO 9660 path", f.path))
+			}
+		}
+		parent := root
+		for i := range parts[:len(parts)-1] {
+			dirPath := strings.Join(parts[:i+1], "/")
+			dir, ok := dirs[dirPath]
+			if !ok {
+				dir = &isoDir{name: parts[i], parent: parent}
+				dirs[dirPath] = dir
+				parent.dirs = append(parent.dirs, dir)
+			}
+			parent = dir
+		}
+		parent.files = append(parent.files, f)
+	}
+
+	img.dirs = []*isoDir{root}
+	for i := 0; i < len(img.dirs); i++ {
+		dir := img.dirs[i]
+		dir.number = uint16(i + 1)
+		sort.Slice(dir.dirs, func(a, b int) bool { return dir.dirs[a].name < dir.dirs[b].name })
+		sort.Slice(dir.files, func(a, b int) bool { return isoFileName(dir.files[a]) < isoFileName(dir.files[b]) })
+		img.dirs = append(img.dirs, dir.dirs...)
+	}
+
+	next := uint32(isoPVDSector + 1)
+	if len(img.boot) > 0 {
+		next++ // The El Torito boot record / El Torito 引导记录
+	}
+	next++ // The terminator / 终止符
+	if len(img.boot) > 0 {
+		img.catalogLBA = next
+		next++
+	}
+	for _, dir := range img.dirs {
+		img.pathTableSize += uint32(8 + len(dir.name) + len(dir.name)%2)
+		if dir == root {
+			img.pathTableSize += 2 // The name of the root is one byte and padding / 根的名称为一个字节加填充
+		}
+	}
+	for i := range img.pathTableLBA {
+		img.pathTableLBA[i] = next
+		next += sectorsFor(int64(img.pathTableSize))
+	}
+	for _, dir := range img.dirs {
+		dir.lba = next
+		dir.size = uint32(len(img.directory(dir)))
+		next += dir.size / isoSectorSize
+	}
+	for _, f := range img.files {
+		f.lba = next
+		next += sectorsFor(f.size)
+	}
+	img.totalSectors = next
+	return nil
+}
+
+// sectorsFor returns the number of sectors holding size bytes.
+// sectorsFor 返回容纳 size 字节所需的扇区数。
+func sectorsFor(size int64) uint32 {
+	return uint32((size + isoSectorSize - 1) / isoSectorSize)
+}
+
+// isoValidName reports whether name is an uppercase 8.3 name of d-characters.
+// isoValidName 报告 name 是否为由 d 字符组成的大写 8.3 名称。
+func isoValidName(name string) bool {
+	base, ext, _ := strings.Cut(name, ".")
+	if base == "" || len(base) > 8 || len(ext) > 3 || strings.Contains(ext, ".") {
+		return false
+	}
+	for _, c := range base + ext {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// isoFileName returns the file identifier of f: its name, a dot when it has no extension, and version 1.
+// isoFileName 返回 f 的文件标识符：其名称、无扩展名时的点号以及版本号 1。
+func isoFileName(f *isoFile) string {
+	name := f.path[strings.LastIndex(f.path, "/")+1:]
+	if !strings.Contains(name, ".") {
+		name += "."
+	}
+	return name + ";1"
+}
+
+// directory renders the extent of dir, padded to whole sectors; records do not cross sector boundaries.
+// directory 渲染 dir 的数据区并填充到整扇区；记录不跨越扇区边界。
+func (img *isoImage) directory(dir *isoDir) []byte {
+	var out []byte
+	add := func(record []byte) {
+		if len(out)%isoSectorSize+len(record) > isoSectorSize {
+			out = append(out, make([]byte, isoSectorSize-len(out)%isoSectorSize)...)
+		}
+		out = append(out, record...)
+	}
+	parent := dir.parent
+	if parent == nil {
+		parent = dir
+	}
+	add(isoDirRecord("\x00", dir.lba, dir.size, isoFlagDirectory, img.created))
+	add(isoDirRecord("\x01", parent.lba, parent.size, isoFlagDirectory, img.created))
+	for _, child := range dir.dirs {
+		add(isoDirRecord(child.name, child.lba, child.size, isoFlagDirectory, img.created))
+	}
+	for _, f := range dir.files {
+		lba, remaining := f.lba, f.size
+		for {
+			extent, flags := remaining, byte(0)
+			if extent > isoMaxExtent {
+				extent, flags = isoMaxExtent, isoFlagMultiExtent
+			}
+			add(isoDirRecord(isoFileName(f), lba, uint32(extent), flags, img.created))
+			if flags == 0 {
+				break
+			}
+			lba += isoMaxExtent / isoSectorSize
+			remaining -= extent
+		}
+	}
+	return append(out, make([]byte, int(sectorsFor(int64(len(out))))*isoSectorSize-len(out))...)
+}
+
+// isoDirRecord renders a directory record.
+// isoDirRecord 渲染一条目录记录。
+func isoDirRecord(name string, lba, size uint32, flags byte, t time.Time) []byte {
+	length := 33 + len(name)
+	length += length % 2
+	r := make([]byte, length)
+	r[0] = byte(length)
+	putBoth32(r[2:], lba)
+	putBoth32(r[10:], size)
+	t = t.UTC()
+	copy(r[18:25], []byte{byte(t.Year() - 1900), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()), byte(t.Second()), 0})
+	r[25] = flags
+	putBoth16(r[28:], 1) // Volume sequence number / 卷序号
+	r[32] = byte(len(name))
+	copy(r[33:], name)
+	return r
+}
+
+// putBoth16 and putBoth32 write a number in both byte orders, little-endian first, as ISO 9660 stores most numbers.
+// putBoth16 和 putBoth32 以两种字节序写入数字（小端序在前），ISO 9660 以这种方式存储大多数数字。
+func putBoth16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func putBoth32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// isoDate renders a volume descriptor date in UTC, with hundredths of a second.
+// isoDate 以 UTC 渲染卷描述符日期，精确到百分之一秒。
+func isoDate(t time.Time) []byte {
+	t = t.UTC()
+	return append([]byte(fmt.Sprintf("%04d%02d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1e7)), 0)
+}
+
+// isoUUID returns the UUID GRUB and blkid derive from the modification date of the volume.
+// isoUUID 返回 GRUB 和 blkid 根据卷的修改日期派生的 UUID。
+func isoUUID(t time.Time) string {
+	d := isoDate(t)
+	return fmt.Sprintf("%s-%s-%s-%s-%s-%s-%s", d[0:4], d[4:6], d[6:8], d[8:10], d[10:12], d[12:14], d[14:16])
+}
+
+// primaryVolumeDescriptor renders the primary volume descriptor.
+// primaryVolumeDescriptor 渲染主卷描述符。
+func (img *isoImage) primaryVolumeDescriptor() []byte {
+	d := make([]byte, isoSectorSize)
+	d[0] = 1
+	copy(d[1:], "CD001\x01")
+	copy(d[8:72], fmt.Sprintf("%-32s%-32s", "LINUX", img.volumeID))
+	putBoth32(d[80:], img.totalSectors)
+	putBoth16(d[120:], 1) // Volume set size / 卷集大小
+	putBoth16(d[124:], 1) // Volume sequence number / 卷序号
+	putBoth16(d[128:], isoSectorSize)
+	putBoth32(d[132:], img.pathTableSize)
+	binary.LittleEndian.PutUint32(d[140:], img.pathTableLBA[0])
+	binary.BigEndian.PutUint32(d[148:], img.pathTableLBA[1])
+	root := img.dirs[0]
+	copy(d[156:190], isoDirRecord("\x00", root.lba, root.size, isoFlagDirectory, img.created))
+	copy(d[190:813], strings.Repeat(" ", 813-190)) // Volume set, publisher, preparer, application and file IDs / 卷集、发布者、准备者、应用程序和文件 ID
+	copy(d[574:], "CHASI-BOD")
+	copy(d[813:], isoDate(img.created))
+	copy(d[830:], isoDate(img.created))
+	copy(d[847:], append([]byte(strings.Repeat("0", 16)), 0)) // Never expires / 永不过期
+	copy(d[864:], append([]byte(strings.Repeat("0", 16)), 0)) // Effective immediately / 立即生效
+	d[881] = 1                                                // File structure version / 文件结构版本
+	return d
+}
+
+// bootRecord renders the El Torito boot record volume descriptor pointing to the boot catalog.
+// bootRecord 渲染指向引导目录的 El Torito 引导记录卷描述符。
+func (img *isoImage) bootRecord() []byte {
+	d := make([]byte, isoSectorSize)
+	copy(d[1:], "CD001\x01EL TORITO SPECIFICATION")
+	binary.LittleEndian.PutUint32(d[0x47:], img.catalogLBA)
+	return d
+}
+
+// bootCatalog renders the El Torito boot catalog: the first boot entry is the default entry, each other one has a
+// section of its own.
+// bootCatalog 渲染 El Torito 引导目录：第一个引导条目为默认条目，其余每个条目各有一个分区段。
+func (img *isoImage) bootCatalog() []byte {
+	c := make([]byte, isoSectorSize)
+	c[0] = 1
+	c[1] = img.boot[0].platform
+	copy(c[4:28], "CHASI-BOD")
+	c[30], c[31] = 0x55, 0xAA
+	var sum uint16
+	for i := 0; i < 32; i += 2 {
+		sum += binary.LittleEndian.Uint16(c[i:])
+	}
+	binary.LittleEndian.PutUint16(c[28:], -sum)
+
+	entry := func(b []byte, e isoBootEntry) {
+		b[0] = 0x88 // Bootable, no emulation / 可引导，无仿真
+		binary.LittleEndian.PutUint16(b[6:], e.sectors)
+		binary.LittleEndian.PutUint32(b[8:], e.file.lba)
+	}
+	entry(c[32:64], img.boot[0])
+	for i, e := range img.boot[1:] {
+		header := c[64+i*64 : 96+i*64]
+		header[0] = 0x90
+		if i == len(img.boot)-2 {
+			header[0] = 0x91 // The final section / 最后一个分区段
+		}
+		header[1] = e.platform
+		binary.LittleEndian.PutUint16(header[2:], 1)
+		entry(c[96+i*64:128+i*64], e)
+	}
+	return c
+}
+
+// pathTable renders the path table in the given byte order.
+// pathTable 以给定字节序渲染路径表。
+func (img *isoImage) pathTable(order binary.ByteOrder) []byte {
+	var t []byte
+	for _, dir := range img.dirs {
+		name, parent := dir.name, uint16(1)
+		if dir.parent == nil {
+			name = "\x00"
+		} else {
+			parent = dir.parent.number
+		}
+		r := make([]byte, 8+len(name)+len(name)%2)
+		r[0] = byte(len(name))
+		order.PutUint32(r[2:], dir.lba)
+		order.PutUint16(r[6:], parent)
+		copy(r[8:], name)
+		t = append(t, r...)
+	}
+	return t
+}
+
+// write writes the laid out image to out, with systemArea, e.g. a hybrid MBR, in its first sectors.
+// write 将已布局的镜像写入 out，并将 systemArea（例如混合 MBR）写入其开头的扇区。
+func (img *isoImage) write(out *os.File, systemArea []byte) error {
+	writeErr := func(err error) error {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", out.Name()), err)
+	}
+	if err := out.Truncate(int64(img.totalSectors) * isoSectorSize); err != nil {
+		return writeErr(err)
+	}
+	at := func(lba uint32, data []byte) error {
+		if _, err := out.WriteAt(data, int64(lba)*isoSectorSize); err != nil {
+			return writeErr(err)
+		}
+		return nil
+	}
+	terminator := make([]byte, isoSectorSize)
+	terminator[0] = 0xFF
+	copy(terminator[1:], "CD001\x01")
+	descriptors := [][]byte{systemArea, img.primaryVolumeDescriptor()}
+	if len(img.boot) > 0 {
+		descriptors = append(descriptors, img.bootRecord())
+	}
+	descriptors = append(descriptors, terminator)
+	if len(img.boot) > 0 {
+		descriptors = append(descriptors, img.bootCatalog())
+	}
+	for i, data := range descriptors {
+		lba := uint32(0)
+		if i > 0 {
+			lba = isoPVDSector + uint32(i) - 1
+		}
+		if err := at(lba, data); err != nil {
+			return err
+		}
+	}
+	if err := at(img.pathTableLBA[0], img.pathTable(binary.LittleEndian)); err != nil {
+		return err
+	}
+	if err := at(img.pathTableLBA[1], img.pathTable(binary.BigEndian)); err != nil {
+		return err
+	}
+	for _, dir := range img.dirs {
+		if err := at(dir.lba, img.directory(dir)); err != nil {
+			return err
+		}
+	}
+	for _, f := range img.files {
+		if f.data != nil {
+			if err := at(f.lba, f.data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyFileAt(out, int64(f.lba)*isoSectorSize, f.source, f.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileAt copies the size bytes of the file at source to out at offset.
+// copyFileAt 将 source 处文件的 size 字节复制到 out 的 offset 处。
+func copyFileAt(out *os.File, offset int64, source string, size int64) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to open %s", source), err)
+	}
+	defer in.Close()
+	if _, err := io.CopyN(io.NewOffsetWriter(out, offset), in, size); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to copy %s to %s", source, out.Name()), err)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package packer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// isoReader reads the files of an ISO 9660 image by their names, which it matches the way Linux shows them.
+type isoReader struct {
+	t *testing.T
+	f *os.File
+}
+
+func (r *isoReader) sectors(lba uint32, size int) []byte {
+	buf := make([]byte, size)
+	_, err := r.f.ReadAt(buf, int64(lba)*isoSectorSize)
+	require.NoError(r.t, err)
+	return buf
+}
+
+// lookup returns the extent of the file at a slash-separated lowercase path.
+func (r *isoReader) lookup(path string) (uint32, int) {
+	pvd := r.sectors(isoPVDSector, isoSectorSize)
+	require.Equal(r.t, "\x01CD001\x01", string(pvd[:7]))
+	lba, size := binary.LittleEndian.Uint32(pvd[158:]), int(binary.LittleEndian.Uint32(pvd[166:]))
+	for _, name := range strings.Split(path, "/") {
+		dir := r.sectors(lba, size)
+		found := false
+		for pos := 0; pos < len(dir) && !found; {
+			length := int(dir[pos])
+			if length == 0 {
+				pos = (pos/isoSectorSize + 1) * isoSectorSize
+				continue
+			}
+			record := dir[pos : pos+length]
+			id := strings.TrimSuffix(strings.TrimSuffix(string(record[33:33+record[32]]), ";1"), ".")
+			if strings.ToLower(id) == name {
+				require.Zero(r.t, record[25]&isoFlagMultiExtent)
+				lba, size, found = binary.LittleEndian.Uint32(record[2:]), int(binary.LittleEndian.Uint32(record[10:])), true
+			}
+			pos += length
+		}
+		require.True(r.t, found, "%s is not in the image", path)
+	}
+	return lba, size
+}
+
+func (r *isoReader) read(path string) []byte {
+	return r.sectors(r.lookup(path))
+}
+
+// readCPIO returns the files of a newc cpio archive and their modes.
+func readCPIO(t *testing.T, archive []byte) (map[string][]byte, map[string]uint32) {
+	t.Helper()
+	files, modes := map[string][]byte{}, map[string]uint32{}
+	field := func(b []byte, i int) int {
+		v, err := strconv.ParseUint(string(b[6+i*8:14+i*8]), 16, 32)
+		require.NoError(t, err)
+		return int(v)
+	}
+	align := func(n int) int { return (n + 3) &^ 3 }
+	for pos := 0; ; {
+		header := archive[pos:]
+		require.Equal(t, "070701", string(header[:6]))
+		nameSize, dataSize := field(header, 11), field(header, 6)
+		name := string(header[110 : 110+nameSize-1])
+		if name == "TRAILER!!!" {
+			return files, modes
+		}
+		dataStart := align(110 + nameSize)
+		files[name] = header[dataStart : dataStart+dataSize]
+		modes[name] = uint32(field(header, 1))
+		pos += dataStart + align(dataSize)
+	}
+}
+
+// newTestISORootFS returns a root filesystem with what the ISO installer needs besides GRUB.
+func newTestISORootFS(t *testing.T) string {
+	t.Helper()
+	rootFS := newTestRootFS(t)
+	modules := filepath.Join("lib", "modules", "6.8.0-45-generic")
+	for path, content := range map[string]string{
+		"usr/sbin/sfdisk":    "#!/bin/sh\n",
+		"usr/sbin/mkfs.ext4": "#!/bin/sh\n",
+		"usr/bin/gzip":       "#!/bin/sh\n",
+		hybridMBRPath:        strings.Repeat("\xEB", sectorSize),
+		filepath.Join(modules, "modules.dep"): "kernel/fs/isofs/isofs.ko.zst:\n" +
+			"kernel/drivers/scsi/sr_mod.ko.zst: kernel/drivers/cdrom/cdrom.ko.zst kernel/drivers/scsi/scsi_common.ko.zst\n" +
+			"kernel/drivers/cdrom/cdrom.ko.zst: kernel/drivers/scsi/scsi_common.ko.zst\n" +
+			"kernel/drivers/scsi/scsi_common.ko.zst:\n" +
+			"kernel/drivers/usb/storage/usb-storage.ko.zst:\n" +
+			"kernel/drivers/unused.ko.zst:\n",
+		filepath.Join(modules, "kernel/fs/isofs/isofs.ko.zst"):                  "isofs",
+		filepath.Join(modules, "kernel/drivers/scsi/sr_mod.ko.zst"):             "sr_mod",
+		filepath.Join(modules, "kernel/drivers/cdrom/cdrom.ko.zst"):             "cdrom",
+		filepath.Join(modules, "kernel/drivers/scsi/scsi_common.ko.zst"):        "scsi_common",
+		filepath.Join(modules, "kernel/drivers/usb/storage/usb-storage.ko.zst"): "usb-storage",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(rootFS, path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(rootFS, path), []byte(content), 0755))
+	}
+	return rootFS
+}
+
+func TestISOPacker_Package(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the ISO boots with BIOS on amd64 only")
+	}
+	utils.InitLogger("info", 0)
+	fakeGrubImage(t)
+	rootFS := newTestISORootFS(t)
+	p, err := NewImagePacker(enum.OutputFormatISO, []string{"console=ttyS0,115200"})
+	require.NoError(t, err)
+	config := &model.OutputConfig{Format: enum.OutputFormatISO, OutputDir: t.TempDir(), ImageName: "platform", InstallDisk: "/dev/sda"}
+
+	path, err := p.Package(context.Background(), rootFS, config)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(config.OutputDir, "platform.iso"), path)
+	leftovers, err := filepath.Glob(filepath.Join(config.OutputDir, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, leftovers, "the temporary files are removed")
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	r := &isoReader{t: t, f: f}
+
+	// The boot menu finds the ISO by the UUID blkid and GRUB derive from its modification date.
+	pvd := r.sectors(isoPVDSector, isoSectorSize)
+	assert.Equal(t, "CHASI_BOD", strings.TrimSpace(string(pvd[40:72])))
+	assert.Equal(t, info.Size()/isoSectorSize, int64(binary.LittleEndian.Uint32(pvd[80:])))
+	d := pvd[830:846]
+	isoUUID := strings.Join([]string{string(d[0:4]), string(d[4:6]), string(d[6:8]), string(d[8:10]), string(d[10:12]), string(d[12:14]), string(d[14:16])}, "-")
+	grubCfg := string(r.read("boot/grub/grub.cfg"))
+	assert.Contains(t, grubCfg, "search --no-floppy --fs-uuid --set=root "+isoUUID+"\n")
+	assert.Contains(t, grubCfg, "menuentry 'Install chasi-bod (6.8.0-45-generic) to /dev/sda' {\n")
+	assert.Contains(t, grubCfg, "\tlinux /live/vmlinuz rdinit=/chasi-bod/init chasi-bod.install=/dev/sda console=ttyS0,115200\n")
+	assert.Contains(t, grubCfg, "\tinitrd /live/initrd.img /live/install.img\n")
+	assert.Equal(t, "kernel", string(r.read("live/vmlinuz")))
+	assert.Equal(t, "initrd", string(r.read("live/initrd.img")))
+
+	// El Torito: BIOS.IMG is the default entry, EFI.IMG the UEFI one.
+	bootRecord := r.sectors(isoPVDSector+1, isoSectorSize)
+	assert.Equal(t, "\x00CD001\x01EL TORITO SPECIFICATION", string(bootRecord[:30]))
+	catalog := r.sectors(binary.LittleEndian.Uint32(bootRecord[0x47:]), isoSectorSize)
+	var sum uint16
+	for i := 0; i < 32; i += 2 {
+		sum += binary.LittleEndian.Uint16(catalog[i:])
+	}
+	assert.Zero(t, sum, "validation entry checksum")
+	assert.Equal(t, []byte{0x55, 0xAA}, catalog[30:32])
+	biosLBA, biosSize := r.lookup("boot/bios.img")
+	efiLBA, efiSize := r.lookup("boot/efi.img")
+	assert.Equal(t, byte(0x88), catalog[32])
+	assert.Equal(t, uint16(4), binary.LittleEndian.Uint16(catalog[38:]))
+	assert.Equal(t, biosLBA, binary.LittleEndian.Uint32(catalog[40:]))
+	assert.Equal(t, []byte{0x91, eltoritoEFI, 1, 0}, catalog[64:68])
+	assert.Equal(t, byte(0x88), catalog[96])
+	assert.Equal(t, uint16(efiSize/sectorSize), binary.LittleEndian.Uint16(catalog[102:]))
+	assert.Equal(t, efiLBA, binary.LittleEndian.Uint32(catalog[104:]))
+
+	bios := r.read("boot/bios.img")
+	assert.Equal(t, "MZ grub ", string(bios[:8]), "the image before its boot info table")
+	assert.Equal(t, []uint32{isoPVDSector, biosLBA, uint32(biosSize)},
+		[]uint32{binary.LittleEndian.Uint32(bios[8:]), binary.LittleEndian.Uint32(bios[12:]), binary.LittleEndian.Uint32(bios[16:])}, "boot info table")
+	assert.Equal(t, uint64(biosLBA)*4+5, binary.LittleEndian.Uint64(bios[2548:]), "GRUB boot info")
+
+	// The hybrid MBR boots the El Torito image from a USB stick and exposes EFI.IMG as an EFI system partition.
+	mbr := r.sectors(0, sectorSize)
+	assert.Equal(t, strings.Repeat("\xEB", 0x1B0), string(mbr[:0x1B0]))
+	assert.Equal(t, uint64(biosLBA)*4+4, binary.LittleEndian.Uint64(mbr[0x1B0:]))
+	assert.Equal(t, byte(0x80), mbr[446])
+	assert.Equal(t, uint32(info.Size()/sectorSize), binary.LittleEndian.Uint32(mbr[446+12:]))
+	assert.Equal(t, byte(0xEF), mbr[462+4])
+	assert.Equal(t, []uint32{efiLBA * 4, uint32(efiSize / sectorSize)}, []uint32{binary.LittleEndian.Uint32(mbr[462+8:]), binary.LittleEndian.Uint32(mbr[462+12:])})
+	assert.Equal(t, []byte{0x55, 0xAA}, mbr[510:])
+
+	efiOffset := int64(efiLBA) * isoSectorSize
+	efiBoot := make([]byte, sectorSize)
+	_, err = f.ReadAt(efiBoot, efiOffset)
+	require.NoError(t, err)
+	assert.Equal(t, "FAT16   ", string(efiBoot[54:62]))
+	assert.Equal(t, "MZ grub x86_64-efi /EFI/BOOT", string(readFATFile(t, f, efiOffset, "EFI/BOOT/BOOTX64.EFI")))
+	assert.Equal(t, "# Written by chasi-bod.\nsearch --no-floppy --fs-uuid --set=root "+isoUUID+"\nset prefix=($root)/boot/grub\nconfigfile /boot/grub/grub.cfg\n",
+		string(readFATFile(t, f, efiOffset, "EFI/BOOT/GRUB.CFG")))
+
+	// The installer finds the medium by its build ID and sets up the disk the platform expects.
+	files, modes := readCPIO(t, r.read("live/install.img"))
+	buildID := strings.TrimSpace(string(r.read("live/build.id")))
+	assert.Regexp(t, `^[0-9a-f-]{36}$`, buildID)
+	init := string(files["chasi-bod/init"])
+	assert.Contains(t, init, "\nid="+buildID+"\n")
+	assert.Contains(t, init, "\nmodules=\"isofs.ko.zst scsi_common.ko.zst cdrom.ko.zst sr_mod.ko.zst usb-storage.ko.zst\"\n")
+	assert.Equal(t, "cdrom", string(files["chasi-bod/modules/cdrom.ko.zst"]))
+	assert.NotContains(t, files, "chasi-bod/modules/unused.ko.zst")
+	assert.Equal(t, uint32(0100755), modes["chasi-bod/init"])
+	assert.Equal(t, uint32(0100755), modes["chasi-bod/install"])
+	assert.Equal(t, uint32(0040755), modes["chasi-bod/root"])
+	install := string(files["chasi-bod/install"])
+	match := regexp.MustCompile(`\nroot_uuid=([0-9a-f-]{36})\n`).FindStringSubmatch(install)
+	require.NotNil(t, match, install)
+	rootUUID := match[1]
+	assert.Contains(t, install, "\nparam=chasi-bod.install\n")
+	assert.Contains(t, install, "\nstart=2048, size=524288, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B, name=\"EFI System Partition\"\ntype=0FC63DAF-8483-4772-8E79-3D69D8477DE4, name=root\n")
+	for name, script := range map[string]string{"init": init, "install": install} {
+		output, err := exec.Command("sh", "-n", "-c", script).CombinedOutput()
+		assert.NoError(t, err, "%s: %s", name, output)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(r.read("live/esp.gz")))
+	require.NoError(t, err)
+	esp, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Len(t, esp, espSize)
+	assert.Equal(t, "MZ grub x86_64-efi /EFI/BOOT", string(readFATFile(t, bytes.NewReader(esp), 0, "EFI/BOOT/BOOTX64.EFI")))
+	espCfg := string(readFATFile(t, bytes.NewReader(esp), 0, "EFI/BOOT/GRUB.CFG"))
+	assert.Contains(t, espCfg, "search --no-floppy --fs-uuid --set=root "+rootUUID+"\n")
+	assert.Contains(t, espCfg, " root=UUID="+rootUUID+" ro console=ttyS0,115200\n")
+	espVolumeID := binary.LittleEndian.Uint32(esp[67:])
+
+	sfsPath := filepath.Join(t.TempDir(), "rootfs.sfs")
+	require.NoError(t, os.WriteFile(sfsPath, r.read("live/rootfs.sfs"), 0644))
+	sfs := openSquashfs(t, sfsPath)
+	assert.Equal(t, "node\n", string(sfs.read(sfs.lookup("etc/hostname"))))
+	fstab := string(sfs.read(sfs.lookup("etc/fstab")))
+	assert.Contains(t, fstab, "UUID="+rootUUID+" / ext4 defaults 0 1\n")
+	assert.Contains(t, fstab, fmt.Sprintf("UUID=%04X-%04X /boot/efi vfat umask=0077 0 2\n", espVolumeID>>16, espVolumeID&0xFFFF))
+	assert.Equal(t, uint16(squashfsDirType), sfs.lookup("boot/efi").typ)
+}
+
+func TestISOPacker_MissingTools(t *testing.T) {
+	fakeGrubImage(t)
+	rootFS := newTestISORootFS(t)
+	require.NoError(t, os.Remove(filepath.Join(rootFS, "usr/sbin/sfdisk")))
+	p, err := NewImagePacker(enum.OutputFormatISO, nil)
+	require.NoError(t, err)
+	_, err = p.Package(context.Background(), rootFS, &model.OutputConfig{OutputDir: t.TempDir(), ImageName: "platform"})
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+	assert.ErrorContains(t, err, "no sfdisk")
+}
@@ -8,12 +8,6 @@ import (
 	"github.com/turtacn/chasi-bod/common/errors"
 	"github.com/turtacn/chasi-bod/common/types/enum"
 	"github.com/turtacn/chasi-bod/pkg/config/model"
-	// You might need specific libraries for creating different image formats
-	// 您可能需要特定的库来创建不同的镜像格式
-	// For ISO: go-isomd // Example library
-	// For QCOW2/VMA/OVA: qemu-img command execution or specific libraries
-	// 对于 ISO：go-isomd // 示例库
-	// 对于 QCOW2/VMA/OVA：qemu-img 命令执行或特定库
 )
 
 // ImagePacker defines the interface for creating different types of images from a root filesystem.
@@ -34,53 +28,20 @@ type ImagePacker interface {
 // NewImagePacker creates a new ImagePacker implementation based on the desired output format.
 // NewImagePacker 根据期望的输出格式创建一个新的 ImagePacker 实现。
 // format: The desired output image format. / 期望的输出镜像格式。
+// kernelArgs: Arguments added to the kernel command line of bootable images. / 添加到可引导镜像内核命令行的参数。
 // Returns an ImagePacker implementation or an error if the format is unsupported.
 // 返回 ImagePacker 实现，如果格式不受支持则返回错误。
-func NewImagePacker(format enum.BuilderOutputFormat) (ImagePacker, error) {
+func NewImagePacker(format enum.BuilderOutputFormat, kernelArgs []string) (ImagePacker, error) {
 	switch format {
 	case enum.OutputFormatISO:
-		// return &ISOPacker{} // Assuming an ISOPacker exists
-		return nil, errors.New(errors.ErrTypeNotImplemented, "ISO packer not implemented yet")
-	case enum.OutputFormatQCOW2:
-		// return &QCOW2Packer{} // Assuming a QCOW2Packer exists
-		return nil, errors.New(errors.ErrTypeNotImplemented, "QCOW2 packer not implemented yet")
+		return &isoPacker{kernelArgs: kernelArgs}, nil
+	case enum.OutputFormatQCOW2, enum.OutputFormatRaw:
+		return &diskPacker{format: format, kernelArgs: kernelArgs}, nil
 	case enum.OutputFormatOVA:
-		// return &OVAPacker{} // Assuming an OVAPacker exists
 		return nil, errors.New(errors.ErrTypeNotImplemented, "OVA packer not implemented yet")
 	case enum.OutputFormatVMA:
-		// return &VMAPacker{} // Assuming a VMAPacker exists
 		return nil, errors.New(errors.ErrTypeNotImplemented, "VMA packer not implemented yet")
 	default:
 		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported image output format '%s'", format))
 	}
 }
-
-// Example implementation structure (not fully functional)
-// 示例实现结构（不完全功能）
-// type ISOPacker struct{}
-//
-// func (p *ISOPacker) Package(ctx context.Context, rootFS string, config *model.OutputConfig) (string, error) {
-// 	utils.GetLogger().Printf("Placeholder: Packaging %s into ISO format in directory %s", rootFS, config.OutputDir)
-// 	// Use tools like genisoimage or xorriso to create a bootable ISO from rootFS
-// 	// Need to handle bootloader integration (e.g., syslinux, grub2)
-// 	// This might involve running external commands using os/exec
-// 	// 使用 genisoimage 或 xorriso 等工具从 rootFS 创建可引导 ISO
-// 	// 需要处理引导加载程序集成（例如，syslinux, grub2）
-// 	// 这可能涉及使用 os/exec 运行外部命令
-//
-// 	outputPath := filepath.Join(config.OutputDir, config.ImageName+".iso")
-//
-// 	// Ensure output directory exists
-// 	// 确保输出目录存在
-// 	if err := utils.MkdirAll(config.OutputDir, 0755); err != nil {
-// 		return "", err
-// 	}
-//
-// 	// Simulate creating a dummy file
-// 	// 模拟创建一个虚拟文件
-// 	if err := utils.WriteFileContent(outputPath, []byte("dummy iso content"), 0644); err != nil {
-// 		return "", err
-// 	}
-//
-// 	return outputPath, errors.New(errors.ErrTypeNotImplemented, "ISO packaging not implemented")
-// }
@@ -0,0 +1,183 @@
+package packer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+const (
+	// qcow2ClusterBits gives 64 KiB clusters, the qemu-img default.
+	// qcow2ClusterBits 使簇大小为 64 KiB，即 qemu-img 的默认值。
+	qcow2ClusterBits = 16
+	qcow2ClusterSize = 1 << qcow2ClusterBits
+	// qcow2HeaderLength is the size of a version 3 header without optional fields.
+	// qcow2HeaderLength 是不含可选字段的第 3 版头的大小。
+	qcow2HeaderLength = 104
+	// qcow2RefcountOrder gives 16-bit reference counts.
+	// qcow2RefcountOrder 使引用计数为 16 位。
+	qcow2RefcountOrder = 4
+	// qcow2Copied marks L1 and L2 entries of clusters referenced once, which may be written in place.
+	// qcow2Copied 标记仅被引用一次、可以原地写入的簇的 L1 和 L2 条目。
+	qcow2Copied = uint64(1) << 63
+)
+
+// convertQCOW2 writes the raw disk image at rawPath as a qcow2 (version 3) image at path. Clusters holding only zeros
+// are left unallocated, so the image only stores what the disk holds.
+// convertQCOW2 将 rawPath 处的原始磁盘镜像写为 path 处的 qcow2（第 3 版）镜像。仅包含零的簇不分配，因此镜像只存储磁盘的实际内容。
+func convertQCOW2(ctx context.Context, rawPath, path string) error {
+	raw, err := os.Open(rawPath)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to open %s", rawPath), err)
+	}
+	defer raw.Close()
+	info, err := raw.Stat()
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to stat %s", rawPath), err)
+	}
+	virtualSize := uint64(info.Size())
+	guestClusters := (virtualSize + qcow2ClusterSize - 1) / qcow2ClusterSize
+
+	// First pass: find the guest clusters holding data.
+	// 第一遍：找出包含数据的客户机簇。
+	var used []uint64
+	buf := make([]byte, qcow2ClusterSize)
+	zero := make([]byte, qcow2ClusterSize)
+	for cluster := uint64(0); cluster < guestClusters; cluster++ {
+		if cluster%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		n, err := raw.ReadAt(buf, int64(cluster*qcow2ClusterSize))
+		if err != nil && err != io.EOF {
+			return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read %s", rawPath), err)
+		}
+		if !bytes.Equal(buf[:n], zero[:n]) {
+			used = append(used, cluster)
+		}
+	}
+
+	// Layout: header, L1 table, refcount table, refcount blocks, L2 tables, data. The refcount structures count
+	// themselves, so their size is iterated to a fixed point.
+	// 布局：头、L1 表、引用计数表、引用计数块、L2 表、数据。引用计数结构也计数自身，因此其大小迭代到不动点。
+	const l2Entries = qcow2ClusterSize / 8
+	const refcountsPerBlock = qcow2ClusterSize * 8 / (1 << qcow2RefcountOrder)
+	l1Size := (guestClusters + l2Entries - 1) / l2Entries
+	l1Clusters := clustersFor(l1Size * 8)
+	var l2Tables []uint64 // L1 indexes with an L2 table, ascending / 具有 L2 表的 L1 索引，升序
+	for _, cluster := range used {
+		if index := cluster / l2Entries; len(l2Tables) == 0 || l2Tables[len(l2Tables)-1] != index {
+			l2Tables = append(l2Tables, index)
+		}
+	}
+	var refcountTableClusters, refcountBlocks, hostClusters uint64
+	for {
+		hostClusters = 1 + l1Clusters + refcountTableClusters + refcountBlocks + uint64(len(l2Tables)) + uint64(len(used))
+		blocks := (hostClusters + refcountsPerBlock - 1) / refcountsPerBlock
+		tableClusters := clustersFor(blocks * 8)
+		if blocks == refcountBlocks && tableClusters == refcountTableClusters {
+			break
+		}
+		refcountBlocks, refcountTableClusters = blocks, tableClusters
+	}
+	l1Offset := uint64(qcow2ClusterSize)
+	refcountTableOffset := l1Offset + l1Clusters*qcow2ClusterSize
+	refcountBlocksOffset := refcountTableOffset + refcountTableClusters*qcow2ClusterSize
+	l2Offset := refcountBlocksOffset + refcountBlocks*qcow2ClusterSize
+	dataOffset := l2Offset + uint64(len(l2Tables))*qcow2ClusterSize
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create %s", path), err)
+	}
+	defer out.Close()
+	writeErr := func(err error) error {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", path), err)
+	}
+
+	header := make([]byte, qcow2HeaderLength+8) // The header is followed by the end-of-extensions marker / 头之后是扩展结束标记
+	binary.BigEndian.PutUint32(header[0:], 0x514649fb)
+	binary.BigEndian.PutUint32(header[4:], 3)
+	binary.BigEndian.PutUint32(header[20:], qcow2ClusterBits)
+	binary.BigEndian.PutUint64(header[24:], virtualSize)
+	binary.BigEndian.PutUint32(header[36:], uint32(l1Size))
+	binary.BigEndian.PutUint64(header[40:], l1Offset)
+	binary.BigEndian.PutUint64(header[48:], refcountTableOffset)
+	binary.BigEndian.PutUint32(header[56:], uint32(refcountTableClusters))
+	binary.BigEndian.PutUint32(header[96:], qcow2RefcountOrder)
+	binary.BigEndian.PutUint32(header[100:], qcow2HeaderLength)
+	if _, err := out.WriteAt(header, 0); err != nil {
+		return writeErr(err)
+	}
+
+	l1 := make([]byte, l1Size*8)
+	for i, index := range l2Tables {
+		binary.BigEndian.PutUint64(l1[index*8:], (l2Offset+uint64(i)*qcow2ClusterSize)|qcow2Copied)
+	}
+	refcountTable := make([]byte, refcountBlocks*8)
+	for i := uint64(0); i < refcountBlocks; i++ {
+		binary.BigEndian.PutUint64(refcountTable[i*8:], refcountBlocksOffset+i*qcow2ClusterSize)
+	}
+	refcounts := make([]byte, refcountBlocks*qcow2ClusterSize)
+	for i := uint64(0); i < hostClusters; i++ {
+		binary.BigEndian.PutUint16(refcounts[i*2:], 1)
+	}
+	l2 := make([]byte, uint64(len(l2Tables))*qcow2ClusterSize)
+	table := -1
+	for i, cluster := range used {
+		if table < 0 || l2Tables[table] != cluster/l2Entries {
+			table++
+		}
+		entry := uint64(table)*qcow2ClusterSize + cluster%l2Entries*8
+		binary.BigEndian.PutUint64(l2[entry:], (dataOffset+uint64(i)*qcow2ClusterSize)|qcow2Copied)
+	}
+	for _, region := range []struct {
+		offset uint64
+		data   []byte
+	}{{l1Offset, l1}, {refcountTableOffset, refcountTable}, {refcountBlocksOffset, refcounts}, {l2Offset, l2}} {
+		if _, err := out.WriteAt(region.data, int64(region.offset)); err != nil {
+			return writeErr(err)
+		}
+	}
+
+	// Second pass: copy the data clusters. The last one is padded to a whole cluster.
+	// 第二遍：复制数据簇。最后一个簇填充为完整的簇。
+	for i, cluster := range used {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for j := range buf {
+			buf[j] = 0
+		}
+		if _, err := raw.ReadAt(buf, int64(cluster*qcow2ClusterSize)); err != nil && err != io.EOF {
+			return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read %s", rawPath), err)
+		}
+		if _, err := out.WriteAt(buf, int64(dataOffset+uint64(i)*qcow2ClusterSize)); err != nil {
+			return writeErr(err)
+		}
+	}
+	// The file must cover every allocated cluster even when the last structures are all zeros.
+	// 即使最后的结构全为零，文件也必须覆盖每个已分配的簇。
+	if err := out.Truncate(int64(hostClusters * qcow2ClusterSize)); err != nil {
+		return writeErr(err)
+	}
+	if err := out.Close(); err != nil {
+		return writeErr(err)
+	}
+	return nil
+}
+
+// clustersFor returns the number of qcow2 clusters holding size bytes, at least one.
+// clustersFor 返回容纳 size 字节所需的 qcow2 簇数，至少为一个。
+func clustersFor(size uint64) uint64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + qcow2ClusterSize - 1) / qcow2ClusterSize
+}
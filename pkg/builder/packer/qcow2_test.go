@@ -0,0 +1,112 @@
+package packer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// qcow2Reader reads the guest data of a qcow2 image written by convertQCOW2.
+type qcow2Reader struct {
+	f    *os.File
+	size int64
+	l1   []uint64
+}
+
+func openQCOW2(t *testing.T, path string) *qcow2Reader {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	header := make([]byte, qcow2HeaderLength)
+	_, err = f.ReadAt(header, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("QFI\xfb"), header[0:4])
+	require.Equal(t, uint32(3), binary.BigEndian.Uint32(header[4:]))
+	require.Equal(t, uint32(qcow2ClusterBits), binary.BigEndian.Uint32(header[20:]))
+	require.Zero(t, binary.BigEndian.Uint64(header[72:]), "no incompatible features")
+
+	l1 := make([]uint64, binary.BigEndian.Uint32(header[36:]))
+	require.NoError(t, binary.Read(io.NewSectionReader(f, int64(binary.BigEndian.Uint64(header[40:])), int64(len(l1))*8), binary.BigEndian, l1))
+	return &qcow2Reader{f: f, size: int64(binary.BigEndian.Uint64(header[24:])), l1: l1}
+}
+
+func (r *qcow2Reader) ReadAt(p []byte, off int64) (int, error) {
+	const offsetMask = 0x00FFFFFFFFFFFE00
+	n := 0
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+		cluster, within := uint64(off)/qcow2ClusterSize, off%qcow2ClusterSize
+		chunk := p[n:min(len(p), n+int(qcow2ClusterSize-within))]
+		clear(chunk)
+		if l2Offset := r.l1[cluster/(qcow2ClusterSize/8)] & offsetMask; l2Offset != 0 {
+			var entry [8]byte
+			if _, err := r.f.ReadAt(entry[:], int64(l2Offset+cluster%(qcow2ClusterSize/8)*8)); err != nil {
+				return n, err
+			}
+			if dataOffset := binary.BigEndian.Uint64(entry[:]) & offsetMask; dataOffset != 0 {
+				if _, err := r.f.ReadAt(chunk, int64(dataOffset)+within); err != nil {
+					return n, err
+				}
+			}
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+	return n, nil
+}
+
+func TestConvertQCOW2(t *testing.T) {
+	dir := t.TempDir()
+	rawPath := filepath.Join(dir, "disk.raw")
+	raw, err := os.Create(rawPath)
+	require.NoError(t, err)
+	// Data in the first cluster, across a cluster boundary, in the second L2 table and in a partial last cluster.
+	const size = 1<<30 + 1<<29 + 1000
+	writes := map[int64][]byte{
+		0:                    []byte("boot sector"),
+		qcow2ClusterSize - 3: []byte("straddles"),
+		1<<30 + 12345:        bytes.Repeat([]byte{0xAB}, 100000),
+		size - 10:            []byte("last bytes"),
+	}
+	for off, data := range writes {
+		_, err := raw.WriteAt(data, off)
+		require.NoError(t, err)
+	}
+	require.NoError(t, raw.Close())
+
+	path := filepath.Join(dir, "disk.qcow2")
+	require.NoError(t, convertQCOW2(context.Background(), rawPath, path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Less(t, info.Size(), int64(1<<20), "zero clusters are not allocated")
+
+	r := openQCOW2(t, path)
+	assert.Equal(t, int64(size), r.size)
+	for off, data := range writes {
+		got := make([]byte, len(data))
+		_, err := r.ReadAt(got, off)
+		require.NoError(t, err)
+		assert.Equal(t, data, got, fmt.Sprintf("data at %d", off))
+	}
+	hole := make([]byte, qcow2ClusterSize)
+	_, err = r.ReadAt(hole, 1<<29)
+	require.NoError(t, err)
+	assert.Equal(t, make([]byte, qcow2ClusterSize), hole)
+
+	if _, err := exec.LookPath("qemu-img"); err == nil {
+		output, err := exec.Command("qemu-img", "check", path).CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+}
@@ -0,0 +1,637 @@
+package packer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+const (
+	squashfsMagic = 0x73717368
+	// squashfsBlockLog and squashfsBlockSize are the size of the data blocks, the mksquashfs default.
+	// squashfsBlockLog 和 squashfsBlockSize 是数据块的大小，即 mksquashfs 的默认值。
+	squashfsBlockLog  = 17
+	squashfsBlockSize = 1 << squashfsBlockLog
+	// squashfsMetadataSize is the uncompressed size of the metadata blocks holding the inodes, directories and tables.
+	// squashfsMetadataSize 是保存 inode、目录和各表的元数据块的未压缩大小。
+	squashfsMetadataSize = 8192
+	squashfsGzip         = 1
+	squashfsInvalid      = ^uint64(0)
+	squashfsNoFragment   = ^uint32(0)
+	squashfsNoXattr      = ^uint32(0)
+	// squashfsDataUncompressed flags a data block stored as is; squashfsMetadataUncompressed a metadata block.
+	// squashfsDataUncompressed 标记按原样存储的数据块；squashfsMetadataUncompressed 标记按原样存储的元数据块。
+	squashfsDataUncompressed     = 1 << 24
+	squashfsMetadataUncompressed = 1 << 15
+	// squashfsDirCount is the largest number of entries sharing a directory header.
+	// squashfsDirCount 是共享一个目录头的最大条目数。
+	squashfsDirCount = 256
+	// Superblock flags / 超级块标志
+	squashfsFlagNoFragments = 0x0010
+	squashfsFlagNoXattrs    = 0x0200
+)
+
+// Inode types; the extended ones carry an xattr index and wider fields.
+// inode 类型；扩展类型带有 xattr 索引和更宽的字段。
+const (
+	squashfsDirType = iota + 1
+	squashfsFileType
+	squashfsSymlinkType
+	squashfsBlockDevType
+	squashfsCharDevType
+	squashfsFifoType
+	squashfsSocketType
+	squashfsExtendedOffset = 7 // Added to a basic type / 加到基本类型上
+)
+
+// squashfsXattrPrefixes are the extended attribute namespaces squashfs can store.
+// squashfsXattrPrefixes 是 squashfs 可以存储的扩展属性命名空间。
+var squashfsXattrPrefixes = []string{"user.", "trusted.", "security."}
+
+// fileStat is the metadata of a file that os.FileInfo does not carry portably.
+// fileStat 是 os.FileInfo 无法可移植地提供的文件元数据。
+type fileStat struct {
+	uid, gid uint32
+	dev, ino uint64
+	nlink    uint64
+	rdev     uint32 // Encoded as the kernel's new_encode_dev / 按内核 new_encode_dev 编码
+	xattrs   map[string][]byte
+}
+
+// squashfsEntry is a named directory entry; the hard links of a file are entries sharing its node.
+// squashfsEntry 是具名的目录条目；文件的各硬链接是共享其节点的条目。
+type squashfsEntry struct {
+	name string
+	node *squashfsNode
+}
+
+// squashfsNode is an inode of the tree being written.
+// squashfsNode 是正在写入的树中的一个 inode。
+type squashfsNode struct {
+	mode     fs.FileMode
+	modTime  uint32
+	stat     fileStat
+	size     uint64
+	target   string // Symbolic link target / 符号链接目标
+	children []squashfsEntry
+	subdirs  uint32
+	links    uint32 // Directory entries naming the inode / 指向该 inode 的目录条目数
+	xattr    uint32
+	number   uint32
+	ref      uint64
+	written  bool
+	start    uint64
+	blocks   []uint32
+	sparse   uint64
+}
+
+// basicType returns the basic inode type of the node, which directory entries record.
+// basicType 返回节点的基本 inode 类型，目录条目记录该类型。
+func (n *squashfsNode) basicType() uint16 {
+	switch {
+	case n.mode.IsDir():
+		return squashfsDirType
+	case n.mode&fs.ModeSymlink != 0:
+		return squashfsSymlinkType
+	case n.mode&fs.ModeCharDevice != 0:
+		return squashfsCharDevType
+	case n.mode&fs.ModeDevice != 0:
+		return squashfsBlockDevType
+	case n.mode&fs.ModeNamedPipe != 0:
+		return squashfsFifoType
+	case n.mode&fs.ModeSocket != 0:
+		return squashfsSocketType
+	default:
+		return squashfsFileType
+	}
+}
+
+// permissions returns the permission, setuid, setgid and sticky bits in their Unix encoding.
+// permissions 以 Unix 编码返回权限位、setuid、setgid 和 sticky 位。
+func (n *squashfsNode) permissions() uint16 {
+	perm := uint16(n.mode.Perm())
+	if n.mode&fs.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if n.mode&fs.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if n.mode&fs.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return perm
+}
+
+// metadataWriter packs a stream into compressed metadata blocks.
+// metadataWriter 将数据流打包为压缩的元数据块。
+type metadataWriter struct {
+	out    bytes.Buffer
+	block  []byte
+	starts []uint64 // Offsets of the blocks in out / 各块在 out 中的偏移
+}
+
+// ref returns the reference of the next byte written: the offset of its block and its offset in the block.
+// ref 返回下一个写入字节的引用：其所在块的偏移及其在块内的偏移。
+func (m *metadataWriter) ref() uint64 {
+	return uint64(m.out.Len())<<16 | uint64(len(m.block))
+}
+
+func (m *metadataWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := min(squashfsMetadataSize-len(m.block), len(p))
+		m.block = append(m.block, p[:chunk]...)
+		p = p[chunk:]
+		if len(m.block) == squashfsMetadataSize {
+			m.flush()
+		}
+	}
+	return n, nil
+}
+
+// flush writes the current block, compressed unless that makes it larger.
+// flush 写入当前块，除非压缩使其变大，否则写入压缩后的数据。
+func (m *metadataWriter) flush() {
+	if len(m.block) == 0 {
+		return
+	}
+	m.starts = append(m.starts, uint64(m.out.Len()))
+	data, header := compressBlock(m.block), uint16(0)
+	if data == nil {
+		data, header = m.block, squashfsMetadataUncompressed
+	}
+	binary.Write(&m.out, binary.LittleEndian, header|uint16(len(data)))
+	m.out.Write(data)
+	m.block = m.block[:0]
+}
+
+// bytes flushes the last block and returns the blocks.
+// bytes 刷新最后一个块并返回所有块。
+func (m *metadataWriter) bytes() []byte {
+	m.flush()
+	return m.out.Bytes()
+}
+
+// compressBlock returns the zlib stream of data, or nil when it is not smaller than data.
+// compressBlock 返回 data 的 zlib 流；若其不小于 data 则返回 nil。
+func compressBlock(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	if buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// squashfsWriter writes a root filesystem as a gzip-compressed squashfs 4.0 image without fragments.
+// squashfsWriter 将根文件系统写为不含碎片的 gzip 压缩 squashfs 4.0 镜像。
+type squashfsWriter struct {
+	ctx      context.Context
+	out      *os.File
+	pos      uint64
+	hardlink map[[2]uint64]*squashfsNode
+	ids      []uint32
+	idIndex  map[uint32]uint16
+	xattrs   metadataWriter
+	xattrIDs []byte
+	xattrSet map[string]uint32
+	count    uint32
+	inodes   metadataWriter
+	dirs     metadataWriter
+}
+
+// writeSquashfs writes the content of rootFS as a squashfs image at path, keeping ownership, permissions, device
+// nodes, hard links and the extended attributes squashfs supports.
+// writeSquashfs 将 rootFS 的内容写为 path 处的 squashfs 镜像，保留属主、权限、设备节点、硬链接以及 squashfs 支持的扩展属性。
+func writeSquashfs(ctx context.Context, rootFS, path string, modTime time.Time) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create %s", path), err)
+	}
+	defer out.Close()
+	w := &squashfsWriter{
+		ctx:      ctx,
+		out:      out,
+		pos:      96, // The superblock is written last / 超级块最后写入
+		hardlink: map[[2]uint64]*squashfsNode{},
+		idIndex:  map[uint32]uint16{},
+		xattrSet: map[string]uint32{},
+	}
+	root, err := w.add(rootFS)
+	if err != nil {
+		return err
+	}
+	w.number(root)
+	if err := w.writeInode(root, w.count+1); err != nil {
+		return err
+	}
+	if err := w.finish(root, modTime); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", path), err)
+	}
+	if err := out.Close(); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", path), err)
+	}
+	return nil
+}
+
+// add reads the file at path into a node, writing the data of regular files and descending into directories.
+// A hard link to a file already added returns its node.
+// add 将 path 处的文件读入节点，写入常规文件的数据并进入目录。指向已添加文件的硬链接返回其节点。
+func (w *squashfsWriter) add(path string) (*squashfsNode, error) {
+	if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to inspect %s", path), err)
+	}
+	stat, err := statFile(path, info)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to inspect %s", path), err)
+	}
+	if !info.IsDir() && stat.nlink > 1 {
+		if node, ok := w.hardlink[[2]uint64{stat.dev, stat.ino}]; ok {
+			node.links++
+			return node, nil
+		}
+	}
+	node := &squashfsNode{mode: info.Mode(), modTime: uint32(info.ModTime().Unix()), stat: stat, links: 1}
+	if !info.IsDir() && stat.nlink > 1 {
+		w.hardlink[[2]uint64{stat.dev, stat.ino}] = node
+	}
+	for _, id := range []uint32{stat.uid, stat.gid} {
+		if _, ok := w.idIndex[id]; !ok {
+			w.idIndex[id] = uint16(len(w.ids))
+			w.ids = append(w.ids, id)
+		}
+	}
+	if len(w.ids) > 1<<16 {
+		return nil, errors.New(errors.ErrTypeValidation, "the root filesystem has more user and group IDs than squashfs can store")
+	}
+	node.xattr = w.addXattrs(stat.xattrs)
+
+	switch {
+	case info.IsDir():
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to list %s", path), err)
+		}
+		for _, entry := range entries {
+			child, err := w.add(filepath.Join(path, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if child.mode.IsDir() {
+				node.subdirs++
+			}
+			node.children = append(node.children, squashfsEntry{name: entry.Name(), node: child})
+		}
+	case info.Mode()&fs.ModeSymlink != 0:
+		if node.target, err = os.Readlink(path); err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read the link %s", path), err)
+		}
+	case info.Mode().IsRegular():
+		if err := w.writeData(node, path); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// addXattrs stores a set of extended attributes and returns its index, sharing identical sets.
+// addXattrs 存储一组扩展属性并返回其索引，相同的属性组共享索引。
+func (w *squashfsWriter) addXattrs(xattrs map[string][]byte) uint32 {
+	type pair struct {
+		prefix int
+		name   string
+		value  []byte
+	}
+	var pairs []pair
+	for name, value := range xattrs {
+		for i, prefix := range squashfsXattrPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				pairs = append(pairs, pair{i, strings.TrimPrefix(name, prefix), value})
+			}
+		}
+	}
+	if len(pairs) == 0 {
+		return squashfsNoXattr
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].prefix < pairs[j].prefix || pairs[i].prefix == pairs[j].prefix && pairs[i].name < pairs[j].name
+	})
+	var kv bytes.Buffer
+	listSize := 0
+	for _, p := range pairs {
+		binary.Write(&kv, binary.LittleEndian, uint16(p.prefix))
+		binary.Write(&kv, binary.LittleEndian, uint16(len(p.name)))
+		kv.WriteString(p.name)
+		binary.Write(&kv, binary.LittleEndian, uint32(len(p.value)))
+		kv.Write(p.value)
+		listSize += len(squashfsXattrPrefixes[p.prefix]) + len(p.name) + 1
+	}
+	if index, ok := w.xattrSet[kv.String()]; ok {
+		return index
+	}
+	index := uint32(len(w.xattrIDs) / 16)
+	entry := make([]byte, 16)
+	binary.LittleEndian.PutUint64(entry[0:], w.xattrs.ref())
+	binary.LittleEndian.PutUint32(entry[8:], uint32(len(pairs)))
+	binary.LittleEndian.PutUint32(entry[12:], uint32(listSize))
+	w.xattrIDs = append(w.xattrIDs, entry...)
+	w.xattrs.Write(kv.Bytes())
+	w.xattrSet[kv.String()] = index
+	return index
+}
+
+// writeData writes the blocks of a regular file; blocks of zeros are stored as holes.
+// writeData 写入常规文件的数据块；全零的块存储为空洞。
+func (w *squashfsWriter) writeData(node *squashfsNode, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to open %s", path), err)
+	}
+	defer f.Close()
+	node.start = w.pos
+	buf := make([]byte, squashfsBlockSize)
+	zero := make([]byte, squashfsBlockSize)
+	for {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			node.size += uint64(n)
+			block := buf[:n]
+			switch data := compressBlock(block); {
+			case bytes.Equal(block, zero[:n]):
+				node.blocks = append(node.blocks, 0)
+				node.sparse += uint64(n)
+			case data != nil:
+				if err := w.write(data); err != nil {
+					return err
+				}
+				node.blocks = append(node.blocks, uint32(len(data)))
+			default:
+				if err := w.write(block); err != nil {
+					return err
+				}
+				node.blocks = append(node.blocks, uint32(n)|squashfsDataUncompressed)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read %s", path), err)
+		}
+	}
+}
+
+// write appends data to the image.
+// write 将数据追加到镜像。
+func (w *squashfsWriter) write(data []byte) error {
+	if _, err := w.out.WriteAt(data, int64(w.pos)); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", w.out.Name()), err)
+	}
+	w.pos += uint64(len(data))
+	return nil
+}
+
+// number assigns the inode numbers in the order the inodes are written: children before their directory.
+// number 按 inode 的写入顺序分配 inode 编号：子节点先于其目录。
+func (w *squashfsWriter) number(node *squashfsNode) {
+	if node.number != 0 {
+		return
+	}
+	for _, child := range node.children {
+		w.number(child.node)
+	}
+	w.count++
+	node.number = w.count
+}
+
+// writeInode writes the inodes of the children of a directory, its listing and then its own inode.
+// writeInode 写入目录子节点的 inode、目录列表，然后写入其自身的 inode。
+func (w *squashfsWriter) writeInode(node *squashfsNode, parent uint32) error {
+	if node.written {
+		return nil
+	}
+	node.written = true
+	var listingRef uint64
+	var listingSize int
+	if node.mode.IsDir() {
+		for _, child := range node.children {
+			if err := w.writeInode(child.node, node.number); err != nil {
+				return err
+			}
+		}
+		listingRef = w.dirs.ref()
+		listingSize = w.writeListing(node.children)
+	}
+
+	ext := node.xattr != squashfsNoXattr
+	typ := node.basicType()
+	switch typ {
+	case squashfsDirType:
+		ext = ext || listingSize+3 > 0xFFFF
+	case squashfsFileType:
+		ext = ext || node.links > 1 || node.start > 0xFFFFFFFF || node.size > 0xFFFFFFFF
+	}
+	if ext {
+		typ += squashfsExtendedOffset
+	}
+
+	node.ref = w.inodes.ref()
+	le := binary.LittleEndian
+	var buf bytes.Buffer
+	put := func(v any) { binary.Write(&buf, le, v) }
+	put(typ)
+	put(node.permissions())
+	put(w.idIndex[node.stat.uid])
+	put(w.idIndex[node.stat.gid])
+	put(node.modTime)
+	put(node.number)
+	switch typ {
+	case squashfsDirType:
+		put(uint32(listingRef >> 16))
+		put(2 + node.subdirs)
+		put(uint16(listingSize + 3))
+		put(uint16(listingRef & 0xFFFF))
+		put(parent)
+	case squashfsDirType + squashfsExtendedOffset:
+		put(2 + node.subdirs)
+		put(uint32(listingSize + 3))
+		put(uint32(listingRef >> 16))
+		put(parent)
+		put(uint16(0)) // No directory index / 无目录索引
+		put(uint16(listingRef & 0xFFFF))
+		put(node.xattr)
+	case squashfsFileType:
+		put(uint32(node.start))
+		put(squashfsNoFragment)
+		put(uint32(0))
+		put(uint32(node.size))
+		put(node.blocks)
+	case squashfsFileType + squashfsExtendedOffset:
+		put(node.start)
+		put(node.size)
+		put(node.sparse)
+		put(node.links)
+		put(squashfsNoFragment)
+		put(uint32(0))
+		put(node.xattr)
+		put(node.blocks)
+	case squashfsSymlinkType, squashfsSymlinkType + squashfsExtendedOffset:
+		put(node.links)
+		put(uint32(len(node.target)))
+		buf.WriteString(node.target)
+	case squashfsBlockDevType, squashfsCharDevType, squashfsBlockDevType + squashfsExtendedOffset, squashfsCharDevType + squashfsExtendedOffset:
+		put(node.links)
+		put(node.stat.rdev)
+	default:
+		put(node.links)
+	}
+	if ext && typ != squashfsDirType+squashfsExtendedOffset && typ != squashfsFileType+squashfsExtendedOffset {
+		put(node.xattr)
+	}
+	w.inodes.Write(buf.Bytes())
+	return nil
+}
+
+// writeListing writes the directory entries of children, sorted by name, and returns their size. A header starts
+// the entries whose inodes share a metadata block and have nearby numbers, 256 at most.
+// writeListing 写入按名称排序的 children 的目录条目并返回其大小。inode 位于同一元数据块且编号相近的条目（最多 256 个）以一个目录头开始。
+func (w *squashfsWriter) writeListing(children []squashfsEntry) int {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	for i := 0; i < len(children); {
+		base := children[i].node
+		j := i + 1
+		for ; j < len(children) && j-i < squashfsDirCount; j++ {
+			next := children[j].node
+			if delta := int64(next.number) - int64(base.number); next.ref>>16 != base.ref>>16 || delta < -32768 || delta > 32767 {
+				break
+			}
+		}
+		binary.Write(&buf, le, uint32(j-i-1))
+		binary.Write(&buf, le, uint32(base.ref>>16))
+		binary.Write(&buf, le, base.number)
+		for _, child := range children[i:j] {
+			binary.Write(&buf, le, uint16(child.node.ref&0xFFFF))
+			binary.Write(&buf, le, int16(int64(child.node.number)-int64(base.number)))
+			binary.Write(&buf, le, child.node.basicType())
+			binary.Write(&buf, le, uint16(len(child.name)-1))
+			buf.WriteString(child.name)
+		}
+		i = j
+	}
+	w.dirs.Write(buf.Bytes())
+	return buf.Len()
+}
+
+// finish writes the inode, directory, ID and xattr tables after the data, then the superblock, and pads the image
+// to 4 KiB so it can back a loop device.
+// finish 在数据之后写入 inode、目录、ID 和 xattr 表，然后写入超级块，并将镜像填充到 4 KiB 以便作为环回设备的后端。
+func (w *squashfsWriter) finish(root *squashfsNode, modTime time.Time) error {
+	le := binary.LittleEndian
+	inodeTableStart := w.pos
+	if err := w.write(w.inodes.bytes()); err != nil {
+		return err
+	}
+	dirTableStart := w.pos
+	if err := w.write(w.dirs.bytes()); err != nil {
+		return err
+	}
+
+	// Each table is a sequence of metadata blocks followed by the index of their positions.
+	// 每个表是一系列元数据块，后跟它们位置的索引。
+	writeTable := func(data []byte) (uint64, error) {
+		var m metadataWriter
+		m.Write(data)
+		blocks := m.bytes()
+		start := w.pos
+		if err := w.write(blocks); err != nil {
+			return 0, err
+		}
+		index := make([]byte, 8*len(m.starts))
+		for i, offset := range m.starts {
+			le.PutUint64(index[i*8:], start+offset)
+		}
+		indexStart := w.pos
+		return indexStart, w.write(index)
+	}
+	ids := make([]byte, 4*len(w.ids))
+	for i, id := range w.ids {
+		le.PutUint32(ids[i*4:], id)
+	}
+	idTableStart, err := writeTable(ids)
+	if err != nil {
+		return err
+	}
+	xattrTableStart, flags := squashfsInvalid, uint16(squashfsFlagNoFragments)
+	if len(w.xattrIDs) == 0 {
+		flags |= squashfsFlagNoXattrs
+	} else {
+		// The references of the xattr IDs are relative to the key-value blocks, which come first.
+		// xattr ID 的引用相对于首先写入的键值块。
+		kvStart := w.pos
+		if err := w.write(w.xattrs.bytes()); err != nil {
+			return err
+		}
+		var m metadataWriter
+		m.Write(w.xattrIDs)
+		blocks := m.bytes()
+		blocksStart := w.pos
+		if err := w.write(blocks); err != nil {
+			return err
+		}
+		header := make([]byte, 16+8*len(m.starts))
+		le.PutUint64(header[0:], kvStart)
+		le.PutUint32(header[8:], uint32(len(w.xattrIDs)/16))
+		for i, offset := range m.starts {
+			le.PutUint64(header[16+i*8:], blocksStart+offset)
+		}
+		xattrTableStart = w.pos
+		if err := w.write(header); err != nil {
+			return err
+		}
+	}
+	bytesUsed := w.pos
+
+	sb := make([]byte, 96)
+	le.PutUint32(sb[0:], squashfsMagic)
+	le.PutUint32(sb[4:], w.count)
+	le.PutUint32(sb[8:], uint32(modTime.Unix()))
+	le.PutUint32(sb[12:], squashfsBlockSize)
+	le.PutUint16(sb[20:], squashfsGzip)
+	le.PutUint16(sb[22:], squashfsBlockLog)
+	le.PutUint16(sb[24:], flags)
+	le.PutUint16(sb[26:], uint16(len(w.ids)))
+	le.PutUint16(sb[28:], 4)
+	le.PutUint64(sb[32:], root.ref)
+	le.PutUint64(sb[40:], bytesUsed)
+	le.PutUint64(sb[48:], idTableStart)
+	le.PutUint64(sb[56:], xattrTableStart)
+	le.PutUint64(sb[64:], inodeTableStart)
+	le.PutUint64(sb[72:], dirTableStart)
+	le.PutUint64(sb[80:], squashfsInvalid) // No fragment table / 无碎片表
+	le.PutUint64(sb[88:], squashfsInvalid) // No export table / 无导出表
+	if _, err := w.out.WriteAt(sb, 0); err != nil {
+		return err
+	}
+	return w.out.Truncate(int64((bytesUsed + 4095) / 4096 * 4096))
+}
@@ -0,0 +1,64 @@
+package packer
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// statFile returns the ownership, identity, device number and extended attributes of the file at path.
+// statFile 返回 path 处文件的属主、标识、设备号和扩展属性。
+func statFile(path string, info os.FileInfo) (fileStat, error) {
+	st := info.Sys().(*syscall.Stat_t)
+	major, minor := unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev))
+	stat := fileStat{
+		uid:   st.Uid,
+		gid:   st.Gid,
+		dev:   uint64(st.Dev),
+		ino:   st.Ino,
+		nlink: uint64(st.Nlink),
+		rdev:  minor&0xff | major<<8 | (minor&^0xff)<<12,
+	}
+	names, err := listXattrs(path)
+	if err != nil || len(names) == 0 {
+		return stat, err
+	}
+	stat.xattrs = map[string][]byte{}
+	for _, name := range names {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return stat, err
+		}
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(path, name, value); err != nil {
+			return stat, err
+		}
+		stat.xattrs[name] = value[:size]
+	}
+	return stat, nil
+}
+
+// listXattrs returns the names of the extended attributes of path, none if the file system has no support for them.
+// listXattrs 返回 path 的扩展属性名称；若文件系统不支持扩展属性则返回空。
+func listXattrs(path string) ([]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]byte, size)
+	if size, err = unix.Llistxattr(path, list); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
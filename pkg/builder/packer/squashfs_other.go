@@ -0,0 +1,15 @@
+//go:build !linux
+
+package packer
+
+import (
+	"os"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+// statFile is only supported on Linux, where images are built.
+// statFile 仅在构建镜像的 Linux 上受支持。
+func statFile(path string, info os.FileInfo) (fileStat, error) {
+	return fileStat{}, errors.New(errors.ErrTypeNotImplemented, "squashfs images are only supported on Linux")
+}
@@ -0,0 +1,306 @@
+package packer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// squashfsReader reads the images written by writeSquashfs.
+type squashfsReader struct {
+	t      *testing.T
+	image  []byte
+	sb     []byte
+	inodes metadataTable
+	dirs   metadataTable
+	ids    []uint32
+}
+
+// metadataTable is a decompressed metadata table and the offset of each of its blocks in it.
+type metadataTable struct {
+	data   []byte
+	blocks map[uint64]int
+}
+
+func openSquashfs(t *testing.T, path string) *squashfsReader {
+	t.Helper()
+	image, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Zero(t, len(image)%4096, "padded to 4 KiB")
+	r := &squashfsReader{t: t, image: image, sb: image[:96]}
+	require.Equal(t, uint32(squashfsMagic), r.u32(0))
+	require.Equal(t, uint16(4), r.u16(28), "major version")
+	require.Equal(t, uint16(squashfsGzip), r.u16(20))
+	idTable := r.u64(48)
+	idBlocks := binary.LittleEndian.Uint64(image[idTable:])
+	r.inodes = r.table(r.u64(64), r.u64(72))
+	r.dirs = r.table(r.u64(72), idBlocks)
+	ids := r.table(idBlocks, idTable)
+	for i := 0; i < int(r.u16(26)); i++ {
+		r.ids = append(r.ids, binary.LittleEndian.Uint32(ids.data[i*4:]))
+	}
+	return r
+}
+
+func (r *squashfsReader) u16(off int) uint16 { return binary.LittleEndian.Uint16(r.sb[off:]) }
+func (r *squashfsReader) u32(off int) uint32 { return binary.LittleEndian.Uint32(r.sb[off:]) }
+func (r *squashfsReader) u64(off int) uint64 { return binary.LittleEndian.Uint64(r.sb[off:]) }
+
+// table decompresses the metadata blocks from start to end.
+func (r *squashfsReader) table(start, end uint64) metadataTable {
+	m := metadataTable{blocks: map[uint64]int{}}
+	for pos := start; pos < end; {
+		header := binary.LittleEndian.Uint16(r.image[pos:])
+		size := uint64(header &^ squashfsMetadataUncompressed)
+		block := r.image[pos+2 : pos+2+size]
+		if header&squashfsMetadataUncompressed == 0 {
+			block = r.inflate(block)
+		}
+		require.LessOrEqual(r.t, len(block), squashfsMetadataSize)
+		m.blocks[pos-start] = len(m.data)
+		m.data = append(m.data, block...)
+		pos += 2 + size
+	}
+	return m
+}
+
+func (r *squashfsReader) inflate(data []byte) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	require.NoError(r.t, err)
+	out, err := io.ReadAll(zr)
+	require.NoError(r.t, err)
+	return out
+}
+
+// at returns the metadata at a reference of the table.
+func (m metadataTable) at(t *testing.T, block uint64, offset uint16) []byte {
+	start, ok := m.blocks[block]
+	require.True(t, ok, "no metadata block at %d", block)
+	return m.data[start+int(offset):]
+}
+
+// squashfsTestInode is a decoded inode.
+type squashfsTestInode struct {
+	typ, mode, uid, gid uint16
+	number, nlink       uint32
+	size                uint64
+	start               uint64
+	blocks              []uint32
+	target              string
+	dirBlock            uint32
+	dirOffset           uint16
+	parent              uint32
+	rdev                uint32
+	xattr               uint32
+}
+
+func (r *squashfsReader) inode(ref uint64) *squashfsTestInode {
+	b := r.inodes.at(r.t, ref>>16, uint16(ref))
+	le := binary.LittleEndian
+	in := &squashfsTestInode{typ: le.Uint16(b), mode: le.Uint16(b[2:]), number: le.Uint32(b[12:]), xattr: squashfsNoXattr}
+	in.uid, in.gid = uint16(r.ids[le.Uint16(b[4:])]), uint16(r.ids[le.Uint16(b[6:])])
+	b = b[16:]
+	blockCount := func() int {
+		return int((in.size + squashfsBlockSize - 1) / squashfsBlockSize)
+	}
+	switch in.typ {
+	case squashfsDirType:
+		in.dirBlock, in.nlink, in.size, in.dirOffset, in.parent = le.Uint32(b), le.Uint32(b[4:]), uint64(le.Uint16(b[8:])), le.Uint16(b[10:]), le.Uint32(b[12:])
+	case squashfsDirType + squashfsExtendedOffset:
+		in.nlink, in.size, in.dirBlock, in.parent, in.dirOffset, in.xattr = le.Uint32(b), uint64(le.Uint32(b[4:])), le.Uint32(b[8:]), le.Uint32(b[12:]), le.Uint16(b[18:]), le.Uint32(b[20:])
+	case squashfsFileType:
+		in.start, in.size, in.nlink = uint64(le.Uint32(b)), uint64(le.Uint32(b[12:])), 1
+		require.Equal(r.t, squashfsNoFragment, le.Uint32(b[4:]))
+		for i := 0; i < blockCount(); i++ {
+			in.blocks = append(in.blocks, le.Uint32(b[16+i*4:]))
+		}
+	case squashfsFileType + squashfsExtendedOffset:
+		in.start, in.size, in.nlink, in.xattr = le.Uint64(b), le.Uint64(b[8:]), le.Uint32(b[24:]), le.Uint32(b[36:])
+		for i := 0; i < blockCount(); i++ {
+			in.blocks = append(in.blocks, le.Uint32(b[40+i*4:]))
+		}
+	case squashfsSymlinkType, squashfsSymlinkType + squashfsExtendedOffset:
+		in.nlink = le.Uint32(b)
+		in.target = string(b[8 : 8+le.Uint32(b[4:])])
+	case squashfsBlockDevType, squashfsCharDevType:
+		in.nlink, in.rdev = le.Uint32(b), le.Uint32(b[4:])
+	default:
+		in.nlink = le.Uint32(b)
+	}
+	return in
+}
+
+// list returns the references of the entries of a directory, checking the directory headers.
+func (r *squashfsReader) list(dir *squashfsTestInode) map[string]uint64 {
+	require.Equal(r.t, uint16(squashfsDirType), dir.typ%squashfsExtendedOffset, "a directory")
+	b := r.dirs.at(r.t, uint64(dir.dirBlock), dir.dirOffset)[:dir.size-3]
+	le := binary.LittleEndian
+	entries := map[string]uint64{}
+	for len(b) > 0 {
+		count, block, base := le.Uint32(b)+1, le.Uint32(b[4:]), le.Uint32(b[8:])
+		require.LessOrEqual(r.t, count, uint32(squashfsDirCount))
+		b = b[12:]
+		for i := uint32(0); i < count; i++ {
+			offset, delta, typ, nameLen := le.Uint16(b), int16(le.Uint16(b[2:])), le.Uint16(b[4:]), int(le.Uint16(b[6:]))+1
+			name := string(b[8 : 8+nameLen])
+			ref := uint64(block)<<16 | uint64(offset)
+			in := r.inode(ref)
+			assert.Equal(r.t, uint32(int64(base)+int64(delta)), in.number, "inode number of %s", name)
+			basic := in.typ
+			if basic > squashfsExtendedOffset {
+				basic -= squashfsExtendedOffset
+			}
+			assert.Equal(r.t, basic, typ, "type of %s", name)
+			entries[name] = ref
+			b = b[8+nameLen:]
+		}
+	}
+	return entries
+}
+
+// lookup returns the inode at a slash-separated path.
+func (r *squashfsReader) lookup(path string) *squashfsTestInode {
+	in := r.inode(r.u64(32))
+	for _, name := range strings.Split(path, "/") {
+		ref, ok := r.list(in)[name]
+		require.True(r.t, ok, "%s is not in the image", path)
+		in = r.inode(ref)
+	}
+	return in
+}
+
+// read returns the content of a file inode.
+func (r *squashfsReader) read(in *squashfsTestInode) []byte {
+	var data []byte
+	pos := in.start
+	for i, block := range in.blocks {
+		size := uint64(block &^ squashfsDataUncompressed)
+		length := min(squashfsBlockSize, int(in.size)-i*squashfsBlockSize)
+		switch {
+		case size == 0:
+			data = append(data, make([]byte, length)...)
+		case block&squashfsDataUncompressed != 0:
+			data = append(data, r.image[pos:pos+size]...)
+		default:
+			data = append(data, r.inflate(r.image[pos:pos+size])...)
+		}
+		pos += size
+	}
+	return data
+}
+
+// xattrs returns the extended attributes of an inode.
+func (r *squashfsReader) xattrs(in *squashfsTestInode) map[string]string {
+	if in.xattr == squashfsNoXattr {
+		return nil
+	}
+	le := binary.LittleEndian
+	header := r.image[r.u64(56):]
+	kvStart, count := le.Uint64(header), le.Uint32(header[8:])
+	require.Less(r.t, in.xattr, count)
+	idBlocks := le.Uint64(header[16:])
+	ids := r.table(idBlocks, r.u64(56))
+	entry := ids.data[in.xattr*16:]
+	ref, pairs := le.Uint64(entry), le.Uint32(entry[8:])
+	b := r.table(kvStart, idBlocks).at(r.t, ref>>16, uint16(ref))
+	out := map[string]string{}
+	for i := uint32(0); i < pairs; i++ {
+		prefix, nameLen := le.Uint16(b), int(le.Uint16(b[2:]))
+		name := squashfsXattrPrefixes[prefix] + string(b[4:4+nameLen])
+		b = b[4+nameLen:]
+		valueLen := int(le.Uint32(b))
+		out[name] = string(b[4 : 4+valueLen])
+		b = b[4+valueLen:]
+	}
+	return out
+}
+
+func TestWriteSquashfs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating files of other users and device nodes needs root")
+	}
+	rootFS := t.TempDir()
+	random := make([]byte, 3*squashfsBlockSize+1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	sparse := append(bytes.Repeat([]byte("compressible "), squashfsBlockSize/13+1)[:squashfsBlockSize], make([]byte, 2*squashfsBlockSize)...)
+	sparse = append(sparse, []byte("end")...)
+	for path, content := range map[string][]byte{
+		"etc/os-release":   []byte("ID=test\n"),
+		"usr/bin/tool":     random,
+		"var/lib/sparse":   sparse,
+		"var/lib/empty":    nil,
+		"usr/bin/passwd":   []byte("setuid"),
+		"usr/share/shared": []byte("linked"),
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(rootFS, path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(rootFS, path), content, 0644))
+	}
+	// Enough entries for several directory headers.
+	for i := 0; i < 600; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(rootFS, "usr", "share", fmt.Sprintf("file-%03d", i)), nil, 0600))
+	}
+	require.NoError(t, os.Lchown(filepath.Join(rootFS, "usr/bin/passwd"), 1000, 1001))
+	require.NoError(t, os.Chmod(filepath.Join(rootFS, "usr/bin/passwd"), os.ModeSetuid|0755))
+	require.NoError(t, os.Chmod(filepath.Join(rootFS, "usr/bin"), 0750))
+	require.NoError(t, os.Link(filepath.Join(rootFS, "usr/share/shared"), filepath.Join(rootFS, "etc/shared")))
+	require.NoError(t, os.Symlink("../usr/bin/tool", filepath.Join(rootFS, "etc/tool")))
+	require.NoError(t, unix.Mkfifo(filepath.Join(rootFS, "etc/fifo"), 0600))
+	require.NoError(t, unix.Mknod(filepath.Join(rootFS, "etc/null"), syscall.S_IFCHR|0666, int(unix.Mkdev(1, 3))))
+	require.NoError(t, unix.Mknod(filepath.Join(rootFS, "etc/big"), syscall.S_IFBLK|0600, int(unix.Mkdev(259, 300))))
+	xattrs := unix.Lsetxattr(filepath.Join(rootFS, "usr/bin/tool"), "user.chasi-bod", []byte("tool"), 0) == nil
+
+	path := filepath.Join(t.TempDir(), "rootfs.sfs")
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, writeSquashfs(context.Background(), rootFS, path, modTime))
+	r := openSquashfs(t, path)
+	assert.Equal(t, uint32(modTime.Unix()), r.u32(8))
+
+	root := r.inode(r.u64(32))
+	assert.Equal(t, r.u32(4), root.number, "the root inode is the last one")
+	assert.Equal(t, root.number+1, root.parent)
+	assert.Equal(t, uint32(5), root.nlink, "the root has three subdirectories")
+
+	assert.Equal(t, "ID=test\n", string(r.read(r.lookup("etc/os-release"))))
+	tool := r.lookup("usr/bin/tool")
+	assert.Equal(t, random, r.read(tool))
+	assert.NotZero(t, tool.blocks[0]&squashfsDataUncompressed, "random data is stored uncompressed")
+	if xattrs {
+		assert.Equal(t, map[string]string{"user.chasi-bod": "tool"}, r.xattrs(tool))
+	}
+	sparseInode := r.lookup("var/lib/sparse")
+	assert.Equal(t, sparse, r.read(sparseInode))
+	assert.Equal(t, []uint32{0, 0}, sparseInode.blocks[1:3], "zero blocks are holes")
+	assert.Zero(t, sparseInode.blocks[0]&squashfsDataUncompressed)
+	assert.Empty(t, r.read(r.lookup("var/lib/empty")))
+
+	passwd := r.lookup("usr/bin/passwd")
+	assert.Equal(t, uint16(04755), passwd.mode)
+	assert.Equal(t, [2]uint16{1000, 1001}, [2]uint16{passwd.uid, passwd.gid})
+	assert.Equal(t, uint16(0750), r.lookup("usr/bin").mode)
+
+	shared, linked := r.lookup("usr/share/shared"), r.lookup("etc/shared")
+	assert.Equal(t, shared, linked, "hard links share the inode")
+	assert.Equal(t, uint16(squashfsFileType+squashfsExtendedOffset), shared.typ)
+	assert.Equal(t, uint32(2), shared.nlink)
+	assert.Equal(t, "linked", string(r.read(shared)))
+
+	assert.Equal(t, "../usr/bin/tool", r.lookup("etc/tool").target)
+	assert.Equal(t, uint16(squashfsFifoType), r.lookup("etc/fifo").typ)
+	assert.Equal(t, uint32(1<<8|3), r.lookup("etc/null").rdev)
+	assert.Equal(t, uint32(300&0xff|259<<8|(300&^0xff)<<12), r.lookup("etc/big").rdev)
+	assert.Len(t, r.list(r.lookup("usr/share")), 601)
+}
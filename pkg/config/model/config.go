@@ -21,6 +21,23 @@ type PlatformConfig struct {
 	// 添加其他顶层配置，例如 DFX 设置等
 	Output    OutputConfig `yaml:"output"` // Output configuration for the platform image / 平台镜像的输出配置
 	DFXConfig DFXConfig    `yaml:"dfx"`    // DFX (Design for Excellence) configuration / DFX（卓越设计）配置
+
+	// Phases declares custom script phases that are added to the deployment pipeline.
+	// Phases 声明添加到部署流水线中的自定义脚本阶段。
+	Phases []PhaseConfig `yaml:"phases,omitempty"`
+}
+
+// PhaseConfig declares a custom deployment phase that runs a shell script.
+// PhaseConfig 声明一个运行 shell 脚本的自定义部署阶段。
+type PhaseConfig struct {
+	Name      string          `yaml:"name"`      // Unique phase name / 唯一的阶段名称
+	Scope     enum.PhaseScope `yaml:"scope"`     // "node" (default) runs on every matching node, "cluster" runs once on the first master / "node"（默认）在每个匹配节点上运行，"cluster" 在第一个主节点上运行一次
+	DependsOn []string        `yaml:"dependsOn"` // Phases that must complete first (e.g., "os", "kubernetes") / 必须先完成的阶段（例如，“os”、“kubernetes”）
+	Roles     []enum.NodeRole `yaml:"roles"`     // Node roles the phase applies to; empty means all nodes / 阶段适用的节点角色；为空表示所有节点
+	Script    string          `yaml:"script"`    // Shell script to run / 要运行的 shell 脚本
+	Sudo      bool            `yaml:"sudo"`      // Whether to run the script with sudo / 是否使用 sudo 运行脚本
+	Check     string          `yaml:"check"`     // Optional command that succeeds when the script has nothing to do; the script is then skipped / 可选命令，当脚本无需执行时成功，此时跳过脚本
+	Timeout   string          `yaml:"timeout"`   // Timeout per node, as a Go duration (e.g., "10m") / 每个节点的超时时间，Go duration 格式（例如，“10m”）
 }
 
 // Metadata contains metadata for the configuration.
@@ -39,6 +56,14 @@ type OutputConfig struct {
 	// Add format-specific options as needed
 	// 根据需要添加格式特定的选项
 	ImageName string `yaml:"imageName"` // Name for the output image file / 输出镜像文件的名称
+	// DiskSize is the size of raw and qcow2 disk images (e.g. "20Gi"); by default the root filesystem gets a quarter
+	// more than its content plus 512Mi.
+	// DiskSize 是 raw 和 qcow2 磁盘镜像的大小（例如 "20Gi"）；默认情况下根文件系统获得比其内容多四分之一再加 512Mi 的空间。
+	DiskSize string `yaml:"diskSize,omitempty"`
+	// InstallDisk is the disk the iso installer writes the platform to (e.g. "/dev/sda"); when empty, it is chosen at
+	// boot by adding chasi-bod.install=<disk> to the kernel command line.
+	// InstallDisk 是 iso 安装程序写入平台的磁盘（例如 "/dev/sda"）；为空时，在引导时通过向内核命令行添加 chasi-bod.install=<disk> 选择。
+	InstallDisk string `yaml:"installDisk,omitempty"`
 }
 
 // ClusterConfig represents the configuration for the Host Cluster.
@@ -50,11 +75,81 @@ type ClusterConfig struct {
 	Network           types.NetworkConfig `yaml:"network"`           // Network configuration / 网络配置
 	Storage           types.StorageConfig `yaml:"storage"`           // Storage configuration / 存储配置
 	Nodes             []NodeConfig        `yaml:"nodes"`             // Node configurations for deployment / 部署的节点配置
-	// Add other host cluster specific configurations like apiserver cert sans etc.
-	// 添加其他 Host Cluster 特定配置，例如 apiserver 证书 sans 等
+	CgroupDriver      string              `yaml:"cgroupDriver"`      // Kubelet cgroup driver, "systemd" (default) or "cgroupfs" / Kubelet cgroup 驱动，"systemd"（默认）或 "cgroupfs"
+	APIServerCertSANs []string            `yaml:"apiServerCertSANs"` // Extra SANs for the API server certificate; master addresses are always included / API 服务器证书的额外 SAN；始终包含主节点地址
+	// ControlPlaneEndpoint is the stable API server endpoint of a multi-master cluster. Without it the first master is used.
+	// ControlPlaneEndpoint 是多主节点集群的稳定 API 服务器端点。未设置时使用第一个主节点。
+	ControlPlaneEndpoint *ControlPlaneEndpointConfig `yaml:"controlPlaneEndpoint,omitempty"`
+	// RuntimeConfig tunes the container runtime configuration generated for containerd and CRI-O.
+	// RuntimeConfig 调整为 containerd 和 CRI-O 生成的容器运行时配置。
+	RuntimeConfig ContainerRuntimeConfig `yaml:"runtimeConfig,omitempty"`
+	// Firewall enables the host firewall generated from node roles. Without it the host firewall is left alone.
+	// Firewall 启用根据节点角色生成的主机防火墙。未设置时不改动主机防火墙。
+	Firewall *FirewallConfig `yaml:"firewall,omitempty"`
+	// ArtifactDir is the directory of the platform artifact. When set the installation is air-gapped:
+	// CNI manifests and image references are taken from it instead of the internet.
+	// ArtifactDir 是平台制品的目录。设置后安装处于离线模式：CNI 清单和镜像引用从中获取，而不是从互联网获取。
+	ArtifactDir string `yaml:"artifactDir,omitempty"`
+	// Add other host cluster specific configurations
+	// 添加其他 Host Cluster 特定配置
 	BaseOS BaseOSConfig `yaml:"baseOS"` // Base OS configuration for the image builder / 镜像构建器的基础操作系统配置
 }
 
+// ControlPlaneEndpointConfig describes how the API servers of the Host Cluster are reached through one address.
+// ControlPlaneEndpointConfig 描述如何通过一个地址访问 Host 集群的 API 服务器。
+type ControlPlaneEndpointConfig struct {
+	Mode            enum.ControlPlaneEndpointMode `yaml:"mode"`            // "kube-vip", "keepalived" or "external" / "kube-vip"、"keepalived" 或 "external"
+	Address         string                        `yaml:"address"`         // Virtual IP, or load balancer IP/DNS name for "external" / 虚拟 IP，或 "external" 模式下的负载均衡器 IP/DNS 名称
+	Port            int                           `yaml:"port"`            // Endpoint port; 6443 by default, 8443 for "keepalived" where haproxy shares the masters with the API servers / 端点端口；默认 6443，"keepalived" 模式下为 8443，因为 haproxy 与 API 服务器共享主节点
+	Interface       string                        `yaml:"interface"`       // Master network interface carrying the virtual IP / 承载虚拟 IP 的主节点网络接口
+	KubeVIPImage    string                        `yaml:"kubeVIPImage"`    // kube-vip image; a pinned default is used when empty / kube-vip 镜像；为空时使用固定的默认版本
+	VirtualRouterID int                           `yaml:"virtualRouterID"` // VRRP router ID for "keepalived", unique per network segment (default 51) / "keepalived" 的 VRRP 路由器 ID，在每个网段内唯一（默认 51）
+}
+
+// ContainerRuntimeConfig holds the settings written to the container runtime configuration of every node.
+// ContainerRuntimeConfig 保存写入每个节点容器运行时配置的设置。
+type ContainerRuntimeConfig struct {
+	SandboxImage string           `yaml:"sandboxImage,omitempty"` // Pod sandbox (pause) image; a pinned default is used when empty / Pod 沙箱（pause）镜像；为空时使用固定的默认版本
+	DataRoot     string           `yaml:"dataRoot,omitempty"`     // Directory for images and container state; the runtime default is used when empty / 镜像和容器状态的目录；为空时使用运行时默认值
+	Registries   []RegistryConfig `yaml:"registries,omitempty"`   // Registry mirrors, credentials and TLS settings / 镜像仓库镜像、凭据和 TLS 设置
+}
+
+// RegistryConfig describes how the container runtime reaches one image registry.
+// RegistryConfig 描述容器运行时如何访问一个镜像仓库。
+// Certificate and key files are read on the machine running chasi-bod and copied to the nodes.
+// 证书和密钥文件在运行 chasi-bod 的机器上读取并复制到节点。
+type RegistryConfig struct {
+	Host               string   `yaml:"host"`                         // Registry host with optional port, e.g. "docker.io" or "registry.example.com:5000" / 带可选端口的仓库主机，例如 "docker.io" 或 "registry.example.com:5000"
+	Mirrors            []string `yaml:"mirrors,omitempty"`            // Mirror URLs tried in order before the registry itself / 在仓库本身之前按顺序尝试的镜像 URL
+	Username           string   `yaml:"username,omitempty"`           // Username for the registry / 仓库用户名
+	Password           string   `yaml:"password,omitempty"`           // Password or token for the registry / 仓库密码或令牌
+	CAFile             string   `yaml:"caFile,omitempty"`             // CA certificate trusted for the registry and its mirrors / 为仓库及其镜像信任的 CA 证书
+	CertFile           string   `yaml:"certFile,omitempty"`           // Client certificate for mutual TLS / 双向 TLS 的客户端证书
+	KeyFile            string   `yaml:"keyFile,omitempty"`            // Client key for mutual TLS / 双向 TLS 的客户端密钥
+	InsecureSkipVerify bool     `yaml:"insecureSkipVerify,omitempty"` // Skip TLS verification of the registry and its mirrors / 跳过仓库及其镜像的 TLS 验证
+}
+
+// FirewallConfig describes the host firewall of the Host Cluster nodes.
+// FirewallConfig 描述 Host 集群节点的主机防火墙。
+// Each node only accepts the ports its roles and the CNI plugin need, SSH, and the allowlist; everything else is dropped.
+// 每个节点只接受其角色和 CNI 插件所需的端口、SSH 以及允许列表；其他一切都会被丢弃。
+type FirewallConfig struct {
+	Enabled       bool                 `yaml:"enabled"`                 // Whether chasi-bod manages the host firewall / chasi-bod 是否管理主机防火墙
+	Backend       enum.FirewallBackend `yaml:"backend,omitempty"`       // "nftables" or "iptables"; empty uses nftables where available / "nftables" 或 "iptables"；为空时在可用处使用 nftables
+	NodePortRange string               `yaml:"nodePortRange,omitempty"` // Service NodePort range, "30000-32767" by default / Service NodePort 范围，默认为 "30000-32767"
+	Allow         []FirewallRule       `yaml:"allow,omitempty"`         // Additional allowed traffic / 额外允许的流量
+}
+
+// FirewallRule allows incoming traffic to a port or port range.
+// FirewallRule 允许到某个端口或端口范围的入站流量。
+type FirewallRule struct {
+	Description string          `yaml:"description,omitempty"` // Shown as the rule comment / 显示为规则注释
+	Protocol    string          `yaml:"protocol,omitempty"`    // "tcp" (default) or "udp" / "tcp"（默认）或 "udp"
+	Ports       string          `yaml:"ports"`                 // A port or range, e.g. "9100" or "8000-8080" / 端口或范围，例如 "9100" 或 "8000-8080"
+	Sources     []string        `yaml:"sources,omitempty"`     // Allowed source addresses or CIDRs; empty allows any / 允许的源地址或 CIDR；为空时允许任意来源
+	Roles       []enum.NodeRole `yaml:"roles,omitempty"`       // Node roles the rule applies to; empty means all nodes / 规则适用的节点角色；为空表示所有节点
+}
+
 // BaseOSConfig represents the base OS configuration for the image builder.
 // BaseOSConfig 表示镜像构建器的基础操作系统配置。
 type BaseOSConfig struct {
@@ -64,8 +159,20 @@ type BaseOSConfig struct {
 	Files             []FileConfig       `yaml:"files"`             // List of files to copy into the image / 要复制到镜像中的文件列表
 	Commands          []string           `yaml:"commands"`          // List of shell commands to run during build / 构建期间要运行的 shell 命令列表
 	SysctlConfig      types.SysctlConfig `yaml:"sysctl"`            // Base OS sysctl configuration / 基础操作系统 sysctl 配置
-	SSHAuthorizedKeys []string           `yaml:"sshAuthorizedKeys"` // SSH authorized keys to add / 要添加的 SSH 授权密钥
+	SSHAuthorizedKeys []string           `yaml:"sshAuthorizedKeys"` // SSH authorized keys to add for root / 要为 root 添加的 SSH 授权密钥
 	Users             []UserConfig       `yaml:"users"`             // Users to create / 要创建的用户
+	// ImageArchive is an OCI image layout directory, or a tarball of one or of "docker save", holding Image.
+	// The root filesystem is unpacked from it, so builds work offline.
+	// ImageArchive 是包含 Image 的 OCI 镜像布局目录，或其 tar 包，或 "docker save" 的 tar 包。根文件系统从中解包，因此构建可离线进行。
+	ImageArchive string `yaml:"imageArchive,omitempty"`
+	// SSHAuthorizedKeysExclusive makes the declared keys the only ones of root and of the declared users,
+	// removing keys that are no longer declared; by default other keys are kept.
+	// SSHAuthorizedKeysExclusive 使声明的密钥成为 root 和已声明用户的唯一密钥，并删除不再声明的密钥；默认保留其他密钥。
+	SSHAuthorizedKeysExclusive bool `yaml:"sshAuthorizedKeysExclusive,omitempty"`
+	// PackageRepositories replace the package sources of the image while the build installs packages, e.g. with a local
+	// file:// mirror for air-gapped builds; the sources of the image are restored before it is packaged.
+	// PackageRepositories 在构建安装软件包期间替换镜像的软件包源，例如用于离线构建的本地 file:// 镜像源；打包前会恢复镜像的源。
+	PackageRepositories []PackageRepositoryConfig `yaml:"packageRepositories,omitempty"`
 }
 
 // FileConfig represents a file to copy into the image during the build process.
@@ -76,8 +183,19 @@ type FileConfig struct {
 	Mode   string `yaml:"mode"`   // File permissions (e.g., "0644", "0755") as octal string / 文件权限（例如，“0644”、“0755”），八进制字符串
 }
 
-// UserConfig represents a user to create in the image during the build process.
-// UserConfig 表示在构建过程中要在镜像中创建的用户。
+// PackageRepositoryConfig represents a package repository used while building the image.
+// PackageRepositoryConfig 表示构建镜像期间使用的软件包仓库。
+type PackageRepositoryConfig struct {
+	Name       string   `yaml:"name"`                 // Repository name, used in the generated source files / 仓库名称，用于生成的源文件
+	URL        string   `yaml:"url"`                  // http(s):// URL, or file:// directory on the build machine / http(s):// URL，或构建机器上的 file:// 目录
+	Suite      string   `yaml:"suite,omitempty"`      // apt suite; defaults to the codename of the image, a path ending in "/" for flat repositories / apt 套件；默认为镜像的代号，平面仓库使用以 "/" 结尾的路径
+	Components []string `yaml:"components,omitempty"` // apt components; defaults to "main" / apt 组件；默认为 "main"
+	GPGKey     string   `yaml:"gpgKey,omitempty"`     // Signing key file on the build machine; defaults to the keys of the image / 构建机器上的签名密钥文件；默认使用镜像中的密钥
+	Trusted    bool     `yaml:"trusted,omitempty"`    // Skip signature checks, e.g. for an unsigned local mirror / 跳过签名检查，例如用于未签名的本地镜像源
+}
+
+// UserConfig represents a user to create in the image during the build process and on the nodes at deploy time.
+// UserConfig 表示在构建过程中要在镜像中、以及在部署时要在节点上创建的用户。
 type UserConfig struct {
 	Name     string   `yaml:"name"`     // Username / 用户名
 	Password string   `yaml:"password"` // Password hash (use secure methods) / 密码哈希（使用安全方法）
@@ -87,6 +205,9 @@ type UserConfig struct {
 	Shell    string   `yaml:"shell"`    // User's default shell / 用户的默认 shell
 	HomeDir  string   `yaml:"homeDir"`  // User's home directory / 用户的家目录
 	Sudo     bool     `yaml:"sudo"`     // Whether to grant sudo access / 是否授予 sudo 权限
+	// SSHAuthorizedKeys are the SSH public keys allowed to log in as the user.
+	// SSHAuthorizedKeys 是允许以该用户身份登录的 SSH 公钥。
+	SSHAuthorizedKeys []string `yaml:"sshAuthorizedKeys,omitempty"`
 }
 
 // NodeConfig represents the configuration for a node during deployment.
@@ -105,6 +226,15 @@ type NodeConfig struct {
 	// Add more node specific configurations like disks, mount points etc.
 	// 添加更多节点特定配置，例如磁盘、挂载点等
 	DiskConfigs []DiskConfig `yaml:"diskConfigs"` // Disk configurations for partitioning and mounting / 磁盘配置用于分区和挂载
+	// Interfaces configures host interfaces of this node; they replace cluster-level interfaces of the same name.
+	// Interfaces 配置此节点的主机接口；它们会替换集群级别的同名接口。
+	Interfaces []types.InterfaceConfig `yaml:"interfaces,omitempty"`
+	// DNS replaces the cluster-level resolver configuration for this node.
+	// DNS 为此节点替换集群级别的解析器配置。
+	DNS *types.DNSConfig `yaml:"dns,omitempty"`
+	// InsecureSkipHostKeyCheck accepts any SSH host key of the node. Without it the key must be in ~/.ssh/known_hosts.
+	// InsecureSkipHostKeyCheck 接受节点的任何 SSH 主机密钥。未设置时该密钥必须在 ~/.ssh/known_hosts 中。
+	InsecureSkipHostKeyCheck bool `yaml:"insecureSkipHostKeyCheck,omitempty"`
 }
 
 // DiskConfig represents configuration for a disk on a node.
@@ -116,6 +246,11 @@ type DiskConfig struct {
 	Filesystem string `yaml:"filesystem"` // Filesystem type (e.g., "ext4", "xfs") / 文件系统类型（例如，“ext4”、“xfs”）
 	MountPoint string `yaml:"mountPoint"` // Mount point (e.g., "/var/lib/chasi-bod") / 挂载点（例如，“/var/lib/chasi-bod”）
 	Format     bool   `yaml:"format"`     // Whether to format the disk / 是否格式化磁盘
+	// ConfirmWipe allows formatting a disk that already holds a filesystem, partitions or other data.
+	// Without it such a disk is refused; a mounted disk is refused either way.
+	// ConfirmWipe 允许格式化已包含文件系统、分区或其他数据的磁盘。
+	// 未设置时此类磁盘会被拒绝；已挂载的磁盘无论如何都会被拒绝。
+	ConfirmWipe bool `yaml:"confirmWipe,omitempty"`
 }
 
 // VClusterConfig represents the configuration for a virtual cluster.
@@ -5,6 +5,10 @@ package validator
 import (
 	"fmt"
 	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/turtacn/chasi-bod/common/errors"
@@ -12,6 +16,7 @@ import (
 	"github.com/turtacn/chasi-bod/common/types/enum"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming utils are needed for IP/Hostname validation and logger
 	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // ValidateConfig validates the entire PlatformConfig structure.
@@ -79,6 +84,12 @@ func ValidateConfig(config *model.PlatformConfig) error {
 		return fmt.Errorf("invalid dfx configuration: %w", err)
 	}
 
+	// Validate custom phases
+	// 校验自定义阶段
+	if err := validatePhaseConfigs(config.Phases); err != nil {
+		return fmt.Errorf("invalid phases configuration: %w", err)
+	}
+
 	// TODO: Add cross-resource validation (e.g., check for port conflicts across vclusters/host)
 	// TODO: 添加跨资源校验（例如，检查 vcluster/host 之间的端口冲突）
 
@@ -94,7 +105,7 @@ func validateOutputConfig(config *model.OutputConfig) error {
 	// Basic format validation (check if it's a known enum value)
 	// 基本格式校验（检查是否是已知枚举值）
 	switch config.Format {
-	case enum.OutputFormatISO, enum.OutputFormatQCOW2, enum.OutputFormatOVA, enum.OutputFormatVMA:
+	case enum.OutputFormatISO, enum.OutputFormatQCOW2, enum.OutputFormatRaw, enum.OutputFormatOVA, enum.OutputFormatVMA:
 		// Valid formats
 		// 有效格式
 	default:
@@ -105,6 +116,22 @@ func validateOutputConfig(config *model.OutputConfig) error {
 		// 如果未提供，可以派生默认镜像名称，但这会简化当前的情况，因此将其设为必需。
 		return errors.New(errors.ErrTypeValidation, "output.imageName is required")
 	}
+	if config.DiskSize != "" {
+		if config.Format != enum.OutputFormatQCOW2 && config.Format != enum.OutputFormatRaw {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("output.diskSize only applies to the qcow2 and raw formats, not '%s'", config.Format))
+		}
+		if size, err := resource.ParseQuantity(config.DiskSize); err != nil || size.Sign() <= 0 {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("output.diskSize '%s' is not a positive quantity (e.g. 20Gi)", config.DiskSize))
+		}
+	}
+	if config.InstallDisk != "" {
+		if config.Format != enum.OutputFormatISO {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("output.installDisk only applies to the iso format, not '%s'", config.Format))
+		}
+		if !strings.HasPrefix(config.InstallDisk, "/dev/") || strings.ContainsAny(config.InstallDisk, " \t\n'\"") {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("output.installDisk '%s' is not a device path (e.g. /dev/sda)", config.InstallDisk))
+		}
+	}
 	return nil
 }
 
@@ -126,6 +153,30 @@ func validateClusterConfig(config *model.ClusterConfig) error {
 		return fmt.Errorf("invalid cluster.network configuration: %w", err)
 	}
 
+	switch config.CgroupDriver {
+	case "", "systemd", "cgroupfs":
+	default:
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid cluster.cgroupDriver '%s' (use systemd or cgroupfs)", config.CgroupDriver))
+	}
+	for _, san := range config.APIServerCertSANs {
+		if strings.TrimSpace(san) == "" {
+			return errors.New(errors.ErrTypeValidation, "cluster.apiServerCertSANs must not contain empty entries")
+		}
+	}
+	if config.ControlPlaneEndpoint != nil {
+		if err := validateControlPlaneEndpoint(config.ControlPlaneEndpoint); err != nil {
+			return err
+		}
+	}
+	if config.Firewall != nil {
+		if err := validateFirewallConfig(config.Firewall); err != nil {
+			return err
+		}
+	}
+	if err := validateContainerRuntimeConfig(&config.RuntimeConfig); err != nil {
+		return err
+	}
+
 	// Validate StorageConfig
 	// 校验存储配置
 	if err := validateStorageConfig(&config.Storage); err != nil {
@@ -151,6 +202,23 @@ func validateClusterConfig(config *model.ClusterConfig) error {
 	if !hasMaster {
 		return errors.New(errors.ErrTypeValidation, "cluster.nodes must contain at least one node with role 'master'")
 	}
+	// HostPath PersistentVolumes can only be pinned to nodes of the cluster
+	// HostPath PersistentVolume 只能固定到集群中的节点
+	for _, pvCfg := range config.Storage.PVConfigs {
+		if pvCfg.Node == "" {
+			continue
+		}
+		found := false
+		for _, nodeCfg := range config.Nodes {
+			if nodeCfg.Address == pvCfg.Node {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.storage.pvConfigs '%s' node '%s' is not a cluster node", pvCfg.Name, pvCfg.Node))
+		}
+	}
 
 	// Validate BaseOSConfig
 	// 校验基础操作系统配置
@@ -182,23 +250,139 @@ func validateBaseOSConfig(config *model.BaseOSConfig) error {
 		if fileCfg.Source == "" || fileCfg.Dest == "" {
 			return errors.New(errors.ErrTypeValidation, "cluster.baseOS.files requires source and dest paths")
 		}
-		// TODO: Validate file permissions format
-		// TODO: 校验文件权限格式
+		if !path.IsAbs(fileCfg.Dest) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.files '%s': dest must be an absolute path", fileCfg.Dest))
+		}
+		if fileCfg.Mode != "" {
+			if mode, err := strconv.ParseUint(fileCfg.Mode, 8, 32); err != nil || mode > 07777 {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.files '%s': mode '%s' is not an octal permission", fileCfg.Dest, fileCfg.Mode))
+			}
+		}
+	}
+
+	// Validate package repositories
+	// 校验软件包仓库
+	seenRepos := map[string]bool{}
+	for _, repoCfg := range config.PackageRepositories {
+		if !isValidRepositoryName(repoCfg.Name) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.packageRepositories '%s': name must be lower-case letters, digits, dots, underscores or dashes", repoCfg.Name))
+		}
+		if seenRepos[repoCfg.Name] {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.packageRepositories '%s' is declared more than once", repoCfg.Name))
+		}
+		seenRepos[repoCfg.Name] = true
+		u, err := url.Parse(repoCfg.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") || (u.Scheme == "file" && !path.IsAbs(u.Path)) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.packageRepositories '%s': url must be an http(s):// URL or a file:// absolute path", repoCfg.Name))
+		}
+		if repoCfg.Trusted && repoCfg.GPGKey != "" {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.packageRepositories '%s': gpgKey is never checked for a trusted repository", repoCfg.Name))
+		}
 	}
 
 	// Validate Users
 	// 校验用户配置
+	seenUsers := map[string]bool{}
 	for _, userCfg := range config.Users {
 		if userCfg.Name == "" {
 			return errors.New(errors.ErrTypeValidation, "cluster.baseOS.users requires user name")
 		}
-		// TODO: Add password/ssh key requirements based on usage
-		// TODO: 根据使用情况添加密码/ssh 密钥要求
+		if !isValidAccountName(userCfg.Name) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.users '%s': invalid user name", userCfg.Name))
+		}
+		if userCfg.Name == "root" {
+			return errors.New(errors.ErrTypeValidation, "cluster.baseOS.users cannot declare root; use cluster.baseOS.sshAuthorizedKeys for its keys")
+		}
+		if seenUsers[userCfg.Name] {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.users '%s' is declared more than once", userCfg.Name))
+		}
+		seenUsers[userCfg.Name] = true
+		// The password is written to /etc/shadow as is, so it must already be a crypt(3) hash or a locked marker.
+		// 密码按原样写入 /etc/shadow，因此它必须已经是 crypt(3) 哈希或锁定标记。
+		if userCfg.Password != "" && !strings.HasPrefix(userCfg.Password, "$") && !strings.HasPrefix(userCfg.Password, "!") && userCfg.Password != "*" {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.users '%s': password must be a crypt hash (e.g. from 'openssl passwd -6'), not plain text", userCfg.Name))
+		}
+		if userCfg.Shell != "" && !path.IsAbs(userCfg.Shell) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.users '%s': shell must be an absolute path", userCfg.Name))
+		}
+		if userCfg.HomeDir != "" && !path.IsAbs(userCfg.HomeDir) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.users '%s': homeDir must be an absolute path", userCfg.Name))
+		}
+		for _, id := range []*int{userCfg.UID, userCfg.GID} {
+			if id != nil && *id < 0 {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.users '%s': uid and gid must not be negative", userCfg.Name))
+			}
+		}
+		for _, group := range userCfg.Groups {
+			if !isValidAccountName(group) {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.baseOS.users '%s': invalid group name '%s'", userCfg.Name, group))
+			}
+		}
+		if err := validateAuthorizedKeys(userCfg.SSHAuthorizedKeys); err != nil {
+			return fmt.Errorf("cluster.baseOS.users '%s': %w", userCfg.Name, err)
+		}
+	}
+	if err := validateAuthorizedKeys(config.SSHAuthorizedKeys); err != nil {
+		return fmt.Errorf("cluster.baseOS: %w", err)
+	}
+	// An empty exclusive list would remove every key of root, including the one the deployer may log in with.
+	// 空的独占列表会删除 root 的所有密钥，包括部署器可能用于登录的密钥。
+	if config.SSHAuthorizedKeysExclusive && len(config.SSHAuthorizedKeys) == 0 {
+		return errors.New(errors.ErrTypeValidation, "cluster.baseOS.sshAuthorizedKeysExclusive requires at least one key in cluster.baseOS.sshAuthorizedKeys")
 	}
 
 	return nil
 }
 
+// isValidAccountName reports whether name is a portable user or group name: a lower-case letter or underscore
+// followed by up to 31 lower-case letters, digits, underscores, dots or dashes.
+// isValidAccountName 报告 name 是否为可移植的用户名或组名：以小写字母或下划线开头，后跟最多 31 个小写字母、数字、下划线、点或连字符。
+func isValidAccountName(name string) bool {
+	if len(name) == 0 || len(name) > 32 {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isValidRepositoryName reports whether name can be used in the file names and section headers of package sources.
+// isValidRepositoryName 报告 name 是否可用于软件包源的文件名和节标题。
+func isValidRepositoryName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case i > 0 && (c == '-' || c == '.' || c == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateAuthorizedKeys checks that every SSH key is a single non-empty line.
+// validateAuthorizedKeys 检查每个 SSH 密钥都是单个非空行。
+func validateAuthorizedKeys(keys []string) error {
+	for _, key := range keys {
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(key, "\r\n") {
+			return errors.New(errors.ErrTypeValidation, "sshAuthorizedKeys entries must be single non-empty lines")
+		}
+		if len(strings.Fields(key)) < 2 {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("sshAuthorizedKeys entry '%s' is not a public key (expected '<type> <key> [comment]')", key))
+		}
+	}
+	return nil
+}
+
 // validateNodeConfig validates a single NodeConfig.
 // validateNodeConfig 校验单个 NodeConfig。
 func validateNodeConfig(config *model.NodeConfig) error {
@@ -252,22 +436,162 @@ func validateNodeConfig(config *model.NodeConfig) error {
 		return fmt.Errorf("node %s: invalid sysctl configuration: %w", config.Address, err)
 	}
 
+	// Validate host interfaces and DNS
+	// 校验主机接口和 DNS
+	if err := validateInterfaces("node "+config.Address, config.Interfaces, config.DNS); err != nil {
+		return err
+	}
+
 	// Validate DiskConfigs
 	// 校验磁盘配置
+	devices := make(map[string]bool)
+	mountPoints := make(map[string]bool)
 	for _, diskCfg := range config.DiskConfigs {
 		if diskCfg.Device == "" {
 			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s: disk config requires device name", config.Address))
 		}
+		if !strings.HasPrefix(diskCfg.Device, "/dev/") {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s, disk %s: device must be a path under /dev", config.Address, diskCfg.Device))
+		}
+		if devices[diskCfg.Device] {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s: disk %s is configured more than once", config.Address, diskCfg.Device))
+		}
+		devices[diskCfg.Device] = true
 		if diskCfg.MountPoint != "" && diskCfg.Filesystem == "" {
 			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s, disk %s: filesystem is required if mountPoint is specified", config.Address, diskCfg.Device))
 		}
-		// TODO: Add stricter validation for device names, filesystems, mount points
-		// TODO: 对设备名称、文件系统、挂载点添加更严格的校验
+		if diskCfg.MountPoint != "" {
+			if !path.IsAbs(diskCfg.MountPoint) || path.Clean(diskCfg.MountPoint) == "/" {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s, disk %s: mountPoint must be an absolute path other than /", config.Address, diskCfg.Device))
+			}
+			if mountPoints[path.Clean(diskCfg.MountPoint)] {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s: mount point %s is used by more than one disk", config.Address, diskCfg.MountPoint))
+			}
+			mountPoints[path.Clean(diskCfg.MountPoint)] = true
+		}
+		if diskCfg.ConfirmWipe && !diskCfg.Format {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s, disk %s: confirmWipe only applies together with format", config.Address, diskCfg.Device))
+		}
+	}
+
+	return nil
+}
+
+// validateControlPlaneEndpoint validates the ControlPlaneEndpointConfig.
+// validateControlPlaneEndpoint 校验 ControlPlaneEndpointConfig。
+func validateControlPlaneEndpoint(config *model.ControlPlaneEndpointConfig) error {
+	switch config.Mode {
+	case enum.EndpointModeKubeVIP, enum.EndpointModeKeepalived:
+		// A virtual IP is announced by the masters themselves, so it must be an IP on an interface they share.
+		// 虚拟 IP 由主节点自身通告，因此它必须是主节点共享接口上的 IP。
+		if net.ParseIP(config.Address) == nil {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.controlPlaneEndpoint.address '%s' must be a virtual IP address in %s mode", config.Address, config.Mode))
+		}
+		if config.Interface == "" {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.controlPlaneEndpoint.interface is required in %s mode", config.Mode))
+		}
+	case enum.EndpointModeExternal:
+		if config.Address == "" {
+			return errors.New(errors.ErrTypeValidation, "cluster.controlPlaneEndpoint.address is required")
+		}
+	default:
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid cluster.controlPlaneEndpoint.mode '%s' (use kube-vip, keepalived or external)", config.Mode))
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid cluster.controlPlaneEndpoint.port %d", config.Port))
+	}
+	if config.VirtualRouterID < 0 || config.VirtualRouterID > 255 {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid cluster.controlPlaneEndpoint.virtualRouterID %d (use 1-255)", config.VirtualRouterID))
+	}
+	return nil
+}
+
+// validateContainerRuntimeConfig validates the ContainerRuntimeConfig.
+// validateContainerRuntimeConfig 校验 ContainerRuntimeConfig。
+func validateContainerRuntimeConfig(config *model.ContainerRuntimeConfig) error {
+	if config.DataRoot != "" && !path.IsAbs(config.DataRoot) {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cluster.runtimeConfig.dataRoot '%s' must be an absolute path", config.DataRoot))
+	}
+	hosts := make(map[string]bool)
+	for i, registry := range config.Registries {
+		field := fmt.Sprintf("cluster.runtimeConfig.registries[%d]", i)
+		if registry.Host == "" || strings.ContainsAny(registry.Host, "/ ") {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid host '%s' (use a host name with an optional port, without scheme)", field, registry.Host))
+		}
+		if hosts[registry.Host] {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: duplicate host '%s'", field, registry.Host))
+		}
+		hosts[registry.Host] = true
+		for _, mirror := range registry.Mirrors {
+			u, err := url.Parse(mirror)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid mirror '%s' (use an http:// or https:// URL)", field, mirror))
+			}
+		}
+		if registry.Password != "" && registry.Username == "" {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: password requires username", field))
+		}
+		if (registry.CertFile == "") != (registry.KeyFile == "") {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: certFile and keyFile must be set together", field))
+		}
 	}
+	return nil
+}
 
+// validateFirewallConfig validates the FirewallConfig.
+// validateFirewallConfig 校验 FirewallConfig。
+func validateFirewallConfig(config *model.FirewallConfig) error {
+	switch config.Backend {
+	case "", enum.FirewallBackendNFTables, enum.FirewallBackendIPTables:
+	default:
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid cluster.firewall.backend '%s' (use nftables or iptables)", config.Backend))
+	}
+	if config.NodePortRange != "" && !validPortRange(config.NodePortRange) {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid cluster.firewall.nodePortRange '%s' (use first-last)", config.NodePortRange))
+	}
+	for i, rule := range config.Allow {
+		field := fmt.Sprintf("cluster.firewall.allow[%d]", i)
+		switch rule.Protocol {
+		case "", "tcp", "udp":
+		default:
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid protocol '%s' (use tcp or udp)", field, rule.Protocol))
+		}
+		if !validPortRange(rule.Ports) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid ports '%s' (use a port or first-last)", field, rule.Ports))
+		}
+		for _, source := range rule.Sources {
+			if net.ParseIP(source) == nil {
+				if _, _, err := net.ParseCIDR(source); err != nil {
+					return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid source '%s' (use an IP address or CIDR)", field, source))
+				}
+			}
+		}
+		for _, role := range rule.Roles {
+			switch role {
+			case enum.RoleMaster, enum.RoleWorker, enum.RoleEdge:
+			default:
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid role '%s'", field, role))
+			}
+		}
+	}
 	return nil
 }
 
+// validPortRange reports whether s is a port or a "first-last" port range within 1-65535.
+// validPortRange 报告 s 是否为 1-65535 内的端口或 "first-last" 端口范围。
+func validPortRange(s string) bool {
+	first, last, isRange := strings.Cut(s, "-")
+	low, err := strconv.Atoi(first)
+	if err != nil || low < 1 || low > 65535 {
+		return false
+	}
+	if !isRange {
+		return true
+	}
+	high, err := strconv.Atoi(last)
+	return err == nil && high >= low && high <= 65535
+}
+
 // validateNetworkConfig validates the NetworkConfig.
 // validateNetworkConfig 校验 NetworkConfig。
 func validateNetworkConfig(config *types.NetworkConfig) error {
@@ -286,9 +610,91 @@ func validateNetworkConfig(config *types.NetworkConfig) error {
 			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid network.serviceCIDR format '%s': %v", config.ServiceCIDR, err))
 		}
 	}
+	if config.DNSServiceIP != "" {
+		ip := net.ParseIP(config.DNSServiceIP)
+		if ip == nil {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid network.dnsServiceIP '%s'", config.DNSServiceIP))
+		}
+		if config.ServiceCIDR != "" {
+			if _, serviceNet, _ := net.ParseCIDR(config.ServiceCIDR); !serviceNet.Contains(ip) {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("network.dnsServiceIP '%s' is outside network.serviceCIDR '%s'", config.DNSServiceIP, config.ServiceCIDR))
+			}
+		}
+	}
+
+	if config.MTU < 0 || (config.MTU > 0 && config.MTU < 576) {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid network.mtu %d", config.MTU))
+	}
+	if strings.ContainsAny(config.Interface, " /\t\n") {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid network.interface '%s'", config.Interface))
+	}
+
+	if err := validateInterfaces("network", config.Interfaces, config.DNS); err != nil {
+		return err
+	}
+	switch config.Renderer {
+	case "", enum.NetworkRendererNetplan, enum.NetworkRendererNetworkManager, enum.NetworkRendererIfcfg:
+	default:
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported network.renderer '%s' (use netplan, networkmanager or ifcfg)", config.Renderer))
+	}
 
-	// TODO: Add validation for interfaces, IP addresses, gateways etc.
+	return nil
+}
 
+// validateInterfaces validates host interface and DNS configurations.
+// validateInterfaces 校验主机接口和 DNS 配置。
+// scope: Prefix of error messages, e.g. "network" or "node 10.0.0.1". / 错误消息的前缀，例如 "network" 或 "node 10.0.0.1"。
+func validateInterfaces(scope string, ifaces []types.InterfaceConfig, dns *types.DNSConfig) error {
+	names := make(map[string]bool)
+	for _, iface := range ifaces {
+		if iface.Name == "" || strings.ContainsAny(iface.Name, " /\t\n") {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid interface name '%s'", scope, iface.Name))
+		}
+		if names[iface.Name] {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: interface %s is configured more than once", scope, iface.Name))
+		}
+		names[iface.Name] = true
+		for _, addr := range iface.IPAddrs {
+			if _, _, err := net.ParseCIDR(addr); err != nil {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s, interface %s: address '%s' must be in CIDR notation", scope, iface.Name, addr))
+			}
+		}
+		if iface.Gateway != "" && net.ParseIP(iface.Gateway) == nil {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s, interface %s: invalid gateway '%s'", scope, iface.Name, iface.Gateway))
+		}
+		if len(iface.IPAddrs) == 0 && !iface.DHCP {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s, interface %s: needs ipAddrs or dhcp", scope, iface.Name))
+		}
+		if iface.MTU < 0 || (iface.MTU > 0 && iface.MTU < 576) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s, interface %s: invalid mtu %d", scope, iface.Name, iface.MTU))
+		}
+		for _, route := range iface.Routes {
+			if _, _, err := net.ParseCIDR(route.To); err != nil {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s, interface %s: route destination '%s' must be in CIDR notation", scope, iface.Name, route.To))
+			}
+			if net.ParseIP(route.Via) == nil {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s, interface %s: invalid next hop '%s' for route %s", scope, iface.Name, route.Via, route.To))
+			}
+			if route.Metric < 0 {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s, interface %s: negative metric for route %s", scope, iface.Name, route.To))
+			}
+		}
+	}
+	if dns != nil {
+		if len(dns.Nameservers) == 0 {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: dns requires at least one nameserver", scope))
+		}
+		for _, server := range dns.Nameservers {
+			if net.ParseIP(server) == nil {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid dns nameserver '%s'", scope, server))
+			}
+		}
+		for _, domain := range dns.Search {
+			if domain == "" || strings.ContainsAny(domain, " ;\"\t\n") {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s: invalid dns search domain '%s'", scope, domain))
+			}
+		}
+	}
 	return nil
 }
 
@@ -304,8 +710,16 @@ func validateStorageConfig(config *types.StorageConfig) error {
 		if scCfg.Name == "" || scCfg.Provisioner == "" {
 			return errors.New(errors.ErrTypeValidation, "storage.storageClasses requires name and provisioner")
 		}
-		// TODO: Add validation for provisioner format
-		// TODO: 校验 provisioner 格式
+		switch scCfg.ReclaimPolicy {
+		case "", "Delete", "Retain":
+		default:
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.storageClasses '%s' has invalid reclaimPolicy '%s' (must be Delete or Retain)", scCfg.Name, scCfg.ReclaimPolicy))
+		}
+		switch scCfg.VolumeBindingMode {
+		case "", "Immediate", "WaitForFirstConsumer":
+		default:
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.storageClasses '%s' has invalid volumeBindingMode '%s' (must be Immediate or WaitForFirstConsumer)", scCfg.Name, scCfg.VolumeBindingMode))
+		}
 	}
 
 	// Validate PVConfigs
@@ -314,15 +728,40 @@ func validateStorageConfig(config *types.StorageConfig) error {
 		if pvCfg.Name == "" || pvCfg.Capacity == "" {
 			return errors.New(errors.ErrTypeValidation, "storage.pvConfigs requires name and capacity")
 		}
-		// TODO: Validate capacity format (e.g., "10Gi")
-		// TODO: 校验容量格式（例如，“10Gi”）
+		if _, err := resource.ParseQuantity(pvCfg.Capacity); err != nil {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.pvConfigs '%s' has invalid capacity '%s' (e.g. 10Gi)", pvCfg.Name, pvCfg.Capacity))
+		}
 		if len(pvCfg.AccessModes) == 0 {
 			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.pvConfigs '%s' requires at least one accessMode", pvCfg.Name))
 		}
-		// TODO: Validate access mode values (e.g., "ReadWriteOnce")
-		// TODO: 校验访问模式值（例如，“ReadWriteOnce”）
-		// TODO: Validate PersistentVolumeSource configuration based on type
-		// TODO: 根据类型校验 PersistentVolumeSource 配置
+		for _, mode := range pvCfg.AccessModes {
+			switch mode {
+			case "ReadWriteOnce", "ReadOnlyMany", "ReadWriteMany", "ReadWriteOncePod":
+			default:
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.pvConfigs '%s' has invalid accessMode '%s'", pvCfg.Name, mode))
+			}
+		}
+		switch pvCfg.ReclaimPolicy {
+		case "", "Retain", "Delete", "Recycle":
+		default:
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.pvConfigs '%s' has invalid reclaimPolicy '%s' (must be Retain, Delete or Recycle)", pvCfg.Name, pvCfg.ReclaimPolicy))
+		}
+		hostPath := pvCfg.PersistentVolumeSource.HostPath
+		if hostPath == nil {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.pvConfigs '%s' requires a hostPath source", pvCfg.Name))
+		}
+		if !path.IsAbs(hostPath.Path) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.pvConfigs '%s' hostPath.path must be an absolute path", pvCfg.Name))
+		}
+		switch hostPath.Type {
+		case "", "DirectoryOrCreate", "Directory", "FileOrCreate", "File", "Socket", "CharDevice", "BlockDevice":
+		default:
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("storage.pvConfigs '%s' has invalid hostPath.type '%s'", pvCfg.Name, hostPath.Type))
+		}
+	}
+
+	if lp := config.LocalPathProvisioner; lp != nil && lp.Path != "" && !path.IsAbs(lp.Path) {
+		return errors.New(errors.ErrTypeValidation, "storage.localPathProvisioner.path must be an absolute path")
 	}
 
 	return nil
@@ -346,6 +785,42 @@ func validateSysctlConfig(config types.SysctlConfig) error {
 	return nil
 }
 
+// validatePhaseConfigs validates the custom script phases.
+// validatePhaseConfigs 校验自定义脚本阶段。
+// Dependencies on built-in phases are resolved by the deployer, which knows the full pipeline.
+// 对内置阶段的依赖由了解完整流水线的 deployer 解析。
+func validatePhaseConfigs(phases []model.PhaseConfig) error {
+	seen := make(map[string]bool, len(phases))
+	for _, phase := range phases {
+		if phase.Name == "" {
+			return errors.New(errors.ErrTypeValidation, "phase name is required")
+		}
+		if seen[phase.Name] {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("duplicate phase name '%s'", phase.Name))
+		}
+		seen[phase.Name] = true
+		if strings.TrimSpace(phase.Script) == "" {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("phase '%s': script is required", phase.Name))
+		}
+		switch phase.Scope {
+		case "", enum.PhaseScopeNode, enum.PhaseScopeCluster:
+		default:
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("phase '%s': invalid scope '%s'", phase.Name, phase.Scope))
+		}
+		for _, role := range phase.Roles {
+			if role != enum.RoleMaster && role != enum.RoleWorker && role != enum.RoleEdge {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("phase '%s': invalid role '%s'", phase.Name, role))
+			}
+		}
+		if phase.Timeout != "" {
+			if _, err := time.ParseDuration(phase.Timeout); err != nil {
+				return errors.New(errors.ErrTypeValidation, fmt.Sprintf("phase '%s': invalid timeout '%s': %v", phase.Name, phase.Timeout, err))
+			}
+		}
+	}
+	return nil
+}
+
 // validateVClusterConfig validates a single VClusterConfig.
 // validateVClusterConfig 校验单个 VClusterConfig。
 // name: The name of the vcluster being validated. / 正在校验的 vcluster 的名称。
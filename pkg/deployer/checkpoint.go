@@ -0,0 +1,291 @@
+// Package deployer orchestrates the deployment of the chasi-bod platform onto target nodes.
+// 包 deployer 协调将 chasi-bod 平台部署到目标节点。
+package deployer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// DefaultCheckpointDir is where deployment checkpoints are stored, one file per platform config.
+// DefaultCheckpointDir 是存储部署检查点的位置，每个平台配置一个文件。
+var DefaultCheckpointDir = filepath.Join(constants.DefaultDataDir, "checkpoints")
+
+// ClusterTarget is the checkpoint target used for phases that run once for the whole cluster.
+// ClusterTarget 是用于整个集群只运行一次的阶段的检查点目标。
+const ClusterTarget = plan.ClusterTarget
+
+// StepStatus is the state of one phase on one target.
+// StepStatus 是某个阶段在某个目标上的状态。
+type StepStatus string
+
+const (
+	// StepRunning means the step started but has not finished (or the process died while running it).
+	// StepRunning 表示步骤已开始但尚未完成（或进程在运行时终止）。
+	StepRunning StepStatus = "running"
+	// StepCompleted means the step finished successfully.
+	// StepCompleted 表示步骤已成功完成。
+	StepCompleted StepStatus = "completed"
+	// StepFailed means the step finished with an error.
+	// StepFailed 表示步骤以错误结束。
+	StepFailed StepStatus = "failed"
+)
+
+// StepRecord is the persisted progress of one phase on one target.
+// StepRecord 是某个阶段在某个目标上的持久化进度。
+type StepRecord struct {
+	Status     StepStatus `yaml:"status"`               // Current status / 当前状态
+	StartedAt  time.Time  `yaml:"startedAt"`            // When the step started / 步骤开始时间
+	FinishedAt time.Time  `yaml:"finishedAt,omitempty"` // When the step finished / 步骤结束时间
+	Error      string     `yaml:"error,omitempty"`      // Error message if the step failed / 步骤失败时的错误消息
+}
+
+// Checkpoint is the persisted progress of a deployment.
+// Checkpoint 是部署的持久化进度。
+type Checkpoint struct {
+	ConfigName string                            `yaml:"configName"` // Name of the platform config / 平台配置的名称
+	ConfigHash string                            `yaml:"configHash"` // Hash of the config the progress belongs to / 进度所属配置的哈希
+	UpdatedAt  time.Time                         `yaml:"updatedAt"`  // Last time the checkpoint was written / 检查点最后写入时间
+	Steps      map[string]map[string]*StepRecord `yaml:"steps"`      // Phase -> target (node address or "cluster") -> record / 阶段 -> 目标（节点地址或 "cluster"）-> 记录
+}
+
+// CheckpointStore persists a Checkpoint to disk after every change. It is safe for concurrent use.
+// CheckpointStore 在每次更改后将 Checkpoint 持久化到磁盘。它可以安全地并发使用。
+type CheckpointStore struct {
+	path string
+	mu   sync.Mutex
+	cp   *Checkpoint
+}
+
+// CheckpointPath returns the checkpoint file used for a platform config.
+// CheckpointPath 返回平台配置使用的检查点文件。
+// dir: Directory holding checkpoints. / 存放检查点的目录。
+// configName: Name of the platform config. / 平台配置的名称。
+func CheckpointPath(dir, configName string) string {
+	if configName == "" {
+		configName = "default"
+	}
+	return filepath.Join(dir, configName+".yaml")
+}
+
+// ConfigHash returns a stable hash of the platform config, used to detect config changes between runs.
+// ConfigHash 返回平台配置的稳定哈希，用于检测两次运行之间的配置更改。
+func ConfigHash(config *model.PlatformConfig) (string, error) {
+	data, err := yaml.Marshal(config) // yaml.v2 sorts map keys, so the output is stable / yaml.v2 会对 map 键排序，因此输出是稳定的
+	if err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeConfig, "failed to marshal configuration for hashing", err)
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// NewCheckpointStore creates an empty checkpoint for the given config hash and writes it to path.
+// NewCheckpointStore 为给定的配置哈希创建一个空检查点并将其写入 path。
+// path: Checkpoint file path. / 检查点文件路径。
+// configName: Name of the platform config. / 平台配置的名称。
+// configHash: Hash of the platform config. / 平台配置的哈希。
+// Returns the store, or an error if the checkpoint cannot be written.
+// 返回存储，如果无法写入检查点则返回错误。
+func NewCheckpointStore(path, configName, configHash string) (*CheckpointStore, error) {
+	s := &CheckpointStore{
+		path: path,
+		cp: &Checkpoint{
+			ConfigName: configName,
+			ConfigHash: configHash,
+			Steps:      map[string]map[string]*StepRecord{},
+		},
+	}
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadCheckpointStore reads an existing checkpoint.
+// LoadCheckpointStore 读取现有的检查点。
+// path: Checkpoint file path. / 检查点文件路径。
+// Returns an ErrTypeNotFound error if no checkpoint exists.
+// 如果检查点不存在，则返回 ErrTypeNotFound 错误。
+func LoadCheckpointStore(path string) (*CheckpointStore, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New(errors.ErrTypeNotFound, fmt.Sprintf("no deployment checkpoint found at %s", path))
+	}
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read checkpoint %s", path), err)
+	}
+	cp := &Checkpoint{}
+	if err := yaml.Unmarshal(data, cp); err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("failed to parse checkpoint %s", path), err)
+	}
+	if cp.Steps == nil {
+		cp.Steps = map[string]map[string]*StepRecord{}
+	}
+	return &CheckpointStore{path: path, cp: cp}, nil
+}
+
+// ConfigHash returns the config hash recorded in the checkpoint.
+// ConfigHash 返回检查点中记录的配置哈希。
+func (s *CheckpointStore) ConfigHash() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cp.ConfigHash
+}
+
+// SetConfigHash records a new config hash, e.g. when a resume is forced after the config changed.
+// SetConfigHash 记录新的配置哈希，例如在配置更改后强制恢复时。
+func (s *CheckpointStore) SetConfigHash(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cp.ConfigHash = hash
+	return s.save()
+}
+
+// Record returns a copy of the record for a phase and target, or nil if the step never ran.
+// Record 返回某个阶段和目标的记录副本，如果该步骤从未运行则返回 nil。
+func (s *CheckpointStore) Record(phase, target string) *StepRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.cp.Steps[phase][target]
+	if !ok {
+		return nil
+	}
+	copied := *record
+	return &copied
+}
+
+// Completed reports whether a phase finished successfully on a target.
+// Completed 报告某个阶段是否已在目标上成功完成。
+func (s *CheckpointStore) Completed(phase, target string) bool {
+	record := s.Record(phase, target)
+	return record != nil && record.Status == StepCompleted
+}
+
+// Start marks a phase as running on a target.
+// Start 将某个阶段标记为在目标上运行。
+func (s *CheckpointStore) Start(phase, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cp.Steps[phase] == nil {
+		s.cp.Steps[phase] = map[string]*StepRecord{}
+	}
+	s.cp.Steps[phase][target] = &StepRecord{Status: StepRunning, StartedAt: time.Now().UTC()}
+	return s.save()
+}
+
+// Finish marks a phase as completed or failed on a target, depending on stepErr.
+// Finish 根据 stepErr 将某个阶段在目标上标记为已完成或失败。
+func (s *CheckpointStore) Finish(phase, target string, stepErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cp.Steps[phase] == nil {
+		s.cp.Steps[phase] = map[string]*StepRecord{}
+	}
+	record := s.cp.Steps[phase][target]
+	if record == nil {
+		record = &StepRecord{StartedAt: time.Now().UTC()}
+		s.cp.Steps[phase][target] = record
+	}
+	record.FinishedAt = time.Now().UTC()
+	record.Status = StepCompleted
+	record.Error = ""
+	if stepErr != nil {
+		record.Status = StepFailed
+		record.Error = stepErr.Error()
+	}
+	return s.save()
+}
+
+// save writes the checkpoint atomically. The caller must hold s.mu.
+// save 以原子方式写入检查点。调用方必须持有 s.mu。
+func (s *CheckpointStore) save() error {
+	s.cp.UpdatedAt = time.Now().UTC()
+	data, err := yaml.Marshal(s.cp)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeInternal, "failed to marshal deployment checkpoint", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := utils.WriteFileContent(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write checkpoint %s", s.path), err)
+	}
+	return nil
+}
+
+// openCheckpoint returns the checkpoint store for this deployment.
+// openCheckpoint 返回本次部署的检查点存储。
+// When resuming, the existing checkpoint must exist and match the current config hash unless forced.
+// Targeted re-runs (--from-phase/--only-phase) keep recording into a matching checkpoint; otherwise a fresh one is started.
+// 恢复时，现有检查点必须存在且与当前配置哈希匹配（除非强制）。
+// 定向重新运行（--from-phase/--only-phase）会继续记录到匹配的检查点中；否则会启动一个新的检查点。
+func (d *defaultDeployer) openCheckpoint(config *model.PlatformConfig) (*CheckpointStore, error) {
+	hash, err := ConfigHash(config)
+	if err != nil {
+		return nil, err
+	}
+	path := CheckpointPath(d.checkpointDir, config.Metadata.Name)
+	existing, loadErr := LoadCheckpointStore(path)
+
+	if d.run.Resume {
+		if loadErr != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeConfig, "cannot resume deployment", loadErr)
+		}
+		if existing.ConfigHash() != hash {
+			if !d.run.Force {
+				return nil, errors.New(errors.ErrTypeConfig, fmt.Sprintf("configuration changed since checkpoint %s was written (checkpoint %s, current %s); re-run without --resume or pass --force", path, existing.ConfigHash(), hash))
+			}
+			utils.GetLogger().Printf("Warning: Configuration changed since checkpoint %s was written; resuming anyway because --force was set.", path)
+			if err := existing.SetConfigHash(hash); err != nil {
+				return nil, err
+			}
+		}
+		utils.GetLogger().Printf("Resuming deployment from checkpoint %s.", path)
+		return existing, nil
+	}
+
+	if loadErr == nil && existing.ConfigHash() == hash && (d.run.FromPhase != "" || d.run.OnlyPhase != "") {
+		utils.GetLogger().Printf("Recording targeted re-run into existing checkpoint %s.", path)
+		return existing, nil
+	}
+	if loadErr != nil && !errors.IsChasiBodError(loadErr, errors.ErrTypeNotFound) {
+		utils.GetLogger().Printf("Warning: Ignoring unreadable checkpoint: %v", loadErr)
+	}
+	utils.GetLogger().Printf("Recording deployment progress to %s.", path)
+	return NewCheckpointStore(path, config.Metadata.Name, hash)
+}
+
+// runStep runs one phase on one target and records the outcome in the checkpoint.
+// runStep 在一个目标上运行一个阶段，并将结果记录到检查点中。
+// When resuming, steps the checkpoint already records as completed are skipped.
+// 恢复时，跳过检查点中已记录为完成的步骤。
+func (d *defaultDeployer) runStep(checkpoint *CheckpointStore, phaseID, target string, fn func() error) error {
+	if d.run.Resume && checkpoint.Completed(phaseID, target) {
+		utils.GetLogger().Printf("[%s] Skipping %s: already completed according to checkpoint.", phaseID, target)
+		return nil
+	}
+	// A checkpoint write failure must not fail the deployment itself; it only weakens a later resume.
+	// 检查点写入失败不能导致部署本身失败；它只会影响之后的恢复。
+	if err := checkpoint.Start(phaseID, target); err != nil {
+		utils.GetLogger().Printf("Warning: Failed to record start of %s on %s: %v", phaseID, target, err)
+	}
+	stepErr := fn()
+	if err := checkpoint.Finish(phaseID, target, stepErr); err != nil {
+		utils.GetLogger().Printf("Warning: Failed to record result of %s on %s: %v", phaseID, target, err)
+	}
+	return stepErr
+}
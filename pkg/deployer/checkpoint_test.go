@@ -0,0 +1,208 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+	"github.com/turtacn/chasi-bod/pkg/deployer/preflight"
+)
+
+// callRecorder records "phase@target" for every phase invocation and fails the configured ones.
+type callRecorder struct {
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]bool
+}
+
+func (r *callRecorder) record(phase, target string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := phase + "@" + target
+	r.calls = append(r.calls, key)
+	if r.fail[key] {
+		return errors.New("injected failure")
+	}
+	return nil
+}
+
+func (r *callRecorder) reset() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls
+	r.calls = nil
+	sort.Strings(calls)
+	return calls
+}
+
+type fakeNodePhase struct {
+	id  string
+	rec *callRecorder
+}
+
+func (p *fakeNodePhase) Run(_ context.Context, nodeCfg *model.NodeConfig, _ *model.ClusterConfig) error {
+	return p.rec.record(p.id, nodeCfg.Address)
+}
+
+func (p *fakeNodePhase) Plan(_ context.Context, nodeCfg *model.NodeConfig, _ *model.ClusterConfig) ([]plan.Action, error) {
+	return []plan.Action{plan.SudoCommand("Run "+p.id, p.id+" --node "+nodeCfg.Address)}, nil
+}
+
+// fakeInitPhase is a fakeNodePhase whose preflight reports the configured results.
+type fakeInitPhase struct {
+	fakeNodePhase
+	preflight []preflight.Result
+	// clusterNodes records the addresses of the cluster nodes passed to the last preflight.
+	clusterNodes []string
+}
+
+func (p *fakeInitPhase) Preflight(_ context.Context, _ []model.NodeConfig, clusterNodes []model.NodeConfig, _ *model.ClusterConfig) *preflight.Report {
+	p.clusterNodes = nil
+	for _, node := range clusterNodes {
+		p.clusterNodes = append(p.clusterNodes, node.Address)
+	}
+	report := preflight.NewReport()
+	for _, result := range p.preflight {
+		report.Add(result)
+	}
+	return report
+}
+
+type fakeK8sPhase struct{ rec *callRecorder }
+
+func (p *fakeK8sPhase) Run(context.Context, []model.NodeConfig, *model.ClusterConfig) error {
+	return p.rec.record(PhaseKubernetes, ClusterTarget)
+}
+
+func (p *fakeK8sPhase) JoinNode(_ context.Context, nodeCfg *model.NodeConfig, _ *model.ClusterConfig) error {
+	return p.rec.record("join", nodeCfg.Address)
+}
+
+func (p *fakeK8sPhase) Plan(_ context.Context, nodes []model.NodeConfig, _ *model.ClusterConfig) ([]plan.TargetActions, error) {
+	return []plan.TargetActions{{Target: nodes[0].Address, Actions: []plan.Action{plan.SudoCommand("Initialize the control plane", "kubeadm init")}}}, nil
+}
+
+func (p *fakeK8sPhase) RemoveEtcdMember(_ context.Context, _ string, nodeCfg *model.NodeConfig, _ *model.ClusterConfig) error {
+	return p.rec.record("remove-etcd-member", nodeCfg.Address)
+}
+
+func (p *fakeK8sPhase) ResetNode(_ context.Context, nodeCfg *model.NodeConfig, _ *model.ClusterConfig) error {
+	return p.rec.record("reset", nodeCfg.Address)
+}
+
+type fakeVClusterPhase struct{ rec *callRecorder }
+
+func (p *fakeVClusterPhase) Run(context.Context, *model.PlatformConfig, interface{}) error {
+	return p.rec.record(PhaseVCluster, ClusterTarget)
+}
+
+func (p *fakeVClusterPhase) Plan(context.Context, *model.PlatformConfig) ([]plan.Action, error) {
+	return []plan.Action{plan.Helm("Install or upgrade vcluster team-a", plan.HelmRelease{Name: "team-a", Namespace: "vc-team-a", Chart: "vcluster"})}, nil
+}
+
+func newFakeDeployer(rec *callRecorder, checkpointDir string, run RunOptions, custom ...PhaseDefinition) *defaultDeployer {
+	d := &defaultDeployer{
+		initializationPhase: &fakeInitPhase{fakeNodePhase: fakeNodePhase{PhaseInit, rec}},
+		osConfigPhase:       &fakeNodePhase{PhaseOS, rec},
+		runtimeConfigPhase:  &fakeNodePhase{PhaseRuntime, rec},
+		networkConfigPhase:  &fakeNodePhase{PhaseNetwork, rec},
+		storageConfigPhase:  &fakeNodePhase{PhaseStorage, rec},
+		k8sInstallPhase:     &fakeK8sPhase{rec},
+		vclusterDeployPhase: &fakeVClusterPhase{rec},
+		customPhases:        custom,
+		parallel:            DefaultParallelOptions(),
+		run:                 run,
+		checkpointDir:       checkpointDir,
+		hostClient:          fake.NewSimpleClientset(),
+	}
+	if err := d.buildRegistry(); err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func testPlatformConfig() *model.PlatformConfig {
+	config := &model.PlatformConfig{}
+	config.Metadata.Name = "test-platform"
+	config.Cluster.Nodes = testNodes(2)
+	return config
+}
+
+func TestDeploy_ResumeSkipsCompletedSteps(t *testing.T) {
+	utils.InitLogger("info", 0)
+	dir := t.TempDir()
+	config := testPlatformConfig()
+	rec := &callRecorder{fail: map[string]bool{PhaseNetwork + "@10.0.0.2": true}}
+
+	err := newFakeDeployer(rec, dir, RunOptions{}).Deploy(context.Background(), config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "node 10.0.0.2")
+	rec.reset()
+
+	checkpoint, err := LoadCheckpointStore(CheckpointPath(dir, config.Metadata.Name))
+	require.NoError(t, err)
+	assert.True(t, checkpoint.Completed(PhaseNetwork, "10.0.0.1"))
+	failedStep := checkpoint.Record(PhaseNetwork, "10.0.0.2")
+	require.NotNil(t, failedStep)
+	assert.Equal(t, StepFailed, failedStep.Status)
+	assert.Contains(t, failedStep.Error, "injected failure")
+	assert.False(t, failedStep.FinishedAt.IsZero())
+
+	rec.fail = nil
+	require.NoError(t, newFakeDeployer(rec, dir, RunOptions{Resume: true}).Deploy(context.Background(), config))
+	assert.Equal(t, []string{
+		"kubernetes@cluster",
+		"network@10.0.0.2",
+		"storage@10.0.0.1",
+		"storage@10.0.0.2",
+		"vcluster@cluster",
+	}, rec.reset(), "only the failed and never-run steps run again")
+}
+
+func TestDeploy_ResumeRefusesChangedConfigUnlessForced(t *testing.T) {
+	utils.InitLogger("info", 0)
+	dir := t.TempDir()
+	config := testPlatformConfig()
+	rec := &callRecorder{}
+	require.NoError(t, newFakeDeployer(rec, dir, RunOptions{}).Deploy(context.Background(), config))
+	rec.reset()
+
+	config.Cluster.KubernetesVersion = "v1.31.0"
+	err := newFakeDeployer(rec, dir, RunOptions{Resume: true}).Deploy(context.Background(), config)
+	require.Error(t, err)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeConfig))
+	assert.Empty(t, rec.reset())
+
+	require.NoError(t, newFakeDeployer(rec, dir, RunOptions{Resume: true, Force: true}).Deploy(context.Background(), config))
+	assert.Empty(t, rec.reset(), "everything is already completed")
+
+	_, err = newFakeDeployer(rec, t.TempDir(), RunOptions{Resume: true}).openCheckpoint(config)
+	assert.Error(t, err, "resuming without a checkpoint fails")
+}
+
+func TestDeploy_PhaseSelection(t *testing.T) {
+	utils.InitLogger("info", 0)
+	config := testPlatformConfig()
+	rec := &callRecorder{}
+
+	require.NoError(t, newFakeDeployer(rec, t.TempDir(), RunOptions{OnlyPhase: PhaseStorage}).Deploy(context.Background(), config))
+	assert.Equal(t, []string{"storage@10.0.0.1", "storage@10.0.0.2"}, rec.reset())
+
+	require.NoError(t, newFakeDeployer(rec, t.TempDir(), RunOptions{FromPhase: PhaseKubernetes}).Deploy(context.Background(), config))
+	assert.Equal(t, []string{"kubernetes@cluster", "vcluster@cluster"}, rec.reset())
+
+	err := newFakeDeployer(rec, t.TempDir(), RunOptions{OnlyPhase: "bogus"}).Deploy(context.Background(), config)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation), "unknown phases are rejected")
+	assert.Error(t, RunOptions{OnlyPhase: PhaseOS, FromPhase: PhaseInit}.Validate())
+	assert.NoError(t, RunOptions{FromPhase: PhaseNetwork}.Validate())
+}
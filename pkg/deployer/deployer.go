@@ -5,19 +5,23 @@ package deployer
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	//"time"
 
 	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
 	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
 	"github.com/turtacn/chasi-bod/pkg/deployer/phases"
-	// Placeholder for Kubernetes client-go, needed for vcluster phase
-	// Kubernetes client-go 的占位符，vcluster 阶段需要它
-	// "k8s.io/client-go/kubernetes"
-	// "k8s.io/client-go/rest" // Might be needed to build K8s client config // 可能需要它来构建 K8s 客户端配置
-	// "k8s.io/client-go/tools/clientcmd" // Needed to load kubeconfig // 需要它来加载 kubeconfig
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+	"github.com/turtacn/chasi-bod/pkg/deployer/preflight"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd" // Needed to load kubeconfig // 需要它来加载 kubeconfig
 )
 
 // Deployer defines the interface for the platform deployment orchestrator.
@@ -31,6 +35,23 @@ type Deployer interface {
 	// 如果部署在任何阶段失败则返回错误。
 	Deploy(ctx context.Context, config *model.PlatformConfig) error
 
+	// Plan walks the same phases as Deploy and returns the actions they would perform, without changing any node.
+	// Plan 遍历与 Deploy 相同的阶段并返回它们将执行的操作，而不更改任何节点。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// config: The platform configuration including cluster and node details. / 包含集群和节点详细信息的平台配置。
+	// opts: Plan settings, such as whether to diff files against the nodes. / 计划设置，例如是否将文件与节点进行比较。
+	// Returns the deployment plan, or an error if it cannot be rendered.
+	// 返回部署计划，如果无法渲染则返回错误。
+	Plan(ctx context.Context, config *model.PlatformConfig, opts PlanOptions) (*plan.Plan, error)
+
+	// Preflight checks that all nodes meet the platform requirements, without changing them.
+	// Preflight 检查所有节点是否满足平台要求，而不更改节点。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// config: The platform configuration including cluster and node details. / 包含集群和节点详细信息的平台配置。
+	// Returns the preflight report; use its Err method to turn blocking failures into an error.
+	// 返回预检报告；使用其 Err 方法将阻止部署的失败转换为错误。
+	Preflight(ctx context.Context, config *model.PlatformConfig) *preflight.Report
+
 	// AddNode adds a new node to an existing Host Kubernetes cluster.
 	// AddNode 将新节点添加到现有的 Host Kubernetes 集群。
 	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
@@ -40,18 +61,32 @@ type Deployer interface {
 	// 如果添加节点失败则返回错误。
 	AddNode(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) error
 
+	// ReconfigureNode re-runs the node-specific phases on a node that is already part of the cluster.
+	// ReconfigureNode 在已属于集群的节点上重新运行节点特定阶段。
+	// It is used by upgrades to roll a changed configuration out to existing nodes.
+	// 升级时使用它将更改后的配置推送到现有节点。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// config: The overall platform configuration. / 整体平台配置。
+	// nodeCfg: The configuration for the node. / 节点的配置。
+	// Returns an error if any phase fails on the node.
+	// 如果任何阶段在节点上失败则返回错误。
+	ReconfigureNode(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) error
+
 	// RemoveNode removes a node from an existing Host Kubernetes cluster.
 	// RemoveNode 从现有的 Host Kubernetes 集群中移除节点。
+	// The node is cordoned and drained, a master's etcd member is removed, the Node object is deleted,
+	// and finally kubeadm, the CNI and the Kubernetes iptables rules are cleaned up on the machine.
+	// 节点被封锁并排空，主节点的 etcd 成员被移除，Node 对象被删除，最后在机器上清理 kubeadm、CNI 和 Kubernetes iptables 规则。
 	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// config: The platform configuration the node is no longer part of. / 不再包含该节点的平台配置。
 	// nodeCfg: The configuration for the node to remove. / 要移除的节点的配置。
 	// hostK8sClient: A Kubernetes client connected to the Host Cluster. / 连接到 Host 集群的 Kubernetes 客户端。
 	// Returns an error if removing the node fails.
 	// 如果移除节点失败则返回错误。
-	RemoveNode(ctx context.Context, nodeCfg *model.NodeConfig, hostK8sClient interface{} /* kubernetes.Interface */) error
+	RemoveNode(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig, hostK8sClient kubernetes.Interface) error
 
-	// Add more methods for other deployment actions like upgrade, etc.
-	// 添加其他部署操作的方法，例如升级等。
-	// Upgrade(ctx context.Context, config *model.PlatformConfig, newConfig *model.PlatformConfig) error // This might be in lifecycle manager
+	// Add more methods for other deployment actions.
+	// 添加其他部署操作的方法。
 }
 
 // defaultDeployer is a default implementation of the Deployer interface.
@@ -66,36 +101,362 @@ type defaultDeployer struct {
 	storageConfigPhase  phases.StorageConfigPhase
 	k8sInstallPhase     phases.K8sInstallPhase // Renamed for clarity // 重命名以提高清晰度
 	vclusterDeployPhase phases.VClusterDeployPhase
-	// Add other phases here
-	// 在这里添加其他阶段
+
+	// registry holds the built-in and custom phases; Deploy executes them in dependency order
+	// registry 持有内置和自定义阶段；Deploy 按依赖顺序执行它们
+	registry *Registry
+	// customPhases are registered after the built-in phases
+	// customPhases 在内置阶段之后注册
+	customPhases []PhaseDefinition
+
+	// newExecutor creates the NodeExecutor used to reach nodes (SSH by default)
+	// newExecutor 创建用于访问节点的 NodeExecutor（默认为 SSH）
+	newExecutor executor.Factory
+	// parallel controls how node-specific phases fan out across nodes
+	// parallel 控制节点特定阶段如何在节点之间并行展开
+	parallel ParallelOptions
+	// run selects which phases Deploy runs and whether completed work is skipped
+	// run 选择 Deploy 运行哪些阶段以及是否跳过已完成的工作
+	run RunOptions
+	// checkpointDir is where deployment progress is persisted
+	// checkpointDir 是持久化部署进度的位置
+	checkpointDir string
+
+	// inventoryDir is where the deployed nodes are recorded; empty disables the inventory
+	// inventoryDir 是记录已部署节点的位置；为空时禁用清单
+	inventoryDir string
+
+	// drain controls how nodes are drained before they are removed
+	// drain 控制节点在移除之前如何被排空
+	drain DrainOptions
+
+	// hostClient reaches the Host Cluster; nil means the kubeconfig exported by the Kubernetes phase is used
+	// hostClient 用于访问 Host 集群；为 nil 时使用 Kubernetes 阶段导出的 kubeconfig
+	hostClient kubernetes.Interface
+}
+
+// Built-in phase names, in deployment order. They are used in dependencies, on the command line and in checkpoints.
+// 内置阶段名称，按部署顺序排列。它们用于依赖关系、命令行和检查点。
+const (
+	PhaseInit       = "init"
+	PhaseOS         = "os"
+	PhaseRuntime    = "runtime"
+	PhaseNetwork    = "network"
+	PhaseStorage    = "storage"
+	PhaseKubernetes = "kubernetes"
+	PhaseVCluster   = "vcluster"
+)
+
+// PhaseOrder lists the built-in phase names in the order Deploy runs them.
+// PhaseOrder 按 Deploy 运行的顺序列出内置阶段名称。
+var PhaseOrder = []string{PhaseInit, PhaseOS, PhaseRuntime, PhaseNetwork, PhaseStorage, PhaseKubernetes, PhaseVCluster}
+
+// RunOptions selects which parts of a deployment run.
+// RunOptions 选择部署运行的哪些部分。
+type RunOptions struct {
+	// Resume skips the steps the checkpoint records as completed.
+	// Resume 跳过检查点中记录为已完成的步骤。
+	Resume bool
+	// Force allows resuming even though the config changed since the checkpoint was written.
+	// Force 允许在检查点写入后配置发生更改的情况下仍然恢复。
+	Force bool
+	// FromPhase starts the deployment at this phase, skipping the earlier ones.
+	// FromPhase 从该阶段开始部署，跳过之前的阶段。
+	FromPhase string
+	// OnlyPhase runs this single phase.
+	// OnlyPhase 仅运行该阶段。
+	OnlyPhase string
+	// SkipPreflight skips the preflight checks that otherwise run before the initialization phase.
+	// SkipPreflight 跳过原本在初始化阶段之前运行的预检。
+	SkipPreflight bool
+}
+
+// Validate checks that the options are not contradictory.
+// Validate 检查选项是否互不矛盾。
+// Phase names are checked against the pipeline when Deploy runs, since config-declared phases are only known then.
+// 阶段名称在 Deploy 运行时根据流水线进行检查，因为配置中声明的阶段只有那时才知道。
+func (o RunOptions) Validate() error {
+	if o.FromPhase != "" && o.OnlyPhase != "" {
+		return errors.New(errors.ErrTypeValidation, "--from-phase and --only-phase cannot be used together")
+	}
+	return nil
+}
+
+// validatePhases checks that the selected phases exist in the pipeline.
+// validatePhases 检查所选阶段是否存在于流水线中。
+func (o RunOptions) validatePhases(pipeline []*PhaseDefinition) error {
+	for _, name := range []string{o.FromPhase, o.OnlyPhase} {
+		if name != "" && phaseIndex(pipeline, name) < 0 {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("unknown phase %q (valid phases: %s)", name, strings.Join(phaseNames(pipeline), ", ")))
+		}
+	}
+	return nil
+}
+
+// selects reports whether the named phase should run.
+// selects 报告指定的阶段是否应该运行。
+func (o RunOptions) selects(pipeline []*PhaseDefinition, name string) bool {
+	switch {
+	case o.OnlyPhase != "":
+		return name == o.OnlyPhase
+	case o.FromPhase != "":
+		return phaseIndex(pipeline, name) >= phaseIndex(pipeline, o.FromPhase)
+	default:
+		return true
+	}
+}
+
+// phaseIndex returns the position of a phase in the pipeline, or -1 if it is not part of it.
+// phaseIndex 返回阶段在流水线中的位置，如果不在流水线中则返回 -1。
+func phaseIndex(pipeline []*PhaseDefinition, name string) int {
+	for i, def := range pipeline {
+		if def.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// phaseNames returns the phase names of the pipeline in order.
+// phaseNames 按顺序返回流水线中的阶段名称。
+func phaseNames(pipeline []*PhaseDefinition) []string {
+	names := make([]string, len(pipeline))
+	for i, def := range pipeline {
+		names[i] = def.Name
+	}
+	return names
+}
+
+// Option configures the default Deployer.
+// Option 配置默认的 Deployer。
+type Option func(*defaultDeployer)
+
+// WithExecutorFactory overrides how the deployer connects to nodes.
+// WithExecutorFactory 覆盖 deployer 连接节点的方式。
+// factory: Creates a NodeExecutor for a node. / 为节点创建 NodeExecutor。
+func WithExecutorFactory(factory executor.Factory) Option {
+	return func(d *defaultDeployer) {
+		d.newExecutor = factory
+	}
+}
+
+// WithParallelOptions sets how many nodes are processed at once and what happens when one fails.
+// WithParallelOptions 设置同时处理多少个节点以及某个节点失败时的处理方式。
+// opts: Parallelism settings. / 并行设置。
+func WithParallelOptions(opts ParallelOptions) Option {
+	return func(d *defaultDeployer) {
+		d.parallel = opts
+	}
+}
+
+// WithRunOptions selects which phases Deploy runs and whether it resumes from the last checkpoint.
+// WithRunOptions 选择 Deploy 运行哪些阶段以及是否从上一个检查点恢复。
+// opts: Phase selection and resume settings. / 阶段选择和恢复设置。
+func WithRunOptions(opts RunOptions) Option {
+	return func(d *defaultDeployer) {
+		d.run = opts
+	}
+}
+
+// WithCheckpointDir overrides where deployment checkpoints are stored (DefaultCheckpointDir by default).
+// WithCheckpointDir 覆盖部署检查点的存储位置（默认为 DefaultCheckpointDir）。
+// dir: Directory holding checkpoints. / 存放检查点的目录。
+func WithCheckpointDir(dir string) Option {
+	return func(d *defaultDeployer) {
+		d.checkpointDir = dir
+	}
+}
+
+// WithDrainOptions sets how long RemoveNode waits for evictions and whether it forces them.
+// WithDrainOptions 设置 RemoveNode 等待驱逐的时间以及是否强制驱逐。
+// opts: Drain settings. / 排空设置。
+func WithDrainOptions(opts DrainOptions) Option {
+	return func(d *defaultDeployer) {
+		d.drain = opts
+	}
+}
+
+// WithHostK8sClient sets the client used to reconcile Node metadata after nodes join.
+// WithHostK8sClient 设置节点加入后用于协调 Node 元数据的客户端。
+// Without it the admin kubeconfig exported by the Kubernetes phase is loaded.
+// 未设置时加载 Kubernetes 阶段导出的管理员 kubeconfig。
+// client: A Kubernetes client connected to the Host Cluster. / 连接到 Host 集群的 Kubernetes 客户端。
+func WithHostK8sClient(client kubernetes.Interface) Option {
+	return func(d *defaultDeployer) {
+		d.hostClient = client
+	}
+}
+
+// WithInventoryDir overrides where the node inventory is recorded (DefaultInventoryDir by default).
+// WithInventoryDir 覆盖节点清单的记录位置（默认为 DefaultInventoryDir）。
+// dir: Directory holding inventories. / 存放清单的目录。
+func WithInventoryDir(dir string) Option {
+	return func(d *defaultDeployer) {
+		d.inventoryDir = dir
+	}
+}
+
+// WithPhase registers a custom phase, e.g. a corporate hardening step, alongside the built-in phases.
+// WithPhase 在内置阶段之外注册一个自定义阶段，例如企业加固步骤。
+// Its DependsOn decides where it runs in the pipeline (e.g., DependsOn: []string{PhaseOS}).
+// 它的 DependsOn 决定它在流水线中的运行位置（例如，DependsOn: []string{PhaseOS}）。
+// def: The phase definition. / 阶段定义。
+func WithPhase(def PhaseDefinition) Option {
+	return func(d *defaultDeployer) {
+		d.customPhases = append(d.customPhases, def)
+	}
 }
 
 // NewDeployer creates a new Deployer instance.
 // NewDeployer 创建一个新的 Deployer 实例。
-// Returns a Deployer implementation.
-// 返回 Deployer 实现。
-func NewDeployer() (Deployer, error) {
+// opts: Optional settings such as WithExecutorFactory. / 可选设置，例如 WithExecutorFactory。
+// Returns a Deployer implementation, or an error if the options or the phase graph are invalid.
+// 返回 Deployer 实现，如果选项或阶段图无效则返回错误。
+func NewDeployer(opts ...Option) (Deployer, error) {
 	// Default deployer implementation will orchestrate the phases
 	// 默认的 deployer 实现将协调各个阶段
-	d := &defaultDeployer{}
-	d.init() // Initialize phases on creation
+	d := &defaultDeployer{newExecutor: executor.NewSSHExecutor, parallel: DefaultParallelOptions(), checkpointDir: DefaultCheckpointDir, inventoryDir: DefaultInventoryDir, drain: DefaultDrainOptions()}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.parallel.Validate(); err != nil {
+		return nil, err
+	}
+	if err := d.run.Validate(); err != nil {
+		return nil, err
+	}
+	if err := d.init(); err != nil { // Initialize phases on creation
+		return nil, err
+	}
 	return d, nil
 }
 
-// init initializes the defaultDeployer with concrete phase implementations.
-// init 使用具体的阶段实现初始化 defaultDeployer。
-func (d *defaultDeployer) init() {
+// init initializes the defaultDeployer with concrete phase implementations and builds the phase registry.
+// init 使用具体的阶段实现初始化 defaultDeployer 并构建阶段注册表。
+func (d *defaultDeployer) init() error {
 	// Initialize all phases
 	// 初始化所有阶段
-	d.initializationPhase = phases.NewInitPhase()
-	d.osConfigPhase = phases.NewOSConfigPhase()
-	d.runtimeConfigPhase = phases.NewRuntimeConfigPhase()
-	d.networkConfigPhase = phases.NewNetworkConfigPhase()
-	d.storageConfigPhase = phases.NewStorageConfigPhase()
-	d.k8sInstallPhase = phases.NewK8sInstallPhase()
+	d.initializationPhase = phases.NewInitPhase(d.newExecutor)
+	d.osConfigPhase = phases.NewOSConfigPhase(d.newExecutor)
+	d.runtimeConfigPhase = phases.NewRuntimeConfigPhase(d.newExecutor)
+	d.networkConfigPhase = phases.NewNetworkConfigPhase(d.newExecutor)
+	d.storageConfigPhase = phases.NewStorageConfigPhase(d.newExecutor)
+	d.k8sInstallPhase = phases.NewK8sInstallPhase(d.newExecutor)
 	d.vclusterDeployPhase = phases.NewVClusterDeployPhase()
-	// Initialize other phases
-	// 初始化其他阶段
+	return d.buildRegistry()
+}
+
+// buildRegistry registers the built-in phases followed by the custom ones and checks that the graph resolves.
+// buildRegistry 注册内置阶段和随后的自定义阶段，并检查依赖图是否可以解析。
+func (d *defaultDeployer) buildRegistry() error {
+	d.registry = NewRegistry()
+	for _, def := range append(d.builtinPhases(), d.customPhases...) {
+		if err := d.registry.Register(def); err != nil {
+			return err
+		}
+	}
+	_, err := d.registry.Resolve()
+	return err
+}
+
+// builtinPhases returns the built-in phase definitions, backed by the deployer's phase instances.
+// builtinPhases 返回由 deployer 的阶段实例支持的内置阶段定义。
+// This sequence represents the state transitions from bare OS to running platform;
+// vCluster deployment happens *after* Host K8s is fully up.
+// 这个顺序代表了从裸操作系统到运行平台的状态转换；vCluster 部署发生在 Host K8s 完全启动之后。
+func (d *defaultDeployer) builtinPhases() []PhaseDefinition {
+	nodePhase := func(phase phases.NodeSpecificPhase) NodeRunFunc {
+		return func(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) error {
+			return phase.Run(ctx, nodeCfg, &config.Cluster)
+		}
+	}
+	nodePlan := func(phase phases.NodeSpecificPhase) NodePlanFunc {
+		return func(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) ([]plan.Action, error) {
+			return phase.Plan(ctx, nodeCfg, &config.Cluster)
+		}
+	}
+
+	return []PhaseDefinition{
+		{Name: PhaseInit, Description: "Initialization", Scope: enum.PhaseScopeNode, Timeout: 5 * time.Minute,
+			RunNode: nodePhase(d.initializationPhase), PlanNode: nodePlan(d.initializationPhase)},
+		{Name: PhaseOS, Description: "OS Configuration", Scope: enum.PhaseScopeNode, DependsOn: []string{PhaseInit}, Timeout: 10 * time.Minute, // Longer timeout for OS tasks
+			RunNode: withEffectiveSysctl(nodePhase(d.osConfigPhase)), PlanNode: withEffectiveSysctlPlan(nodePlan(d.osConfigPhase))},
+		{Name: PhaseRuntime, Description: "Runtime Configuration", Scope: enum.PhaseScopeNode, DependsOn: []string{PhaseOS}, Timeout: 5 * time.Minute,
+			RunNode: nodePhase(d.runtimeConfigPhase), PlanNode: nodePlan(d.runtimeConfigPhase)},
+		{Name: PhaseNetwork, Description: "Network Configuration", Scope: enum.PhaseScopeNode, DependsOn: []string{PhaseOS}, Timeout: 5 * time.Minute,
+			RunNode: nodePhase(d.networkConfigPhase), PlanNode: nodePlan(d.networkConfigPhase)},
+		{Name: PhaseStorage, Description: "Storage Configuration", Scope: enum.PhaseScopeNode, DependsOn: []string{PhaseOS}, Timeout: 5 * time.Minute,
+			RunNode: nodePhase(d.storageConfigPhase), PlanNode: nodePlan(d.storageConfigPhase)},
+		// Kubernetes installation needs the full config and orchestrates across nodes
+		// Kubernetes 安装需要完整的配置并在节点之间协调
+		{Name: PhaseKubernetes, Description: "Kubernetes Installation", Scope: enum.PhaseScopeCluster, DependsOn: []string{PhaseRuntime, PhaseNetwork, PhaseStorage}, Timeout: 30 * time.Minute,
+			RunCluster: func(ctx context.Context, config *model.PlatformConfig) error {
+				if err := d.k8sInstallPhase.Run(ctx, config.Cluster.Nodes, &config.Cluster); err != nil {
+					return err
+				}
+				return d.reconcileNodeMetadata(ctx, config, config.Cluster.Nodes)
+			},
+			PlanCluster: func(ctx context.Context, config *model.PlatformConfig) ([]plan.TargetActions, error) {
+				return d.k8sInstallPhase.Plan(ctx, config.Cluster.Nodes, &config.Cluster)
+			}},
+		{Name: PhaseVCluster, Description: "vCluster Deployment", Scope: enum.PhaseScopeCluster, DependsOn: []string{PhaseKubernetes}, Timeout: 15 * time.Minute,
+			RunCluster: func(ctx context.Context, config *model.PlatformConfig) error {
+				// TODO: Get Host K8s client
+				// TODO: 获取 Host K8s 客户端
+				// hostK8sClient, err := getHostK8sClient(config) // Need to implement this function
+				// if err != nil {
+				// 	return errors.NewWithCause(errors.ErrTypeSystem, "failed to get host Kubernetes client", err)
+				// }
+				var hostK8sClient interface{} // Placeholder for now - should be kubernetes.Interface
+				utils.GetLogger().Println("Placeholder: Acquired host Kubernetes client.")
+				return d.vclusterDeployPhase.Run(ctx, config, hostK8sClient /* Pass config.VClusters etc. */)
+			},
+			PlanCluster: func(ctx context.Context, config *model.PlatformConfig) ([]plan.TargetActions, error) {
+				actions, err := d.vclusterDeployPhase.Plan(ctx, config)
+				return []plan.TargetActions{{Target: plan.ClusterTarget, Actions: actions}}, err
+			}},
+		// TODO: Add DFX deployment/configuration phase
+		// TODO: 添加 DFX 部署/配置阶段
+	}
+}
+
+// withEffectiveSysctl hands a phase the node with its base OS, platform and node sysctl settings merged.
+// withEffectiveSysctl 将合并了基础操作系统、平台和节点 sysctl 设置的节点交给阶段。
+func withEffectiveSysctl(run NodeRunFunc) NodeRunFunc {
+	return func(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) error {
+		node := *nodeCfg
+		node.SysctlConfig = phases.EffectiveSysctl(config, nodeCfg)
+		return run(ctx, config, &node)
+	}
+}
+
+// withEffectiveSysctlPlan is withEffectiveSysctl for planning.
+// withEffectiveSysctlPlan 是用于计划的 withEffectiveSysctl。
+func withEffectiveSysctlPlan(planNode NodePlanFunc) NodePlanFunc {
+	return func(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) ([]plan.Action, error) {
+		node := *nodeCfg
+		node.SysctlConfig = phases.EffectiveSysctl(config, nodeCfg)
+		return planNode(ctx, config, &node)
+	}
+}
+
+// pipeline resolves the phases for a platform config: the registered phases plus the config-declared script phases.
+// pipeline 为平台配置解析阶段：已注册的阶段加上配置中声明的脚本阶段。
+func (d *defaultDeployer) pipeline(config *model.PlatformConfig) ([]*PhaseDefinition, error) {
+	registry := d.registry
+	if len(config.Phases) > 0 {
+		registry = registry.Clone()
+		for _, phaseCfg := range config.Phases {
+			def, err := ScriptPhase(phaseCfg, d.newExecutor)
+			if err != nil {
+				return nil, err
+			}
+			if err := registry.Register(def); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return registry.Resolve()
 }
 
 // Deploy orchestrates the full deployment process.
@@ -103,258 +464,456 @@ func (d *defaultDeployer) init() {
 func (d *defaultDeployer) Deploy(ctx context.Context, config *model.PlatformConfig) error {
 	utils.GetLogger().Printf("Starting platform deployment for config: %s", config.Metadata.Name)
 
-	// Define the sequence of deployment phases
-	// 定义部署阶段的顺序
-	// This sequence is crucial and represents the state transitions from bare OS to running platform
-	// 这个顺序至关重要，代表了从裸操作系统到运行平台的状体转换
-	// Note: vCluster Deployment happens *after* Host K8s is fully up
-	// 注意：vCluster 部署发生在 Host K8s 完全启动之后
-
-	// Phase 1: Initialization (per node)
-	// 阶段 1：初始化（每个节点）
-	utils.GetLogger().Println("--- Running Initialization Phase ---")
-	for i, nodeCfg := range config.Cluster.Nodes {
-		nodeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute) // Context for node-specific phase
-		defer cancel()
-		utils.GetLogger().Printf("Initializing node %d/%d: %s", i+1, len(config.Cluster.Nodes), nodeCfg.Address)
-		if err := d.initializationPhase.Run(nodeCtx, &nodeCfg, &config.Cluster); err != nil {
-			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("initialization phase failed for node %s", nodeCfg.Address), err)
+	pipeline, err := d.pipeline(config)
+	if err != nil {
+		return err
+	}
+	if err := d.run.validatePhases(pipeline); err != nil {
+		return err
+	}
+	if err := validateNodeMetadata(config.Cluster.Nodes); err != nil {
+		return err
+	}
+	utils.GetLogger().Printf("Deployment pipeline: %s", strings.Join(phaseNames(pipeline), " -> "))
+
+	// Progress is checkpointed per node and phase so a failed deployment can be resumed.
+	// 进度按节点和阶段记录检查点，以便可以恢复失败的部署。
+	// Nodes are checked before the first phase touches any of them.
+	// 在第一个阶段改动任何节点之前先检查节点。
+	if d.run.selects(pipeline, PhaseInit) {
+		if err := d.runPreflight(ctx, config.Cluster.Nodes, nil, &config.Cluster); err != nil {
+			return err
 		}
-		utils.GetLogger().Printf("Node %s initialized successfully.", nodeCfg.Address)
-	}
-
-	// Phase 2: OS Configuration (per node)
-	// 阶段 2：操作系统配置（每个节点）
-	utils.GetLogger().Println("--- Running OS Configuration Phase ---")
-	for i, nodeCfg := range config.Cluster.Nodes {
-		nodeCtx, cancel := context.WithTimeout(ctx, 10*time.Minute) // Longer timeout for OS tasks
-		defer cancel()
-		utils.GetLogger().Printf("Configuring OS for node %d/%d: %s", i+1, len(config.Cluster.Nodes), nodeCfg.Address)
-		if err := d.osConfigPhase.Run(nodeCtx, &nodeCfg, &config.Cluster); err != nil {
-			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("OS configuration phase failed for node %s", nodeCfg.Address), err)
+	}
+
+	checkpoint, err := d.openCheckpoint(config)
+	if err != nil {
+		return err
+	}
+
+	// Phases record every item they check as unchanged, changed or failed; the summary is logged however the run ends.
+	// 各阶段将检查的每个条目记录为 unchanged、changed 或 failed；无论运行如何结束都会记录摘要。
+	report := plan.NewReport()
+	defer logReport("Deployment", report)
+
+	// Node-scoped phases fan out across the nodes; a phase only starts once the previous one has finished everywhere.
+	// 节点范围的阶段在各节点之间并行展开；只有当上一个阶段在所有节点上完成后，下一个阶段才会开始。
+	nodes := config.Cluster.Nodes
+	var failed NodeErrors
+	for _, p := range pipeline {
+		if !d.run.selects(pipeline, p.Name) {
+			utils.GetLogger().Printf("--- Skipping %s Phase (not selected) ---", p.displayName())
+			continue
 		}
-		utils.GetLogger().Printf("OS configured successfully for node %s.", nodeCfg.Address)
-	}
-
-	// Phase 3: Runtime Configuration (per node)
-	// 阶段 3：运行时配置（每个节点）
-	utils.GetLogger().Println("--- Running Runtime Configuration Phase ---")
-	for i, nodeCfg := range config.Cluster.Nodes {
-		nodeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-		defer cancel()
-		utils.GetLogger().Printf("Configuring Runtime for node %d/%d: %s", i+1, len(config.Cluster.Nodes), nodeCfg.Address)
-		if err := d.runtimeConfigPhase.Run(nodeCtx, &nodeCfg, &config.Cluster); err != nil {
-			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("runtime configuration phase failed for node %s", nodeCfg.Address), err)
+
+		if p.Scope == enum.PhaseScopeCluster {
+			if len(failed) > 0 {
+				// Cluster-scoped phases need every node, so stop here and report all failures together.
+				// 集群范围的阶段需要所有节点，因此在此停止并一并报告所有失败。
+				return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("node phases failed; skipping %s", p.displayName()), failed)
+			}
+			utils.GetLogger().Printf("--- Running %s Phase ---", p.displayName())
+			phaseCtx, cancel := context.WithTimeout(plan.WithReporter(ctx, report, ClusterTarget, p.Name), p.Timeout)
+			err := d.runStep(checkpoint, p.Name, ClusterTarget, func() error {
+				return p.RunCluster(phaseCtx, config)
+			})
+			cancel()
+			recordFailure(report, ClusterTarget, p, err)
+			if err != nil {
+				return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("%s phase failed", p.displayName()), err)
+			}
+			utils.GetLogger().Printf("%s phase completed successfully.", p.displayName())
+			continue
+		}
+
+		targets := nodesFor(p, nodes)
+		if len(targets) == 0 {
+			utils.GetLogger().Printf("--- Skipping %s Phase (no matching nodes) ---", p.displayName())
+			continue
 		}
-		utils.GetLogger().Printf("Runtime configured successfully for node %s.", nodeCfg.Address)
-	}
-
-	// Phase 4: Network Configuration (per node)
-	// 阶段 4：网络配置（每个节点）
-	utils.GetLogger().Println("--- Running Network Configuration Phase ---")
-	for i, nodeCfg := range config.Cluster.Nodes {
-		nodeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-		defer cancel()
-		utils.GetLogger().Printf("Configuring Network for node %d/%d: %s", i+1, len(config.Cluster.Nodes), nodeCfg.Address)
-		if err := d.networkConfigPhase.Run(nodeCtx, &nodeCfg, &config.Cluster); err != nil {
-			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("network configuration phase failed for node %s", nodeCfg.Address), err)
+		utils.GetLogger().Printf("--- Running %s Phase on %d node(s) (max parallel %d) ---", p.displayName(), len(targets), d.parallel.MaxParallel)
+		phaseFailed := RunOnNodes(ctx, p.displayName(), targets, p.Timeout, d.parallel, func(nodeCtx context.Context, nodeCfg *model.NodeConfig) error {
+			err := d.runStep(checkpoint, p.Name, nodeCfg.Address, func() error {
+				return p.RunNode(plan.WithReporter(nodeCtx, report, nodeCfg.Address, p.Name), config, nodeCfg)
+			})
+			recordFailure(report, nodeCfg.Address, p, err)
+			return err
+		})
+		if len(phaseFailed) == 0 {
+			continue
 		}
-		utils.GetLogger().Printf("Network configured successfully for node %s.", nodeCfg.Address)
-	}
-
-	// Phase 5: Storage Configuration (per node)
-	// 阶段 5：存储配置（每个节点）
-	utils.GetLogger().Println("--- Running Storage Configuration Phase ---")
-	for i, nodeCfg := range config.Cluster.Nodes {
-		nodeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-		defer cancel()
-		utils.GetLogger().Printf("Configuring Storage for node %d/%d: %s", i+1, len(config.Cluster.Nodes), nodeCfg.Address)
-		if err := d.storageConfigPhase.Run(nodeCtx, &nodeCfg, &config.Cluster); err != nil {
-			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("storage configuration phase failed for node %s", nodeCfg.Address), err)
+		failed = append(failed, phaseFailed...)
+		if d.parallel.FailurePolicy != enum.FailurePolicyContinue {
+			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("%s phase failed", p.displayName()), failed)
 		}
-		utils.GetLogger().Printf("Storage configured successfully for node %s.", nodeCfg.Address)
+		// With the continue policy the failed nodes drop out and the others carry on.
+		// 在 continue 策略下，失败的节点退出，其他节点继续执行。
+		nodes = excludeFailed(nodes, phaseFailed)
 	}
-
-	// Phase 6: Kubernetes Installation (orchestrated across nodes)
-	// 阶段 6：Kubernetes 安装（跨节点协调执行）
-	utils.GetLogger().Println("--- Running Kubernetes Installation Phase ---")
-	k8sInstallCtx, cancel := context.WithTimeout(ctx, 30*time.Minute) // Give K8s installation more time
-	defer cancel()
-	// This phase needs the full config and orchestrates across nodes
-	// 这个阶段需要完整的配置并在节点之间协调
-	if err := d.k8sInstallPhase.Run(k8sInstallCtx, config.Cluster.Nodes, &config.Cluster); err != nil {
-		return errors.NewWithCause(errors.ErrTypeSystem, "Kubernetes Host Cluster installation phase failed", err)
-	}
-	utils.GetLogger().Println("Kubernetes Host Cluster installed successfully.")
-
-	// --- Host Cluster is now UP ---
-	// At this point, the Host Cluster is running and accessible.
-	// 现在，Host 集群已经启动并可访问。
-	// We need to get a Kubernetes client to interact with it for the next phase.
-	// 我们需要获取一个 Kubernetes 客户端来与其交互以便进行下一阶段。
-
-	// TODO: Get Host K8s client
-	// TODO: 获取 Host K8s 客户端
-	// hostK8sClient, err := getHostK8sClient(config) // Need to implement this function
-	// if err != nil {
-	// 	return errors.NewWithCause(errors.ErrTypeSystem, "failed to get host Kubernetes client", err)
-	// }
-	var hostK8sClient interface{} // Placeholder for now - should be kubernetes.Interface
-	utils.GetLogger().Println("Placeholder: Acquired host Kubernetes client.")
-
-	// Phase 7: vCluster Deployment (orchestrated)
-	// 阶段 7：vCluster 部署（协调执行）
-	utils.GetLogger().Println("--- Running vCluster Deployment Phase ---")
-	vclusterDeployCtx, cancel := context.WithTimeout(ctx, 15*time.Minute) // Give vcluster deployment time
-	defer cancel()
-	// This phase needs the full platform config and the Host K8s client
-	// 这个阶段需要完整的平台配置和 Host K8s 客户端
-	if err := d.vclusterDeployPhase.Run(vclusterDeployCtx, config, hostK8sClient /* Pass config.VClusters etc. */); err != nil {
-		return errors.NewWithCause(errors.ErrTypeVCluster, "vcluster deployment phase failed", err)
+	if len(failed) > 0 {
+		return errors.NewWithCause(errors.ErrTypeSystem, "deployment finished with node failures", failed)
 	}
-	utils.GetLogger().Println("vClusters deployed successfully.")
-
-	// TODO: Add DFX deployment/configuration phase
-	// TODO: 添加 DFX 部署/配置阶段
 
+	d.saveInventory(config)
 	utils.GetLogger().Println("Platform deployment completed successfully.")
 	return nil
 }
 
+// Preflight runs the preflight checks of the initialization phase against all nodes.
+// Preflight 对所有节点运行初始化阶段的预检。
+func (d *defaultDeployer) Preflight(ctx context.Context, config *model.PlatformConfig) *preflight.Report {
+	return d.initializationPhase.Preflight(ctx, config.Cluster.Nodes, nil, &config.Cluster)
+}
+
+// runPreflight checks the nodes unless the run options skip it, logs the results and fails on any blocking failure.
+// runPreflight 检查节点（除非运行选项跳过），记录结果，并在出现任何阻止部署的失败时失败。
+func (d *defaultDeployer) runPreflight(ctx context.Context, nodes []model.NodeConfig, clusterNodes []model.NodeConfig, clusterCfg *model.ClusterConfig) error {
+	if d.run.SkipPreflight {
+		utils.GetLogger().Println("Warning: Skipping preflight checks as requested.")
+		return nil
+	}
+	report := d.initializationPhase.Preflight(ctx, nodes, clusterNodes, clusterCfg)
+	var table strings.Builder
+	if err := report.WriteTable(&table); err == nil {
+		utils.GetLogger().Printf("Preflight results:\n%s", table.String())
+	}
+	return report.Err()
+}
+
+// recordFailure records a failed step unless the phase already recorded which of its items failed.
+// recordFailure 记录失败的步骤，除非阶段已记录其哪个条目失败。
+func recordFailure(report *plan.Report, target string, p *PhaseDefinition, err error) {
+	if err == nil || report.HasFailure(target, p.Name) {
+		return
+	}
+	report.Add(plan.Result{Target: target, Phase: p.Name, Item: p.displayName(), Status: plan.StatusFailed, Error: err.Error()})
+}
+
+// logReport logs the changed and failed items of a run followed by its summary, e.g. "Deployment summary: 0 changed, 12 unchanged, 0 failed".
+// logReport 记录一次运行中已变更和失败的条目，随后记录其摘要，例如 "Deployment summary: 0 changed, 12 unchanged, 0 failed"。
+func logReport(operation string, report *plan.Report) {
+	for _, result := range report.Results() {
+		switch result.Status {
+		case plan.StatusChanged:
+			utils.GetLogger().Printf("  changed: [%s] %s: %s", result.Target, result.Phase, result.Item)
+		case plan.StatusFailed:
+			utils.GetLogger().Printf("  failed:  [%s] %s: %s: %s", result.Target, result.Phase, result.Item, result.Error)
+		}
+	}
+	utils.GetLogger().Printf("%s summary: %s", operation, report.Summary())
+}
+
+// PlanOptions controls how a deployment plan is rendered.
+// PlanOptions 控制部署计划的渲染方式。
+type PlanOptions struct {
+	// Diff connects to the nodes (read-only) and diffs every planned file against the file currently on the node.
+	// Diff 连接到节点（只读）并将每个计划的文件与节点上的当前文件进行比较。
+	Diff bool
+}
+
+// Plan walks the same phases as Deploy, honouring the phase selection, and collects their actions instead of applying them.
+// Plan 遍历与 Deploy 相同的阶段（遵循阶段选择），并收集其操作而不是应用它们。
+func (d *defaultDeployer) Plan(ctx context.Context, config *model.PlatformConfig, opts PlanOptions) (*plan.Plan, error) {
+	pipeline, err := d.pipeline(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.run.validatePhases(pipeline); err != nil {
+		return nil, err
+	}
+	hash, err := ConfigHash(config)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := config.Cluster.Nodes
+	result := plan.New(config.Metadata.Name, hash, addressesOf(nodes))
+	for _, p := range pipeline {
+		if !d.run.selects(pipeline, p.Name) {
+			continue
+		}
+		result.Phases = append(result.Phases, p.Name)
+
+		if p.Scope == enum.PhaseScopeCluster {
+			targets := []plan.TargetActions{{Target: plan.ClusterTarget, Actions: []plan.Action{plan.Opaque(p.displayName())}}}
+			if p.PlanCluster != nil {
+				if targets, err = p.PlanCluster(ctx, config); err != nil {
+					return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("failed to plan %s phase", p.displayName()), err)
+				}
+			}
+			for _, target := range targets {
+				result.Add(target.Target, p.Name, target.Actions)
+			}
+			continue
+		}
+
+		for _, nodeCfg := range nodesFor(p, nodes) {
+			actions := []plan.Action{plan.Opaque(p.displayName())}
+			if p.PlanNode != nil {
+				if actions, err = p.PlanNode(ctx, config, &nodeCfg); err != nil {
+					return nil, &NodeError{Node: nodeCfg.Address, Phase: p.displayName(), Err: err}
+				}
+			}
+			result.Add(nodeCfg.Address, p.Name, actions)
+		}
+	}
+
+	if opts.Diff {
+		// Reading the current files is the only remote access of a dry run.
+		// 读取当前文件是试运行中唯一的远程访问。
+		failed := RunOnNodes(ctx, "Plan Diff", nodes, 2*time.Minute, d.parallel, func(nodeCtx context.Context, nodeCfg *model.NodeConfig) error {
+			exec, err := d.newExecutor(nodeCtx, nodeCfg)
+			if err != nil {
+				return errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to connect to node %s", nodeCfg.Address), err)
+			}
+			defer exec.Close()
+			return result.DiffFiles(nodeCtx, nodeCfg.Address, plan.NodeFileReader(exec))
+		})
+		if len(failed) > 0 {
+			return nil, errors.NewWithCause(errors.ErrTypeNetwork, "failed to diff planned files against the nodes", failed)
+		}
+	}
+	return result, nil
+}
+
+// addressesOf returns the addresses of the nodes, in order.
+// addressesOf 按顺序返回节点的地址。
+func addressesOf(nodes []model.NodeConfig) []string {
+	out := make([]string, len(nodes))
+	for i := range nodes {
+		out[i] = nodes[i].Address
+	}
+	return out
+}
+
+// nodesFor returns the nodes a node-scoped phase applies to, preserving order.
+// nodesFor 返回节点范围阶段适用的节点，并保持顺序。
+func nodesFor(p *PhaseDefinition, nodes []model.NodeConfig) []model.NodeConfig {
+	targets := make([]model.NodeConfig, 0, len(nodes))
+	for i := range nodes {
+		if p.appliesTo(&nodes[i]) {
+			targets = append(targets, nodes[i])
+		}
+	}
+	return targets
+}
+
 // AddNode adds a new node to an existing Host Kubernetes cluster.
 // AddNode 将新节点添加到现有的 Host Kubernetes 集群。
 func (d *defaultDeployer) AddNode(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) error {
 	utils.GetLogger().Printf("Starting to add node %s to Host Cluster...", nodeCfg.Address)
 
-	// This process involves running a subset of the main deploy phases on the new node.
-	// 此过程涉及在新节点上运行主部署阶段的一个子集。
-	// Phase sequence for adding a node: Init -> OS Config -> Runtime Config -> Network Config -> Storage Config -> K8s Join
-	// 添加节点的阶段顺序：Init -> OS Config -> Runtime Config -> Network Config -> Storage Config -> K8s Join
-
-	// TODO: Implement AddNode logic by running specific phases for the new node
-	// TODO: 通过为新节点运行特定阶段来实现 AddNode 逻辑
+	// This process involves running the node-scoped phases of the pipeline on the new node.
+	// Phases that depend on a cluster-scoped phase (e.g., Kubernetes Installation) run after the node has joined.
+	// 此过程涉及在新节点上运行流水线中节点范围的阶段。
+	// 依赖集群范围阶段（例如 Kubernetes 安装）的阶段在节点加入后运行。
+	pipeline, err := d.pipeline(config)
+	if err != nil {
+		return err
+	}
+	beforeJoin, afterJoin := splitAtCluster(pipeline)
+	if err := validateNodeMetadata([]model.NodeConfig{*nodeCfg}); err != nil {
+		return err
+	}
 
 	nodeCtx, cancel := context.WithTimeout(ctx, 20*time.Minute) // Context for single node deployment
 	defer cancel()
+	// The hostname of the new node is compared with the nodes already in the cluster, which kubeadm join would reject.
+	// 新节点的主机名会与已在集群中的节点进行比较，kubeadm join 会拒绝重复的主机名。
+	if err := d.runPreflight(ctx, []model.NodeConfig{*nodeCfg}, d.clusterNodes(config, nodeCfg.Address), &config.Cluster); err != nil {
+		return err
+	}
+	report := plan.NewReport()
+	defer logReport("Add node", report)
 
-	// Run phases Init, OS Config, Runtime Config, Network Config, Storage Config
-	// 运行 Init, OS Config, Runtime Config, Network Config, Storage Config 阶段
-	phasesToRun := []struct {
-		name  string
-		phase phases.NodeSpecificPhase // Assuming a common interface for node phases
-	}{
-		{"Initialization", d.initializationPhase},
-		{"OS Configuration", d.osConfigPhase},
-		{"Runtime Configuration", d.runtimeConfigPhase},
-		{"Network Configuration", d.networkConfigPhase},
-		{"Storage Configuration", d.storageConfigPhase},
-	}
-
-	for _, p := range phasesToRun {
-		utils.GetLogger().Printf("--- Running %s Phase for new node %s ---", p.name, nodeCfg.Address)
-		// Need to check if the phase implements NodeSpecificPhase and call Run appropriately
-		// 需要检查阶段是否实现了 NodeSpecificPhase 并适当调用 Run
-		// For now, call Run assuming it takes nodeCfg and clusterCfg
-		// 现在，假设 Run 接受 nodeCfg 和 clusterCfg 来调用它
-		if err := p.phase.Run(nodeCtx, nodeCfg, &config.Cluster); err != nil { // Assuming Run method signature
-			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("%s phase failed for new node %s", p.name, nodeCfg.Address), err)
-		}
-		utils.GetLogger().Printf("%s phase completed successfully for new node %s.", p.name, nodeCfg.Address)
+	if err := d.runNodePhases(nodeCtx, config, nodeCfg, beforeJoin, report); err != nil {
+		return err
 	}
 
 	// Run K8s Join Phase specifically for the new node
 	// 专门为新节点运行 K8s Join Phase
 	utils.GetLogger().Printf("--- Running Kubernetes Join Phase for new node %s ---", nodeCfg.Address)
-	// The K8sInstallPhase.Run method currently takes ALL nodes.
-	// We need to adapt it to only *join* a specific node to an *existing* cluster.
-	// K8sInstallPhase.Run 方法当前接受所有节点。
-	// 我们需要对其进行调整，使其只将特定节点加入到现有集群中。
-	// This likely requires calling kubeadm join remotely on the new node using SSH.
-	// 这可能需要通过 SSH 在新节点上远程调用 kubeadm join。
-	// It also needs the join command/token from the existing cluster (often fetched from a master).
-	// 它还需要从现有集群获取 join command/token（通常从主节点获取）。
-
-	// TODO: Get join command/token from an existing master node
-	// TODO: 从现有主节点获取 join command/token
-	// TODO: Execute `sudo kubeadm join ...` on the new node via SSH
-	// TODO: 通过 SSH 在新节点上执行 `sudo kubeadm join ...`
-	utils.GetLogger().Printf("Placeholder: Running kubeadm join on new node %s.", nodeCfg.Address)
-
-	// TODO: Wait for the new node to become Ready in the Host Cluster
-	// TODO: 等待新节点在 Host Cluster 中变为 Ready
-	utils.GetLogger().Printf("Placeholder: Waiting for new node %s to become Ready.", nodeCfg.Address)
+	// The join command/token is fetched from an existing master of the cluster.
+	// join command/token 从集群中现有的主节点获取。
+	if err := d.k8sInstallPhase.JoinNode(nodeCtx, nodeCfg, &config.Cluster); err != nil {
+		report.Add(plan.Result{Target: nodeCfg.Address, Phase: PhaseKubernetes, Item: "Kubernetes Join", Status: plan.StatusFailed, Error: err.Error()})
+		return &NodeError{Node: nodeCfg.Address, Phase: "Kubernetes Join", Err: err}
+	}
+
+	if err := d.runNodePhases(nodeCtx, config, nodeCfg, afterJoin, report); err != nil {
+		return err
+	}
+
+	// Apply the declared labels, annotations and taints once the node has registered.
+	// 节点注册后应用声明的标签、注解和污点。
+	if err := d.reconcileNodeMetadata(plan.WithReporter(nodeCtx, report, nodeCfg.Address, PhaseKubernetes), config, []model.NodeConfig{*nodeCfg}); err != nil {
+		return err
+	}
+
+	// The node is only reported as added once it is Ready, as lifecycle apply reports it.
+	// 只有在节点处于 Ready 状态后才报告其已添加，与 lifecycle apply 的报告方式一致。
+	hostK8sClient, err := d.hostK8sClient(config)
+	if err != nil {
+		return err
+	}
+	utils.GetLogger().Printf("Waiting for node %s to become Ready...", nodeCfg.Address)
+	if err := waitForNodeReady(nodeCtx, hostK8sClient, nodeCfg.Address); err != nil {
+		report.Add(plan.Result{Target: nodeCfg.Address, Phase: PhaseKubernetes, Item: "Node Ready", Status: plan.StatusFailed, Error: err.Error()})
+		return &NodeError{Node: nodeCfg.Address, Phase: "Node Ready", Err: err}
+	}
 
 	utils.GetLogger().Printf("Node %s added to Host Cluster successfully.", nodeCfg.Address)
 	return nil
 }
 
+// ReconfigureNode re-runs the node-specific phases on an existing node.
+// ReconfigureNode 在现有节点上重新运行节点特定阶段。
+func (d *defaultDeployer) ReconfigureNode(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) error {
+	utils.GetLogger().Printf("Reconfiguring node %s...", nodeCfg.Address)
+	pipeline, err := d.pipeline(config)
+	if err != nil {
+		return err
+	}
+	report := plan.NewReport()
+	defer logReport("Reconfigure", report)
+	if err := d.runNodePhases(ctx, config, nodeCfg, pipeline, report); err != nil {
+		return err
+	}
+	utils.GetLogger().Printf("Node %s reconfigured successfully.", nodeCfg.Address)
+	return nil
+}
+
+// splitAtCluster splits the node-scoped phases of a pipeline into those that can run before the node joins the cluster
+// and those that depend, directly or transitively, on a cluster-scoped phase.
+// splitAtCluster 将流水线中节点范围的阶段拆分为可以在节点加入集群之前运行的阶段，以及直接或间接依赖集群范围阶段的阶段。
+func splitAtCluster(pipeline []*PhaseDefinition) (beforeJoin, afterJoin []*PhaseDefinition) {
+	needsCluster := map[string]bool{}
+	for _, p := range pipeline { // The pipeline is topologically sorted, so dependencies are already classified / 流水线已拓扑排序，因此依赖已分类
+		needsCluster[p.Name] = p.Scope == enum.PhaseScopeCluster
+		for _, dep := range p.DependsOn {
+			needsCluster[p.Name] = needsCluster[p.Name] || needsCluster[dep]
+		}
+		if p.Scope != enum.PhaseScopeNode {
+			continue
+		}
+		if needsCluster[p.Name] {
+			afterJoin = append(afterJoin, p)
+		} else {
+			beforeJoin = append(beforeJoin, p)
+		}
+	}
+	return beforeJoin, afterJoin
+}
+
+// runNodePhases runs the given node-scoped phases on a single node, stopping at the first failure.
+// Item outcomes are recorded in report.
+// runNodePhases 在单个节点上运行给定的节点范围阶段，遇到第一个失败即停止。
+// 条目结果记录在 report 中。
+// Cluster-scoped phases and phases not applying to the node's roles are skipped.
+// The returned error is a *NodeError naming the node and the failed phase.
+// 跳过集群范围的阶段以及不适用于节点角色的阶段。
+// 返回的错误是一个 *NodeError，其中包含节点和失败的阶段。
+func (d *defaultDeployer) runNodePhases(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig, pipeline []*PhaseDefinition, report *plan.Report) error {
+	for _, p := range pipeline {
+		if p.Scope != enum.PhaseScopeNode || !p.appliesTo(nodeCfg) {
+			continue
+		}
+		utils.GetLogger().Printf("--- Running %s Phase for node %s ---", p.displayName(), nodeCfg.Address)
+		phaseCtx, cancel := context.WithTimeout(plan.WithReporter(ctx, report, nodeCfg.Address, p.Name), p.Timeout)
+		err := p.RunNode(phaseCtx, config, nodeCfg)
+		cancel()
+		recordFailure(report, nodeCfg.Address, p, err)
+		if err != nil {
+			return &NodeError{Node: nodeCfg.Address, Phase: p.displayName(), Err: err}
+		}
+		utils.GetLogger().Printf("%s phase completed successfully for node %s.", p.displayName(), nodeCfg.Address)
+	}
+	return nil
+}
+
 // RemoveNode removes a node from an existing Host Kubernetes cluster.
 // RemoveNode 从现有的 Host Kubernetes 集群中移除节点。
-func (d *defaultDeployer) RemoveNode(ctx context.Context, nodeCfg *model.NodeConfig, hostK8sClient interface{} /* kubernetes.Interface */) error {
+func (d *defaultDeployer) RemoveNode(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig, hostK8sClient kubernetes.Interface) error {
 	utils.GetLogger().Printf("Starting to remove node %s from Host Cluster...", nodeCfg.Address)
+	if hostK8sClient == nil {
+		return errors.New(errors.ErrTypeInternal, "removing a node requires a Host Cluster client")
+	}
+	report := plan.NewReport()
+	defer logReport("Remove node", report)
+	ctx = plan.WithReporter(ctx, report, nodeCfg.Address, PhaseKubernetes)
+
+	// Step 1: Drain the node so its workloads move elsewhere. The Node name may differ from the configured address.
+	// 步骤 1：排空节点，使其工作负载迁移到其他地方。Node 名称可能与配置的地址不同。
+	node, err := findNode(ctx, hostK8sClient, nodeCfg.Address)
+	if err != nil {
+		return err
+	}
+	nodeName := ""
+	if node == nil {
+		utils.GetLogger().Printf("Node %s is not registered in the cluster; skipping the drain.", nodeCfg.Address)
+	} else {
+		nodeName = node.Name
+		if err := drainNode(ctx, hostK8sClient, node, d.drain); err != nil {
+			plan.Record(ctx, "Drain node "+nodeName, plan.StatusFailed, err)
+			return &NodeError{Node: nodeCfg.Address, Phase: "Drain", Err: err}
+		}
+		plan.Record(ctx, "Drain node "+nodeName, plan.StatusChanged, nil)
+	}
+
+	// Step 2: A master leaves etcd while the remaining members still have quorum with it counted.
+	// 步骤 2：主节点在剩余成员（将其计算在内）仍具有仲裁时离开 etcd。
+	if hasRole(nodeCfg, enum.RoleMaster) {
+		if err := d.k8sInstallPhase.RemoveEtcdMember(ctx, nodeName, nodeCfg, &config.Cluster); err != nil {
+			return &NodeError{Node: nodeCfg.Address, Phase: "Remove etcd member", Err: err}
+		}
+	}
+
+	// Step 3: Delete the Node object.
+	// 步骤 3：删除 Node 对象。
+	if node != nil {
+		err := hostK8sClient.CoreV1().Nodes().Delete(ctx, nodeName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			plan.Record(ctx, "Delete node "+nodeName, plan.StatusFailed, err)
+			return &NodeError{Node: nodeCfg.Address, Phase: "Delete Node", Err: err}
+		}
+		plan.Record(ctx, "Delete node "+nodeName, plan.StatusChanged, nil)
+	}
 
-	// This involves draining the node, removing it from the cluster, and potentially cleaning up the OS.
-	// 这涉及排空节点、将其从集群中移除，以及可能清理操作系统。
-
-	// TODO: Get Host K8s client using the provided interface{}
-	// TODO: 使用提供的 interface{} 获取 Host K8s 客户端
-	// k8sClient, ok := hostK8sClient.(kubernetes.Interface)
-	// if !ok { return errors.New(errors.ErrTypeInternal, "invalid Host Kubernetes client provided") }
-
-	// Step 1: Drain the node (move pods to other nodes)
-	// 步骤 1：排空节点（将 pod 移走）
-	utils.GetLogger().Printf("Draining node %s...", nodeCfg.Address)
-	// This requires the Host K8s client and the node's Kubernetes name (which might be different from address).
-	// Needs the node's Kubernetes name (obj.Name from List(Nodes)).
-	// 这需要 Host K8s 客户端和节点的 Kubernetes 名称（可能与地址不同）。
-	// 需要节点的 Kubernetes 名称（来自 List(Nodes) 的 obj.Name）。
-	// Assume we can get the Kubernetes node name from its address or config.
-	// 假设我们可以从节点的地址或配置中获取其 Kubernetes 节点名称。
-	k8sNodeName := nodeCfg.Address // Placeholder - should find the actual K8s node object
-	// TODO: Implement drain logic using client-go corev1.Nodes().Evict() or related functions
-	// Can use kubectl drain equivalent logic via client-go.
-	// 可以使用 client-go 实现 kubectl drain 的等效逻辑。
-	utils.GetLogger().Printf("Placeholder: Node %s drained.", nodeCfg.Address)
-
-	// Step 2: Delete the node from the cluster
-	// 步骤 2：从集群中删除节点
-	utils.GetLogger().Printf("Deleting node %s from cluster...", k8sNodeName)
-	// TODO: Implement deletion logic using client-go corev1.Nodes().Delete()
-	utils.GetLogger().Printf("Placeholder: Node %s deleted from cluster.", k8sNodeName)
-
-	// Step 3: Clean up the OS on the removed node (optional, depends on full deprovisioning vs reuse)
-	// 步骤 3：清理已移除节点上的操作系统（可选，取决于完全解除供应还是重用）
-	// This might involve running `kubeadm reset` remotely via SSH.
-	// 这可能涉及通过 SSH 远程运行 `kubeadm reset`。
-	utils.GetLogger().Printf("Placeholder: Running OS cleanup on node %s (e.g., kubeadm reset).", nodeCfg.Address)
-	// TODO: Get SSH client
-	// TODO: sshClient.RunCommand(ctx, "sudo kubeadm reset --force")
+	// Step 4: Clean up the machine so it can be reused or join again.
+	// 步骤 4：清理机器，以便可以重用或再次加入。
+	utils.GetLogger().Printf("Running kubeadm reset and network cleanup on node %s...", nodeCfg.Address)
+	if err := d.k8sInstallPhase.ResetNode(ctx, nodeCfg, &config.Cluster); err != nil {
+		if !d.drain.Force {
+			return &NodeError{Node: nodeCfg.Address, Phase: "Reset", Err: err}
+		}
+		utils.GetLogger().Printf("Warning: Node %s left the cluster but could not be cleaned up: %v", nodeCfg.Address, err)
+	}
 
 	utils.GetLogger().Printf("Node %s removed from Host Cluster successfully.", nodeCfg.Address)
 	return nil
 }
 
-// Placeholder function to get Host K8s client - requires client-go and kubeconfig loading
-// 获取 Host K8s 客户端的占位符函数 - 需要 client-go 和 kubeconfig 加载
-// func getHostK8sClient(config *model.PlatformConfig) (kubernetes.Interface, error) {
-// 	// This function would typically read kubeconfig from a well-known location
-// 	// on the master node or construct it based on cluster configuration.
-// 	// For a real implementation, you'd use clientcmd.BuildConfigFromFlags or clientcmd.RESTConfigFromKubeConfig
-// 	// 这个函数通常会从主节点上的已知位置读取 kubeconfig，或根据集群配置构造它。
-// 	// 对于实际实现，您将使用 clientcmd.BuildConfigFromFlags 或 clientcmd.RESTConfigFromKubeConfig
-//
-// 	// Example using a hypothetical kubeconfig path derived from config
-// 	// 示例使用从配置派生的假设 kubeconfig 路径
-// 	// kubeconfigPath := filepath.Join(constants.DefaultDataDir, config.Metadata.Name, "kubeconfig")
-// 	// config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-// 	// if err != nil { return nil, err }
-// 	// return kubernetes.NewForConfig(config)
-//
-// 	return nil, errors.New(errors.ErrTypeNotImplemented, "getting host Kubernetes client not implemented")
-// }
-
-// Ensure node-specific phases implement the marker interface (for the example loop above)
-// 确保节点特定的阶段实现了标记接口（用于上面的示例循环）
+// hostK8sClient returns the client of the Host Cluster: the configured one, or one built from the admin kubeconfig
+// the Kubernetes phase exported.
+// hostK8sClient 返回 Host 集群的客户端：已配置的客户端，或根据 Kubernetes 阶段导出的管理员 kubeconfig 构建的客户端。
+func (d *defaultDeployer) hostK8sClient(config *model.PlatformConfig) (kubernetes.Interface, error) {
+	if d.hostClient != nil {
+		return d.hostClient, nil
+	}
+	kubeconfigPath := phases.KubeconfigPath(phases.DefaultKubeconfigDir, config.Cluster.Name)
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("failed to load the Host Cluster kubeconfig %s", kubeconfigPath), err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeSystem, "failed to create the Host Cluster client", err)
+	}
+	return client, nil
+}
+
+// Ensure the built-in node-specific phases implement the common interface used by builtinPhases
+// 确保内置的节点特定阶段实现了 builtinPhases 使用的通用接口
 var _ phases.NodeSpecificPhase = &phases.DefaultInitPhase{}
 var _ phases.NodeSpecificPhase = &phases.DefaultOSConfigPhase{}
 var _ phases.NodeSpecificPhase = &phases.DefaultRuntimeConfigPhase{}
@@ -0,0 +1,127 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+	"github.com/turtacn/chasi-bod/pkg/deployer/preflight"
+)
+
+// stepPhases returns the phase names of a plan target's steps.
+func stepPhases(target *plan.Target) []string {
+	var names []string
+	for _, step := range target.Steps {
+		names = append(names, step.Phase)
+	}
+	return names
+}
+
+func TestPlan_CollectsActionsWithoutRunningPhases(t *testing.T) {
+	utils.InitLogger("info", 0)
+	config := testPlatformConfig()
+	config.Cluster.Nodes[0].Roles = []enum.NodeRole{enum.RoleMaster}
+	config.Phases = []model.PhaseConfig{{Name: "harden", DependsOn: []string{PhaseOS}, Roles: []enum.NodeRole{enum.RoleMaster}, Script: "/opt/corp/harden.sh", Sudo: true}}
+	rec := &callRecorder{}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{}, recordingPhase(rec, "label", []string{PhaseKubernetes}))
+
+	result, err := d.Plan(context.Background(), config, PlanOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, rec.reset(), "planning must not run any phase")
+
+	assert.Equal(t, []string{PhaseInit, PhaseOS, PhaseRuntime, PhaseNetwork, PhaseStorage, "harden", PhaseKubernetes, "label", PhaseVCluster}, result.Phases)
+	require.Len(t, result.Targets, 3)
+	assert.Equal(t, []string{PhaseInit, PhaseOS, PhaseRuntime, PhaseNetwork, PhaseStorage, "harden", PhaseKubernetes, "label"}, stepPhases(&result.Targets[0]))
+	assert.Equal(t, []string{PhaseInit, PhaseOS, PhaseRuntime, PhaseNetwork, PhaseStorage, "label"}, stepPhases(&result.Targets[1]), "the script phase only targets masters")
+	assert.Equal(t, plan.ClusterTarget, result.Targets[2].Name)
+
+	harden := result.Targets[0].Steps[5].Actions[0]
+	assert.Equal(t, plan.SudoCommand("Run script phase harden", "/opt/corp/harden.sh"), harden)
+	assert.Equal(t, plan.ActionOpaque, result.Targets[1].Steps[5].Actions[0].Kind, "Go phases without a planner are shown as opaque")
+	assert.Equal(t, plan.ActionHelmRelease, result.Targets[2].Steps[0].Actions[0].Kind)
+
+	first, err := result.Render("yaml")
+	require.NoError(t, err)
+	again, err := d.Plan(context.Background(), config, PlanOptions{})
+	require.NoError(t, err)
+	second, err := again.Render("yaml")
+	require.NoError(t, err)
+	assert.Equal(t, string(first), string(second), "the rendered plan is stable")
+}
+
+func TestPlan_HonoursPhaseSelection(t *testing.T) {
+	utils.InitLogger("info", 0)
+	rec := &callRecorder{}
+	result, err := newFakeDeployer(rec, t.TempDir(), RunOptions{FromPhase: PhaseKubernetes}).Plan(context.Background(), testPlatformConfig(), PlanOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{PhaseKubernetes, PhaseVCluster}, result.Phases)
+	assert.Equal(t, []string{PhaseKubernetes}, stepPhases(&result.Targets[0]))
+	assert.Empty(t, result.Targets[1].Steps)
+
+	_, err = newFakeDeployer(rec, t.TempDir(), RunOptions{OnlyPhase: "bogus"}).Plan(context.Background(), testPlatformConfig(), PlanOptions{})
+	assert.Error(t, err)
+}
+
+func TestDeploy_LogsChangeSummary(t *testing.T) {
+	utils.InitLogger("info", 0)
+	var logs bytes.Buffer
+	utils.GetLogger().SetOutput(&logs)
+	t.Cleanup(func() { utils.GetLogger().SetOutput(os.Stdout) })
+
+	converge := PhaseDefinition{
+		Name:      "converge",
+		Scope:     enum.PhaseScopeNode,
+		DependsOn: []string{PhaseStorage},
+		Timeout:   time.Minute,
+		RunNode: func(ctx context.Context, _ *model.PlatformConfig, nodeCfg *model.NodeConfig) error {
+			status := plan.StatusUnchanged
+			if nodeCfg.Address == "10.0.0.1" {
+				status = plan.StatusChanged
+			}
+			plan.Record(ctx, "Converge "+nodeCfg.Address, status, nil)
+			return nil
+		},
+	}
+	rec := &callRecorder{fail: map[string]bool{PhaseNetwork + "@10.0.0.2": true}}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{}, converge)
+	d.parallel.FailurePolicy = enum.FailurePolicyContinue
+	require.Error(t, d.Deploy(context.Background(), testPlatformConfig()))
+
+	assert.Contains(t, logs.String(), "changed: [10.0.0.1] converge: Converge 10.0.0.1")
+	assert.Contains(t, logs.String(), "failed:  [10.0.0.2] network: Network Configuration: injected failure")
+	assert.Contains(t, logs.String(), "Deployment summary: 1 changed, 0 unchanged, 1 failed", "the failed node drops out before converge")
+}
+
+func TestDeploy_PreflightFailureBlocksEveryPhase(t *testing.T) {
+	utils.InitLogger("info", 0)
+	rec := &callRecorder{}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{})
+	d.initializationPhase.(*fakeInitPhase).preflight = []preflight.Result{
+		{Node: "10.0.0.1", Check: preflight.CheckSwap, Status: preflight.StatusFail, Message: "swap is enabled on /swap.img"},
+		{Node: "10.0.0.2", Check: preflight.CheckCgroup, Status: preflight.StatusWarn, Message: "v1"},
+	}
+
+	err := d.Deploy(context.Background(), testPlatformConfig())
+	require.Error(t, err)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+	assert.Contains(t, err.Error(), "10.0.0.1 swap: swap is enabled on /swap.img")
+	assert.NotContains(t, err.Error(), "cgroup", "warnings do not block")
+	assert.Empty(t, rec.reset(), "no phase runs when a node is unfit")
+
+	d.run.SkipPreflight = true
+	require.NoError(t, d.Deploy(context.Background(), testPlatformConfig()))
+	assert.NotEmpty(t, rec.reset())
+
+	d.run = RunOptions{FromPhase: PhaseKubernetes}
+	require.NoError(t, d.Deploy(context.Background(), testPlatformConfig()), "preflight only guards runs that include the initialization phase")
+}
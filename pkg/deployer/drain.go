@@ -0,0 +1,227 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/deployer/phases"
+)
+
+// mirrorPodAnnotation marks the API mirror of a static pod, which cannot be evicted.
+// mirrorPodAnnotation 标记静态 Pod 的 API 镜像，它无法被驱逐。
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// drainPollInterval is how often evictions blocked by a PodDisruptionBudget are retried.
+// drainPollInterval 是被 PodDisruptionBudget 阻止的驱逐的重试间隔。
+var drainPollInterval = 5 * time.Second
+
+// DrainOptions controls how a node is drained before it is removed.
+// DrainOptions 控制节点在移除之前如何被排空。
+type DrainOptions struct {
+	// Timeout bounds how long evictions may be blocked by PodDisruptionBudgets (values <= 0 mean DefaultDrainTimeout).
+	// Timeout 限制驱逐被 PodDisruptionBudget 阻止的最长时间（小于等于 0 的值视为 DefaultDrainTimeout）。
+	Timeout time.Duration
+	// Force deletes pods without a controller and pods still blocked when the timeout expires, instead of failing.
+	// Force 删除没有控制器的 Pod 以及超时后仍被阻止的 Pod，而不是失败。
+	Force bool
+}
+
+// DefaultDrainOptions returns the drain settings used when nothing else is configured.
+// DefaultDrainOptions 返回未进行其他配置时使用的排空设置。
+func DefaultDrainOptions() DrainOptions {
+	return DrainOptions{Timeout: constants.DefaultDrainTimeout}
+}
+
+// findNode looks up the Node registered for a configured address, returning nil if there is none.
+// findNode 查找为配置的地址注册的 Node，如果不存在则返回 nil。
+func findNode(ctx context.Context, client kubernetes.Interface, address string) (*corev1.Node, error) {
+	list, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeSystem, "failed to list the Host Cluster nodes", err)
+	}
+	return phases.MatchNode(address, list.Items), nil
+}
+
+// cordonNode marks a Node unschedulable.
+// cordonNode 将 Node 标记为不可调度。
+func cordonNode(ctx context.Context, client kubernetes.Interface, node *corev1.Node) error {
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	if _, err := client.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to cordon node %s", node.Name), err)
+	}
+	utils.GetLogger().Printf("Node %s cordoned.", node.Name)
+	return nil
+}
+
+// podsToEvict lists the pods that must leave a node. DaemonSet pods and static pods stay; they go away with the node.
+// podsToEvict 列出必须离开节点的 Pod。DaemonSet Pod 和静态 Pod 保留；它们随节点一起消失。
+// Pods without a controller would not be recreated elsewhere, so they are only evicted when forced.
+// 没有控制器的 Pod 不会在其他地方重新创建，因此只有在强制时才会被驱逐。
+func podsToEvict(ctx context.Context, client kubernetes.Interface, nodeName string, force bool) ([]corev1.Pod, error) {
+	list, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String()})
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to list the pods on node %s", nodeName), err)
+	}
+	var pods []corev1.Pod
+	var unmanaged []string
+	for _, pod := range list.Items {
+		if pod.Spec.NodeName != nodeName {
+			continue
+		}
+		if _, mirror := pod.Annotations[mirrorPodAnnotation]; mirror {
+			continue
+		}
+		controller := metav1.GetControllerOf(&pod)
+		if controller != nil && controller.Kind == "DaemonSet" {
+			continue
+		}
+		finished := pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+		if controller == nil && !finished && !force {
+			unmanaged = append(unmanaged, pod.Namespace+"/"+pod.Name)
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	if len(unmanaged) > 0 {
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s runs pods without a controller that would be lost: %s; delete them or force the drain", nodeName, strings.Join(unmanaged, ", ")))
+	}
+	return pods, nil
+}
+
+// drainNode cordons a Node and evicts its pods through the Eviction API, so PodDisruptionBudgets are honored.
+// drainNode 封锁 Node 并通过 Eviction API 驱逐其 Pod，从而遵守 PodDisruptionBudget。
+// Evictions refused by a budget are retried until the timeout; then the remaining pods are deleted if forced.
+// 被预算拒绝的驱逐会重试直到超时；之后如果强制，则删除剩余的 Pod。
+// It returns once every evicted pod is gone from the node.
+// 所有被驱逐的 Pod 都从节点上消失后返回。
+func drainNode(ctx context.Context, client kubernetes.Interface, node *corev1.Node, opts DrainOptions) error {
+	if err := cordonNode(ctx, client, node); err != nil {
+		return err
+	}
+	pods, err := podsToEvict(ctx, client, node.Name, opts.Force)
+	if err != nil {
+		return err
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = constants.DefaultDrainTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	utils.GetLogger().Printf("Draining node %s: evicting %d pod(s) (timeout %s).", node.Name, len(pods), timeout)
+
+	pending := pods
+	for {
+		var blocked []corev1.Pod
+		for _, pod := range pending {
+			err := client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}})
+			switch {
+			case err == nil, apierrors.IsNotFound(err):
+			case apierrors.IsTooManyRequests(err):
+				// The PodDisruptionBudget allows no disruption right now.
+				// PodDisruptionBudget 当前不允许中断。
+				blocked = append(blocked, pod)
+			default:
+				return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to evict pod %s/%s from node %s", pod.Namespace, pod.Name, node.Name), err)
+			}
+		}
+		pending = blocked
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			if !opts.Force {
+				return errors.New(errors.ErrTypeTimeout, fmt.Sprintf("timed out draining node %s: PodDisruptionBudgets still block %s", node.Name, podNames(pending)))
+			}
+			utils.GetLogger().Printf("Warning: Deleting pods still blocked by PodDisruptionBudgets on node %s: %s", node.Name, podNames(pending))
+			for _, pod := range pending {
+				if err := client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+					return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to delete pod %s/%s", pod.Namespace, pod.Name), err)
+				}
+			}
+			break
+		}
+		if err := sleepContext(ctx, drainPollInterval); err != nil {
+			return err
+		}
+	}
+
+	return waitForPodsGone(ctx, client, node.Name, pods, deadline, opts.Force)
+}
+
+// waitForPodsGone waits until the evicted pods have terminated. A pod replaced under the same name has a new UID.
+// waitForPodsGone 等待被驱逐的 Pod 终止。以相同名称替换的 Pod 具有新的 UID。
+// Once the deadline passed, a forced drain stops waiting; the pods go away with the node.
+// 超过截止时间后，强制排空停止等待；这些 Pod 随节点一起消失。
+func waitForPodsGone(ctx context.Context, client kubernetes.Interface, nodeName string, pods []corev1.Pod, deadline time.Time, force bool) error {
+	remaining := pods
+	for len(remaining) > 0 {
+		var running []corev1.Pod
+		for _, pod := range remaining {
+			current, err := client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			switch {
+			case apierrors.IsNotFound(err):
+			case err != nil:
+				return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to get pod %s/%s", pod.Namespace, pod.Name), err)
+			case current.UID == pod.UID:
+				running = append(running, pod)
+			}
+		}
+		remaining = running
+		if len(remaining) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			if force {
+				utils.GetLogger().Printf("Warning: Pods on node %s are still terminating: %s", nodeName, podNames(remaining))
+				return nil
+			}
+			return errors.New(errors.ErrTypeTimeout, fmt.Sprintf("timed out waiting for pods on node %s to terminate: %s", nodeName, podNames(remaining)))
+		}
+		if err := sleepContext(ctx, drainPollInterval); err != nil {
+			return err
+		}
+	}
+	utils.GetLogger().Printf("Node %s drained.", nodeName)
+	return nil
+}
+
+// podNames lists pods as namespace/name in a stable order.
+// podNames 以稳定的顺序将 Pod 列为 namespace/name。
+func podNames(pods []corev1.Pod) string {
+	names := make([]string, len(pods))
+	for i, pod := range pods {
+		names[i] = pod.Namespace + "/" + pod.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// sleepContext waits for d or until ctx is done.
+// sleepContext 等待 d 或直到 ctx 结束。
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
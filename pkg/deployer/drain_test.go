@@ -0,0 +1,141 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+func testPod(name, ownerKind string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if ownerKind != "" {
+		controller := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: name + "-owner", Controller: &controller}}
+	}
+	return pod
+}
+
+// newDrainClient returns a fake clientset with node-1 and the given pods. Evicting a pod deletes it,
+// unless blocked says a PodDisruptionBudget refuses it.
+// newDrainClient 返回包含 node-1 和给定 Pod 的假 clientset。驱逐 Pod 会删除它，除非 blocked 表示 PodDisruptionBudget 拒绝了它。
+func newDrainClient(blocked map[string]bool, pods ...runtime.Object) (*fake.Clientset, *[]string) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}}},
+	}
+	client := fake.NewSimpleClientset(append(pods, node)...)
+	var evicted []string
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		if blocked[eviction.Name] {
+			return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		evicted = append(evicted, eviction.Name)
+		err := client.Tracker().Delete(schema.GroupVersionResource{Version: "v1", Resource: "pods"}, eviction.Namespace, eviction.Name)
+		return true, nil, err
+	})
+	return client, &evicted
+}
+
+func TestDrainNode(t *testing.T) {
+	utils.InitLogger("info", 0)
+	drainPollInterval = time.Millisecond
+	mirror := testPod("kube-proxy-static", "Node")
+	mirror.Annotations = map[string]string{mirrorPodAnnotation: "hash"}
+	client, evicted := newDrainClient(nil, testPod("web", "ReplicaSet"), testPod("fluentd", "DaemonSet"), mirror)
+
+	node, err := findNode(context.Background(), client, "10.0.0.1")
+	require.NoError(t, err)
+	require.NotNil(t, node)
+	require.NoError(t, drainNode(context.Background(), client, node, DrainOptions{Timeout: time.Second}))
+
+	assert.Equal(t, []string{"web"}, *evicted, "DaemonSet and static pods stay on the node")
+	updated, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, updated.Spec.Unschedulable)
+}
+
+func TestDrainNode_Blocked(t *testing.T) {
+	utils.InitLogger("info", 0)
+	drainPollInterval = time.Millisecond
+
+	client, _ := newDrainClient(map[string]bool{"db": true}, testPod("db", "StatefulSet"))
+	node, err := findNode(context.Background(), client, "node-1")
+	require.NoError(t, err)
+	err = drainNode(context.Background(), client, node, DrainOptions{Timeout: 20 * time.Millisecond})
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeTimeout))
+	assert.Contains(t, err.Error(), "default/db")
+
+	require.NoError(t, drainNode(context.Background(), client, node, DrainOptions{Timeout: 20 * time.Millisecond, Force: true}))
+	_, err = client.CoreV1().Pods("default").Get(context.Background(), "db", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "a forced drain deletes pods the budget still protects")
+}
+
+func TestDrainNode_UnmanagedPods(t *testing.T) {
+	utils.InitLogger("info", 0)
+	drainPollInterval = time.Millisecond
+
+	client, evicted := newDrainClient(nil, testPod("debug", ""))
+	node, err := findNode(context.Background(), client, "node-1")
+	require.NoError(t, err)
+	err = drainNode(context.Background(), client, node, DrainOptions{Timeout: time.Second})
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+	assert.Empty(t, *evicted)
+
+	require.NoError(t, drainNode(context.Background(), client, node, DrainOptions{Timeout: time.Second, Force: true}))
+	assert.Equal(t, []string{"debug"}, *evicted)
+}
+
+func TestRemoveNode(t *testing.T) {
+	utils.InitLogger("info", 0)
+	drainPollInterval = time.Millisecond
+	client, evicted := newDrainClient(nil, testPod("web", "ReplicaSet"))
+	rec := &callRecorder{}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{})
+	config := testPlatformConfig()
+	nodeCfg := model.NodeConfig{Address: "10.0.0.1", Roles: []enum.NodeRole{enum.RoleMaster}}
+
+	require.NoError(t, d.RemoveNode(context.Background(), config, &nodeCfg, client))
+	assert.Equal(t, []string{"web"}, *evicted)
+	assert.Equal(t, []string{"remove-etcd-member@10.0.0.1", "reset@10.0.0.1"}, rec.reset())
+	_, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// The node is gone from the cluster; removing it again only cleans up the machine.
+	// 节点已从集群中消失；再次移除只会清理机器。
+	nodeCfg.Roles = []enum.NodeRole{enum.RoleWorker}
+	require.NoError(t, d.RemoveNode(context.Background(), config, &nodeCfg, client))
+	assert.Equal(t, []string{"reset@10.0.0.1"}, rec.reset())
+
+	rec.fail = map[string]bool{"reset@10.0.0.1": true}
+	err = d.RemoveNode(context.Background(), config, &nodeCfg, client)
+	var nodeErr *NodeError
+	require.ErrorAs(t, err, &nodeErr)
+	assert.Equal(t, "Reset", nodeErr.Phase)
+	d.drain.Force = true
+	assert.NoError(t, d.RemoveNode(context.Background(), config, &nodeCfg, client), "a forced removal only warns about cleanup failures")
+}
@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+// chrootCommand builds the local command running a shell command inside rootFS; tests replace it to run without root privileges.
+// chrootCommand 构建在 rootFS 内运行 shell 命令的本地命令；测试会替换它以便无需 root 权限运行。
+var chrootCommand = func(ctx context.Context, rootFS, cmd string) *exec.Cmd {
+	return exec.CommandContext(ctx, "chroot", rootFS, "/bin/sh", "-c", cmd)
+}
+
+// chrootExecutor is a NodeExecutor running commands inside a root filesystem on the build machine, so the actions
+// planned for live nodes can be applied to an image being built.
+// chrootExecutor 是在构建机器上的根文件系统内运行命令的 NodeExecutor，使为在线节点计划的操作可以应用到正在构建的镜像。
+type chrootExecutor struct {
+	rootFS string
+}
+
+// NewChrootExecutor returns an executor running commands inside rootFS with chroot.
+// The build runs as root, so sudo is not needed and ignored.
+// NewChrootExecutor 返回一个使用 chroot 在 rootFS 内运行命令的执行器。构建以 root 身份运行，因此不需要并忽略 sudo。
+// rootFS: The root filesystem of the image being built. / 正在构建的镜像的根文件系统。
+func NewChrootExecutor(rootFS string) NodeExecutor {
+	return &chrootExecutor{rootFS: rootFS}
+}
+
+// Address returns the root filesystem path, which names the target in logs and errors.
+// Address 返回根文件系统路径，用于在日志和错误中命名目标。
+func (e *chrootExecutor) Address() string {
+	return e.rootFS
+}
+
+// Close is a no-op; the executor holds no connection.
+// Close 不执行任何操作；执行器不持有连接。
+func (e *chrootExecutor) Close() error {
+	return nil
+}
+
+// Run executes a command inside the root filesystem.
+// Run 在根文件系统内执行命令。
+func (e *chrootExecutor) Run(ctx context.Context, cmd string, opts ...RunOption) (*CommandResult, error) {
+	o := newRunOptions(opts)
+	command := chrootCommand(ctx, e.rootFS, cmd)
+	var stdout, stderr bytes.Buffer
+	stdoutWriters := o.stdout
+	if !o.skipStdoutCapture {
+		stdoutWriters = append([]io.Writer{&stdout}, stdoutWriters...)
+	}
+	command.Stdout = io.MultiWriter(stdoutWriters...)
+	command.Stderr = io.MultiWriter(append([]io.Writer{&stderr}, o.stderr...)...)
+	command.Stdin = o.stdin
+
+	err := command.Run()
+	if ctx.Err() != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeTimeout, fmt.Sprintf("command %q in %s was interrupted", cmd, e.rootFS), ctx.Err())
+	}
+	result := &CommandResult{
+		Node:    e.rootFS,
+		Command: cmd,
+		Stdout:  stdout.String(),
+		Stderr:  stderr.String(),
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+			return result, nil
+		}
+		return nil, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to run %q in %s", cmd, e.rootFS), err)
+	}
+	return result, nil
+}
+
+// Upload writes content to a temporary file next to remotePath inside the root filesystem and renames it into place.
+// Paths are resolved inside the chroot, so absolute symlinks of the image never point at the build machine.
+// Upload 将内容写入根文件系统内 remotePath 旁边的临时文件，然后将其重命名到位。
+// 路径在 chroot 内解析，因此镜像中的绝对符号链接永远不会指向构建机器。
+func (e *chrootExecutor) Upload(ctx context.Context, content io.Reader, remotePath string, mode os.FileMode) error {
+	tmpPath := remotePath + ".chasi-bod.tmp"
+	cmd := fmt.Sprintf("mkdir -p %s && cat > %s && chmod %o %s && mv -f %s %s",
+		ShellQuote(path.Dir(remotePath)), ShellQuote(tmpPath), mode.Perm(), ShellQuote(tmpPath), ShellQuote(tmpPath), ShellQuote(remotePath))
+	result, err := e.Run(ctx, cmd, WithStdin(content))
+	if err != nil {
+		return err
+	}
+	if err := result.Err(); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s in %s", remotePath, e.rootFS), err)
+	}
+	return nil
+}
+
+// Download streams a file of the root filesystem into w.
+// Download 将根文件系统中的文件流式写入 w。
+func (e *chrootExecutor) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	skipCapture := func(o *runOptions) { o.skipStdoutCapture = true }
+	result, err := e.Run(ctx, "cat "+ShellQuote(remotePath), WithStdout(w), skipCapture)
+	if err != nil {
+		return err
+	}
+	if err := result.Err(); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read %s in %s", remotePath, e.rootFS), err)
+	}
+	return nil
+}
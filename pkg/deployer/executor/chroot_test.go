@@ -0,0 +1,66 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+)
+
+// newTestChroot returns a chroot executor whose commands run in a shell started in a temporary directory,
+// so relative paths stand for paths inside the root filesystem.
+// newTestChroot 返回一个 chroot 执行器，其命令在临时目录中启动的 shell 内运行，因此相对路径代表根文件系统内的路径。
+func newTestChroot(t *testing.T) (NodeExecutor, string) {
+	t.Helper()
+	rootFS := t.TempDir()
+	previous := chrootCommand
+	chrootCommand = func(ctx context.Context, dir, cmd string) *exec.Cmd {
+		command := exec.CommandContext(ctx, "/bin/sh", "-c", cmd)
+		command.Dir = dir
+		return command
+	}
+	t.Cleanup(func() { chrootCommand = previous })
+	return NewChrootExecutor(rootFS), rootFS
+}
+
+func TestChrootExecutor_Run(t *testing.T) {
+	chroot, rootFS := newTestChroot(t)
+	assert.Equal(t, rootFS, chroot.Address())
+
+	result, err := chroot.Run(context.Background(), "echo out; echo err >&2; exit 3", WithSudo())
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, "out\n", result.Stdout)
+	assert.Equal(t, "err\n", result.Stderr)
+	assert.Error(t, result.Err())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = chroot.Run(ctx, "true")
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeTimeout))
+}
+
+func TestChrootExecutor_UploadDownload(t *testing.T) {
+	chroot, rootFS := newTestChroot(t)
+	ctx := context.Background()
+
+	require.NoError(t, chroot.Upload(ctx, strings.NewReader("hello\n"), "etc/chasi-bod/motd", 0640))
+	info, err := os.Stat(filepath.Join(rootFS, "etc", "chasi-bod", "motd"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	var buf bytes.Buffer
+	require.NoError(t, chroot.Download(ctx, "etc/chasi-bod/motd", &buf))
+	assert.Equal(t, "hello\n", buf.String())
+
+	err = chroot.Download(ctx, "etc/missing", &buf)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeIO))
+}
@@ -0,0 +1,283 @@
+// Package executor provides the remote execution layer used by the deployer to drive target nodes.
+// 包 executor 提供部署器用于驱动目标节点的远程执行层。
+// Phases and storage helpers run commands and transfer files through a NodeExecutor instead of talking SSH directly.
+// 各阶段和存储辅助函数通过 NodeExecutor 运行命令和传输文件，而不是直接使用 SSH。
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// NodeExecutor defines the interface for executing commands and transferring files on a target node.
+// NodeExecutor 定义了在目标节点上执行命令和传输文件的接口。
+type NodeExecutor interface {
+	// Run executes a shell command on the node.
+	// Run 在节点上执行 shell 命令。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// cmd: The shell command to execute. / 要执行的 shell 命令。
+	// opts: Options such as sudo, stdin and output streaming. / sudo、标准输入和输出流等选项。
+	// Returns the command result (including a non-zero exit code) or an error if the command could not be run at all.
+	// 返回命令结果（包括非零退出码），如果命令根本无法运行则返回错误。
+	Run(ctx context.Context, cmd string, opts ...RunOption) (*CommandResult, error)
+
+	// Upload writes content to a file on the node with root privileges, replacing it atomically.
+	// Upload 以 root 权限将内容写入节点上的文件，并原子地替换它。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// content: The file content to upload. / 要上传的文件内容。
+	// remotePath: The destination path on the node. / 节点上的目标路径。
+	// mode: The file permission mode to set. / 要设置的文件权限模式。
+	// Returns an error if the upload fails.
+	// 如果上传失败则返回错误。
+	Upload(ctx context.Context, content io.Reader, remotePath string, mode os.FileMode) error
+
+	// Download reads a file from the node with root privileges and writes it to w.
+	// Download 以 root 权限从节点读取文件并写入 w。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// remotePath: The source path on the node. / 节点上的源路径。
+	// w: The writer receiving the file content. / 接收文件内容的写入器。
+	// Returns an error if the download fails.
+	// 如果下载失败则返回错误。
+	Download(ctx context.Context, remotePath string, w io.Writer) error
+
+	// Address returns the address of the node this executor is connected to.
+	// Address 返回此执行器所连接节点的地址。
+	Address() string
+
+	// Close releases the underlying connection.
+	// Close 释放底层连接。
+	Close() error
+}
+
+// Factory creates a NodeExecutor for a node. NewSSHExecutor is the default implementation.
+// Factory 为节点创建 NodeExecutor。NewSSHExecutor 是默认实现。
+type Factory func(ctx context.Context, nodeCfg *model.NodeConfig) (NodeExecutor, error)
+
+// CommandResult holds the structured outcome of a remote command.
+// CommandResult 保存远程命令的结构化结果。
+type CommandResult struct {
+	// Node is the address of the node the command ran on.
+	// Node 是命令运行所在节点的地址。
+	Node string
+	// Command is the command as requested by the caller (without sudo wrapping).
+	// Command 是调用者请求的命令（不含 sudo 包装）。
+	Command string
+	// Stdout is the captured standard output.
+	// Stdout 是捕获的标准输出。
+	Stdout string
+	// Stderr is the captured standard error.
+	// Stderr 是捕获的标准错误。
+	Stderr string
+	// ExitCode is the exit status reported by the remote command.
+	// ExitCode 是远程命令报告的退出状态。
+	ExitCode int
+}
+
+// Success reports whether the command exited with status 0.
+// Success 报告命令是否以状态 0 退出。
+func (r *CommandResult) Success() bool {
+	return r.ExitCode == 0
+}
+
+// Err converts a non-zero exit code into an error, or returns nil on success.
+// Err 将非零退出码转换为错误，成功时返回 nil。
+func (r *CommandResult) Err() error {
+	if r.Success() {
+		return nil
+	}
+	msg := fmt.Sprintf("command %q on node %s exited with code %d", r.Command, r.Node, r.ExitCode)
+	if stderr := strings.TrimSpace(r.Stderr); stderr != "" {
+		msg = fmt.Sprintf("%s: %s", msg, stderr)
+	}
+	return errors.New(errors.ErrTypeSystem, msg)
+}
+
+// runOptions holds the settings collected from RunOption values.
+// runOptions 保存从 RunOption 值收集的设置。
+type runOptions struct {
+	sudo   bool
+	stdin  io.Reader
+	stdout []io.Writer
+	stderr []io.Writer
+	// skipStdoutCapture avoids buffering large outputs (e.g. downloads) that are already streamed.
+	// skipStdoutCapture 避免缓冲已经流式传输的大量输出（例如下载）。
+	skipStdoutCapture bool
+}
+
+// RunOption configures a single Run call.
+// RunOption 配置单次 Run 调用。
+type RunOption func(*runOptions)
+
+// WithSudo runs the command with root privileges via sudo (a no-op when connected as root).
+// WithSudo 通过 sudo 以 root 权限运行命令（以 root 连接时无操作）。
+func WithSudo() RunOption {
+	return func(o *runOptions) { o.sudo = true }
+}
+
+// WithStdin feeds r to the command's standard input.
+// WithStdin 将 r 作为命令的标准输入。
+func WithStdin(r io.Reader) RunOption {
+	return func(o *runOptions) { o.stdin = r }
+}
+
+// WithStdout streams the command's standard output to w while it runs, in addition to capturing it.
+// WithStdout 在命令运行时将其标准输出流式写入 w，同时仍然捕获它。
+func WithStdout(w io.Writer) RunOption {
+	return func(o *runOptions) { o.stdout = append(o.stdout, w) }
+}
+
+// WithStderr streams the command's standard error to w while it runs, in addition to capturing it.
+// WithStderr 在命令运行时将其标准错误流式写入 w，同时仍然捕获它。
+func WithStderr(w io.Writer) RunOption {
+	return func(o *runOptions) { o.stderr = append(o.stderr, w) }
+}
+
+// WithLogging streams both output streams line by line to the global logger, prefixed with the node address.
+// WithLogging 将两个输出流逐行写入全局日志记录器，并以节点地址作为前缀。
+// Useful for long-running commands such as kubeadm init.
+// 适用于 kubeadm init 等长时间运行的命令。
+func WithLogging(node string) RunOption {
+	return func(o *runOptions) {
+		o.stdout = append(o.stdout, NewLogWriter(node))
+		o.stderr = append(o.stderr, NewLogWriter(node))
+	}
+}
+
+// newRunOptions applies opts over the defaults.
+// newRunOptions 在默认值之上应用 opts。
+func newRunOptions(opts []RunOption) *runOptions {
+	o := &runOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// RunCommand runs cmd on the executor and treats a non-zero exit code as an error.
+// RunCommand 在执行器上运行 cmd，并将非零退出码视为错误。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// e: The executor for the target node. / 目标节点的执行器。
+// cmd: The shell command to execute. / 要执行的 shell 命令。
+// opts: Options passed through to Run. / 传递给 Run 的选项。
+// Returns the trimmed standard output, or an error.
+// 返回去除首尾空白的标准输出，或错误。
+func RunCommand(ctx context.Context, e NodeExecutor, cmd string, opts ...RunOption) (string, error) {
+	result, err := e.Run(ctx, cmd, opts...)
+	if err != nil {
+		return "", err
+	}
+	if err := result.Err(); err != nil {
+		return strings.TrimSpace(result.Stdout), err
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+// UploadFile uploads a local file to the node, preserving its permission bits.
+// UploadFile 将本地文件上传到节点，并保留其权限位。
+func UploadFile(ctx context.Context, e NodeExecutor, localPath string, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to open local file %s", localPath), err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to stat local file %s", localPath), err)
+	}
+	return e.Upload(ctx, f, remotePath, info.Mode().Perm())
+}
+
+// DownloadFile downloads a file from the node into a local file, creating parent directories as needed.
+// DownloadFile 将节点上的文件下载到本地文件，并按需创建父目录。
+func DownloadFile(ctx context.Context, e NodeExecutor, remotePath string, localPath string) error {
+	if err := utils.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create local directory for %s", localPath), err)
+	}
+	f, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create local file %s", localPath), err)
+	}
+	if err := e.Download(ctx, remotePath, f); err != nil {
+		f.Close()
+		os.Remove(localPath) // Do not leave a truncated copy behind // 不要留下截断的副本
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write local file %s", localPath), err)
+	}
+	return nil
+}
+
+// ShellQuote quotes s so that it is passed to a POSIX shell as a single literal word.
+// ShellQuote 对 s 加引号，使其作为单个字面量单词传递给 POSIX shell。
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_./=:,+@%", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// logWriter is an io.Writer that forwards complete lines to the global logger.
+// logWriter 是一个将完整行转发到全局日志记录器的 io.Writer。
+type logWriter struct {
+	prefix string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// NewLogWriter returns an io.Writer that logs each complete line it receives, prefixed with "[prefix] ".
+// NewLogWriter 返回一个 io.Writer，它记录收到的每个完整行，并以 "[prefix] " 为前缀。
+func NewLogWriter(prefix string) io.Writer {
+	return &logWriter{prefix: prefix}
+}
+
+// Write implements io.Writer.
+// Write 实现 io.Writer。
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		utils.GetLogger().Printf("[%s] %s", w.prefix, strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// ParseOSRelease parses the KEY=value lines of /etc/os-release.
+// ParseOSRelease 解析 /etc/os-release 中的 KEY=value 行。
+func ParseOSRelease(content string) map[string]string {
+	release := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		release[key] = strings.Trim(value, `"'`)
+	}
+	return release
+}
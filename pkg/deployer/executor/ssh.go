@@ -0,0 +1,307 @@
+// Package executor provides the remote execution layer used by the deployer to drive target nodes.
+// 包 executor 提供部署器用于驱动目标节点的远程执行层。
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// defaultDialTimeout bounds the TCP connect and SSH handshake when the context has no earlier deadline.
+// defaultDialTimeout 在上下文没有更早截止时间时限制 TCP 连接和 SSH 握手的时间。
+const defaultDialTimeout = 30 * time.Second
+
+// sshExecutor is a NodeExecutor backed by a single SSH connection; each Run opens a new session on it.
+// sshExecutor 是由单个 SSH 连接支持的 NodeExecutor；每次 Run 都在其上打开一个新会话。
+type sshExecutor struct {
+	address  string
+	user     string
+	password string
+	client   *ssh.Client
+
+	// sudoNoPassword records that sudo did not ask for the password, once probed.
+	// sudoNoPassword 记录探测后 sudo 不要求密码。
+	sudoMu         sync.Mutex
+	sudoNoPassword bool
+}
+
+// NewSSHExecutor connects to a node over SSH using the credentials in its NodeConfig.
+// NewSSHExecutor 使用 NodeConfig 中的凭据通过 SSH 连接到节点。
+// ctx: Context for cancellation and timeouts of the connection attempt. / 用于连接尝试取消和超时的上下文。
+// nodeCfg: The node configuration (Address, Port, User, PrivateKey or Password). / 节点配置（Address、Port、User、PrivateKey 或 Password）。
+// Host keys are verified against ~/.ssh/known_hosts unless the node sets InsecureSkipHostKeyCheck.
+// 除非节点设置了 InsecureSkipHostKeyCheck，否则将根据 ~/.ssh/known_hosts 验证主机密钥。
+// Returns a connected NodeExecutor or an error.
+// 返回已连接的 NodeExecutor 或错误。
+func NewSSHExecutor(ctx context.Context, nodeCfg *model.NodeConfig) (NodeExecutor, error) {
+	user := nodeCfg.User
+	if user == "" {
+		user = "root"
+	}
+	port := nodeCfg.Port
+	if port == 0 {
+		port = constants.DefaultSSHPort
+	}
+
+	auth, err := authMethods(nodeCfg)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := hostKeyCallback(nodeCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(nodeCfg.Address, strconv.Itoa(port))
+	clientCfg := &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         defaultDialTimeout,
+	}
+
+	dialer := net.Dialer{Timeout: defaultDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to connect to %s", addr), err)
+	}
+	deadline := time.Now().Add(defaultDialTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline) // Bound the handshake // 限制握手时间
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientCfg)
+	if err != nil {
+		conn.Close()
+		return nil, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("SSH handshake with %s failed", addr), err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &sshExecutor{
+		address:  nodeCfg.Address,
+		user:     user,
+		password: nodeCfg.Password,
+		client:   ssh.NewClient(sshConn, chans, reqs),
+	}, nil
+}
+
+// authMethods builds the SSH auth methods from the node configuration.
+// authMethods 根据节点配置构建 SSH 认证方法。
+func authMethods(nodeCfg *model.NodeConfig) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if nodeCfg.PrivateKey != "" {
+		keyBytes, err := os.ReadFile(nodeCfg.PrivateKey)
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read SSH private key %s", nodeCfg.PrivateKey), err)
+		}
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("failed to parse SSH private key %s", nodeCfg.PrivateKey), err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if nodeCfg.Password != "" {
+		password := nodeCfg.Password
+		methods = append(methods, ssh.Password(password),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New(errors.ErrTypeConfig, fmt.Sprintf("no SSH credentials (privateKey or password) configured for node %s", nodeCfg.Address))
+	}
+	return methods, nil
+}
+
+// hostKeyCallback verifies host keys against the user's known_hosts file. A missing file is an error: the executor
+// runs commands as root and sends passwords and join tokens, so it accepts any key only when the node opts in.
+// hostKeyCallback 根据用户的 known_hosts 文件验证主机密钥。缺少该文件即为错误：执行器以 root 身份运行命令并发送密码和加入令牌，
+// 因此仅当节点明确选择时才接受任何密钥。
+func hostKeyCallback(nodeCfg *model.NodeConfig) (ssh.HostKeyCallback, error) {
+	if nodeCfg.InsecureSkipHostKeyCheck {
+		utils.GetLogger().Printf("Warning: the SSH host key of %s is not verified (insecureSkipHostKeyCheck).", nodeCfg.Address)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, "cannot locate ~/.ssh/known_hosts to verify SSH host keys", err)
+	}
+	knownHostsPath := filepath.Join(home, ".ssh", "known_hosts")
+	if exists, _ := utils.PathExists(knownHostsPath); !exists {
+		return nil, errors.New(errors.ErrTypeConfig, fmt.Sprintf(
+			"%s does not exist, the SSH host key of %s cannot be verified; add it (e.g. ssh-keyscan %s >> %s) or set insecureSkipHostKeyCheck on the node",
+			knownHostsPath, nodeCfg.Address, nodeCfg.Address, knownHostsPath))
+	}
+	callback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("failed to load %s", knownHostsPath), err)
+	}
+	return callback, nil
+}
+
+// Address returns the node address.
+// Address 返回节点地址。
+func (e *sshExecutor) Address() string {
+	return e.address
+}
+
+// Close closes the SSH connection.
+// Close 关闭 SSH 连接。
+func (e *sshExecutor) Close() error {
+	return e.client.Close()
+}
+
+// Run executes a command in a new SSH session.
+// Run 在新的 SSH 会话中执行命令。
+func (e *sshExecutor) Run(ctx context.Context, cmd string, opts ...RunOption) (*CommandResult, error) {
+	o := newRunOptions(opts)
+	remoteCmd, stdin, err := e.wrapSudo(ctx, cmd, o)
+	if err != nil {
+		return nil, err
+	}
+	return e.run(ctx, cmd, remoteCmd, stdin, o)
+}
+
+// run executes remoteCmd, the possibly sudo wrapped form of cmd, in a new SSH session.
+// run 在新的 SSH 会话中执行 remoteCmd，即 cmd 可能经 sudo 包装后的形式。
+func (e *sshExecutor) run(ctx context.Context, cmd, remoteCmd string, stdin io.Reader, o *runOptions) (*CommandResult, error) {
+	session, err := e.client.NewSession()
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to open SSH session on %s", e.address), err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	stdoutWriters := o.stdout
+	if !o.skipStdoutCapture {
+		stdoutWriters = append([]io.Writer{&stdout}, stdoutWriters...)
+	}
+	session.Stdout = io.MultiWriter(stdoutWriters...)
+	session.Stderr = io.MultiWriter(append([]io.Writer{&stderr}, o.stderr...)...)
+	session.Stdin = stdin
+
+	done := make(chan error, 1)
+	go func() { done <- session.Run(remoteCmd) }()
+
+	select {
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL) // Best effort, not all servers honour signals // 尽力而为，并非所有服务器都支持信号
+		session.Close()
+		return nil, errors.NewWithCause(errors.ErrTypeTimeout, fmt.Sprintf("command %q on %s was interrupted", cmd, e.address), ctx.Err())
+	case err = <-done:
+	}
+
+	result := &CommandResult{
+		Node:    e.address,
+		Command: cmd,
+		Stdout:  stdout.String(),
+		Stderr:  stderr.String(),
+	}
+	if err != nil {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			result.ExitCode = exitErr.ExitStatus()
+			return result, nil
+		}
+		return nil, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("command %q on %s did not complete", cmd, e.address), err)
+	}
+	return result, nil
+}
+
+// wrapSudo wraps cmd with sudo when requested and the login user is not root.
+// wrapSudo 在请求且登录用户不是 root 时用 sudo 包装 cmd。
+// With password authentication the password is fed to `sudo -k -S` ahead of the real stdin, but only when
+// `sudo -n` shows that sudo asks for it: with NOPASSWD sudo never reads it and the command would get it as input.
+// 使用密码认证时，仅当 `sudo -n` 表明 sudo 会要求密码时，密码才会在真正的标准输入之前传给 `sudo -k -S`：
+// 对于 NOPASSWD，sudo 从不读取密码，命令会把它当作输入。
+func (e *sshExecutor) wrapSudo(ctx context.Context, cmd string, o *runOptions) (string, io.Reader, error) {
+	stdin := o.stdin
+	if !o.sudo || e.user == "root" {
+		return cmd, stdin, nil
+	}
+	if e.password == "" {
+		return "sudo -n sh -c " + ShellQuote(cmd), stdin, nil
+	}
+	noPassword, err := e.sudoWithoutPassword(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if noPassword {
+		return "sudo -n sh -c " + ShellQuote(cmd), stdin, nil
+	}
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	return "sudo -k -S -p '' sh -c " + ShellQuote(cmd), io.MultiReader(strings.NewReader(e.password+"\n"), stdin), nil
+}
+
+// sudoWithoutPassword reports whether sudo runs commands without asking for the password. A NOPASSWD result is
+// kept for the connection; otherwise sudo is probed again before each command, as a sudoers drop-in written by a
+// previous command may have lifted the password requirement.
+// sudoWithoutPassword 报告 sudo 是否无需密码即可运行命令。NOPASSWD 结果在连接期间保留；否则每个命令之前都会重新探测 sudo，
+// 因为之前命令写入的 sudoers 片段可能已取消密码要求。
+func (e *sshExecutor) sudoWithoutPassword(ctx context.Context) (bool, error) {
+	e.sudoMu.Lock()
+	defer e.sudoMu.Unlock()
+	if e.sudoNoPassword {
+		return true, nil
+	}
+	probe := "sudo -n sh -c true"
+	result, err := e.run(ctx, probe, probe, nil, &runOptions{})
+	if err != nil {
+		return false, err
+	}
+	e.sudoNoPassword = result.Success()
+	return e.sudoNoPassword, nil
+}
+
+// Upload streams content to a temporary file next to remotePath and renames it into place.
+// Upload 将内容流式写入 remotePath 旁边的临时文件，然后将其重命名到位。
+func (e *sshExecutor) Upload(ctx context.Context, content io.Reader, remotePath string, mode os.FileMode) error {
+	tmpPath := remotePath + ".chasi-bod.tmp"
+	cmd := fmt.Sprintf("mkdir -p %s && cat > %s && chmod %o %s && mv -f %s %s",
+		ShellQuote(path.Dir(remotePath)), ShellQuote(tmpPath), mode.Perm(), ShellQuote(tmpPath), ShellQuote(tmpPath), ShellQuote(remotePath))
+	result, err := e.Run(ctx, cmd, WithSudo(), WithStdin(content))
+	if err != nil {
+		return err
+	}
+	if err := result.Err(); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to upload %s to %s", remotePath, e.address), err)
+	}
+	return nil
+}
+
+// Download streams a remote file into w.
+// Download 将远程文件流式写入 w。
+func (e *sshExecutor) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	skipCapture := func(o *runOptions) { o.skipStdoutCapture = true }
+	result, err := e.Run(ctx, "cat "+ShellQuote(remotePath), WithSudo(), WithStdout(w), skipCapture)
+	if err != nil {
+		return err
+	}
+	if err := result.Err(); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to download %s from %s", remotePath, e.address), err)
+	}
+	return nil
+}
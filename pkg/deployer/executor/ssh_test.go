@@ -0,0 +1,197 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor/sshtest"
+)
+
+// newTestServer starts an sshtest server and isolates the test from the user's known_hosts.
+// newTestServer 启动一个 sshtest 服务器，并将测试与用户的 known_hosts 隔离。
+func newTestServer(t *testing.T, user string) *sshtest.Server {
+	t.Helper()
+	utils.InitLogger("info", 0)
+	t.Setenv("HOME", t.TempDir())
+	server, err := sshtest.NewServer(user, "secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestSSHExecutor_RunReportsExitCodeAndOutput(t *testing.T) {
+	server := newTestServer(t, "root")
+	server.HandleOutput("uname -r", "6.1.0\n", 0)
+	server.Handle("false", func(_ string, _ []byte, _, stderr io.Writer) int {
+		io.WriteString(stderr, "boom\n")
+		return 3
+	})
+
+	nodeCfg := server.NodeConfig()
+	exec, err := NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	defer exec.Close()
+
+	var streamed bytes.Buffer
+	out, err := RunCommand(context.Background(), exec, "uname -r", WithStdout(&streamed))
+	assert.NoError(t, err)
+	assert.Equal(t, "6.1.0", out)
+	assert.Equal(t, "6.1.0\n", streamed.String(), "stdout should be streamed as well as captured")
+
+	result, err := exec.Run(context.Background(), "false")
+	require.NoError(t, err, "a non-zero exit is a result, not a transport error")
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, "boom\n", result.Stderr)
+	assert.Error(t, result.Err())
+
+	_, err = RunCommand(context.Background(), exec, "false")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exited with code 3")
+}
+
+func TestSSHExecutor_SudoWithPassword(t *testing.T) {
+	server := newTestServer(t, "ops")
+	server.Handle("sudo -n sh -c true", func(_ string, _ []byte, _, stderr io.Writer) int {
+		io.WriteString(stderr, "sudo: a password is required\n")
+		return 1
+	})
+	nodeCfg := server.NodeConfig()
+	exec, err := NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	defer exec.Close()
+
+	_, err = RunCommand(context.Background(), exec, "systemctl restart containerd", WithSudo())
+	require.NoError(t, err)
+
+	execs := server.Execs()
+	require.Len(t, execs, 2)
+	assert.Equal(t, "sudo -n sh -c true", execs[0].Command, "sudo is probed for a password prompt first")
+	assert.Equal(t, "sudo -k -S -p '' sh -c 'systemctl restart containerd'", execs[1].Command)
+	assert.Equal(t, "secret\n", string(execs[1].Stdin), "the sudo password is sent on stdin")
+}
+
+func TestSSHExecutor_UploadWithNOPASSWDSudo(t *testing.T) {
+	// The server runs any unhandled command successfully, so the probe finds a NOPASSWD sudoers entry.
+	server := newTestServer(t, "ops")
+	nodeCfg := server.NodeConfig()
+	exec, err := NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	defer exec.Close()
+
+	for _, name := range []string{"a", "b"} {
+		err = exec.Upload(context.Background(), strings.NewReader("ops ALL=(ALL) NOPASSWD: ALL\n"), "/etc/sudoers.d/"+name, 0440)
+		require.NoError(t, err)
+	}
+
+	execs := server.Execs()
+	require.Len(t, execs, 3, "the NOPASSWD probe result is kept for the connection")
+	assert.Equal(t, "sudo -n sh -c true", execs[0].Command)
+	for _, e := range execs[1:] {
+		assert.True(t, strings.HasPrefix(e.Command, "sudo -n sh -c 'mkdir -p /etc/sudoers.d && cat > "), e.Command)
+		assert.Equal(t, "ops ALL=(ALL) NOPASSWD: ALL\n", string(e.Stdin), "the password must not reach the uploaded file")
+		assert.NotContains(t, string(e.Stdin), "secret")
+	}
+}
+
+func TestSSHExecutor_UploadAndDownload(t *testing.T) {
+	server := newTestServer(t, "root")
+	server.HandleOutput("cat /etc/hostname", "node-1\n", 0)
+	nodeCfg := server.NodeConfig()
+	exec, err := NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	defer exec.Close()
+
+	err = exec.Upload(context.Background(), strings.NewReader("net.ipv4.ip_forward = 1\n"), "/etc/sysctl.d/99-test.conf", 0644)
+	require.NoError(t, err)
+	execs := server.Execs()
+	require.Len(t, execs, 1)
+	assert.Equal(t, "mkdir -p /etc/sysctl.d && cat > /etc/sysctl.d/99-test.conf.chasi-bod.tmp && chmod 644 /etc/sysctl.d/99-test.conf.chasi-bod.tmp && mv -f /etc/sysctl.d/99-test.conf.chasi-bod.tmp /etc/sysctl.d/99-test.conf", execs[0].Command)
+	assert.Equal(t, "net.ipv4.ip_forward = 1\n", string(execs[0].Stdin))
+
+	localPath := filepath.Join(t.TempDir(), "backup", "hostname")
+	require.NoError(t, DownloadFile(context.Background(), exec, "/etc/hostname", localPath))
+	content, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, "node-1\n", string(content))
+
+	server.HandleOutput("cat /missing", "", 1)
+	err = DownloadFile(context.Background(), exec, "/missing", localPath+".missing")
+	assert.Error(t, err)
+	exists, _ := utils.PathExists(localPath + ".missing")
+	assert.False(t, exists, "failed downloads must not leave partial files")
+}
+
+func TestSSHExecutor_PrivateKeyAuth(t *testing.T) {
+	server := newTestServer(t, "root")
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	server.AuthorizeKey(sshPub)
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+
+	nodeCfg := server.NodeConfig()
+	nodeCfg.Password = ""
+	nodeCfg.PrivateKey = keyPath
+	exec, err := NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	defer exec.Close()
+	_, err = RunCommand(context.Background(), exec, "true")
+	assert.NoError(t, err)
+
+	nodeCfg.PrivateKey = ""
+	_, err = NewSSHExecutor(context.Background(), &nodeCfg)
+	assert.Error(t, err, "a node without credentials must be rejected")
+}
+
+func TestSSHExecutor_HostKeyVerification(t *testing.T) {
+	server := newTestServer(t, "root")
+	nodeCfg := server.NodeConfig()
+	nodeCfg.InsecureSkipHostKeyCheck = false
+
+	_, err := NewSSHExecutor(context.Background(), &nodeCfg)
+	require.Error(t, err, "a missing known_hosts must not fall back to accepting any key")
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeConfig))
+	assert.Contains(t, err.Error(), "insecureSkipHostKeyCheck")
+
+	knownHosts := filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	require.NoError(t, os.MkdirAll(filepath.Dir(knownHosts), 0700))
+	other, err := sshtest.NewServer("root", "secret")
+	require.NoError(t, err)
+	defer other.Close()
+	// The other server's key under this server's address: a changed host key.
+	line := strings.Replace(other.KnownHostsLine(), fmt.Sprintf("%d", other.Port()), fmt.Sprintf("%d", server.Port()), 1)
+	require.NoError(t, os.WriteFile(knownHosts, []byte(line+"\n"), 0600))
+	_, err = NewSSHExecutor(context.Background(), &nodeCfg)
+	assert.Error(t, err, "a host key that does not match known_hosts is rejected")
+
+	require.NoError(t, os.WriteFile(knownHosts, []byte(server.KnownHostsLine()+"\n"), 0600))
+	exec, err := NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	exec.Close()
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "/etc/fstab", ShellQuote("/etc/fstab"))
+	assert.Equal(t, "''", ShellQuote(""))
+	assert.Equal(t, `'it'\''s here'`, ShellQuote("it's here"))
+}
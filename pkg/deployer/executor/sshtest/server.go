@@ -0,0 +1,302 @@
+// Package sshtest provides an in-process SSH server for testing code that drives nodes through the executor package.
+// 包 sshtest 提供一个进程内 SSH 服务器，用于测试通过 executor 包驱动节点的代码。
+// The server never runs real commands: it records every exec request and answers it from registered handlers,
+// so phases can be exercised end to end without real machines.
+// 服务器从不运行真实命令：它记录每个 exec 请求并使用已注册的处理器进行应答，
+// 因此可以在没有真实机器的情况下端到端地测试各个阶段。
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// Exec records a single command received by the server.
+// Exec 记录服务器收到的单个命令。
+type Exec struct {
+	// Command is the raw command line, including any sudo wrapping added by the client.
+	// Command 是原始命令行，包括客户端添加的任何 sudo 包装。
+	Command string
+	// Stdin is everything the client sent on standard input.
+	// Stdin 是客户端在标准输入上发送的所有内容。
+	Stdin []byte
+}
+
+// HandlerFunc answers a command. It writes the command output and returns the exit status.
+// HandlerFunc 应答一个命令。它写入命令输出并返回退出状态。
+type HandlerFunc func(cmd string, stdin []byte, stdout, stderr io.Writer) int
+
+// route binds a handler to commands containing a substring.
+// route 将处理器绑定到包含某个子串的命令。
+type route struct {
+	match   string
+	handler HandlerFunc
+}
+
+// Server is an in-process SSH server listening on 127.0.0.1.
+// Server 是一个监听 127.0.0.1 的进程内 SSH 服务器。
+type Server struct {
+	// User is the login name accepted by the server.
+	// User 是服务器接受的登录名。
+	User string
+	// Password is the password accepted by the server.
+	// Password 是服务器接受的密码。
+	Password string
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+
+	mu             sync.Mutex
+	execs          []Exec
+	routes         []route
+	authorizedKeys []ssh.PublicKey
+	conns          map[net.Conn]struct{}
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a server accepting the given user with the given password.
+// NewServer 启动一个接受给定用户和密码的服务器。
+// Keys added with AuthorizeKey are accepted as well.
+// 通过 AuthorizeKey 添加的密钥也会被接受。
+func NewServer(user, password string) (*Server, error) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{User: user, Password: password, hostKey: signer.PublicKey(), conns: make(map[net.Conn]struct{})}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if conn.User() == s.User && string(pass) == s.Password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", conn.User())
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, k := range s.authorizedKeys {
+				if conn.User() == s.User && string(k.Marshal()) == string(key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("public key rejected for %q", conn.User())
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Port returns the TCP port the server listens on.
+// Port 返回服务器监听的 TCP 端口。
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// NodeConfig returns a node configuration that connects to this server with password authentication.
+// NodeConfig 返回一个使用密码认证连接到此服务器的节点配置。
+// The host key is generated per server, so the configuration skips the host key check; see KnownHostsLine.
+// 主机密钥按服务器生成，因此该配置跳过主机密钥检查；参见 KnownHostsLine。
+func (s *Server) NodeConfig() model.NodeConfig {
+	return model.NodeConfig{
+		Address:                  "127.0.0.1",
+		Port:                     s.Port(),
+		User:                     s.User,
+		Password:                 s.Password,
+		InsecureSkipHostKeyCheck: true,
+	}
+}
+
+// KnownHostsLine returns the known_hosts entry of this server's host key.
+// KnownHostsLine 返回此服务器主机密钥的 known_hosts 条目。
+func (s *Server) KnownHostsLine() string {
+	return knownhosts.Line([]string{knownhosts.Normalize(s.listener.Addr().String())}, s.hostKey)
+}
+
+// AuthorizeKey allows clients to log in with the given public key.
+// AuthorizeKey 允许客户端使用给定的公钥登录。
+func (s *Server) AuthorizeKey(key ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizedKeys = append(s.authorizedKeys, key)
+}
+
+// Handle registers a handler for commands containing match. Later registrations take precedence.
+// Handle 为包含 match 的命令注册处理器。后注册的优先。
+// Commands without a matching handler succeed with no output.
+// 没有匹配处理器的命令将成功且没有输出。
+func (s *Server) Handle(match string, handler HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, route{match: match, handler: handler})
+}
+
+// HandleOutput registers a canned response for commands containing match.
+// HandleOutput 为包含 match 的命令注册固定响应。
+func (s *Server) HandleOutput(match string, stdout string, exitCode int) {
+	s.Handle(match, func(_ string, _ []byte, out, _ io.Writer) int {
+		io.WriteString(out, stdout)
+		return exitCode
+	})
+}
+
+// HandleFile answers the reads of a regular file at path (`stat -c %a` and `cat`) with its content and permission mode.
+// HandleFile 以文件内容和权限模式应答对 path 处普通文件的读取（`stat -c %a` 和 `cat`）。
+func (s *Server) HandleFile(path, content string, mode os.FileMode) {
+	s.HandleOutput("stat -c %a "+path, fmt.Sprintf("%o\n", mode.Perm()), 0)
+	s.HandleOutput("cat "+path, content, 0)
+}
+
+// Execs returns a copy of all commands received so far, in order.
+// Execs 按顺序返回迄今为止收到的所有命令的副本。
+func (s *Server) Execs() []Exec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Exec(nil), s.execs...)
+}
+
+// Commands returns the command lines received so far, in order.
+// Commands 按顺序返回迄今为止收到的命令行。
+func (s *Server) Commands() []string {
+	execs := s.Execs()
+	cmds := make([]string, len(execs))
+	for i, e := range execs {
+		cmds[i] = e.Command
+	}
+	return cmds
+}
+
+// Close stops the server, drops open connections and waits for its goroutines to exit.
+// Close 停止服务器，断开已打开的连接并等待其 goroutine 退出。
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// serve accepts connections until the listener is closed.
+// serve 接受连接直到监听器关闭。
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn performs the SSH handshake and serves session channels.
+// handleConn 执行 SSH 握手并处理会话通道。
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	var sessions sync.WaitGroup
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			s.handleSession(ch, chReqs)
+		}()
+	}
+	sessions.Wait()
+}
+
+// handleSession answers the first exec request on a session channel.
+// handleSession 应答会话通道上的第一个 exec 请求。
+func (s *Server) handleSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			// Accept env/pty requests silently, everything else is unsupported.
+			// 静默接受 env/pty 请求，其他请求均不支持。
+			req.Reply(req.Type == "env" || req.Type == "pty-req", nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+
+		stdin, _ := io.ReadAll(ch)
+		s.mu.Lock()
+		s.execs = append(s.execs, Exec{Command: payload.Command, Stdin: stdin})
+		handler := s.lookup(payload.Command)
+		s.mu.Unlock()
+
+		status := 0
+		if handler != nil {
+			status = handler(payload.Command, stdin, ch, ch.Stderr())
+		}
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+		go ssh.DiscardRequests(reqs)
+		return
+	}
+}
+
+// lookup returns the most recently registered handler matching cmd. Callers hold s.mu.
+// lookup 返回最近注册的与 cmd 匹配的处理器。调用者持有 s.mu。
+func (s *Server) lookup(cmd string) HandlerFunc {
+	for i := len(s.routes) - 1; i >= 0; i-- {
+		if strings.Contains(cmd, s.routes[i].match) {
+			return s.routes[i].handler
+		}
+	}
+	return nil
+}
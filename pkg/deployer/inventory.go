@@ -0,0 +1,116 @@
+package deployer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// DefaultInventoryDir is where the node inventories of deployed platforms are stored, one file per platform config.
+// DefaultInventoryDir 是已部署平台的节点清单的存储位置，每个平台配置一个文件。
+var DefaultInventoryDir = filepath.Join(constants.DefaultDataDir, "inventory")
+
+// Inventory is the recorded set of nodes chasi-bod last deployed or applied.
+// Inventory 是 chasi-bod 上次部署或应用的节点的记录集合。
+// It keeps the SSH settings of every node so that a node deleted from the config can still be cleaned up.
+// 它保存每个节点的 SSH 设置，以便从配置中删除的节点仍然可以被清理。
+type Inventory struct {
+	ConfigName string             `yaml:"configName"` // Name of the platform config / 平台配置的名称
+	UpdatedAt  time.Time          `yaml:"updatedAt"`  // Last time the inventory was written / 清单最后写入时间
+	Nodes      []model.NodeConfig `yaml:"nodes"`      // Nodes that are part of the Host Cluster / 属于 Host 集群的节点
+}
+
+// InventoryPath returns the inventory file used for a platform config.
+// InventoryPath 返回平台配置使用的清单文件。
+// dir: Directory holding inventories. / 存放清单的目录。
+// configName: Name of the platform config. / 平台配置的名称。
+func InventoryPath(dir, configName string) string {
+	if configName == "" {
+		configName = "default"
+	}
+	return filepath.Join(dir, configName+".yaml")
+}
+
+// LoadInventory reads an existing inventory.
+// LoadInventory 读取现有的清单。
+// path: Inventory file path. / 清单文件路径。
+// Returns an ErrTypeNotFound error if no inventory exists.
+// 如果清单不存在，则返回 ErrTypeNotFound 错误。
+func LoadInventory(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.New(errors.ErrTypeNotFound, fmt.Sprintf("no node inventory found at %s", path))
+	}
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read inventory %s", path), err)
+	}
+	inventory := &Inventory{}
+	if err := yaml.Unmarshal(data, inventory); err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("failed to parse inventory %s", path), err)
+	}
+	return inventory, nil
+}
+
+// SaveInventory atomically writes the nodes of a platform config as its inventory.
+// SaveInventory 以原子方式将平台配置的节点写为其清单。
+// The file may hold SSH passwords, so it is only readable by its owner.
+// 该文件可能包含 SSH 密码，因此只有其所有者可读。
+// path: Inventory file path. / 清单文件路径。
+// configName: Name of the platform config. / 平台配置的名称。
+// nodes: Nodes that are part of the Host Cluster. / 属于 Host 集群的节点。
+func SaveInventory(path, configName string, nodes []model.NodeConfig) error {
+	data, err := yaml.Marshal(&Inventory{ConfigName: configName, UpdatedAt: time.Now().UTC(), Nodes: nodes})
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeInternal, "failed to marshal node inventory", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := utils.WriteFileContent(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write inventory %s", path), err)
+	}
+	return nil
+}
+
+// saveInventory records the deployed nodes. A failure only weakens a later apply, so it is logged rather than returned.
+// saveInventory 记录已部署的节点。失败只会影响之后的 apply，因此只记录日志而不返回。
+func (d *defaultDeployer) saveInventory(config *model.PlatformConfig) {
+	if d.inventoryDir == "" {
+		return
+	}
+	path := InventoryPath(d.inventoryDir, config.Metadata.Name)
+	if err := SaveInventory(path, config.Metadata.Name, config.Cluster.Nodes); err != nil {
+		utils.GetLogger().Printf("Warning: Failed to record the node inventory: %v", err)
+	}
+}
+
+// clusterNodes returns the nodes of the config and of the recorded inventory, except the node at exclude.
+// clusterNodes 返回配置和已记录清单中的节点，不包括 exclude 处的节点。
+// The inventory still lists nodes deleted from the config but not yet removed from the cluster.
+// 清单中仍列有已从配置中删除但尚未从集群中移除的节点。
+func (d *defaultDeployer) clusterNodes(config *model.PlatformConfig, exclude string) []model.NodeConfig {
+	nodes := append([]model.NodeConfig(nil), config.Cluster.Nodes...)
+	if d.inventoryDir != "" {
+		if inventory, err := LoadInventory(InventoryPath(d.inventoryDir, config.Metadata.Name)); err == nil {
+			nodes = append(nodes, inventory.Nodes...)
+		}
+	}
+	var result []model.NodeConfig
+	seen := map[string]bool{exclude: true}
+	for _, node := range nodes {
+		if !seen[node.Address] {
+			seen[node.Address] = true
+			result = append(result, node)
+		}
+	}
+	return result
+}
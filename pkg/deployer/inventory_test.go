@@ -0,0 +1,49 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+func TestInventory_SaveAndLoad(t *testing.T) {
+	path := InventoryPath(filepath.Join(t.TempDir(), "inventory"), "prod")
+	_, err := LoadInventory(path)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeNotFound))
+
+	nodes := []model.NodeConfig{{Address: "10.0.0.1", User: "root", Password: "secret", Roles: []enum.NodeRole{enum.RoleMaster}}}
+	require.NoError(t, SaveInventory(path, "prod", nodes))
+
+	inventory, err := LoadInventory(path)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", inventory.ConfigName)
+	assert.Equal(t, nodes[0].Address, inventory.Nodes[0].Address)
+	assert.Equal(t, nodes[0].Password, inventory.Nodes[0].Password, "removed nodes must stay reachable")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestDeploy_RecordsInventory(t *testing.T) {
+	utils.InitLogger("info", 0)
+	rec := &callRecorder{}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{})
+	d.inventoryDir = t.TempDir()
+	config := testPlatformConfig()
+
+	require.NoError(t, d.Deploy(context.Background(), config))
+
+	inventory, err := LoadInventory(InventoryPath(d.inventoryDir, config.Metadata.Name))
+	require.NoError(t, err)
+	assert.Len(t, inventory.Nodes, len(config.Cluster.Nodes))
+}
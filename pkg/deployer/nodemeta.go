@@ -0,0 +1,381 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/phases"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// ManagedMetadataAnnotation records which labels, annotations and taints of a Node chasi-bod manages.
+// ManagedMetadataAnnotation 记录 chasi-bod 管理 Node 的哪些标签、注解和污点。
+// Only these are removed when they are no longer declared; metadata set by others is left alone.
+// 只有这些在不再声明时才会被移除；其他方设置的元数据保持不变。
+const ManagedMetadataAnnotation = "chasi-bod.io/managed-metadata"
+
+// nodeRegistrationTimeout bounds how long a joined node may take to register its Node object.
+// nodeRegistrationTimeout 限制已加入的节点注册其 Node 对象所需的最长时间。
+var nodeRegistrationTimeout = 2 * time.Minute
+
+// nodeRegistrationPollInterval is how often the Node of a joined node is looked up.
+// nodeRegistrationPollInterval 是查找已加入节点的 Node 的间隔。
+var nodeRegistrationPollInterval = 2 * time.Second
+
+// nodeReadyTimeout bounds how long a joined node may take to become Ready once it has registered.
+// nodeReadyTimeout 限制已加入的节点在注册后变为 Ready 所需的最长时间。
+var nodeReadyTimeout = 5 * time.Minute
+
+// managedMetadata is the value of ManagedMetadataAnnotation.
+// managedMetadata 是 ManagedMetadataAnnotation 的值。
+type managedMetadata struct {
+	Labels      []string `json:"labels,omitempty"`      // Label keys / 标签键
+	Annotations []string `json:"annotations,omitempty"` // Annotation keys / 注解键
+	Taints      []string `json:"taints,omitempty"`      // Taints as key:Effect / 以 key:Effect 表示的污点
+}
+
+// ParseTaint parses a taint declared as key[=value]:Effect.
+// ParseTaint 解析以 key[=value]:Effect 形式声明的污点。
+// taint: The declared taint. / 声明的污点。
+func ParseTaint(taint string) (corev1.Taint, error) {
+	idx := strings.LastIndex(taint, ":")
+	if idx <= 0 {
+		return corev1.Taint{}, errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid taint %q: expected key[=value]:Effect", taint))
+	}
+	keyValue, effect := taint[:idx], corev1.TaintEffect(taint[idx+1:])
+	switch effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return corev1.Taint{}, errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid taint %q: effect must be NoSchedule, PreferNoSchedule or NoExecute", taint))
+	}
+	key, value, _ := strings.Cut(keyValue, "=")
+	if key == "" {
+		return corev1.Taint{}, errors.New(errors.ErrTypeValidation, fmt.Sprintf("invalid taint %q: the key is empty", taint))
+	}
+	return corev1.Taint{Key: key, Value: value, Effect: effect}, nil
+}
+
+// validateNodeMetadata checks the declared taints of all nodes, so a typo fails before any node is touched.
+// validateNodeMetadata 检查所有节点声明的污点，以便拼写错误在改动任何节点之前就失败。
+func validateNodeMetadata(nodes []model.NodeConfig) error {
+	for _, nodeCfg := range nodes {
+		if _, err := parseTaints(&nodeCfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTaints parses the declared taints of a node.
+// parseTaints 解析节点声明的污点。
+func parseTaints(nodeCfg *model.NodeConfig) ([]corev1.Taint, error) {
+	taints := make([]corev1.Taint, 0, len(nodeCfg.Taints))
+	for _, declared := range nodeCfg.Taints {
+		taint, err := ParseTaint(declared)
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("node %s", nodeCfg.Address), err)
+		}
+		taints = append(taints, taint)
+	}
+	return taints, nil
+}
+
+// taintID identifies a taint the way the API server does: by key and effect.
+// taintID 按照 API 服务器的方式标识污点：通过键和效果。
+func taintID(taint corev1.Taint) string {
+	return taint.Key + ":" + string(taint.Effect)
+}
+
+// NodeMetadataChanges lists what reconciling the Node with the declared labels, annotations and taints would change,
+// e.g. "label zone=a" or "remove taint dedicated:NoSchedule".
+// NodeMetadataChanges 列出将 Node 与声明的标签、注解和污点协调时会发生的更改，例如 "label zone=a" 或 "remove taint dedicated:NoSchedule"。
+// nodeCfg: The declared node. / 声明的节点。
+// node: The Node registered for it. / 为其注册的 Node。
+func NodeMetadataChanges(nodeCfg *model.NodeConfig, node *corev1.Node) ([]string, error) {
+	_, changes, err := nodeMetadataPatch(nodeCfg, node)
+	return changes, err
+}
+
+// nodeMetadataPatch computes the JSON merge patch that brings a Node in line with the declared metadata,
+// together with a description of each change. The patch is nil if nothing changes.
+// nodeMetadataPatch 计算使 Node 与声明的元数据一致的 JSON 合并补丁，以及每项更改的描述。如果没有更改，补丁为 nil。
+func nodeMetadataPatch(nodeCfg *model.NodeConfig, node *corev1.Node) (map[string]interface{}, []string, error) {
+	declaredTaints, err := parseTaints(nodeCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var previous managedMetadata
+	if raw := node.Annotations[ManagedMetadataAnnotation]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &previous); err != nil {
+			utils.GetLogger().Printf("Warning: Ignoring the unreadable %s annotation of node %s: %v", ManagedMetadataAnnotation, node.Name, err)
+		}
+	}
+
+	var changes []string
+	labels := map[string]interface{}{}
+	for _, key := range sortedKeys(nodeCfg.Labels) {
+		if current, ok := node.Labels[key]; !ok || current != nodeCfg.Labels[key] {
+			labels[key] = nodeCfg.Labels[key]
+			changes = append(changes, fmt.Sprintf("label %s=%s", key, nodeCfg.Labels[key]))
+		}
+	}
+	for _, key := range previous.Labels {
+		if _, declared := nodeCfg.Labels[key]; !declared {
+			if _, ok := node.Labels[key]; ok {
+				labels[key] = nil
+				changes = append(changes, "remove label "+key)
+			}
+		}
+	}
+
+	annotations := map[string]interface{}{}
+	for _, key := range sortedKeys(nodeCfg.Annotations) {
+		if current, ok := node.Annotations[key]; !ok || current != nodeCfg.Annotations[key] {
+			annotations[key] = nodeCfg.Annotations[key]
+			changes = append(changes, fmt.Sprintf("annotation %s=%s", key, nodeCfg.Annotations[key]))
+		}
+	}
+	for _, key := range previous.Annotations {
+		if _, declared := nodeCfg.Annotations[key]; !declared {
+			if _, ok := node.Annotations[key]; ok {
+				annotations[key] = nil
+				changes = append(changes, "remove annotation "+key)
+			}
+		}
+	}
+
+	// Taints are a list, so the patch carries the whole list: foreign taints are kept in place,
+	// declared ones are set and the ones chasi-bod managed before are dropped.
+	// 污点是一个列表，因此补丁携带整个列表：保留其他方的污点，设置声明的污点，并删除 chasi-bod 之前管理的污点。
+	declaredByID := make(map[string]corev1.Taint, len(declaredTaints))
+	for _, taint := range declaredTaints {
+		declaredByID[taintID(taint)] = taint
+	}
+	previousTaints := make(map[string]bool, len(previous.Taints))
+	for _, id := range previous.Taints {
+		previousTaints[id] = true
+	}
+	taints := make([]corev1.Taint, 0, len(node.Spec.Taints)+len(declaredTaints))
+	placed := map[string]bool{}
+	taintsChanged := false
+	for _, existing := range node.Spec.Taints {
+		id := taintID(existing)
+		if declared, ok := declaredByID[id]; ok {
+			if !placed[id] {
+				if existing.Value != declared.Value {
+					changes = append(changes, "taint "+formatTaint(declared))
+					taintsChanged = true
+					existing.Value = declared.Value
+				}
+				taints = append(taints, existing)
+				placed[id] = true
+			}
+			continue
+		}
+		if previousTaints[id] {
+			changes = append(changes, "remove taint "+id)
+			taintsChanged = true
+			continue
+		}
+		taints = append(taints, existing)
+	}
+	for _, taint := range declaredTaints {
+		if !placed[taintID(taint)] {
+			changes = append(changes, "taint "+formatTaint(taint))
+			taintsChanged = true
+			taints = append(taints, taint)
+			placed[taintID(taint)] = true
+		}
+	}
+
+	// The ownership annotation follows the declaration, so the next reconciliation knows what to remove.
+	// 所有权注解跟随声明，以便下一次协调知道要移除什么。
+	owned := managedMetadata{Labels: sortedKeys(nodeCfg.Labels), Annotations: sortedKeys(nodeCfg.Annotations)}
+	for _, taint := range declaredTaints {
+		owned.Taints = append(owned.Taints, taintID(taint))
+	}
+	sort.Strings(owned.Taints)
+	if len(owned.Labels)+len(owned.Annotations)+len(owned.Taints) == 0 {
+		if _, ok := node.Annotations[ManagedMetadataAnnotation]; ok {
+			annotations[ManagedMetadataAnnotation] = nil
+		}
+	} else {
+		raw, err := json.Marshal(owned)
+		if err != nil {
+			return nil, nil, errors.NewWithCause(errors.ErrTypeInternal, "failed to encode the managed metadata", err)
+		}
+		if node.Annotations[ManagedMetadataAnnotation] != string(raw) {
+			annotations[ManagedMetadataAnnotation] = string(raw)
+		}
+	}
+
+	if len(labels) == 0 && len(annotations) == 0 && !taintsChanged {
+		return nil, nil, nil
+	}
+	if len(changes) == 0 {
+		// Only the bookkeeping changes, e.g. on metadata that was already in place.
+		// 只有记录发生变化，例如元数据已经存在的情况。
+		changes = append(changes, "record the managed metadata in "+ManagedMetadataAnnotation)
+	}
+	metadata := map[string]interface{}{"resourceVersion": node.ResourceVersion}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	patch := map[string]interface{}{"metadata": metadata}
+	if taintsChanged {
+		patch["spec"] = map[string]interface{}{"taints": taints}
+	}
+	return patch, changes, nil
+}
+
+// ReconcileNodeMetadata applies the declared labels, annotations and taints to a Node with a merge patch,
+// and removes the ones chasi-bod managed before that are no longer declared.
+// ReconcileNodeMetadata 使用合并补丁将声明的标签、注解和污点应用到 Node，并移除 chasi-bod 之前管理但不再声明的项。
+// The patch carries the Node's resourceVersion, so a concurrent update makes it retry against the new state.
+// 补丁携带 Node 的 resourceVersion，因此并发更新会使其基于新状态重试。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// hostK8sClient: A Kubernetes client connected to the Host Cluster. / 连接到 Host 集群的 Kubernetes 客户端。
+// nodeCfg: The declared node. / 声明的节点。
+// nodeName: The name of its Node. / 其 Node 的名称。
+// Returns the applied changes, empty if the Node already matched.
+// 返回已应用的更改，如果 Node 已经一致则为空。
+func ReconcileNodeMetadata(ctx context.Context, hostK8sClient kubernetes.Interface, nodeCfg *model.NodeConfig, nodeName string) ([]string, error) {
+	var changes []string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := hostK8sClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		patch, nodeChanges, err := nodeMetadataPatch(nodeCfg, node)
+		if err != nil || patch == nil {
+			changes = nil
+			return err
+		}
+		raw, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		changes = nodeChanges
+		_, err = hostK8sClient.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, raw, metav1.PatchOptions{})
+		return err
+	})
+	if err != nil {
+		if errors.IsChasiBodError(err, errors.ErrTypeValidation) {
+			return nil, err
+		}
+		return nil, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to update the labels, annotations and taints of node %s", nodeName), err)
+	}
+	return changes, nil
+}
+
+// reconcileNodeMetadata brings the Nodes of freshly deployed or joined nodes in line with their declared metadata.
+// reconcileNodeMetadata 使新部署或加入的节点的 Node 与其声明的元数据保持一致。
+// Nodes declaring metadata are waited for until they register; the others are only cleaned up if they are registered.
+// 声明了元数据的节点会一直等待到其注册；其他节点仅在已注册时才会被清理。
+func (d *defaultDeployer) reconcileNodeMetadata(ctx context.Context, config *model.PlatformConfig, nodes []model.NodeConfig) error {
+	hostK8sClient, err := d.hostK8sClient(config)
+	if err != nil {
+		return err
+	}
+	for i := range nodes {
+		nodeCfg := &nodes[i]
+		declares := len(nodeCfg.Labels)+len(nodeCfg.Annotations)+len(nodeCfg.Taints) > 0
+		node, err := waitForNode(ctx, hostK8sClient, nodeCfg.Address, declares)
+		if err != nil {
+			return &NodeError{Node: nodeCfg.Address, Phase: "Node metadata", Err: err}
+		}
+		if node == nil {
+			continue
+		}
+		item := "Reconcile the labels, annotations and taints of node " + node.Name
+		nodeCtx := plan.WithTarget(ctx, nodeCfg.Address)
+		changes, err := ReconcileNodeMetadata(ctx, hostK8sClient, nodeCfg, node.Name)
+		switch {
+		case err != nil:
+			plan.Record(nodeCtx, item, plan.StatusFailed, err)
+			return &NodeError{Node: nodeCfg.Address, Phase: "Node metadata", Err: err}
+		case len(changes) > 0:
+			utils.GetLogger().Printf("Updated node %s (%s): %s", nodeCfg.Address, node.Name, strings.Join(changes, ", "))
+			plan.Record(nodeCtx, item, plan.StatusChanged, nil)
+		default:
+			plan.Record(nodeCtx, item, plan.StatusUnchanged, nil)
+		}
+	}
+	return nil
+}
+
+// waitForNode returns the Node registered for address. If wait is set it polls until the node registers
+// or nodeRegistrationTimeout expires; otherwise a missing Node is returned as nil.
+// waitForNode 返回为 address 注册的 Node。如果设置了 wait，则轮询直到节点注册或 nodeRegistrationTimeout 到期；否则缺失的 Node 返回 nil。
+func waitForNode(ctx context.Context, hostK8sClient kubernetes.Interface, address string, wait bool) (*corev1.Node, error) {
+	deadline := time.Now().Add(nodeRegistrationTimeout)
+	for {
+		node, err := findNode(ctx, hostK8sClient, address)
+		if err != nil || node != nil || !wait {
+			return node, err
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New(errors.ErrTypeTimeout, fmt.Sprintf("node %s did not register in the Host Cluster within %s", address, nodeRegistrationTimeout))
+		}
+		if err := sleepContext(ctx, nodeRegistrationPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitForNodeReady polls until the Node registered for address is Ready or nodeReadyTimeout expires.
+// waitForNodeReady 轮询直到为 address 注册的 Node 处于 Ready 状态或 nodeReadyTimeout 到期。
+func waitForNodeReady(ctx context.Context, hostK8sClient kubernetes.Interface, address string) error {
+	deadline := time.Now().Add(nodeReadyTimeout)
+	for {
+		node, err := findNode(ctx, hostK8sClient, address)
+		if err != nil {
+			return err
+		}
+		if node != nil && phases.NodeReady(node) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(errors.ErrTypeTimeout, fmt.Sprintf("node %s is not Ready in the Host Cluster after %s", address, nodeReadyTimeout))
+		}
+		if err := sleepContext(ctx, nodeRegistrationPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// formatTaint renders a taint the way it is declared, key[=value]:Effect.
+// formatTaint 以声明的形式 key[=value]:Effect 渲染污点。
+func formatTaint(taint corev1.Taint) string {
+	if taint.Value == "" {
+		return taintID(taint)
+	}
+	return taint.Key + "=" + taint.Value + ":" + string(taint.Effect)
+}
+
+// sortedKeys returns the keys of m in order, so changes are listed in a stable order.
+// sortedKeys 按顺序返回 m 的键，以使更改以稳定的顺序列出。
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,148 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// readyNode returns a registered Node with the Ready condition.
+func readyNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	}
+}
+
+func TestParseTaint(t *testing.T) {
+	taint, err := ParseTaint("dedicated=db:NoSchedule")
+	require.NoError(t, err)
+	assert.Equal(t, corev1.Taint{Key: "dedicated", Value: "db", Effect: corev1.TaintEffectNoSchedule}, taint)
+
+	taint, err = ParseTaint("example.com/gpu:NoExecute")
+	require.NoError(t, err)
+	assert.Equal(t, corev1.Taint{Key: "example.com/gpu", Effect: corev1.TaintEffectNoExecute}, taint)
+
+	for _, invalid := range []string{"dedicated=db", "dedicated:Sometimes", "=db:NoSchedule", ":NoSchedule"} {
+		_, err := ParseTaint(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestReconcileNodeMetadata(t *testing.T) {
+	utils.InitLogger("info", 0)
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"team": "ops", "zone": "old"}},
+		Spec:       corev1.NodeSpec{Taints: []corev1.Taint{{Key: "node.kubernetes.io/unreachable", Effect: corev1.TaintEffectNoExecute}}},
+	})
+	nodeCfg := &model.NodeConfig{
+		Address:     "10.0.0.1",
+		Labels:      map[string]string{"zone": "a", "disk": "ssd"},
+		Annotations: map[string]string{"example.com/owner": "db-team"},
+		Taints:      []string{"dedicated=db:NoSchedule", "gpu:NoExecute"},
+	}
+
+	changes, err := ReconcileNodeMetadata(ctx, client, nodeCfg, "node-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"label disk=ssd", "label zone=a", "annotation example.com/owner=db-team", "taint dedicated=db:NoSchedule", "taint gpu:NoExecute"}, changes)
+
+	node, err := client.CoreV1().Nodes().Get(ctx, "node-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "ops", "zone": "a", "disk": "ssd"}, node.Labels)
+	assert.Equal(t, "db-team", node.Annotations["example.com/owner"])
+	assert.JSONEq(t, `{"labels":["disk","zone"],"annotations":["example.com/owner"],"taints":["dedicated:NoSchedule","gpu:NoExecute"]}`, node.Annotations[ManagedMetadataAnnotation])
+	assert.Len(t, node.Spec.Taints, 3)
+
+	changes, err = ReconcileNodeMetadata(ctx, client, nodeCfg, "node-1")
+	require.NoError(t, err)
+	assert.Empty(t, changes, "a second run changes nothing")
+
+	// Dropping declarations removes what chasi-bod managed, and only that.
+	// 删除声明会移除 chasi-bod 管理的内容，且仅移除这些内容。
+	nodeCfg.Labels = map[string]string{"zone": "a"}
+	nodeCfg.Annotations = nil
+	nodeCfg.Taints = []string{"dedicated=cache:NoSchedule"}
+	changes, err = ReconcileNodeMetadata(ctx, client, nodeCfg, "node-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remove label disk", "remove annotation example.com/owner", "taint dedicated=cache:NoSchedule", "remove taint gpu:NoExecute"}, changes)
+
+	node, err = client.CoreV1().Nodes().Get(ctx, "node-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "ops", "zone": "a"}, node.Labels)
+	assert.NotContains(t, node.Annotations, "example.com/owner")
+	assert.Equal(t, []corev1.Taint{
+		{Key: "node.kubernetes.io/unreachable", Effect: corev1.TaintEffectNoExecute},
+		{Key: "dedicated", Value: "cache", Effect: corev1.TaintEffectNoSchedule},
+	}, node.Spec.Taints)
+
+	nodeCfg.Labels, nodeCfg.Taints = nil, nil
+	_, err = ReconcileNodeMetadata(ctx, client, nodeCfg, "node-1")
+	require.NoError(t, err)
+	node, err = client.CoreV1().Nodes().Get(ctx, "node-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, node.Annotations, ManagedMetadataAnnotation, "nothing is managed any more")
+	assert.Equal(t, map[string]string{"team": "ops"}, node.Labels)
+}
+
+func TestDeploy_ReconcilesNodeMetadata(t *testing.T) {
+	utils.InitLogger("info", 0)
+	nodeRegistrationPollInterval = time.Millisecond
+	config := testPlatformConfig()
+	config.Cluster.Nodes[1].Labels = map[string]string{"zone": "a"}
+	d := newFakeDeployer(&callRecorder{}, t.TempDir(), RunOptions{})
+	client := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.2"}})
+	d.hostClient = client
+
+	require.NoError(t, d.Deploy(context.Background(), config))
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "10.0.0.2", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "a", node.Labels["zone"])
+
+	config.Cluster.Nodes[1].Taints = []string{"dedicated"}
+	err = d.Deploy(context.Background(), config)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation), "invalid taints fail before any phase runs")
+}
+
+func TestAddNode_WaitsForRegistration(t *testing.T) {
+	utils.InitLogger("info", 0)
+	nodeRegistrationPollInterval, nodeRegistrationTimeout = time.Millisecond, 20*time.Millisecond
+	defer func() { nodeRegistrationTimeout = 2 * time.Minute }()
+	config := testPlatformConfig()
+	d := newFakeDeployer(&callRecorder{}, t.TempDir(), RunOptions{SkipPreflight: true})
+
+	nodeCfg := model.NodeConfig{Address: "10.0.0.3", Labels: map[string]string{"zone": "b"}}
+	err := d.AddNode(context.Background(), config, &nodeCfg)
+	var nodeErr *NodeError
+	require.ErrorAs(t, err, &nodeErr)
+	assert.True(t, chasierrors.IsChasiBodError(nodeErr.Err, chasierrors.ErrTypeTimeout), "the Node never registers")
+}
+
+func TestAddNode_WaitsForReady(t *testing.T) {
+	utils.InitLogger("info", 0)
+	nodeRegistrationPollInterval, nodeReadyTimeout = time.Millisecond, 20*time.Millisecond
+	defer func() { nodeReadyTimeout = 5 * time.Minute }()
+	config := testPlatformConfig()
+	d := newFakeDeployer(&callRecorder{}, t.TempDir(), RunOptions{SkipPreflight: true})
+	d.hostClient = fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.3"}})
+
+	nodeCfg := model.NodeConfig{Address: "10.0.0.3"}
+	err := d.AddNode(context.Background(), config, &nodeCfg)
+	var nodeErr *NodeError
+	require.ErrorAs(t, err, &nodeErr)
+	assert.Equal(t, "Node Ready", nodeErr.Phase)
+	assert.True(t, chasierrors.IsChasiBodError(nodeErr.Err, chasierrors.ErrTypeTimeout), "the Node never becomes Ready")
+
+	d.hostClient = fake.NewSimpleClientset(readyNode("10.0.0.3"))
+	assert.NoError(t, d.AddNode(context.Background(), config, &nodeCfg))
+}
@@ -0,0 +1,215 @@
+// Package deployer orchestrates the deployment of the chasi-bod platform onto target nodes.
+// 包 deployer 协调将 chasi-bod 平台部署到目标节点。
+package deployer
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// ParallelOptions controls how node-specific work fans out across nodes.
+// ParallelOptions 控制节点特定的工作如何在节点之间并行展开。
+type ParallelOptions struct {
+	// MaxParallel is the maximum number of nodes processed at the same time (values < 1 mean 1).
+	// MaxParallel 是同时处理的最大节点数（小于 1 的值视为 1）。
+	MaxParallel int
+	// FailurePolicy decides whether a failing node cancels the others or lets them finish.
+	// FailurePolicy 决定失败的节点是取消其他节点还是让它们完成。
+	FailurePolicy enum.FailurePolicy
+}
+
+// DefaultParallelOptions returns the parallelism used when nothing else is configured.
+// DefaultParallelOptions 返回未进行其他配置时使用的并行设置。
+func DefaultParallelOptions() ParallelOptions {
+	return ParallelOptions{
+		MaxParallel:   constants.DefaultMaxParallel,
+		FailurePolicy: enum.FailurePolicyFailFast,
+	}
+}
+
+// Validate checks that the options are usable.
+// Validate 检查选项是否可用。
+func (o ParallelOptions) Validate() error {
+	if o.MaxParallel < 1 {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("max parallel must be at least 1, got %d", o.MaxParallel))
+	}
+	switch o.FailurePolicy {
+	case enum.FailurePolicyFailFast, enum.FailurePolicyContinue:
+		return nil
+	default:
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported failure policy %q (expected %q or %q)", o.FailurePolicy, enum.FailurePolicyFailFast, enum.FailurePolicyContinue))
+	}
+}
+
+// NodeError records the failure of one phase on one node.
+// NodeError 记录某个阶段在某个节点上的失败。
+type NodeError struct {
+	Node  string // Address of the failed node / 失败节点的地址
+	Phase string // Name of the phase that failed / 失败阶段的名称
+	Err   error  // Underlying error / 底层错误
+}
+
+// Error returns the string representation of the error.
+// Error 返回错误的字符串表示。
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("%s phase failed on node %s: %v", e.Phase, e.Node, e.Err)
+}
+
+// Unwrap returns the underlying cause error.
+// Unwrap 返回底层原因错误。
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// NodeErrors aggregates the failures of a parallel run, in node order.
+// NodeErrors 按节点顺序汇总并行运行中的失败。
+type NodeErrors []*NodeError
+
+// Error lists every failed node and phase.
+// Error 列出每个失败的节点和阶段。
+func (e NodeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, nodeErr := range e {
+		msgs[i] = nodeErr.Error()
+	}
+	return fmt.Sprintf("%d node failure(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the individual node errors so errors.Is and errors.As can inspect them.
+// Unwrap 返回各个节点错误，以便 errors.Is 和 errors.As 检查它们。
+func (e NodeErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, nodeErr := range e {
+		errs[i] = nodeErr
+	}
+	return errs
+}
+
+// Has reports whether the given node failed.
+// Has 报告给定节点是否失败。
+func (e NodeErrors) Has(address string) bool {
+	for _, nodeErr := range e {
+		if nodeErr.Node == address {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeFunc is the work performed on a single node.
+// NodeFunc 是在单个节点上执行的工作。
+type NodeFunc func(ctx context.Context, nodeCfg *model.NodeConfig) error
+
+// RunOnNodes runs fn on every node with at most opts.MaxParallel nodes in flight.
+// RunOnNodes 在每个节点上运行 fn，同时进行的节点数不超过 opts.MaxParallel。
+// Each node gets its own context bounded by timeout. With the fail-fast policy the first failure
+// cancels the nodes still running and nothing new is started; with the continue policy every node runs.
+// 每个节点都有自己受 timeout 限制的上下文。在 fail-fast 策略下，第一次失败会取消仍在运行的节点且不再启动新节点；
+// 在 continue 策略下所有节点都会运行。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// phase: Phase name used in logs and errors. / 用于日志和错误的阶段名称。
+// nodes: Nodes to run on. / 要运行的节点。
+// timeout: Timeout for a single node. / 单个节点的超时时间。
+// opts: Parallelism settings. / 并行设置。
+// fn: Work to run on each node. / 在每个节点上运行的工作。
+// Returns the failed nodes, or nil if every node succeeded.
+// 返回失败的节点，如果所有节点都成功则返回 nil。
+func RunOnNodes(ctx context.Context, phase string, nodes []model.NodeConfig, timeout time.Duration, opts ParallelOptions, fn NodeFunc) NodeErrors {
+	maxParallel := opts.MaxParallel
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	failFast := opts.FailurePolicy != enum.FailurePolicyContinue
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Errors are stored by node index so the aggregated error is stable regardless of completion order.
+	// 错误按节点索引存储，因此无论完成顺序如何，汇总的错误都是稳定的。
+	results := make([]*NodeError, len(nodes))
+	var (
+		mu        sync.Mutex
+		aborted   bool
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, maxParallel)
+	)
+
+	for i := range nodes {
+		select {
+		case semaphore <- struct{}{}:
+		case <-runCtx.Done():
+		}
+		if runCtx.Err() != nil {
+			// Nodes that never started are only reported when the caller cancelled, not after a fail-fast abort.
+			// 仅当调用方取消时才报告从未启动的节点，fail-fast 中止后不报告。
+			mu.Lock()
+			if !aborted {
+				results[i] = &NodeError{Node: nodes[i].Address, Phase: phase, Err: errors.NewWithCause(errors.ErrTypeTimeout, "node was not started", ctx.Err())}
+			}
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			nodeCfg := nodes[i]
+			nodeCtx, nodeCancel := context.WithTimeout(runCtx, timeout)
+			defer nodeCancel()
+
+			utils.GetLogger().Printf("[%s] Starting on node %d/%d: %s", phase, i+1, len(nodes), nodeCfg.Address)
+			err := fn(nodeCtx, &nodeCfg)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				utils.GetLogger().Printf("[%s] Node %s completed successfully.", phase, nodeCfg.Address)
+			case aborted && stderrors.Is(err, context.Canceled):
+				// This node was interrupted because another node failed first; it did not fail on its own.
+				// 该节点因另一个节点先失败而被中断；它本身并没有失败。
+				utils.GetLogger().Printf("[%s] Node %s aborted after another node failed.", phase, nodeCfg.Address)
+			default:
+				utils.GetLogger().Printf("[%s] Node %s failed: %v", phase, nodeCfg.Address, err)
+				results[i] = &NodeError{Node: nodeCfg.Address, Phase: phase, Err: err}
+				if failFast && !aborted {
+					aborted = true
+					cancel()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var failed NodeErrors
+	for _, nodeErr := range results {
+		if nodeErr != nil {
+			failed = append(failed, nodeErr)
+		}
+	}
+	return failed
+}
+
+// excludeFailed returns the nodes that are not part of failed, preserving order.
+// excludeFailed 返回不属于 failed 的节点，并保持顺序。
+func excludeFailed(nodes []model.NodeConfig, failed NodeErrors) []model.NodeConfig {
+	remaining := make([]model.NodeConfig, 0, len(nodes))
+	for _, nodeCfg := range nodes {
+		if !failed.Has(nodeCfg.Address) {
+			remaining = append(remaining, nodeCfg)
+		}
+	}
+	return remaining
+}
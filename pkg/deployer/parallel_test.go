@@ -0,0 +1,93 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+func testNodes(n int) []model.NodeConfig {
+	nodes := make([]model.NodeConfig, n)
+	for i := range nodes {
+		nodes[i].Address = fmt.Sprintf("10.0.0.%d", i+1)
+	}
+	return nodes
+}
+
+func TestRunOnNodes_RespectsMaxParallel(t *testing.T) {
+	utils.InitLogger("info", 0)
+	var inFlight, peak int32
+	failed := RunOnNodes(context.Background(), "Test", testNodes(12), time.Minute, ParallelOptions{MaxParallel: 3, FailurePolicy: enum.FailurePolicyFailFast}, func(ctx context.Context, nodeCfg *model.NodeConfig) error {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if current <= old || atomic.CompareAndSwapInt32(&peak, old, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	assert.Empty(t, failed)
+	assert.LessOrEqual(t, peak, int32(3))
+	assert.Greater(t, peak, int32(1), "nodes should actually run concurrently")
+}
+
+func TestRunOnNodes_ContinueReportsEveryFailureInNodeOrder(t *testing.T) {
+	utils.InitLogger("info", 0)
+	var mu sync.Mutex
+	var ran []string
+	failed := RunOnNodes(context.Background(), "OS Configuration", testNodes(5), time.Minute, ParallelOptions{MaxParallel: 5, FailurePolicy: enum.FailurePolicyContinue}, func(ctx context.Context, nodeCfg *model.NodeConfig) error {
+		mu.Lock()
+		ran = append(ran, nodeCfg.Address)
+		mu.Unlock()
+		if nodeCfg.Address == "10.0.0.4" || nodeCfg.Address == "10.0.0.2" {
+			return errors.New("disk missing")
+		}
+		return nil
+	})
+	assert.Len(t, ran, 5, "the continue policy runs every node")
+	require.Len(t, failed, 2)
+	assert.Equal(t, "10.0.0.2", failed[0].Node)
+	assert.Equal(t, "10.0.0.4", failed[1].Node)
+	assert.True(t, failed.Has("10.0.0.4"))
+	assert.Equal(t, "2 node failure(s): OS Configuration phase failed on node 10.0.0.2: disk missing; OS Configuration phase failed on node 10.0.0.4: disk missing", failed.Error())
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.3", "10.0.0.5"}, addresses(excludeFailed(testNodes(5), failed)))
+}
+
+func TestRunOnNodes_FailFastCancelsRemainingNodes(t *testing.T) {
+	utils.InitLogger("info", 0)
+	var started int32
+	failed := RunOnNodes(context.Background(), "Init", testNodes(10), time.Minute, ParallelOptions{MaxParallel: 2, FailurePolicy: enum.FailurePolicyFailFast}, func(ctx context.Context, nodeCfg *model.NodeConfig) error {
+		atomic.AddInt32(&started, 1)
+		if nodeCfg.Address == "10.0.0.1" {
+			return errors.New("ssh refused")
+		}
+		// The other node in flight waits until it is cancelled by the failure.
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.Len(t, failed, 1, "nodes aborted by fail-fast are not reported as failures")
+	assert.Equal(t, "10.0.0.1", failed[0].Node)
+	assert.Less(t, atomic.LoadInt32(&started), int32(10), "no new nodes start after the first failure")
+}
+
+func addresses(nodes []model.NodeConfig) []string {
+	out := make([]string, len(nodes))
+	for i, nodeCfg := range nodes {
+		out[i] = nodeCfg.Address
+	}
+	return out
+}
@@ -0,0 +1,817 @@
+package phases
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+const (
+	// defaultClassAnnotation marks the default StorageClass of the cluster.
+	// defaultClassAnnotation 标记集群的默认 StorageClass。
+	defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	// managedByLabel marks the storage objects chasi-bod created.
+	// managedByLabel 标记 chasi-bod 创建的存储对象。
+	managedByLabel = "app.kubernetes.io/managed-by"
+	// hostnameLabel is the node label HostPath PersistentVolumes are pinned with.
+	// hostnameLabel 是用于固定 HostPath PersistentVolume 的节点标签。
+	hostnameLabel = "kubernetes.io/hostname"
+	// noProvisioner is the provisioner of classes that only bind static PersistentVolumes.
+	// noProvisioner 是仅绑定静态 PersistentVolume 的类所使用的 provisioner。
+	noProvisioner = "kubernetes.io/no-provisioner"
+
+	// LocalPathClassName is the StorageClass served by the local-path provisioner.
+	// LocalPathClassName 是由 local-path provisioner 提供的 StorageClass。
+	LocalPathClassName = "local-path"
+	// DefaultLocalPathDir is the node directory the local-path provisioner creates volumes in by default.
+	// DefaultLocalPathDir 是 local-path provisioner 默认创建卷的节点目录。
+	DefaultLocalPathDir = "/opt/local-path-provisioner"
+
+	localPathProvisioner  = "rancher.io/local-path"
+	localPathNamespace    = "local-path-storage"
+	localPathName         = "local-path-provisioner"
+	localPathConfigMap    = "local-path-config"
+	defaultLocalPathImage = "docker.io/rancher/local-path-provisioner:v0.0.30"
+	localPathHelperImage  = "docker.io/library/busybox:1.36"
+)
+
+// ReconcileStorage creates and updates the StorageClasses, the static PersistentVolumes and the local-path
+// provisioner declared by the cluster storage configuration, and returns the changes it made.
+// Objects removed from the configuration are left in place.
+// ReconcileStorage 创建并更新集群存储配置声明的 StorageClass、静态 PersistentVolume 和 local-path provisioner，
+// 并返回所做的更改。从配置中移除的对象会被保留。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// client: A Kubernetes client connected to the Host Cluster. / 连接到 Host 集群的 Kubernetes 客户端。
+// clusterCfg: The cluster configuration holding the storage configuration. / 包含存储配置的集群配置。
+func ReconcileStorage(ctx context.Context, client kubernetes.Interface, clusterCfg *model.ClusterConfig) ([]string, error) {
+	return reconcileStorage(ctx, client, clusterCfg, true)
+}
+
+// installStorage reconciles the cluster storage once the nodes are Ready, recording each change against the cluster.
+// installStorage 在节点就绪后协调集群存储，并针对集群记录每项更改。
+func (p *defaultK8sInstallPhase) installStorage(ctx context.Context, clusterCfg *model.ClusterConfig) error {
+	if !HasStorage(&clusterCfg.Storage) {
+		utils.GetLogger().Println("No storage declared, skipping storage deployment.")
+		return nil
+	}
+	utils.GetLogger().Println("Deploying cluster storage...")
+	clients, err := p.clusterClients(clusterCfg)
+	if err != nil {
+		return err
+	}
+	ctx = plan.WithTarget(ctx, plan.ClusterTarget)
+	changes, err := ReconcileStorage(ctx, clients.Kube, clusterCfg)
+	for _, change := range changes {
+		plan.Record(ctx, change, plan.StatusChanged, nil)
+	}
+	if err != nil {
+		plan.Record(ctx, "Reconcile cluster storage", plan.StatusFailed, err)
+		return err
+	}
+	utils.GetLogger().Printf("Cluster storage deployed (%d changes).", len(changes))
+	return nil
+}
+
+// StorageDrift reports the declared storage objects that are missing or differ from the configuration, without changing them.
+// StorageDrift 报告缺失或与配置不一致的已声明存储对象，但不做更改。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// client: A Kubernetes client connected to the Host Cluster. / 连接到 Host 集群的 Kubernetes 客户端。
+// clusterCfg: The cluster configuration holding the storage configuration. / 包含存储配置的集群配置。
+func StorageDrift(ctx context.Context, client kubernetes.Interface, clusterCfg *model.ClusterConfig) ([]string, error) {
+	return reconcileStorage(ctx, client, clusterCfg, false)
+}
+
+// reconcileStorage compares the storage objects with the configuration and, when apply is set, brings them in line.
+// It returns the changes made, or with apply unset the drift found.
+// reconcileStorage 将存储对象与配置进行比较，并在 apply 为 true 时使其一致。
+// 返回所做的更改，apply 为 false 时返回发现的偏差。
+func reconcileStorage(ctx context.Context, client kubernetes.Interface, clusterCfg *model.ClusterConfig, apply bool) ([]string, error) {
+	storage := &clusterCfg.Storage
+	var changes []string
+	if localPathEnabled(storage) {
+		localPathChanges, err := reconcileLocalPath(ctx, client, clusterCfg, apply)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, localPathChanges...)
+	}
+
+	classChanges, err := reconcileStorageClasses(ctx, client, storage, apply)
+	changes = append(changes, classChanges...)
+	if err != nil {
+		return changes, err
+	}
+
+	pvChanges, err := reconcilePersistentVolumes(ctx, client, storage, apply)
+	changes = append(changes, pvChanges...)
+	return changes, err
+}
+
+// HasStorage reports whether the storage configuration declares anything to deploy to the cluster.
+// HasStorage 报告存储配置是否声明了需要部署到集群的内容。
+func HasStorage(storage *types.StorageConfig) bool {
+	return storage.DefaultStorageClass != "" || len(storage.StorageClasses) > 0 || len(storage.PVConfigs) > 0 || localPathEnabled(storage)
+}
+
+// localPathEnabled reports whether the local-path provisioner is deployed: it is requested and no other dynamic provisioner is declared.
+// localPathEnabled 报告是否部署 local-path provisioner：已请求部署且未声明其他动态 provisioner。
+func localPathEnabled(storage *types.StorageConfig) bool {
+	if storage.LocalPathProvisioner == nil || !storage.LocalPathProvisioner.Enabled {
+		return false
+	}
+	for _, class := range storage.StorageClasses {
+		if class.Provisioner != localPathProvisioner && class.Provisioner != noProvisioner {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalPathDir returns the node directory of the local-path provisioner, or "" when it is not deployed.
+// LocalPathDir 返回 local-path provisioner 的节点目录，未部署时返回 ""。
+func LocalPathDir(storage *types.StorageConfig) string {
+	if !localPathEnabled(storage) {
+		return ""
+	}
+	if storage.LocalPathProvisioner.Path != "" {
+		return storage.LocalPathProvisioner.Path
+	}
+	return DefaultLocalPathDir
+}
+
+// defaultClassName returns the StorageClass to mark as default: the configured one, or local-path when none is configured.
+// defaultClassName 返回要标记为默认的 StorageClass：已配置的类，未配置时为 local-path。
+func defaultClassName(storage *types.StorageConfig) string {
+	if storage.DefaultStorageClass != "" {
+		return storage.DefaultStorageClass
+	}
+	if localPathEnabled(storage) {
+		return LocalPathClassName
+	}
+	return ""
+}
+
+// desiredStorageClasses returns the StorageClasses of the configuration, plus local-path when the provisioner is deployed.
+// desiredStorageClasses 返回配置中的 StorageClass，部署 provisioner 时还包括 local-path。
+func desiredStorageClasses(storage *types.StorageConfig) []*storagev1.StorageClass {
+	defaultName := defaultClassName(storage)
+	var classes []*storagev1.StorageClass
+	declared := map[string]bool{}
+	for _, class := range storage.StorageClasses {
+		declared[class.Name] = true
+		classes = append(classes, storageClassObject(class, class.Name == defaultName))
+	}
+	if localPathEnabled(storage) && !declared[LocalPathClassName] {
+		classes = append(classes, storageClassObject(types.StorageClassConfig{
+			Name:              LocalPathClassName,
+			Provisioner:       localPathProvisioner,
+			VolumeBindingMode: string(storagev1.VolumeBindingWaitForFirstConsumer),
+		}, defaultName == LocalPathClassName))
+	}
+	return classes
+}
+
+// storageClassObject builds the StorageClass of a class configuration.
+// storageClassObject 根据类配置构建 StorageClass。
+func storageClassObject(class types.StorageClassConfig, isDefault bool) *storagev1.StorageClass {
+	reclaim := corev1.PersistentVolumeReclaimDelete
+	if class.ReclaimPolicy != "" {
+		reclaim = corev1.PersistentVolumeReclaimPolicy(class.ReclaimPolicy)
+	}
+	binding := storagev1.VolumeBindingImmediate
+	if class.VolumeBindingMode != "" {
+		binding = storagev1.VolumeBindingMode(class.VolumeBindingMode)
+	}
+	expansion := class.AllowVolumeExpansion
+	return &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        class.Name,
+			Labels:      map[string]string{managedByLabel: fieldManager},
+			Annotations: map[string]string{defaultClassAnnotation: fmt.Sprint(isDefault)},
+		},
+		Provisioner:          class.Provisioner,
+		Parameters:           class.Parameters,
+		ReclaimPolicy:        &reclaim,
+		VolumeBindingMode:    &binding,
+		AllowVolumeExpansion: &expansion,
+	}
+}
+
+// reconcileStorageClasses creates the missing StorageClasses, recreates those whose immutable fields drifted,
+// updates the others in place and makes sure only the configured class is marked default.
+// reconcileStorageClasses 创建缺失的 StorageClass，重建不可变字段发生偏差的类，原地更新其他类，
+// 并确保只有配置的类被标记为默认。
+func reconcileStorageClasses(ctx context.Context, client kubernetes.Interface, storage *types.StorageConfig, apply bool) ([]string, error) {
+	classClient := client.StorageV1().StorageClasses()
+	var changes []string
+	managed := map[string]bool{}
+	for _, desired := range desiredStorageClasses(storage) {
+		managed[desired.Name] = true
+		item := "StorageClass " + desired.Name
+		live, err := classClient.Get(ctx, desired.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			if !apply {
+				changes = append(changes, item+": missing")
+				continue
+			}
+			if _, err := classClient.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+				return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to create "+item, err)
+			}
+			changes = append(changes, "Created "+item)
+			continue
+		}
+		if err != nil {
+			return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to read "+item, err)
+		}
+
+		immutable, mutable := storageClassDrift(live, desired)
+		switch {
+		case len(immutable) == 0 && len(mutable) == 0:
+		case !apply:
+			changes = append(changes, fmt.Sprintf("%s: %s", item, strings.Join(append(immutable, mutable...), ", ")))
+		case len(immutable) > 0:
+			// Provisioner, parameters, reclaim policy and binding mode cannot be updated, so the class is recreated.
+			// Bound claims and volumes keep working; only new claims use the new settings.
+			// provisioner、参数、回收策略和绑定模式不能更新，因此重建该类。
+			// 已绑定的声明和卷保持正常工作；只有新的声明使用新设置。
+			if err := classClient.Delete(ctx, desired.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to delete "+item, err)
+			}
+			if _, err := classClient.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+				return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to recreate "+item, err)
+			}
+			changes = append(changes, fmt.Sprintf("Recreated %s (%s)", item, strings.Join(immutable, ", ")))
+		default:
+			updated := live.DeepCopy()
+			updated.AllowVolumeExpansion = desired.AllowVolumeExpansion
+			updated.Labels = mergeStrings(updated.Labels, desired.Labels)
+			updated.Annotations = mergeStrings(updated.Annotations, desired.Annotations)
+			if _, err := classClient.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+				return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to update "+item, err)
+			}
+			changes = append(changes, fmt.Sprintf("Updated %s (%s)", item, strings.Join(mutable, ", ")))
+		}
+	}
+
+	defaultName := defaultClassName(storage)
+	if defaultName == "" {
+		return changes, nil
+	}
+	// The default class may be provided by a CSI driver outside the configuration; any other default is demoted.
+	// 默认类可能由配置之外的 CSI 驱动提供；其他默认类会被取消默认。
+	list, err := classClient.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to list StorageClasses", err)
+	}
+	foundDefault := false
+	for i := range list.Items {
+		class := &list.Items[i]
+		if class.Name == defaultName {
+			foundDefault = true
+		}
+		if managed[class.Name] {
+			continue
+		}
+		want := fmt.Sprint(class.Name == defaultName)
+		current := class.Annotations[defaultClassAnnotation]
+		if current == want || (want == "false" && current != "true") {
+			continue
+		}
+		item := "StorageClass " + class.Name
+		if !apply {
+			changes = append(changes, fmt.Sprintf("%s: default is %t, want %s", item, current == "true", want))
+			continue
+		}
+		payload := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, defaultClassAnnotation, want)
+		if _, err := classClient.Patch(ctx, class.Name, k8stypes.MergePatchType, []byte(payload), metav1.PatchOptions{}); err != nil {
+			return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to update the default annotation of "+item, err)
+		}
+		changes = append(changes, fmt.Sprintf("Marked %s as default=%s", item, want))
+	}
+	if !foundDefault && !managed[defaultName] {
+		if !apply {
+			changes = append(changes, fmt.Sprintf("StorageClass %s: missing, it is the default class", defaultName))
+		} else {
+			utils.GetLogger().Printf("Warning: default StorageClass %s does not exist yet; it is marked default once its CSI driver creates it.", defaultName)
+		}
+	}
+	return changes, nil
+}
+
+// storageClassDrift returns the fields of a live StorageClass that differ from the desired one,
+// split into those that require recreating the class and those that can be updated.
+// storageClassDrift 返回实际 StorageClass 与期望值不同的字段，分为需要重建该类的字段和可以更新的字段。
+func storageClassDrift(live, desired *storagev1.StorageClass) (immutable, mutable []string) {
+	if live.Provisioner != desired.Provisioner {
+		immutable = append(immutable, fmt.Sprintf("provisioner is %s, want %s", live.Provisioner, desired.Provisioner))
+	}
+	if len(live.Parameters) != 0 || len(desired.Parameters) != 0 {
+		if !reflect.DeepEqual(live.Parameters, desired.Parameters) {
+			immutable = append(immutable, "parameters differ")
+		}
+	}
+	liveReclaim := corev1.PersistentVolumeReclaimDelete
+	if live.ReclaimPolicy != nil {
+		liveReclaim = *live.ReclaimPolicy
+	}
+	if liveReclaim != *desired.ReclaimPolicy {
+		immutable = append(immutable, fmt.Sprintf("reclaimPolicy is %s, want %s", liveReclaim, *desired.ReclaimPolicy))
+	}
+	liveBinding := storagev1.VolumeBindingImmediate
+	if live.VolumeBindingMode != nil {
+		liveBinding = *live.VolumeBindingMode
+	}
+	if liveBinding != *desired.VolumeBindingMode {
+		immutable = append(immutable, fmt.Sprintf("volumeBindingMode is %s, want %s", liveBinding, *desired.VolumeBindingMode))
+	}
+	liveExpansion := live.AllowVolumeExpansion != nil && *live.AllowVolumeExpansion
+	if liveExpansion != *desired.AllowVolumeExpansion {
+		mutable = append(mutable, fmt.Sprintf("allowVolumeExpansion is %t, want %t", liveExpansion, *desired.AllowVolumeExpansion))
+	}
+	liveDefault := live.Annotations[defaultClassAnnotation] == "true"
+	wantDefault := desired.Annotations[defaultClassAnnotation] == "true"
+	if liveDefault != wantDefault {
+		mutable = append(mutable, fmt.Sprintf("default is %t, want %t", liveDefault, wantDefault))
+	}
+	return immutable, mutable
+}
+
+// reconcilePersistentVolumes creates the missing static PersistentVolumes and updates the mutable fields of the others.
+// A changed source or node cannot be applied to an existing volume and is only reported.
+// reconcilePersistentVolumes 创建缺失的静态 PersistentVolume 并更新其他卷的可变字段。
+// 已有卷的源或节点更改无法应用，仅会被报告。
+func reconcilePersistentVolumes(ctx context.Context, client kubernetes.Interface, storage *types.StorageConfig, apply bool) ([]string, error) {
+	if len(storage.PVConfigs) == 0 {
+		return nil, nil
+	}
+	var liveNodes []corev1.Node
+	for _, pvCfg := range storage.PVConfigs {
+		if pvCfg.Node != "" {
+			list, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, errors.NewWithCause(errors.ErrTypeSystem, "failed to list the cluster nodes", err)
+			}
+			liveNodes = list.Items
+			break
+		}
+	}
+
+	pvClient := client.CoreV1().PersistentVolumes()
+	var changes []string
+	for _, pvCfg := range storage.PVConfigs {
+		item := "PersistentVolume " + pvCfg.Name
+		hostname := ""
+		if pvCfg.Node != "" {
+			node := MatchNode(pvCfg.Node, liveNodes)
+			if node == nil {
+				if !apply {
+					changes = append(changes, fmt.Sprintf("%s: node %s is not registered", item, pvCfg.Node))
+					continue
+				}
+				return changes, errors.New(errors.ErrTypeNotFound, fmt.Sprintf("node %s of %s is not registered in the cluster", pvCfg.Node, item))
+			}
+			hostname = node.Labels[hostnameLabel]
+			if hostname == "" {
+				hostname = node.Name
+			}
+		}
+		desired, err := persistentVolumeObject(pvCfg, hostname)
+		if err != nil {
+			return changes, err
+		}
+
+		live, err := pvClient.Get(ctx, pvCfg.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			if !apply {
+				changes = append(changes, item+": missing")
+				continue
+			}
+			if _, err := pvClient.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+				return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to create "+item, err)
+			}
+			changes = append(changes, "Created "+item)
+			continue
+		}
+		if err != nil {
+			return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to read "+item, err)
+		}
+
+		immutable, mutable := persistentVolumeDrift(live, desired)
+		if len(immutable) > 0 {
+			if !apply {
+				changes = append(changes, fmt.Sprintf("%s: %s", item, strings.Join(immutable, ", ")))
+			} else {
+				utils.GetLogger().Printf("Warning: %s cannot be changed in place (%s); delete it to recreate it.", item, strings.Join(immutable, ", "))
+			}
+		}
+		if len(mutable) == 0 {
+			continue
+		}
+		if !apply {
+			changes = append(changes, fmt.Sprintf("%s: %s", item, strings.Join(mutable, ", ")))
+			continue
+		}
+		updated := live.DeepCopy()
+		updated.Spec.Capacity = desired.Spec.Capacity
+		updated.Spec.AccessModes = desired.Spec.AccessModes
+		updated.Spec.PersistentVolumeReclaimPolicy = desired.Spec.PersistentVolumeReclaimPolicy
+		updated.Spec.StorageClassName = desired.Spec.StorageClassName
+		if _, err := pvClient.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+			return changes, errors.NewWithCause(errors.ErrTypeSystem, "failed to update "+item, err)
+		}
+		changes = append(changes, fmt.Sprintf("Updated %s (%s)", item, strings.Join(mutable, ", ")))
+	}
+	return changes, nil
+}
+
+// persistentVolumeObject builds the PersistentVolume of a PV configuration; hostname pins it to a node when set.
+// persistentVolumeObject 根据 PV 配置构建 PersistentVolume；设置 hostname 时将其固定到节点。
+func persistentVolumeObject(pvCfg types.PVConfig, hostname string) (*corev1.PersistentVolume, error) {
+	capacity, err := resource.ParseQuantity(pvCfg.Capacity)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("invalid capacity %q of PersistentVolume %s", pvCfg.Capacity, pvCfg.Name), err)
+	}
+	hostPath := pvCfg.PersistentVolumeSource.HostPath
+	if hostPath == nil {
+		return nil, errors.New(errors.ErrTypeConfig, fmt.Sprintf("PersistentVolume %s has no hostPath source", pvCfg.Name))
+	}
+	reclaim := corev1.PersistentVolumeReclaimRetain
+	if pvCfg.ReclaimPolicy != "" {
+		reclaim = corev1.PersistentVolumeReclaimPolicy(pvCfg.ReclaimPolicy)
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   pvCfg.Name,
+			Labels: map[string]string{managedByLabel: fieldManager},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: capacity},
+			PersistentVolumeReclaimPolicy: reclaim,
+			StorageClassName:              pvCfg.StorageClassName,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: hostPath.Path},
+			},
+		},
+	}
+	for _, mode := range pvCfg.AccessModes {
+		pv.Spec.AccessModes = append(pv.Spec.AccessModes, corev1.PersistentVolumeAccessMode(mode))
+	}
+	if hostPath.Type != "" {
+		pathType := corev1.HostPathType(hostPath.Type)
+		pv.Spec.HostPath.Type = &pathType
+	}
+	if hostname != "" {
+		pv.Spec.NodeAffinity = &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{{
+				Key:      hostnameLabel,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{hostname},
+			}}}},
+		}}
+	}
+	return pv, nil
+}
+
+// persistentVolumeDrift returns the fields of a live PersistentVolume that differ from the desired one,
+// split into those that cannot change and those that can be updated.
+// persistentVolumeDrift 返回实际 PersistentVolume 与期望值不同的字段，分为不可更改的字段和可以更新的字段。
+func persistentVolumeDrift(live, desired *corev1.PersistentVolume) (immutable, mutable []string) {
+	if !reflect.DeepEqual(live.Spec.PersistentVolumeSource, desired.Spec.PersistentVolumeSource) {
+		immutable = append(immutable, "source differs")
+	}
+	if !reflect.DeepEqual(live.Spec.NodeAffinity, desired.Spec.NodeAffinity) {
+		immutable = append(immutable, "node affinity differs")
+	}
+	liveCapacity := live.Spec.Capacity[corev1.ResourceStorage]
+	desiredCapacity := desired.Spec.Capacity[corev1.ResourceStorage]
+	if liveCapacity.Cmp(desiredCapacity) != 0 {
+		mutable = append(mutable, fmt.Sprintf("capacity is %s, want %s", liveCapacity.String(), desiredCapacity.String()))
+	}
+	if !reflect.DeepEqual(live.Spec.AccessModes, desired.Spec.AccessModes) {
+		mutable = append(mutable, fmt.Sprintf("accessModes are %v, want %v", live.Spec.AccessModes, desired.Spec.AccessModes))
+	}
+	if live.Spec.PersistentVolumeReclaimPolicy != desired.Spec.PersistentVolumeReclaimPolicy {
+		mutable = append(mutable, fmt.Sprintf("reclaimPolicy is %s, want %s", live.Spec.PersistentVolumeReclaimPolicy, desired.Spec.PersistentVolumeReclaimPolicy))
+	}
+	if live.Spec.StorageClassName != desired.Spec.StorageClassName {
+		mutable = append(mutable, fmt.Sprintf("storageClassName is %q, want %q", live.Spec.StorageClassName, desired.Spec.StorageClassName))
+	}
+	return immutable, mutable
+}
+
+// typedObject is a cluster object applied with server-side apply through the typed client.
+// typedObject 是通过类型化客户端以服务端应用方式应用的集群对象。
+type typedObject struct {
+	// Item names the object in reports, e.g. "Deployment local-path-storage/local-path-provisioner".
+	// Item 在报告中命名该对象，例如 "Deployment local-path-storage/local-path-provisioner"。
+	Item string
+	// Object is the desired object, with its apiVersion and kind set.
+	// Object 是期望的对象，已设置其 apiVersion 和 kind。
+	Object interface{}
+	// Get reads the live object and Patch applies the desired one; both return its resourceVersion.
+	// Get 读取实际对象，Patch 应用期望对象；两者都返回其 resourceVersion。
+	Get   func(ctx context.Context) (string, error)
+	Patch func(ctx context.Context, data []byte) (string, error)
+}
+
+// reconcileLocalPath applies the local-path provisioner objects or, with apply unset, reports whether it is deployed and ready.
+// reconcileLocalPath 应用 local-path provisioner 对象；apply 为 false 时报告其是否已部署并就绪。
+func reconcileLocalPath(ctx context.Context, client kubernetes.Interface, clusterCfg *model.ClusterConfig, apply bool) ([]string, error) {
+	item := fmt.Sprintf("Deployment %s/%s", localPathNamespace, localPathName)
+	if !apply {
+		deployment, err := client.AppsV1().Deployments(localPathNamespace).Get(ctx, localPathName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return []string{item + ": missing"}, nil
+		}
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeSystem, "failed to read "+item, err)
+		}
+		if deployment.Status.ReadyReplicas < 1 {
+			return []string{fmt.Sprintf("%s: not ready (%d/%d)", item, deployment.Status.ReadyReplicas, deployment.Status.Replicas)}, nil
+		}
+		return nil, nil
+	}
+
+	objects, err := localPathObjects(client, clusterCfg)
+	if err != nil {
+		return nil, err
+	}
+	var changes []string
+	for _, obj := range objects {
+		changed, err := applyTypedObject(ctx, obj)
+		if err != nil {
+			return changes, err
+		}
+		if changed {
+			changes = append(changes, "Applied "+obj.Item)
+		}
+	}
+	return changes, nil
+}
+
+// applyTypedObject applies an object with server-side apply and reports whether it was created or changed.
+// applyTypedObject 以服务端应用方式应用对象，并报告其是否被创建或更改。
+func applyTypedObject(ctx context.Context, obj typedObject) (bool, error) {
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeInternal, "failed to encode "+obj.Item, err)
+	}
+	before, err := obj.Get(ctx)
+	existed := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, errors.NewWithCause(errors.ErrTypeSystem, "failed to read "+obj.Item, err)
+	}
+	after, err := obj.Patch(ctx, data)
+	if err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeSystem, "failed to apply "+obj.Item, err)
+	}
+	return !existed || before != after, nil
+}
+
+// localPathObjects returns the objects of the local-path provisioner, with its images taken from the platform artifact when the install is air-gapped.
+// localPathObjects 返回 local-path provisioner 的对象；离线安装时其镜像取自平台制品。
+func localPathObjects(client kubernetes.Interface, clusterCfg *model.ClusterConfig) ([]typedObject, error) {
+	image, helperImage := defaultLocalPathImage, localPathHelperImage
+	if custom := clusterCfg.Storage.LocalPathProvisioner.Image; custom != "" {
+		image = custom
+	}
+	if clusterCfg.ArtifactDir != "" {
+		images, err := loadArtifactImages(clusterCfg.ArtifactDir)
+		if err != nil {
+			return nil, err
+		}
+		var missing []string
+		for _, ref := range []*string{&image, &helperImage} {
+			if replacement := artifactImage(*ref, images); replacement != "" {
+				*ref = replacement
+			} else {
+				missing = append(missing, *ref)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return nil, errors.New(errors.ErrTypeNotFound, "images missing from the platform artifact: "+strings.Join(missing, ", "))
+		}
+	}
+
+	labels := map[string]string{"app": localPathName, managedByLabel: fieldManager}
+	meta := func(namespace, name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}
+	}
+	config, err := json.MarshalIndent(map[string]interface{}{
+		"nodePathMap": []map[string]interface{}{{
+			"node":  "DEFAULT_PATH_FOR_NON_LISTED_NODES",
+			"paths": []string{LocalPathDir(&clusterCfg.Storage)},
+		}},
+	}, "", "  ")
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeInternal, "failed to encode the local-path provisioner configuration", err)
+	}
+	replicas := int32(1)
+	opts := metav1.PatchOptions{FieldManager: fieldManager, Force: boolPtr(true)}
+	apply := k8stypes.ApplyPatchType
+
+	core, rbac, apps := client.CoreV1(), client.RbacV1(), client.AppsV1()
+	return []typedObject{
+		{
+			Item: "Namespace " + localPathNamespace,
+			Object: &corev1.Namespace{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+				ObjectMeta: meta("", localPathNamespace),
+			},
+			Get: func(ctx context.Context) (string, error) {
+				obj, err := core.Namespaces().Get(ctx, localPathNamespace, metav1.GetOptions{})
+				return resourceVersion(obj, err)
+			},
+			Patch: func(ctx context.Context, data []byte) (string, error) {
+				obj, err := core.Namespaces().Patch(ctx, localPathNamespace, apply, data, opts)
+				return resourceVersion(obj, err)
+			},
+		},
+		{
+			Item: fmt.Sprintf("ServiceAccount %s/%s", localPathNamespace, localPathName),
+			Object: &corev1.ServiceAccount{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+				ObjectMeta: meta(localPathNamespace, localPathName),
+			},
+			Get: func(ctx context.Context) (string, error) {
+				obj, err := core.ServiceAccounts(localPathNamespace).Get(ctx, localPathName, metav1.GetOptions{})
+				return resourceVersion(obj, err)
+			},
+			Patch: func(ctx context.Context, data []byte) (string, error) {
+				obj, err := core.ServiceAccounts(localPathNamespace).Patch(ctx, localPathName, apply, data, opts)
+				return resourceVersion(obj, err)
+			},
+		},
+		{
+			Item: "ClusterRole " + localPathName,
+			Object: &rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+				ObjectMeta: meta("", localPathName),
+				Rules: []rbacv1.PolicyRule{
+					{APIGroups: []string{""}, Resources: []string{"nodes", "persistentvolumeclaims", "configmaps", "pods/log"}, Verbs: []string{"get", "list", "watch"}},
+					{APIGroups: []string{""}, Resources: []string{"pods", "persistentvolumes"}, Verbs: []string{"get", "list", "watch", "create", "patch", "update", "delete"}},
+					{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+					{APIGroups: []string{"storage.k8s.io"}, Resources: []string{"storageclasses"}, Verbs: []string{"get", "list", "watch"}},
+				},
+			},
+			Get: func(ctx context.Context) (string, error) {
+				obj, err := rbac.ClusterRoles().Get(ctx, localPathName, metav1.GetOptions{})
+				return resourceVersion(obj, err)
+			},
+			Patch: func(ctx context.Context, data []byte) (string, error) {
+				obj, err := rbac.ClusterRoles().Patch(ctx, localPathName, apply, data, opts)
+				return resourceVersion(obj, err)
+			},
+		},
+		{
+			Item: "ClusterRoleBinding " + localPathName,
+			Object: &rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+				ObjectMeta: meta("", localPathName),
+				RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: localPathName},
+				Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: localPathName, Namespace: localPathNamespace}},
+			},
+			Get: func(ctx context.Context) (string, error) {
+				obj, err := rbac.ClusterRoleBindings().Get(ctx, localPathName, metav1.GetOptions{})
+				return resourceVersion(obj, err)
+			},
+			Patch: func(ctx context.Context, data []byte) (string, error) {
+				obj, err := rbac.ClusterRoleBindings().Patch(ctx, localPathName, apply, data, opts)
+				return resourceVersion(obj, err)
+			},
+		},
+		{
+			Item: fmt.Sprintf("ConfigMap %s/%s", localPathNamespace, localPathConfigMap),
+			Object: &corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: meta(localPathNamespace, localPathConfigMap),
+				Data: map[string]string{
+					"config.json":    string(config) + "\n",
+					"setup":          "#!/bin/sh\nset -eu\nmkdir -m 0777 -p \"$VOL_DIR\"\n",
+					"teardown":       "#!/bin/sh\nset -eu\nrm -rf \"$VOL_DIR\"\n",
+					"helperPod.yaml": localPathHelperPod(helperImage),
+				},
+			},
+			Get: func(ctx context.Context) (string, error) {
+				obj, err := core.ConfigMaps(localPathNamespace).Get(ctx, localPathConfigMap, metav1.GetOptions{})
+				return resourceVersion(obj, err)
+			},
+			Patch: func(ctx context.Context, data []byte) (string, error) {
+				obj, err := core.ConfigMaps(localPathNamespace).Patch(ctx, localPathConfigMap, apply, data, opts)
+				return resourceVersion(obj, err)
+			},
+		},
+		{
+			Item: fmt.Sprintf("Deployment %s/%s", localPathNamespace, localPathName),
+			Object: &appsv1.Deployment{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				ObjectMeta: meta(localPathNamespace, localPathName),
+				Spec: appsv1.DeploymentSpec{
+					Replicas: &replicas,
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": localPathName}},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": localPathName}},
+						Spec: corev1.PodSpec{
+							ServiceAccountName: localPathName,
+							// Single-node and control-plane-only clusters have no untainted node.
+							// 单节点和仅控制平面的集群没有未设置污点的节点。
+							Tolerations: []corev1.Toleration{{Key: "node-role.kubernetes.io/control-plane", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+							Containers: []corev1.Container{{
+								Name:            localPathName,
+								Image:           image,
+								ImagePullPolicy: corev1.PullIfNotPresent,
+								Command:         []string{"local-path-provisioner", "--debug", "start", "--config", "/etc/config/config.json"},
+								Env: []corev1.EnvVar{
+									{Name: "POD_NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+									{Name: "CONFIG_MOUNT_PATH", Value: "/etc/config/"},
+								},
+								VolumeMounts: []corev1.VolumeMount{{Name: "config-volume", MountPath: "/etc/config/"}},
+							}},
+							Volumes: []corev1.Volume{{
+								Name: "config-volume",
+								VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{Name: localPathConfigMap},
+								}},
+							}},
+						},
+					},
+				},
+			},
+			Get: func(ctx context.Context) (string, error) {
+				obj, err := apps.Deployments(localPathNamespace).Get(ctx, localPathName, metav1.GetOptions{})
+				return resourceVersion(obj, err)
+			},
+			Patch: func(ctx context.Context, data []byte) (string, error) {
+				obj, err := apps.Deployments(localPathNamespace).Patch(ctx, localPathName, apply, data, opts)
+				return resourceVersion(obj, err)
+			},
+		},
+	}, nil
+}
+
+// localPathHelperPod returns the template of the helper pods that create and remove the volume directories.
+// localPathHelperPod 返回创建和删除卷目录的辅助 Pod 模板。
+func localPathHelperPod(image string) string {
+	return `apiVersion: v1
+kind: Pod
+metadata:
+  name: helper-pod
+spec:
+  priorityClassName: system-node-critical
+  tolerations:
+    - key: node.kubernetes.io/disk-pressure
+      operator: Exists
+      effect: NoSchedule
+  containers:
+    - name: helper-pod
+      image: ` + image + `
+      imagePullPolicy: IfNotPresent
+`
+}
+
+// resourceVersion returns the resourceVersion of an object read or written through a typed client.
+// resourceVersion 返回通过类型化客户端读取或写入的对象的 resourceVersion。
+func resourceVersion(obj metav1.Object, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return obj.GetResourceVersion(), nil
+}
+
+// mergeStrings returns base with the entries of overlay set.
+// mergeStrings 返回设置了 overlay 中条目的 base。
+func mergeStrings(base, overlay map[string]string) map[string]string {
+	if base == nil {
+		base = map[string]string{}
+	}
+	for key, value := range overlay {
+		base[key] = value
+	}
+	return base
+}
+
+// boolPtr returns a pointer to b.
+// boolPtr 返回指向 b 的指针。
+func boolPtr(b bool) *bool {
+	return &b
+}
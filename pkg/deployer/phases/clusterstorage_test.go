@@ -0,0 +1,217 @@
+package phases
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// testStorageCluster returns a cluster with a static class, a HostPath PV pinned to the worker and the local-path provisioner.
+// testStorageCluster 返回一个带静态类、固定到工作节点的 HostPath PV 以及 local-path provisioner 的集群。
+func testStorageCluster() *model.ClusterConfig {
+	clusterCfg := testHACluster()
+	clusterCfg.Storage = types.StorageConfig{
+		StorageClasses: []types.StorageClassConfig{{Name: "manual", Provisioner: noProvisioner, VolumeBindingMode: "WaitForFirstConsumer"}},
+		PVConfigs: []types.PVConfig{{
+			Name:             "data",
+			Capacity:         "10Gi",
+			AccessModes:      []string{"ReadWriteOnce"},
+			StorageClassName: "manual",
+			Node:             "10.0.0.3",
+			PersistentVolumeSource: types.PersistentVolumeSource{
+				HostPath: &types.HostPathVolumeSource{Path: "/data/pv", Type: "DirectoryOrCreate"},
+			},
+		}},
+		LocalPathProvisioner: &types.LocalPathProvisionerConfig{Enabled: true},
+	}
+	return clusterCfg
+}
+
+// testStorageClientset returns a cluster with the worker registered and an existing default StorageClass.
+// testStorageClientset 返回一个已注册工作节点并已有默认 StorageClass 的集群。
+func testStorageClientset() *fake.Clientset {
+	utils.InitLogger("info", 0)
+	return fake.NewClientset(
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{hostnameLabel: "worker-1.example.com"}},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.3"}}},
+		},
+		&storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: "standard", Annotations: map[string]string{defaultClassAnnotation: "true"}},
+			Provisioner: "example.com/legacy",
+		},
+	)
+}
+
+func TestReconcileStorage(t *testing.T) {
+	ctx := context.Background()
+	client := testStorageClientset()
+	clusterCfg := testStorageCluster()
+
+	drift, err := StorageDrift(ctx, client, clusterCfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Deployment local-path-storage/local-path-provisioner: missing",
+		"StorageClass manual: missing",
+		"StorageClass local-path: missing",
+		"StorageClass standard: default is true, want false",
+		"PersistentVolume data: missing",
+	}, drift)
+
+	changes, err := ReconcileStorage(ctx, client, clusterCfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Applied Namespace local-path-storage",
+		"Applied ServiceAccount local-path-storage/local-path-provisioner",
+		"Applied ClusterRole local-path-provisioner",
+		"Applied ClusterRoleBinding local-path-provisioner",
+		"Applied ConfigMap local-path-storage/local-path-config",
+		"Applied Deployment local-path-storage/local-path-provisioner",
+		"Created StorageClass manual",
+		"Created StorageClass local-path",
+		"Marked StorageClass standard as default=false",
+		"Created PersistentVolume data",
+	}, changes)
+
+	localPath, err := client.StorageV1().StorageClasses().Get(ctx, LocalPathClassName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "true", localPath.Annotations[defaultClassAnnotation], "local-path is the default when none is declared")
+	assert.Equal(t, storagev1.VolumeBindingWaitForFirstConsumer, *localPath.VolumeBindingMode)
+	manual, err := client.StorageV1().StorageClasses().Get(ctx, "manual", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "false", manual.Annotations[defaultClassAnnotation])
+
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, "data", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, corev1.PersistentVolumeReclaimRetain, pv.Spec.PersistentVolumeReclaimPolicy)
+	assert.Equal(t, corev1.HostPathDirectoryOrCreate, *pv.Spec.HostPath.Type)
+	assert.Equal(t, []string{"worker-1.example.com"}, pv.Spec.NodeAffinity.Required.NodeSelectorTerms[0].MatchExpressions[0].Values)
+
+	deployment, err := client.AppsV1().Deployments(localPathNamespace).Get(ctx, localPathName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultLocalPathImage, deployment.Spec.Template.Spec.Containers[0].Image)
+	config, err := client.CoreV1().ConfigMaps(localPathNamespace).Get(ctx, localPathConfigMap, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, config.Data["config.json"], `"/opt/local-path-provisioner"`)
+	assert.Contains(t, config.Data["helperPod.yaml"], "image: "+localPathHelperImage)
+
+	// A second run changes nothing; a ready provisioner leaves no drift.
+	// 第二次运行不做任何更改；就绪的 provisioner 不会产生偏差。
+	changes, err = ReconcileStorage(ctx, client, clusterCfg)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	drift, err = StorageDrift(ctx, client, clusterCfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Deployment local-path-storage/local-path-provisioner: not ready (0/0)"}, drift)
+	deployment.Status = appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1}
+	_, err = client.AppsV1().Deployments(localPathNamespace).UpdateStatus(ctx, deployment, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	drift, err = StorageDrift(ctx, client, clusterCfg)
+	require.NoError(t, err)
+	assert.Empty(t, drift)
+}
+
+func TestReconcileStorage_Drift(t *testing.T) {
+	ctx := context.Background()
+	client := testStorageClientset()
+	clusterCfg := testStorageCluster()
+	clusterCfg.Storage.LocalPathProvisioner = nil
+	clusterCfg.Storage.DefaultStorageClass = "manual"
+	_, err := ReconcileStorage(ctx, client, clusterCfg)
+	require.NoError(t, err)
+
+	// Immutable class fields recreate the class; PV capacity is updated in place, its source is only reported.
+	// 不可变的类字段会重建该类；PV 容量原地更新，其源仅会被报告。
+	clusterCfg.Storage.StorageClasses[0].ReclaimPolicy = "Retain"
+	clusterCfg.Storage.StorageClasses[0].AllowVolumeExpansion = true
+	clusterCfg.Storage.PVConfigs[0].Capacity = "20Gi"
+	clusterCfg.Storage.PVConfigs[0].PersistentVolumeSource.HostPath.Path = "/data/other"
+	drift, err := StorageDrift(ctx, client, clusterCfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"StorageClass manual: reclaimPolicy is Delete, want Retain, allowVolumeExpansion is false, want true",
+		"PersistentVolume data: source differs",
+		"PersistentVolume data: capacity is 10Gi, want 20Gi",
+	}, drift)
+
+	changes, err := ReconcileStorage(ctx, client, clusterCfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Recreated StorageClass manual (reclaimPolicy is Delete, want Retain)",
+		"Updated PersistentVolume data (capacity is 10Gi, want 20Gi)",
+	}, changes)
+	manual, err := client.StorageV1().StorageClasses().Get(ctx, "manual", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, *manual.AllowVolumeExpansion)
+	assert.Equal(t, "true", manual.Annotations[defaultClassAnnotation])
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, "data", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, resource.MustParse("20Gi"), pv.Spec.Capacity[corev1.ResourceStorage])
+	assert.Equal(t, "/data/pv", pv.Spec.HostPath.Path, "the source of an existing volume is never changed")
+
+	clusterCfg.Storage.PVConfigs[0].Node = "10.0.0.9"
+	_, err = ReconcileStorage(ctx, client, clusterCfg)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeNotFound))
+}
+
+func TestReconcileStorage_AirGapped(t *testing.T) {
+	artifactDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(artifactDir, ArtifactImagesFile),
+		[]byte("registry.local/rancher/local-path-provisioner:v0.0.30\nregistry.local/library/busybox:1.36\n"), 0644))
+	clusterCfg := testStorageCluster()
+	clusterCfg.ArtifactDir = artifactDir
+	client := testStorageClientset()
+	_, err := ReconcileStorage(context.Background(), client, clusterCfg)
+	require.NoError(t, err)
+	deployment, err := client.AppsV1().Deployments(localPathNamespace).Get(context.Background(), localPathName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "registry.local/rancher/local-path-provisioner:v0.0.30", deployment.Spec.Template.Spec.Containers[0].Image)
+
+	clusterCfg.Storage.LocalPathProvisioner.Image = "docker.io/example/local-path-provisioner:v1.0.0"
+	_, err = ReconcileStorage(context.Background(), testStorageClientset(), clusterCfg)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeNotFound))
+}
+
+func TestStorageConfigPhase_Plan(t *testing.T) {
+	clusterCfg := testStorageCluster()
+	clusterCfg.Storage.PVConfigs = append(clusterCfg.Storage.PVConfigs, types.PVConfig{
+		Name: "socket",
+		PersistentVolumeSource: types.PersistentVolumeSource{
+			HostPath: &types.HostPathVolumeSource{Path: "/run/app.sock", Type: "Socket"},
+		},
+	})
+	phase := NewStorageConfigPhase(nil)
+
+	actions, err := phase.Plan(context.Background(), &clusterCfg.Nodes[2], clusterCfg)
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	assert.Equal(t, "mkdir -p /data/pv", actions[0].Command)
+	assert.Equal(t, "test -d /data/pv", actions[0].Check)
+	assert.Equal(t, "mkdir -p /opt/local-path-provisioner", actions[1].Command)
+
+	actions, err = phase.Plan(context.Background(), &clusterCfg.Nodes[0], clusterCfg)
+	require.NoError(t, err)
+	require.Len(t, actions, 1, "the PV is pinned to the worker")
+
+	// A declared CSI driver replaces the local-path provisioner.
+	// 声明的 CSI 驱动会取代 local-path provisioner。
+	clusterCfg.Storage.StorageClasses = append(clusterCfg.Storage.StorageClasses, types.StorageClassConfig{Name: "ceph", Provisioner: "rbd.csi.ceph.com"})
+	actions, err = phase.Plan(context.Background(), &clusterCfg.Nodes[0], clusterCfg)
+	require.NoError(t, err)
+	assert.Empty(t, actions)
+}
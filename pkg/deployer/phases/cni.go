@@ -0,0 +1,800 @@
+package phases
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+const (
+	// ArtifactCNIDir is the directory of the platform artifact holding the CNI manifests,
+	// named <plugin>.yaml, or cilium.tgz for the Cilium Helm chart.
+	// ArtifactCNIDir 是平台制品中存放 CNI 清单的目录，文件名为 <plugin>.yaml，Cilium Helm chart 为 cilium.tgz。
+	ArtifactCNIDir = "cni"
+	// ArtifactImagesFile lists the image references of the platform artifact, one per line.
+	// ArtifactImagesFile 列出平台制品的镜像引用，每行一个。
+	ArtifactImagesFile = "images.txt"
+
+	// fieldManager owns the fields chasi-bod applies with server-side apply.
+	// fieldManager 拥有 chasi-bod 通过服务端应用写入的字段。
+	fieldManager = "chasi-bod"
+)
+
+var (
+	// cniReadyTimeout bounds the wait for the CNI DaemonSet and for every node to become Ready.
+	// cniReadyTimeout 限制等待 CNI DaemonSet 以及所有节点就绪的时间。
+	cniReadyTimeout = 10 * time.Minute
+	// cniPollInterval is how often the CNI DaemonSet and the nodes are checked.
+	// cniPollInterval 是检查 CNI DaemonSet 和节点的间隔。
+	cniPollInterval = 5 * time.Second
+)
+
+// cniOptions are the cluster settings a CNI plugin is rendered from.
+// cniOptions 是渲染 CNI 插件所依据的集群设置。
+type cniOptions struct {
+	PodCIDRs          []string // Pod network CIDRs, at most one per address family / Pod 网络 CIDR，每个地址族最多一个
+	MTU               int      // Pod network MTU; 0 lets the plugin detect it / Pod 网络 MTU；0 表示由插件自动检测
+	Interface         string   // Host interface carrying pod traffic; empty lets the plugin detect it / 承载 Pod 流量的主机接口；为空时由插件自动检测
+	KubernetesVersion string   // Kubernetes version the manifests are rendered for / 清单所针对的 Kubernetes 版本
+	Nodes             int      // Number of nodes in the cluster / 集群中的节点数
+}
+
+// cniInstaller describes a built-in CNI plugin: where its manifests come from, how they are rendered and what to wait for.
+// cniInstaller 描述一个内置 CNI 插件：其清单的来源、渲染方式以及需要等待的对象。
+type cniInstaller struct {
+	Source    string // File name of the manifest or chart in the platform artifact / 清单或 chart 在平台制品中的文件名
+	URL       string // Pinned upstream release / 固定的上游版本
+	Overhead  int    // Encapsulation overhead subtracted from the interface MTU / 从接口 MTU 中减去的封装开销
+	Namespace string // Namespace of the DaemonSet / DaemonSet 的命名空间
+	DaemonSet string // DaemonSet running the plugin on every node / 在每个节点上运行插件的 DaemonSet
+	render    func(ctx context.Context, src []byte, opts cniOptions) ([]*unstructured.Unstructured, error)
+}
+
+// cniInstallers are the built-in CNI plugins, by network.plugin.
+// cniInstallers 是按 network.plugin 索引的内置 CNI 插件。
+var cniInstallers = map[string]cniInstaller{
+	"calico": {
+		Source:    "calico.yaml",
+		URL:       "https://raw.githubusercontent.com/projectcalico/calico/v3.28.2/manifests/calico.yaml",
+		Overhead:  20, // IP-in-IP
+		Namespace: "kube-system",
+		DaemonSet: "calico-node",
+		render:    renderCalico,
+	},
+	"cilium": {
+		Source:    "cilium.tgz",
+		URL:       "https://helm.cilium.io/cilium-1.16.3.tgz",
+		Overhead:  50, // VXLAN
+		Namespace: "kube-system",
+		DaemonSet: "cilium",
+		render:    renderCilium,
+	},
+	"flannel": {
+		Source:    "flannel.yaml",
+		URL:       "https://github.com/flannel-io/flannel/releases/download/v0.26.1/kube-flannel.yml",
+		Overhead:  50, // VXLAN
+		Namespace: "kube-flannel",
+		DaemonSet: "kube-flannel-ds",
+		render:    renderFlannel,
+	},
+}
+
+// lookupCNIInstaller returns the built-in installer of a CNI plugin.
+// lookupCNIInstaller 返回 CNI 插件的内置安装器。
+func lookupCNIInstaller(plugin string) (cniInstaller, bool) {
+	installer, ok := cniInstallers[strings.ToLower(plugin)]
+	return installer, ok
+}
+
+// clusterClients are the clients the installation phase uses to reach the Host Cluster.
+// clusterClients 是安装阶段用于访问 Host 集群的客户端。
+type clusterClients struct {
+	Kube    kubernetes.Interface
+	Dynamic dynamic.Interface
+	Mapper  meta.RESTMapper
+}
+
+// clusterClientFactory builds the clients of the Host Cluster from its admin kubeconfig.
+// clusterClientFactory 根据管理员 kubeconfig 构建 Host 集群的客户端。
+type clusterClientFactory func(kubeconfigPath string) (*clusterClients, error)
+
+// kubeconfigClients is the clusterClientFactory used outside tests.
+// kubeconfigClients 是测试之外使用的 clusterClientFactory。
+func kubeconfigClients(kubeconfigPath string) (*clusterClients, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("failed to load the Host Cluster kubeconfig %s", kubeconfigPath), err)
+	}
+	kube, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, "failed to create the Host Cluster client", err)
+	}
+	dyn, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, "failed to create the Host Cluster client", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(kube.Discovery()))
+	return &clusterClients{Kube: kube, Dynamic: dyn, Mapper: mapper}, nil
+}
+
+// clusterClients connects to the Host Cluster through the exported administrator kubeconfig.
+// clusterClients 通过导出的管理员 kubeconfig 连接到 Host 集群。
+func (p *defaultK8sInstallPhase) clusterClients(clusterCfg *model.ClusterConfig) (*clusterClients, error) {
+	newClients := p.newClients
+	if newClients == nil {
+		newClients = kubeconfigClients
+	}
+	return newClients(KubeconfigPath(p.kubeconfigDir, clusterCfg.Name))
+}
+
+// installCNI deploys the built-in CNI plugin selected by network.plugin and waits for it and every node to be Ready.
+// installCNI 部署 network.plugin 选择的内置 CNI 插件，并等待其及所有节点就绪。
+// Plugins that are not built in are left to the operator.
+// 非内置的插件由运维人员自行部署。
+func (p *defaultK8sInstallPhase) installCNI(ctx context.Context, nodes []model.NodeConfig, clusterCfg *model.ClusterConfig) error {
+	plugin := clusterCfg.Network.Plugin
+	installer, ok := lookupCNIInstaller(plugin)
+	if !ok {
+		utils.GetLogger().Printf("CNI plugin %q is not built in, skipping its deployment.", plugin)
+		return nil
+	}
+	utils.GetLogger().Printf("Deploying CNI plugin %s...", plugin)
+	objects, err := renderCNI(ctx, installer, clusterCfg)
+	if err != nil {
+		return err
+	}
+
+	clients, err := p.clusterClients(clusterCfg)
+	if err != nil {
+		return err
+	}
+	ctx = plan.WithTarget(ctx, plan.ClusterTarget)
+	for _, obj := range objects {
+		item := "Apply " + obj.GetKind() + " " + objectName(obj)
+		changed, err := applyObject(ctx, clients, obj, installer.Namespace)
+		switch {
+		case err != nil:
+			plan.Record(ctx, item, plan.StatusFailed, err)
+			return err
+		case changed:
+			plan.Record(ctx, item, plan.StatusChanged, nil)
+		default:
+			plan.Record(ctx, item, plan.StatusUnchanged, nil)
+		}
+	}
+	utils.GetLogger().Printf("CNI plugin %s applied, waiting for DaemonSet %s/%s.", plugin, installer.Namespace, installer.DaemonSet)
+
+	if err := waitForDaemonSet(ctx, clients.Kube, installer.Namespace, installer.DaemonSet); err != nil {
+		return err
+	}
+	utils.GetLogger().Println("Waiting for all nodes to become Ready...")
+	if err := waitForNodesReady(ctx, clients.Kube, nodes); err != nil {
+		return err
+	}
+	utils.GetLogger().Println("All nodes are Ready.")
+	return nil
+}
+
+// renderCNI loads and renders the manifests of a CNI plugin, taking image references from the platform artifact if there is one.
+// renderCNI 加载并渲染 CNI 插件的清单，如果存在平台制品则从中获取镜像引用。
+func renderCNI(ctx context.Context, installer cniInstaller, clusterCfg *model.ClusterConfig) ([]*unstructured.Unstructured, error) {
+	src, err := loadCNISource(ctx, installer, clusterCfg)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := installer.render(ctx, src, newCNIOptions(installer, clusterCfg))
+	if err != nil {
+		return nil, err
+	}
+	if clusterCfg.ArtifactDir == "" {
+		return objects, nil
+	}
+	images, err := loadArtifactImages(clusterCfg.ArtifactDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := rewriteImages(objects, images); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// newCNIOptions derives the rendering options of a plugin from the cluster configuration.
+// newCNIOptions 根据集群配置推导插件的渲染选项。
+// Without network.mtu the pod MTU is the MTU of the pod interface minus the plugin's encapsulation overhead.
+// 未设置 network.mtu 时，Pod MTU 为 Pod 接口的 MTU 减去插件的封装开销。
+func newCNIOptions(installer cniInstaller, clusterCfg *model.ClusterConfig) cniOptions {
+	network := clusterCfg.Network
+	opts := cniOptions{
+		MTU:               network.MTU,
+		Interface:         network.Interface,
+		KubernetesVersion: clusterCfg.KubernetesVersion,
+		Nodes:             len(clusterCfg.Nodes),
+	}
+	for _, cidr := range strings.Split(network.PodCIDR, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			opts.PodCIDRs = append(opts.PodCIDRs, cidr)
+		}
+	}
+	if opts.Interface == "" && len(network.Interfaces) > 0 {
+		opts.Interface = network.Interfaces[0].Name
+	}
+	if opts.MTU == 0 {
+		for _, iface := range network.Interfaces {
+			if iface.Name == opts.Interface && iface.MTU > 0 {
+				opts.MTU = iface.MTU - installer.Overhead
+			}
+		}
+	}
+	return opts
+}
+
+// cniSourceLocation returns where the manifest or chart of a plugin is read from:
+// network.pluginManifest, then the platform artifact, then the pinned upstream release.
+// cniSourceLocation 返回读取插件清单或 chart 的位置：依次为 network.pluginManifest、平台制品、固定的上游版本。
+func cniSourceLocation(installer cniInstaller, clusterCfg *model.ClusterConfig) string {
+	switch {
+	case clusterCfg.Network.PluginManifest != "":
+		return clusterCfg.Network.PluginManifest
+	case clusterCfg.ArtifactDir != "":
+		return filepath.Join(clusterCfg.ArtifactDir, ArtifactCNIDir, installer.Source)
+	default:
+		return installer.URL
+	}
+}
+
+// loadCNISource reads the manifest or chart of a plugin from a local file or URL.
+// loadCNISource 从本地文件或 URL 读取插件的清单或 chart。
+func loadCNISource(ctx context.Context, installer cniInstaller, clusterCfg *model.ClusterConfig) ([]byte, error) {
+	location := cniSourceLocation(installer, clusterCfg)
+	if !strings.HasPrefix(location, "https://") && !strings.HasPrefix(location, "http://") {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read the CNI manifest %s", location), err)
+		}
+		return data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("invalid CNI manifest URL %s", location), err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to download the CNI manifest %s", location), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(errors.ErrTypeNetwork, fmt.Sprintf("failed to download the CNI manifest %s: %s", location, resp.Status))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to download the CNI manifest %s", location), err)
+	}
+	return data, nil
+}
+
+// decodeManifests splits a multi-document YAML or JSON manifest into objects, skipping empty documents.
+// decodeManifests 将多文档 YAML 或 JSON 清单拆分为对象，并跳过空文档。
+func decodeManifests(data []byte) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var doc map[string]interface{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			return objects, nil
+		} else if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeValidation, "failed to decode the CNI manifest", err)
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: doc}
+		if obj.GetKind() == "" || obj.GetName() == "" {
+			return nil, errors.New(errors.ErrTypeValidation, "the CNI manifest contains an object without kind or name")
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// renderCalico patches the upstream Calico manifest with the pod CIDRs, the MTU and the interface addresses are detected on.
+// renderCalico 使用 Pod CIDR、MTU 以及用于检测地址的接口修补上游 Calico 清单。
+func renderCalico(_ context.Context, src []byte, opts cniOptions) ([]*unstructured.Unstructured, error) {
+	objects, err := decodeManifests(src)
+	if err != nil {
+		return nil, err
+	}
+	config := findObject(objects, "ConfigMap", "calico-config")
+	node := findObject(objects, "DaemonSet", "calico-node")
+	if config == nil || node == nil {
+		return nil, errors.New(errors.ErrTypeValidation, "the Calico manifest has no calico-config ConfigMap or calico-node DaemonSet")
+	}
+
+	if opts.MTU > 0 {
+		if err := unstructured.SetNestedField(config.Object, strconv.Itoa(opts.MTU), "data", "veth_mtu"); err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeValidation, "failed to set the Calico MTU", err)
+		}
+	}
+	v4, v6 := splitFamilies(opts.PodCIDRs)
+	var env [][2]string
+	if len(v4) > 0 {
+		env = append(env, [2]string{"CALICO_IPV4POOL_CIDR", v4[0]})
+	}
+	if len(v6) > 0 {
+		env = append(env, [2]string{"CALICO_IPV6POOL_CIDR", v6[0]}, [2]string{"FELIX_IPV6SUPPORT", "true"}, [2]string{"IP6", "autodetect"})
+		if len(v4) == 0 {
+			env = append(env, [2]string{"IP", "none"})
+		}
+	}
+	if opts.Interface != "" {
+		method := "interface=" + regexp.QuoteMeta(opts.Interface)
+		env = append(env, [2]string{"IP_AUTODETECTION_METHOD", method})
+		if len(v6) > 0 {
+			env = append(env, [2]string{"IP6_AUTODETECTION_METHOD", method})
+		}
+	}
+	for _, variable := range env {
+		if err := setContainerEnv(node, "calico-node", variable[0], variable[1]); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// renderFlannel patches the upstream Flannel manifest with the pod CIDRs and the interface pod traffic uses.
+// renderFlannel 使用 Pod CIDR 以及 Pod 流量使用的接口修补上游 Flannel 清单。
+// Flannel derives its MTU from the interface, so the MTU is not set.
+// Flannel 根据接口推导 MTU，因此不设置 MTU。
+func renderFlannel(_ context.Context, src []byte, opts cniOptions) ([]*unstructured.Unstructured, error) {
+	objects, err := decodeManifests(src)
+	if err != nil {
+		return nil, err
+	}
+	config := findObject(objects, "ConfigMap", "kube-flannel-cfg")
+	daemonSet := findObject(objects, "DaemonSet", "kube-flannel-ds")
+	if config == nil || daemonSet == nil {
+		return nil, errors.New(errors.ErrTypeValidation, "the Flannel manifest has no kube-flannel-cfg ConfigMap or kube-flannel-ds DaemonSet")
+	}
+
+	if len(opts.PodCIDRs) > 0 {
+		raw, _, _ := unstructured.NestedString(config.Object, "data", "net-conf.json")
+		netConf := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(raw), &netConf); err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeValidation, "the Flannel manifest has an invalid net-conf.json", err)
+		}
+		v4, v6 := splitFamilies(opts.PodCIDRs)
+		if len(v4) > 0 {
+			netConf["Network"] = v4[0]
+		} else {
+			delete(netConf, "Network")
+			netConf["EnableIPv4"] = false
+		}
+		if len(v6) > 0 {
+			netConf["EnableIPv6"] = true
+			netConf["IPv6Network"] = v6[0]
+		}
+		rendered, err := json.MarshalIndent(netConf, "", "  ")
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeInternal, "failed to render the Flannel net-conf.json", err)
+		}
+		if err := unstructured.SetNestedField(config.Object, string(rendered)+"\n", "data", "net-conf.json"); err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeValidation, "failed to set the Flannel network", err)
+		}
+	}
+	if opts.Interface != "" {
+		if err := setContainerArg(daemonSet, "kube-flannel", "--iface=", opts.Interface); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// ciliumValues returns the Helm values of the Cilium chart.
+// ciliumValues 返回 Cilium chart 的 Helm 值。
+func ciliumValues(opts cniOptions) map[string]interface{} {
+	v4, v6 := splitFamilies(opts.PodCIDRs)
+	pool := map[string]interface{}{}
+	if len(v4) > 0 {
+		pool["clusterPoolIPv4PodCIDRList"] = toInterfaces(v4)
+	}
+	if len(v6) > 0 {
+		pool["clusterPoolIPv6PodCIDRList"] = toInterfaces(v6)
+	}
+	values := map[string]interface{}{
+		"ipam": map[string]interface{}{"mode": "cluster-pool", "operator": pool},
+		"ipv4": map[string]interface{}{"enabled": len(v6) == 0 || len(v4) > 0},
+		"ipv6": map[string]interface{}{"enabled": len(v6) > 0},
+	}
+	if opts.MTU > 0 {
+		values["MTU"] = opts.MTU
+	}
+	if opts.Interface != "" {
+		values["devices"] = []interface{}{opts.Interface}
+	}
+	if opts.Nodes == 1 {
+		// The operator spreads its replicas over nodes, so a single node only runs one.
+		// operator 将副本分散到各节点上，因此单节点只运行一个副本。
+		values["operator"] = map[string]interface{}{"replicas": 1}
+	}
+	return values
+}
+
+// renderCilium templates the Cilium Helm chart locally with the values derived from the cluster.
+// renderCilium 使用根据集群推导的值在本地渲染 Cilium Helm chart。
+func renderCilium(ctx context.Context, src []byte, opts cniOptions) ([]*unstructured.Unstructured, error) {
+	chrt, err := loader.LoadArchive(bytes.NewReader(src))
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeValidation, "failed to load the Cilium chart", err)
+	}
+	install := action.NewInstall(&action.Configuration{Log: func(string, ...interface{}) {}})
+	install.ReleaseName = "cilium"
+	install.Namespace = "kube-system"
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	install.IncludeCRDs = true
+	if opts.KubernetesVersion != "" {
+		kubeVersion, err := chartutil.ParseKubeVersion(opts.KubernetesVersion)
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("invalid Kubernetes version %s", opts.KubernetesVersion), err)
+		}
+		install.KubeVersion = kubeVersion
+	}
+	release, err := install.RunWithContext(ctx, chrt, ciliumValues(opts))
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeValidation, "failed to render the Cilium chart", err)
+	}
+	return decodeManifests([]byte(release.Manifest))
+}
+
+// findObject returns the object of a manifest with the given kind and name, or nil.
+// findObject 返回清单中具有给定类型和名称的对象，否则返回 nil。
+func findObject(objects []*unstructured.Unstructured, kind, name string) *unstructured.Unstructured {
+	for _, obj := range objects {
+		if obj.GetKind() == kind && obj.GetName() == name {
+			return obj
+		}
+	}
+	return nil
+}
+
+// updateContainer calls update on the named container of a workload's pod template and stores the result.
+// updateContainer 对工作负载 Pod 模板中指定名称的容器调用 update 并保存结果。
+func updateContainer(obj *unstructured.Unstructured, name string, update func(container map[string]interface{})) error {
+	path := []string{"spec", "template", "spec", "containers"}
+	containers, _, _ := unstructured.NestedSlice(obj.Object, path...)
+	for i, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok || container["name"] != name {
+			continue
+		}
+		update(container)
+		containers[i] = container
+		if err := unstructured.SetNestedSlice(obj.Object, containers, path...); err != nil {
+			return errors.NewWithCause(errors.ErrTypeValidation, fmt.Sprintf("failed to update container %s of %s %s", name, obj.GetKind(), obj.GetName()), err)
+		}
+		return nil
+	}
+	return errors.New(errors.ErrTypeValidation, fmt.Sprintf("%s %s has no container %s", obj.GetKind(), obj.GetName(), name))
+}
+
+// setContainerEnv sets an environment variable of a container, replacing any existing value.
+// setContainerEnv 设置容器的环境变量，替换任何已有的值。
+func setContainerEnv(obj *unstructured.Unstructured, container, name, value string) error {
+	return updateContainer(obj, container, func(c map[string]interface{}) {
+		env, _ := c["env"].([]interface{})
+		for i, e := range env {
+			if entry, ok := e.(map[string]interface{}); ok && entry["name"] == name {
+				env[i] = map[string]interface{}{"name": name, "value": value}
+				return
+			}
+		}
+		c["env"] = append(env, map[string]interface{}{"name": name, "value": value})
+	})
+}
+
+// setContainerArg sets a "--flag=" argument of a container, replacing any existing value.
+// setContainerArg 设置容器的 "--flag=" 参数，替换任何已有的值。
+func setContainerArg(obj *unstructured.Unstructured, container, flag, value string) error {
+	return updateContainer(obj, container, func(c map[string]interface{}) {
+		args, _ := c["args"].([]interface{})
+		for i, a := range args {
+			if arg, ok := a.(string); ok && strings.HasPrefix(arg, flag) {
+				args[i] = flag + value
+				return
+			}
+		}
+		c["args"] = append(args, flag+value)
+	})
+}
+
+// loadArtifactImages reads the image references listed by the platform artifact.
+// loadArtifactImages 读取平台制品列出的镜像引用。
+func loadArtifactImages(artifactDir string) ([]string, error) {
+	path := filepath.Join(artifactDir, ArtifactImagesFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read the artifact image list %s", path), err)
+	}
+	var images []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			images = append(images, line)
+		}
+	}
+	return images, nil
+}
+
+// rewriteImages replaces every container image of the objects with the artifact image of the same repository,
+// preferring the same tag. Images the artifact does not provide are an error, as air-gapped nodes cannot pull them.
+// rewriteImages 将对象中的每个容器镜像替换为平台制品中相同仓库的镜像，优先选择相同标签。
+// 平台制品未提供的镜像会导致错误，因为离线节点无法拉取它们。
+func rewriteImages(objects []*unstructured.Unstructured, images []string) error {
+	missing := map[string]bool{}
+	for _, obj := range objects {
+		walkContainers(obj.Object, func(container map[string]interface{}) {
+			ref, _ := container["image"].(string)
+			if ref == "" {
+				return
+			}
+			if replacement := artifactImage(ref, images); replacement != "" {
+				container["image"] = replacement
+			} else {
+				missing[ref] = true
+			}
+		})
+	}
+	if len(missing) > 0 {
+		refs := make([]string, 0, len(missing))
+		for ref := range missing {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		return errors.New(errors.ErrTypeNotFound, "images missing from the platform artifact: "+strings.Join(refs, ", "))
+	}
+	return nil
+}
+
+// walkContainers calls fn for every container, init container and ephemeral container found in a decoded object.
+// walkContainers 对解码对象中的每个容器、初始化容器和临时容器调用 fn。
+func walkContainers(value interface{}, fn func(container map[string]interface{})) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if list, ok := child.([]interface{}); ok && (key == "containers" || key == "initContainers" || key == "ephemeralContainers") {
+				for _, item := range list {
+					if container, ok := item.(map[string]interface{}); ok {
+						fn(container)
+					}
+				}
+				continue
+			}
+			walkContainers(child, fn)
+		}
+	case []interface{}:
+		for _, child := range v {
+			walkContainers(child, fn)
+		}
+	}
+}
+
+// artifactImage returns the artifact image of the same repository as ref, or "" if there is none.
+// A mirror may prefix the repository with a path, e.g. registry.local/mirror/calico/node.
+// artifactImage 返回与 ref 相同仓库的平台制品镜像，如果没有则返回 ""。镜像仓库可能会为仓库添加路径前缀，例如 registry.local/mirror/calico/node。
+func artifactImage(ref string, images []string) string {
+	repository, tag := splitImage(ref)
+	match := ""
+	for _, image := range images {
+		candidate, candidateTag := splitImage(image)
+		if candidate != repository && !strings.HasSuffix(candidate, "/"+repository) {
+			continue
+		}
+		if candidateTag == tag {
+			return image
+		}
+		if match == "" {
+			match = image
+		}
+	}
+	return match
+}
+
+// splitImage returns the repository path of an image reference without registry, and its tag.
+// Docker Hub official images are normalized, so "nginx" and "docker.io/library/nginx" are the same repository.
+// splitImage 返回镜像引用中不含仓库地址的仓库路径及其标签。Docker Hub 官方镜像会被规范化，因此 "nginx" 和 "docker.io/library/nginx" 是同一个仓库。
+func splitImage(ref string) (repository, tag string) {
+	name, _, _ := strings.Cut(ref, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		name = rest
+	}
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return name, tag
+}
+
+// objectName returns namespace/name of a namespaced object, or its name.
+// objectName 返回命名空间对象的 namespace/name，或其名称。
+func objectName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() != "" {
+		return obj.GetNamespace() + "/" + obj.GetName()
+	}
+	return obj.GetName()
+}
+
+// applyObject server-side applies an object and reports whether the cluster changed.
+// applyObject 通过服务端应用写入对象，并报告集群是否发生变化。
+// namespace: Namespace of namespaced objects that do not set one. / 未设置命名空间的命名空间对象所使用的命名空间。
+func applyObject(ctx context.Context, clients *clusterClients, obj *unstructured.Unstructured, namespace string) (bool, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := clients.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// Custom resources may be defined by CRDs applied earlier in the same manifest.
+		// 自定义资源可能由同一清单中较早应用的 CRD 定义。
+		if resettable, ok := clients.Mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = clients.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	if err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("the Host Cluster does not serve %s", gvk), err)
+	}
+
+	var resource dynamic.ResourceInterface = clients.Dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(namespace)
+		}
+		resource = clients.Dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+	}
+	name := obj.GetKind() + " " + objectName(obj)
+
+	previousVersion := ""
+	if live, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{}); err == nil {
+		previousVersion = live.GetResourceVersion()
+	} else if !apierrors.IsNotFound(err) {
+		return false, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to read %s", name), err)
+	}
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeInternal, fmt.Sprintf("failed to encode %s", name), err)
+	}
+	force := true
+	applied, err := resource.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	if err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to apply %s", name), err)
+	}
+	return previousVersion == "" || applied.GetResourceVersion() != previousVersion, nil
+}
+
+// waitForDaemonSet polls a DaemonSet until its current generation runs ready on every node it targets.
+// waitForDaemonSet 轮询 DaemonSet，直到其当前版本在所有目标节点上就绪运行。
+func waitForDaemonSet(ctx context.Context, client kubernetes.Interface, namespace, name string) error {
+	deadline := time.Now().Add(cniReadyTimeout)
+	progress := "not created"
+	for {
+		daemonSet, err := client.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			status := daemonSet.Status
+			if status.ObservedGeneration >= daemonSet.Generation && status.DesiredNumberScheduled > 0 &&
+				status.UpdatedNumberScheduled == status.DesiredNumberScheduled && status.NumberReady == status.DesiredNumberScheduled {
+				return nil
+			}
+			progress = fmt.Sprintf("%d/%d ready", status.NumberReady, status.DesiredNumberScheduled)
+		case !apierrors.IsNotFound(err):
+			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to read DaemonSet %s/%s", namespace, name), err)
+		}
+		if time.Now().After(deadline) {
+			return errors.New(errors.ErrTypeTimeout, fmt.Sprintf("DaemonSet %s/%s is not ready after %s (%s)", namespace, name, cniReadyTimeout, progress))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cniPollInterval):
+		}
+	}
+}
+
+// waitForNodesReady polls the Host Cluster until every configured node is registered and Ready.
+// waitForNodesReady 轮询 Host 集群，直到所有配置的节点都已注册并就绪。
+func waitForNodesReady(ctx context.Context, client kubernetes.Interface, nodes []model.NodeConfig) error {
+	deadline := time.Now().Add(cniReadyTimeout)
+	for {
+		list, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeSystem, "failed to list the Host Cluster nodes", err)
+		}
+		var pending []string
+		for _, nodeCfg := range nodes {
+			if node := MatchNode(nodeCfg.Address, list.Items); node == nil || !NodeReady(node) {
+				pending = append(pending, nodeCfg.Address)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		utils.GetLogger().Printf("Waiting for nodes: %d/%d ready", len(nodes)-len(pending), len(nodes))
+		if time.Now().After(deadline) {
+			return errors.New(errors.ErrTypeTimeout, fmt.Sprintf("nodes %s are not Ready after %s", strings.Join(pending, ", "), cniReadyTimeout))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cniPollInterval):
+		}
+	}
+}
+
+// MatchNode returns the Node whose name or one of whose addresses is address, or nil.
+// MatchNode 返回名称或某个地址为 address 的 Node，否则返回 nil。
+// address: A node address from the configuration (IP or hostname). / 配置中的节点地址（IP 或主机名）。
+// nodes: Nodes registered in the cluster. / 集群中注册的节点。
+func MatchNode(address string, nodes []corev1.Node) *corev1.Node {
+	for i := range nodes {
+		if strings.EqualFold(nodes[i].Name, address) {
+			return &nodes[i]
+		}
+		for _, nodeAddress := range nodes[i].Status.Addresses {
+			if nodeAddress.Address == address {
+				return &nodes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// NodeReady reports whether the Ready condition of a Node is true.
+// NodeReady 报告 Node 的 Ready 状况是否为 true。
+func NodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// toInterfaces converts strings to the []interface{} Helm values are made of.
+// toInterfaces 将字符串转换为构成 Helm 值的 []interface{}。
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
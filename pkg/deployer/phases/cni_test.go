@@ -0,0 +1,397 @@
+package phases
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// testCalicoManifest is a trimmed upstream Calico manifest.
+// testCalicoManifest 是精简后的上游 Calico 清单。
+const testCalicoManifest = `kind: ConfigMap
+apiVersion: v1
+metadata:
+  name: calico-config
+  namespace: kube-system
+data:
+  veth_mtu: "0"
+---
+kind: DaemonSet
+apiVersion: apps/v1
+metadata:
+  name: calico-node
+  namespace: kube-system
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: install-cni
+        image: docker.io/calico/cni:v3.28.2
+      containers:
+      - name: calico-node
+        image: docker.io/calico/node:v3.28.2
+        env:
+        - name: CALICO_IPV4POOL_IPIP
+          value: Always
+        - name: IP
+          value: autodetect
+`
+
+// testFlannelManifest is a trimmed upstream Flannel manifest.
+// testFlannelManifest 是精简后的上游 Flannel 清单。
+const testFlannelManifest = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: kube-flannel
+---
+kind: ConfigMap
+apiVersion: v1
+metadata:
+  name: kube-flannel-cfg
+  namespace: kube-flannel
+data:
+  net-conf.json: |
+    {
+      "Network": "10.244.0.0/16",
+      "Backend": {
+        "Type": "vxlan"
+      }
+    }
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: kube-flannel-ds
+  namespace: kube-flannel
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: install-cni-plugin
+        image: docker.io/flannel/flannel-cni-plugin:v1.5.1-flannel2
+      containers:
+      - name: kube-flannel
+        image: docker.io/flannel/flannel:v0.26.1
+        args:
+        - --ip-masq
+        - --kube-subnet-mgr
+`
+
+// testArtifactImages is the image list of the test platform artifact.
+// testArtifactImages 是测试平台制品的镜像列表。
+const testArtifactImages = `# Images of the platform artifact
+registry.local:5000/mirror/calico/cni:v3.28.2
+registry.local:5000/mirror/calico/node:v3.28.2
+registry.local:5000/flannel/flannel:v0.25.0
+registry.local:5000/flannel/flannel:v0.26.1
+registry.local:5000/flannel/flannel-cni-plugin:v1.5.1-flannel2
+`
+
+// testCNIArtifact writes a platform artifact holding a CNI manifest and the test image list.
+// testCNIArtifact 写入一个包含 CNI 清单和测试镜像列表的平台制品。
+func testCNIArtifact(t *testing.T, name, manifest string) string {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ArtifactCNIDir), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ArtifactCNIDir, name), []byte(manifest), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ArtifactImagesFile), []byte(testArtifactImages), 0644))
+	return dir
+}
+
+// manifestYAML renders objects as a multi-document manifest, for golden files.
+// manifestYAML 将对象渲染为多文档清单，用于黄金文件。
+func manifestYAML(t *testing.T, objects []*unstructured.Unstructured) string {
+	docs := make([]string, len(objects))
+	for i, obj := range objects {
+		data, err := yaml.Marshal(obj.Object)
+		require.NoError(t, err)
+		docs[i] = string(data)
+	}
+	return strings.Join(docs, "---\n")
+}
+
+// newFakeClusterClients returns clients backed by fakes. The dynamic client emulates server-side apply
+// with create-or-replace, bumping the resource version only when an object changes.
+// newFakeClusterClients 返回由伪实现支持的客户端。动态客户端以创建或替换的方式模拟服务端应用，仅在对象变化时递增资源版本。
+func newFakeClusterClients(kube kubernetes.Interface) *clusterClients {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, meta.RESTScopeNamespace)
+
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), nil)
+	dyn.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		gvr, namespace := action.GetResource(), action.GetNamespace()
+		live, err := dyn.Tracker().Get(gvr, namespace, patch.GetName())
+		if apierrors.IsNotFound(err) {
+			obj.SetResourceVersion("1")
+			return true, obj, dyn.Tracker().Create(gvr, obj, namespace)
+		} else if err != nil {
+			return true, nil, err
+		}
+		current := live.(*unstructured.Unstructured).DeepCopy()
+		version, _ := strconv.Atoi(current.GetResourceVersion())
+		current.SetResourceVersion("")
+		if equality.Semantic.DeepEqual(current.Object, obj.Object) {
+			return true, live, nil
+		}
+		obj.SetResourceVersion(strconv.Itoa(version + 1))
+		return true, obj, dyn.Tracker().Update(gvr, obj, namespace)
+	})
+	return &clusterClients{Kube: kube, Dynamic: dyn, Mapper: mapper}
+}
+
+func TestNewCNIOptions(t *testing.T) {
+	clusterCfg := testHACluster()
+	clusterCfg.Network.PodCIDR = "10.244.0.0/16, fd00:244::/56"
+	clusterCfg.Network.Interfaces = []types.InterfaceConfig{{Name: "eth0", DHCP: true, MTU: 9000}, {Name: "eth1", DHCP: true, MTU: 1500}}
+	installer, _ := lookupCNIInstaller("Calico")
+
+	opts := newCNIOptions(installer, clusterCfg)
+	assert.Equal(t, cniOptions{PodCIDRs: []string{"10.244.0.0/16", "fd00:244::/56"}, MTU: 8980, Interface: "eth0", KubernetesVersion: "1.30.0", Nodes: 3}, opts)
+
+	clusterCfg.Network.Interface = "eth1"
+	assert.Equal(t, 1480, newCNIOptions(installer, clusterCfg).MTU, "the IP-in-IP overhead is subtracted from the pod interface")
+	clusterCfg.Network.MTU = 1400
+	assert.Equal(t, 1400, newCNIOptions(installer, clusterCfg).MTU)
+
+	clusterCfg.Network = types.NetworkConfig{PodCIDR: "10.244.0.0/16"}
+	opts = newCNIOptions(installer, clusterCfg)
+	assert.Zero(t, opts.MTU, "the plugin detects the MTU")
+	assert.Empty(t, opts.Interface)
+}
+
+func TestRenderCalico(t *testing.T) {
+	opts := cniOptions{PodCIDRs: []string{"10.42.0.0/16"}, MTU: 1480, Interface: "eth1"}
+	objects, err := renderCalico(context.Background(), []byte(testCalicoManifest), opts)
+	require.NoError(t, err)
+	assertGolden(t, "cni-calico.yaml", manifestYAML(t, objects))
+
+	// An IPv6-only pod network disables IPv4 addresses.
+	// 仅 IPv6 的 Pod 网络会禁用 IPv4 地址。
+	objects, err = renderCalico(context.Background(), []byte(testCalicoManifest), cniOptions{PodCIDRs: []string{"fd00:42::/56"}, Interface: "eth1"})
+	require.NoError(t, err)
+	env, _, _ := unstructured.NestedSlice(objects[1].Object, "spec", "template", "spec", "containers")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "CALICO_IPV4POOL_IPIP", "value": "Always"},
+		map[string]interface{}{"name": "IP", "value": "none"},
+		map[string]interface{}{"name": "CALICO_IPV6POOL_CIDR", "value": "fd00:42::/56"},
+		map[string]interface{}{"name": "FELIX_IPV6SUPPORT", "value": "true"},
+		map[string]interface{}{"name": "IP6", "value": "autodetect"},
+		map[string]interface{}{"name": "IP_AUTODETECTION_METHOD", "value": "interface=eth1"},
+		map[string]interface{}{"name": "IP6_AUTODETECTION_METHOD", "value": "interface=eth1"},
+	}, env[0].(map[string]interface{})["env"])
+	mtu, _, _ := unstructured.NestedString(objects[0].Object, "data", "veth_mtu")
+	assert.Equal(t, "0", mtu, "Calico detects the MTU")
+
+	_, err = renderCalico(context.Background(), []byte(testFlannelManifest), opts)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+}
+
+func TestRenderFlannel(t *testing.T) {
+	objects, err := renderFlannel(context.Background(), []byte(testFlannelManifest), cniOptions{PodCIDRs: []string{"10.42.0.0/16", "fd00:42::/56"}, Interface: "eth1"})
+	require.NoError(t, err)
+	assertGolden(t, "cni-flannel.yaml", manifestYAML(t, objects))
+
+	objects, err = renderFlannel(context.Background(), []byte(testFlannelManifest), cniOptions{})
+	require.NoError(t, err)
+	args, _, _ := unstructured.NestedSlice(objects[2].Object, "spec", "template", "spec", "containers")
+	assert.Equal(t, []interface{}{"--ip-masq", "--kube-subnet-mgr"}, args[0].(map[string]interface{})["args"], "the upstream defaults are kept")
+}
+
+func TestRenderCilium(t *testing.T) {
+	opts := cniOptions{PodCIDRs: []string{"10.42.0.0/16"}, MTU: 1450, Interface: "eth1", KubernetesVersion: "v1.30.0", Nodes: 1}
+	values, err := yaml.Marshal(ciliumValues(opts))
+	require.NoError(t, err)
+	assertGolden(t, "cilium-values.yaml", string(values))
+
+	chrt := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "cilium", Version: "1.16.3", KubeVersion: ">= 1.21.0-0"},
+		Values:   map[string]interface{}{"MTU": 0, "devices": []interface{}{}},
+		Templates: []*chart.File{{Name: "templates/cilium-agent.yaml", Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cilium-config
+  namespace: {{ .Release.Namespace }}
+data:
+  mtu: {{ .Values.MTU | quote }}
+  devices: {{ join " " .Values.devices | quote }}
+  cluster-pool-ipv4-cidr: {{ join " " .Values.ipam.operator.clusterPoolIPv4PodCIDRList | quote }}
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: cilium
+  namespace: {{ .Release.Namespace }}
+spec:
+  template:
+    spec:
+      containers:
+      - name: cilium-agent
+        image: quay.io/cilium/cilium:v1.16.3@sha256:62d2a09bbef840a46099ac4c69421c90f84f28d018d479749049011329aa7f28
+`)}},
+	}
+	archive, err := chartutil.Save(chrt, t.TempDir())
+	require.NoError(t, err)
+	src, err := os.ReadFile(archive)
+	require.NoError(t, err)
+
+	objects, err := renderCilium(context.Background(), src, opts)
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	assert.Equal(t, map[string]interface{}{"mtu": "1450", "devices": "eth1", "cluster-pool-ipv4-cidr": "10.42.0.0/16"}, objects[0].Object["data"])
+	assert.Equal(t, "kube-system", objects[1].GetNamespace())
+
+	require.NoError(t, rewriteImages(objects, []string{"registry.local/cilium/cilium:v1.16.3"}))
+	containers, _, _ := unstructured.NestedSlice(objects[1].Object, "spec", "template", "spec", "containers")
+	assert.Equal(t, "registry.local/cilium/cilium:v1.16.3", containers[0].(map[string]interface{})["image"])
+
+	opts.KubernetesVersion = "v1.20.0"
+	_, err = renderCilium(context.Background(), src, opts)
+	assert.Error(t, err, "the chart's Kubernetes version constraint is checked")
+}
+
+func TestRewriteImages(t *testing.T) {
+	objects, err := decodeManifests([]byte(testFlannelManifest + "---\n" + testCalicoManifest))
+	require.NoError(t, err)
+	images := strings.Split(strings.TrimSpace(testArtifactImages), "\n")[1:]
+	require.NoError(t, rewriteImages(objects, images))
+
+	var refs []string
+	for _, obj := range objects {
+		walkContainers(obj.Object, func(container map[string]interface{}) {
+			refs = append(refs, container["image"].(string))
+		})
+	}
+	assert.ElementsMatch(t, []string{
+		"registry.local:5000/flannel/flannel-cni-plugin:v1.5.1-flannel2",
+		"registry.local:5000/flannel/flannel:v0.26.1",
+		"registry.local:5000/mirror/calico/cni:v3.28.2",
+		"registry.local:5000/mirror/calico/node:v3.28.2",
+	}, refs)
+
+	assert.Equal(t, "registry.local/library/busybox:1.36", artifactImage("busybox:1.36", []string{"registry.local/library/busybox:1.36"}))
+	assert.Equal(t, "registry.local/flannel/flannel:v0.25.0", artifactImage("flannel/flannel:v0.26.1", []string{"registry.local/flannel/flannel:v0.25.0"}), "another tag is used when the artifact has only one")
+
+	err = rewriteImages(objects, images[:1])
+	require.Error(t, err)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeNotFound))
+	assert.Contains(t, err.Error(), "registry.local:5000/flannel/flannel:v0.26.1")
+}
+
+func TestK8sInstallPhase_InstallsCNI(t *testing.T) {
+	cniPollInterval = time.Millisecond
+	server, nodeCfg := newTestNode(t)
+	nodeCfg.Roles = []enum.NodeRole{enum.RoleMaster}
+	clusterCfg := &model.ClusterConfig{
+		Name:        "edge",
+		Nodes:       []model.NodeConfig{nodeCfg},
+		ArtifactDir: testCNIArtifact(t, "flannel.yaml", testFlannelManifest),
+		Network:     types.NetworkConfig{Plugin: "Flannel", PodCIDR: "10.42.0.0/16", Interface: "eth1"},
+	}
+	kube := fake.NewSimpleClientset(
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-flannel-ds", Namespace: "kube-flannel"},
+			Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, UpdatedNumberScheduled: 1, NumberReady: 1},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge-master-1"},
+			Status: corev1.NodeStatus{
+				Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: nodeCfg.Address}},
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		},
+	)
+	clients := newFakeClusterClients(kube)
+	phase := newTestK8sInstallPhase(t, server)
+	phase.newClients = func(kubeconfigPath string) (*clusterClients, error) {
+		assert.Equal(t, KubeconfigPath(phase.kubeconfigDir, "edge"), kubeconfigPath)
+		return clients, nil
+	}
+
+	report := plan.NewReport()
+	ctx := plan.WithReporter(context.Background(), report, plan.ClusterTarget, "kubernetes")
+	require.NoError(t, phase.Run(ctx, clusterCfg.Nodes, clusterCfg))
+	assert.Equal(t, plan.Summary{Changed: 4, Unchanged: 1}, report.Summary(), "the kubeconfig and the three Flannel objects are written")
+
+	daemonSets := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}
+	daemonSet, err := clients.Dynamic.Resource(daemonSets).Namespace("kube-flannel").Get(context.Background(), "kube-flannel-ds", metav1.GetOptions{})
+	require.NoError(t, err)
+	containers, _, _ := unstructured.NestedSlice(daemonSet.Object, "spec", "template", "spec", "containers")
+	assert.Equal(t, "registry.local:5000/flannel/flannel:v0.26.1", containers[0].(map[string]interface{})["image"], "the image comes from the artifact")
+	assert.Equal(t, []interface{}{"--ip-masq", "--kube-subnet-mgr", "--iface=eth1"}, containers[0].(map[string]interface{})["args"])
+
+	// Re-running applies the same objects without changing them.
+	// 重新运行会应用相同的对象而不改变它们。
+	require.NoError(t, phase.Run(ctx, clusterCfg.Nodes, clusterCfg))
+	assert.Equal(t, plan.Summary{Changed: 4, Unchanged: 6}, report.Summary())
+
+	// A DaemonSet that does not become ready fails the phase.
+	// 未就绪的 DaemonSet 会使阶段失败。
+	defer func(timeout time.Duration) { cniReadyTimeout = timeout }(cniReadyTimeout)
+	cniReadyTimeout = 0
+	daemonSetStatus := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-flannel-ds", Namespace: "kube-flannel"},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, UpdatedNumberScheduled: 2, NumberReady: 1},
+	}
+	_, err = kube.AppsV1().DaemonSets("kube-flannel").Update(context.Background(), daemonSetStatus, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	err = phase.Run(context.Background(), clusterCfg.Nodes, clusterCfg)
+	require.Error(t, err)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeTimeout))
+	assert.Contains(t, err.Error(), "1/2 ready")
+}
+
+func TestK8sInstallPhase_PlanCNI(t *testing.T) {
+	clusterCfg := testHACluster()
+	clusterCfg.Network.Plugin = "cilium"
+	targets, err := NewK8sInstallPhase(nil).Plan(context.Background(), clusterCfg.Nodes, clusterCfg)
+	require.NoError(t, err)
+	last := targets[len(targets)-1]
+	assert.Equal(t, plan.ClusterTarget, last.Target)
+	require.Len(t, last.Actions, 1)
+	assert.Equal(t, "Apply the cilium CNI plugin from https://helm.cilium.io/cilium-1.16.3.tgz and wait for DaemonSet kube-system/cilium and every node to be Ready", last.Actions[0].Description)
+
+	clusterCfg.ArtifactDir = "/opt/chasi-bod/artifact"
+	targets, err = NewK8sInstallPhase(nil).Plan(context.Background(), clusterCfg.Nodes, clusterCfg)
+	require.NoError(t, err)
+	assert.Contains(t, targets[len(targets)-1].Actions[0].Description, "from /opt/chasi-bod/artifact/cni/cilium.tgz")
+
+	clusterCfg.Network.Plugin = "weave"
+	targets, err = NewK8sInstallPhase(nil).Plan(context.Background(), clusterCfg.Nodes, clusterCfg)
+	require.NoError(t, err)
+	assert.NotEqual(t, plan.ClusterTarget, targets[len(targets)-1].Target, "plugins that are not built in are not planned")
+}
@@ -0,0 +1,147 @@
+package phases
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// fstabPath is the filesystem table that persists disk mounts across reboots.
+// fstabPath 是跨重启持久化磁盘挂载的文件系统表。
+const fstabPath = "/etc/fstab"
+
+// filesystemPattern matches filesystem names that can be appended to mkfs. and passed to mount -t.
+// filesystemPattern 匹配可以追加到 mkfs. 并传给 mount -t 的文件系统名称。
+var filesystemPattern = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// diskActions formats (if requested), mounts and persists a single disk on the node.
+// diskActions 在节点上格式化（如有请求）、挂载并持久化单个磁盘。
+// Each step checks the node first, so a disk that is already set up is left untouched.
+// 每个步骤都会先检查节点，因此已配置好的磁盘不会被改动。
+// The disk is mounted and recorded in /etc/fstab by filesystem UUID, so device renames after a reboot do not matter.
+// 磁盘按文件系统 UUID 挂载并记录在 /etc/fstab 中，因此重启后设备重命名不会产生影响。
+func diskActions(diskCfg model.DiskConfig) ([]plan.Action, error) {
+	if diskCfg.Filesystem != "" && !filesystemPattern.MatchString(diskCfg.Filesystem) {
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("disk %s has an invalid filesystem %q", diskCfg.Device, diskCfg.Filesystem))
+	}
+	if diskCfg.ConfirmWipe && !diskCfg.Format {
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("disk %s confirms wiping but does not request formatting", diskCfg.Device))
+	}
+	var actions []plan.Action
+	if diskCfg.Format {
+		if diskCfg.Filesystem == "" {
+			return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("disk %s requests formatting but has no filesystem", diskCfg.Device))
+		}
+		actions = append(actions, plan.SudoCommand(fmt.Sprintf("Format %s as %s", diskCfg.Device, diskCfg.Filesystem), formatCommand(diskCfg)).
+			WithCheck(fmt.Sprintf(`test "$(blkid -s TYPE -o value %s)" = %s`, executor.ShellQuote(diskCfg.Device), executor.ShellQuote(diskCfg.Filesystem))))
+	}
+	if diskCfg.MountPoint == "" {
+		return actions, nil
+	}
+	if !strings.HasPrefix(diskCfg.MountPoint, "/") || diskCfg.MountPoint == "/" || strings.ContainsAny(diskCfg.MountPoint, " \t\n\\") {
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("disk %s has an invalid mount point %q", diskCfg.Device, diskCfg.MountPoint))
+	}
+	return append(actions,
+		plan.SudoCommand(fmt.Sprintf("Mount %s at %s", diskCfg.Device, diskCfg.MountPoint), mountCommand(diskCfg)).
+			WithCheck(mountedCheck(diskCfg)),
+		plan.SudoCommand(fmt.Sprintf("Persist the mount of %s in /etc/fstab", diskCfg.Device), fstabCommand(diskCfg)).
+			WithCheck(fstabCheck(diskCfg)),
+	), nil
+}
+
+// formatCommand creates the filesystem, refusing devices that are mounted or hold data.
+// formatCommand 创建文件系统，拒绝已挂载或包含数据的设备。
+// A device holds data when it carries any signature (filesystem, partition table, RAID, LVM) or has partitions.
+// Such a device is only erased with confirmWipe; a mounted device is never touched.
+// 当设备带有任何签名（文件系统、分区表、RAID、LVM）或有分区时，即视为包含数据。
+// 此类设备只有在设置 confirmWipe 时才会被擦除；已挂载的设备永远不会被改动。
+func formatCommand(diskCfg model.DiskConfig) string {
+	device := executor.ShellQuote(diskCfg.Device)
+	refuse := func(reason string) string {
+		return fmt.Sprintf("{ echo %s >&2; exit 1; }", executor.ShellQuote(fmt.Sprintf("refusing to format %s: %s", diskCfg.Device, reason)))
+	}
+	hasData := fmt.Sprintf(`blkid -p %[1]s >/dev/null 2>&1 || test "$(lsblk -nro NAME %[1]s | wc -l)" -gt 1`, device)
+	onData := refuse("it holds a filesystem, partitions or other data; set confirmWipe to erase it")
+	if diskCfg.ConfirmWipe {
+		onData = "wipefs -a " + device
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("test -b %s || %s", device, refuse("it is not a block device")),
+		fmt.Sprintf("if lsblk -nro MOUNTPOINT %s | grep -q .; then %s; fi", device, refuse("it or one of its partitions is mounted")),
+		fmt.Sprintf("if %s; then %s; fi", hasData, onData),
+		fmt.Sprintf("mkfs.%s %s", diskCfg.Filesystem, device),
+	}, "\n")
+}
+
+// filesystemUUID is a shell snippet that sets $uuid to the filesystem UUID of the disk, failing when it has none.
+// filesystemUUID 是一段 shell 片段，将 $uuid 设置为磁盘的文件系统 UUID，没有时失败。
+func filesystemUUID(diskCfg model.DiskConfig) string {
+	return fmt.Sprintf(`uuid=$(blkid -s UUID -o value %s) && test -n "$uuid"`, executor.ShellQuote(diskCfg.Device))
+}
+
+// mountedCheck succeeds when the mount table shows the disk at its mount point.
+// mountedCheck 在挂载表显示磁盘位于其挂载点时成功。
+func mountedCheck(diskCfg model.DiskConfig) string {
+	return fmt.Sprintf("findmnt -rn --source %s --mountpoint %s >/dev/null", executor.ShellQuote(diskCfg.Device), executor.ShellQuote(diskCfg.MountPoint))
+}
+
+// mountCommand mounts the disk by UUID and reads the mount table back to verify it.
+// mountCommand 按 UUID 挂载磁盘，并读回挂载表进行验证。
+// A mount point used by another device, or a disk mounted elsewhere, is an error rather than something to undo.
+// 被其他设备占用的挂载点或挂载在其他位置的磁盘会被视为错误，而不是需要撤销的内容。
+func mountCommand(diskCfg model.DiskConfig) string {
+	device := executor.ShellQuote(diskCfg.Device)
+	mountPoint := executor.ShellQuote(diskCfg.MountPoint)
+	fail := func(reason string) string {
+		return fmt.Sprintf("{ echo %s >&2; exit 1; }", executor.ShellQuote(fmt.Sprintf("cannot mount %s at %s: %s", diskCfg.Device, diskCfg.MountPoint, reason)))
+	}
+	mount := "mount"
+	if diskCfg.Filesystem != "" {
+		mount += " -t " + diskCfg.Filesystem
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("%s || %s", filesystemUUID(diskCfg), fail("it has no filesystem")),
+		fmt.Sprintf("if mountpoint -q %s; then %s; fi", mountPoint, fail("another device is mounted there")),
+		fmt.Sprintf("if findmnt -rn --source %s >/dev/null; then %s; fi", device, fail("it is mounted elsewhere")),
+		fmt.Sprintf(`mkdir -p %s && %s UUID="$uuid" %s || exit 1`, mountPoint, mount, mountPoint),
+		fmt.Sprintf("%s || %s", mountedCheck(diskCfg), fail("the mount table does not show it after mounting")),
+	}, "\n")
+}
+
+// fstabCheck succeeds when /etc/fstab mounts the disk's UUID at its mount point.
+// fstabCheck 在 /etc/fstab 将磁盘的 UUID 挂载到其挂载点时成功。
+func fstabCheck(diskCfg model.DiskConfig) string {
+	return fmt.Sprintf(`%s && findmnt --fstab -rn --source UUID="$uuid" --mountpoint %s >/dev/null`, filesystemUUID(diskCfg), executor.ShellQuote(diskCfg.MountPoint))
+}
+
+// fstabCommand replaces the /etc/fstab entry of the mount point with one that mounts the disk by UUID.
+// fstabCommand 将挂载点的 /etc/fstab 条目替换为按 UUID 挂载磁盘的条目。
+// Other entries and comments are kept, the previous file is saved next to it,
+// and the new file is moved into place in one step and then read back.
+// 其他条目和注释会被保留，之前的文件会保存在旁边，新文件一步移动到位后再读回验证。
+func fstabCommand(diskCfg model.DiskConfig) string {
+	filesystem := diskCfg.Filesystem
+	if filesystem == "" {
+		filesystem = "auto"
+	}
+	// Only the ext family needs a boot-time fsck pass; xfs and btrfs check themselves on mount.
+	// 只有 ext 系列需要启动时的 fsck；xfs 和 btrfs 在挂载时自行检查。
+	pass := "0"
+	if strings.HasPrefix(filesystem, "ext") {
+		pass = "2"
+	}
+	tmp := fstabPath + ".chasi-bod.tmp"
+	return strings.Join([]string{
+		filesystemUUID(diskCfg),
+		fmt.Sprintf("cp -p %s %s.chasi-bod.bak", fstabPath, fstabPath),
+		fmt.Sprintf(`awk -v mp=%s '$1 ~ /^#/ || $2 != mp' %s > %s`, executor.ShellQuote(diskCfg.MountPoint), fstabPath, tmp),
+		fmt.Sprintf(`echo "UUID=$uuid"%s >> %s`, executor.ShellQuote(fmt.Sprintf(" %s %s defaults 0 %s", diskCfg.MountPoint, filesystem, pass)), tmp),
+		fmt.Sprintf("mv %s %s", tmp, fstabPath),
+		fstabCheck(diskCfg),
+	}, " && ")
+}
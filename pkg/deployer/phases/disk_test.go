@@ -0,0 +1,49 @@
+package phases
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+func TestDiskActions_Format(t *testing.T) {
+	actions, err := diskActions(model.DiskConfig{Device: "/dev/vdb", Filesystem: "ext4", Format: true, MountPoint: "/var/lib/etcd"})
+	require.NoError(t, err)
+	require.Len(t, actions, 3)
+	for _, action := range actions {
+		out, err := exec.Command("sh", "-n", "-c", action.Command+"\n"+action.Check).CombinedOutput()
+		assert.NoError(t, err, "%s: %s", action.Description, out)
+	}
+
+	format := actions[0].Command
+	assert.Contains(t, format, "if lsblk -nro MOUNTPOINT /dev/vdb | grep -q .; then { echo 'refusing to format /dev/vdb: it or one of its partitions is mounted' >&2; exit 1; }; fi")
+	assert.Contains(t, format, "set confirmWipe to erase it")
+	assert.NotContains(t, format, "wipefs")
+	assert.Less(t, strings.Index(format, "blkid -p /dev/vdb"), strings.Index(format, "mkfs.ext4 /dev/vdb"))
+	assert.Contains(t, actions[2].Command, `' /var/lib/etcd ext4 defaults 0 2'`, "ext filesystems are checked at boot")
+
+	actions, err = diskActions(model.DiskConfig{Device: "/dev/vdb", Filesystem: "xfs", Format: true, ConfirmWipe: true})
+	require.NoError(t, err)
+	require.Len(t, actions, 1, "without a mount point the disk is only formatted")
+	assert.Contains(t, actions[0].Command, "then wipefs -a /dev/vdb; fi")
+	assert.Contains(t, actions[0].Command, "is mounted", "a confirmed wipe still refuses mounted disks")
+}
+
+func TestDiskActions_Invalid(t *testing.T) {
+	for name, diskCfg := range map[string]model.DiskConfig{
+		"format without filesystem":  {Device: "/dev/vdb", Format: true},
+		"wipe without format":        {Device: "/dev/vdb", Filesystem: "xfs", ConfirmWipe: true, MountPoint: "/data"},
+		"filesystem with shell code": {Device: "/dev/vdb", Filesystem: "xfs;reboot", Format: true},
+		"relative mount point":       {Device: "/dev/vdb", Filesystem: "xfs", MountPoint: "data"},
+		"root mount point":           {Device: "/dev/vdb", Filesystem: "xfs", MountPoint: "/"},
+		"mount point with spaces":    {Device: "/dev/vdb", Filesystem: "xfs", MountPoint: "/my data"},
+	} {
+		_, err := diskActions(diskCfg)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,244 @@
+package phases
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+const (
+	// defaultKubeVIPImage is the kube-vip image used when the configuration does not pin one.
+	// defaultKubeVIPImage 是配置未指定时使用的 kube-vip 镜像。
+	defaultKubeVIPImage = "ghcr.io/kube-vip/kube-vip:v0.8.9"
+	// kubeVIPManifestPath is the kube-vip static pod manifest on a master.
+	// kubeVIPManifestPath 是主节点上的 kube-vip 静态 Pod 清单。
+	kubeVIPManifestPath = "/etc/kubernetes/manifests/kube-vip.yaml"
+	// superAdminConfigPath is the kubeconfig kubeadm 1.29+ grants cluster-admin during init, before RBAC is bootstrapped.
+	// superAdminConfigPath 是 kubeadm 1.29+ 在 init 期间、RBAC 引导之前授予 cluster-admin 的 kubeconfig。
+	superAdminConfigPath = "/etc/kubernetes/super-admin.conf"
+	// haproxyConfigPath is the haproxy configuration on a master.
+	// haproxyConfigPath 是主节点上的 haproxy 配置。
+	haproxyConfigPath = "/etc/haproxy/haproxy.cfg"
+	// keepalivedConfigPath is the keepalived configuration on a master.
+	// keepalivedConfigPath 是主节点上的 keepalived 配置。
+	keepalivedConfigPath = "/etc/keepalived/keepalived.conf"
+	// keepalivedCheckScriptPath is the health check keepalived uses to move the virtual IP away from a broken master.
+	// keepalivedCheckScriptPath 是 keepalived 用于将虚拟 IP 从故障主节点移走的健康检查。
+	keepalivedCheckScriptPath = "/etc/keepalived/check_apiserver.sh"
+	// keepalivedEndpointPort is the default haproxy port; 6443 is taken by the API server on the same host.
+	// keepalivedEndpointPort 是默认的 haproxy 端口；6443 已被同一主机上的 API 服务器占用。
+	keepalivedEndpointPort = 8443
+	// defaultVirtualRouterID is the default VRRP router ID of keepalived.
+	// defaultVirtualRouterID 是 keepalived 的默认 VRRP 路由器 ID。
+	defaultVirtualRouterID = 51
+)
+
+// controlPlaneEndpoint returns the host:port all nodes use to reach the API server: the configured endpoint, or the first master.
+// controlPlaneEndpoint 返回所有节点用于访问 API 服务器的 host:port：配置的端点，或第一个主节点。
+func controlPlaneEndpoint(masterCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) string {
+	endpoint := clusterCfg.ControlPlaneEndpoint
+	if endpoint == nil {
+		return net.JoinHostPort(masterCfg.Address, strconv.Itoa(constants.DefaultKubeAPIServerPort))
+	}
+	return net.JoinHostPort(endpoint.Address, strconv.Itoa(endpointPort(endpoint)))
+}
+
+// endpointPort returns the configured endpoint port or the default of its mode.
+// endpointPort 返回配置的端点端口或其模式的默认端口。
+func endpointPort(endpoint *model.ControlPlaneEndpointConfig) int {
+	switch {
+	case endpoint.Port != 0:
+		return endpoint.Port
+	case endpoint.Mode == enum.EndpointModeKeepalived:
+		return keepalivedEndpointPort
+	default:
+		return constants.DefaultKubeAPIServerPort
+	}
+}
+
+// endpointActions returns the actions that make a master take part in the control plane endpoint.
+// endpointActions 返回使主节点参与控制平面端点的操作。
+// They run before kubeadm init or join, so the virtual IP is served as soon as the API server is up.
+// 它们在 kubeadm init 或 join 之前运行，因此 API 服务器一启动即可提供虚拟 IP。
+// masters: All masters, in configuration order. / 所有主节点，按配置顺序排列。
+// index: The position of the master being configured; the first master initializes the cluster. / 正在配置的主节点的位置；第一个主节点初始化集群。
+// clusterCfg: The cluster configuration. / 集群配置。
+// Returns nil when the endpoint is external or not configured.
+// 当端点为外部端点或未配置时返回 nil。
+func endpointActions(masters []model.NodeConfig, index int, clusterCfg *model.ClusterConfig) ([]plan.Action, error) {
+	endpoint := clusterCfg.ControlPlaneEndpoint
+	if endpoint == nil {
+		return nil, nil
+	}
+	switch endpoint.Mode {
+	case enum.EndpointModeKubeVIP:
+		kubeconfig := kubeadmAdminConfigPath
+		if index == 0 && needsSuperAdminConfig(clusterCfg.KubernetesVersion) {
+			kubeconfig = superAdminConfigPath
+		}
+		manifest, err := renderKubeVIPManifest(endpoint, kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		return []plan.Action{plan.File("Write the kube-vip static pod manifest", kubeVIPManifestPath, manifest, 0600)}, nil
+	case enum.EndpointModeKeepalived:
+		return []plan.Action{
+			plan.File("Write the haproxy configuration", haproxyConfigPath, renderHAProxyConfig(masters, endpoint), 0644),
+			plan.File("Write the keepalived health check", keepalivedCheckScriptPath, renderKeepalivedCheckScript(endpoint), 0755),
+			plan.File("Write the keepalived configuration", keepalivedConfigPath, renderKeepalivedConfig(index, clusterCfg), 0644),
+			plan.SudoCommand("Start haproxy", "systemctl enable haproxy && systemctl restart haproxy").
+				WithCheck(serviceCurrentCheck("haproxy", haproxyConfigPath)),
+			plan.SudoCommand("Start keepalived", "systemctl enable keepalived && systemctl restart keepalived").
+				WithCheck(serviceCurrentCheck("keepalived", keepalivedConfigPath)),
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// serviceCurrentCheck succeeds when a service is running and was started after its configuration file last changed.
+// serviceCurrentCheck 在服务正在运行且在其配置文件最后一次更改之后启动时成功。
+func serviceCurrentCheck(service, configPath string) string {
+	return fmt.Sprintf(`systemctl is-active --quiet %s && test "$(stat -c %%Y %s)" -le "$(date -d "$(systemctl show -p ActiveEnterTimestamp --value %s)" +%%s)"`,
+		service, configPath, service)
+}
+
+// needsSuperAdminConfig reports whether kube-vip must use super-admin.conf while kubeadm init runs.
+// needsSuperAdminConfig 报告在 kubeadm init 运行期间 kube-vip 是否必须使用 super-admin.conf。
+// Since Kubernetes 1.29 admin.conf is only authorized once init has created its RBAC binding,
+// which needs the virtual IP kube-vip is about to announce.
+// 自 Kubernetes 1.29 起，admin.conf 仅在 init 创建其 RBAC 绑定后才被授权，而这需要 kube-vip 即将通告的虚拟 IP。
+// Unknown versions are treated as recent.
+// 未知版本视为较新版本。
+func needsSuperAdminConfig(version string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return true
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	return major > 1 || (major == 1 && minor >= 29)
+}
+
+// renderKubeVIPManifest renders the kube-vip static pod announcing the virtual IP via ARP with leader election.
+// renderKubeVIPManifest 渲染通过 ARP 并使用领导者选举通告虚拟 IP 的 kube-vip 静态 Pod。
+func renderKubeVIPManifest(endpoint *model.ControlPlaneEndpointConfig, kubeconfigPath string) (string, error) {
+	image := endpoint.KubeVIPImage
+	if image == "" {
+		image = defaultKubeVIPImage
+	}
+	env := []corev1.EnvVar{
+		{Name: "vip_arp", Value: "true"},
+		{Name: "port", Value: strconv.Itoa(endpointPort(endpoint))},
+		{Name: "vip_interface", Value: endpoint.Interface},
+		{Name: "vip_cidr", Value: "32"},
+		{Name: "cp_enable", Value: "true"},
+		{Name: "cp_namespace", Value: "kube-system"},
+		{Name: "vip_leaderelection", Value: "true"},
+		{Name: "vip_leasename", Value: "plndr-cp-lock"},
+		{Name: "vip_leaseduration", Value: "5"},
+		{Name: "vip_renewdeadline", Value: "3"},
+		{Name: "vip_retryperiod", Value: "1"},
+		{Name: "address", Value: endpoint.Address},
+	}
+	if net.ParseIP(endpoint.Address).To4() == nil {
+		env[3].Value = "128"
+	}
+	hostPathFile := corev1.HostPathFileOrCreate
+	pod := corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-vip", Namespace: "kube-system"},
+		Spec: corev1.PodSpec{
+			HostNetwork: true,
+			HostAliases: []corev1.HostAlias{{IP: "127.0.0.1", Hostnames: []string{"kubernetes"}}},
+			Containers: []corev1.Container{{
+				Name:            "kube-vip",
+				Image:           image,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				Args:            []string{"manager"},
+				Env:             env,
+				SecurityContext: &corev1.SecurityContext{Capabilities: &corev1.Capabilities{
+					Add: []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+				}},
+				VolumeMounts: []corev1.VolumeMount{{Name: "kubeconfig", MountPath: kubeadmAdminConfigPath}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name:         "kubeconfig",
+				VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: kubeconfigPath, Type: &hostPathFile}},
+			}},
+		},
+	}
+	out, err := yaml.Marshal(pod)
+	if err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeInternal, "failed to render the kube-vip manifest", err)
+	}
+	return string(out), nil
+}
+
+// renderHAProxyConfig renders an haproxy configuration balancing the endpoint port over the API servers of all masters.
+// renderHAProxyConfig 渲染一个 haproxy 配置，将端点端口负载均衡到所有主节点的 API 服务器。
+func renderHAProxyConfig(masters []model.NodeConfig, endpoint *model.ControlPlaneEndpointConfig) string {
+	var b strings.Builder
+	b.WriteString("# Managed by chasi-bod. Do not edit.\n")
+	b.WriteString("global\n    log /dev/log local0\n    daemon\n\n")
+	b.WriteString("defaults\n    mode http\n    log global\n    option httplog\n    option dontlognull\n    retries 1\n")
+	b.WriteString("    timeout connect 5s\n    timeout client 35s\n    timeout server 35s\n    timeout http-request 10s\n\n")
+	fmt.Fprintf(&b, "frontend kube-apiserver\n    bind *:%d\n    mode tcp\n    option tcplog\n    default_backend kube-apiserver\n\n", endpointPort(endpoint))
+	b.WriteString("backend kube-apiserver\n    mode tcp\n    option httpchk\n    http-check connect ssl\n")
+	b.WriteString("    http-check send meth GET uri /healthz\n    http-check expect status 200\n    balance roundrobin\n")
+	for i, master := range masters {
+		fmt.Fprintf(&b, "    server master-%d %s check verify none\n", i+1, net.JoinHostPort(master.Address, strconv.Itoa(constants.DefaultKubeAPIServerPort)))
+	}
+	return b.String()
+}
+
+// renderKeepalivedCheckScript renders the script that fails when the local haproxy cannot reach a healthy API server.
+// renderKeepalivedCheckScript 渲染在本地 haproxy 无法访问健康的 API 服务器时失败的脚本。
+func renderKeepalivedCheckScript(endpoint *model.ControlPlaneEndpointConfig) string {
+	return fmt.Sprintf("#!/bin/sh\n# Managed by chasi-bod. Do not edit.\nexec curl -sfk --max-time 2 -o /dev/null https://localhost:%d/healthz\n", endpointPort(endpoint))
+}
+
+// renderKeepalivedConfig renders the keepalived configuration of one master. The first master starts as the VRRP master.
+// renderKeepalivedConfig 渲染一个主节点的 keepalived 配置。第一个主节点以 VRRP 主节点身份启动。
+func renderKeepalivedConfig(index int, clusterCfg *model.ClusterConfig) string {
+	endpoint := clusterCfg.ControlPlaneEndpoint
+	routerID := endpoint.VirtualRouterID
+	if routerID == 0 {
+		routerID = defaultVirtualRouterID
+	}
+	state, priority := "BACKUP", 100
+	if index == 0 {
+		state, priority = "MASTER", 101
+	}
+	// VRRP authentication only keeps unrelated clusters on the segment apart; it is derived so every master agrees on it.
+	// VRRP 认证仅用于区分同一网段上不相关的集群；它是派生的，因此每个主节点都一致。
+	sum := sha256.Sum256([]byte(clusterCfg.Name + "/" + endpoint.Address))
+	authPass := hex.EncodeToString(sum[:])[:8]
+
+	var b strings.Builder
+	b.WriteString("! Managed by chasi-bod. Do not edit.\n")
+	b.WriteString("global_defs {\n    router_id chasi-bod\n    enable_script_security\n    script_user root\n}\n\n")
+	fmt.Fprintf(&b, "vrrp_script check_apiserver {\n    script \"%s\"\n    interval 3\n    weight -2\n    fall 10\n    rise 2\n}\n\n", keepalivedCheckScriptPath)
+	b.WriteString("vrrp_instance chasi_bod_apiserver {\n")
+	fmt.Fprintf(&b, "    state %s\n    interface %s\n    virtual_router_id %d\n    priority %d\n", state, endpoint.Interface, routerID, priority)
+	fmt.Fprintf(&b, "    authentication {\n        auth_type PASS\n        auth_pass %s\n    }\n", authPass)
+	fmt.Fprintf(&b, "    virtual_ipaddress {\n        %s\n    }\n", endpoint.Address)
+	b.WriteString("    track_script {\n        check_apiserver\n    }\n}\n")
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package phases
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+	"github.com/turtacn/chasi-bod/pkg/deployer/preflight"
+)
+
+func TestControlPlaneEndpoint(t *testing.T) {
+	master := &model.NodeConfig{Address: "10.0.0.1"}
+	clusterCfg := &model.ClusterConfig{}
+	assert.Equal(t, "10.0.0.1:6443", controlPlaneEndpoint(master, clusterCfg), "the first master without a configured endpoint")
+
+	clusterCfg.ControlPlaneEndpoint = &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeKeepalived, Address: "10.0.0.100"}
+	assert.Equal(t, "10.0.0.100:8443", controlPlaneEndpoint(master, clusterCfg), "haproxy cannot share 6443 with the API server")
+
+	clusterCfg.ControlPlaneEndpoint = &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeExternal, Address: "api.example.com", Port: 443}
+	assert.Equal(t, "api.example.com:443", controlPlaneEndpoint(master, clusterCfg))
+	actions, err := endpointActions(clusterCfg.Nodes, 0, clusterCfg)
+	require.NoError(t, err)
+	assert.Empty(t, actions, "an external load balancer is not managed by chasi-bod")
+}
+
+func TestRenderEndpointConfigs(t *testing.T) {
+	clusterCfg := testHACluster()
+	clusterCfg.ControlPlaneEndpoint = &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeKeepalived, Address: "10.0.0.100", Interface: "eth0"}
+	masters, _ := splitNodesByRole(clusterCfg.Nodes)
+
+	assertGolden(t, "haproxy.cfg", renderHAProxyConfig(masters, clusterCfg.ControlPlaneEndpoint))
+	assertGolden(t, "keepalived-master.conf", renderKeepalivedConfig(0, clusterCfg))
+	assertGolden(t, "keepalived-backup.conf", renderKeepalivedConfig(1, clusterCfg))
+
+	manifest, err := renderKubeVIPManifest(&model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeKubeVIP, Address: "10.0.0.100", Interface: "eth0"}, superAdminConfigPath)
+	require.NoError(t, err)
+	assertGolden(t, "kube-vip.yaml", manifest)
+}
+
+func TestNeedsSuperAdminConfig(t *testing.T) {
+	assert.False(t, needsSuperAdminConfig("v1.28.9"))
+	assert.True(t, needsSuperAdminConfig("1.29.0"))
+	assert.True(t, needsSuperAdminConfig("v1.30.0"))
+	assert.True(t, needsSuperAdminConfig(""))
+}
+
+func TestK8sInstallPhase_KubeVIPEndpoint(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	handleFreshNode(server)
+	handleClusterCA(t, server)
+
+	master := nodeCfg
+	master.Roles = []enum.NodeRole{enum.RoleMaster}
+	clusterCfg := &model.ClusterConfig{
+		Name:                 "prod",
+		KubernetesVersion:    "v1.30.0",
+		Nodes:                []model.NodeConfig{master},
+		ControlPlaneEndpoint: &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeKubeVIP, Address: "10.0.0.100", Interface: "eth0"},
+	}
+
+	phase := newTestK8sInstallPhase(t, server)
+	report := plan.NewReport()
+	ctx := plan.WithReporter(context.Background(), report, plan.ClusterTarget, "kubernetes")
+	require.NoError(t, phase.Run(ctx, clusterCfg.Nodes, clusterCfg))
+
+	commands := server.Commands()
+	manifestIdx := commandIndex(commands, "cat > /etc/kubernetes/manifests/kube-vip.yaml.chasi-bod.tmp")
+	initIdx := commandIndex(commands, "kubeadm init --config")
+	assert.NotEqual(t, -1, manifestIdx)
+	assert.Less(t, manifestIdx, initIdx, "the virtual IP is announced while kubeadm init waits for it")
+	assert.Contains(t, uploadedContent(server, kubeVIPManifestPath), "path: /etc/kubernetes/super-admin.conf")
+	assert.Contains(t, report.Results(), plan.Result{Target: master.Address, Phase: "kubernetes", Item: "Write the kube-vip static pod manifest", Status: plan.StatusChanged})
+
+	initConfig := uploadedContent(server, kubeadmInitConfigPath)
+	assert.Contains(t, initConfig, "controlPlaneEndpoint: 10.0.0.100:6443", "set even for a single master so more can join later")
+	assert.Contains(t, initConfig, "- DirAvailable--etc-kubernetes-manifests")
+	assert.Contains(t, initConfig, "  - 10.0.0.100\n", "the virtual IP is a certificate SAN")
+
+	kubeconfig, err := clientcmd.LoadFromFile(KubeconfigPath(phase.kubeconfigDir, "prod"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.0.0.100:6443", kubeconfig.Clusters["kubernetes"].Server)
+	info, err := os.Stat(KubeconfigPath(phase.kubeconfigDir, "prod"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestK8sInstallPhase_PlanKeepalivedEndpoint(t *testing.T) {
+	clusterCfg := testHACluster()
+	clusterCfg.ControlPlaneEndpoint = &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeKeepalived, Address: "10.0.0.100", Interface: "eth0"}
+	targets, err := NewK8sInstallPhase(nil).Plan(context.Background(), clusterCfg.Nodes, clusterCfg)
+	require.NoError(t, err)
+	require.Len(t, targets, 3)
+
+	descriptions := func(actions []plan.Action) []string {
+		var out []string
+		for _, action := range actions {
+			out = append(out, action.Description)
+		}
+		return out
+	}
+	endpoint := []string{"Write the haproxy configuration", "Write the keepalived health check", "Write the keepalived configuration", "Start haproxy", "Start keepalived"}
+	assert.Equal(t, endpoint, descriptions(targets[0].Actions)[:5])
+	assert.Equal(t, append(endpoint, "Write the kubeadm join configuration", "Join the control plane"), descriptions(targets[1].Actions))
+	assert.Contains(t, targets[1].Actions[2].Content, "state BACKUP")
+	assert.Equal(t, []string{"Write the kubeadm join configuration", "Join the cluster"}, descriptions(targets[2].Actions), "workers do not carry the virtual IP")
+	assert.Contains(t, targets[2].Actions[0].Content, "apiServerEndpoint: 10.0.0.100:8443")
+}
+
+func TestEndpointRequirements(t *testing.T) {
+	defaults := preflight.DefaultRequirements()
+	clusterCfg := &model.ClusterConfig{ControlPlaneEndpoint: &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeKeepalived, Address: "10.0.0.100"}}
+
+	req := endpointRequirements(defaults, clusterCfg)
+	assert.Equal(t, []string{"haproxy", "keepalived"}, req.RoleTools[enum.RoleMaster])
+	assert.Contains(t, req.Ports[enum.RoleMaster], 8443)
+	assert.NotContains(t, defaults.Ports[enum.RoleMaster], 8443, "the base requirements are not modified")
+	assert.Equal(t, defaults.Ports[enum.RoleWorker], req.Ports[enum.RoleWorker])
+}
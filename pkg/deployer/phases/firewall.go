@@ -0,0 +1,393 @@
+package phases
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+const (
+	// firewallDir holds the rendered rulesets and the script that loads them.
+	// firewallDir 存放渲染后的规则集及加载它们的脚本。
+	firewallDir = "/etc/chasi-bod/firewall"
+	// firewallTable is the nftables table (family inet) owned by chasi-bod.
+	// firewallTable 是 chasi-bod 拥有的 nftables 表（inet 族）。
+	firewallTable = "chasi_bod"
+	// firewallChain is the iptables chain owned by chasi-bod, jumped to from INPUT.
+	// firewallChain 是 chasi-bod 拥有的 iptables 链，从 INPUT 跳转进入。
+	firewallChain = "CHASI-BOD-INPUT"
+	// firewallServiceName is the systemd unit that loads the firewall at boot.
+	// firewallServiceName 是在启动时加载防火墙的 systemd 单元。
+	firewallServiceName = "chasi-bod-firewall.service"
+	// defaultNodePortRange is the Kubernetes default Service NodePort range.
+	// defaultNodePortRange 是 Kubernetes 默认的 Service NodePort 范围。
+	defaultNodePortRange = "30000-32767"
+)
+
+var (
+	firewallNFTablesPath = path.Join(firewallDir, "rules.nft")
+	firewallIPv4Path     = path.Join(firewallDir, "rules.v4")
+	firewallIPv6Path     = path.Join(firewallDir, "rules.v6")
+	firewallScriptPath   = path.Join(firewallDir, "apply.sh")
+	firewallUnitPath     = path.Join("/etc/systemd/system", firewallServiceName)
+	// firewallStampPath records the hash of the last ruleset loaded, so an unchanged ruleset is not reloaded.
+	// firewallStampPath 记录上次加载的规则集的哈希，因此未更改的规则集不会被重新加载。
+	firewallStampPath = path.Join(constants.DefaultDataDir, "firewall", "applied")
+)
+
+// firewallRule accepts incoming traffic matching a protocol, ports and sources.
+// firewallRule 接受匹配协议、端口和来源的入站流量。
+type firewallRule struct {
+	Comment string
+	// Protocol is "tcp", "udp", an IP protocol number, or empty for any protocol.
+	// Protocol 为 "tcp"、"udp"、IP 协议号，或为空表示任意协议。
+	Protocol string
+	// Ports is a port or "first-last" range; empty for protocols without ports.
+	// Ports 是端口或 "first-last" 范围；对于没有端口的协议为空。
+	Ports string
+	// Sources are addresses or CIDRs; empty accepts any source.
+	// Sources 是地址或 CIDR；为空时接受任意来源。
+	Sources []string
+}
+
+// cniFirewallRules returns the traffic a CNI plugin exchanges between nodes.
+// cniFirewallRules 返回 CNI 插件在节点之间交换的流量。
+func cniFirewallRules(plugin string, nodes []string) []firewallRule {
+	switch strings.ToLower(plugin) {
+	case "calico":
+		return []firewallRule{
+			{Comment: "Calico BGP", Protocol: "tcp", Ports: "179", Sources: nodes},
+			{Comment: "Calico IP-in-IP", Protocol: "4", Sources: nodes},
+			{Comment: "Calico VXLAN", Protocol: "udp", Ports: "4789", Sources: nodes},
+			{Comment: "Calico Typha", Protocol: "tcp", Ports: "5473", Sources: nodes},
+		}
+	case "cilium":
+		return []firewallRule{
+			{Comment: "Cilium VXLAN", Protocol: "udp", Ports: "8472", Sources: nodes},
+			{Comment: "Cilium health checks", Protocol: "tcp", Ports: "4240", Sources: nodes},
+			{Comment: "Cilium Hubble", Protocol: "tcp", Ports: "4244", Sources: nodes},
+		}
+	case "flannel":
+		return []firewallRule{{Comment: "Flannel VXLAN", Protocol: "udp", Ports: "8472", Sources: nodes}}
+	default:
+		return nil
+	}
+}
+
+// firewallRules returns the traffic a node accepts, from its roles, the cluster configuration and the allowlist.
+// firewallRules 根据节点角色、集群配置和允许列表返回节点接受的流量。
+// Traffic between nodes is limited to the node addresses when they are all IP addresses.
+// 当所有节点地址都是 IP 地址时，节点之间的流量仅限于这些地址。
+func firewallRules(nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) []firewallRule {
+	var all, masters []model.NodeConfig
+	for _, n := range clusterCfg.Nodes {
+		all = append(all, n)
+		if hasRole(&n, enum.RoleMaster) {
+			masters = append(masters, n)
+		}
+	}
+	nodes, masterSources := firewallSources(all), firewallSources(masters)
+
+	sshPort := nodeCfg.Port
+	if sshPort == 0 {
+		sshPort = constants.DefaultSSHPort
+	}
+	rules := []firewallRule{{Comment: "SSH", Protocol: "tcp", Ports: strconv.Itoa(sshPort)}}
+	// A dual-stack pod network lists one CIDR per family, which the renderers match with the rules of that family.
+	// 双栈 Pod 网络为每个地址族列出一个 CIDR，渲染器用该地址族的规则匹配它。
+	var podCIDRs []string
+	for _, cidr := range strings.Split(clusterCfg.Network.PodCIDR, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			podCIDRs = append(podCIDRs, cidr)
+		}
+	}
+	if len(podCIDRs) > 0 {
+		rules = append(rules, firewallRule{Comment: "Pod network", Sources: podCIDRs})
+	}
+	rules = append(rules, firewallRule{Comment: "kubelet API", Protocol: "tcp", Ports: "10250", Sources: nodes})
+	rules = append(rules, cniFirewallRules(clusterCfg.Network.Plugin, nodes)...)
+
+	if hasRole(nodeCfg, enum.RoleMaster) {
+		rules = append(rules, firewallRule{Comment: "Kubernetes API server", Protocol: "tcp", Ports: strconv.Itoa(constants.DefaultKubeAPIServerPort)})
+		if endpoint := clusterCfg.ControlPlaneEndpoint; endpoint != nil && endpoint.Mode != enum.EndpointModeExternal {
+			if port := endpointPort(endpoint); port != constants.DefaultKubeAPIServerPort {
+				rules = append(rules, firewallRule{Comment: "Control plane endpoint", Protocol: "tcp", Ports: strconv.Itoa(port)})
+			}
+			if endpoint.Mode == enum.EndpointModeKeepalived {
+				rules = append(rules, firewallRule{Comment: "keepalived VRRP", Protocol: "112", Sources: masterSources})
+			}
+		}
+		rules = append(rules, firewallRule{Comment: "etcd client and peer", Protocol: "tcp", Ports: "2379-2380", Sources: masterSources})
+	}
+	if hasRole(nodeCfg, enum.RoleWorker) || hasRole(nodeCfg, enum.RoleEdge) {
+		nodePorts := defaultNodePortRange
+		if clusterCfg.Firewall != nil && clusterCfg.Firewall.NodePortRange != "" {
+			nodePorts = clusterCfg.Firewall.NodePortRange
+		}
+		rules = append(rules,
+			firewallRule{Comment: "NodePort Services", Protocol: "tcp", Ports: nodePorts},
+			firewallRule{Comment: "NodePort Services", Protocol: "udp", Ports: nodePorts})
+	}
+	if hasRole(nodeCfg, enum.RoleEdge) {
+		rules = append(rules,
+			firewallRule{Comment: "HTTP ingress", Protocol: "tcp", Ports: "80"},
+			firewallRule{Comment: "HTTPS ingress", Protocol: "tcp", Ports: "443"})
+	}
+
+	if clusterCfg.Firewall != nil {
+		for _, allow := range clusterCfg.Firewall.Allow {
+			if !firewallRuleApplies(allow, nodeCfg) {
+				continue
+			}
+			protocol := allow.Protocol
+			if protocol == "" {
+				protocol = "tcp"
+			}
+			comment := allow.Description
+			if comment == "" {
+				comment = "Allowlist " + protocol + "/" + allow.Ports
+			}
+			sources := append([]string(nil), allow.Sources...)
+			sort.Strings(sources)
+			rules = append(rules, firewallRule{Comment: comment, Protocol: protocol, Ports: allow.Ports, Sources: sources})
+		}
+	}
+	return rules
+}
+
+// firewallRuleApplies reports whether an allowlist rule applies to the roles of a node.
+// firewallRuleApplies 报告允许列表规则是否适用于节点的角色。
+func firewallRuleApplies(rule model.FirewallRule, nodeCfg *model.NodeConfig) bool {
+	if len(rule.Roles) == 0 {
+		return true
+	}
+	for _, role := range rule.Roles {
+		if hasRole(nodeCfg, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// firewallSources returns the sorted addresses of nodes, or nil (any source) when one of them is a hostname.
+// firewallSources 返回节点的已排序地址；当其中一个是主机名时返回 nil（任意来源）。
+func firewallSources(nodes []model.NodeConfig) []string {
+	sources := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if net.ParseIP(n.Address) == nil {
+			return nil
+		}
+		sources = append(sources, n.Address)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// firewallComment makes a rule comment safe to quote in nftables and iptables rules.
+// firewallComment 使规则注释可以安全地在 nftables 和 iptables 规则中加引号。
+// nftables limits comments to 128 bytes.
+// nftables 将注释限制为 128 字节。
+func firewallComment(comment string) string {
+	comment = strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || r < ' ' {
+			return -1
+		}
+		return r
+	}, comment)
+	for len(comment) > 128 {
+		_, size := utf8.DecodeLastRuneInString(comment)
+		comment = comment[:len(comment)-size]
+	}
+	return comment
+}
+
+// renderNFTables renders the rules as an nftables script that replaces the chasi-bod table in one transaction.
+// renderNFTables 将规则渲染为在一个事务中替换 chasi-bod 表的 nftables 脚本。
+// Declaring the table before deleting it makes the delete succeed on the first run too.
+// 在删除表之前先声明它，使删除在首次运行时也能成功。
+func renderNFTables(rules []firewallRule) string {
+	var b strings.Builder
+	b.WriteString(managedFileHeader)
+	fmt.Fprintf(&b, "table inet %[1]s\ndelete table inet %[1]s\n\ntable inet %[1]s {\n", firewallTable)
+	b.WriteString("\tchain input {\n")
+	b.WriteString("\t\ttype filter hook input priority 0; policy drop;\n")
+	b.WriteString("\t\tiif \"lo\" accept\n")
+	b.WriteString("\t\tct state established,related accept\n")
+	b.WriteString("\t\tct state invalid drop\n")
+	b.WriteString("\t\tmeta l4proto { icmp, ipv6-icmp } accept\n")
+	for _, rule := range rules {
+		var match string
+		switch {
+		case rule.Ports != "":
+			match = fmt.Sprintf("%s dport %s ", rule.Protocol, rule.Ports)
+		case rule.Protocol != "":
+			match = fmt.Sprintf("meta l4proto %s ", rule.Protocol)
+		}
+		accept := fmt.Sprintf("%saccept comment \"%s\"\n", match, firewallComment(rule.Comment))
+		if len(rule.Sources) == 0 {
+			b.WriteString("\t\t" + accept)
+			continue
+		}
+		v4, v6 := splitFamilies(rule.Sources)
+		if len(v4) > 0 {
+			fmt.Fprintf(&b, "\t\tip saddr { %s } %s", strings.Join(v4, ", "), accept)
+		}
+		if len(v6) > 0 {
+			fmt.Fprintf(&b, "\t\tip6 saddr { %s } %s", strings.Join(v6, ", "), accept)
+		}
+	}
+	b.WriteString("\t}\n}\n")
+	return b.String()
+}
+
+// renderIPTables renders the rules of one address family as an iptables-restore file.
+// renderIPTables 将一个地址族的规则渲染为 iptables-restore 文件。
+// Declaring the chain flushes it, so loading the file with --noflush replaces only the chasi-bod chain.
+// Rules whose sources all belong to the other family are left out.
+// 声明链会清空它，因此使用 --noflush 加载文件时只替换 chasi-bod 链。所有来源都属于另一地址族的规则会被省略。
+func renderIPTables(rules []firewallRule, ipv6 bool) string {
+	var b strings.Builder
+	b.WriteString(managedFileHeader)
+	fmt.Fprintf(&b, "*filter\n:%s - [0:0]\n", firewallChain)
+	appendRule := func(spec string) {
+		fmt.Fprintf(&b, "-A %s %s\n", firewallChain, spec)
+	}
+	appendRule("-i lo -j ACCEPT")
+	appendRule("-m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT")
+	appendRule("-m conntrack --ctstate INVALID -j DROP")
+	if ipv6 {
+		appendRule("-p ipv6-icmp -j ACCEPT")
+	} else {
+		appendRule("-p icmp -j ACCEPT")
+	}
+	for _, rule := range rules {
+		var match string
+		switch {
+		case rule.Ports != "":
+			match = fmt.Sprintf("-p %[1]s -m %[1]s --dport %[2]s ", rule.Protocol, strings.Replace(rule.Ports, "-", ":", 1))
+		case rule.Protocol != "":
+			match = fmt.Sprintf("-p %s ", rule.Protocol)
+		}
+		accept := fmt.Sprintf("%s-m comment --comment \"%s\" -j ACCEPT", match, firewallComment(rule.Comment))
+		if len(rule.Sources) == 0 {
+			appendRule(accept)
+			continue
+		}
+		v4, v6 := splitFamilies(rule.Sources)
+		sources := v4
+		if ipv6 {
+			sources = v6
+		}
+		for _, source := range sources {
+			appendRule(fmt.Sprintf("-s %s %s", source, accept))
+		}
+	}
+	appendRule("-j DROP")
+	b.WriteString("COMMIT\n")
+	return b.String()
+}
+
+// renderFirewallScript renders the script that validates and loads the rulesets, preferring nftables when the backend is not fixed.
+// renderFirewallScript 渲染验证并加载规则集的脚本；未固定后端时优先使用 nftables。
+// The rulesets are checked before anything is loaded, so a bad ruleset leaves the running firewall untouched.
+// 在加载任何内容之前会先检查规则集，因此错误的规则集不会改动正在运行的防火墙。
+func renderFirewallScript(backend enum.FirewallBackend, hash string) string {
+	nft := []string{
+		"nft -c -f " + firewallNFTablesPath,
+		"nft -f " + firewallNFTablesPath,
+	}
+	iptables := []string{
+		fmt.Sprintf("iptables-restore --test --noflush %s", firewallIPv4Path),
+		fmt.Sprintf("ip6tables-restore --test --noflush %s", firewallIPv6Path),
+		fmt.Sprintf("iptables-restore --noflush %s", firewallIPv4Path),
+		fmt.Sprintf("ip6tables-restore --noflush %s", firewallIPv6Path),
+	}
+	for _, tool := range []string{"iptables", "ip6tables"} {
+		iptables = append(iptables, fmt.Sprintf("%[1]s -C INPUT -j %[2]s 2>/dev/null || %[1]s -I INPUT 1 -j %[2]s", tool, firewallChain))
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n" + managedFileHeader + "set -e\n")
+	switch backend {
+	case enum.FirewallBackendNFTables:
+		b.WriteString(strings.Join(nft, "\n") + "\n")
+	case enum.FirewallBackendIPTables:
+		b.WriteString(strings.Join(iptables, "\n") + "\n")
+	default:
+		b.WriteString("if command -v nft >/dev/null 2>&1; then\n\t" + strings.Join(nft, "\n\t") + "\nelse\n\t" + strings.Join(iptables, "\n\t") + "\nfi\n")
+	}
+	fmt.Fprintf(&b, "mkdir -p %s\necho %s > %s\n", path.Dir(firewallStampPath), hash, firewallStampPath)
+	return b.String()
+}
+
+// renderFirewallUnit renders the systemd unit that loads the firewall before the network comes up.
+// renderFirewallUnit 渲染在网络启动之前加载防火墙的 systemd 单元。
+func renderFirewallUnit() string {
+	return managedFileHeader + `[Unit]
+Description=chasi-bod host firewall
+Wants=network-pre.target
+Before=network-pre.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh ` + firewallScriptPath + `
+
+[Install]
+WantedBy=multi-user.target
+`
+}
+
+// firewallActions renders the host firewall of a node, installs it as a boot-time unit and loads it.
+// firewallActions 渲染节点的主机防火墙，将其安装为启动时单元并加载它。
+// Nothing is returned while the firewall is not enabled.
+// 防火墙未启用时不返回任何内容。
+func firewallActions(nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) []plan.Action {
+	if clusterCfg.Firewall == nil || !clusterCfg.Firewall.Enabled {
+		return nil
+	}
+	backend := clusterCfg.Firewall.Backend
+	rules := firewallRules(nodeCfg, clusterCfg)
+
+	var files []plan.Action
+	if backend != enum.FirewallBackendIPTables {
+		files = append(files, plan.File("Write the nftables firewall rules", firewallNFTablesPath, renderNFTables(rules), 0600))
+	}
+	if backend != enum.FirewallBackendNFTables {
+		files = append(files,
+			plan.File("Write the iptables firewall rules", firewallIPv4Path, renderIPTables(rules, false), 0600),
+			plan.File("Write the ip6tables firewall rules", firewallIPv6Path, renderIPTables(rules, true), 0600))
+	}
+	sum := sha256.New()
+	for _, file := range files {
+		sum.Write([]byte(file.Content))
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))[:16]
+
+	loaded := fmt.Sprintf("nft list table inet %s", firewallTable)
+	switch backend {
+	case enum.FirewallBackendIPTables:
+		loaded = "iptables -S " + firewallChain
+	case "":
+		loaded = fmt.Sprintf("{ %s || iptables -S %s; }", loaded, firewallChain)
+	}
+	return append(files,
+		plan.File("Write the firewall loader", firewallScriptPath, renderFirewallScript(backend, hash), 0700),
+		plan.File("Write the firewall systemd unit", firewallUnitPath, renderFirewallUnit(), 0644),
+		plan.SudoCommand("Enable the firewall at boot", "systemctl daemon-reload && systemctl enable "+firewallServiceName).
+			WithCheck(fmt.Sprintf("systemctl is-enabled --quiet %[1]s && test \"$(systemctl show -p NeedDaemonReload --value %[1]s)\" != yes", firewallServiceName)),
+		plan.SudoCommand("Load the firewall rules", "/bin/sh "+firewallScriptPath).
+			WithCheck(fmt.Sprintf("test \"$(cat %s 2>/dev/null)\" = %s && %s >/dev/null 2>&1", firewallStampPath, hash, loaded)),
+	)
+}
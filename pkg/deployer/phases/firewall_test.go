@@ -0,0 +1,154 @@
+package phases
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// testFirewallCluster returns an HA Calico cluster with a keepalived endpoint, a dual-stack edge node and an allowlist.
+// testFirewallCluster 返回一个带 keepalived 端点、双栈边缘节点和允许列表的高可用 Calico 集群。
+func testFirewallCluster() *model.ClusterConfig {
+	clusterCfg := testHACluster()
+	clusterCfg.Network.Plugin = "calico"
+	clusterCfg.Nodes = append(clusterCfg.Nodes, model.NodeConfig{Address: "fd00::4", Roles: []enum.NodeRole{enum.RoleEdge}})
+	clusterCfg.ControlPlaneEndpoint = &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeKeepalived, Address: "10.0.0.100", Interface: "eth0"}
+	clusterCfg.Firewall = &model.FirewallConfig{
+		Enabled: true,
+		Allow: []model.FirewallRule{
+			{Description: "node-exporter", Ports: "9100", Sources: []string{"fd00:1::/64", "10.1.0.0/16"}},
+			{Protocol: "udp", Ports: "6081", Roles: []enum.NodeRole{enum.RoleWorker}},
+		},
+	}
+	return clusterCfg
+}
+
+// firewallPorts lists the protocol/ports of rules, for comparing the services nodes of each role accept.
+// firewallPorts 列出规则的协议/端口，用于比较各角色节点接受的服务。
+func firewallPorts(rules []firewallRule) []string {
+	ports := make([]string, len(rules))
+	for i, rule := range rules {
+		ports[i] = strings.TrimSuffix(rule.Protocol+"/"+rule.Ports, "/")
+	}
+	return ports
+}
+
+func TestFirewallRules(t *testing.T) {
+	clusterCfg := testFirewallCluster()
+
+	master := firewallRules(&clusterCfg.Nodes[0], clusterCfg)
+	assert.Equal(t, []string{"tcp/22", "", "tcp/10250", "tcp/179", "4", "udp/4789", "tcp/5473", "tcp/6443", "tcp/8443", "112", "tcp/2379-2380", "tcp/9100"}, firewallPorts(master))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, master[9].Sources, "VRRP is only accepted from the masters")
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "fd00::4"}, master[2].Sources)
+
+	worker := firewallRules(&clusterCfg.Nodes[2], clusterCfg)
+	assert.Equal(t, []string{"tcp/22", "", "tcp/10250", "tcp/179", "4", "udp/4789", "tcp/5473", "tcp/30000-32767", "udp/30000-32767", "tcp/9100", "udp/6081"}, firewallPorts(worker))
+
+	clusterCfg.Firewall.NodePortRange = "30000-30100"
+	edge := firewallRules(&clusterCfg.Nodes[3], clusterCfg)
+	assert.Equal(t, []string{"tcp/22", "", "tcp/10250", "tcp/179", "4", "udp/4789", "tcp/5473", "tcp/30000-30100", "udp/30000-30100", "tcp/80", "tcp/443", "tcp/9100"}, firewallPorts(edge))
+
+	// A hostname cannot be matched by the firewall, so traffic between nodes is accepted from anywhere.
+	// 防火墙无法匹配主机名，因此接受来自任意位置的节点间流量。
+	clusterCfg.Nodes[2].Address = "worker-1.example.com"
+	rules := firewallRules(&clusterCfg.Nodes[0], clusterCfg)
+	assert.Empty(t, rules[2].Sources)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, rules[10].Sources)
+}
+
+func TestRenderFirewallRules(t *testing.T) {
+	clusterCfg := testFirewallCluster()
+	master := firewallRules(&clusterCfg.Nodes[0], clusterCfg)
+	assertGolden(t, "firewall-master.nft", renderNFTables(master))
+	assertGolden(t, "firewall-master.v4", renderIPTables(master, false))
+	assertGolden(t, "firewall-master.v6", renderIPTables(master, true))
+	assertGolden(t, "firewall-edge.nft", renderNFTables(firewallRules(&clusterCfg.Nodes[3], clusterCfg)))
+
+	// A dual-stack pod network is accepted by the rules of each address family.
+	// 双栈 Pod 网络由各地址族的规则分别接受。
+	clusterCfg.Network.PodCIDR = "10.244.0.0/16, fd00:244::/56"
+	dualStack := firewallRules(&clusterCfg.Nodes[0], clusterCfg)
+	assert.Equal(t, []string{"10.244.0.0/16", "fd00:244::/56"}, dualStack[1].Sources)
+	assertGolden(t, "firewall-dualstack.nft", renderNFTables(dualStack))
+	assertGolden(t, "firewall-dualstack.v4", renderIPTables(dualStack, false))
+	assertGolden(t, "firewall-dualstack.v6", renderIPTables(dualStack, true))
+
+	assert.Equal(t, renderNFTables(master), renderNFTables(firewallRules(&clusterCfg.Nodes[0], testFirewallCluster())), "rendering is deterministic")
+	assert.Equal(t, "a b", firewallComment("a \"b\"\n"))
+	assert.Len(t, firewallComment(strings.Repeat("防", 50)), 126)
+}
+
+func TestFirewallActions(t *testing.T) {
+	clusterCfg := testFirewallCluster()
+	nodeCfg := &clusterCfg.Nodes[2]
+	paths := func(actions []plan.Action) []string {
+		var files []string
+		for _, action := range actions {
+			if action.Kind == plan.ActionFile {
+				files = append(files, action.Path)
+			}
+		}
+		return files
+	}
+
+	actions := firewallActions(nodeCfg, clusterCfg)
+	require.Len(t, actions, 7)
+	assert.Equal(t, []string{firewallNFTablesPath, firewallIPv4Path, firewallIPv6Path, firewallScriptPath, firewallUnitPath}, paths(actions))
+	assert.Contains(t, actions[3].Content, "if command -v nft >/dev/null 2>&1; then\n\tnft -c -f /etc/chasi-bod/firewall/rules.nft\n\tnft -f /etc/chasi-bod/firewall/rules.nft\nelse\n\tiptables-restore --test --noflush")
+	assert.Equal(t, "/bin/sh /etc/chasi-bod/firewall/apply.sh", actions[6].Command)
+	hash := strings.Fields(actions[6].Check)[5]
+	assert.Contains(t, actions[3].Content, "echo "+hash+" > /var/lib/chasi-bod/firewall/applied\n", "the loader records the hash the check expects")
+
+	clusterCfg.Firewall.Backend = enum.FirewallBackendIPTables
+	actions = firewallActions(nodeCfg, clusterCfg)
+	assert.Equal(t, []string{firewallIPv4Path, firewallIPv6Path, firewallScriptPath, firewallUnitPath}, paths(actions))
+	assert.NotContains(t, actions[2].Content, "nft")
+	assert.Contains(t, actions[5].Check, "iptables -S CHASI-BOD-INPUT")
+
+	clusterCfg.Firewall.Backend = enum.FirewallBackendNFTables
+	assert.Equal(t, []string{firewallNFTablesPath, firewallScriptPath, firewallUnitPath}, paths(firewallActions(nodeCfg, clusterCfg)))
+
+	clusterCfg.Firewall.Enabled = false
+	assert.Empty(t, firewallActions(nodeCfg, clusterCfg))
+}
+
+func TestNetworkConfigPhase_AppliesFirewall(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	handleFreshNode(server)
+	server.HandleOutput("cat /var/lib/chasi-bod/firewall/applied", "", 1)
+	nodeCfg.Roles = []enum.NodeRole{enum.RoleWorker}
+	clusterCfg := &model.ClusterConfig{Nodes: []model.NodeConfig{nodeCfg}, Firewall: &model.FirewallConfig{Enabled: true}}
+	clusterCfg.Network.Plugin = "flannel"
+
+	require.NoError(t, NewNetworkConfigPhase(nil).Run(context.Background(), &nodeCfg, clusterCfg))
+	commands := server.Commands()
+	loadIdx := commandIndex(commands, "/bin/sh /etc/chasi-bod/firewall/apply.sh")
+	require.NotEqual(t, -1, loadIdx)
+	assert.Less(t, commandIndex(commands, "cat > /etc/chasi-bod/firewall/rules.nft"), loadIdx)
+	assert.Less(t, commandIndex(commands, "systemctl enable chasi-bod-firewall.service"), loadIdx)
+	assert.Contains(t, uploadedContent(server, firewallNFTablesPath), "udp dport 8472 accept comment \"Flannel VXLAN\"\n")
+
+	// A converged node is left alone.
+	// 已收敛的节点不会被改动。
+	actions, err := NewNetworkConfigPhase(nil).Plan(context.Background(), &nodeCfg, clusterCfg)
+	require.NoError(t, err)
+	for _, action := range actions {
+		if action.Kind == plan.ActionFile {
+			mode, err := action.FileMode()
+			require.NoError(t, err)
+			server.HandleFile(action.Path, action.Content, mode)
+		}
+	}
+	server.HandleOutput("cat /var/lib/chasi-bod/firewall/applied", "", 0)
+	server.HandleOutput("systemctl is-enabled", "", 0)
+	before := len(server.Commands())
+	require.NoError(t, NewNetworkConfigPhase(nil).Run(context.Background(), &nodeCfg, clusterCfg))
+	assert.Equal(t, -1, commandIndex(server.Commands()[before:], "/bin/sh /etc/chasi-bod/firewall/apply.sh"))
+}
@@ -0,0 +1,592 @@
+package phases
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+const (
+	// netplanConfigPath is the netplan file chasi-bod writes; it sorts after the installer's files so its settings win.
+	// netplanConfigPath 是 chasi-bod 写入的 netplan 文件；它排在安装程序的文件之后，因此其设置优先。
+	netplanConfigPath = "/etc/netplan/90-chasi-bod.yaml"
+	// networkManagerConnectionDir holds NetworkManager keyfiles.
+	// networkManagerConnectionDir 存放 NetworkManager keyfile。
+	networkManagerConnectionDir = "/etc/NetworkManager/system-connections"
+	// ifcfgDir holds RHEL ifcfg files.
+	// ifcfgDir 存放 RHEL ifcfg 文件。
+	ifcfgDir = "/etc/sysconfig/network-scripts"
+	// networkRevertUnit is the transient systemd unit that restores the previous configuration unless it is cancelled.
+	// networkRevertUnit 是恢复先前配置的临时 systemd 单元，除非被取消。
+	networkRevertUnit = "chasi-bod-network-revert"
+	// networkApplyUnit is the transient systemd unit that applies a new configuration, detached from the SSH session it may cut.
+	// networkApplyUnit 是应用新配置的临时 systemd 单元，与其可能切断的 SSH 会话分离。
+	networkApplyUnit = "chasi-bod-network-apply"
+	// managedFileHeader starts every file chasi-bod renders for a network stack.
+	// managedFileHeader 是 chasi-bod 为网络栈渲染的每个文件的开头。
+	managedFileHeader = "# Managed by chasi-bod. Do not edit.\n"
+)
+
+// networkStateDir holds the backup, revert script and apply status of a network change.
+// networkStateDir 存放网络更改的备份、恢复脚本和应用状态。
+var networkStateDir = path.Join(constants.DefaultDataDir, "network")
+
+// Timing of the safe apply. The revert fires after networkRevertTimeout unless the deployer reconnects,
+// verifies the node and cancels it within networkConfirmTimeout. Variables so tests can shorten them.
+// 安全应用的时间设置。除非 deployer 在 networkConfirmTimeout 内重新连接、验证节点并取消，否则恢复会在 networkRevertTimeout 后触发。
+// 使用变量以便测试可以缩短它们。
+var (
+	networkRevertTimeout  = 3 * time.Minute
+	networkConfirmTimeout = 2 * time.Minute
+	networkPollInterval   = 2 * time.Second
+)
+
+// networkFile is a configuration file rendered for a network stack.
+// networkFile 是为网络栈渲染的配置文件。
+type networkFile struct {
+	Path    string
+	Content string
+	Mode    os.FileMode
+}
+
+// effectiveNetwork returns the interfaces and resolver configuration of a node.
+// effectiveNetwork 返回节点的接口和解析器配置。
+// Node interfaces replace cluster interfaces of the same name, and node DNS replaces cluster DNS.
+// 节点接口替换同名的集群接口，节点 DNS 替换集群 DNS。
+func effectiveNetwork(nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]types.InterfaceConfig, *types.DNSConfig) {
+	byName := make(map[string]types.InterfaceConfig)
+	for _, iface := range nodeCfg.Interfaces {
+		byName[iface.Name] = iface
+	}
+	var ifaces []types.InterfaceConfig
+	for _, iface := range clusterCfg.Network.Interfaces {
+		if override, ok := byName[iface.Name]; ok {
+			iface = override
+			delete(byName, iface.Name)
+		}
+		ifaces = append(ifaces, iface)
+	}
+	for _, iface := range nodeCfg.Interfaces {
+		if _, ok := byName[iface.Name]; ok {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	dns := clusterCfg.Network.DNS
+	if nodeCfg.DNS != nil {
+		dns = nodeCfg.DNS
+	}
+	return ifaces, dns
+}
+
+// detectNetworkRenderer picks the network stack of a node from its /etc/os-release.
+// detectNetworkRenderer 根据节点的 /etc/os-release 选择其网络栈。
+// Ubuntu uses netplan; the RHEL family uses ifcfg files up to release 8 and NetworkManager keyfiles from 9 on, as does Fedora.
+// Ubuntu 使用 netplan；RHEL 系列在 8 及以下版本使用 ifcfg 文件，从 9 开始与 Fedora 一样使用 NetworkManager keyfile。
+func detectNetworkRenderer(release map[string]string) (enum.NetworkRenderer, error) {
+	family := strings.Fields(release["ID"] + " " + release["ID_LIKE"])
+	has := func(ids ...string) bool {
+		for _, id := range family {
+			for _, want := range ids {
+				if id == want {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case has("ubuntu"):
+		return enum.NetworkRendererNetplan, nil
+	case release["ID"] == "fedora":
+		return enum.NetworkRendererNetworkManager, nil
+	case has("rhel", "centos", "fedora"):
+		major, _ := strconv.Atoi(strings.SplitN(release["VERSION_ID"], ".", 2)[0])
+		if major >= 9 {
+			return enum.NetworkRendererNetworkManager, nil
+		}
+		return enum.NetworkRendererIfcfg, nil
+	default:
+		return "", errors.New(errors.ErrTypeNotImplemented, fmt.Sprintf("no network renderer for OS %q; set network.renderer", release["ID"]))
+	}
+}
+
+// renderNetworkFiles renders the configuration files of a network stack for the interfaces.
+// renderNetworkFiles 为接口渲染网络栈的配置文件。
+func renderNetworkFiles(renderer enum.NetworkRenderer, ifaces []types.InterfaceConfig, dns *types.DNSConfig) ([]networkFile, error) {
+	switch renderer {
+	case enum.NetworkRendererNetplan:
+		content, err := renderNetplan(ifaces, dns)
+		if err != nil {
+			return nil, err
+		}
+		// netplan warns about world-readable files.
+		// netplan 会对所有人可读的文件发出警告。
+		return []networkFile{{Path: netplanConfigPath, Content: content, Mode: 0600}}, nil
+	case enum.NetworkRendererNetworkManager:
+		files := make([]networkFile, 0, len(ifaces))
+		for _, iface := range ifaces {
+			// NetworkManager ignores keyfiles readable by other users.
+			// NetworkManager 会忽略其他用户可读的 keyfile。
+			files = append(files, networkFile{Path: keyfilePath(iface.Name), Content: renderKeyfile(iface, dns), Mode: 0600})
+		}
+		return files, nil
+	case enum.NetworkRendererIfcfg:
+		var files []networkFile
+		for _, iface := range ifaces {
+			files = append(files, networkFile{Path: path.Join(ifcfgDir, "ifcfg-"+iface.Name), Content: renderIfcfg(iface, dns), Mode: 0644})
+			routes4, routes6 := renderIfcfgRoutes(iface)
+			if routes4 != "" {
+				files = append(files, networkFile{Path: path.Join(ifcfgDir, "route-"+iface.Name), Content: routes4, Mode: 0644})
+			}
+			if routes6 != "" {
+				files = append(files, networkFile{Path: path.Join(ifcfgDir, "route6-"+iface.Name), Content: routes6, Mode: 0644})
+			}
+		}
+		return files, nil
+	default:
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported network renderer %q", renderer))
+	}
+}
+
+// netplanConfig is the netplan YAML document.
+// netplanConfig 是 netplan YAML 文档。
+type netplanConfig struct {
+	Network struct {
+		Version   int                         `yaml:"version"`
+		Ethernets map[string]netplanInterface `yaml:"ethernets"`
+	} `yaml:"network"`
+}
+
+// netplanInterface is the netplan configuration of one ethernet interface.
+// netplanInterface 是单个以太网接口的 netplan 配置。
+type netplanInterface struct {
+	DHCP4       bool                `yaml:"dhcp4"`
+	Addresses   []string            `yaml:"addresses,omitempty"`
+	MTU         int                 `yaml:"mtu,omitempty"`
+	Routes      []netplanRoute      `yaml:"routes,omitempty"`
+	Nameservers *netplanNameservers `yaml:"nameservers,omitempty"`
+}
+
+// netplanRoute is a netplan route.
+// netplanRoute 是 netplan 路由。
+type netplanRoute struct {
+	To     string `yaml:"to"`
+	Via    string `yaml:"via"`
+	Metric int    `yaml:"metric,omitempty"`
+}
+
+// netplanNameservers is the netplan resolver configuration.
+// netplanNameservers 是 netplan 解析器配置。
+type netplanNameservers struct {
+	Addresses []string `yaml:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty"`
+}
+
+// renderNetplan renders all interfaces as a single netplan file. The gateway becomes a default route.
+// renderNetplan 将所有接口渲染为单个 netplan 文件。网关成为默认路由。
+func renderNetplan(ifaces []types.InterfaceConfig, dns *types.DNSConfig) (string, error) {
+	var doc netplanConfig
+	doc.Network.Version = 2
+	doc.Network.Ethernets = make(map[string]netplanInterface, len(ifaces))
+	for _, iface := range ifaces {
+		entry := netplanInterface{DHCP4: iface.DHCP, Addresses: iface.IPAddrs, MTU: iface.MTU}
+		if iface.Gateway != "" {
+			entry.Routes = append(entry.Routes, netplanRoute{To: "default", Via: iface.Gateway})
+		}
+		for _, route := range iface.Routes {
+			entry.Routes = append(entry.Routes, netplanRoute{To: route.To, Via: route.Via, Metric: route.Metric})
+		}
+		if dns != nil {
+			entry.Nameservers = &netplanNameservers{Addresses: dns.Nameservers, Search: dns.Search}
+		}
+		doc.Network.Ethernets[iface.Name] = entry
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeInternal, "failed to render the netplan configuration", err)
+	}
+	return managedFileHeader + string(out), nil
+}
+
+// keyfileConnection returns the NetworkManager connection name chasi-bod uses for an interface.
+// keyfileConnection 返回 chasi-bod 为接口使用的 NetworkManager 连接名称。
+func keyfileConnection(name string) string {
+	return "chasi-bod-" + name
+}
+
+// keyfilePath returns the NetworkManager keyfile of an interface.
+// keyfilePath 返回接口的 NetworkManager keyfile。
+func keyfilePath(name string) string {
+	return path.Join(networkManagerConnectionDir, keyfileConnection(name)+".nmconnection")
+}
+
+// splitFamilies splits addresses into IPv4 and IPv6 ones; entries may carry a prefix length.
+// splitFamilies 将地址分为 IPv4 和 IPv6；条目可以带有前缀长度。
+func splitFamilies(addrs []string) (v4, v6 []string) {
+	for _, addr := range addrs {
+		if isIPv6(addr) {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+	return v4, v6
+}
+
+// isIPv6 reports whether an address or CIDR is IPv6.
+// isIPv6 报告地址或 CIDR 是否为 IPv6。
+func isIPv6(addr string) bool {
+	host, _, _ := strings.Cut(addr, "/")
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+// renderKeyfile renders the NetworkManager keyfile of an interface.
+// renderKeyfile 渲染接口的 NetworkManager keyfile。
+// The high autoconnect priority makes NetworkManager prefer it over the profile the installer created.
+// 较高的自动连接优先级使 NetworkManager 优先选择它，而不是安装程序创建的配置文件。
+func renderKeyfile(iface types.InterfaceConfig, dns *types.DNSConfig) string {
+	var b strings.Builder
+	b.WriteString(managedFileHeader)
+	fmt.Fprintf(&b, "[connection]\nid=%s\ntype=ethernet\ninterface-name=%s\nautoconnect=true\nautoconnect-priority=100\n", keyfileConnection(iface.Name), iface.Name)
+	if iface.MTU > 0 {
+		fmt.Fprintf(&b, "\n[ethernet]\nmtu=%d\n", iface.MTU)
+	}
+
+	v4, v6 := splitFamilies(iface.IPAddrs)
+	var dns4, dns6 []string
+	if dns != nil {
+		dns4, dns6 = splitFamilies(dns.Nameservers)
+	}
+	var routes4, routes6 []types.RouteConfig
+	for _, route := range iface.Routes {
+		if isIPv6(route.To) {
+			routes6 = append(routes6, route)
+		} else {
+			routes4 = append(routes4, route)
+		}
+	}
+	section := func(name string, addrs, nameservers []string, routes []types.RouteConfig, gatewayFamily bool, dhcp bool, unusedMethod string) {
+		method := "manual"
+		switch {
+		case dhcp:
+			method = "auto"
+		case len(addrs) == 0:
+			method = unusedMethod
+		}
+		fmt.Fprintf(&b, "\n[%s]\nmethod=%s\n", name, method)
+		for i, addr := range addrs {
+			fmt.Fprintf(&b, "address%d=%s\n", i+1, addr)
+		}
+		if gatewayFamily {
+			fmt.Fprintf(&b, "gateway=%s\n", iface.Gateway)
+		}
+		for i, route := range routes {
+			line := route.To + "," + route.Via
+			if route.Metric > 0 {
+				line += "," + strconv.Itoa(route.Metric)
+			}
+			fmt.Fprintf(&b, "route%d=%s\n", i+1, line)
+		}
+		if method == "manual" || method == "auto" {
+			if len(nameservers) > 0 {
+				fmt.Fprintf(&b, "dns=%s;\n", strings.Join(nameservers, ";"))
+			}
+			if dns != nil && len(dns.Search) > 0 {
+				fmt.Fprintf(&b, "dns-search=%s;\n", strings.Join(dns.Search, ";"))
+			}
+		}
+	}
+	gateway6 := iface.Gateway != "" && isIPv6(iface.Gateway)
+	section("ipv4", v4, dns4, routes4, iface.Gateway != "" && !gateway6, iface.DHCP, "disabled")
+	section("ipv6", v6, dns6, routes6, gateway6, false, "ignore")
+	return b.String()
+}
+
+// renderIfcfg renders the ifcfg file of an interface.
+// renderIfcfg 渲染接口的 ifcfg 文件。
+func renderIfcfg(iface types.InterfaceConfig, dns *types.DNSConfig) string {
+	var b strings.Builder
+	b.WriteString(managedFileHeader)
+	fmt.Fprintf(&b, "DEVICE=%s\nNAME=%s\nTYPE=Ethernet\nONBOOT=yes\n", iface.Name, keyfileConnection(iface.Name))
+	if iface.DHCP {
+		b.WriteString("BOOTPROTO=dhcp\n")
+	} else {
+		b.WriteString("BOOTPROTO=none\n")
+	}
+	v4, v6 := splitFamilies(iface.IPAddrs)
+	for i, addr := range v4 {
+		ip, prefix, _ := strings.Cut(addr, "/")
+		fmt.Fprintf(&b, "IPADDR%d=%s\n", i, ip)
+		if prefix != "" {
+			fmt.Fprintf(&b, "PREFIX%d=%s\n", i, prefix)
+		}
+	}
+	if iface.Gateway != "" && !isIPv6(iface.Gateway) {
+		fmt.Fprintf(&b, "GATEWAY=%s\nDEFROUTE=yes\n", iface.Gateway)
+	}
+	if len(v6) > 0 {
+		fmt.Fprintf(&b, "IPV6INIT=yes\nIPV6ADDR=%s\n", v6[0])
+		if len(v6) > 1 {
+			fmt.Fprintf(&b, "IPV6ADDR_SECONDARIES=%q\n", strings.Join(v6[1:], " "))
+		}
+	}
+	if iface.Gateway != "" && isIPv6(iface.Gateway) {
+		fmt.Fprintf(&b, "IPV6_DEFAULTGW=%s\n", iface.Gateway)
+	}
+	if iface.MTU > 0 {
+		fmt.Fprintf(&b, "MTU=%d\n", iface.MTU)
+	}
+	if dns != nil {
+		for i, server := range dns.Nameservers {
+			fmt.Fprintf(&b, "DNS%d=%s\n", i+1, server)
+		}
+		if len(dns.Search) > 0 {
+			fmt.Fprintf(&b, "DOMAIN=%q\n", strings.Join(dns.Search, " "))
+		}
+	}
+	return b.String()
+}
+
+// renderIfcfgRoutes renders the route- and route6- files of an interface; empty strings mean no routes of that family.
+// renderIfcfgRoutes 渲染接口的 route- 和 route6- 文件；空字符串表示该地址族没有路由。
+func renderIfcfgRoutes(iface types.InterfaceConfig) (string, string) {
+	var v4, v6 strings.Builder
+	for _, route := range iface.Routes {
+		b := &v4
+		if isIPv6(route.To) {
+			b = &v6
+		}
+		if b.Len() == 0 {
+			b.WriteString(managedFileHeader)
+		}
+		fmt.Fprintf(b, "%s via %s", route.To, route.Via)
+		if route.Metric > 0 {
+			fmt.Fprintf(b, " metric %d", route.Metric)
+		}
+		b.WriteString("\n")
+	}
+	return v4.String(), v6.String()
+}
+
+// networkApplyCommand returns the command that makes a network stack pick up the rendered files.
+// networkApplyCommand 返回使网络栈加载已渲染文件的命令。
+// When reverting, the profiles chasi-bod removed are gone, so the interfaces are reconnected
+// with whatever profile NetworkManager now prefers instead of being brought up by name.
+// 恢复时，chasi-bod 移除的配置文件已不存在，因此接口会以 NetworkManager 当前首选的配置文件重新连接，而不是按名称启用。
+func networkApplyCommand(renderer enum.NetworkRenderer, ifaces []types.InterfaceConfig, revert bool) string {
+	names := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		names[i] = executor.ShellQuote(iface.Name)
+	}
+	nmcli := func() string {
+		if revert {
+			return fmt.Sprintf(`nmcli connection reload && for dev in %s; do nmcli device connect "$dev"; done`, strings.Join(names, " "))
+		}
+		up := make([]string, len(ifaces))
+		for i, iface := range ifaces {
+			up[i] = "nmcli connection up " + executor.ShellQuote(keyfileConnection(iface.Name))
+		}
+		return "nmcli connection reload && " + strings.Join(up, " && ")
+	}
+	switch renderer {
+	case enum.NetworkRendererNetplan:
+		return "netplan generate && netplan apply"
+	case enum.NetworkRendererIfcfg:
+		// RHEL 8 reads ifcfg files through NetworkManager; RHEL 7 may still run the legacy network service.
+		// RHEL 8 通过 NetworkManager 读取 ifcfg 文件；RHEL 7 可能仍在运行旧版 network 服务。
+		return fmt.Sprintf(`if systemctl is-active -q NetworkManager; then %s; else for dev in %s; do ifdown "$dev"; ifup "$dev" || exit 1; done; fi`, nmcli(), strings.Join(names, " "))
+	default:
+		return nmcli()
+	}
+}
+
+// networkCheckCommand verifies the node after an apply: every static address is assigned, DHCP interfaces have an IPv4 address,
+// and every gateway answers.
+// networkCheckCommand 在应用后验证节点：每个静态地址都已分配，DHCP 接口有 IPv4 地址，且每个网关都有响应。
+func networkCheckCommand(ifaces []types.InterfaceConfig) string {
+	checks := []string{"true"}
+	for _, iface := range ifaces {
+		name := executor.ShellQuote(iface.Name)
+		for _, addr := range iface.IPAddrs {
+			checks = append(checks, fmt.Sprintf("ip -o addr show dev %s | grep -qF %s", name, executor.ShellQuote(" "+canonicalPrefix(addr)+" ")))
+		}
+		if iface.DHCP {
+			checks = append(checks, fmt.Sprintf("ip -o -4 addr show dev %s | grep -q inet", name))
+		}
+		if iface.Gateway != "" {
+			checks = append(checks, fmt.Sprintf("ping -c 3 -W 2 %s >/dev/null", executor.ShellQuote(iface.Gateway)))
+		}
+	}
+	return strings.Join(checks, " && ")
+}
+
+// canonicalPrefix writes an address with prefix length the way `ip addr` prints it, e.g. "fd00:0:0::0001/64" as
+// "fd00::1/64"; addresses that do not parse are returned unchanged.
+// canonicalPrefix 按 `ip addr` 的输出格式书写带前缀长度的地址，例如将 "fd00:0:0::0001/64" 写为 "fd00::1/64"；无法解析的地址原样返回。
+func canonicalPrefix(addr string) string {
+	prefix, err := netip.ParsePrefix(addr)
+	if err != nil {
+		return addr
+	}
+	return prefix.String()
+}
+
+// networkRevertScript restores the files saved by networkBackupCommand, deletes those that did not exist,
+// and applies the result.
+// networkRevertScript 恢复 networkBackupCommand 保存的文件，删除之前不存在的文件，并应用结果。
+func networkRevertScript(renderer enum.NetworkRenderer, files []networkFile, ifaces []types.InterfaceConfig) string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n" + managedFileHeader)
+	b.WriteString("# Restores the network configuration from before the last chasi-bod change.\n")
+	fmt.Fprintf(&b, "backup=%s\n", executor.ShellQuote(path.Join(networkStateDir, "backup")))
+	b.WriteString(`restore() { if [ -e "$backup/$1" ]; then cp -a "$backup/$1" "$2"; else rm -f "$2"; fi; }` + "\n")
+	for i, file := range files {
+		fmt.Fprintf(&b, "restore %d %s\n", i, executor.ShellQuote(file.Path))
+	}
+	b.WriteString(networkApplyCommand(renderer, ifaces, true) + "\n")
+	return b.String()
+}
+
+// networkBackupCommand saves the current version of every file that is about to change.
+// networkBackupCommand 保存每个即将更改的文件的当前版本。
+func networkBackupCommand(files []networkFile) string {
+	backup := executor.ShellQuote(path.Join(networkStateDir, "backup"))
+	cmds := []string{fmt.Sprintf("rm -rf %[1]s && mkdir -p %[1]s", backup)}
+	for i, file := range files {
+		p := executor.ShellQuote(file.Path)
+		cmds = append(cmds, fmt.Sprintf("if [ -e %s ]; then cp -a %s %s/%d; fi", p, p, backup, i))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// applyHostNetwork writes the rendered files and applies them with an automatic revert.
+// applyHostNetwork 写入渲染后的文件并在自动恢复的保护下应用它们。
+// A revert is scheduled on the node first; the new configuration is then applied in the background,
+// the deployer reconnects and checks the node, and only then cancels the revert.
+// If the node cannot be reached or the checks fail, the previous configuration comes back by itself.
+// 首先在节点上安排恢复；然后在后台应用新配置，deployer 重新连接并检查节点，之后才取消恢复。
+// 如果无法访问节点或检查失败，先前的配置会自动恢复。
+// Returns the executor to keep using, which is a new connection once the network was changed.
+// 返回要继续使用的执行器；网络更改后它是一个新连接。
+func (p *DefaultNetworkConfigPhase) applyHostNetwork(ctx context.Context, exec executor.NodeExecutor, nodeCfg *model.NodeConfig, renderer enum.NetworkRenderer, files []networkFile, ifaces []types.InterfaceConfig) (executor.NodeExecutor, error) {
+	description := fmt.Sprintf("Configure %d host network interface(s) with %s", len(ifaces), renderer)
+	read := plan.NodeFileReader(exec)
+	var changed []networkFile
+	for _, file := range files {
+		current, mode, exists, err := read(ctx, file.Path)
+		if err != nil {
+			return exec, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to read %s on %s", file.Path, nodeCfg.Address), err)
+		}
+		if !exists || current != file.Content || mode != file.Mode.Perm() {
+			changed = append(changed, file)
+		}
+	}
+	if len(changed) == 0 {
+		plan.Record(ctx, description, plan.StatusUnchanged, nil)
+		return exec, nil
+	}
+
+	stateDir := executor.ShellQuote(networkStateDir)
+	revertScript := path.Join(networkStateDir, "revert.sh")
+	statusFile := path.Join(networkStateDir, "apply.status")
+	prepare := []plan.Action{
+		plan.SudoCommand("Clear a previous network revert", fmt.Sprintf("systemctl stop %[1]s.timer %[1]s.service 2>/dev/null; systemctl reset-failed %[1]s.service %[2]s.service 2>/dev/null; mkdir -p %[3]s && rm -f %[4]s",
+			networkRevertUnit, networkApplyUnit, stateDir, executor.ShellQuote(statusFile))),
+		plan.File("Write the network revert script", revertScript, networkRevertScript(renderer, files, ifaces), 0700),
+		plan.SudoCommand("Back up the network configuration", networkBackupCommand(files)),
+		plan.SudoCommand("Schedule the network revert", fmt.Sprintf("systemd-run --unit=%s --on-active=%ds /bin/sh %s",
+			networkRevertUnit, int(networkRevertTimeout.Seconds()), executor.ShellQuote(revertScript))),
+	}
+	for _, file := range changed {
+		prepare = append(prepare, plan.File("Write "+file.Path, file.Path, file.Content, file.Mode))
+	}
+	// The apply may cut the SSH session, so it runs as its own unit and leaves its exit status behind.
+	// 应用可能会切断 SSH 会话，因此它作为独立单元运行并留下其退出状态。
+	prepare = append(prepare, plan.SudoCommand("Apply the network configuration", fmt.Sprintf("systemd-run --unit=%s --collect /bin/sh -c %s",
+		networkApplyUnit, executor.ShellQuote(fmt.Sprintf("%s; echo $? > %s", networkApplyCommand(renderer, ifaces, false), statusFile)))))
+	if err := plan.Apply(ctx, exec, prepare); err != nil {
+		return exec, err
+	}
+	exec.Close()
+
+	exec, err := p.confirmHostNetwork(ctx, nodeCfg, statusFile, networkCheckCommand(ifaces))
+	if err != nil {
+		plan.Record(ctx, description, plan.StatusFailed, err)
+		if exec != nil {
+			// Still reachable: revert now instead of waiting for the timer.
+			// 仍可访问：立即恢复，而不是等待定时器。
+			if _, revertErr := executor.RunCommand(ctx, exec, fmt.Sprintf("systemctl stop %[1]s.timer; systemd-run --unit=%[1]s-now --collect /bin/sh %[2]s",
+				networkRevertUnit, executor.ShellQuote(revertScript)), executor.WithSudo()); revertErr != nil {
+				utils.GetLogger().Printf("Warning: failed to revert the network configuration of %s now, the scheduled revert will: %v", nodeCfg.Address, revertErr)
+			}
+		}
+		return exec, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("network configuration of %s failed its checks and is being reverted", nodeCfg.Address), err)
+	}
+	if _, err := executor.RunCommand(ctx, exec, fmt.Sprintf("systemctl stop %s.timer && rm -rf %s", networkRevertUnit, executor.ShellQuote(path.Join(networkStateDir, "backup"))), executor.WithSudo()); err != nil {
+		return exec, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to cancel the network revert on %s", nodeCfg.Address), err)
+	}
+	plan.Record(ctx, description, plan.StatusChanged, nil)
+	return exec, nil
+}
+
+// confirmHostNetwork reconnects to the node until the background apply has finished, then runs the checks.
+// confirmHostNetwork 重新连接节点直到后台应用完成，然后运行检查。
+// Returns the new connection (nil if the node could not be reached) and an error if the apply or the checks failed.
+// 返回新连接（如果无法访问节点则为 nil），如果应用或检查失败则返回错误。
+func (p *DefaultNetworkConfigPhase) confirmHostNetwork(ctx context.Context, nodeCfg *model.NodeConfig, statusFile, check string) (executor.NodeExecutor, error) {
+	deadline := time.Now().Add(networkConfirmTimeout)
+	var lastErr error
+	for {
+		if time.Now().After(deadline) {
+			if lastErr == nil {
+				lastErr = errors.New(errors.ErrTypeTimeout, "the network configuration was not applied in time")
+			}
+			return nil, lastErr
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(networkPollInterval):
+		}
+
+		exec, err := connectNode(ctx, p.newExecutor, nodeCfg)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		status, err := executor.RunCommand(ctx, exec, "cat "+executor.ShellQuote(statusFile)+" 2>/dev/null || true", executor.WithSudo())
+		switch {
+		case err != nil:
+			lastErr = err
+			exec.Close()
+			continue
+		case status == "":
+			exec.Close()
+			continue
+		case status != "0":
+			return exec, errors.New(errors.ErrTypeSystem, fmt.Sprintf("applying the network configuration exited with status %s", status))
+		}
+		if _, err := executor.RunCommand(ctx, exec, check, executor.WithSudo()); err != nil {
+			return exec, errors.NewWithCause(errors.ErrTypeNetwork, "the node failed its connectivity checks", err)
+		}
+		return exec, nil
+	}
+}
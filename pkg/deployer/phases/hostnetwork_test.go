@@ -0,0 +1,192 @@
+package phases
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/common/types"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// testInterfaces returns a dual-stack static interface with routes and a DHCP interface.
+// testInterfaces 返回一个带路由的双栈静态接口和一个 DHCP 接口。
+func testInterfaces() ([]types.InterfaceConfig, *types.DNSConfig) {
+	return []types.InterfaceConfig{
+		{
+			Name:    "eth0",
+			IPAddrs: []string{"192.168.10.11/24", "fd00:10::11/64"},
+			Gateway: "192.168.10.1",
+			MTU:     9000,
+			Routes: []types.RouteConfig{
+				{To: "10.20.0.0/16", Via: "192.168.10.254", Metric: 100},
+				{To: "fd00:20::/48", Via: "fd00:10::fe"},
+			},
+		},
+		{Name: "eth1", DHCP: true},
+	}, &types.DNSConfig{
+		Nameservers: []string{"192.168.10.53", "fd00:10::53"},
+		Search:      []string{"dc1.example.com", "example.com"},
+	}
+}
+
+func TestEffectiveNetwork(t *testing.T) {
+	clusterCfg := &model.ClusterConfig{Network: types.NetworkConfig{
+		Interfaces: []types.InterfaceConfig{{Name: "eth0", DHCP: true}, {Name: "eth1", DHCP: true}},
+		DNS:        &types.DNSConfig{Nameservers: []string{"1.1.1.1"}},
+	}}
+	nodeCfg := &model.NodeConfig{Interfaces: []types.InterfaceConfig{{Name: "eth2", IPAddrs: []string{"10.0.0.2/24"}}, {Name: "eth1", IPAddrs: []string{"10.1.0.2/24"}}}}
+
+	ifaces, dns := effectiveNetwork(nodeCfg, clusterCfg)
+	assert.Equal(t, []types.InterfaceConfig{
+		{Name: "eth0", DHCP: true},
+		{Name: "eth1", IPAddrs: []string{"10.1.0.2/24"}},
+		{Name: "eth2", IPAddrs: []string{"10.0.0.2/24"}},
+	}, ifaces)
+	assert.Equal(t, clusterCfg.Network.DNS, dns)
+
+	nodeCfg.DNS = &types.DNSConfig{Nameservers: []string{"10.0.0.53"}}
+	_, dns = effectiveNetwork(nodeCfg, clusterCfg)
+	assert.Equal(t, nodeCfg.DNS, dns)
+}
+
+func TestDetectNetworkRenderer(t *testing.T) {
+	for release, want := range map[string]enum.NetworkRenderer{
+		"ID=ubuntu\nVERSION_ID=\"22.04\"":                                  enum.NetworkRendererNetplan,
+		"ID=linuxmint\nID_LIKE=\"ubuntu debian\"":                          enum.NetworkRendererNetplan,
+		"ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nVERSION_ID=\"9.3\"": enum.NetworkRendererNetworkManager,
+		"ID=\"centos\"\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"7\"":         enum.NetworkRendererIfcfg,
+		"ID=\"rhel\"\nID_LIKE=\"fedora\"\nVERSION_ID=\"8.9\"":              enum.NetworkRendererIfcfg,
+		"ID=fedora\nVERSION_ID=40":                                         enum.NetworkRendererNetworkManager,
+	} {
+		got, err := detectNetworkRenderer(executor.ParseOSRelease(release))
+		require.NoError(t, err, release)
+		assert.Equal(t, want, got, release)
+	}
+	_, err := detectNetworkRenderer(executor.ParseOSRelease("ID=alpine"))
+	assert.Error(t, err)
+}
+
+func TestRenderNetworkFiles(t *testing.T) {
+	ifaces, dns := testInterfaces()
+
+	files, err := renderNetworkFiles(enum.NetworkRendererNetplan, ifaces, dns)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, netplanConfigPath, files[0].Path)
+	assertGolden(t, "netplan.yaml", files[0].Content)
+
+	files, err = renderNetworkFiles(enum.NetworkRendererNetworkManager, ifaces, dns)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "/etc/NetworkManager/system-connections/chasi-bod-eth0.nmconnection", files[0].Path)
+	assert.EqualValues(t, 0600, files[0].Mode)
+	assertGolden(t, "chasi-bod-eth0.nmconnection", files[0].Content)
+	assertGolden(t, "chasi-bod-eth1.nmconnection", files[1].Content)
+
+	files, err = renderNetworkFiles(enum.NetworkRendererIfcfg, ifaces, dns)
+	require.NoError(t, err)
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.Path
+		assertGolden(t, path.Base(file.Path), file.Content)
+	}
+	assert.Equal(t, []string{
+		"/etc/sysconfig/network-scripts/ifcfg-eth0",
+		"/etc/sysconfig/network-scripts/route-eth0",
+		"/etc/sysconfig/network-scripts/route6-eth0",
+		"/etc/sysconfig/network-scripts/ifcfg-eth1",
+	}, paths)
+}
+
+func TestNetworkConfigPhase_SafeApply(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	networkPollInterval = time.Millisecond
+	handleFreshNode(server)
+	server.HandleOutput("cat /etc/os-release", "ID=ubuntu\nVERSION_ID=\"24.04\"\n", 0)
+	server.HandleOutput("cat /var/lib/chasi-bod/network/apply.status", "0\n", 0)
+	nodeCfg.Interfaces = []types.InterfaceConfig{{Name: "eth0", IPAddrs: []string{"192.168.10.11/24"}, Gateway: "192.168.10.1"}}
+	clusterCfg := &model.ClusterConfig{}
+
+	report := plan.NewReport()
+	ctx := plan.WithReporter(context.Background(), report, nodeCfg.Address, "network")
+	require.NoError(t, NewNetworkConfigPhase(nil).Run(ctx, &nodeCfg, clusterCfg))
+
+	commands := server.Commands()
+	revertIdx := commandIndex(commands, "systemd-run --unit=chasi-bod-network-revert --on-active=180s /bin/sh /var/lib/chasi-bod/network/revert.sh")
+	require.NotEqual(t, -1, revertIdx)
+	assert.Less(t, commandIndex(commands, "cp -a /etc/netplan/90-chasi-bod.yaml /var/lib/chasi-bod/network/backup/0"), revertIdx, "the backup exists before the revert is armed")
+	assert.Less(t, revertIdx, commandIndex(commands, "cat > /etc/netplan/90-chasi-bod.yaml"))
+	applyIdx := commandIndex(commands, "systemd-run --unit=chasi-bod-network-apply --collect /bin/sh -c 'netplan generate && netplan apply;")
+	assert.Less(t, commandIndex(commands, "cat > /etc/netplan/90-chasi-bod.yaml"), applyIdx)
+	checkIdx := commandIndex(commands, "ip -o addr show dev eth0 | grep -qF ' 192.168.10.11/24 ' && ping -c 3 -W 2 192.168.10.1")
+	assert.Less(t, applyIdx, checkIdx)
+	assert.Less(t, checkIdx, commandIndex(commands, "systemctl stop chasi-bod-network-revert.timer && rm -rf"), "the revert is only cancelled after the checks pass")
+	assert.Contains(t, uploadedContent(server, "/var/lib/chasi-bod/network/revert.sh"), "restore 0 /etc/netplan/90-chasi-bod.yaml\nnetplan generate && netplan apply\n")
+	assert.Equal(t, 0, report.Summary().Failed)
+
+	// A converged node is left alone.
+	// 已收敛的节点不会被改动。
+	rendered := uploadedContent(server, netplanConfigPath)
+	server.HandleFile(netplanConfigPath, rendered, 0600)
+	before := len(server.Commands())
+	require.NoError(t, NewNetworkConfigPhase(nil).Run(ctx, &nodeCfg, clusterCfg))
+	assert.Equal(t, -1, commandIndex(server.Commands()[before:], "systemd-run"))
+
+	// netplan warns about a world-readable file, so a mode change alone is applied.
+	// netplan 会对全局可读的文件发出警告，因此仅模式变化也会被应用。
+	server.HandleFile(netplanConfigPath, rendered, 0644)
+	before = len(server.Commands())
+	require.NoError(t, NewNetworkConfigPhase(nil).Run(ctx, &nodeCfg, clusterCfg))
+	assert.NotEqual(t, -1, commandIndex(server.Commands()[before:], "systemd-run"))
+}
+
+func TestNetworkCheckCommand_CanonicalAddresses(t *testing.T) {
+	ifaces := []types.InterfaceConfig{{Name: "eth0", IPAddrs: []string{"192.168.10.11/24", "FD00:0:0:0::0010/64"}}}
+	assert.Equal(t, "true && ip -o addr show dev eth0 | grep -qF ' 192.168.10.11/24 ' && ip -o addr show dev eth0 | grep -qF ' fd00::10/64 '",
+		networkCheckCommand(ifaces), "addresses are matched as ip addr prints them")
+}
+
+func TestNetworkConfigPhase_RevertsFailedApply(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	networkPollInterval = time.Millisecond
+	handleFreshNode(server)
+	server.HandleOutput("cat /var/lib/chasi-bod/network/apply.status", "0\n", 0)
+	server.HandleOutput("ping -c 3", "", 1)
+	nodeCfg.Interfaces = []types.InterfaceConfig{{Name: "eth0", IPAddrs: []string{"192.168.10.11/24"}, Gateway: "192.168.10.1"}}
+	clusterCfg := &model.ClusterConfig{Network: types.NetworkConfig{Renderer: enum.NetworkRendererNetworkManager}}
+
+	err := NewNetworkConfigPhase(nil).Run(context.Background(), &nodeCfg, clusterCfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is being reverted")
+	commands := server.Commands()
+	assert.Equal(t, -1, commandIndex(commands, "cat /etc/os-release"), "a configured renderer is not detected")
+	assert.NotEqual(t, -1, commandIndex(commands, "nmcli connection reload && nmcli connection up chasi-bod-eth0"))
+	assert.NotEqual(t, -1, commandIndex(commands, "systemd-run --unit=chasi-bod-network-revert-now"))
+	assert.Equal(t, -1, commandIndex(commands, "systemctl stop chasi-bod-network-revert.timer && rm -rf"), "the backup is kept for the revert")
+}
+
+func TestNetworkConfigPhase_Plan(t *testing.T) {
+	nodeCfg := &model.NodeConfig{Interfaces: []types.InterfaceConfig{{Name: "eth0", DHCP: true}}}
+	actions, err := NewNetworkConfigPhase(nil).Plan(context.Background(), nodeCfg, &model.ClusterConfig{})
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.Equal(t, plan.ActionOpaque, actions[0].Kind, "the network stack is detected on the node")
+
+	actions, err = NewNetworkConfigPhase(nil).Plan(context.Background(), nodeCfg, &model.ClusterConfig{Network: types.NetworkConfig{Renderer: enum.NetworkRendererIfcfg}})
+	require.NoError(t, err)
+	require.Len(t, actions, 2)
+	assert.Equal(t, "/etc/sysconfig/network-scripts/ifcfg-eth0", actions[0].Path)
+	assert.Contains(t, actions[1].Command, "nmcli connection up chasi-bod-eth0")
+
+	actions, err = NewNetworkConfigPhase(nil).Plan(context.Background(), &model.NodeConfig{}, &model.ClusterConfig{})
+	require.NoError(t, err)
+	assert.Empty(t, actions)
+}
@@ -6,13 +6,16 @@ package phases
 
 import (
 	"context"
-	"time"
+	"fmt"
 
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
 	"github.com/turtacn/chasi-bod/pkg/config/model"
-	// Assuming you have an SSH client utility
-	// 假设您有一个 SSH 客户端工具
-	// "github.com/turtacn/chasi-bod/pkg/sshutil"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+	"github.com/turtacn/chasi-bod/pkg/deployer/preflight"
 )
 
 // InitPhase defines the interface for the initial node preparation phase.
@@ -20,6 +23,19 @@ import (
 // This phase is responsible for checking SSH connectivity, validating basic node requirements, etc.
 // 此阶段负责检查 SSH 连接、验证基本节点要求等。
 type InitPhase interface {
+	// Preflight checks that the nodes meet the platform requirements without changing them.
+	// Preflight 检查节点是否满足平台要求，而不更改节点。
+	// The deployer runs it for all nodes before the first phase so that no node is touched when any of them is unfit.
+	// deployer 在第一个阶段之前对所有节点运行它，以便在任何节点不合格时不会改动任何节点。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// nodes: The nodes to check; hostnames must be unique among them. / 要检查的节点；其主机名必须唯一。
+	// clusterNodes: Nodes already in the cluster whose hostnames the checked nodes must not reuse; only their hostnames are read.
+	// clusterNodes: 已在集群中的节点，被检查的节点不得重复使用其主机名；只读取它们的主机名。
+	// clusterCfg: The overall cluster configuration. / 整体集群配置。
+	// Returns the report; blocking failures are reported in it, not as an error.
+	// 返回报告；阻止部署的失败记录在报告中，而不是作为错误返回。
+	Preflight(ctx context.Context, nodes []model.NodeConfig, clusterNodes []model.NodeConfig, clusterCfg *model.ClusterConfig) *preflight.Report
+
 	// Run executes the initialization phase for a single node.
 	// Run 为单个节点执行初始化阶段。
 	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
@@ -28,69 +44,114 @@ type InitPhase interface {
 	// Returns an error if the phase fails for the node.
 	// 如果阶段在节点上失败则返回错误。
 	Run(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error
+
+	// Plan returns the actions Run would apply to the node, without contacting it.
+	// Plan 返回 Run 将应用到节点的操作，而不连接节点。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// nodeCfg: The configuration for the specific node. / 特定节点的配置。
+	// clusterCfg: The overall cluster configuration. / 整体集群配置。
+	// Returns the planned actions, or an error if the configuration cannot be rendered.
+	// 返回计划的操作，如果配置无法渲染则返回错误。
+	Plan(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.Action, error)
 }
 
 // NewInitPhase creates a new InitPhase instance.
 // NewInitPhase 创建一个新的 InitPhase 实例。
+// newExecutor: Factory used to connect to nodes; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
 // Returns an InitPhase implementation.
 // 返回 InitPhase 实现。
-func NewInitPhase() InitPhase {
-	return &DefaultInitPhase{}
+func NewInitPhase(newExecutor executor.Factory) InitPhase {
+	return &DefaultInitPhase{newExecutor: newExecutor, requirements: preflight.DefaultRequirements()}
 }
 
 // DefaultInitPhase is a default implementation of the InitPhase.
 // DefaultInitPhase 是 InitPhase 的默认实现。
-type DefaultInitPhase struct{}
+type DefaultInitPhase struct {
+	newExecutor  executor.Factory
+	requirements preflight.Requirements
+}
+
+// Preflight runs the preflight checks against the nodes.
+// Preflight 对节点运行预检。
+func (p *DefaultInitPhase) Preflight(ctx context.Context, nodes []model.NodeConfig, clusterNodes []model.NodeConfig, clusterCfg *model.ClusterConfig) *preflight.Report {
+	utils.GetLogger().Printf("Running preflight checks on nodes: %s", formatNodeAddresses(nodes))
+	return preflight.NewChecker(p.newExecutor, endpointRequirements(p.requirements, clusterCfg)).WithClusterNodes(clusterNodes).Run(ctx, nodes)
+}
+
+// endpointRequirements extends the requirements of the masters with what the control plane endpoint runs on them.
+// endpointRequirements 使用控制平面端点在主节点上运行的组件扩展主节点的要求。
+func endpointRequirements(req preflight.Requirements, clusterCfg *model.ClusterConfig) preflight.Requirements {
+	endpoint := clusterCfg.ControlPlaneEndpoint
+	if endpoint == nil || endpoint.Mode != enum.EndpointModeKeepalived {
+		return req
+	}
+	roleTools := map[enum.NodeRole][]string{}
+	for role, tools := range req.RoleTools {
+		roleTools[role] = tools
+	}
+	roleTools[enum.RoleMaster] = append(append([]string(nil), roleTools[enum.RoleMaster]...), "haproxy", "keepalived")
+	ports := map[enum.NodeRole][]int{}
+	for role, rolePorts := range req.Ports {
+		ports[role] = rolePorts
+	}
+	ports[enum.RoleMaster] = append(append([]int(nil), ports[enum.RoleMaster]...), endpointPort(endpoint))
+	req.RoleTools, req.Ports = roleTools, ports
+	return req
+}
 
 // Run executes the initialization phase.
 // Run 执行初始化阶段。
 func (p *DefaultInitPhase) Run(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error {
 	utils.GetLogger().Printf("Running InitPhase for node %s", nodeCfg.Address)
 
-	// Step 1: Check SSH connectivity
-	// 步骤 1：检查 SSH 连接
-	utils.GetLogger().Printf("Checking SSH connectivity to %s:%d...", nodeCfg.Address, nodeCfg.Port)
-	// TODO: Implement SSH connection check logic using sshutil
-	// TODO: 使用 sshutil 实现 SSH 连接检查逻辑
-	// sshClient, err := sshutil.NewClient(nodeCfg.Address, nodeCfg.Port, nodeCfg.User, nodeCfg.Password, nodeCfg.PrivateKey)
-	// if err != nil {
-	// 	return errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to create SSH client for %s", nodeCfg.Address), err)
-	// }
-	// defer sshClient.Close() // Ensure client is closed
-
-	// Use a placeholder for now
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-time.After(5 * time.Second): // Simulate connection attempt
-		utils.GetLogger().Printf("Placeholder: SSH connection to %s successful.", nodeCfg.Address)
-		// return nil // Simulate success
+	// The requirements of the node were verified by Preflight before any phase ran.
+	// 节点的要求已在任何阶段运行之前由 Preflight 验证。
+	exec, err := connectNode(ctx, p.newExecutor, nodeCfg)
+	if err != nil {
+		return err
 	}
-	// return errors.New(errors.ErrTypeNotImplemented, fmt.Sprintf("SSH connectivity check not implemented for %s", nodeCfg.Address)) // Simulate failure if not implemented
-
-	// Step 2: Validate basic OS requirements (e.g., kernel version, required tools)
-	// 步骤 2：验证基本操作系统要求（例如，内核版本、所需工具）
-	utils.GetLogger().Printf("Validating basic OS requirements on %s...", nodeCfg.Address)
-	// TODO: Implement remote command execution via SSH to check OS details
-	// TODO: 通过 SSH 实现远程命令执行以检查操作系统详细信息
-	// Example: output, err := sshClient.RunCommand(ctx, "uname -r")
-	// if err != nil { ... }
-	// if !checkKernelVersion(output) { ... }
-	utils.GetLogger().Printf("Placeholder: Basic OS requirements validated on %s.", nodeCfg.Address)
-
-	// Step 3: Check necessary directories and permissions
-	// 步骤 3：检查必要的目录和权限
+	defer exec.Close()
+
+	// Prepare the necessary directories and check permissions
+	// 准备必要的目录并检查权限
+	// This also proves that privilege escalation works for the configured user.
+	// 这也证明了所配置用户的权限提升可以正常工作。
 	utils.GetLogger().Printf("Checking required directories and permissions on %s...", nodeCfg.Address)
-	// TODO: Implement remote checks via SSH
-	// TODO: 通过 SSH 实现远程检查
-	utils.GetLogger().Printf("Placeholder: Required directories and permissions checked on %s.", nodeCfg.Address)
+	actions, err := p.Plan(ctx, nodeCfg, clusterCfg)
+	if err != nil {
+		return err
+	}
+	if err := plan.Apply(ctx, exec, actions); err != nil {
+		return err
+	}
+	utils.GetLogger().Printf("Required directories and permissions checked on %s.", nodeCfg.Address)
 
 	utils.GetLogger().Printf("InitPhase completed successfully for node %s", nodeCfg.Address)
 	return nil
 }
 
-// TODO: Add helper functions like checkKernelVersion, checkDiskSpace, etc.
-// TODO: 添加辅助函数，例如 checkKernelVersion, checkDiskSpace 等。
+// Plan returns the actions of the initialization phase. The preflight checks are read-only and not part of the plan.
+// Plan 返回初始化阶段的操作。预检是只读的，不属于计划的一部分。
+func (p *DefaultInitPhase) Plan(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.Action, error) {
+	dataDir := executor.ShellQuote(constants.DefaultDataDir)
+	return []plan.Action{
+		plan.SudoCommand("Create the writable data directory "+constants.DefaultDataDir, fmt.Sprintf("mkdir -p %s && test -w %s", dataDir, dataDir)).
+			WithCheck(fmt.Sprintf("test -d %s && test -w %s", dataDir, dataDir)),
+	}, nil
+}
+
+// connectNode opens an executor for the node, defaulting to SSH when no factory is configured.
+// connectNode 为节点打开执行器，未配置工厂时默认使用 SSH。
+func connectNode(ctx context.Context, newExecutor executor.Factory, nodeCfg *model.NodeConfig) (executor.NodeExecutor, error) {
+	if newExecutor == nil {
+		newExecutor = executor.NewSSHExecutor
+	}
+	exec, err := newExecutor(ctx, nodeCfg)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to connect to node %s", nodeCfg.Address), err)
+	}
+	return exec, nil
+}
 
 // NodeSpecificPhase is a marker interface for phases that run on individual nodes.
 // This is just for conceptual grouping in the deployer's run loop example.
@@ -98,4 +159,5 @@ func (p *DefaultInitPhase) Run(ctx context.Context, nodeCfg *model.NodeConfig, c
 // 这只是在 deployer 的运行循环示例中进行概念分组。
 type NodeSpecificPhase interface {
 	Run(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error
+	Plan(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.Action, error)
 }
@@ -3,26 +3,17 @@
 package phases
 
 import (
+	"bytes"
 	"context"
-	"time"
-
+	"fmt"
 	"strings"
 
 	"github.com/turtacn/chasi-bod/common/errors"
 	"github.com/turtacn/chasi-bod/common/types/enum"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
 	"github.com/turtacn/chasi-bod/pkg/config/model"
-	// Assuming you have an SSH client utility
-	// 假设您有一个 SSH 客户端工具
-	// "github.com/turtacn/chasi-bod/pkg/sshutil"
-	// Placeholder for Kubernetes client-go, needed for CNI/CSI deployment and node status check
-	// Kubernetes client-go 的占位符，CNI/CSI 部署和节点状态检查需要它
-	// "k8s.io/client-go/kubernetes"
-	// "k8s.io/client-go/rest" // Needed to build K8s client config // 需要它来构建 K8s 客户端配置
-	// "k8s.io/client-go/tools/clientcmd" // Needed to load kubeconfig // 需要它来加载 kubeconfig
-	// corev1 "k8s.io/api/core/v1" // Needed for Node object // 需要它来获取 Node 对象
-	// metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Needed for ListOptions // 需要它来获取 ListOptions
-	// "k8s.io/apimachinery/pkg/util/wait" // Needed for polling // 需要它来进行轮询
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
 )
 
 // K8sInstallPhase defines the interface for installing and initializing the Host Kubernetes cluster.
@@ -39,23 +30,75 @@ type K8sInstallPhase interface {
 	// 如果阶段失败则返回错误。
 	Run(ctx context.Context, nodes []model.NodeConfig, clusterCfg *model.ClusterConfig) error
 
-	// Add methods for joining/removing nodes if needed separate from full deploy
-	// 如果需要与完整部署分开，则添加用于加入/移除节点的方法
-	// JoinNode(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig, joinCommand string) error
-	// RemoveNode(ctx context.Context, nodeCfg *model.NodeConfig, hostK8sClient kubernetes.Interface) error // This is also in deployer.Deployer
+	// JoinNode joins a single new node to an already initialized cluster.
+	// JoinNode 将单个新节点加入已初始化的集群。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// nodeCfg: The configuration for the node to join. / 要加入的节点的配置。
+	// clusterCfg: The overall cluster configuration; its first master issues the join credentials. / 整体集群配置；其第一个主节点签发加入凭据。
+	// Returns an error if the join fails.
+	// 如果加入失败则返回错误。
+	JoinNode(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error
+
+	// Plan returns the actions Run would perform on each node, without contacting them.
+	// Plan 返回 Run 将在每个节点上执行的操作，而不连接节点。
+	// Secrets generated at run time (bootstrap tokens, certificate keys, the CA hash) are shown as placeholders.
+	// 运行时生成的机密信息（引导令牌、证书密钥、CA 哈希）显示为占位符。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// nodes: The configurations for all nodes in the cluster. / 集群中所有节点的配置。
+	// clusterCfg: The overall cluster configuration. / 整体集群配置。
+	// Returns the planned actions per node, or an error if the configuration cannot be rendered.
+	// 返回每个节点的计划操作，如果配置无法渲染则返回错误。
+	Plan(ctx context.Context, nodes []model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.TargetActions, error)
+
+	// RemoveEtcdMember removes the etcd member of a master leaving the cluster, running etcdctl on a remaining master.
+	// RemoveEtcdMember 移除正在离开集群的主节点的 etcd 成员，在剩余的主节点上运行 etcdctl。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// nodeName: The Kubernetes Node name, which kubeadm uses as the member name; may be empty. / Kubernetes Node 名称，kubeadm 将其用作成员名称；可以为空。
+	// nodeCfg: The configuration of the leaving master. / 离开的主节点的配置。
+	// clusterCfg: The cluster configuration without the leaving master. / 不含离开主节点的集群配置。
+	// Returns nil if the node is not an etcd member.
+	// 如果节点不是 etcd 成员则返回 nil。
+	RemoveEtcdMember(ctx context.Context, nodeName string, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error
+
+	// ResetNode undoes kubeadm on a node leaving the cluster and cleans up its CNI and iptables state.
+	// ResetNode 在离开集群的节点上撤销 kubeadm，并清理其 CNI 和 iptables 状态。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// nodeCfg: The configuration of the leaving node. / 离开节点的配置。
+	// clusterCfg: The overall cluster configuration. / 整体集群配置。
+	// Returns an error if a cleanup step fails.
+	// 如果某个清理步骤失败则返回错误。
+	ResetNode(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error
 }
 
+const (
+	// kubeadmAdminConfigPath exists on a master once kubeadm init has completed.
+	// kubeadmAdminConfigPath 在 kubeadm init 完成后存在于主节点上。
+	kubeadmAdminConfigPath = "/etc/kubernetes/admin.conf"
+	// kubeletConfigPath exists on a node once it has joined the cluster.
+	// kubeletConfigPath 在节点加入集群后存在于节点上。
+	kubeletConfigPath = "/etc/kubernetes/kubelet.conf"
+)
+
 // NewK8sInstallPhase creates a new K8sInstallPhase instance.
 // NewK8sInstallPhase 创建一个新的 K8sInstallPhase 实例。
+// newExecutor: Factory used to connect to nodes; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
 // Returns a K8sInstallPhase implementation.
 // 返回 K8sInstallPhase 实现。
-func NewK8sInstallPhase() K8sInstallPhase {
-	return &defaultK8sInstallPhase{}
+func NewK8sInstallPhase(newExecutor executor.Factory) K8sInstallPhase {
+	return &defaultK8sInstallPhase{newExecutor: newExecutor, kubeconfigDir: DefaultKubeconfigDir, newClients: kubeconfigClients}
 }
 
 // defaultK8sInstallPhase is a default implementation of the K8sInstallPhase.
 // defaultK8sInstallPhase 是 K8sInstallPhase 的默认实现。
-type defaultK8sInstallPhase struct{}
+type defaultK8sInstallPhase struct {
+	newExecutor executor.Factory
+	// kubeconfigDir is where the admin kubeconfig of the cluster is exported
+	// kubeconfigDir 是导出集群管理员 kubeconfig 的位置
+	kubeconfigDir string
+	// newClients builds the Host Cluster clients the CNI plugin and storage are applied with; nil means kubeconfigClients
+	// newClients 构建用于应用 CNI 插件和存储的 Host 集群客户端；nil 表示使用 kubeconfigClients
+	newClients clusterClientFactory
+}
 
 // Run executes the Kubernetes installation phase.
 // Run 执行 Kubernetes 安装阶段。
@@ -64,21 +107,7 @@ func (p *defaultK8sInstallPhase) Run(ctx context.Context, nodes []model.NodeConf
 
 	// Identify master and worker nodes
 	// 识别主节点和工作节点
-	var masterNodes, workerNodes []model.NodeConfig
-	for _, node := range nodes {
-		isMaster := false
-		for _, role := range node.Roles {
-			if role == enum.RoleMaster {
-				isMaster = true
-				break
-			}
-		}
-		if isMaster {
-			masterNodes = append(masterNodes, node)
-		} else {
-			workerNodes = append(workerNodes, node)
-		}
-	}
+	masterNodes, workerNodes := splitNodesByRole(nodes)
 
 	if len(masterNodes) == 0 {
 		return errors.New(errors.ErrTypeValidation, "no master nodes specified in cluster configuration")
@@ -90,130 +119,479 @@ func (p *defaultK8sInstallPhase) Run(ctx context.Context, nodes []model.NodeConf
 	// 这涉及在第一个主节点上运行 `kubeadm init`，如果是高可用则在其他节点上运行 `kubeadm join`
 	utils.GetLogger().Printf("Initializing Kubernetes control plane on master nodes: %s", formatNodeAddresses(masterNodes))
 
-	// TODO: Implement kubeadm init/join logic via SSH
-	// TODO: 通过 SSH 实现 kubeadm init/join 逻辑
-	// You'll need to:
-	// - Generate kubeadm config file (YAML) based on clusterCfg
-	// - Copy config file to master node
-	// - Execute `sudo kubeadm init --config=<config-file>` on the first master via SSH
-	// - Wait for init to complete
-	// - Extract join command and token from the output
-	// - For HA masters, execute `sudo kubeadm join ...` with the --control-plane flag via SSH
-	// - Copy kubeconfig from the first master to a known location (e.g., /etc/kubernetes/admin.conf) on the master, and potentially download it locally for the deployer to use.
-
-	utils.GetLogger().Println("Placeholder: Running kubeadm init on master nodes...")
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-time.After(5 * time.Minute): // Simulate kubeadm init time
-		utils.GetLogger().Println("Placeholder: Kubeadm init completed on master nodes.")
-	}
-
-	// Placeholder for join command and token - should be extracted from kubeadm init output
-	// join 命令和 token 的占位符 - 应从 kubeadm init 输出中提取
-	joinCommandPlaceholder := "kubeadm join <ControlPlaneEndpoint>:<ControlPlanePort> --token <token> --discovery-token-ca-cert-hash sha256:<hash>"
-	if len(masterNodes) > 1 {
-		joinCommandPlaceholder = "kubeadm join <ControlPlaneEndpoint>:<ControlPlanePort> --token <token> --discovery-token-ca-cert-hash sha256:<hash> --control-plane --certificate-key <key>"
+	// Nodes that already carry their kubeadm kubeconfig are left alone, so re-running the phase is a no-op.
+	// 已具有 kubeadm kubeconfig 的节点保持不变，因此重新运行此阶段不会产生任何操作。
+	if err := p.configureEndpoint(ctx, masterNodes, clusterCfg); err != nil {
+		return err
+	}
+	firstMaster := &masterNodes[0]
+	initialized, err := p.nodeHasFile(ctx, firstMaster, kubeadmAdminConfigPath)
+	if err != nil {
+		return err
+	}
+	initItem := "Initialize the control plane on " + firstMaster.Address
+	var creds joinCredentials
+	if initialized {
+		utils.GetLogger().Printf("Control plane already initialized on %s, skipping kubeadm init.", firstMaster.Address)
+		plan.Record(ctx, initItem, plan.StatusUnchanged, nil)
+	} else {
+		creds, err = p.initControlPlane(ctx, firstMaster, clusterCfg, len(masterNodes) > 1)
+		if err != nil {
+			plan.Record(ctx, initItem, plan.StatusFailed, err)
+			return err
+		}
+		plan.Record(ctx, initItem, plan.StatusChanged, nil)
+	}
+	if err := p.exportKubeconfig(ctx, firstMaster, clusterCfg); err != nil {
+		return err
+	}
+
+	pendingMasters, err := p.pendingJoins(ctx, masterNodes[1:])
+	if err != nil {
+		return err
+	}
+	pendingWorkers, err := p.pendingJoins(ctx, workerNodes)
+	if err != nil {
+		return err
+	}
+	if len(pendingMasters) == 0 && len(pendingWorkers) == 0 {
+		utils.GetLogger().Println("All nodes have already joined the cluster.")
+	} else if err := p.joinPending(ctx, firstMaster, pendingMasters, pendingWorkers, clusterCfg, creds, initialized); err != nil {
+		return err
+	}
+
+	// --- Host Cluster Control Plane is now UP, but potentially not fully functional without CNI/CSI ---
+
+	// Steps 3 and 5: Deploy the CNI plugin, then wait for its DaemonSet and for all nodes to be Ready
+	// 步骤 3 和 5：部署 CNI 插件，然后等待其 DaemonSet 以及所有节点就绪
+	if err := p.installCNI(ctx, nodes, clusterCfg); err != nil {
+		return err
+	}
+
+	// Step 4: Create the StorageClasses and static PersistentVolumes, and deploy the local-path provisioner if requested
+	// 步骤 4：创建 StorageClass 和静态 PersistentVolume，并按需部署 local-path provisioner
+	if err := p.installStorage(ctx, clusterCfg); err != nil {
+		return err
+	}
+
+	utils.GetLogger().Println("K8sInstallPhase completed successfully.")
+	return nil
+}
+
+// joinPending joins the masters and workers that have not joined the cluster yet.
+// joinPending 将尚未加入集群的主节点和工作节点加入集群。
+// The token and certificate key registered by kubeadm init are reused; an existing cluster gets fresh ones.
+// 复用 kubeadm init 注册的令牌和证书密钥；已有集群则获取新的令牌和证书密钥。
+func (p *defaultK8sInstallPhase) joinPending(ctx context.Context, firstMaster *model.NodeConfig, pendingMasters, pendingWorkers []model.NodeConfig, clusterCfg *model.ClusterConfig, creds joinCredentials, initialized bool) error {
+	var err error
+	if initialized {
+		creds, err = p.issueJoinCredentials(ctx, firstMaster, len(pendingMasters) > 0)
+		if err != nil {
+			return err
+		}
+	}
+	creds.APIServerEndpoint = controlPlaneEndpoint(firstMaster, clusterCfg)
+	if creds.CACertHash, err = p.fetchCACertHash(ctx, firstMaster); err != nil {
+		return err
+	}
+	for i := range pendingMasters {
+		if err := p.recordJoin(ctx, &pendingMasters[i], clusterCfg, creds); err != nil {
+			return err
+		}
 	}
-	utils.GetLogger().Printf("Placeholder: Extracted join command: %s", joinCommandPlaceholder)
+	utils.GetLogger().Println("Kubernetes control plane initialized on master nodes.")
 
 	// Step 2: Join worker nodes to the cluster (using kubeadm)
 	// 步骤 2：将工作节点加入集群（使用 kubeadm）
-	if len(workerNodes) > 0 {
-		utils.GetLogger().Printf("Joining worker nodes to the cluster: %s", formatNodeAddresses(workerNodes))
-		// TODO: Implement kubeadm join logic via SSH on worker nodes
-		// TODO: 通过 SSH 在工作节点上实现 kubeadm join 逻辑
-		// For each worker node:
-		// - Execute `sudo <joinCommandPlaceholder>`
-		// - Wait for node to join and become Ready (can use K8s client after deploying CNI/CSI)
-		utils.GetLogger().Println("Placeholder: Running kubeadm join on worker nodes...")
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(time.Duration(len(workerNodes)) * 2 * time.Minute): // Simulate join time per node
-			utils.GetLogger().Println("Placeholder: Kubeadm join completed on worker nodes.")
-		}
-	} else {
+	if len(pendingWorkers) == 0 {
 		utils.GetLogger().Println("No worker nodes to join.")
+		return nil
 	}
+	utils.GetLogger().Printf("Joining worker nodes to the cluster: %s", formatNodeAddresses(pendingWorkers))
+	for i := range pendingWorkers {
+		if err := p.recordJoin(ctx, &pendingWorkers[i], clusterCfg, creds); err != nil {
+			return err
+		}
+	}
+	utils.GetLogger().Println("Kubeadm join completed on worker nodes.")
+	return nil
+}
 
-	// --- Host Cluster Control Plane is now UP, but potentially not fully functional without CNI/CSI ---
+// Plan returns the kubeadm actions of the installation phase per node, in the order Run performs them.
+// Plan 按 Run 执行的顺序返回安装阶段每个节点的 kubeadm 操作。
+func (p *defaultK8sInstallPhase) Plan(ctx context.Context, nodes []model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.TargetActions, error) {
+	masterNodes, workerNodes := splitNodesByRole(nodes)
+	if len(masterNodes) == 0 {
+		return nil, errors.New(errors.ErrTypeValidation, "no master nodes specified in cluster configuration")
+	}
+	firstMaster := &masterNodes[0]
+	// Secrets are generated at run time and shown as placeholders.
+	// 机密信息在运行时生成，以占位符显示。
+	creds := joinCredentials{
+		APIServerEndpoint: controlPlaneEndpoint(firstMaster, clusterCfg),
+		Token:             "<bootstrap token>",
+		CACertHash:        "<CA certificate hash>",
+		CertificateKey:    "<certificate key>",
+	}
+	initConfig, err := renderKubeadmInitConfig(firstMaster, clusterCfg, creds, len(masterNodes) > 1)
+	if err != nil {
+		return nil, err
+	}
 
-	// TODO: Get Host K8s client (requires kubeconfig which should be available after init)
-	// TODO: 获取 Host K8s 客户端（需要 kubeconfig，在 init 后应该可用）
-	// hostK8sClient, err := getHostK8sClientForInstallPhase(ctx, masterNodes[0]) // Assuming a helper to get client after init
-	// if err != nil { return errors.NewWithCause(...) }
-	var hostK8sClient interface{} // Placeholder
-	if hostK8sClient == nil {
-
-	}
-
-	// Step 3: Deploy CNI (usually applied after cluster is up and client is available)
-	// 步骤 3：部署 CNI（通常在集群启动并客户端可用后应用）
-	utils.GetLogger().Printf("Deploying CNI plugin %s...", clusterCfg.Network.Plugin)
-	// TODO: Implement CNI deployment (e.g., apply YAML manifest via kubectl or K8s client)
-	// This might involve:
-	// - Getting the K8s client for the Host Cluster (needs kubeconfig from master)
-	// - Loading the CNI manifest (e.g., read from a file in the built image or download)
-	// - Applying the manifest using the hostK8sClient
-	utils.GetLogger().Println("Placeholder: CNI plugin deployed.")
-
-	// Step 4: Deploy CSI (usually applied after cluster is up and client is available)
-	// 步骤 4：部署 CSI（通常在集群启动并客户端可用后应用）
-	if clusterCfg.Storage.DefaultStorageClass != "" || len(clusterCfg.Storage.StorageClasses) > 0 {
-		utils.GetLogger().Printf("Deploying CSI driver for storage classes...")
-		// TODO: Implement CSI deployment (similar to CNI) using hostK8sClient
-		// This depends on the chosen CSI driver.
-		// TODO: 使用 hostK8sClient 实现 CSI 部署（类似于 CNI）
-		// 这取决于所选的 CSI 驱动。
-		utils.GetLogger().Println("Placeholder: CSI driver deployed.")
-	} else {
-		utils.GetLogger().Println("No storage classes defined, skipping CSI deployment.")
-	}
-
-	// Step 5: Wait for all nodes to be Ready (including masters and workers, and CNI/CSI components might need to be up)
-	// 步骤 5：等待所有节点就绪（包括主节点和工作节点，以及 CNI/CSI 组件可能需要启动）
-	utils.GetLogger().Println("Waiting for all nodes to become Ready...")
-	// TODO: Implement waiting logic using K8s client
-	// Poll the status of Nodes in the Host Cluster until all desired nodes are in the Ready state.
-	// 轮询 Host Cluster 中节点的状态，直到所有期望的节点都处于 Ready 状态。
-	// Example:
-	// err = wait.PollUntilContextTimeout(ctx, 5*time.Second, 10*time.Minute, func(ctx context.Context) (bool, error) {
-	// 	nodeList, listErr := hostK8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
-	// 	if listErr != nil { return false, listErr }
-	// 	readyNodes := 0
-	// 	expectedNodes := len(nodes) // Total number of nodes in the cluster config
-	// 	for _, node := range nodeList.Items {
-	// 		for _, condition := range node.Status.Conditions {
-	// 			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
-	// 				readyNodes++
-	// 				break
-	// 			}
-	// 		}
-	// 	}
-	// 	utils.GetLogger().Printf("Waiting for nodes: %d/%d ready", readyNodes, expectedNodes)
-	// 	return readyNodes == expectedNodes, nil
-	// })
-	// if err != nil { return errors.NewWithCause(...) }
-	utils.GetLogger().Println("Placeholder: All nodes are Ready.")
+	// Tokens and certificates are only re-issued for a cluster that was initialized by an earlier run.
+	// 仅为先前运行中已初始化的集群重新签发令牌和证书。
+	notInitialized := "! test -f " + kubeadmAdminConfigPath
+	firstMasterActions, err := endpointActions(masterNodes, 0, clusterCfg)
+	if err != nil {
+		return nil, err
+	}
+	firstMasterActions = append(firstMasterActions,
+		plan.KubeadmConfig("Write the kubeadm init configuration", kubeadmInitConfigPath, initConfig).
+			WithCheck("test -f "+kubeadmAdminConfigPath),
+		plan.SudoCommand("Initialize the control plane", kubeadmInitCommand()).
+			WithCheck("test -f "+kubeadmAdminConfigPath),
+		plan.SudoCommand("Create a bootstrap token for joining nodes", tokenCreateCommand(creds.Token)).
+			WithCheck(notInitialized),
+	)
+	if len(masterNodes) > 1 {
+		firstMasterActions = append(firstMasterActions,
+			plan.SudoCommand("Upload control plane certificates for joining masters", uploadCertsCommand(creds.CertificateKey)).
+				WithCheck(notInitialized))
+	}
+	targets := []plan.TargetActions{{Target: firstMaster.Address, Actions: firstMasterActions}}
+	joinTargets := append(append([]model.NodeConfig(nil), masterNodes[1:]...), workerNodes...)
+	for i := range joinTargets {
+		nodeCfg := &joinTargets[i]
+		joinConfig, err := renderKubeadmJoinConfig(nodeCfg, clusterCfg, creds)
+		if err != nil {
+			return nil, err
+		}
+		var actions []plan.Action
+		description := "Join the cluster"
+		if hasRole(nodeCfg, enum.RoleMaster) {
+			description = "Join the control plane"
+			if actions, err = endpointActions(masterNodes, i+1, clusterCfg); err != nil {
+				return nil, err
+			}
+		}
+		actions = append(actions,
+			plan.KubeadmConfig("Write the kubeadm join configuration", kubeadmJoinConfigPath, joinConfig).
+				WithCheck("test -f "+kubeletConfigPath),
+			plan.SudoCommand(description, kubeadmJoinCommand()).WithCheck("test -f "+kubeletConfigPath),
+		)
+		targets = append(targets, plan.TargetActions{Target: nodeCfg.Address, Actions: actions})
+	}
+	if installer, ok := lookupCNIInstaller(clusterCfg.Network.Plugin); ok {
+		description := fmt.Sprintf("Apply the %s CNI plugin from %s and wait for DaemonSet %s/%s and every node to be Ready",
+			clusterCfg.Network.Plugin, cniSourceLocation(installer, clusterCfg), installer.Namespace, installer.DaemonSet)
+		targets = append(targets, plan.TargetActions{Target: plan.ClusterTarget, Actions: []plan.Action{plan.Opaque(description)}})
+	}
+	if HasStorage(&clusterCfg.Storage) {
+		description := fmt.Sprintf("Reconcile %d StorageClasses and %d PersistentVolumes", len(desiredStorageClasses(&clusterCfg.Storage)), len(clusterCfg.Storage.PVConfigs))
+		if localPathEnabled(&clusterCfg.Storage) {
+			description += " and deploy the local-path provisioner in " + localPathNamespace
+		}
+		targets = append(targets, plan.TargetActions{Target: plan.ClusterTarget, Actions: []plan.Action{plan.Opaque(description)}})
+	}
+	return targets, nil
+}
 
-	utils.GetLogger().Println("K8sInstallPhase completed successfully.")
+// JoinNode joins a single node to the existing cluster with a fresh bootstrap token from the first master.
+// JoinNode 使用来自第一个主节点的新引导令牌将单个节点加入现有集群。
+// A node that has already joined is left untouched.
+// 已加入的节点保持不变。
+func (p *defaultK8sInstallPhase) JoinNode(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error {
+	masterNodes, _ := splitNodesByRole(clusterCfg.Nodes)
+	var firstMaster *model.NodeConfig
+	for i := range masterNodes {
+		if masterNodes[i].Address != nodeCfg.Address {
+			firstMaster = &masterNodes[i]
+			break
+		}
+	}
+	if firstMaster == nil {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("no existing master node available to join %s", nodeCfg.Address))
+	}
+
+	joined, err := p.nodeHasFile(ctx, nodeCfg, kubeletConfigPath)
+	if err != nil {
+		return err
+	}
+	if joined {
+		utils.GetLogger().Printf("Node %s has already joined the cluster, skipping kubeadm join.", nodeCfg.Address)
+		return nil
+	}
+
+	if hasRole(nodeCfg, enum.RoleMaster) {
+		// Every master, including the new one, takes part in the control plane endpoint.
+		// 每个主节点（包括新主节点）都参与控制平面端点。
+		endpointMasters := masterNodes
+		if !containsNode(endpointMasters, nodeCfg.Address) {
+			endpointMasters = append(endpointMasters, *nodeCfg)
+		}
+		if err := p.configureEndpoint(ctx, endpointMasters, clusterCfg); err != nil {
+			return err
+		}
+	}
+
+	creds, err := p.issueJoinCredentials(ctx, firstMaster, hasRole(nodeCfg, enum.RoleMaster))
+	if err != nil {
+		return err
+	}
+	creds.APIServerEndpoint = controlPlaneEndpoint(firstMaster, clusterCfg)
+	if creds.CACertHash, err = p.fetchCACertHash(ctx, firstMaster); err != nil {
+		return err
+	}
+	return p.runJoin(ctx, nodeCfg, clusterCfg, creds)
+}
+
+// initControlPlane writes the kubeadm init configuration to the first master and runs kubeadm init with it.
+// initControlPlane 将 kubeadm init 配置写入第一个主节点并使用它运行 kubeadm init。
+// Returns the bootstrap token and certificate key registered by kubeadm init.
+// 返回 kubeadm init 注册的引导令牌和证书密钥。
+func (p *defaultK8sInstallPhase) initControlPlane(ctx context.Context, masterCfg *model.NodeConfig, clusterCfg *model.ClusterConfig, highAvailability bool) (joinCredentials, error) {
+	var creds joinCredentials
+	var err error
+	if creds.Token, err = newBootstrapToken(); err != nil {
+		return creds, err
+	}
+	if creds.CertificateKey, err = newCertificateKey(); err != nil {
+		return creds, err
+	}
+	config, err := renderKubeadmInitConfig(masterCfg, clusterCfg, creds, highAvailability)
+	if err != nil {
+		return creds, err
+	}
+
+	exec, err := connectNode(ctx, p.newExecutor, masterCfg)
+	if err != nil {
+		return creds, err
+	}
+	defer exec.Close()
+
+	if err := exec.Upload(ctx, strings.NewReader(config), kubeadmInitConfigPath, 0600); err != nil {
+		return creds, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to write the kubeadm configuration on %s", masterCfg.Address), err)
+	}
+	utils.GetLogger().Printf("Running kubeadm init on %s...", masterCfg.Address)
+	if _, err := executor.RunCommand(ctx, exec, kubeadmInitCommand(), executor.WithSudo(), executor.WithLogging(masterCfg.Address)); err != nil {
+		return creds, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("kubeadm init failed on %s", masterCfg.Address), err)
+	}
+	utils.GetLogger().Printf("Kubeadm init completed on %s.", masterCfg.Address)
+	return creds, nil
+}
+
+// configureEndpoint sets up the control plane endpoint on every master, before any of them runs kubeadm.
+// configureEndpoint 在任何主节点运行 kubeadm 之前，在每个主节点上设置控制平面端点。
+// Masters that are already configured are left alone; the haproxy backends follow the current list of masters.
+// 已配置的主节点保持不变；haproxy 后端跟随当前的主节点列表。
+func (p *defaultK8sInstallPhase) configureEndpoint(ctx context.Context, masterNodes []model.NodeConfig, clusterCfg *model.ClusterConfig) error {
+	for i := range masterNodes {
+		actions, err := endpointActions(masterNodes, i, clusterCfg)
+		if err != nil {
+			return err
+		}
+		if len(actions) == 0 {
+			continue
+		}
+		exec, err := connectNode(ctx, p.newExecutor, &masterNodes[i])
+		if err != nil {
+			return err
+		}
+		err = plan.Apply(plan.WithTarget(ctx, masterNodes[i].Address), exec, actions)
+		exec.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kubeadmInitCommand returns the kubeadm init command run with the generated configuration.
+// kubeadmInitCommand 返回使用生成的配置运行的 kubeadm init 命令。
+func kubeadmInitCommand() string {
+	return "kubeadm init --config " + executor.ShellQuote(kubeadmInitConfigPath) + " --upload-certs"
+}
+
+// kubeadmJoinCommand returns the kubeadm join command run with the generated configuration.
+// kubeadmJoinCommand 返回使用生成的配置运行的 kubeadm join 命令。
+func kubeadmJoinCommand() string {
+	return "kubeadm join --config " + executor.ShellQuote(kubeadmJoinConfigPath)
+}
+
+// tokenCreateCommand returns the command registering a bootstrap token on a master.
+// tokenCreateCommand 返回在主节点上注册引导令牌的命令。
+func tokenCreateCommand(token string) string {
+	return "kubeadm token create " + executor.ShellQuote(token) + " --ttl " + bootstrapTokenTTL
+}
+
+// uploadCertsCommand returns the command re-uploading the control plane certificates encrypted with the certificate key.
+// uploadCertsCommand 返回使用证书密钥加密并重新上传控制平面证书的命令。
+func uploadCertsCommand(certificateKey string) string {
+	return "kubeadm init phase upload-certs --upload-certs --certificate-key " + executor.ShellQuote(certificateKey)
+}
+
+// issueJoinCredentials registers a new bootstrap token on a master of an initialized cluster and,
+// for joining masters, re-uploads the control plane certificates under a new certificate key.
+// issueJoinCredentials 在已初始化集群的主节点上注册新的引导令牌，并为加入的主节点使用新的证书密钥重新上传控制平面证书。
+func (p *defaultK8sInstallPhase) issueJoinCredentials(ctx context.Context, masterCfg *model.NodeConfig, withCertificateKey bool) (joinCredentials, error) {
+	var creds joinCredentials
+	var err error
+	if creds.Token, err = newBootstrapToken(); err != nil {
+		return creds, err
+	}
+	if withCertificateKey {
+		if creds.CertificateKey, err = newCertificateKey(); err != nil {
+			return creds, err
+		}
+	}
+
+	exec, err := connectNode(ctx, p.newExecutor, masterCfg)
+	if err != nil {
+		return creds, err
+	}
+	defer exec.Close()
+
+	if _, err := executor.RunCommand(ctx, exec, tokenCreateCommand(creds.Token), executor.WithSudo()); err != nil {
+		return creds, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to create a bootstrap token on %s", masterCfg.Address), err)
+	}
+	if withCertificateKey {
+		if _, err := executor.RunCommand(ctx, exec, uploadCertsCommand(creds.CertificateKey), executor.WithSudo()); err != nil {
+			return creds, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to upload control plane certificates on %s", masterCfg.Address), err)
+		}
+	}
+	return creds, nil
+}
+
+// fetchCACertHash reads the cluster CA from a master and returns its public key pin.
+// fetchCACertHash 从主节点读取集群 CA 并返回其公钥固定值。
+func (p *defaultK8sInstallPhase) fetchCACertHash(ctx context.Context, masterCfg *model.NodeConfig) (string, error) {
+	exec, err := connectNode(ctx, p.newExecutor, masterCfg)
+	if err != nil {
+		return "", err
+	}
+	defer exec.Close()
+
+	var caPEM bytes.Buffer
+	if err := exec.Download(ctx, kubeadmCACertPath, &caPEM); err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to read the cluster CA from %s", masterCfg.Address), err)
+	}
+	return caCertHash(caPEM.Bytes())
+}
+
+// nodeHasFile reports whether a file exists on a node.
+// nodeHasFile 报告节点上是否存在某个文件。
+func (p *defaultK8sInstallPhase) nodeHasFile(ctx context.Context, nodeCfg *model.NodeConfig, path string) (bool, error) {
+	exec, err := connectNode(ctx, p.newExecutor, nodeCfg)
+	if err != nil {
+		return false, err
+	}
+	defer exec.Close()
+
+	result, err := exec.Run(ctx, "test -f "+executor.ShellQuote(path), executor.WithSudo())
+	if err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to check %s on %s", path, nodeCfg.Address), err)
+	}
+	return result.Success(), nil
+}
+
+// pendingJoins returns the nodes that have not joined the cluster yet and records the others as unchanged.
+// pendingJoins 返回尚未加入集群的节点，并将其他节点记录为未变更。
+func (p *defaultK8sInstallPhase) pendingJoins(ctx context.Context, nodes []model.NodeConfig) ([]model.NodeConfig, error) {
+	var pending []model.NodeConfig
+	for i := range nodes {
+		joined, err := p.nodeHasFile(ctx, &nodes[i], kubeletConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		if joined {
+			utils.GetLogger().Printf("Node %s has already joined the cluster, skipping kubeadm join.", nodes[i].Address)
+			plan.Record(ctx, joinItem(&nodes[i]), plan.StatusUnchanged, nil)
+			continue
+		}
+		pending = append(pending, nodes[i])
+	}
+	return pending, nil
+}
+
+// recordJoin joins a node to the cluster and records the outcome.
+// recordJoin 将节点加入集群并记录结果。
+func (p *defaultK8sInstallPhase) recordJoin(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig, creds joinCredentials) error {
+	if err := p.runJoin(ctx, nodeCfg, clusterCfg, creds); err != nil {
+		plan.Record(ctx, joinItem(nodeCfg), plan.StatusFailed, err)
+		return err
+	}
+	plan.Record(ctx, joinItem(nodeCfg), plan.StatusChanged, nil)
+	return nil
+}
+
+// joinItem names the join of a node in the deployment report.
+// joinItem 为部署报告中节点的加入命名。
+func joinItem(nodeCfg *model.NodeConfig) string {
+	if hasRole(nodeCfg, enum.RoleMaster) {
+		return "Join " + nodeCfg.Address + " to the control plane"
+	}
+	return "Join " + nodeCfg.Address + " to the cluster"
+}
+
+// runJoin writes the kubeadm join configuration to a node and runs kubeadm join with it.
+// runJoin 将 kubeadm join 配置写入节点并使用它运行 kubeadm join。
+func (p *defaultK8sInstallPhase) runJoin(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig, creds joinCredentials) error {
+	config, err := renderKubeadmJoinConfig(nodeCfg, clusterCfg, creds)
+	if err != nil {
+		return err
+	}
+
+	exec, err := connectNode(ctx, p.newExecutor, nodeCfg)
+	if err != nil {
+		return err
+	}
+	defer exec.Close()
+
+	if err := exec.Upload(ctx, strings.NewReader(config), kubeadmJoinConfigPath, 0600); err != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to write the kubeadm configuration on %s", nodeCfg.Address), err)
+	}
+	utils.GetLogger().Printf("Running kubeadm join on %s...", nodeCfg.Address)
+	if _, err := executor.RunCommand(ctx, exec, kubeadmJoinCommand(), executor.WithSudo(), executor.WithLogging(nodeCfg.Address)); err != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("kubeadm join failed on %s", nodeCfg.Address), err)
+	}
+	utils.GetLogger().Printf("Node %s joined the cluster.", nodeCfg.Address)
 	return nil
 }
 
-// TODO: Implement helper functions for remote command execution, file transfer, etc. using sshutil
-// TODO: 使用 sshutil 实现远程命令执行、文件传输等辅助函数
-// TODO: Implement helper function to generate kubeadm config YAML
-// TODO: 实现生成 kubeadm 配置 YAML 的辅助函数
-// TODO: Implement helper function to get K8s client for Host Cluster after init (needs kubeconfig from master)
-// TODO: 实现获取 Host 集群 K8s 客户端的辅助函数（在 init 后需要从主节点获取 kubeconfig）
-// func getHostK8sClientForInstallPhase(ctx context.Context, masterNodeCfg *model.NodeConfig) (kubernetes.Interface, error) { ... }
-// TODO: Implement helper function to drain a node using K8s client
-// TODO: 实现使用 K8s 客户端排空节点的辅助函数
-// TODO: Implement helper function to remove a node from cluster using K8s client
-// TODO: 实现使用 K8s 客户端从集群移除节点的辅助函数
-// TODO: Implement helper function to wait for a node to be Ready using K8s client
-// TODO: 实现使用 K8s 客户端等待节点就绪的辅助函数
+// splitNodesByRole separates master nodes from all other nodes, preserving order.
+// splitNodesByRole 将主节点与其他所有节点分开，并保持顺序。
+func splitNodesByRole(nodes []model.NodeConfig) (masterNodes, workerNodes []model.NodeConfig) {
+	for _, node := range nodes {
+		if hasRole(&node, enum.RoleMaster) {
+			masterNodes = append(masterNodes, node)
+		} else {
+			workerNodes = append(workerNodes, node)
+		}
+	}
+	return masterNodes, workerNodes
+}
+
+// containsNode reports whether a node with the given address is in nodes.
+// containsNode 报告 nodes 中是否存在具有给定地址的节点。
+func containsNode(nodes []model.NodeConfig, address string) bool {
+	for _, node := range nodes {
+		if node.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRole reports whether the node has the given role.
+// hasRole 报告节点是否具有给定角色。
+func hasRole(nodeCfg *model.NodeConfig, role enum.NodeRole) bool {
+	for _, r := range nodeCfg.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
 
 func formatNodeAddresses(nodes []model.NodeConfig) string {
 	addresses := make([]string, len(nodes))
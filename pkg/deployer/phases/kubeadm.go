@@ -0,0 +1,375 @@
+package phases
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+const (
+	// kubeadmAPIVersion is the kubeadm configuration API the generated files use.
+	// kubeadmAPIVersion 是生成的文件所使用的 kubeadm 配置 API。
+	kubeadmAPIVersion = "kubeadm.k8s.io/v1beta3"
+	// kubeletAPIVersion is the kubelet configuration API the generated files use.
+	// kubeletAPIVersion 是生成的文件所使用的 kubelet 配置 API。
+	kubeletAPIVersion = "kubelet.config.k8s.io/v1beta1"
+	// defaultCgroupDriver is used when the cluster configuration does not set one.
+	// defaultCgroupDriver 在集群配置未设置时使用。
+	defaultCgroupDriver = "systemd"
+	// kubeadmCACertPath is the cluster CA certificate on a master.
+	// kubeadmCACertPath 是主节点上的集群 CA 证书。
+	kubeadmCACertPath = "/etc/kubernetes/pki/ca.crt"
+	// bootstrapTokenTTL is how long a generated bootstrap token stays valid.
+	// bootstrapTokenTTL 是生成的引导令牌的有效期。
+	bootstrapTokenTTL = "24h0m0s"
+)
+
+// kubeadmConfigDir holds the generated kubeadm configuration files on the nodes. They contain secrets and are only readable by root.
+// kubeadmConfigDir 保存节点上生成的 kubeadm 配置文件。它们包含机密信息，只有 root 可读。
+var kubeadmConfigDir = path.Join(constants.DefaultDataDir, "kubeadm")
+
+var (
+	kubeadmInitConfigPath = path.Join(kubeadmConfigDir, "init.yaml")
+	kubeadmJoinConfigPath = path.Join(kubeadmConfigDir, "join.yaml")
+)
+
+// joinCredentials are what a node needs to join the cluster.
+// joinCredentials 是节点加入集群所需的信息。
+type joinCredentials struct {
+	// APIServerEndpoint is the host:port nodes use to reach the API server.
+	// APIServerEndpoint 是节点用于访问 API 服务器的 host:port。
+	APIServerEndpoint string
+	// Token is a bootstrap token ("abcdef.0123456789abcdef").
+	// Token 是引导令牌（"abcdef.0123456789abcdef"）。
+	Token string
+	// CACertHash pins the cluster CA ("sha256:<hex>").
+	// CACertHash 固定集群 CA（"sha256:<hex>"）。
+	CACertHash string
+	// CertificateKey decrypts the control plane certificates uploaded by kubeadm; only masters need it.
+	// CertificateKey 用于解密 kubeadm 上传的控制平面证书；只有主节点需要它。
+	CertificateKey string
+}
+
+// kubeadm configuration documents. Only the fields chasi-bod sets are modelled.
+// kubeadm 配置文档。仅对 chasi-bod 设置的字段建模。
+
+type kubeadmTypeMeta struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+}
+
+type kubeadmBootstrapToken struct {
+	Token  string   `yaml:"token"`
+	TTL    string   `yaml:"ttl"`
+	Usages []string `yaml:"usages"`
+	Groups []string `yaml:"groups"`
+}
+
+type kubeadmAPIEndpoint struct {
+	AdvertiseAddress string `yaml:"advertiseAddress"`
+	BindPort         int    `yaml:"bindPort"`
+}
+
+type kubeadmNodeRegistration struct {
+	CRISocket             string   `yaml:"criSocket"`
+	IgnorePreflightErrors []string `yaml:"ignorePreflightErrors,omitempty"`
+}
+
+type kubeadmInitConfiguration struct {
+	kubeadmTypeMeta  `yaml:",inline"`
+	BootstrapTokens  []kubeadmBootstrapToken `yaml:"bootstrapTokens"`
+	LocalAPIEndpoint kubeadmAPIEndpoint      `yaml:"localAPIEndpoint"`
+	NodeRegistration kubeadmNodeRegistration `yaml:"nodeRegistration"`
+	CertificateKey   string                  `yaml:"certificateKey,omitempty"`
+}
+
+type kubeadmNetworking struct {
+	PodSubnet     string `yaml:"podSubnet,omitempty"`
+	ServiceSubnet string `yaml:"serviceSubnet,omitempty"`
+	DNSDomain     string `yaml:"dnsDomain"`
+}
+
+type kubeadmAPIServer struct {
+	CertSANs []string `yaml:"certSANs,omitempty"`
+}
+
+type kubeadmClusterConfiguration struct {
+	kubeadmTypeMeta      `yaml:",inline"`
+	ClusterName          string            `yaml:"clusterName,omitempty"`
+	KubernetesVersion    string            `yaml:"kubernetesVersion,omitempty"`
+	ControlPlaneEndpoint string            `yaml:"controlPlaneEndpoint,omitempty"`
+	Networking           kubeadmNetworking `yaml:"networking"`
+	APIServer            kubeadmAPIServer  `yaml:"apiServer"`
+}
+
+type kubeletConfiguration struct {
+	kubeadmTypeMeta `yaml:",inline"`
+	CgroupDriver    string   `yaml:"cgroupDriver"`
+	ClusterDNS      []string `yaml:"clusterDNS,omitempty"`
+}
+
+type kubeadmBootstrapTokenDiscovery struct {
+	APIServerEndpoint string   `yaml:"apiServerEndpoint"`
+	Token             string   `yaml:"token"`
+	CACertHashes      []string `yaml:"caCertHashes"`
+}
+
+type kubeadmDiscovery struct {
+	BootstrapToken kubeadmBootstrapTokenDiscovery `yaml:"bootstrapToken"`
+}
+
+type kubeadmJoinControlPlane struct {
+	LocalAPIEndpoint kubeadmAPIEndpoint `yaml:"localAPIEndpoint"`
+	CertificateKey   string             `yaml:"certificateKey"`
+}
+
+type kubeadmJoinConfiguration struct {
+	kubeadmTypeMeta  `yaml:",inline"`
+	Discovery        kubeadmDiscovery         `yaml:"discovery"`
+	NodeRegistration kubeadmNodeRegistration  `yaml:"nodeRegistration"`
+	ControlPlane     *kubeadmJoinControlPlane `yaml:"controlPlane,omitempty"`
+}
+
+// renderKubeadmInitConfig renders the InitConfiguration, ClusterConfiguration and KubeletConfiguration for the first master.
+// renderKubeadmInitConfig 为第一个主节点渲染 InitConfiguration、ClusterConfiguration 和 KubeletConfiguration。
+// masterCfg: The first master. / 第一个主节点。
+// clusterCfg: The cluster configuration providing version, networking, SANs and cgroup driver. / 提供版本、网络、SAN 和 cgroup 驱动的集群配置。
+// creds: The bootstrap token and certificate key to register; the CA hash is not known yet. / 要注册的引导令牌和证书密钥；CA 哈希尚不可知。
+// highAvailability: Whether more masters will join, which requires a shared control plane endpoint. / 是否会有更多主节点加入，这需要共享的控制平面端点。
+func renderKubeadmInitConfig(masterCfg *model.NodeConfig, clusterCfg *model.ClusterConfig, creds joinCredentials, highAvailability bool) (string, error) {
+	dnsIP, err := clusterDNSIP(clusterCfg)
+	if err != nil {
+		return "", err
+	}
+	initCfg := kubeadmInitConfiguration{
+		kubeadmTypeMeta: kubeadmTypeMeta{APIVersion: kubeadmAPIVersion, Kind: "InitConfiguration"},
+		BootstrapTokens: []kubeadmBootstrapToken{{
+			Token:  creds.Token,
+			TTL:    bootstrapTokenTTL,
+			Usages: []string{"signing", "authentication"},
+			Groups: []string{"system:bootstrappers:kubeadm:default-node-token"},
+		}},
+		LocalAPIEndpoint: kubeadmAPIEndpoint{AdvertiseAddress: masterCfg.Address, BindPort: constants.DefaultKubeAPIServerPort},
+		NodeRegistration: nodeRegistration(masterCfg, clusterCfg),
+		CertificateKey:   creds.CertificateKey,
+	}
+	cluster := kubeadmClusterConfiguration{
+		kubeadmTypeMeta:   kubeadmTypeMeta{APIVersion: kubeadmAPIVersion, Kind: "ClusterConfiguration"},
+		ClusterName:       clusterCfg.Name,
+		KubernetesVersion: kubernetesVersion(clusterCfg.KubernetesVersion),
+		Networking: kubeadmNetworking{
+			PodSubnet:     clusterCfg.Network.PodCIDR,
+			ServiceSubnet: clusterCfg.Network.ServiceCIDR,
+			DNSDomain:     "cluster.local",
+		},
+		APIServer: kubeadmAPIServer{CertSANs: certSANs(masterCfg, clusterCfg)},
+	}
+	if highAvailability || clusterCfg.ControlPlaneEndpoint != nil {
+		cluster.ControlPlaneEndpoint = controlPlaneEndpoint(masterCfg, clusterCfg)
+	}
+	kubelet := kubeletConfiguration{
+		kubeadmTypeMeta: kubeadmTypeMeta{APIVersion: kubeletAPIVersion, Kind: "KubeletConfiguration"},
+		CgroupDriver:    cgroupDriver(clusterCfg),
+	}
+	if dnsIP != "" {
+		kubelet.ClusterDNS = []string{dnsIP}
+	}
+	return marshalKubeadmDocuments(initCfg, cluster, kubelet)
+}
+
+// renderKubeadmJoinConfig renders the JoinConfiguration of a node. Masters join as control plane members.
+// renderKubeadmJoinConfig 渲染节点的 JoinConfiguration。主节点作为控制平面成员加入。
+// nodeCfg: The joining node. / 要加入的节点。
+// clusterCfg: The cluster configuration. / 集群配置。
+// creds: The credentials obtained from the first master. / 从第一个主节点获取的凭据。
+func renderKubeadmJoinConfig(nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig, creds joinCredentials) (string, error) {
+	join := kubeadmJoinConfiguration{
+		kubeadmTypeMeta: kubeadmTypeMeta{APIVersion: kubeadmAPIVersion, Kind: "JoinConfiguration"},
+		Discovery: kubeadmDiscovery{BootstrapToken: kubeadmBootstrapTokenDiscovery{
+			APIServerEndpoint: creds.APIServerEndpoint,
+			Token:             creds.Token,
+			CACertHashes:      []string{creds.CACertHash},
+		}},
+		NodeRegistration: nodeRegistration(nodeCfg, clusterCfg),
+	}
+	if hasRole(nodeCfg, enum.RoleMaster) {
+		join.ControlPlane = &kubeadmJoinControlPlane{
+			LocalAPIEndpoint: kubeadmAPIEndpoint{AdvertiseAddress: nodeCfg.Address, BindPort: constants.DefaultKubeAPIServerPort},
+			CertificateKey:   creds.CertificateKey,
+		}
+	}
+	return marshalKubeadmDocuments(join)
+}
+
+// marshalKubeadmDocuments renders the documents as a multi-document YAML stream.
+// marshalKubeadmDocuments 将文档渲染为多文档 YAML 流。
+func marshalKubeadmDocuments(docs ...interface{}) (string, error) {
+	parts := make([]string, len(docs))
+	for i, doc := range docs {
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return "", errors.NewWithCause(errors.ErrTypeInternal, "failed to render kubeadm configuration", err)
+		}
+		parts[i] = string(out)
+	}
+	return strings.Join(parts, "---\n"), nil
+}
+
+// nodeRegistration returns the node registration settings shared by init and join.
+// nodeRegistration 返回 init 和 join 共享的节点注册设置。
+func nodeRegistration(nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) kubeadmNodeRegistration {
+	registration := kubeadmNodeRegistration{CRISocket: criSocket(clusterCfg.ContainerRuntime)}
+	// The kube-vip manifest is in place before kubeadm writes the control plane manifests next to it.
+	// kube-vip 清单在 kubeadm 写入其旁边的控制平面清单之前就已就位。
+	if endpoint := clusterCfg.ControlPlaneEndpoint; endpoint != nil && endpoint.Mode == enum.EndpointModeKubeVIP && hasRole(nodeCfg, enum.RoleMaster) {
+		registration.IgnorePreflightErrors = []string{"DirAvailable--etc-kubernetes-manifests"}
+	}
+	return registration
+}
+
+// criSocket maps a configured container runtime to its CRI endpoint.
+// criSocket 将配置的容器运行时映射到其 CRI 端点。
+func criSocket(runtime string) string {
+	switch runtime {
+	case "cri-o", "crio":
+		return "unix:///var/run/crio/crio.sock"
+	case "docker":
+		return "unix:///var/run/cri-dockerd.sock"
+	default:
+		return constants.DefaultContainerRuntimeEndpoint
+	}
+}
+
+// cgroupDriver returns the configured kubelet cgroup driver, defaulting to systemd.
+// cgroupDriver 返回配置的 kubelet cgroup 驱动，默认为 systemd。
+func cgroupDriver(clusterCfg *model.ClusterConfig) string {
+	if clusterCfg.CgroupDriver != "" {
+		return clusterCfg.CgroupDriver
+	}
+	return defaultCgroupDriver
+}
+
+// kubernetesVersion normalizes "1.30.0" to the "v1.30.0" form kubeadm expects.
+// kubernetesVersion 将 "1.30.0" 规范化为 kubeadm 期望的 "v1.30.0" 形式。
+func kubernetesVersion(version string) string {
+	if version == "" || strings.HasPrefix(version, "v") {
+		return version
+	}
+	return "v" + version
+}
+
+// certSANs returns the extra API server certificate SANs: the configured ones, the control plane endpoint and every master address.
+// certSANs 返回额外的 API 服务器证书 SAN：配置的 SAN、控制平面端点以及每个主节点地址。
+func certSANs(masterCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) []string {
+	seen := map[string]bool{}
+	var sans []string
+	add := func(san string) {
+		if san != "" && !seen[san] {
+			seen[san] = true
+			sans = append(sans, san)
+		}
+	}
+	for _, san := range clusterCfg.APIServerCertSANs {
+		add(san)
+	}
+	if clusterCfg.ControlPlaneEndpoint != nil {
+		add(clusterCfg.ControlPlaneEndpoint.Address)
+	}
+	add(masterCfg.Address)
+	for i := range clusterCfg.Nodes {
+		if hasRole(&clusterCfg.Nodes[i], enum.RoleMaster) {
+			add(clusterCfg.Nodes[i].Address)
+		}
+	}
+	return sans
+}
+
+// clusterDNSIP returns the configured cluster DNS service IP, or the tenth address of the (first) service CIDR as
+// kubeadm does.
+// clusterDNSIP 返回配置的集群 DNS 服务 IP，或像 kubeadm 一样返回（第一个）服务 CIDR 的第十个地址。
+// Returns "" if neither is configured, leaving the choice to kubeadm, and an ErrTypeConfig error if the service CIDR
+// has no tenth address.
+// 如果两者均未配置则返回 ""，由 kubeadm 决定；如果服务 CIDR 没有第十个地址则返回 ErrTypeConfig 错误。
+func clusterDNSIP(clusterCfg *model.ClusterConfig) (string, error) {
+	if clusterCfg.Network.DNSServiceIP != "" {
+		return clusterCfg.Network.DNSServiceIP, nil
+	}
+	if clusterCfg.Network.ServiceCIDR == "" {
+		return "", nil
+	}
+	first, _, _ := strings.Cut(clusterCfg.Network.ServiceCIDR, ",")
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(first))
+	if err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("invalid service CIDR %q", clusterCfg.Network.ServiceCIDR), err)
+	}
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+	for i := 0; i < 10 && addr.IsValid(); i++ {
+		addr = addr.Next()
+	}
+	if !addr.IsValid() || !prefix.Contains(addr) {
+		return "", errors.New(errors.ErrTypeConfig, fmt.Sprintf(
+			"service CIDR %s is too small for the cluster DNS IP, its tenth address; use a larger CIDR or set network.dnsServiceIP", prefix))
+	}
+	return addr.String(), nil
+}
+
+// newBootstrapToken generates a random kubeadm bootstrap token ("[a-z0-9]{6}.[a-z0-9]{16}").
+// newBootstrapToken 生成一个随机的 kubeadm 引导令牌（"[a-z0-9]{6}.[a-z0-9]{16}"）。
+func newBootstrapToken() (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	// Bytes from 252 up are discarded, so every symbol is equally likely (252 is the largest multiple of 36 below 256).
+	// 丢弃 252 及以上的字节，使每个符号出现的概率相同（252 是小于 256 的 36 的最大倍数）。
+	const limit = 256 - 256%len(alphabet)
+	token := make([]byte, 0, 22)
+	random := make([]byte, 32)
+	for len(token) < cap(token) {
+		if _, err := rand.Read(random); err != nil {
+			return "", errors.NewWithCause(errors.ErrTypeInternal, "failed to generate a bootstrap token", err)
+		}
+		for _, b := range random {
+			if int(b) < limit && len(token) < cap(token) {
+				token = append(token, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(token[:6]) + "." + string(token[6:]), nil
+}
+
+// newCertificateKey generates a random key for encrypting the uploaded control plane certificates (32 bytes, hex).
+// newCertificateKey 生成一个用于加密上传的控制平面证书的随机密钥（32 字节，十六进制）。
+func newCertificateKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeInternal, "failed to generate a certificate key", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// caCertHash computes the "sha256:<hex>" public key pin of a PEM encoded CA certificate, as kubeadm join expects.
+// caCertHash 计算 PEM 编码的 CA 证书的 "sha256:<hex>" 公钥固定值，与 kubeadm join 的期望一致。
+func caCertHash(caPEM []byte) (string, error) {
+	block, _ := pem.Decode(caPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", errors.New(errors.ErrTypeSystem, "cluster CA is not a PEM encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeSystem, "failed to parse the cluster CA certificate", err)
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
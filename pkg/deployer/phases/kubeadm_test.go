@@ -0,0 +1,146 @@
+package phases
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+)
+
+// update rewrites the golden files: go test ./pkg/deployer/phases -run Kubeadm -update
+// update 重写黄金文件：go test ./pkg/deployer/phases -run Kubeadm -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// assertGolden compares got with testdata/<name>, rewriting the file when -update is set.
+// assertGolden 将 got 与 testdata/<name> 进行比较，设置 -update 时重写该文件。
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.WriteFile(path, []byte(got), 0644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), got)
+}
+
+// testHACluster returns a cluster with two masters and a worker exercising every generated setting.
+// testHACluster 返回一个包含两个主节点和一个工作节点的集群，覆盖每个生成的设置。
+func testHACluster() *model.ClusterConfig {
+	clusterCfg := &model.ClusterConfig{
+		Name:              "chasi-bod",
+		KubernetesVersion: "1.30.0",
+		ContainerRuntime:  "containerd",
+		CgroupDriver:      "systemd",
+		APIServerCertSANs: []string{"api.example.com", "10.0.0.1"},
+		Nodes: []model.NodeConfig{
+			{Address: "10.0.0.1", Roles: []enum.NodeRole{enum.RoleMaster}},
+			{Address: "10.0.0.2", Roles: []enum.NodeRole{enum.RoleMaster}},
+			{Address: "10.0.0.3", Roles: []enum.NodeRole{enum.RoleWorker}},
+		},
+	}
+	clusterCfg.Network.PodCIDR = "10.244.0.0/16"
+	clusterCfg.Network.ServiceCIDR = "10.96.0.0/12"
+	clusterCfg.Network.DNSServiceIP = "10.96.0.10"
+	return clusterCfg
+}
+
+var testCredentials = joinCredentials{
+	APIServerEndpoint: "10.0.0.1:6443",
+	Token:             "abcdef.0123456789abcdef",
+	CACertHash:        "sha256:592191cb5594112e082bfd27e9c63b1f7f9d69f3fd656044d312a1709281ea1a",
+	CertificateKey:    "e6a2eb8581237ab72a4f494f30285ec12a9694d750b9785706a83bfcbbbd2204",
+}
+
+func TestRenderKubeadmInitConfig(t *testing.T) {
+	clusterCfg := testHACluster()
+	got, err := renderKubeadmInitConfig(&clusterCfg.Nodes[0], clusterCfg, testCredentials, true)
+	require.NoError(t, err)
+	assertGolden(t, "kubeadm-init-ha.yaml", got)
+
+	single := &model.ClusterConfig{
+		KubernetesVersion: "v1.30.0",
+		ContainerRuntime:  "cri-o",
+		CgroupDriver:      "cgroupfs",
+		Nodes:             []model.NodeConfig{{Address: "192.168.1.10", Roles: []enum.NodeRole{enum.RoleMaster}}},
+	}
+	single.Network.ServiceCIDR = "10.100.0.0/16"
+	got, err = renderKubeadmInitConfig(&single.Nodes[0], single, joinCredentials{Token: testCredentials.Token}, false)
+	require.NoError(t, err)
+	assertGolden(t, "kubeadm-init-single.yaml", got)
+}
+
+func TestRenderKubeadmJoinConfig(t *testing.T) {
+	clusterCfg := testHACluster()
+	got, err := renderKubeadmJoinConfig(&clusterCfg.Nodes[1], clusterCfg, testCredentials)
+	require.NoError(t, err)
+	assertGolden(t, "kubeadm-join-master.yaml", got)
+
+	got, err = renderKubeadmJoinConfig(&clusterCfg.Nodes[2], clusterCfg, testCredentials)
+	require.NoError(t, err)
+	assertGolden(t, "kubeadm-join-worker.yaml", got)
+}
+
+func TestCACertHash(t *testing.T) {
+	// Expected value computed with:
+	// openssl x509 -pubkey -noout -in testdata/ca.crt | openssl rsa -pubin -outform der | sha256sum
+	caPEM, err := os.ReadFile(filepath.Join("testdata", "ca.crt"))
+	require.NoError(t, err)
+	hash, err := caCertHash(caPEM)
+	require.NoError(t, err)
+	assert.Equal(t, testCredentials.CACertHash, hash)
+
+	_, err = caCertHash([]byte("not a certificate"))
+	assert.Error(t, err)
+}
+
+func TestNewBootstrapTokenAndCertificateKey(t *testing.T) {
+	token, err := newBootstrapToken()
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z0-9]{6}\.[a-z0-9]{16}$`), token)
+
+	key, err := newCertificateKey()
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{64}$`), key)
+}
+
+func TestClusterDNSIP(t *testing.T) {
+	tests := []struct {
+		serviceCIDR, dnsServiceIP string
+		want                      string
+		wantErr                   bool
+	}{
+		{serviceCIDR: "10.96.0.0/12", want: "10.96.0.10"},
+		{serviceCIDR: "10.96.0.0/12", dnsServiceIP: "10.96.0.53", want: "10.96.0.53"},
+		{serviceCIDR: "", want: ""},
+		{serviceCIDR: "192.168.1.248/28", want: "192.168.1.250"},
+		{serviceCIDR: "192.168.1.240/28", want: "192.168.1.250"},
+		{serviceCIDR: "10.0.0.248/29", wantErr: true},
+		{serviceCIDR: "10.0.0.255/32", wantErr: true},
+		{serviceCIDR: "255.255.255.248/29", wantErr: true},
+		{serviceCIDR: "fd00:10:96::/112", want: "fd00:10:96::a"},
+		{serviceCIDR: "10.96.0.0/16,fd00:10:96::/112", want: "10.96.0.10"},
+		{serviceCIDR: "10.96.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		clusterCfg := &model.ClusterConfig{}
+		clusterCfg.Network.ServiceCIDR = tt.serviceCIDR
+		clusterCfg.Network.DNSServiceIP = tt.dnsServiceIP
+		ip, err := clusterDNSIP(clusterCfg)
+		if tt.wantErr {
+			require.Error(t, err, tt.serviceCIDR)
+			assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeConfig), tt.serviceCIDR)
+			continue
+		}
+		require.NoError(t, err, tt.serviceCIDR)
+		assert.Equal(t, tt.want, ip, tt.serviceCIDR)
+	}
+}
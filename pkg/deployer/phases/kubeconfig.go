@@ -0,0 +1,96 @@
+package phases
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// DefaultKubeconfigDir is where the admin kubeconfigs of deployed Host Clusters are stored on the deploying machine.
+// DefaultKubeconfigDir 是已部署 Host 集群的管理员 kubeconfig 在部署机器上的存储位置。
+var DefaultKubeconfigDir = filepath.Join(constants.DefaultDataDir, "kubeconfigs")
+
+// KubeconfigPath returns the admin kubeconfig exported for a Host Cluster.
+// KubeconfigPath 返回为 Host 集群导出的管理员 kubeconfig。
+// dir: Directory holding kubeconfigs. / 存放 kubeconfig 的目录。
+// clusterName: The Host Cluster name. / Host 集群名称。
+func KubeconfigPath(dir, clusterName string) string {
+	if clusterName == "" {
+		clusterName = "kubernetes"
+	}
+	return filepath.Join(dir, clusterName+".conf")
+}
+
+// exportKubeconfig copies admin.conf from the first master to the deploying machine, pointing it at the control plane endpoint.
+// exportKubeconfig 将 admin.conf 从第一个主节点复制到部署机器，并使其指向控制平面端点。
+func (p *defaultK8sInstallPhase) exportKubeconfig(ctx context.Context, masterCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error {
+	path := KubeconfigPath(p.kubeconfigDir, clusterCfg.Name)
+	item := "Export the admin kubeconfig to " + path
+	changed, err := p.writeKubeconfig(ctx, masterCfg, clusterCfg, path)
+	switch {
+	case err != nil:
+		plan.Record(ctx, item, plan.StatusFailed, err)
+		return err
+	case changed:
+		utils.GetLogger().Printf("Admin kubeconfig of the Host Cluster written to %s.", path)
+		plan.Record(ctx, item, plan.StatusChanged, nil)
+	default:
+		plan.Record(ctx, item, plan.StatusUnchanged, nil)
+	}
+	return nil
+}
+
+// writeKubeconfig downloads and rewrites admin.conf, and reports whether the local copy changed.
+// writeKubeconfig 下载并重写 admin.conf，并报告本地副本是否已更改。
+func (p *defaultK8sInstallPhase) writeKubeconfig(ctx context.Context, masterCfg *model.NodeConfig, clusterCfg *model.ClusterConfig, path string) (bool, error) {
+	exec, err := connectNode(ctx, p.newExecutor, masterCfg)
+	if err != nil {
+		return false, err
+	}
+	defer exec.Close()
+
+	var raw bytes.Buffer
+	if err := exec.Download(ctx, kubeadmAdminConfigPath, &raw); err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to read %s from %s", kubeadmAdminConfigPath, masterCfg.Address), err)
+	}
+	kubeconfig, err := clientcmd.Load(raw.Bytes())
+	if err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to parse %s from %s", kubeadmAdminConfigPath, masterCfg.Address), err)
+	}
+	if len(kubeconfig.Clusters) == 0 {
+		return false, errors.New(errors.ErrTypeSystem, fmt.Sprintf("%s on %s defines no cluster", kubeadmAdminConfigPath, masterCfg.Address))
+	}
+	server := "https://" + controlPlaneEndpoint(masterCfg, clusterCfg)
+	for _, cluster := range kubeconfig.Clusters {
+		cluster.Server = server
+	}
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeInternal, "failed to render the admin kubeconfig", err)
+	}
+
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create %s", filepath.Dir(path)), err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", tmpPath), err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return false, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to write %s", path), err)
+	}
+	return true, nil
+}
@@ -4,12 +4,13 @@ package phases
 
 import (
 	"context"
+	"fmt"
+
+	"github.com/turtacn/chasi-bod/common/errors"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
 	"github.com/turtacn/chasi-bod/pkg/config/model"
-	"strings"
-	// Assuming you have an SSH client utility
-	// 假设您有一个 SSH 客户端工具
-	// "github.com/turtacn/chasi-bod/pkg/sshutil"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
 )
 
 // NetworkConfigPhase defines the interface for configuring network settings on target nodes.
@@ -25,96 +26,155 @@ type NetworkConfigPhase interface {
 	// Returns an error if the phase fails for the node.
 	// 如果阶段在节点上失败则返回错误。
 	Run(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error
+
+	// Plan returns the actions Run would apply to the node, without contacting it.
+	// Plan 返回 Run 将应用到节点的操作，而不连接节点。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// nodeCfg: The configuration for the specific node. / 特定节点的配置。
+	// clusterCfg: The overall cluster configuration. / 整体集群配置。
+	// Returns the planned actions, or an error if the configuration cannot be rendered.
+	// 返回计划的操作，如果配置无法渲染则返回错误。
+	Plan(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.Action, error)
 }
 
 // NewNetworkConfigPhase creates a new NetworkConfigPhase instance.
 // NewNetworkConfigPhase 创建一个新的 NetworkConfigPhase 实例。
+// newExecutor: Factory used to connect to nodes; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
 // Returns a NetworkConfigPhase implementation.
 // 返回 NetworkConfigPhase 实现。
-func NewNetworkConfigPhase() NetworkConfigPhase {
-	return &DefaultNetworkConfigPhase{}
+func NewNetworkConfigPhase(newExecutor executor.Factory) NetworkConfigPhase {
+	return &DefaultNetworkConfigPhase{newExecutor: newExecutor}
 }
 
 // DefaultNetworkConfigPhase is a default implementation of the NetworkConfigPhase.
 // DefaultNetworkConfigPhase 是 NetworkConfigPhase 的默认实现。
-type DefaultNetworkConfigPhase struct{}
+type DefaultNetworkConfigPhase struct {
+	newExecutor executor.Factory
+}
 
 // Run executes the network configuration phase.
 // Run 执行网络配置阶段。
 func (p *DefaultNetworkConfigPhase) Run(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error {
 	utils.GetLogger().Printf("Running NetworkConfigPhase for node %s", nodeCfg.Address)
 
-	// TODO: Get SSH client for the node
-	// TODO: 获取节点的 SSH 客户端
-	// sshClient, err := sshutil.NewClient(...)
-	// if err != nil { return errors.NewWithCause(...) }
-	// defer sshClient.Close()
-
-	// Step 1: Configure network interfaces (IP addresses, netmask, gateway)
-	// 步骤 1：配置网络接口（IP 地址、子网掩码、网关）
-	if len(clusterCfg.Network.Interfaces) > 0 {
-		utils.GetLogger().Printf("Configuring network interfaces on %s...", nodeCfg.Address)
-		// This is highly OS-dependent (netplan, networkd, ifconfig, ip command)
-		// This involves generating configuration files and applying them remotely.
-		// 这高度依赖于操作系统（netplan, networkd, ifconfig, ip 命令）
-		// 这涉及生成配置文件并远程应用它们。
-		// TODO: Implement remote interface configuration via SSH based on OS
-		// TODO: 根据操作系统通过 SSH 实现远程接口配置
-		for _, ifaceCfg := range clusterCfg.Network.Interfaces {
-			utils.GetLogger().Printf("Configuring interface %s on %s with IPs %s, Gateway %s",
-				ifaceCfg.Name, nodeCfg.Address, strings.Join(ifaceCfg.IPAddrs, ", "), ifaceCfg.Gateway)
-			// Example commands (Debian/Ubuntu):
-			// - Generate netplan YAML locally
-			// - Copy YAML to /etc/netplan/ via SSH/SFTP
-			// - sshClient.RunCommand(ctx, "sudo netplan apply")
-			// Example commands (CentOS/RHEL):
-			// - Generate ifcfg file locally
-			// - Copy to /etc/sysconfig/network-scripts/ via SSH/SFTP
-			// - sshClient.RunCommand(ctx, "sudo systemctl restart network")
-			utils.GetLogger().Printf("Placeholder: Interface %s configured on %s.", ifaceCfg.Name, nodeCfg.Address)
+	exec, err := connectNode(ctx, p.newExecutor, nodeCfg)
+	if err != nil {
+		return err
+	}
+
+	// Steps 1 and 2: Configure network interfaces, routes and DNS resolution through the node's network stack
+	// 步骤 1 和 2：通过节点的网络栈配置网络接口、路由和 DNS 解析
+	exec, err = p.configureInterfaces(ctx, exec, nodeCfg, clusterCfg)
+	if exec != nil {
+		defer exec.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Step 3: Configure the host firewall for the roles of the node
+	// 步骤 3：为节点的角色配置主机防火墙
+	if actions := firewallActions(nodeCfg, clusterCfg); len(actions) > 0 {
+		utils.GetLogger().Printf("Configuring firewall on %s...", nodeCfg.Address)
+		if err := plan.Apply(ctx, exec, actions); err != nil {
+			return err
 		}
-		utils.GetLogger().Printf("Network interfaces configured successfully on %s.", nodeCfg.Address)
-	} else {
-		utils.GetLogger().Printf("No network interfaces defined to configure on %s.", nodeCfg.Address)
+		utils.GetLogger().Printf("Firewall configured on %s.", nodeCfg.Address)
 	}
 
-	// Step 2: Configure routes (if necessary, beyond default gateway)
-	// 步骤 2：配置路由（如果需要，超出默认网关）
-	utils.GetLogger().Printf("Placeholder: Configuring routes on %s.", nodeCfg.Address)
-	// TODO: Implement remote route configuration via SSH using `ip route add` or similar
-	// TODO: 通过 SSH 使用 `ip route add` 或类似命令实现远程路由配置
-
-	// Step 3: Configure firewall rules (e.g., firewalld, iptables, nftables)
-	// 步骤 3：配置防火墙规则（例如，firewalld, iptables, nftables）
-	// Need to open ports for K8s components, SSH, NodePort range etc.
-	// This is also highly OS-dependent.
-	// 需要打开 K8s 组件、SSH、NodePort 范围等的端口
-	// 这也高度依赖于操作系统。
-	utils.GetLogger().Printf("Configuring firewall on %s...", nodeCfg.Address)
-	// TODO: Implement remote firewall configuration via SSH based on OS and required ports
-	// TODO: 根据操作系统和所需端口通过 SSH 实现远程防火墙配置
-	utils.GetLogger().Printf("Placeholder: Firewall configured on %s.", nodeCfg.Address)
-
-	// Step 4: Configure DNS resolution (e.g., /etc/resolv.conf)
-	// 步骤 4：配置 DNS 解析（例如，/etc/resolv.conf）
-	utils.GetLogger().Printf("Configuring DNS resolution on %s...", nodeCfg.Address)
-	// This involves creating or updating /etc/resolv.conf.
-	// 这涉及创建或更新 /etc/resolv.conf。
-	// TODO: Implement remote DNS configuration via SSH
-	utils.GetLogger().Printf("Placeholder: DNS resolution configured on %s.", nodeCfg.Address)
-
-	// Step 5: Wait for network to be ready and accessible (optional but recommended)
-	// 步骤 5：等待网络就绪并可访问（可选但推荐）
+	// Step 4: Wait for network to be ready and accessible (optional but recommended)
+	// 步骤 4：等待网络就绪并可访问（可选但推荐）
 	utils.GetLogger().Printf("Waiting for network to be ready on %s...", nodeCfg.Address)
-	// This might involve checking reachability to a known external service or another node via SSH.
-	// 这可能涉及通过 SSH 检查对已知外部服务或其他节点的的可达性。
-	utils.GetLogger().Printf("Placeholder: Network is ready on %s.", nodeCfg.Address)
+	// A node without a default route cannot reach the other nodes' pod networks through the host gateway.
+	// 没有默认路由的节点无法通过主机网关访问其他节点的 Pod 网络。
+	defaultRoute, err := executor.RunCommand(ctx, exec, "ip route show default")
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to read routing table on %s", nodeCfg.Address), err)
+	}
+	if defaultRoute == "" {
+		utils.GetLogger().Printf("Warning: No default route found on %s.", nodeCfg.Address)
+	} else {
+		utils.GetLogger().Printf("Network is ready on %s (default route: %s).", nodeCfg.Address, defaultRoute)
+	}
 
 	utils.GetLogger().Printf("NetworkConfigPhase completed successfully for node %s", nodeCfg.Address)
 	return nil
 }
 
-// TODO: Implement sshutil package for remote command execution and file transfer
-// TODO: 实现 sshutil 包用于远程命令执行和文件传输
-// TODO: Implement helper functions to generate OS-specific network config files (netplan, ifcfg etc.)
-// TODO: 实现生成操作系统特定网络配置文件的辅助函数（netplan, ifcfg 等）
+// configureInterfaces renders the interfaces, routes and DNS of the node for its network stack and applies them safely.
+// configureInterfaces 为节点的网络栈渲染其接口、路由和 DNS，并安全地应用它们。
+// Returns the executor to keep using (see applyHostNetwork).
+// 返回要继续使用的执行器（参见 applyHostNetwork）。
+func (p *DefaultNetworkConfigPhase) configureInterfaces(ctx context.Context, exec executor.NodeExecutor, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) (executor.NodeExecutor, error) {
+	ifaces, dns := effectiveNetwork(nodeCfg, clusterCfg)
+	if len(ifaces) == 0 {
+		utils.GetLogger().Printf("No network interfaces defined to configure on %s.", nodeCfg.Address)
+		return exec, nil
+	}
+	for _, ifaceCfg := range ifaces {
+		result, err := exec.Run(ctx, "ip link show dev "+executor.ShellQuote(ifaceCfg.Name))
+		if err != nil {
+			return exec, err
+		}
+		if !result.Success() {
+			return exec, errors.New(errors.ErrTypeValidation, fmt.Sprintf("interface %s does not exist on %s", ifaceCfg.Name, nodeCfg.Address))
+		}
+	}
+
+	renderer := clusterCfg.Network.Renderer
+	if renderer == "" {
+		osRelease, err := executor.RunCommand(ctx, exec, "cat /etc/os-release")
+		if err != nil {
+			return exec, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to read /etc/os-release on %s", nodeCfg.Address), err)
+		}
+		if renderer, err = detectNetworkRenderer(executor.ParseOSRelease(osRelease)); err != nil {
+			return exec, err
+		}
+	}
+	files, err := renderNetworkFiles(renderer, ifaces, dns)
+	if err != nil {
+		return exec, err
+	}
+	utils.GetLogger().Printf("Configuring %d network interface(s) on %s with %s...", len(ifaces), nodeCfg.Address, renderer)
+	exec, err = p.applyHostNetwork(ctx, exec, nodeCfg, renderer, files, ifaces)
+	if err != nil {
+		return exec, err
+	}
+	utils.GetLogger().Printf("Network interfaces configured successfully on %s.", nodeCfg.Address)
+	return exec, nil
+}
+
+// Plan returns the actions of the network configuration phase.
+// Plan 返回网络配置阶段的操作。
+// With network.renderer set, the rendered files are shown; otherwise the network stack is only known once the node is reached.
+// The host firewall, when enabled, follows; the remaining steps only verify the node.
+// 设置 network.renderer 时会显示渲染后的文件；否则只有在访问节点后才能知道网络栈。随后是主机防火墙（如已启用）；其余步骤仅验证节点。
+func (p *DefaultNetworkConfigPhase) Plan(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.Action, error) {
+	actions, err := interfaceActions(nodeCfg, clusterCfg)
+	if err != nil {
+		return nil, err
+	}
+	return append(actions, firewallActions(nodeCfg, clusterCfg)...), nil
+}
+
+// interfaceActions returns the planned actions that configure the interfaces, routes and DNS of the node.
+// interfaceActions 返回配置节点接口、路由和 DNS 的计划操作。
+func interfaceActions(nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.Action, error) {
+	ifaces, dns := effectiveNetwork(nodeCfg, clusterCfg)
+	if len(ifaces) == 0 {
+		return nil, nil
+	}
+	renderer := clusterCfg.Network.Renderer
+	if renderer == "" {
+		return []plan.Action{plan.Opaque(fmt.Sprintf("Configure %d host network interface(s) with the network stack detected on the node, reverting automatically if the node becomes unreachable", len(ifaces)))}, nil
+	}
+	files, err := renderNetworkFiles(renderer, ifaces, dns)
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]plan.Action, 0, len(files)+1)
+	for _, file := range files {
+		actions = append(actions, plan.File("Write "+file.Path, file.Path, file.Content, file.Mode))
+	}
+	return append(actions, plan.SudoCommand("Apply the network configuration, reverting automatically if the node becomes unreachable", networkApplyCommand(renderer, ifaces, false))), nil
+}
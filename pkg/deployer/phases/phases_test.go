@@ -0,0 +1,355 @@
+package phases
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor/sshtest"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// newTestNode starts an sshtest server and returns it together with a node config pointing at it.
+// newTestNode 启动一个 sshtest 服务器，并返回它以及指向它的节点配置。
+func newTestNode(t *testing.T) (*sshtest.Server, model.NodeConfig) {
+	t.Helper()
+	utils.InitLogger("info", 0)
+	t.Setenv("HOME", t.TempDir()) // Do not pick up the developer's known_hosts // 不使用开发者的 known_hosts
+	server, err := sshtest.NewServer("root", "secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return server, server.NodeConfig()
+}
+
+// commandIndex returns the index of the first recorded command containing substr, or -1.
+// commandIndex 返回第一个包含 substr 的已记录命令的索引，找不到则返回 -1。
+func commandIndex(commands []string, substr string) int {
+	for i, cmd := range commands {
+		if strings.Contains(cmd, substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// handleFreshNode makes every idempotency check fail, as on a node that has never been deployed.
+// handleFreshNode 使每个幂等性检查都失败，就像在从未部署过的节点上一样。
+func handleFreshNode(server *sshtest.Server) {
+	for _, check := range []string{"test -d /var/lib/chasi-bod", "test -f ", "sysctl -n", "blkid", "grep -qsF", "NeedDaemonReload", "systemctl is-enabled", "previous.conf || exit 1", "cat /var/lib/chasi-bod/runtime/applied"} {
+		server.HandleOutput(check, "", 1)
+	}
+	server.HandleOutput("touch /var/lib/chasi-bod/sysctl/previous.conf", "", 0) // Contains "sysctl -n" but records the values / 包含 "sysctl -n"，但用于记录值
+	// Disks show up in the mount table once mounted, and in /etc/fstab once persisted.
+	// 磁盘挂载后出现在挂载表中，持久化后出现在 /etc/fstab 中。
+	var mu sync.Mutex
+	mounted, persisted := false, false
+	server.Handle("findmnt", func(cmd string, _ []byte, _, _ io.Writer) int {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.Contains(cmd, `UUID="$uuid" /`):
+			mounted = true
+		case strings.Contains(cmd, "mv /etc/fstab.chasi-bod.tmp /etc/fstab"):
+			persisted = true
+		case strings.Contains(cmd, "--fstab"):
+			return exitCode(persisted)
+		default:
+			return exitCode(mounted)
+		}
+		return 0
+	})
+}
+
+// exitCode returns 0 for true and 1 for false, like a shell test.
+// exitCode 对 true 返回 0，对 false 返回 1，就像 shell 测试一样。
+func exitCode(ok bool) int {
+	if ok {
+		return 0
+	}
+	return 1
+}
+
+// testNodePhases returns the node-specific phases in deployment order.
+// testNodePhases 按部署顺序返回节点特定阶段。
+func testNodePhases() []NodeSpecificPhase {
+	return []NodeSpecificPhase{
+		NewInitPhase(nil),
+		NewOSConfigPhase(nil),
+		NewRuntimeConfigPhase(nil),
+		NewNetworkConfigPhase(nil),
+		NewStorageConfigPhase(nil),
+	}
+}
+
+func TestNodePhases_EndToEnd(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	handleFreshNode(server)
+	server.HandleOutput("ip route show default", "default via 10.0.0.1 dev eth0\n", 0)
+
+	nodeCfg.Roles = []enum.NodeRole{enum.RoleMaster}
+	nodeCfg.SysctlConfig = map[string]string{"vm.swappiness": "0", "net.ipv4.ip_forward": "1"}
+	nodeCfg.DiskConfigs = []model.DiskConfig{{Device: "/dev/vdb", Filesystem: "xfs", MountPoint: "/var/lib/containerd"}}
+	clusterCfg := &model.ClusterConfig{ContainerRuntime: "containerd", Nodes: []model.NodeConfig{nodeCfg}}
+
+	report := plan.NewReport()
+	ctx := plan.WithReporter(context.Background(), report, nodeCfg.Address, "node")
+	for _, phase := range testNodePhases() {
+		require.NoError(t, phase.Run(ctx, &nodeCfg, clusterCfg))
+	}
+	assert.Equal(t, plan.Summary{Changed: 10}, report.Summary())
+
+	commands := server.Commands()
+	assert.NotEqual(t, -1, commandIndex(commands, "mkdir -p /var/lib/chasi-bod"))
+	assert.Less(t, commandIndex(commands, `echo "$key = $value" >> /var/lib/chasi-bod/sysctl/previous.conf`), commandIndex(commands, "cat > /etc/sysctl.d/99-chasi-bod.conf"),
+		"the previous values are recorded before they change")
+	assert.Less(t, commandIndex(commands, "cat > /etc/sysctl.d/99-chasi-bod.conf"), commandIndex(commands, "sysctl -p /etc/sysctl.d/99-chasi-bod.conf"))
+	assert.NotEqual(t, -1, commandIndex(commands, `mount -t xfs UUID="$uuid" /var/lib/containerd`))
+	assert.Less(t, commandIndex(commands, "mount -t xfs"), commandIndex(commands, "mv /etc/fstab.chasi-bod.tmp /etc/fstab"))
+	assert.Equal(t, -1, commandIndex(commands, "mkfs"), "disks without format: true must not be formatted")
+	assert.Less(t, commandIndex(commands, "systemctl daemon-reload"), commandIndex(commands, "systemctl enable --now containerd"))
+	assert.Less(t, commandIndex(commands, "cat > /etc/containerd/config.toml"), commandIndex(commands, "systemctl enable --now containerd"))
+	assert.Less(t, commandIndex(commands, "systemctl enable --now containerd"), commandIndex(commands, "systemctl restart containerd"))
+	assert.Less(t, commandIndex(commands, "systemctl restart containerd"), commandIndex(commands, "crictl --runtime-endpoint unix:///var/run/containerd/containerd.sock info"))
+	assert.NotEqual(t, -1, commandIndex(commands, "findmnt -rn --source /dev/vdb --mountpoint /var/lib/containerd"))
+
+	for _, e := range server.Execs() {
+		if strings.Contains(e.Command, "99-chasi-bod.conf.chasi-bod.tmp") {
+			assert.Equal(t, "# Managed by chasi-bod. Do not edit.\nnet.ipv4.ip_forward = 1\nvm.swappiness = 0\n", string(e.Stdin))
+		}
+	}
+}
+
+func TestNodePhases_ConvergedNodeIsNoOp(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	server.HandleOutput("ip route show default", "default via 10.0.0.1 dev eth0\n", 0)
+	server.HandleFile("/etc/sysctl.d/99-chasi-bod.conf", "# Managed by chasi-bod. Do not edit.\nvm.swappiness = 0\n", 0644)
+
+	nodeCfg.SysctlConfig = map[string]string{"vm.swappiness": "0"}
+	nodeCfg.DiskConfigs = []model.DiskConfig{{Device: "/dev/vdb", Filesystem: "xfs", Format: true, MountPoint: "/data"}}
+	clusterCfg := &model.ClusterConfig{ContainerRuntime: "containerd", Nodes: []model.NodeConfig{nodeCfg}}
+	server.HandleFile(containerdConfigPath, renderContainerdConfig(clusterCfg), 0644)
+
+	report := plan.NewReport()
+	ctx := plan.WithReporter(context.Background(), report, nodeCfg.Address, "node")
+	for _, phase := range testNodePhases() {
+		require.NoError(t, phase.Run(ctx, &nodeCfg, clusterCfg))
+	}
+	assert.Equal(t, plan.Summary{Unchanged: 11}, report.Summary())
+
+	commands := server.Commands()
+	assert.NotEqual(t, -1, commandIndex(commands, `test "$(sysctl -n vm.swappiness | xargs)" = 0`))
+	for _, mutating := range []string{"cat >", "sysctl -p", "mkfs", "mount -t", "/etc/fstab.chasi-bod.tmp", "systemctl daemon-reload", "enable --now", "systemctl restart", "mkdir -p /var/lib/chasi-bod"} {
+		assert.Equal(t, -1, commandIndex(commands, mutating), "%s must not run on a converged node", mutating)
+	}
+}
+
+func TestInitPhase_PreflightReportsMissingTool(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	server.HandleOutput("command -v sysctl", "", 1)
+
+	report := NewInitPhase(nil).Preflight(context.Background(), []model.NodeConfig{nodeCfg}, nil, &model.ClusterConfig{})
+	require.Error(t, report.Err())
+	assert.Contains(t, report.Err().Error(), "missing required tools: sysctl")
+	assert.Equal(t, -1, commandIndex(server.Commands(), "mkdir"), "preflight does not change the node")
+}
+
+// uploadedContent returns what was uploaded to remotePath, or "" if nothing was.
+// uploadedContent 返回上传到 remotePath 的内容，如果没有上传则返回 ""。
+func uploadedContent(server *sshtest.Server, remotePath string) string {
+	for _, exec := range server.Execs() {
+		if strings.Contains(exec.Command, "cat > "+remotePath+".chasi-bod.tmp") {
+			return string(exec.Stdin)
+		}
+	}
+	return ""
+}
+
+// handleClusterCA serves the test CA certificate to kubeadm credential lookups.
+// handleClusterCA 为 kubeadm 凭据查询提供测试 CA 证书。
+func handleClusterCA(t *testing.T, server *sshtest.Server) {
+	caPEM, err := os.ReadFile(filepath.Join("testdata", "ca.crt"))
+	require.NoError(t, err)
+	server.HandleOutput("cat /etc/kubernetes/pki/ca.crt", string(caPEM), 0)
+}
+
+// testAdminConf is a minimal admin.conf as written by kubeadm.
+// testAdminConf 是 kubeadm 写入的最小 admin.conf。
+const testAdminConf = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://127.0.0.1:6443
+  name: kubernetes
+contexts:
+- context:
+    cluster: kubernetes
+    user: kubernetes-admin
+  name: kubernetes-admin@kubernetes
+current-context: kubernetes-admin@kubernetes
+users:
+- name: kubernetes-admin
+  user:
+    token: secret
+`
+
+// newTestK8sInstallPhase returns a K8sInstallPhase exporting kubeconfigs to a temporary directory,
+// and makes the test server serve admin.conf.
+// newTestK8sInstallPhase 返回一个将 kubeconfig 导出到临时目录的 K8sInstallPhase，并使测试服务器提供 admin.conf。
+func newTestK8sInstallPhase(t *testing.T, server *sshtest.Server) *defaultK8sInstallPhase {
+	server.HandleOutput("cat /etc/kubernetes/admin.conf", testAdminConf, 0)
+	return &defaultK8sInstallPhase{kubeconfigDir: t.TempDir()}
+}
+
+func TestK8sInstallPhase_InitAndJoin(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	handleFreshNode(server)
+	handleClusterCA(t, server)
+
+	master := nodeCfg
+	master.Roles = []enum.NodeRole{enum.RoleMaster}
+	worker := nodeCfg
+	worker.Roles = []enum.NodeRole{enum.RoleWorker}
+	clusterCfg := &model.ClusterConfig{
+		KubernetesVersion: "v1.30.0",
+		Nodes:             []model.NodeConfig{master, worker},
+	}
+	clusterCfg.Network.PodCIDR = "10.244.0.0/16"
+
+	phase := newTestK8sInstallPhase(t, server)
+	require.NoError(t, phase.Run(context.Background(), clusterCfg.Nodes, clusterCfg))
+
+	commands := server.Commands()
+	initIdx := commandIndex(commands, "kubeadm init --config /var/lib/chasi-bod/kubeadm/init.yaml --upload-certs")
+	joinIdx := commandIndex(commands, "kubeadm join --config /var/lib/chasi-bod/kubeadm/join.yaml")
+	assert.NotEqual(t, -1, initIdx)
+	assert.Less(t, initIdx, joinIdx, "workers join after the control plane is initialized")
+	assert.Equal(t, -1, commandIndex(commands, "kubeadm token create"), "the token registered by kubeadm init is reused")
+
+	initConfig := uploadedContent(server, kubeadmInitConfigPath)
+	assert.Contains(t, initConfig, "kubernetesVersion: v1.30.0")
+	assert.Contains(t, initConfig, "podSubnet: 10.244.0.0/16")
+	token := regexp.MustCompile(`token: ([a-z0-9]{6}\.[a-z0-9]{16})`).FindStringSubmatch(initConfig)
+	require.Len(t, token, 2)
+
+	joinConfig := uploadedContent(server, kubeadmJoinConfigPath)
+	assert.Contains(t, joinConfig, "apiServerEndpoint: 127.0.0.1:6443")
+	assert.Contains(t, joinConfig, "token: "+token[1])
+	assert.Contains(t, joinConfig, "- sha256:592191cb5594112e082bfd27e9c63b1f7f9d69f3fd656044d312a1709281ea1a")
+	assert.NotContains(t, joinConfig, "controlPlane:", "workers do not join the control plane")
+}
+
+func TestK8sInstallPhase_JoinNodeIssuesCredentials(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	server.HandleOutput("test -f /etc/kubernetes/kubelet.conf", "", 1)
+	handleClusterCA(t, server)
+
+	master := nodeCfg
+	master.Roles = []enum.NodeRole{enum.RoleMaster}
+	newMaster := nodeCfg
+	newMaster.Address = "localhost"
+	newMaster.Roles = []enum.NodeRole{enum.RoleMaster}
+	clusterCfg := &model.ClusterConfig{Nodes: []model.NodeConfig{master, newMaster}}
+
+	require.NoError(t, NewK8sInstallPhase(nil).JoinNode(context.Background(), &newMaster, clusterCfg))
+
+	commands := server.Commands()
+	tokenIdx := commandIndex(commands, "kubeadm token create ")
+	uploadIdx := commandIndex(commands, "kubeadm init phase upload-certs --upload-certs --certificate-key ")
+	joinIdx := commandIndex(commands, "kubeadm join --config")
+	assert.NotEqual(t, -1, tokenIdx)
+	assert.NotEqual(t, -1, uploadIdx)
+	assert.Less(t, uploadIdx, joinIdx)
+
+	joinConfig := uploadedContent(server, kubeadmJoinConfigPath)
+	assert.Contains(t, joinConfig, strings.Fields(commands[tokenIdx])[3], "the node joins with the token just created")
+	assert.Contains(t, joinConfig, "certificateKey: "+strings.Fields(commands[uploadIdx])[6])
+	assert.Contains(t, joinConfig, "advertiseAddress: localhost")
+}
+
+func TestK8sInstallPhase_SkipsInitializedCluster(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	master := nodeCfg
+	master.Roles = []enum.NodeRole{enum.RoleMaster}
+	worker := nodeCfg
+	worker.Roles = []enum.NodeRole{enum.RoleWorker}
+	clusterCfg := &model.ClusterConfig{Nodes: []model.NodeConfig{master, worker}}
+
+	phase := newTestK8sInstallPhase(t, server)
+	report := plan.NewReport()
+	ctx := plan.WithReporter(context.Background(), report, plan.ClusterTarget, "kubernetes")
+	require.NoError(t, phase.Run(ctx, clusterCfg.Nodes, clusterCfg))
+	assert.Equal(t, plan.Summary{Changed: 1, Unchanged: 2}, report.Summary(), "only the local kubeconfig is written")
+
+	require.NoError(t, phase.Run(ctx, clusterCfg.Nodes, clusterCfg))
+	assert.Equal(t, plan.Summary{Changed: 1, Unchanged: 5}, report.Summary(), "the exported kubeconfig is up to date")
+
+	commands := server.Commands()
+	assert.NotEqual(t, -1, commandIndex(commands, "test -f /etc/kubernetes/admin.conf"))
+	for _, mutating := range []string{"kubeadm init", "kubeadm token create", "kubeadm join"} {
+		assert.Equal(t, -1, commandIndex(commands, mutating), "%s must not run on an initialized cluster", mutating)
+	}
+}
+
+func TestOSConfigPhase_PlanMatchesWhatRunApplies(t *testing.T) {
+	nodeCfg := model.NodeConfig{
+		Address:      "10.0.0.1",
+		SysctlConfig: map[string]string{"vm.swappiness": "0"},
+		DiskConfigs:  []model.DiskConfig{{Device: "/dev/vdb", Filesystem: "xfs", Format: true, MountPoint: "/data"}},
+	}
+	actions, err := NewOSConfigPhase(nil).Plan(context.Background(), &nodeCfg, &model.ClusterConfig{})
+	require.NoError(t, err)
+	require.Len(t, actions, 6)
+	assert.Equal(t, "Snapshot the current sysctl values", actions[0].Description)
+	assert.Contains(t, actions[0].Check, "for key in vm.swappiness;")
+	assert.Equal(t, plan.File("Persist sysctl settings", "/etc/sysctl.d/99-chasi-bod.conf", "# Managed by chasi-bod. Do not edit.\nvm.swappiness = 0\n", 0644), actions[1])
+	assert.Equal(t, "sysctl -p /etc/sysctl.d/99-chasi-bod.conf", actions[2].Command)
+	assert.Equal(t, `test "$(sysctl -n vm.swappiness | xargs)" = 0`, actions[2].Check)
+	assert.Contains(t, actions[3].Command, "mkfs.xfs /dev/vdb")
+	assert.Equal(t, `test "$(blkid -s TYPE -o value /dev/vdb)" = xfs`, actions[3].Check)
+	assert.Contains(t, actions[4].Command, `mkdir -p /data && mount -t xfs UUID="$uuid" /data`)
+	assert.Equal(t, "findmnt -rn --source /dev/vdb --mountpoint /data >/dev/null", actions[4].Check)
+	assert.Contains(t, actions[5].Command, `echo "UUID=$uuid"' /data xfs defaults 0 0' >> /etc/fstab.chasi-bod.tmp`)
+	assert.Equal(t, `uuid=$(blkid -s UUID -o value /dev/vdb) && test -n "$uuid" && findmnt --fstab -rn --source UUID="$uuid" --mountpoint /data >/dev/null`, actions[5].Check)
+
+	nodeCfg.DiskConfigs[0].Filesystem = ""
+	_, err = NewOSConfigPhase(nil).Plan(context.Background(), &nodeCfg, &model.ClusterConfig{})
+	assert.Error(t, err, "formatting without a filesystem is rejected while planning")
+}
+
+func TestK8sInstallPhase_Plan(t *testing.T) {
+	clusterCfg := &model.ClusterConfig{KubernetesVersion: "v1.30.0", Nodes: []model.NodeConfig{
+		{Address: "10.0.0.1", Roles: []enum.NodeRole{enum.RoleMaster}},
+		{Address: "10.0.0.2", Roles: []enum.NodeRole{enum.RoleMaster}},
+		{Address: "10.0.0.3", Roles: []enum.NodeRole{enum.RoleWorker}},
+	}}
+	targets, err := NewK8sInstallPhase(nil).Plan(context.Background(), clusterCfg.Nodes, clusterCfg)
+	require.NoError(t, err)
+	require.Len(t, targets, 3)
+
+	firstMaster := targets[0].Actions
+	require.Len(t, firstMaster, 4)
+	assert.Equal(t, plan.ActionKubeadmConfig, firstMaster[0].Kind)
+	assert.Equal(t, "/var/lib/chasi-bod/kubeadm/init.yaml", firstMaster[0].Path)
+	assert.Contains(t, firstMaster[0].Content, "controlPlaneEndpoint: 10.0.0.1:6443")
+	assert.Contains(t, firstMaster[0].Content, "token: <bootstrap token>")
+	assert.Equal(t, "kubeadm init --config /var/lib/chasi-bod/kubeadm/init.yaml --upload-certs", firstMaster[1].Command)
+	assert.Equal(t, "test -f /etc/kubernetes/admin.conf", firstMaster[1].Check)
+	assert.Equal(t, "kubeadm init phase upload-certs --upload-certs --certificate-key '<certificate key>'", firstMaster[3].Command)
+
+	assert.Contains(t, targets[1].Actions[0].Content, "certificateKey: <certificate key>")
+	assert.Equal(t, "kubeadm join --config /var/lib/chasi-bod/kubeadm/join.yaml", targets[1].Actions[1].Command)
+	assert.Equal(t, "10.0.0.3", targets[2].Target)
+	assert.NotContains(t, targets[2].Actions[0].Content, "controlPlane:")
+	assert.Equal(t, "test -f /etc/kubernetes/kubelet.conf", targets[2].Actions[1].Check)
+}
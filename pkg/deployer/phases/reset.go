@@ -0,0 +1,151 @@
+package phases
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// cniStatePaths hold the configuration and state the CNI plugins leave on a node.
+// cniStatePaths 保存 CNI 插件在节点上留下的配置和状态。
+var cniStatePaths = []string{"/etc/cni/net.d", "/var/lib/cni", "/var/lib/calico", "/run/flannel", "/var/run/cilium"}
+
+// cniInterfaces are the network interfaces the supported CNI plugins create.
+// cniInterfaces 是支持的 CNI 插件创建的网络接口。
+var cniInterfaces = []string{"cni0", "flannel.1", "vxlan.calico", "cilium_host", "cilium_net", "cilium_vxlan"}
+
+// kubernetesChainPattern matches the iptables chains and rules of kube-proxy and the supported CNI plugins.
+// kubernetesChainPattern 匹配 kube-proxy 和支持的 CNI 插件的 iptables 链和规则。
+const kubernetesChainPattern = "KUBE-|cali-|CILIUM|FLANNEL"
+
+// etcdctlCommand runs etcdctl inside the etcd static pod of a master, authenticating with the kubeadm etcd certificates.
+// etcdctlCommand 在主节点的 etcd 静态 Pod 中运行 etcdctl，并使用 kubeadm 的 etcd 证书进行认证。
+// The container runtime is used because etcdctl is not installed on the host.
+// 使用容器运行时是因为主机上没有安装 etcdctl。
+func etcdctlCommand(clusterCfg *model.ClusterConfig, args string) string {
+	crictl := "crictl --runtime-endpoint " + executor.ShellQuote(criSocket(clusterCfg.ContainerRuntime))
+	return fmt.Sprintf(`%s exec "$(%s ps --name '^etcd$' --state running -q | head -n 1)" etcdctl --endpoints=https://127.0.0.1:2379 `+
+		"--cacert=/etc/kubernetes/pki/etcd/ca.crt --cert=/etc/kubernetes/pki/etcd/server.crt --key=/etc/kubernetes/pki/etcd/server.key %s",
+		crictl, crictl, args)
+}
+
+// findEtcdMember returns the ID of the member named nodeName or peering from nodeAddress in `etcdctl member list -w simple` output.
+// findEtcdMember 在 `etcdctl member list -w simple` 输出中返回名为 nodeName 或从 nodeAddress 建立对等连接的成员 ID。
+func findEtcdMember(memberList, nodeName, nodeAddress string) string {
+	for _, line := range strings.Split(memberList, "\n") {
+		// ID, status, name, peer URLs, client URLs, is learner
+		// ID、状态、名称、对等 URL、客户端 URL、是否为 learner
+		fields := strings.Split(line, ", ")
+		if len(fields) < 4 {
+			continue
+		}
+		if nodeName != "" && fields[2] == nodeName {
+			return fields[0]
+		}
+		for _, peerURL := range strings.Split(fields[3], ",") {
+			if u, err := url.Parse(peerURL); err == nil && u.Hostname() == nodeAddress {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
+
+// RemoveEtcdMember removes the etcd member of a master that is leaving the cluster, from one of the remaining masters.
+// RemoveEtcdMember 从剩余的某个主节点上移除正在离开集群的主节点的 etcd 成员。
+func (p *defaultK8sInstallPhase) RemoveEtcdMember(ctx context.Context, nodeName string, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error {
+	masters, _ := splitNodesByRole(clusterCfg.Nodes)
+	var remaining *model.NodeConfig
+	for i := range masters {
+		if masters[i].Address != nodeCfg.Address {
+			remaining = &masters[i]
+			break
+		}
+	}
+	if remaining == nil {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("cannot remove the etcd member of %s: no other master remains in the configuration", nodeCfg.Address))
+	}
+
+	exec, err := connectNode(ctx, p.newExecutor, remaining)
+	if err != nil {
+		return err
+	}
+	defer exec.Close()
+
+	item := "Remove the etcd member of " + nodeCfg.Address
+	members, err := executor.RunCommand(ctx, exec, etcdctlCommand(clusterCfg, "member list -w simple"), executor.WithSudo())
+	if err != nil {
+		plan.Record(ctx, item, plan.StatusFailed, err)
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to list etcd members on %s", remaining.Address), err)
+	}
+	address := nodeCfg.Address
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	id := findEtcdMember(members, nodeName, address)
+	if id == "" {
+		utils.GetLogger().Printf("Node %s is not an etcd member; nothing to remove.", nodeCfg.Address)
+		plan.Record(ctx, item, plan.StatusUnchanged, nil)
+		return nil
+	}
+	if _, err := executor.RunCommand(ctx, exec, etcdctlCommand(clusterCfg, "member remove "+id), executor.WithSudo(), executor.WithLogging(remaining.Address)); err != nil {
+		plan.Record(ctx, item, plan.StatusFailed, err)
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to remove etcd member %s of %s", id, nodeCfg.Address), err)
+	}
+	utils.GetLogger().Printf("Removed etcd member %s of node %s.", id, nodeCfg.Address)
+	plan.Record(ctx, item, plan.StatusChanged, nil)
+	return nil
+}
+
+// resetActions returns the actions that undo kubeadm and the CNI on a node leaving the cluster.
+// resetActions 返回在离开集群的节点上撤销 kubeadm 和 CNI 的操作。
+func resetActions(nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) []plan.Action {
+	actions := []plan.Action{
+		// The etcd member was already removed from a remaining master; kubeadm only warns when it cannot remove it again.
+		// etcd 成员已从剩余的主节点上移除；kubeadm 无法再次移除时只会发出警告。
+		plan.SudoCommand("Reset kubeadm", "kubeadm reset --force --cri-socket "+executor.ShellQuote(criSocket(clusterCfg.ContainerRuntime))).
+			WithCheck("! test -e " + kubeletConfigPath + " && ! test -e /etc/kubernetes/manifests/kube-apiserver.yaml"),
+	}
+	if hasRole(nodeCfg, enum.RoleMaster) && clusterCfg.ControlPlaneEndpoint != nil && clusterCfg.ControlPlaneEndpoint.Mode == enum.EndpointModeKeepalived {
+		actions = append(actions, plan.SudoCommand("Stop keepalived and haproxy", "systemctl disable --now keepalived haproxy").
+			WithCheck("! systemctl is-active --quiet keepalived && ! systemctl is-active --quiet haproxy"))
+	}
+	quoted := make([]string, len(cniStatePaths))
+	for i, path := range cniStatePaths {
+		quoted[i] = executor.ShellQuote(path)
+	}
+	actions = append(actions,
+		plan.SudoCommand("Remove the CNI configuration and state", "rm -rf "+strings.Join(quoted, " ")).
+			WithCheck("! test -e "+strings.Join(quoted, " && ! test -e ")),
+		plan.SudoCommand("Delete the CNI network interfaces", fmt.Sprintf("for link in %s; do ip link delete \"$link\" 2>/dev/null || true; done", strings.Join(cniInterfaces, " "))).
+			WithCheck(fmt.Sprintf("for link in %s; do ! ip link show \"$link\" >/dev/null 2>&1 || exit 1; done", strings.Join(cniInterfaces, " "))),
+		// Only the chains of kube-proxy and the CNI plugins are dropped, so host firewall rules (and SSH access) survive.
+		// 只删除 kube-proxy 和 CNI 插件的链，因此主机防火墙规则（以及 SSH 访问）得以保留。
+		plan.SudoCommand("Remove the Kubernetes iptables and IPVS rules", fmt.Sprintf("for tool in iptables ip6tables; do if command -v $tool-save >/dev/null; then $tool-save | grep -v -E %s | $tool-restore; fi; done && "+
+			"if command -v ipvsadm >/dev/null; then ipvsadm --clear; fi", executor.ShellQuote(kubernetesChainPattern))).
+			WithCheck(fmt.Sprintf("! { iptables-save; ip6tables-save; } 2>/dev/null | grep -q -E %s", executor.ShellQuote(kubernetesChainPattern))),
+		plan.SudoCommand("Remove the kubeadm configurations", "rm -rf "+executor.ShellQuote(kubeadmConfigDir)).
+			WithCheck("! test -e "+executor.ShellQuote(kubeadmConfigDir)),
+	)
+	return actions
+}
+
+// ResetNode runs kubeadm reset on a node leaving the cluster and cleans up the CNI and iptables state.
+// ResetNode 在离开集群的节点上运行 kubeadm reset，并清理 CNI 和 iptables 状态。
+func (p *defaultK8sInstallPhase) ResetNode(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error {
+	exec, err := connectNode(ctx, p.newExecutor, nodeCfg)
+	if err != nil {
+		return err
+	}
+	defer exec.Close()
+	return plan.Apply(ctx, exec, resetActions(nodeCfg, clusterCfg))
+}
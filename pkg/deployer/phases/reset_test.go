@@ -0,0 +1,75 @@
+package phases
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+const testEtcdMembers = `8e9e05c52164694d, started, master-1, https://10.0.0.1:2380, https://10.0.0.1:2379, false
+91bc3c398fb3c146, started, master-2, https://10.0.0.2:2380, https://10.0.0.2:2379, false
+fd422379fda50e48, started, localhost, https://[fd00::3]:2380, https://[fd00::3]:2379, false
+`
+
+func TestFindEtcdMember(t *testing.T) {
+	assert.Equal(t, "91bc3c398fb3c146", findEtcdMember(testEtcdMembers, "master-2", "192.168.0.9"), "matched by name")
+	assert.Equal(t, "91bc3c398fb3c146", findEtcdMember(testEtcdMembers, "", "10.0.0.2"), "matched by peer URL")
+	assert.Equal(t, "fd422379fda50e48", findEtcdMember(testEtcdMembers, "", "fd00::3"))
+	assert.Empty(t, findEtcdMember(testEtcdMembers, "master-4", "10.0.0.4"))
+}
+
+func TestK8sInstallPhase_RemoveEtcdMember(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	server.HandleOutput("member list -w simple", testEtcdMembers, 0)
+
+	master := nodeCfg
+	master.Roles = []enum.NodeRole{enum.RoleMaster}
+	leaving := nodeCfg
+	leaving.Address = "localhost"
+	leaving.Roles = []enum.NodeRole{enum.RoleMaster}
+	clusterCfg := &model.ClusterConfig{Nodes: []model.NodeConfig{master}}
+
+	report := plan.NewReport()
+	ctx := plan.WithReporter(context.Background(), report, leaving.Address, "kubernetes")
+	phase := NewK8sInstallPhase(nil)
+	require.NoError(t, phase.RemoveEtcdMember(ctx, "localhost", &leaving, clusterCfg))
+	assert.NotEqual(t, -1, commandIndex(server.Commands(), "etcdctl --endpoints=https://127.0.0.1:2379"))
+	assert.NotEqual(t, -1, commandIndex(server.Commands(), "member remove fd422379fda50e48"))
+
+	require.NoError(t, phase.RemoveEtcdMember(ctx, "gone", &model.NodeConfig{Address: "10.0.0.9"}, clusterCfg))
+	assert.Equal(t, plan.Summary{Changed: 1, Unchanged: 1}, report.Summary(), "a node that is not a member is left alone")
+
+	err := phase.RemoveEtcdMember(ctx, "", &master, clusterCfg)
+	assert.Error(t, err, "the last master cannot leave etcd")
+}
+
+func TestK8sInstallPhase_ResetNode(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	handleFreshNode(server)
+	// Every check fails, as on a node that is still part of the cluster.
+	// 每个检查都失败，就像在仍属于集群的节点上一样。
+	for _, check := range []string{"! test -e", "! systemctl is-active", "ip link show", "! {"} {
+		server.HandleOutput(check, "", 1)
+	}
+
+	nodeCfg.Roles = []enum.NodeRole{enum.RoleMaster}
+	clusterCfg := &model.ClusterConfig{
+		ContainerRuntime:     "containerd",
+		ControlPlaneEndpoint: &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeKeepalived},
+	}
+	require.NoError(t, NewK8sInstallPhase(nil).ResetNode(context.Background(), &nodeCfg, clusterCfg))
+
+	commands := server.Commands()
+	resetIdx := commandIndex(commands, "kubeadm reset --force --cri-socket")
+	assert.NotEqual(t, -1, resetIdx)
+	assert.Less(t, resetIdx, commandIndex(commands, "rm -rf /etc/cni/net.d"))
+	assert.NotEqual(t, -1, commandIndex(commands, "systemctl disable --now keepalived haproxy"))
+	assert.NotEqual(t, -1, commandIndex(commands, "$tool-save | grep -v -E"))
+	assert.Equal(t, -1, commandIndex(commands, "iptables -F"), "host firewall rules are kept")
+}
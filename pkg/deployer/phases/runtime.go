@@ -4,13 +4,25 @@ package phases
 
 import (
 	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/turtacn/chasi-bod/common/errors"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
 	"github.com/turtacn/chasi-bod/pkg/config/model"
-	// Assuming you have an SSH client utility
-	// 假设您有一个 SSH 客户端工具
-	// "github.com/turtacn/chasi-bod/pkg/sshutil"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
 )
 
+// runtimeHealthCheckInterval is the delay between runtime health check attempts.
+// runtimeHealthCheckInterval 是运行时健康检查尝试之间的间隔。
+const runtimeHealthCheckInterval = 2 * time.Second
+
+// runtimeHealthCheckAttempts is how many times the runtime health check is tried before giving up.
+// runtimeHealthCheckAttempts 是运行时健康检查在放弃前尝试的次数。
+const runtimeHealthCheckAttempts = 15
+
 // RuntimeConfigPhase defines the interface for configuring and starting the container runtime.
 // RuntimeConfigPhase 定义了配置和启动容器运行时的接口。
 // This phase assumes the runtime binaries are already present in the image (installed by builder).
@@ -24,76 +36,129 @@ type RuntimeConfigPhase interface {
 	// Returns an error if the phase fails for the node.
 	// 如果阶段在节点上失败则返回错误。
 	Run(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error
+
+	// Plan returns the actions Run would apply to the node, without contacting it.
+	// Plan 返回 Run 将应用到节点的操作，而不连接节点。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// nodeCfg: The configuration for the specific node. / 特定节点的配置。
+	// clusterCfg: The overall cluster configuration. / 整体集群配置。
+	// Returns the planned actions, or an error if the configuration cannot be rendered.
+	// 返回计划的操作，如果配置无法渲染则返回错误。
+	Plan(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.Action, error)
 }
 
 // NewRuntimeConfigPhase creates a new RuntimeConfigPhase instance.
 // NewRuntimeConfigPhase 创建一个新的 RuntimeConfigPhase 实例。
+// newExecutor: Factory used to connect to nodes; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
 // Returns a RuntimeConfigPhase implementation.
 // 返回 RuntimeConfigPhase 实现。
-func NewRuntimeConfigPhase() RuntimeConfigPhase {
-	return &DefaultRuntimeConfigPhase{}
+func NewRuntimeConfigPhase(newExecutor executor.Factory) RuntimeConfigPhase {
+	return &DefaultRuntimeConfigPhase{newExecutor: newExecutor}
 }
 
 // DefaultRuntimeConfigPhase is a default implementation of the RuntimeConfigPhase.
 // DefaultRuntimeConfigPhase 是 RuntimeConfigPhase 的默认实现。
-type DefaultRuntimeConfigPhase struct{}
+type DefaultRuntimeConfigPhase struct {
+	newExecutor executor.Factory
+}
 
 // Run executes the container runtime configuration phase.
 // Run 执行容器运行时配置阶段。
 func (p *DefaultRuntimeConfigPhase) Run(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) error {
 	utils.GetLogger().Printf("Running RuntimeConfigPhase for node %s (Runtime: %s)", nodeCfg.Address, clusterCfg.ContainerRuntime)
 
-	// TODO: Get SSH client for the node
-	// TODO: 获取节点的 SSH 客户端
-	// sshClient, err := sshutil.NewClient(...)
-	// if err != nil { return errors.NewWithCause(...) }
-	// defer sshClient.Close()
-
-	// Step 1: Generate or update container runtime configuration file
-	// 步骤 1：生成或更新容器运行时配置文件
-	utils.GetLogger().Printf("Generating runtime configuration for %s on %s...", clusterCfg.ContainerRuntime, nodeCfg.Address)
-	// This might involve:
-	// - Setting cgroup driver (should match Kubelet)
-	// - Configuring registries, proxies, etc.
-	// This config file template should ideally be in the image, and this phase applies node-specific values or updates.
-	// 这可能涉及：
-	// - 设置 cgroup 驱动（应与 Kubelet 匹配）
-	// - 配置注册表、代理等。
-	// 这个配置文件模板理想情况下应该在镜像中，此阶段应用节点特定的值或更新。
-	// TODO: Implement remote config file generation/update via SSH
-	// Need to determine config file path based on runtime (e.g., /etc/containerd/config.toml)
-	// 需要根据运行时确定配置文件路径（例如，/etc/containerd/config.toml）
-	// Might need to use a template engine remotely or generate locally and copy.
-	// 可能需要远程使用模板引擎或在本地生成后复制。
-	utils.GetLogger().Printf("Placeholder: Runtime configuration generated for %s on %s.", clusterCfg.ContainerRuntime, nodeCfg.Address)
-
-	// Step 2: Reload systemd manager configuration
-	// 步骤 2：重新加载 systemd 管理器配置
-	utils.GetLogger().Printf("Reloading systemd daemon on %s...", nodeCfg.Address)
-	// TODO: sshClient.RunCommand(ctx, "sudo systemctl daemon-reload")
-	utils.GetLogger().Printf("Placeholder: Systemd daemon reloaded on %s.", nodeCfg.Address)
-
-	// Step 3: Enable and start the container runtime service
-	// 步骤 3：启用并启动容器运行时服务
-	runtimeServiceName := clusterCfg.ContainerRuntime // Assuming service name matches runtime name (e.g., containerd, crio, docker)
-	utils.GetLogger().Printf("Enabling and starting %s service on %s...", runtimeServiceName, nodeCfg.Address)
-	// TODO: sshClient.RunCommand(ctx, fmt.Sprintf("sudo systemctl enable %s", runtimeServiceName))
-	// TODO: sshClient.RunCommand(ctx, fmt.Sprintf("sudo systemctl start %s", runtimeServiceName))
-	utils.GetLogger().Printf("Placeholder: %s service enabled and started on %s.", runtimeServiceName, nodeCfg.Address)
+	exec, err := connectNode(ctx, p.newExecutor, nodeCfg)
+	if err != nil {
+		return err
+	}
+	defer exec.Close()
+
+	// Steps 1 to 3: Write the runtime configuration, then enable and start the runtime, restarting it when its configuration changed
+	// 步骤 1 到 3：写入运行时配置，然后启用并启动运行时，并在其配置更改时重启它
+	runtimeServiceName, healthCheckCmd := runtimeServiceAndHealthCheck(clusterCfg.ContainerRuntime)
+	actions, err := p.Plan(ctx, nodeCfg, clusterCfg)
+	if err != nil {
+		return err
+	}
+	utils.GetLogger().Printf("Configuring and starting %s on %s...", runtimeServiceName, nodeCfg.Address)
+	if err := plan.Apply(ctx, exec, actions); err != nil {
+		return err
+	}
+	utils.GetLogger().Printf("%s service configured and started on %s.", runtimeServiceName, nodeCfg.Address)
 
 	// Step 4: Wait for the runtime service to be healthy
 	// 步骤 4：等待运行时服务健康
 	utils.GetLogger().Printf("Waiting for %s service to be healthy on %s...", runtimeServiceName, nodeCfg.Address)
-	// TODO: Implement health check (e.g., check socket file existence, run `crictl info` or `docker info` via SSH)
-	// TODO: 实现健康检查（例如，检查 socket 文件是否存在，通过 SSH 运行 `crictl info` 或 `docker info`）
-	// Example: checkCmd := "sudo crictl info"
-	// err = sshClient.RunCommandUntilSuccess(ctx, checkCmd, 10*time.Second, 1*time.Second) // Assuming a helper exists
-	// if err != nil { return errors.NewWithCause(...) }
-	utils.GetLogger().Printf("Placeholder: %s service is healthy on %s.", runtimeServiceName, nodeCfg.Address)
+	var lastErr error
+	for attempt := 1; attempt <= runtimeHealthCheckAttempts; attempt++ {
+		if _, lastErr = executor.RunCommand(ctx, exec, healthCheckCmd, executor.WithSudo()); lastErr == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return errors.NewWithCause(errors.ErrTypeTimeout, fmt.Sprintf("timed out waiting for %s on %s", runtimeServiceName, nodeCfg.Address), ctx.Err())
+		case <-time.After(runtimeHealthCheckInterval):
+		}
+	}
+	if lastErr != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("%s did not become healthy on %s", runtimeServiceName, nodeCfg.Address), lastErr)
+	}
+	utils.GetLogger().Printf("%s service is healthy on %s.", runtimeServiceName, nodeCfg.Address)
 
 	utils.GetLogger().Printf("RuntimeConfigPhase completed successfully for node %s", nodeCfg.Address)
 	return nil
 }
 
-// TODO: Implement helper functions for remote command execution, file transfer, etc. using sshutil
-// TODO: 使用 sshutil 实现远程命令执行、文件传输等辅助函数
+// Plan returns the configuration and service actions of the runtime configuration phase; each is skipped when the node is already in shape.
+// The health check after them is read-only.
+// Plan 返回运行时配置阶段的配置和服务操作；当节点已处于正确状态时跳过各操作。
+// 其后的健康检查是只读的。
+func (p *DefaultRuntimeConfigPhase) Plan(ctx context.Context, nodeCfg *model.NodeConfig, clusterCfg *model.ClusterConfig) ([]plan.Action, error) {
+	runtimeServiceName, _ := runtimeServiceAndHealthCheck(clusterCfg.ContainerRuntime)
+	files, err := runtimeConfigFiles(clusterCfg)
+	if err != nil {
+		return nil, err
+	}
+	actions := append(files,
+		plan.SudoCommand("Reload the systemd manager configuration", "systemctl daemon-reload").
+			WithCheck(fmt.Sprintf("test \"$(systemctl show -p NeedDaemonReload --value %s)\" != yes", runtimeServiceName)),
+		plan.SudoCommand(fmt.Sprintf("Enable and start %s", runtimeServiceName), "systemctl enable --now "+runtimeServiceName).
+			WithCheck(fmt.Sprintf("systemctl is-enabled --quiet %s && systemctl is-active --quiet %s", runtimeServiceName, runtimeServiceName)),
+	)
+	if len(files) == 0 {
+		return actions, nil
+	}
+	// The runtime only reads its configuration at startup, so it is restarted once per configuration.
+	// 运行时只在启动时读取其配置，因此每个配置只重启一次。
+	hash := runtimeConfigHash(files)
+	return append(actions,
+		plan.SudoCommand(fmt.Sprintf("Restart %s to load its configuration", runtimeServiceName),
+			fmt.Sprintf("systemctl restart %s && mkdir -p %s && echo %s > %s", runtimeServiceName, path.Dir(runtimeStampPath), hash, runtimeStampPath)).
+			WithCheck(fmt.Sprintf("test \"$(cat %s 2>/dev/null)\" = %s", runtimeStampPath, hash)),
+	), nil
+}
+
+// runtimeServiceAndHealthCheck maps a configured container runtime to its systemd unit and health check command.
+// runtimeServiceAndHealthCheck 将配置的容器运行时映射到其 systemd 单元和健康检查命令。
+func runtimeServiceAndHealthCheck(runtime string) (string, string) {
+	service := runtimeServiceName(runtime)
+	if service == "docker" {
+		return service, "docker info"
+	}
+	return service, "crictl --runtime-endpoint " + criSocket(runtime) + " info"
+}
+
+// runtimeServiceName maps a configured container runtime to its systemd unit.
+// runtimeServiceName 将配置的容器运行时映射到其 systemd 单元。
+func runtimeServiceName(runtime string) string {
+	switch runtime {
+	case "cri-o", "crio":
+		return "crio"
+	case "docker":
+		return "docker"
+	default:
+		// containerd is the default runtime
+		// containerd 是默认运行时
+		return "containerd"
+	}
+}
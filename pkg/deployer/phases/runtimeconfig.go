@@ -0,0 +1,291 @@
+package phases
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+const (
+	// defaultSandboxImage is the pod sandbox image used when the configuration does not pin one.
+	// defaultSandboxImage 是配置未指定时使用的 Pod 沙箱镜像。
+	defaultSandboxImage = "registry.k8s.io/pause:3.9"
+
+	containerdConfigPath   = "/etc/containerd/config.toml"
+	containerdRegistryDir  = "/etc/containerd/certs.d"
+	crioConfigPath         = "/etc/crio/crio.conf.d/90-chasi-bod.conf"
+	crioAuthPath           = "/etc/crio/auth.json"
+	containersRegistryPath = "/etc/containers/registries.conf.d/90-chasi-bod.conf"
+	containersCertsDir     = "/etc/containers/certs.d"
+)
+
+// runtimeStampPath records the hash of the configuration the runtime was last restarted with.
+// runtimeStampPath 记录运行时上次重启时所用配置的哈希。
+var runtimeStampPath = path.Join(constants.DefaultDataDir, "runtime", "applied")
+
+// registryFiles reads the TLS files of a registry and returns them as files under dir/<host>.
+// registryFiles 读取仓库的 TLS 文件，并将其作为 dir/<host> 下的文件返回。
+// The names follow containers-certs.d, which containerd reads as well through hosts.toml.
+// 文件名遵循 containers-certs.d 约定，containerd 也通过 hosts.toml 读取它们。
+func registryFiles(registry model.RegistryConfig, dir string) ([]plan.Action, error) {
+	var files []plan.Action
+	for _, f := range []struct {
+		local, name string
+		mode        os.FileMode
+		secret      bool
+	}{
+		{registry.CAFile, "ca.crt", 0644, false},
+		{registry.CertFile, "client.cert", 0644, false},
+		{registry.KeyFile, "client.key", 0600, true},
+	} {
+		if f.local == "" {
+			continue
+		}
+		content, err := os.ReadFile(f.local)
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to read %s of registry %s", f.local, registry.Host), err)
+		}
+		remote := path.Join(dir, registry.Host, f.name)
+		if f.secret {
+			files = append(files, plan.SecretFile("Write "+remote, remote, string(content), f.mode))
+		} else {
+			files = append(files, plan.File("Write "+remote, remote, string(content), f.mode))
+		}
+	}
+	return files, nil
+}
+
+// tomlString quotes s as a TOML basic string.
+// tomlString 将 s 引用为 TOML 基本字符串。
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ' || r == 0x7f:
+			fmt.Fprintf(&b, "\\u%04x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// registryServer returns the URL containerd pulls from for a registry host; Docker Hub is served from registry-1.docker.io.
+// registryServer 返回 containerd 为仓库主机拉取的 URL；Docker Hub 由 registry-1.docker.io 提供服务。
+func registryServer(host string) string {
+	if host == "docker.io" {
+		return "https://registry-1.docker.io"
+	}
+	return "https://" + host
+}
+
+// renderContainerdConfig renders /etc/containerd/config.toml.
+// renderContainerdConfig 渲染 /etc/containerd/config.toml。
+// Mirrors and TLS settings live in hosts.toml files under config_path; only credentials stay in config.toml.
+// 镜像和 TLS 设置位于 config_path 下的 hosts.toml 文件中；只有凭据保留在 config.toml 中。
+func renderContainerdConfig(clusterCfg *model.ClusterConfig) string {
+	runtimeCfg := clusterCfg.RuntimeConfig
+	sandboxImage := runtimeCfg.SandboxImage
+	if sandboxImage == "" {
+		sandboxImage = defaultSandboxImage
+	}
+
+	var b strings.Builder
+	b.WriteString(managedFileHeader)
+	b.WriteString("version = 2\n")
+	if runtimeCfg.DataRoot != "" {
+		fmt.Fprintf(&b, "root = %s\n", tomlString(runtimeCfg.DataRoot))
+	}
+	const cri = `plugins."io.containerd.grpc.v1.cri"`
+	fmt.Fprintf(&b, "\n[%s]\n  sandbox_image = %s\n", cri, tomlString(sandboxImage))
+	fmt.Fprintf(&b, "\n[%s.containerd]\n  default_runtime_name = \"runc\"\n", cri)
+	fmt.Fprintf(&b, "\n[%s.containerd.runtimes.runc]\n  runtime_type = \"io.containerd.runc.v2\"\n", cri)
+	fmt.Fprintf(&b, "\n[%s.containerd.runtimes.runc.options]\n  SystemdCgroup = %t\n", cri, cgroupDriver(clusterCfg) == "systemd")
+	fmt.Fprintf(&b, "\n[%s.registry]\n  config_path = %s\n", cri, tomlString(containerdRegistryDir))
+	for _, registry := range runtimeCfg.Registries {
+		if registry.Username == "" {
+			continue
+		}
+		authHost := strings.TrimPrefix(registryServer(registry.Host), "https://")
+		fmt.Fprintf(&b, "\n[%s.registry.configs.%s.auth]\n  username = %s\n  password = %s\n", cri, tomlString(authHost), tomlString(registry.Username), tomlString(registry.Password))
+	}
+	return b.String()
+}
+
+// renderContainerdHosts renders the hosts.toml of a registry: its mirrors in order, then the registry itself.
+// renderContainerdHosts 渲染仓库的 hosts.toml：按顺序列出其镜像，然后是仓库本身。
+func renderContainerdHosts(registry model.RegistryConfig) string {
+	dir := path.Join(containerdRegistryDir, registry.Host)
+	var tls []string
+	if registry.CAFile != "" {
+		tls = append(tls, "ca = "+tomlString(path.Join(dir, "ca.crt")))
+	}
+	if registry.CertFile != "" {
+		tls = append(tls, fmt.Sprintf("client = [[%s, %s]]", tomlString(path.Join(dir, "client.cert")), tomlString(path.Join(dir, "client.key"))))
+	}
+	if registry.InsecureSkipVerify {
+		tls = append(tls, "skip_verify = true")
+	}
+
+	var b strings.Builder
+	b.WriteString(managedFileHeader)
+	fmt.Fprintf(&b, "server = %s\n", tomlString(registryServer(registry.Host)))
+	// The top-level TLS settings apply to the server, the per-host ones to the mirrors.
+	// 顶层 TLS 设置适用于服务器，每个主机的设置适用于镜像。
+	for _, line := range tls {
+		b.WriteString(line + "\n")
+	}
+	for _, mirror := range registry.Mirrors {
+		fmt.Fprintf(&b, "\n[host.%s]\n  capabilities = [\"pull\", \"resolve\"]\n", tomlString(mirror))
+		for _, line := range tls {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// renderCRIOConfig renders the CRI-O drop-in configuration.
+// renderCRIOConfig 渲染 CRI-O 的附加配置。
+func renderCRIOConfig(clusterCfg *model.ClusterConfig, withAuth bool) string {
+	runtimeCfg := clusterCfg.RuntimeConfig
+	sandboxImage := runtimeCfg.SandboxImage
+	if sandboxImage == "" {
+		sandboxImage = defaultSandboxImage
+	}
+
+	var b strings.Builder
+	b.WriteString(managedFileHeader)
+	if runtimeCfg.DataRoot != "" {
+		fmt.Fprintf(&b, "[crio]\nroot = %s\n\n", tomlString(runtimeCfg.DataRoot))
+	}
+	fmt.Fprintf(&b, "[crio.runtime]\ncgroup_manager = %s\nconmon_cgroup = \"pod\"\n", tomlString(cgroupDriver(clusterCfg)))
+	fmt.Fprintf(&b, "\n[crio.image]\npause_image = %s\n", tomlString(sandboxImage))
+	if withAuth {
+		fmt.Fprintf(&b, "global_auth_file = %s\n", tomlString(crioAuthPath))
+	}
+	return b.String()
+}
+
+// renderContainersRegistries renders the containers-registries.conf drop-in that CRI-O reads mirrors from.
+// renderContainersRegistries 渲染 CRI-O 从中读取镜像的 containers-registries.conf 附加配置。
+// Mirror locations carry no scheme there; plain HTTP mirrors are marked insecure.
+// 其中的镜像位置不带协议；纯 HTTP 镜像被标记为不安全。
+func renderContainersRegistries(registries []model.RegistryConfig) string {
+	var b strings.Builder
+	b.WriteString(managedFileHeader)
+	for i, registry := range registries {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[[registry]]\nprefix = %[1]s\nlocation = %[1]s\ninsecure = %[2]t\n", tomlString(registry.Host), registry.InsecureSkipVerify)
+		for _, mirror := range registry.Mirrors {
+			insecure := registry.InsecureSkipVerify
+			location := mirror
+			if u, err := url.Parse(mirror); err == nil && u.Host != "" {
+				location = strings.TrimSuffix(u.Host+u.Path, "/")
+				insecure = insecure || u.Scheme == "http"
+			}
+			fmt.Fprintf(&b, "\n[[registry.mirror]]\nlocation = %s\ninsecure = %t\n", tomlString(location), insecure)
+		}
+	}
+	return b.String()
+}
+
+// renderCRIOAuth renders the registry credentials as a containers-auth.json file.
+// renderCRIOAuth 将仓库凭据渲染为 containers-auth.json 文件。
+func renderCRIOAuth(registries []model.RegistryConfig) (string, error) {
+	type entry struct {
+		Auth string `json:"auth"`
+	}
+	auths := map[string]entry{}
+	for _, registry := range registries {
+		if registry.Username != "" {
+			auths[registry.Host] = entry{Auth: base64.StdEncoding.EncodeToString([]byte(registry.Username + ":" + registry.Password))}
+		}
+	}
+	if len(auths) == 0 {
+		return "", nil
+	}
+	out, err := json.MarshalIndent(map[string]interface{}{"auths": auths}, "", "  ")
+	if err != nil {
+		return "", errors.NewWithCause(errors.ErrTypeInternal, "failed to render registry credentials", err)
+	}
+	return string(out) + "\n", nil
+}
+
+// runtimeConfigFiles renders the container runtime configuration files of the cluster.
+// runtimeConfigFiles 渲染集群的容器运行时配置文件。
+// Runtimes other than containerd and CRI-O keep their own configuration and get no files.
+// containerd 和 CRI-O 以外的运行时保留其自身配置，不会生成任何文件。
+func runtimeConfigFiles(clusterCfg *model.ClusterConfig) ([]plan.Action, error) {
+	registries := clusterCfg.RuntimeConfig.Registries
+	var files []plan.Action
+	switch runtimeServiceName(clusterCfg.ContainerRuntime) {
+	case "containerd":
+		withAuth := false
+		for _, registry := range registries {
+			withAuth = withAuth || registry.Username != ""
+		}
+		config := renderContainerdConfig(clusterCfg)
+		if withAuth {
+			files = append(files, plan.SecretFile("Write the containerd configuration", containerdConfigPath, config, 0600))
+		} else {
+			files = append(files, plan.File("Write the containerd configuration", containerdConfigPath, config, 0644))
+		}
+		for _, registry := range registries {
+			hostsPath := path.Join(containerdRegistryDir, registry.Host, "hosts.toml")
+			files = append(files, plan.File("Write "+hostsPath, hostsPath, renderContainerdHosts(registry), 0644))
+			tlsFiles, err := registryFiles(registry, containerdRegistryDir)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, tlsFiles...)
+		}
+	case "crio":
+		auth, err := renderCRIOAuth(registries)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, plan.File("Write the CRI-O configuration", crioConfigPath, renderCRIOConfig(clusterCfg, auth != ""), 0644))
+		if auth != "" {
+			files = append(files, plan.SecretFile("Write the registry credentials", crioAuthPath, auth, 0600))
+		}
+		if len(registries) > 0 {
+			files = append(files, plan.File("Write the registry mirrors", containersRegistryPath, renderContainersRegistries(registries), 0644))
+		}
+		for _, registry := range registries {
+			tlsFiles, err := registryFiles(registry, containersCertsDir)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, tlsFiles...)
+		}
+	}
+	return files, nil
+}
+
+// runtimeConfigHash returns a short hash of the rendered runtime configuration files.
+// runtimeConfigHash 返回渲染后的运行时配置文件的短哈希。
+func runtimeConfigHash(files []plan.Action) string {
+	sum := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(sum, "%s\x00%s\x00", file.Path, file.Content)
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:16]
+}
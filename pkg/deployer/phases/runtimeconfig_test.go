@@ -0,0 +1,156 @@
+package phases
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// testRuntimeCluster returns a cluster with a Docker Hub mirror and a private registry using mutual TLS and credentials.
+// testRuntimeCluster 返回一个带 Docker Hub 镜像以及使用双向 TLS 和凭据的私有仓库的集群。
+func testRuntimeCluster(t *testing.T, runtime string) *model.ClusterConfig {
+	dir := t.TempDir()
+	for _, name := range []string{"ca.crt", "client.crt", "client.key"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), 0600))
+	}
+	return &model.ClusterConfig{
+		ContainerRuntime: runtime,
+		RuntimeConfig: model.ContainerRuntimeConfig{
+			SandboxImage: "registry.example.com:5000/pause:3.9",
+			DataRoot:     "/data/containers",
+			Registries: []model.RegistryConfig{
+				{Host: "docker.io", Mirrors: []string{"https://mirror.example.com", "http://10.0.0.9:5000"}},
+				{
+					Host:     "registry.example.com:5000",
+					Username: "robot",
+					Password: `s3cr"et`,
+					CAFile:   filepath.Join(dir, "ca.crt"),
+					CertFile: filepath.Join(dir, "client.crt"),
+					KeyFile:  filepath.Join(dir, "client.key"),
+				},
+			},
+		},
+	}
+}
+
+func TestRuntimeConfigFiles_Containerd(t *testing.T) {
+	files, err := runtimeConfigFiles(testRuntimeCluster(t, "containerd"))
+	require.NoError(t, err)
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.Path
+	}
+	assert.Equal(t, []string{
+		"/etc/containerd/config.toml",
+		"/etc/containerd/certs.d/docker.io/hosts.toml",
+		"/etc/containerd/certs.d/registry.example.com:5000/hosts.toml",
+		"/etc/containerd/certs.d/registry.example.com:5000/ca.crt",
+		"/etc/containerd/certs.d/registry.example.com:5000/client.cert",
+		"/etc/containerd/certs.d/registry.example.com:5000/client.key",
+	}, paths)
+	assert.Equal(t, "0600", files[0].Mode, "the configuration holds registry credentials")
+	assert.Equal(t, "0600", files[5].Mode)
+	assert.Equal(t, "client.crt\n", files[4].Content)
+	assertGolden(t, "containerd-config.toml", files[0].Content)
+	assertGolden(t, "containerd-hosts-docker.io.toml", files[1].Content)
+	assertGolden(t, "containerd-hosts-registry.toml", files[2].Content)
+
+	clusterCfg := testRuntimeCluster(t, "containerd")
+	clusterCfg.RuntimeConfig = model.ContainerRuntimeConfig{}
+	clusterCfg.CgroupDriver = "cgroupfs"
+	files, err = runtimeConfigFiles(clusterCfg)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "0644", files[0].Mode)
+	assert.Contains(t, files[0].Content, "  SystemdCgroup = false\n")
+	assert.Contains(t, files[0].Content, "  sandbox_image = \"registry.k8s.io/pause:3.9\"\n")
+	assert.NotContains(t, files[0].Content, "root =")
+}
+
+func TestRuntimeConfigFiles_CRIO(t *testing.T) {
+	files, err := runtimeConfigFiles(testRuntimeCluster(t, "cri-o"))
+	require.NoError(t, err)
+	require.Len(t, files, 6)
+	assert.Equal(t, crioConfigPath, files[0].Path)
+	assertGolden(t, "crio.conf", files[0].Content)
+	assert.Equal(t, crioAuthPath, files[1].Path)
+	assert.Equal(t, "0600", files[1].Mode)
+	assert.JSONEq(t, `{"auths":{"registry.example.com:5000":{"auth":"cm9ib3Q6czNjciJldA=="}}}`, files[1].Content)
+	assert.Equal(t, containersRegistryPath, files[2].Path)
+	assertGolden(t, "containers-registries.conf", files[2].Content)
+	assert.Equal(t, "/etc/containers/certs.d/registry.example.com:5000/client.cert", files[4].Path)
+
+	files, err = runtimeConfigFiles(&model.ClusterConfig{ContainerRuntime: "docker"})
+	require.NoError(t, err)
+	assert.Empty(t, files, "docker keeps its own configuration")
+
+	clusterCfg := testRuntimeCluster(t, "crio")
+	clusterCfg.RuntimeConfig.Registries[1].CAFile = filepath.Join(t.TempDir(), "missing.crt")
+	_, err = runtimeConfigFiles(clusterCfg)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeIO))
+}
+
+func TestRuntimeConfigPhase_PlanHidesCredentials(t *testing.T) {
+	for _, runtime := range []string{"containerd", "cri-o"} {
+		nodeCfg := model.NodeConfig{Address: "10.0.0.1"}
+		actions, err := NewRuntimeConfigPhase(nil).Plan(context.Background(), &nodeCfg, testRuntimeCluster(t, runtime))
+		require.NoError(t, err)
+		p := plan.New("demo", "sha256:abc", []string{nodeCfg.Address})
+		p.Add(nodeCfg.Address, "runtime", actions)
+		require.NoError(t, p.DiffFiles(context.Background(), nodeCfg.Address, func(context.Context, string) (string, os.FileMode, bool, error) {
+			return "", 0, false, nil
+		}))
+
+		for _, format := range []string{"yaml", "json"} {
+			out, err := p.Render(format)
+			require.NoError(t, err)
+			assert.NotContains(t, string(out), "s3cr", runtime+" "+format)
+			assert.NotContains(t, string(out), "cm9ib3Q6czNjciJldA==", runtime+" "+format)
+			assert.NotContains(t, string(out), "+client.key", runtime+" "+format)
+		}
+	}
+}
+
+func TestRuntimeConfigPhase_RestartsOnConfigChange(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	handleFreshNode(server)
+	clusterCfg := testRuntimeCluster(t, "containerd")
+
+	require.NoError(t, NewRuntimeConfigPhase(nil).Run(context.Background(), &nodeCfg, clusterCfg))
+	commands := server.Commands()
+	restartIdx := commandIndex(commands, "systemctl restart containerd && mkdir -p /var/lib/chasi-bod/runtime && echo ")
+	require.NotEqual(t, -1, restartIdx)
+	assert.Less(t, commandIndex(commands, "cat > /etc/containerd/certs.d/registry.example.com:5000/client.key"), restartIdx)
+	assert.Less(t, restartIdx, commandIndex(commands, "crictl --runtime-endpoint unix:///var/run/containerd/containerd.sock info"))
+
+	// A changed configuration restarts the runtime again; an unchanged one does not.
+	// 配置更改后会再次重启运行时；未更改则不会。
+	actions, err := NewRuntimeConfigPhase(nil).Plan(context.Background(), &nodeCfg, clusterCfg)
+	require.NoError(t, err)
+	restart := actions[len(actions)-1]
+	clusterCfg.RuntimeConfig.SandboxImage = "registry.k8s.io/pause:3.10"
+	changed, err := NewRuntimeConfigPhase(nil).Plan(context.Background(), &nodeCfg, clusterCfg)
+	require.NoError(t, err)
+	assert.NotEqual(t, restart.Check, changed[len(changed)-1].Check)
+
+	server.HandleOutput(restart.Check, "", 0)
+	for _, action := range actions {
+		if action.Kind == plan.ActionFile {
+			mode, err := action.FileMode()
+			require.NoError(t, err)
+			server.HandleFile(action.Path, action.Content, mode)
+		}
+	}
+	clusterCfg.RuntimeConfig.SandboxImage = "registry.example.com:5000/pause:3.9"
+	before := len(server.Commands())
+	require.NoError(t, NewRuntimeConfigPhase(nil).Run(context.Background(), &nodeCfg, clusterCfg))
+	assert.Equal(t, -1, commandIndex(server.Commands()[before:], "systemctl restart"))
+}
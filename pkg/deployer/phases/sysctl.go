@@ -0,0 +1,275 @@
+package phases
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// sysctlSnapshotPath records the values the managed settings had before chasi-bod first changed them.
+// sysctlSnapshotPath 记录受管设置在 chasi-bod 首次更改之前的值。
+var sysctlSnapshotPath = path.Join(constants.DefaultDataDir, "sysctl", "previous.conf")
+
+// EffectiveSysctl merges the sysctl layers that apply to a node. Node settings override platform settings,
+// which override the base OS settings.
+// EffectiveSysctl 合并适用于节点的 sysctl 层。节点设置覆盖平台设置，平台设置覆盖基础操作系统设置。
+// config: The platform configuration. / 平台配置。
+// nodeCfg: The node. / 节点。
+func EffectiveSysctl(config *model.PlatformConfig, nodeCfg *model.NodeConfig) types.SysctlConfig {
+	merged := types.SysctlConfig{}
+	for _, layer := range []types.SysctlConfig{config.Cluster.BaseOS.SysctlConfig, config.SysctlConfig, nodeCfg.SysctlConfig} {
+		for key, value := range layer {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// sysctlActions snapshots, persists and applies sysctl settings on a node.
+// sysctlActions 在节点上快照、持久化并应用 sysctl 设置。
+// The snapshot only gains keys it does not hold yet, so it keeps the values from before chasi-bod.
+// 快照只添加尚未包含的键，因此它保留 chasi-bod 之前的值。
+// Persisting before applying keeps runtime and boot-time values in agreement.
+// 先持久化再应用，使运行时值与启动时值保持一致。
+func sysctlActions(settings map[string]string) []plan.Action {
+	if len(settings) == 0 {
+		return nil
+	}
+	keys := sortedSysctlKeys(settings)
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = executor.ShellQuote(key)
+	}
+	snapshot := executor.ShellQuote(sysctlSnapshotPath)
+	// Keys are compared as whole words, since their dots would match any character in a regular expression.
+	// 键按完整字段比较，因为其中的点在正则表达式中会匹配任意字符。
+	recorded := fmt.Sprintf(`awk -v k="$key" '$1 == k {found = 1} END {exit !found}' %s`, snapshot)
+	return []plan.Action{
+		plan.SudoCommand("Snapshot the current sysctl values", fmt.Sprintf(`mkdir -p %s && touch %s && for key in %s; do %s || if value=$(sysctl -n "$key" 2>/dev/null); then echo "$key = $value" >> %s; fi; done`,
+			executor.ShellQuote(path.Dir(sysctlSnapshotPath)), snapshot, strings.Join(quoted, " "), recorded, snapshot)).
+			WithCheck(fmt.Sprintf("for key in %s; do %s || exit 1; done", strings.Join(quoted, " "), recorded)),
+		PersistSysctlAction(settings),
+		plan.SudoCommand("Apply sysctl settings", "sysctl -p "+sysctlConfigPath).WithCheck(sysctlCheck(settings)),
+	}
+}
+
+// PersistSysctlAction writes sysctl settings to the chasi-bod sysctl.d file without applying them, so an image being
+// built gets them at boot time.
+// PersistSysctlAction 将 sysctl 设置写入 chasi-bod 的 sysctl.d 文件而不应用它们，使正在构建的镜像在启动时获得这些设置。
+func PersistSysctlAction(settings map[string]string) plan.Action {
+	return plan.File("Persist sysctl settings", sysctlConfigPath, renderSysctlConfig(settings), 0644)
+}
+
+// SysctlStatus classifies one setting in a sysctl diff.
+// SysctlStatus 对 sysctl 差异中的一个设置进行分类。
+type SysctlStatus string
+
+const (
+	// SysctlInSync means the runtime and persisted values are the desired one.
+	// SysctlInSync 表示运行时值和持久化值都是期望值。
+	SysctlInSync SysctlStatus = "in-sync"
+	// SysctlDrifted means the runtime or persisted value differs from the desired one.
+	// SysctlDrifted 表示运行时值或持久化值与期望值不同。
+	SysctlDrifted SysctlStatus = "drifted"
+	// SysctlUnknown means the kernel of the node does not know the key.
+	// SysctlUnknown 表示节点的内核不认识该键。
+	SysctlUnknown SysctlStatus = "unknown-key"
+	// SysctlExtra means the key is persisted by chasi-bod but no longer declared.
+	// SysctlExtra 表示该键由 chasi-bod 持久化但不再声明。
+	SysctlExtra SysctlStatus = "not-declared"
+)
+
+// SysctlDiff compares one setting on a node with the configuration.
+// SysctlDiff 将节点上的一个设置与配置进行比较。
+type SysctlDiff struct {
+	Key       string       `json:"key"`                 // Kernel parameter / 内核参数
+	Desired   string       `json:"desired,omitempty"`   // Value from the merged configuration / 合并配置中的值
+	Runtime   string       `json:"runtime,omitempty"`   // Current kernel value / 当前内核值
+	Persisted string       `json:"persisted,omitempty"` // Value in the chasi-bod sysctl.d file / chasi-bod sysctl.d 文件中的值
+	Status    SysctlStatus `json:"status"`              // Comparison result / 比较结果
+}
+
+// DiffSysctl reads the runtime and persisted values of the desired settings from a node, without changing it.
+// DiffSysctl 从节点读取期望设置的运行时值和持久化值，而不更改节点。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// exec: Executor connected to the node. / 连接到节点的执行器。
+// settings: The desired settings, usually from EffectiveSysctl. / 期望的设置，通常来自 EffectiveSysctl。
+// Returns one entry per desired or persisted key, sorted by key.
+// 每个期望或持久化的键返回一个条目，按键排序。
+func DiffSysctl(ctx context.Context, exec executor.NodeExecutor, settings map[string]string) ([]SysctlDiff, error) {
+	persistedFile, err := executor.RunCommand(ctx, exec, fmt.Sprintf("cat %s 2>/dev/null || true", sysctlConfigPath), executor.WithSudo())
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeSystem, "failed to read "+sysctlConfigPath, err)
+	}
+	persisted := parseSysctlFile(persistedFile)
+
+	keys := sortedSysctlKeys(settings)
+	for key := range persisted {
+		if _, ok := settings[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	// A key the kernel does not know is printed as "key!".
+	// 内核不认识的键打印为 "key!"。
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = executor.ShellQuote(key)
+	}
+	out, err := executor.RunCommand(ctx, exec, fmt.Sprintf(`for key in %s; do if value=$(sysctl -n "$key" 2>/dev/null); then echo "$key="$value; else echo "$key!"; fi; done`, strings.Join(quoted, " ")), executor.WithSudo())
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeSystem, "failed to read the sysctl values", err)
+	}
+	runtime := map[string]string{}
+	unknown := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			runtime[key] = normalizeSysctlValue(value)
+		} else if key, ok := strings.CutSuffix(line, "!"); ok {
+			unknown[key] = true
+		}
+	}
+
+	diffs := make([]SysctlDiff, 0, len(keys))
+	for _, key := range keys {
+		desired, declared := settings[key]
+		diff := SysctlDiff{Key: key, Desired: normalizeSysctlValue(desired), Runtime: runtime[key], Persisted: persisted[key]}
+		switch {
+		case !declared:
+			diff.Status = SysctlExtra
+		case unknown[key]:
+			diff.Status = SysctlUnknown
+		case diff.Runtime == diff.Desired && diff.Persisted == diff.Desired:
+			diff.Status = SysctlInSync
+		default:
+			diff.Status = SysctlDrifted
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// HasSysctlDrift reports whether any entry of a sysctl diff is out of sync.
+// HasSysctlDrift 报告 sysctl 差异中是否有任何条目不同步。
+func HasSysctlDrift(diffs []SysctlDiff) bool {
+	for _, diff := range diffs {
+		if diff.Status != SysctlInSync {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderSysctlDiff renders a sysctl diff as "table" (the default) or "json".
+// RenderSysctlDiff 将 sysctl 差异渲染为 "table"（默认）或 "json"。
+func RenderSysctlDiff(diffs []SysctlDiff, format string) ([]byte, error) {
+	switch format {
+	case "", "table":
+		var buf bytes.Buffer
+		writeSysctlDiff(&buf, diffs)
+		return buf.Bytes(), nil
+	case "json":
+		if diffs == nil {
+			diffs = []SysctlDiff{}
+		}
+		out, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeInternal, "failed to render sysctl diff as JSON", err)
+		}
+		return append(out, '\n'), nil
+	default:
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported sysctl diff output format %q (use table or json)", format))
+	}
+}
+
+// writeSysctlDiff writes a sysctl diff as an aligned table; missing values are shown as "-".
+// writeSysctlDiff 将 sysctl 差异写为对齐的表格；缺失的值显示为 "-"。
+func writeSysctlDiff(w io.Writer, diffs []SysctlDiff) {
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tDESIRED\tRUNTIME\tPERSISTED\tSTATUS")
+	for _, diff := range diffs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", diff.Key, orDash(diff.Desired), orDash(diff.Runtime), orDash(diff.Persisted), diff.Status)
+	}
+	tw.Flush()
+}
+
+// RollbackSysctl restores the values recorded before chasi-bod changed them and removes the chasi-bod sysctl.d file.
+// RollbackSysctl 恢复 chasi-bod 更改之前记录的值，并移除 chasi-bod 的 sysctl.d 文件。
+// The snapshot is removed too, so the next deployment records the restored values afresh.
+// 快照也会被移除，因此下一次部署会重新记录恢复后的值。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// exec: Executor connected to the node. / 连接到节点的执行器。
+// address: The node address, for messages. / 节点地址，用于消息。
+func RollbackSysctl(ctx context.Context, exec executor.NodeExecutor, address string) error {
+	snapshot := executor.ShellQuote(sysctlSnapshotPath)
+	result, err := exec.Run(ctx, "test -f "+snapshot, executor.WithSudo())
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to check the sysctl snapshot on %s", address), err)
+	}
+	if result.ExitCode != 0 {
+		return errors.New(errors.ErrTypeNotFound, fmt.Sprintf("node %s has no sysctl snapshot at %s", address, sysctlSnapshotPath))
+	}
+	// -e ignores keys the kernel no longer knows, e.g. after a module was unloaded.
+	// -e 忽略内核不再认识的键，例如在模块卸载之后。
+	cmd := fmt.Sprintf("sysctl -e -p %s && rm -f %s %s", snapshot, sysctlConfigPath, snapshot)
+	if _, err := executor.RunCommand(ctx, exec, cmd, executor.WithSudo(), executor.WithLogging(address)); err != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to restore the sysctl values on %s", address), err)
+	}
+	return nil
+}
+
+// parseSysctlFile parses "key = value" lines of a sysctl.d file, skipping comments.
+// parseSysctlFile 解析 sysctl.d 文件中的 "key = value" 行，跳过注释。
+func parseSysctlFile(content string) map[string]string {
+	settings := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			settings[strings.TrimSpace(key)] = normalizeSysctlValue(value)
+		}
+	}
+	return settings
+}
+
+// normalizeSysctlValue collapses whitespace, since multi-value keys are printed tab-separated.
+// normalizeSysctlValue 合并空白，因为多值键以制表符分隔打印。
+func normalizeSysctlValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// sortedSysctlKeys returns the keys of the settings in order.
+// sortedSysctlKeys 按顺序返回设置的键。
+func sortedSysctlKeys(settings map[string]string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,105 @@
+package phases
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+)
+
+func TestEffectiveSysctl(t *testing.T) {
+	config := &model.PlatformConfig{
+		Cluster:      model.ClusterConfig{BaseOS: model.BaseOSConfig{SysctlConfig: types.SysctlConfig{"a": "base", "b": "base", "c": "base"}}},
+		SysctlConfig: types.SysctlConfig{"b": "platform", "c": "platform"},
+	}
+	nodeCfg := &model.NodeConfig{SysctlConfig: types.SysctlConfig{"c": "node"}}
+
+	assert.Equal(t, types.SysctlConfig{"a": "base", "b": "platform", "c": "node"}, EffectiveSysctl(config, nodeCfg))
+	assert.Equal(t, types.SysctlConfig{"c": "node"}, nodeCfg.SysctlConfig, "the layers are not modified")
+}
+
+func TestDiffSysctl(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	server.HandleOutput("cat /etc/sysctl.d/99-chasi-bod.conf", "# managed by chasi-bod\nnet.ipv4.ip_forward = 1\nvm.swappiness = 10\nnet.core.somaxconn = 1024\n", 0)
+	server.HandleOutput("for key in", "net.core.somaxconn=4096\nnet.ipv4.ip_forward=1\nnet.ipv4.tcp_rmem=4096\t87380\t6291456\nvm.made_up!\nvm.swappiness=10\n", 0)
+
+	exec, err := executor.NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	defer exec.Close()
+
+	diffs, err := DiffSysctl(context.Background(), exec, map[string]string{
+		"net.ipv4.ip_forward": "1",
+		"net.ipv4.tcp_rmem":   "4096 87380 6291456",
+		"vm.made_up":          "1",
+		"vm.swappiness":       "10",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []SysctlDiff{
+		{Key: "net.core.somaxconn", Runtime: "4096", Persisted: "1024", Status: SysctlExtra},
+		{Key: "net.ipv4.ip_forward", Desired: "1", Runtime: "1", Persisted: "1", Status: SysctlInSync},
+		{Key: "net.ipv4.tcp_rmem", Desired: "4096 87380 6291456", Runtime: "4096 87380 6291456", Status: SysctlDrifted},
+		{Key: "vm.made_up", Desired: "1", Status: SysctlUnknown},
+		{Key: "vm.swappiness", Desired: "10", Runtime: "10", Persisted: "10", Status: SysctlInSync},
+	}, diffs)
+	assert.True(t, HasSysctlDrift(diffs))
+	assert.False(t, HasSysctlDrift(diffs[1:2]))
+}
+
+func TestRenderSysctlDiff(t *testing.T) {
+	diffs := []SysctlDiff{{Key: "vm.swappiness", Desired: "10", Runtime: "60", Status: SysctlDrifted}}
+
+	table, err := RenderSysctlDiff(diffs, "table")
+	require.NoError(t, err)
+	assert.Contains(t, string(table), "KEY            DESIRED  RUNTIME  PERSISTED  STATUS")
+	assert.Contains(t, string(table), "vm.swappiness  10       60       -          drifted")
+
+	out, err := RenderSysctlDiff(nil, "json")
+	require.NoError(t, err)
+	assert.JSONEq(t, `[]`, string(out))
+	out, err = RenderSysctlDiff(diffs, "json")
+	require.NoError(t, err)
+	var decoded []SysctlDiff
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, diffs, decoded)
+
+	_, err = RenderSysctlDiff(diffs, "yaml")
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+}
+
+func TestRollbackSysctl(t *testing.T) {
+	server, nodeCfg := newTestNode(t)
+	exec, err := executor.NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	defer exec.Close()
+
+	server.HandleOutput("test -f /var/lib/chasi-bod/sysctl/previous.conf", "", 1)
+	err = RollbackSysctl(context.Background(), exec, nodeCfg.Address)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeNotFound), "nothing to roll back to")
+
+	server.HandleOutput("test -f /var/lib/chasi-bod/sysctl/previous.conf", "", 0)
+	require.NoError(t, RollbackSysctl(context.Background(), exec, nodeCfg.Address))
+	assert.NotEqual(t, -1, commandIndex(server.Commands(), "sysctl -e -p /var/lib/chasi-bod/sysctl/previous.conf && rm -f /etc/sysctl.d/99-chasi-bod.conf"))
+}
+
+func TestSysctlActions_SnapshotMatchesKeysLiterally(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "previous.conf")
+	defer func(orig string) { sysctlSnapshotPath = orig }(sysctlSnapshotPath)
+	sysctlSnapshotPath = snapshot
+	check := sysctlActions(map[string]string{"net.ipv4.ip_forward": "1"})[0].Check
+
+	require.NoError(t, os.WriteFile(snapshot, []byte("net.ipv4Xip_forward = 0\nnet.ipv4.ip_forward.x = 0\n"), 0644))
+	assert.Error(t, exec.Command("/bin/sh", "-c", check).Run(), "the dots of a key are not wildcards")
+
+	require.NoError(t, os.WriteFile(snapshot, []byte("net.ipv4.ip_forward = 0\n"), 0644))
+	assert.NoError(t, exec.Command("/bin/sh", "-c", check).Run())
+}
@@ -0,0 +1,196 @@
+package phases
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// sudoersDir holds the sudoers drop-ins of the users granted sudo access.
+// sudoersDir 存放被授予 sudo 权限的用户的 sudoers 附加配置。
+const sudoersDir = "/etc/sudoers.d"
+
+// UserActions returns the actions creating and updating the groups, users, sudoers drop-ins and authorized_keys
+// declared by the base OS configuration. They only use shadow-utils and POSIX shell commands, so they apply both
+// to live nodes and, through a chroot executor, to the root filesystem of an image being built.
+// UserActions 返回创建和更新基础操作系统配置声明的组、用户、sudoers 附加配置和 authorized_keys 的操作。
+// 它们仅使用 shadow-utils 和 POSIX shell 命令，因此既可应用于在线节点，也可通过 chroot 执行器应用于正在构建的镜像根文件系统。
+// baseOS: The base OS configuration declaring the users and keys. / 声明用户和密钥的基础操作系统配置。
+func UserActions(baseOS *model.BaseOSConfig) []plan.Action {
+	var actions []plan.Action
+	for _, group := range supplementaryGroups(baseOS.Users) {
+		actions = append(actions, plan.SudoCommand("Create group "+group, "groupadd "+executor.ShellQuote(group)).
+			WithCheck("getent group "+executor.ShellQuote(group)+" >/dev/null"))
+	}
+	for i := range baseOS.Users {
+		userCfg := &baseOS.Users[i]
+		actions = append(actions, userAccountActions(userCfg)...)
+		if len(userCfg.SSHAuthorizedKeys) > 0 || baseOS.SSHAuthorizedKeysExclusive {
+			actions = append(actions, authorizedKeysAction(userCfg.Name, userCfg.SSHAuthorizedKeys, baseOS.SSHAuthorizedKeysExclusive))
+		}
+	}
+	if len(baseOS.SSHAuthorizedKeys) > 0 || baseOS.SSHAuthorizedKeysExclusive {
+		actions = append(actions, authorizedKeysAction("root", baseOS.SSHAuthorizedKeys, baseOS.SSHAuthorizedKeysExclusive))
+	}
+	return actions
+}
+
+// supplementaryGroups returns the sorted supplementary groups of the users.
+// supplementaryGroups 返回用户的附加组（已排序）。
+func supplementaryGroups(users []model.UserConfig) []string {
+	seen := map[string]bool{}
+	var groups []string
+	for _, userCfg := range users {
+		for _, group := range userCfg.Groups {
+			if !seen[group] {
+				seen[group] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// userAccountActions returns the actions creating a user, bringing an existing one in line, setting its password and
+// granting or revoking its sudo access.
+// userAccountActions 返回创建用户、使现有用户与配置一致、设置其密码以及授予或撤销其 sudo 权限的操作。
+func userAccountActions(userCfg *model.UserConfig) []plan.Action {
+	name := executor.ShellQuote(userCfg.Name)
+	home := userHome(userCfg)
+	var actions []plan.Action
+
+	// A fixed GID names the primary group, which must exist before the user; without one useradd creates a group named after the user.
+	// 固定的 GID 指定主组，主组必须在用户之前存在；没有 GID 时 useradd 会创建与用户同名的组。
+	var accountFlags []string
+	if userCfg.GID != nil {
+		gid := strconv.Itoa(*userCfg.GID)
+		actions = append(actions, plan.SudoCommand(fmt.Sprintf("Create the primary group of user %s", userCfg.Name), fmt.Sprintf("groupadd -g %s %s", gid, name)).
+			WithCheck("getent group "+gid+" >/dev/null"))
+		accountFlags = append(accountFlags, "-g", gid)
+	}
+	if userCfg.UID != nil {
+		accountFlags = append(accountFlags, "-u", strconv.Itoa(*userCfg.UID))
+	}
+	if userCfg.Shell != "" {
+		accountFlags = append(accountFlags, "-s", executor.ShellQuote(userCfg.Shell))
+	}
+	if len(userCfg.Groups) > 0 {
+		accountFlags = append(accountFlags, "-G", executor.ShellQuote(strings.Join(userCfg.Groups, ",")))
+	}
+	createFlags := append([]string{"-m", "-d", executor.ShellQuote(home)}, accountFlags...)
+	actions = append(actions, plan.SudoCommand("Create user "+userCfg.Name, fmt.Sprintf("useradd %s %s", strings.Join(createFlags, " "), name)).
+		WithCheck(fmt.Sprintf("getent passwd %s >/dev/null", name)))
+
+	// Only declared attributes are compared, so an existing account keeps whatever the configuration leaves open.
+	// 仅比较已声明的属性，因此现有账户会保留配置未指定的内容。
+	var checks []string
+	updateFlags := accountFlags
+	if userCfg.HomeDir != "" {
+		checks = append(checks, fmt.Sprintf(`test "$(getent passwd %s | cut -d: -f6)" = %s`, name, executor.ShellQuote(home)))
+		updateFlags = append([]string{"-d", executor.ShellQuote(home)}, accountFlags...)
+	}
+	if userCfg.UID != nil {
+		checks = append(checks, fmt.Sprintf(`test "$(id -u %s)" = %d`, name, *userCfg.UID))
+	}
+	if userCfg.GID != nil {
+		checks = append(checks, fmt.Sprintf(`test "$(id -g %s)" = %d`, name, *userCfg.GID))
+	}
+	if userCfg.Shell != "" {
+		checks = append(checks, fmt.Sprintf(`test "$(getent passwd %s | cut -d: -f7)" = %s`, name, executor.ShellQuote(userCfg.Shell)))
+	}
+	if len(userCfg.Groups) > 0 {
+		groups := append([]string(nil), userCfg.Groups...)
+		sort.Strings(groups)
+		checks = append(checks, fmt.Sprintf(`test "$(getent group | awk -F: -v u=%s '{n=split($4,m,","); for(i=1;i<=n;i++) if(m[i]==u) print $1}' | sort | xargs)" = %s`,
+			name, executor.ShellQuote(strings.Join(groups, " "))))
+	}
+	if len(checks) > 0 {
+		actions = append(actions, plan.SudoCommand("Update user "+userCfg.Name, fmt.Sprintf("usermod %s %s", strings.Join(updateFlags, " "), name)).
+			WithCheck(strings.Join(checks, " && ")))
+	}
+
+	if userCfg.Password != "" {
+		hash := executor.ShellQuote(userCfg.Password)
+		actions = append(actions, plan.SudoCommand("Set the password of user "+userCfg.Name, fmt.Sprintf("usermod -p %s %s", hash, name)).
+			WithCheck(fmt.Sprintf(`test "$(getent shadow %s | cut -d: -f2)" = %s`, name, hash)))
+	}
+
+	sudoersPath := sudoersDropIn(userCfg.Name)
+	if userCfg.Sudo {
+		actions = append(actions, plan.File("Grant sudo access to user "+userCfg.Name, sudoersPath, renderSudoers(userCfg), 0440))
+	} else {
+		actions = append(actions, plan.SudoCommand("Revoke the sudo access of user "+userCfg.Name, "rm -f "+executor.ShellQuote(sudoersPath)).
+			WithCheck("! test -e "+executor.ShellQuote(sudoersPath)))
+	}
+	return actions
+}
+
+// userHome returns the home directory of a user, /home/<name> unless configured.
+// userHome 返回用户的家目录，未配置时为 /home/<name>。
+func userHome(userCfg *model.UserConfig) string {
+	if userCfg.HomeDir != "" {
+		return userCfg.HomeDir
+	}
+	return "/home/" + userCfg.Name
+}
+
+// sudoersDropIn returns the sudoers drop-in of a user. sudo skips files containing a dot, so dots are replaced.
+// sudoersDropIn 返回用户的 sudoers 附加配置。sudo 会跳过包含点号的文件，因此点号会被替换。
+func sudoersDropIn(name string) string {
+	return sudoersDir + "/chasi-bod-" + strings.ReplaceAll(name, ".", "_")
+}
+
+// renderSudoers renders the sudoers drop-in of a user. Users without a password cannot authenticate to sudo,
+// so they are granted passwordless sudo.
+// renderSudoers 渲染用户的 sudoers 附加配置。没有密码的用户无法通过 sudo 认证，因此授予其免密 sudo。
+func renderSudoers(userCfg *model.UserConfig) string {
+	spec := "ALL=(ALL:ALL) ALL"
+	if userCfg.Password == "" {
+		spec = "ALL=(ALL:ALL) NOPASSWD: ALL"
+	}
+	return fmt.Sprintf("%s%s %s\n", managedFileHeader, userCfg.Name, spec)
+}
+
+// authorizedKeysAction returns the action installing the SSH keys of an account. By default missing keys are appended
+// and other keys are kept; in exclusive mode the file holds exactly the declared keys.
+// The home directory is looked up on the target because existing accounts may not use /home/<name>.
+// authorizedKeysAction 返回安装账户 SSH 密钥的操作。默认追加缺失的密钥并保留其他密钥；独占模式下文件仅包含声明的密钥。
+// 家目录在目标上查找，因为现有账户可能不使用 /home/<name>。
+func authorizedKeysAction(account string, keys []string, exclusive bool) plan.Action {
+	name := executor.ShellQuote(account)
+	prefix := fmt.Sprintf(`home="$(getent passwd %s | cut -d: -f6)" && test -n "$home" && f="$home/.ssh/authorized_keys"`, name)
+	setup := fmt.Sprintf(`install -d -m 0700 -o %s -g "$(id -g %s)" "$home/.ssh"`, name, name)
+	finish := fmt.Sprintf(`chown %s:"$(id -g %s)" "$f" && chmod 0600 "$f"`, name, name)
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = executor.ShellQuote(strings.TrimSpace(key))
+	}
+
+	if exclusive {
+		write := `: > "$f.tmp"`
+		check := `! test -s "$f"`
+		if len(keys) > 0 {
+			write = fmt.Sprintf(`printf '%%s\n' %s > "$f.tmp"`, strings.Join(quoted, " "))
+			check = fmt.Sprintf(`test "$(cat "$f" 2>/dev/null)" = "$(printf '%%s\n' %s)"`, strings.Join(quoted, " "))
+		}
+		command := strings.Join([]string{prefix, setup, write, `mv -f "$f.tmp" "$f"`, finish}, " && ")
+		return plan.SudoCommand(fmt.Sprintf("Set the SSH authorized keys of %s to the %d declared keys", account, len(keys)), command).
+			WithCheck(prefix + " && " + check)
+	}
+
+	appends := make([]string, len(quoted))
+	checks := make([]string, len(quoted))
+	for i, key := range quoted {
+		appends[i] = fmt.Sprintf(`{ grep -qxF %s "$f" || echo %s >> "$f"; }`, key, key)
+		checks[i] = fmt.Sprintf(`grep -qxF %s "$f"`, key)
+	}
+	command := strings.Join(append(append([]string{prefix, setup, `touch "$f"`}, appends...), finish), " && ")
+	return plan.SudoCommand(fmt.Sprintf("Add %d SSH authorized keys for %s", len(keys), account), command).
+		WithCheck(prefix + " && " + strings.Join(checks, " && "))
+}
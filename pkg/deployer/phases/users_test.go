@@ -0,0 +1,139 @@
+package phases
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+func TestUserActions(t *testing.T) {
+	uid, gid := 1500, 1500
+	baseOS := &model.BaseOSConfig{
+		SSHAuthorizedKeys: []string{"ssh-ed25519 AAAAroot admin@example"},
+		Users: []model.UserConfig{
+			{
+				Name:              "ops",
+				Password:          "$6$salt$hash",
+				UID:               &uid,
+				GID:               &gid,
+				Groups:            []string{"wheel", "docker"},
+				Shell:             "/bin/bash",
+				Sudo:              true,
+				SSHAuthorizedKeys: []string{"ssh-ed25519 AAAAops ops@example"},
+			},
+			{Name: "svc.backup", Groups: []string{"docker"}},
+		},
+	}
+	actions := UserActions(baseOS)
+	descriptions := make([]string, len(actions))
+	for i, action := range actions {
+		descriptions[i] = action.Description
+	}
+	assert.Equal(t, []string{
+		"Create group docker",
+		"Create group wheel",
+		"Create the primary group of user ops",
+		"Create user ops",
+		"Update user ops",
+		"Set the password of user ops",
+		"Grant sudo access to user ops",
+		"Add 1 SSH authorized keys for ops",
+		"Create user svc.backup",
+		"Update user svc.backup",
+		"Revoke the sudo access of user svc.backup",
+		"Add 1 SSH authorized keys for root",
+	}, descriptions)
+
+	assert.Equal(t, "groupadd -g 1500 ops", actions[2].Command)
+	assert.Equal(t, "getent group 1500 >/dev/null", actions[2].Check)
+	assert.Equal(t, "useradd -m -d /home/ops -g 1500 -u 1500 -s /bin/bash -G wheel,docker ops", actions[3].Command)
+	assert.Equal(t, "usermod -g 1500 -u 1500 -s /bin/bash -G wheel,docker ops", actions[4].Command)
+	assert.Contains(t, actions[4].Check, `test "$(id -u ops)" = 1500`)
+	assert.Contains(t, actions[4].Check, `| sort | xargs)" = 'docker wheel'`)
+	assert.Equal(t, "usermod -p '$6$salt$hash' ops", actions[5].Command)
+	assert.Equal(t, plan.ActionFile, actions[6].Kind)
+	assert.Equal(t, "/etc/sudoers.d/chasi-bod-ops", actions[6].Path)
+	assert.Equal(t, "0440", actions[6].Mode)
+	assert.Equal(t, managedFileHeader+"ops ALL=(ALL:ALL) ALL\n", actions[6].Content, "users with a password authenticate to sudo")
+	assert.Equal(t, "rm -f /etc/sudoers.d/chasi-bod-svc_backup", actions[10].Command)
+	for _, action := range actions {
+		assert.True(t, action.Kind == plan.ActionFile || action.Sudo, action.Description)
+	}
+
+	// Without declared attributes an existing account is left alone.
+	// 没有声明属性时，现有账户保持不变。
+	actions = UserActions(&model.BaseOSConfig{Users: []model.UserConfig{{Name: "ubuntu", Sudo: true}}})
+	require.Len(t, actions, 2)
+	assert.Equal(t, "Create user ubuntu", actions[0].Description)
+	assert.Equal(t, managedFileHeader+"ubuntu ALL=(ALL:ALL) NOPASSWD: ALL\n", actions[1].Content)
+}
+
+// runKeysAction runs an authorized_keys action in a shell where getent, id, install and chown are stubbed,
+// so the account's home directory is dir.
+// runKeysAction 在 getent、id、install 和 chown 被替换的 shell 中运行 authorized_keys 操作，使账户家目录为 dir。
+func runKeysAction(t *testing.T, dir string, action plan.Action) (checked bool) {
+	t.Helper()
+	stubs := `getent() { echo "ops:x:1500:1500::` + dir + `:/bin/sh"; }
+id() { echo 1500; }
+install() { mkdir -p "$8"; }
+chown() { :; }
+`
+	if exec.Command("/bin/sh", "-c", stubs+action.Check).Run() == nil {
+		return true
+	}
+	out, err := exec.Command("/bin/sh", "-c", stubs+action.Command).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return false
+}
+
+// readKeys returns the content of an authorized_keys file.
+// readKeys 返回 authorized_keys 文件的内容。
+func readKeys(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestAuthorizedKeysAction(t *testing.T) {
+	if _, err := exec.LookPath("/bin/sh"); err != nil {
+		t.Skip("no POSIX shell")
+	}
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, ".ssh", "authorized_keys")
+	existing := "ssh-rsa AAAAold old@example"
+	declared := []string{"ssh-ed25519 AAAAone one@example", "ssh-ed25519 AAAAtwo two@example"}
+
+	// Appending keeps the keys that are already there and is idempotent.
+	// 追加模式保留已有的密钥，并且是幂等的。
+	require.False(t, runKeysAction(t, dir, authorizedKeysAction("ops", []string{existing}, false)))
+	require.False(t, runKeysAction(t, dir, authorizedKeysAction("ops", declared, false)))
+	assert.True(t, runKeysAction(t, dir, authorizedKeysAction("ops", declared, false)))
+	assert.Equal(t, strings.Join(append([]string{existing}, declared...), "\n")+"\n", readKeys(t, keysPath))
+
+	// Exclusive mode removes the keys that are no longer declared.
+	// 独占模式删除不再声明的密钥。
+	require.False(t, runKeysAction(t, dir, authorizedKeysAction("ops", declared[1:], true)))
+	assert.True(t, runKeysAction(t, dir, authorizedKeysAction("ops", declared[1:], true)))
+	assert.Equal(t, declared[1]+"\n", readKeys(t, keysPath))
+	require.False(t, runKeysAction(t, dir, authorizedKeysAction("ops", nil, true)))
+	assert.Empty(t, readKeys(t, keysPath))
+	assert.True(t, runKeysAction(t, dir, authorizedKeysAction("ops", nil, true)))
+}
+
+func TestOSConfigPhase_PlanIncludesUsers(t *testing.T) {
+	clusterCfg := &model.ClusterConfig{BaseOS: model.BaseOSConfig{Users: []model.UserConfig{{Name: "ops"}}}}
+	actions, err := NewOSConfigPhase(nil).Plan(context.Background(), &model.NodeConfig{Address: "10.0.0.1"}, clusterCfg)
+	require.NoError(t, err)
+	require.NotEmpty(t, actions)
+	assert.Equal(t, "Revoke the sudo access of user ops", actions[len(actions)-1].Description)
+}
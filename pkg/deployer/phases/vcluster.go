@@ -5,6 +5,7 @@ package phases
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	//"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/turtacn/chasi-bod/common/errors"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
 	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
 	//vcluster_client "github.com/turtacn/chasi-bod/pkg/vcluster/client" // Alias to avoid naming conflict // 别名以避免命名冲突
 	vcluster_mgr "github.com/turtacn/chasi-bod/pkg/vcluster" // Alias for vcluster manager // vcluster manager 的别名
 	// Assuming you have vcluster management logic and K8s client
@@ -32,8 +34,20 @@ type VClusterDeployPhase interface {
 	// Returns an error if the phase fails.
 	// 如果阶段失败则返回错误。
 	Run(ctx context.Context, config *model.PlatformConfig, hostK8sClient interface{} /* kubernetes.Interface */) error
+
+	// Plan returns the Helm releases Run would install or upgrade, ordered by vcluster name.
+	// Plan 返回 Run 将安装或升级的 Helm 发布，按 vcluster 名称排序。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// config: The overall platform configuration, including vcluster definitions. / 整体平台配置，包括 vcluster 定义。
+	// Returns the planned actions.
+	// 返回计划的操作。
+	Plan(ctx context.Context, config *model.PlatformConfig) ([]plan.Action, error)
 }
 
+// vclusterChartPath is the location of the vcluster Helm chart.
+// vclusterChartPath 是 vcluster Helm chart 的位置。
+const vclusterChartPath = "pkg/vcluster/chart/vcluster"
+
 // NewVClusterDeployPhase creates a new VClusterDeployPhase instance.
 // NewVClusterDeployPhase 创建一个新的 VClusterDeployPhase 实例。
 // Returns a VClusterDeployPhase implementation.
@@ -62,7 +76,7 @@ func (p *defaultVClusterDeployPhase) Run(ctx context.Context, config *model.Plat
 
 	// Initialize vclusterManager with the hostK8sClient
 	// 使用 hostK8sClient 初始化 vclusterManager
-	vclusterManager := vcluster_mgr.NewManager(k8sClient, "pkg/vcluster/chart/vcluster") // Assuming NewManager takes a K8s client
+	vclusterManager := vcluster_mgr.NewManager(k8sClient, vclusterChartPath) // Assuming NewManager takes a K8s client
 	// p.vclusterManager = vclusterManager // If storing in struct
 
 	if len(config.VClusters) == 0 {
@@ -122,6 +136,28 @@ func (p *defaultVClusterDeployPhase) Run(ctx context.Context, config *model.Plat
 	return nil
 }
 
+// Plan returns a Helm release action per vcluster.
+// Plan 为每个 vcluster 返回一个 Helm 发布操作。
+func (p *defaultVClusterDeployPhase) Plan(ctx context.Context, config *model.PlatformConfig) ([]plan.Action, error) {
+	names := make([]string, 0, len(config.VClusters))
+	for name := range config.VClusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	actions := make([]plan.Action, 0, len(names))
+	for _, name := range names {
+		vclusterCfg := config.VClusters[name]
+		actions = append(actions, plan.Helm(fmt.Sprintf("Install or upgrade vcluster %s", name), plan.HelmRelease{
+			Name:      vclusterCfg.Name,
+			Namespace: vclusterCfg.Namespace,
+			Chart:     vclusterChartPath,
+			Values:    vcluster_mgr.HelmValues(&vclusterCfg),
+		}))
+	}
+	return actions, nil
+}
+
 // TODO: Implement a function to apply base configurations inside a virtual cluster
 // TODO: 实现一个在虚拟集群内部应用基础配置的函数
 // This would likely involve using the vclusterClient to create/update resources like ConfigMaps, Deployments, etc.
@@ -0,0 +1,340 @@
+// Package deployer orchestrates the deployment of the chasi-bod platform onto target nodes.
+// 包 deployer 协调将 chasi-bod 平台部署到目标节点。
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/plan"
+)
+
+// NodeRunFunc runs a node-scoped phase on a single node.
+// NodeRunFunc 在单个节点上运行节点范围的阶段。
+type NodeRunFunc func(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) error
+
+// ClusterRunFunc runs a cluster-scoped phase once for the whole cluster.
+// ClusterRunFunc 为整个集群运行一次集群范围的阶段。
+type ClusterRunFunc func(ctx context.Context, config *model.PlatformConfig) error
+
+// NodePlanFunc returns the actions a node-scoped phase would perform on a single node, without performing them.
+// NodePlanFunc 返回节点范围的阶段将在单个节点上执行的操作，但不执行它们。
+type NodePlanFunc func(ctx context.Context, config *model.PlatformConfig, nodeCfg *model.NodeConfig) ([]plan.Action, error)
+
+// ClusterPlanFunc returns the actions a cluster-scoped phase would perform, grouped by target, without performing them.
+// ClusterPlanFunc 返回集群范围的阶段将执行的操作（按目标分组），但不执行它们。
+type ClusterPlanFunc func(ctx context.Context, config *model.PlatformConfig) ([]plan.TargetActions, error)
+
+// PhaseDefinition describes a phase of the deployment pipeline.
+// PhaseDefinition 描述部署流水线中的一个阶段。
+type PhaseDefinition struct {
+	// Name uniquely identifies the phase; it is used in dependencies, checkpoints and --from-phase/--only-phase.
+	// Name 唯一标识阶段；它用于依赖关系、检查点以及 --from-phase/--only-phase。
+	Name string
+	// Description is the human-readable name shown in logs (defaults to Name).
+	// Description 是日志中显示的可读名称（默认为 Name）。
+	Description string
+	// Scope decides whether the phase runs per node or once for the cluster.
+	// Scope 决定阶段是按节点运行还是为集群运行一次。
+	Scope enum.PhaseScope
+	// DependsOn lists the phases that must complete before this one starts.
+	// DependsOn 列出在此阶段开始之前必须完成的阶段。
+	DependsOn []string
+	// Roles restricts a node-scoped phase to nodes having one of these roles; empty means all nodes.
+	// Roles 将节点范围的阶段限制为具有这些角色之一的节点；为空表示所有节点。
+	Roles []enum.NodeRole
+	// Timeout bounds a single run of the phase (per node for node-scoped phases).
+	// Timeout 限制阶段单次运行的时间（对于节点范围的阶段为每个节点）。
+	Timeout time.Duration
+	// RunNode implements a node-scoped phase.
+	// RunNode 实现节点范围的阶段。
+	RunNode NodeRunFunc
+	// RunCluster implements a cluster-scoped phase.
+	// RunCluster 实现集群范围的阶段。
+	RunCluster ClusterRunFunc
+	// PlanNode optionally renders the actions of a node-scoped phase for dry runs.
+	// PlanNode 可选地为试运行渲染节点范围阶段的操作。
+	PlanNode NodePlanFunc
+	// PlanCluster optionally renders the actions of a cluster-scoped phase for dry runs.
+	// PlanCluster 可选地为试运行渲染集群范围阶段的操作。
+	// Phases without a planner show up as a single opaque action.
+	// 没有计划函数的阶段显示为单个不透明操作。
+	PlanCluster ClusterPlanFunc
+}
+
+// displayName returns the name used in logs.
+// displayName 返回日志中使用的名称。
+func (p *PhaseDefinition) displayName() string {
+	if p.Description != "" {
+		return p.Description
+	}
+	return p.Name
+}
+
+// appliesTo reports whether a node-scoped phase should run on the node.
+// appliesTo 报告节点范围的阶段是否应在该节点上运行。
+func (p *PhaseDefinition) appliesTo(nodeCfg *model.NodeConfig) bool {
+	if len(p.Roles) == 0 {
+		return true
+	}
+	for _, want := range p.Roles {
+		for _, role := range nodeCfg.Roles {
+			if role == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validate checks that the definition is complete.
+// validate 检查定义是否完整。
+func (p *PhaseDefinition) validate() error {
+	if p.Name == "" {
+		return errors.New(errors.ErrTypeConfig, "phase name is required")
+	}
+	switch p.Scope {
+	case enum.PhaseScopeNode:
+		if p.RunNode == nil {
+			return errors.New(errors.ErrTypeConfig, fmt.Sprintf("node-scoped phase %q has no RunNode function", p.Name))
+		}
+	case enum.PhaseScopeCluster:
+		if p.RunCluster == nil {
+			return errors.New(errors.ErrTypeConfig, fmt.Sprintf("cluster-scoped phase %q has no RunCluster function", p.Name))
+		}
+	default:
+		return errors.New(errors.ErrTypeConfig, fmt.Sprintf("phase %q has invalid scope %q", p.Name, p.Scope))
+	}
+	if p.Timeout <= 0 {
+		return errors.New(errors.ErrTypeConfig, fmt.Sprintf("phase %q needs a positive timeout", p.Name))
+	}
+	return nil
+}
+
+// Registry holds the phases of a deployment pipeline.
+// Registry 持有部署流水线的各个阶段。
+type Registry struct {
+	phases map[string]*PhaseDefinition
+	order  []string // Registration order, used to break ties deterministically / 注册顺序，用于确定性地打破平局
+}
+
+// NewRegistry creates an empty phase registry.
+// NewRegistry 创建一个空的阶段注册表。
+func NewRegistry() *Registry {
+	return &Registry{phases: map[string]*PhaseDefinition{}}
+}
+
+// Register adds a phase to the registry.
+// Register 将阶段添加到注册表。
+// def: The phase definition. / 阶段定义。
+// Returns an error if the definition is invalid or the name is already registered.
+// 如果定义无效或名称已注册则返回错误。
+func (r *Registry) Register(def PhaseDefinition) error {
+	if err := def.validate(); err != nil {
+		return err
+	}
+	if _, exists := r.phases[def.Name]; exists {
+		return errors.New(errors.ErrTypeAlreadyExists, fmt.Sprintf("phase %q is already registered", def.Name))
+	}
+	r.phases[def.Name] = &def
+	r.order = append(r.order, def.Name)
+	return nil
+}
+
+// Clone returns a copy of the registry that can be extended without affecting the original.
+// Clone 返回注册表的副本，可以在不影响原注册表的情况下扩展。
+func (r *Registry) Clone() *Registry {
+	clone := NewRegistry()
+	for _, name := range r.order {
+		clone.phases[name] = r.phases[name]
+		clone.order = append(clone.order, name)
+	}
+	return clone
+}
+
+// Resolve resolves the dependency graph into an execution order.
+// Resolve 将依赖图解析为执行顺序。
+// Phases become ready once all their dependencies are ordered. Among ready phases node-scoped ones go first,
+// so custom node phases run before the cluster-wide barrier; ties are broken by registration order,
+// so the order is stable for a given registry.
+// 当阶段的所有依赖都已排序后，该阶段即就绪。在就绪阶段中节点范围的阶段优先，
+// 因此自定义节点阶段会在集群范围的屏障之前运行；平局按注册顺序打破，因此对于给定注册表顺序是稳定的。
+// Returns an error if a dependency is unknown or the graph has a cycle.
+// 如果依赖未知或图中存在环，则返回错误。
+func (r *Registry) Resolve() ([]*PhaseDefinition, error) {
+	position := make(map[string]int, len(r.order))
+	for i, name := range r.order {
+		position[name] = i
+	}
+	remaining := make(map[string]int, len(r.order)) // Unordered dependency count / 未排序的依赖数
+	dependents := make(map[string][]string, len(r.order))
+	for _, name := range r.order {
+		def := r.phases[name]
+		for _, dep := range def.DependsOn {
+			if _, ok := r.phases[dep]; !ok {
+				return nil, errors.New(errors.ErrTypeConfig, fmt.Sprintf("phase %q depends on unknown phase %q", name, dep))
+			}
+			dependents[dep] = append(dependents[dep], name)
+		}
+		remaining[name] = len(def.DependsOn)
+	}
+
+	var ready []string
+	for _, name := range r.order {
+		if remaining[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	ordered := make([]*PhaseDefinition, 0, len(r.order))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool {
+			iCluster, jCluster := r.phases[ready[i]].Scope == enum.PhaseScopeCluster, r.phases[ready[j]].Scope == enum.PhaseScopeCluster
+			if iCluster != jCluster {
+				return jCluster
+			}
+			return position[ready[i]] < position[ready[j]]
+		})
+		name := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, r.phases[name])
+		for _, dependent := range dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(ordered) != len(r.order) {
+		var cyclic []string
+		for _, name := range r.order {
+			if remaining[name] > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		return nil, errors.New(errors.ErrTypeConfig, fmt.Sprintf("phase dependencies contain a cycle involving: %s", strings.Join(cyclic, ", ")))
+	}
+	return ordered, nil
+}
+
+// ScriptPhase turns a config-declared script phase into a phase definition.
+// ScriptPhase 将配置中声明的脚本阶段转换为阶段定义。
+// Node-scoped scripts run on every matching node; cluster-scoped scripts run once on the first master.
+// 节点范围的脚本在每个匹配的节点上运行；集群范围的脚本在第一个主节点上运行一次。
+// cfg: The script phase configuration. / 脚本阶段配置。
+// newExecutor: Factory used to connect to nodes. / 用于连接节点的工厂。
+// Returns the phase definition or an error if the configuration is invalid.
+// 返回阶段定义，如果配置无效则返回错误。
+func ScriptPhase(cfg model.PhaseConfig, newExecutor executor.Factory) (PhaseDefinition, error) {
+	timeout := constants.DefaultTimeout
+	if cfg.Timeout != "" {
+		parsed, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return PhaseDefinition{}, errors.NewWithCause(errors.ErrTypeConfig, fmt.Sprintf("phase %q has invalid timeout %q", cfg.Name, cfg.Timeout), err)
+		}
+		timeout = parsed
+	}
+	scope := cfg.Scope
+	if scope == "" {
+		scope = enum.PhaseScopeNode
+	}
+
+	action := plan.Command(fmt.Sprintf("Run script phase %s", cfg.Name), cfg.Script).WithCheck(cfg.Check)
+	action.Sudo = cfg.Sudo
+	runScript := func(ctx context.Context, nodeCfg *model.NodeConfig) error {
+		exec, err := newExecutor(ctx, nodeCfg)
+		if err != nil {
+			return errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to connect to node %s", nodeCfg.Address), err)
+		}
+		defer exec.Close()
+		var opts []executor.RunOption
+		if cfg.Sudo {
+			opts = append(opts, executor.WithSudo())
+		}
+		// Scripts without a check always run and are reported as changed.
+		// 没有检查命令的脚本总是会运行，并报告为已变更。
+		if cfg.Check != "" {
+			result, err := exec.Run(ctx, cfg.Check, opts...)
+			if err != nil {
+				plan.Record(ctx, action.Description, plan.StatusFailed, err)
+				return err
+			}
+			if result.Success() {
+				utils.GetLogger().Printf("[%s] Check of script phase %s passed, skipping the script", nodeCfg.Address, cfg.Name)
+				plan.Record(ctx, action.Description, plan.StatusUnchanged, nil)
+				return nil
+			}
+		}
+		if _, err := executor.RunCommand(ctx, exec, cfg.Script, append(opts, executor.WithLogging(nodeCfg.Address))...); err != nil {
+			plan.Record(ctx, action.Description, plan.StatusFailed, err)
+			return err
+		}
+		plan.Record(ctx, action.Description, plan.StatusChanged, nil)
+		return nil
+	}
+
+	def := PhaseDefinition{
+		Name:        cfg.Name,
+		Description: fmt.Sprintf("Script %s", cfg.Name),
+		Scope:       scope,
+		DependsOn:   cfg.DependsOn,
+		Roles:       cfg.Roles,
+		Timeout:     timeout,
+	}
+	if scope == enum.PhaseScopeCluster {
+		def.RunCluster = func(ctx context.Context, config *model.PlatformConfig) error {
+			master, err := firstMaster(cfg.Name, config)
+			if err != nil {
+				return err
+			}
+			utils.GetLogger().Printf("Running cluster script phase %s on master %s", cfg.Name, master.Address)
+			return runScript(ctx, master)
+		}
+		def.PlanCluster = func(ctx context.Context, config *model.PlatformConfig) ([]plan.TargetActions, error) {
+			master, err := firstMaster(cfg.Name, config)
+			if err != nil {
+				return nil, err
+			}
+			return []plan.TargetActions{{Target: master.Address, Actions: []plan.Action{action}}}, nil
+		}
+	} else {
+		def.RunNode = func(ctx context.Context, _ *model.PlatformConfig, nodeCfg *model.NodeConfig) error {
+			return runScript(ctx, nodeCfg)
+		}
+		def.PlanNode = func(context.Context, *model.PlatformConfig, *model.NodeConfig) ([]plan.Action, error) {
+			return []plan.Action{action}, nil
+		}
+	}
+	return def, def.validate()
+}
+
+// firstMaster returns the first master node of the config, which runs cluster-scoped script phases.
+// firstMaster 返回配置中的第一个主节点，集群范围的脚本阶段在其上运行。
+func firstMaster(phase string, config *model.PlatformConfig) (*model.NodeConfig, error) {
+	for i := range config.Cluster.Nodes {
+		if hasRole(&config.Cluster.Nodes[i], enum.RoleMaster) {
+			return &config.Cluster.Nodes[i], nil
+		}
+	}
+	return nil, errors.New(errors.ErrTypeConfig, fmt.Sprintf("cluster script phase %q needs a master node", phase))
+}
+
+// hasRole reports whether the node has the given role.
+// hasRole 报告节点是否具有给定角色。
+func hasRole(nodeCfg *model.NodeConfig, role enum.NodeRole) bool {
+	for _, r := range nodeCfg.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor/sshtest"
+)
+
+// recordingPhase returns a node-scoped phase definition that records its runs in rec.
+func recordingPhase(rec *callRecorder, name string, dependsOn []string, roles ...enum.NodeRole) PhaseDefinition {
+	return PhaseDefinition{
+		Name:      name,
+		Scope:     enum.PhaseScopeNode,
+		DependsOn: dependsOn,
+		Roles:     roles,
+		Timeout:   time.Minute,
+		RunNode: func(_ context.Context, _ *model.PlatformConfig, nodeCfg *model.NodeConfig) error {
+			return rec.record(name, nodeCfg.Address)
+		},
+	}
+}
+
+func TestRegistry_ResolveOrdersByDependencies(t *testing.T) {
+	rec := &callRecorder{}
+	pipeline, err := newFakeDeployer(rec, t.TempDir(), RunOptions{}).registry.Resolve()
+	require.NoError(t, err)
+	assert.Equal(t, PhaseOrder, phaseNames(pipeline))
+
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{},
+		recordingPhase(rec, "label", []string{PhaseKubernetes}),
+		recordingPhase(rec, "harden", []string{PhaseOS}),
+	)
+	pipeline, err = d.registry.Resolve()
+	require.NoError(t, err)
+	assert.Equal(t, []string{PhaseInit, PhaseOS, PhaseRuntime, PhaseNetwork, PhaseStorage, "harden", PhaseKubernetes, "label", PhaseVCluster}, phaseNames(pipeline),
+		"node phases are planned before the cluster phases they do not depend on")
+}
+
+func TestRegistry_RejectsInvalidGraphs(t *testing.T) {
+	rec := &callRecorder{}
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(recordingPhase(rec, "a", []string{"b"})))
+	require.NoError(t, registry.Register(recordingPhase(rec, "b", []string{"a"})))
+	_, err := registry.Resolve()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle involving: a, b")
+
+	registry = NewRegistry()
+	require.NoError(t, registry.Register(recordingPhase(rec, "a", []string{"missing"})))
+	_, err = registry.Resolve()
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeConfig))
+
+	err = registry.Register(recordingPhase(rec, "a", nil))
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeAlreadyExists))
+	assert.Error(t, registry.Register(PhaseDefinition{Name: "no-func", Scope: enum.PhaseScopeCluster, Timeout: time.Minute}))
+
+	_, err = NewDeployer(WithPhase(recordingPhase(rec, PhaseOS, nil)))
+	assert.Error(t, err, "custom phases cannot replace built-in ones")
+}
+
+func TestDeploy_CustomPhaseRunsOnMatchingRoles(t *testing.T) {
+	utils.InitLogger("info", 0)
+	config := testPlatformConfig()
+	config.Cluster.Nodes[0].Roles = []enum.NodeRole{enum.RoleMaster}
+	config.Cluster.Nodes[1].Roles = []enum.NodeRole{enum.RoleWorker}
+	rec := &callRecorder{}
+
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{OnlyPhase: "harden"}, recordingPhase(rec, "harden", []string{PhaseOS}, enum.RoleWorker))
+	require.NoError(t, d.Deploy(context.Background(), config))
+	assert.Equal(t, []string{"harden@10.0.0.2"}, rec.reset())
+}
+
+func TestAddNode_RunsClusterDependentPhasesAfterJoin(t *testing.T) {
+	utils.InitLogger("info", 0)
+	config := testPlatformConfig()
+	rec := &callRecorder{}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{}, recordingPhase(rec, "label", []string{PhaseKubernetes}))
+
+	d.hostClient = fake.NewSimpleClientset(readyNode("10.0.0.9"))
+	newNode := model.NodeConfig{Address: "10.0.0.9"}
+	require.NoError(t, d.AddNode(context.Background(), config, &newNode))
+	assert.Equal(t, []string{
+		"init@10.0.0.9", "os@10.0.0.9", "runtime@10.0.0.9", "network@10.0.0.9", "storage@10.0.0.9",
+		"join@10.0.0.9", "label@10.0.0.9",
+	}, rec.calls)
+}
+
+func TestAddNode_PreflightComparesWithClusterNodes(t *testing.T) {
+	utils.InitLogger("info", 0)
+	config := testPlatformConfig()
+	rec := &callRecorder{}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{})
+	d.inventoryDir = t.TempDir()
+	inventory := append(testNodes(2), model.NodeConfig{Address: "10.0.0.7"}, model.NodeConfig{Address: "10.0.0.9"})
+	require.NoError(t, SaveInventory(InventoryPath(d.inventoryDir, config.Metadata.Name), config.Metadata.Name, inventory))
+
+	d.hostClient = fake.NewSimpleClientset(readyNode("10.0.0.9"))
+	newNode := model.NodeConfig{Address: "10.0.0.9"}
+	config.Cluster.Nodes = append(config.Cluster.Nodes, newNode)
+	require.NoError(t, d.AddNode(context.Background(), config, &newNode))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.7"}, d.initializationPhase.(*fakeInitPhase).clusterNodes,
+		"the config and inventory nodes, without the new node")
+}
+
+func TestDeploy_ConfigDeclaredScriptPhase(t *testing.T) {
+	utils.InitLogger("info", 0)
+	t.Setenv("HOME", t.TempDir()) // Do not pick up the developer's known_hosts
+	server, err := sshtest.NewServer("root", "secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	config := testPlatformConfig()
+	config.Cluster.Nodes = []model.NodeConfig{server.NodeConfig()}
+	config.Phases = []model.PhaseConfig{{Name: "harden", DependsOn: []string{PhaseOS}, Script: "/opt/corp/harden.sh --strict"}}
+	server.HandleOutput("/opt/corp/harden.sh", "hardened\n", 0)
+
+	rec := &callRecorder{}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{OnlyPhase: "harden"})
+	d.newExecutor = executor.NewSSHExecutor
+	require.NoError(t, d.Deploy(context.Background(), config))
+	assert.Contains(t, server.Commands(), "/opt/corp/harden.sh --strict")
+
+	server.HandleOutput("/opt/corp/harden.sh", "", 3)
+	err = d.Deploy(context.Background(), config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Script harden")
+}
+
+func TestDeploy_ScriptPhaseCheckSkipsScript(t *testing.T) {
+	utils.InitLogger("info", 0)
+	t.Setenv("HOME", t.TempDir()) // Do not pick up the developer's known_hosts
+	server, err := sshtest.NewServer("root", "secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	config := testPlatformConfig()
+	config.Cluster.Nodes = []model.NodeConfig{server.NodeConfig()}
+	config.Phases = []model.PhaseConfig{{Name: "harden", Script: "/opt/corp/harden.sh", Check: "test -f /etc/corp/hardened"}}
+
+	rec := &callRecorder{}
+	d := newFakeDeployer(rec, t.TempDir(), RunOptions{OnlyPhase: "harden"})
+	d.newExecutor = executor.NewSSHExecutor
+	require.NoError(t, d.Deploy(context.Background(), config))
+	assert.Equal(t, []string{"test -f /etc/corp/hardened"}, server.Commands(), "a passing check skips the script")
+
+	server.HandleOutput("test -f /etc/corp/hardened", "", 1)
+	require.NoError(t, d.Deploy(context.Background(), config))
+	assert.Contains(t, server.Commands(), "/opt/corp/harden.sh")
+}
@@ -0,0 +1,497 @@
+// Package plan describes the remote actions a deployment would perform, so they can be reviewed before being applied.
+// 包 plan 描述部署将要执行的远程操作，以便在应用之前对其进行审查。
+// Phases render their work as a list of Actions (plan step); Apply executes those actions on a node (apply step).
+// 各阶段将其工作渲染为 Action 列表（计划步骤）；Apply 在节点上执行这些操作（应用步骤）。
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v2"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+)
+
+// ClusterTarget names the target of actions that are not bound to a single node, such as Helm releases.
+// ClusterTarget 命名不绑定到单个节点的操作（例如 Helm 发布）的目标。
+const ClusterTarget = "cluster"
+
+// redacted stands for the content of a file holding secrets in the rendered or diffed plan.
+// redacted 在渲染或比较后的计划中代替包含机密信息的文件内容。
+const redacted = "<redacted>"
+
+// ActionKind identifies the type of a planned action.
+// ActionKind 标识计划操作的类型。
+type ActionKind string
+
+const (
+	// ActionCommand runs a shell command on the node.
+	// ActionCommand 在节点上运行 shell 命令。
+	ActionCommand ActionKind = "command"
+	// ActionFile writes a file on the node.
+	// ActionFile 在节点上写入文件。
+	ActionFile ActionKind = "file"
+	// ActionKubeadmConfig writes a kubeadm configuration file on the node.
+	// ActionKubeadmConfig 在节点上写入 kubeadm 配置文件。
+	ActionKubeadmConfig ActionKind = "kubeadm-config"
+	// ActionHelmRelease installs or upgrades a Helm release in the Host Cluster.
+	// ActionHelmRelease 在 Host 集群中安装或升级 Helm 发布。
+	ActionHelmRelease ActionKind = "helm-release"
+	// ActionOpaque stands for a phase whose actions cannot be known in advance (e.g. custom Go phases).
+	// ActionOpaque 代表无法预先知道其操作的阶段（例如自定义 Go 阶段）。
+	ActionOpaque ActionKind = "opaque"
+)
+
+// Action is a single remote action.
+// Action 是单个远程操作。
+type Action struct {
+	// Kind is the type of the action.
+	// Kind 是操作的类型。
+	Kind ActionKind `yaml:"kind" json:"kind"`
+	// Description explains what the action does.
+	// Description 解释操作的作用。
+	Description string `yaml:"description" json:"description"`
+	// Command is the shell command of a command action.
+	// Command 是命令操作的 shell 命令。
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	// Sudo runs the command (and its check) with root privileges.
+	// Sudo 以 root 权限运行命令（及其检查）。
+	Sudo bool `yaml:"sudo,omitempty" json:"sudo,omitempty"`
+	// Check is a read-only command that succeeds when the node is already in the desired state, so Command is skipped.
+	// Check 是一个只读命令，当节点已处于期望状态时成功，此时跳过 Command。
+	// Commands without a check always run.
+	// 没有检查的命令总是运行。
+	Check string `yaml:"check,omitempty" json:"check,omitempty"`
+	// Path is the destination of a file action.
+	// Path 是文件操作的目标路径。
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Mode is the octal permission mode of a file action, e.g. "0644".
+	// Mode 是文件操作的八进制权限模式，例如 "0644"。
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Content is the full content of a file action.
+	// Content 是文件操作的完整内容。
+	Content string `yaml:"content,omitempty" json:"content,omitempty"`
+	// Diff is a unified diff from the file currently on the node, filled in when the plan is diffed.
+	// Diff 是与节点上当前文件的统一差异，在计划进行差异比较时填写。
+	Diff string `yaml:"diff,omitempty" json:"diff,omitempty"`
+	// Sensitive marks a file action holding secrets, whose content and diff are never shown in the plan.
+	// Sensitive 标记包含机密信息的文件操作，其内容和差异永远不会显示在计划中。
+	// Kubeadm configurations are planned with placeholders for their secrets, so only their diff is hidden.
+	// kubeadm 配置在计划中以占位符代替其机密信息，因此只隐藏其差异。
+	Sensitive bool `yaml:"sensitive,omitempty" json:"sensitive,omitempty"`
+	// Release describes a Helm release action.
+	// Release 描述 Helm 发布操作。
+	Release *HelmRelease `yaml:"release,omitempty" json:"release,omitempty"`
+}
+
+// HelmRelease describes a Helm release that would be installed or upgraded.
+// HelmRelease 描述将要安装或升级的 Helm 发布。
+type HelmRelease struct {
+	Name      string                 `yaml:"name" json:"name"`
+	Namespace string                 `yaml:"namespace" json:"namespace"`
+	Chart     string                 `yaml:"chart" json:"chart"`
+	Values    map[string]interface{} `yaml:"values,omitempty" json:"values,omitempty"`
+}
+
+// Command returns an action running cmd on the node.
+// Command 返回在节点上运行 cmd 的操作。
+func Command(description, cmd string) Action {
+	return Action{Kind: ActionCommand, Description: description, Command: cmd}
+}
+
+// WithCheck sets the read-only check that tells whether the action is already satisfied.
+// WithCheck 设置用于判断操作是否已满足的只读检查。
+func (a Action) WithCheck(check string) Action {
+	a.Check = check
+	return a
+}
+
+// SudoCommand returns an action running cmd on the node with root privileges.
+// SudoCommand 返回以 root 权限在节点上运行 cmd 的操作。
+func SudoCommand(description, cmd string) Action {
+	return Action{Kind: ActionCommand, Description: description, Command: cmd, Sudo: true}
+}
+
+// File returns an action writing content to path on the node.
+// File 返回将 content 写入节点上 path 的操作。
+func File(description, path, content string, mode os.FileMode) Action {
+	return Action{Kind: ActionFile, Description: description, Path: path, Mode: fmt.Sprintf("%04o", mode.Perm()), Content: content}
+}
+
+// SecretFile returns an action writing a file that holds secrets, such as registry credentials, to path on the node.
+// SecretFile 返回将包含机密信息（例如仓库凭据）的文件写入节点上 path 的操作。
+// The content is applied as is but redacted when the plan is rendered or diffed.
+// 内容按原样应用，但在渲染或比较计划时会被隐去。
+func SecretFile(description, path, content string, mode os.FileMode) Action {
+	action := File(description, path, content, mode)
+	action.Sensitive = true
+	return action
+}
+
+// KubeadmConfig returns an action writing a kubeadm configuration file on the node.
+// KubeadmConfig 返回在节点上写入 kubeadm 配置文件的操作。
+// The file on the node holds the live bootstrap token and certificate key, so its diff is redacted.
+// 节点上的文件包含实际的引导令牌和证书密钥，因此其差异会被隐去。
+func KubeadmConfig(description, path, content string) Action {
+	action := SecretFile(description, path, content, 0600)
+	action.Kind = ActionKubeadmConfig
+	return action
+}
+
+// Helm returns an action installing or upgrading a Helm release.
+// Helm 返回安装或升级 Helm 发布的操作。
+func Helm(description string, release HelmRelease) Action {
+	return Action{Kind: ActionHelmRelease, Description: description, Release: &release}
+}
+
+// Opaque returns an action standing for work that cannot be planned in advance.
+// Opaque 返回代表无法预先计划的工作的操作。
+func Opaque(description string) Action {
+	return Action{Kind: ActionOpaque, Description: description}
+}
+
+// writesFile reports whether the action writes a file on the node.
+// writesFile 报告操作是否在节点上写入文件。
+func (a *Action) writesFile() bool {
+	return a.Kind == ActionFile || a.Kind == ActionKubeadmConfig
+}
+
+// FileMode parses the permission mode of a file action.
+// FileMode 解析文件操作的权限模式。
+func (a *Action) FileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(a.Mode, 8, 32)
+	if err != nil {
+		return 0, errors.NewWithCause(errors.ErrTypeInternal, fmt.Sprintf("invalid file mode %q for %s", a.Mode, a.Path), err)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Apply executes node actions in order on the executor, stopping at the first failure.
+// Apply 在执行器上按顺序执行节点操作，遇到第一个失败即停止。
+// Every action first checks the node: commands whose Check succeeds and files whose content and mode already match
+// are skipped; a file with the right content but another mode is only chmod-ed.
+// Each action is recorded as unchanged, changed or failed with the reporter of ctx (see WithReporter).
+// 每个操作都会先检查节点：Check 成功的命令以及内容和权限模式均已匹配的文件将被跳过；内容正确但模式不同的文件只执行 chmod。
+// 每个操作都会以 unchanged、changed 或 failed 记录到 ctx 的报告器中（参见 WithReporter）。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// exec: The executor for the target node. / 目标节点的执行器。
+// actions: The actions to apply. / 要应用的操作。
+// Returns an error naming the failed action.
+// 返回指明失败操作的错误。
+func Apply(ctx context.Context, exec executor.NodeExecutor, actions []Action) error {
+	for i := range actions {
+		action := &actions[i]
+		changed, err := applyAction(ctx, exec, action)
+		if err != nil {
+			Record(ctx, action.Description, StatusFailed, err)
+			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to %s on %s", lowerFirst(action.Description), exec.Address()), err)
+		}
+		if changed {
+			utils.GetLogger().Printf("[%s] changed: %s", exec.Address(), action.Description)
+			Record(ctx, action.Description, StatusChanged, nil)
+		} else {
+			Record(ctx, action.Description, StatusUnchanged, nil)
+		}
+	}
+	return nil
+}
+
+// applyAction applies a single action unless the node is already in the desired state, and reports whether it changed anything.
+// applyAction 应用单个操作（除非节点已处于期望状态），并报告是否进行了更改。
+func applyAction(ctx context.Context, exec executor.NodeExecutor, action *Action) (bool, error) {
+	opts := []executor.RunOption{}
+	if action.Sudo {
+		opts = append(opts, executor.WithSudo())
+	}
+	if action.Check != "" {
+		result, err := exec.Run(ctx, action.Check, opts...)
+		if err != nil {
+			return false, err
+		}
+		if result.Success() {
+			return false, nil
+		}
+	}
+
+	switch {
+	case action.Kind == ActionCommand:
+		_, err := executor.RunCommand(ctx, exec, action.Command, opts...)
+		return err == nil, err
+	case action.writesFile():
+		mode, err := action.FileMode()
+		if err != nil {
+			return false, err
+		}
+		current, currentMode, exists, err := NodeFileReader(exec)(ctx, action.Path)
+		if err != nil {
+			return false, err
+		}
+		if exists && current == action.Content {
+			if currentMode == mode {
+				return false, nil
+			}
+			_, err := executor.RunCommand(ctx, exec, fmt.Sprintf("chmod %04o %s", mode, executor.ShellQuote(action.Path)), executor.WithSudo())
+			return err == nil, err
+		}
+		return true, exec.Upload(ctx, strings.NewReader(action.Content), action.Path, mode)
+	default:
+		return false, errors.New(errors.ErrTypeInternal, fmt.Sprintf("%s actions cannot be applied on a node", action.Kind))
+	}
+}
+
+// lowerFirst lower-cases the first letter so descriptions read naturally inside error messages.
+// lowerFirst 将首字母小写，使描述在错误消息中读起来自然。
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// TargetActions groups the actions of a phase for one target (a node address or ClusterTarget).
+// TargetActions 为一个目标（节点地址或 ClusterTarget）对阶段的操作进行分组。
+type TargetActions struct {
+	Target  string
+	Actions []Action
+}
+
+// Step lists the actions a phase performs on a target.
+// Step 列出阶段在目标上执行的操作。
+type Step struct {
+	Phase   string   `yaml:"phase" json:"phase"`
+	Actions []Action `yaml:"actions" json:"actions"`
+}
+
+// Target lists the steps for one node, or for the cluster as a whole.
+// Target 列出一个节点或整个集群的步骤。
+type Target struct {
+	Name  string `yaml:"name" json:"name"`
+	Steps []Step `yaml:"steps" json:"steps"`
+}
+
+// Plan is the full set of actions a deployment would perform.
+// Plan 是部署将要执行的完整操作集。
+// It contains no timestamps and keeps a fixed order so the rendered output can be reviewed and diffed in pull requests.
+// 它不包含时间戳并保持固定顺序，以便渲染的输出可以在拉取请求中审查和比较。
+type Plan struct {
+	// Platform is the name of the platform config.
+	// Platform 是平台配置的名称。
+	Platform string `yaml:"platform" json:"platform"`
+	// ConfigHash identifies the configuration the plan was made from.
+	// ConfigHash 标识生成计划所用的配置。
+	ConfigHash string `yaml:"configHash" json:"configHash"`
+	// Phases lists the selected phases in execution order.
+	// Phases 按执行顺序列出所选阶段。
+	Phases []string `yaml:"phases" json:"phases"`
+	// Targets lists the nodes in config order, followed by the cluster target if it has actions.
+	// Targets 按配置顺序列出节点，如果集群目标有操作则随后列出集群目标。
+	Targets []Target `yaml:"targets" json:"targets"`
+}
+
+// New creates an empty plan with a target for each node, in order.
+// New 创建一个空计划，并按顺序为每个节点创建一个目标。
+func New(platform, configHash string, nodes []string) *Plan {
+	p := &Plan{Platform: platform, ConfigHash: configHash}
+	for _, node := range nodes {
+		p.Targets = append(p.Targets, Target{Name: node})
+	}
+	return p
+}
+
+// Add records the actions of a phase for a target. Phases without actions are not recorded.
+// Add 记录阶段在目标上的操作。没有操作的阶段不会被记录。
+func (p *Plan) Add(target, phase string, actions []Action) {
+	if len(actions) == 0 {
+		return
+	}
+	t := p.target(target)
+	t.Steps = append(t.Steps, Step{Phase: phase, Actions: actions})
+}
+
+// Target returns the target with the given name, or nil if it has no entry.
+// Target 返回具有给定名称的目标，如果没有条目则返回 nil。
+func (p *Plan) Target(name string) *Target {
+	for i := range p.Targets {
+		if p.Targets[i].Name == name {
+			return &p.Targets[i]
+		}
+	}
+	return nil
+}
+
+// target returns the target with the given name, appending it if needed.
+// target 返回具有给定名称的目标，必要时追加它。
+func (p *Plan) target(name string) *Target {
+	if t := p.Target(name); t != nil {
+		return t
+	}
+	p.Targets = append(p.Targets, Target{Name: name})
+	return &p.Targets[len(p.Targets)-1]
+}
+
+// FileReader reads the current content and permission mode of a file on a node; exists is false if the file is absent.
+// FileReader 读取节点上文件的当前内容和权限模式；如果文件不存在，exists 为 false。
+type FileReader func(ctx context.Context, path string) (content string, mode os.FileMode, exists bool, err error)
+
+// NodeFileReader returns a FileReader that reads files on the node through the executor, with root privileges.
+// NodeFileReader 返回一个通过执行器以 root 权限读取节点上文件的 FileReader。
+func NodeFileReader(exec executor.NodeExecutor) FileReader {
+	return func(ctx context.Context, path string) (string, os.FileMode, bool, error) {
+		quoted := executor.ShellQuote(path)
+		result, err := exec.Run(ctx, "test -f "+quoted+" && stat -c %a "+quoted, executor.WithSudo())
+		if err != nil {
+			return "", 0, false, err
+		}
+		if !result.Success() {
+			return "", 0, false, nil
+		}
+		mode, err := strconv.ParseUint(strings.TrimSpace(result.Stdout), 8, 32)
+		if err != nil {
+			return "", 0, false, errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("unexpected mode %q of %s on %s", strings.TrimSpace(result.Stdout), path, exec.Address()), err)
+		}
+		var content strings.Builder
+		if err := exec.Download(ctx, path, &content); err != nil {
+			return "", 0, false, err
+		}
+		return content.String(), os.FileMode(mode), true, nil
+	}
+}
+
+// DiffFiles fills in the diff of every file action of a target against the current files on the node,
+// headed by the mode change when the permission mode differs.
+// DiffFiles 将目标的每个文件操作与节点上的当前文件进行比较并填写差异；权限模式不同时差异以模式变更开头。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// target: The node whose actions are diffed. / 要比较其操作的节点。
+// read: Reads the current files on the node. / 读取节点上的当前文件。
+func (p *Plan) DiffFiles(ctx context.Context, target string, read FileReader) error {
+	t := p.Target(target)
+	if t == nil {
+		return nil
+	}
+	for i := range t.Steps {
+		for j := range t.Steps[i].Actions {
+			action := &t.Steps[i].Actions[j]
+			if !action.writesFile() {
+				continue
+			}
+			current, currentMode, exists, err := read(ctx, action.Path)
+			if err != nil {
+				return err
+			}
+			mode, err := action.FileMode()
+			if err != nil {
+				return err
+			}
+			diff := Diff(action.Path, current, exists, action.Content)
+			if action.Sensitive && diff != "" {
+				diff = redactedDiff(action.Path, exists)
+			}
+			action.Diff = ModeDiff(currentMode, exists, mode) + diff
+		}
+	}
+	return nil
+}
+
+// Diff returns a unified diff between the current and desired content of a file.
+// Diff 返回文件当前内容与期望内容之间的统一差异。
+// An empty string means the file is already up to date.
+// 空字符串表示文件已是最新的。
+func Diff(path, current string, exists bool, desired string) string {
+	if exists && current == desired {
+		return ""
+	}
+	from := path
+	if !exists {
+		from = "/dev/null"
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(current),
+		B:        splitLines(desired),
+		FromFile: from,
+		ToFile:   path,
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Sprintf("diff unavailable: %v", err)
+	}
+	return diff
+}
+
+// redactedDiff returns the diff header of a changed file holding secrets, without its content.
+// redactedDiff 返回包含机密信息的已更改文件的差异头，不含其内容。
+func redactedDiff(path string, exists bool) string {
+	from := path
+	if !exists {
+		from = "/dev/null"
+	}
+	return fmt.Sprintf("--- %s\n+++ %s\n%s\n", from, path, redacted)
+}
+
+// ModeDiff returns the "old mode"/"new mode" lines of a file whose permission mode changes, or an empty string.
+// ModeDiff 返回权限模式发生变化的文件的 "old mode"/"new mode" 行，否则返回空字符串。
+func ModeDiff(current os.FileMode, exists bool, desired os.FileMode) string {
+	if !exists || current.Perm() == desired.Perm() {
+		return ""
+	}
+	return fmt.Sprintf("old mode %04o\nnew mode %04o\n", current.Perm(), desired.Perm())
+}
+
+// splitLines splits s into lines, keeping the line endings. Unlike difflib.SplitLines it adds no trailing empty line.
+// splitLines 将 s 拆分为行并保留行尾。与 difflib.SplitLines 不同，它不会添加末尾空行。
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Render serializes the plan in the given format ("yaml" or "json"), with the content of sensitive files redacted.
+// Render 以给定格式（"yaml" 或 "json"）序列化计划，敏感文件的内容会被隐去。
+func (p *Plan) Render(format string) ([]byte, error) {
+	p = p.redacted()
+	switch format {
+	case "yaml", "":
+		return yaml.Marshal(p)
+	case "json":
+		out, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	default:
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported plan output format %q (valid formats: yaml, json)", format))
+	}
+}
+
+// redacted returns a copy of the plan in which the content of sensitive files is replaced with a placeholder.
+// redacted 返回计划的副本，其中敏感文件的内容被替换为占位符。
+func (p *Plan) redacted() *Plan {
+	out := *p
+	out.Targets = append([]Target(nil), p.Targets...)
+	for i := range out.Targets {
+		steps := append([]Step(nil), out.Targets[i].Steps...)
+		for j := range steps {
+			actions := append([]Action(nil), steps[j].Actions...)
+			for k := range actions {
+				if actions[k].Sensitive && actions[k].Kind != ActionKubeadmConfig && actions[k].Content != "" {
+					actions[k].Content = redacted
+				}
+			}
+			steps[j].Actions = actions
+		}
+		out.Targets[i].Steps = steps
+	}
+	return &out
+}
@@ -0,0 +1,222 @@
+package plan
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor/sshtest"
+)
+
+// newTestExecutor starts an sshtest server and connects an executor to it.
+func newTestExecutor(t *testing.T) (*sshtest.Server, executor.NodeExecutor) {
+	t.Helper()
+	utils.InitLogger("info", 0)
+	t.Setenv("HOME", t.TempDir()) // Do not pick up the developer's known_hosts
+	server, err := sshtest.NewServer("root", "secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	nodeCfg := server.NodeConfig()
+	exec, err := executor.NewSSHExecutor(context.Background(), &nodeCfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { exec.Close() })
+	return server, exec
+}
+
+func testPlan() *Plan {
+	p := New("demo", "sha256:abc", []string{"10.0.0.1", "10.0.0.2"})
+	p.Phases = []string{"os", "vcluster"}
+	p.Add("10.0.0.2", "os", []Action{File("Persist sysctl settings", "/etc/sysctl.d/99-chasi-bod.conf", "vm.swappiness = 0\n", 0644)})
+	p.Add("10.0.0.1", "os", nil) // Phases without actions are left out
+	p.Add(ClusterTarget, "vcluster", []Action{Helm("Install or upgrade vcluster team-a", HelmRelease{
+		Name: "team-a", Namespace: "vc-team-a", Chart: "pkg/vcluster/chart/vcluster",
+		Values: map[string]interface{}{"vcluster": map[string]interface{}{"image": "rancher/k3s:v1.30.0"}, "syncer": map[string]interface{}{"extraArgs": []string{"--tls-san=x"}}},
+	})})
+	return p
+}
+
+func TestRender_IsStable(t *testing.T) {
+	first, err := testPlan().Render("yaml")
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		again, err := testPlan().Render("yaml")
+		require.NoError(t, err)
+		assert.Equal(t, string(first), string(again))
+	}
+	assert.Equal(t, `platform: demo
+configHash: sha256:abc
+phases:
+- os
+- vcluster
+targets:
+- name: 10.0.0.1
+  steps: []
+- name: 10.0.0.2
+  steps:
+  - phase: os
+    actions:
+    - kind: file
+      description: Persist sysctl settings
+      path: /etc/sysctl.d/99-chasi-bod.conf
+      mode: "0644"
+      content: |
+        vm.swappiness = 0
+- name: cluster
+  steps:
+  - phase: vcluster
+    actions:
+    - kind: helm-release
+      description: Install or upgrade vcluster team-a
+      release:
+        name: team-a
+        namespace: vc-team-a
+        chart: pkg/vcluster/chart/vcluster
+        values:
+          syncer:
+            extraArgs:
+            - --tls-san=x
+          vcluster:
+            image: rancher/k3s:v1.30.0
+`, string(first))
+
+	_, err = testPlan().Render("json")
+	assert.NoError(t, err)
+	_, err = testPlan().Render("toml")
+	assert.Error(t, err)
+}
+
+func TestRender_RedactsSecretFiles(t *testing.T) {
+	p := testPlan()
+	p.Add("10.0.0.1", "runtime", []Action{SecretFile("Write the registry credentials", "/etc/containers/auth.json", "{\"auth\":\"c2VjcmV0\"}\n", 0600)})
+	for _, format := range []string{"yaml", "json"} {
+		out, err := p.Render(format)
+		require.NoError(t, err)
+		assert.NotContains(t, string(out), "c2VjcmV0", format)
+		assert.Contains(t, string(out), "sensitive", format)
+	}
+	assert.Equal(t, "{\"auth\":\"c2VjcmV0\"}\n", p.Target("10.0.0.1").Steps[0].Actions[0].Content, "the plan itself keeps the content to apply")
+}
+
+func TestDiffFiles_RedactsKubeadmConfigs(t *testing.T) {
+	server, exec := newTestExecutor(t)
+	server.HandleFile("/etc/chasi-bod/kubeadm/join.yaml", "discovery:\n  bootstrapToken:\n    token: abcdef.0123456789abcdef\n", 0600)
+
+	p := New("demo", "sha256:abc", []string{"10.0.0.1"})
+	p.Add("10.0.0.1", "k8s", []Action{KubeadmConfig("Write the kubeadm join configuration", "/etc/chasi-bod/kubeadm/join.yaml", "discovery:\n  bootstrapToken:\n    token: <bootstrap token>\n")})
+	require.NoError(t, p.DiffFiles(context.Background(), "10.0.0.1", NodeFileReader(exec)))
+	out, err := p.Render("yaml")
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "abcdef.0123456789abcdef", "the token on the node is not shown")
+	assert.Contains(t, string(out), "token: <bootstrap token>", "the planned content only holds placeholders")
+	assert.Contains(t, p.Target("10.0.0.1").Steps[0].Actions[0].Diff, redacted)
+}
+
+func TestDiff(t *testing.T) {
+	assert.Empty(t, Diff("/etc/a", "x\n", true, "x\n"), "unchanged files have no diff")
+	assert.Equal(t, "--- /etc/a\n+++ /etc/a\n@@ -1,2 +1,2 @@\n a = 1\n-b = 1\n+b = 2\n", Diff("/etc/a", "a = 1\nb = 1\n", true, "a = 1\nb = 2\n"))
+	assert.Equal(t, "--- /dev/null\n+++ /etc/a\n@@ -0,0 +1 @@\n+a = 1\n", Diff("/etc/a", "", false, "a = 1\n"))
+}
+
+func TestModeDiff(t *testing.T) {
+	assert.Empty(t, ModeDiff(0644, true, 0644))
+	assert.Empty(t, ModeDiff(0, false, 0600), "new files have no mode change")
+	assert.Equal(t, "old mode 0644\nnew mode 0440\n", ModeDiff(0644, true, 0440))
+}
+
+func TestDiffFiles_ReadsCurrentFilesFromTheNode(t *testing.T) {
+	server, exec := newTestExecutor(t)
+	server.HandleFile("/etc/sysctl.d/99-chasi-bod.conf", "vm.swappiness = 60\n", 0644)
+
+	p := testPlan()
+	p.Add("10.0.0.1", "runtime", []Action{File("Write runtime config", "/etc/containerd/config.toml", "version = 2\n", 0644)})
+	server.HandleOutput("test -f /etc/containerd/config.toml", "", 1)
+
+	require.NoError(t, p.DiffFiles(context.Background(), "10.0.0.2", NodeFileReader(exec)))
+	require.NoError(t, p.DiffFiles(context.Background(), "10.0.0.1", NodeFileReader(exec)))
+	assert.Contains(t, p.Target("10.0.0.2").Steps[0].Actions[0].Diff, "-vm.swappiness = 60\n+vm.swappiness = 0\n")
+	assert.Contains(t, p.Target("10.0.0.1").Steps[0].Actions[0].Diff, "--- /dev/null\n")
+
+	// Right content, wrong mode.
+	server.HandleFile("/etc/sysctl.d/99-chasi-bod.conf", "vm.swappiness = 0\n", 0600)
+	require.NoError(t, p.DiffFiles(context.Background(), "10.0.0.2", NodeFileReader(exec)))
+	assert.Equal(t, "old mode 0600\nnew mode 0644\n", p.Target("10.0.0.2").Steps[0].Actions[0].Diff)
+
+	// The diff of a file holding secrets only says that it changes.
+	p.Add("10.0.0.2", "runtime", []Action{SecretFile("Write the registry credentials", "/etc/containers/auth.json", "new-secret\n", 0600)})
+	server.HandleFile("/etc/containers/auth.json", "old-secret\n", 0644)
+	require.NoError(t, p.DiffFiles(context.Background(), "10.0.0.2", NodeFileReader(exec)))
+	assert.Equal(t, "old mode 0644\nnew mode 0600\n--- /etc/containers/auth.json\n+++ /etc/containers/auth.json\n<redacted>\n", p.Target("10.0.0.2").Steps[1].Actions[0].Diff)
+	server.HandleFile("/etc/containers/auth.json", "new-secret\n", 0600)
+	require.NoError(t, p.DiffFiles(context.Background(), "10.0.0.2", NodeFileReader(exec)))
+	assert.Empty(t, p.Target("10.0.0.2").Steps[1].Actions[0].Diff)
+}
+
+func TestApply(t *testing.T) {
+	server, exec := newTestExecutor(t)
+	server.HandleOutput("test -f /etc/sysctl.d/99-chasi-bod.conf", "", 1)
+	server.HandleOutput("sysctl -n", "", 1)
+	actions := []Action{
+		File("Persist sysctl settings", "/etc/sysctl.d/99-chasi-bod.conf", "vm.swappiness = 0\n", 0644),
+		SudoCommand("Apply sysctl settings", "sysctl -p /etc/sysctl.d/99-chasi-bod.conf").WithCheck("test \"$(sysctl -n vm.swappiness)\" = 0"),
+	}
+	report := NewReport()
+	require.NoError(t, Apply(WithReporter(context.Background(), report, "10.0.0.1", "os"), exec, actions))
+	commands := server.Commands()
+	require.Len(t, commands, 4)
+	assert.Contains(t, commands[1], "cat > /etc/sysctl.d/99-chasi-bod.conf")
+	assert.Contains(t, commands[3], "sysctl -p /etc/sysctl.d/99-chasi-bod.conf")
+	assert.Equal(t, Summary{Changed: 2}, report.Summary())
+
+	server.HandleOutput("sysctl -p", "", 255)
+	err := Apply(WithReporter(context.Background(), report, "10.0.0.1", "os"), exec, actions)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to apply sysctl settings on")
+	assert.True(t, report.HasFailure("10.0.0.1", "os"))
+
+	assert.Error(t, Apply(context.Background(), exec, []Action{Helm("Install", HelmRelease{Name: "x"})}), "helm releases are not node actions")
+}
+
+func TestApply_SkipsConvergedActions(t *testing.T) {
+	server, exec := newTestExecutor(t)
+	server.HandleFile("/etc/sysctl.d/99-chasi-bod.conf", "vm.swappiness = 0\n", 0644)
+	actions := []Action{
+		File("Persist sysctl settings", "/etc/sysctl.d/99-chasi-bod.conf", "vm.swappiness = 0\n", 0644),
+		SudoCommand("Apply sysctl settings", "sysctl -p /etc/sysctl.d/99-chasi-bod.conf").WithCheck("test \"$(sysctl -n vm.swappiness)\" = 0"),
+	}
+	report := NewReport()
+	require.NoError(t, Apply(WithReporter(context.Background(), report, "10.0.0.1", "os"), exec, actions))
+	assert.Equal(t, []string{
+		"test -f /etc/sysctl.d/99-chasi-bod.conf && stat -c %a /etc/sysctl.d/99-chasi-bod.conf",
+		"cat /etc/sysctl.d/99-chasi-bod.conf",
+		"test \"$(sysctl -n vm.swappiness)\" = 0",
+	}, server.Commands(), "nothing is written or applied")
+	assert.Equal(t, "0 changed, 2 unchanged, 0 failed", report.Summary().String())
+	assert.Equal(t, Result{Target: "10.0.0.1", Phase: "os", Item: "Apply sysctl settings", Status: StatusUnchanged}, report.Results()[1])
+}
+
+func TestApply_FixesModeDrift(t *testing.T) {
+	server, exec := newTestExecutor(t)
+	server.HandleFile("/etc/sudoers.d/ops", "ops ALL=(ALL) NOPASSWD: ALL\n", 0644)
+	actions := []Action{File("Grant sudo to ops", "/etc/sudoers.d/ops", "ops ALL=(ALL) NOPASSWD: ALL\n", 0440)}
+	report := NewReport()
+	require.NoError(t, Apply(WithReporter(context.Background(), report, "10.0.0.1", "users"), exec, actions))
+	commands := server.Commands()
+	assert.Equal(t, "chmod 0440 /etc/sudoers.d/ops", commands[len(commands)-1], "only the mode is fixed")
+	assert.Equal(t, -1, indexContaining(commands, "cat > "), "the content is not rewritten")
+	assert.Equal(t, Summary{Changed: 1}, report.Summary())
+}
+
+// indexContaining returns the index of the first command containing substr, or -1.
+func indexContaining(commands []string, substr string) int {
+	for i, cmd := range commands {
+		if strings.Contains(cmd, substr) {
+			return i
+		}
+	}
+	return -1
+}
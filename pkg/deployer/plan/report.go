@@ -0,0 +1,158 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Status is the outcome of applying a single item.
+// Status 是应用单个条目的结果。
+type Status string
+
+const (
+	// StatusUnchanged means the node was already in the desired state.
+	// StatusUnchanged 表示节点已处于期望状态。
+	StatusUnchanged Status = "unchanged"
+	// StatusChanged means the item was applied.
+	// StatusChanged 表示条目已被应用。
+	StatusChanged Status = "changed"
+	// StatusFailed means applying the item failed.
+	// StatusFailed 表示应用条目失败。
+	StatusFailed Status = "failed"
+)
+
+// Result records the outcome of one item of a phase on one target.
+// Result 记录阶段的一个条目在一个目标上的结果。
+type Result struct {
+	Target string
+	Phase  string
+	Item   string
+	Status Status
+	Error  string
+}
+
+// Summary counts results by status.
+// Summary 按状态统计结果。
+type Summary struct {
+	Changed   int
+	Unchanged int
+	Failed    int
+}
+
+// String renders the summary, e.g. "0 changed, 12 unchanged, 0 failed".
+// String 渲染摘要，例如 "0 changed, 12 unchanged, 0 failed"。
+func (s Summary) String() string {
+	return fmt.Sprintf("%d changed, %d unchanged, %d failed", s.Changed, s.Unchanged, s.Failed)
+}
+
+// Report collects the results of a run. It is safe for concurrent use.
+// Report 收集一次运行的结果。它可以安全地并发使用。
+type Report struct {
+	mu      sync.Mutex
+	results []Result
+}
+
+// NewReport creates an empty report.
+// NewReport 创建一个空报告。
+func NewReport() *Report {
+	return &Report{}
+}
+
+// Add appends a result.
+// Add 追加一个结果。
+func (r *Report) Add(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+// Results returns a copy of the results in the order they were recorded.
+// Results 按记录顺序返回结果的副本。
+func (r *Report) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Result(nil), r.results...)
+}
+
+// Summary counts the recorded results by status.
+// Summary 按状态统计已记录的结果。
+func (r *Report) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var s Summary
+	for _, result := range r.results {
+		switch result.Status {
+		case StatusChanged:
+			s.Changed++
+		case StatusUnchanged:
+			s.Unchanged++
+		case StatusFailed:
+			s.Failed++
+		}
+	}
+	return s
+}
+
+// HasFailure reports whether a failed result was recorded for the target and phase.
+// HasFailure 报告是否为目标和阶段记录了失败的结果。
+func (r *Report) HasFailure(target, phase string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, result := range r.results {
+		if result.Target == target && result.Phase == phase && result.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// reporterKey is the context key of the scoped reporter.
+// reporterKey 是作用域报告器的上下文键。
+type reporterKey struct{}
+
+// reporter is a report scoped to one target and phase.
+// reporter 是作用于一个目标和阶段的报告。
+type reporter struct {
+	report *Report
+	target string
+	phase  string
+}
+
+// WithReporter returns a context whose Record calls add results for target and phase to report.
+// WithReporter 返回一个上下文，其 Record 调用会将目标和阶段的结果添加到 report。
+// Phases keep their signatures; the deployer scopes the context before running each step.
+// 各阶段保持其签名不变；deployer 在运行每个步骤之前设置上下文的作用域。
+func WithReporter(ctx context.Context, report *Report, target, phase string) context.Context {
+	return context.WithValue(ctx, reporterKey{}, &reporter{report: report, target: target, phase: phase})
+}
+
+// WithTarget returns a context whose Record calls are attributed to another target within the same report and phase.
+// WithTarget 返回一个上下文，其 Record 调用归属于同一报告和阶段中的另一个目标。
+// Cluster-wide phases use it for the work they do on individual nodes.
+// 集群范围的阶段将其用于在单个节点上执行的工作。
+func WithTarget(ctx context.Context, target string) context.Context {
+	r, ok := ctx.Value(reporterKey{}).(*reporter)
+	if !ok {
+		return ctx
+	}
+	return WithReporter(ctx, r.report, target, r.phase)
+}
+
+// Record adds the outcome of an item to the report carried by ctx, if any.
+// Record 将条目的结果添加到 ctx 携带的报告（如果有）。
+// ctx: Context scoped with WithReporter. / 使用 WithReporter 设置作用域的上下文。
+// item: Describes the item, e.g. "Persist sysctl settings". / 描述条目，例如 "Persist sysctl settings"。
+// status: The outcome. / 结果。
+// err: The failure, for StatusFailed. / 失败原因，用于 StatusFailed。
+func Record(ctx context.Context, item string, status Status, err error) {
+	r, ok := ctx.Value(reporterKey{}).(*reporter)
+	if !ok {
+		return
+	}
+	result := Result{Target: r.target, Phase: r.phase, Item: item, Status: status}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	r.report.Add(result)
+}
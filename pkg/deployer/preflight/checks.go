@@ -0,0 +1,625 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/turtacn/chasi-bod/common/constants"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+)
+
+// Names of the checks, as shown in the report.
+// 检查的名称，如报告中所示。
+const (
+	CheckSSH           = "ssh"
+	CheckOS            = "os"
+	CheckKernel        = "kernel"
+	CheckTools         = "tools"
+	CheckSwap          = "swap"
+	CheckKernelModules = "kernel-modules"
+	CheckPorts         = "ports"
+	CheckCPU           = "cpu"
+	CheckMemory        = "memory"
+	CheckDisk          = "disk"
+	CheckTimeDrift     = "time-drift"
+	CheckCgroup        = "cgroup"
+	CheckHostname      = "hostname"
+)
+
+// kubeletConfigPath exists once a node has joined a cluster; its Kubernetes ports are then expected to be in use.
+// kubeletConfigPath 在节点加入集群后存在；此时其 Kubernetes 端口预期已被占用。
+const kubeletConfigPath = "/etc/kubernetes/kubelet.conf"
+
+// Resources are the minimum resources of a node.
+// Resources 是节点的最低资源要求。
+type Resources struct {
+	CPUs      int // Number of CPUs / CPU 数量
+	MemoryMiB int // Total memory in MiB / 总内存（MiB）
+	DiskGiB   int // Free space on the data disk in GiB / 数据盘上的可用空间（GiB）
+}
+
+// Requirements are the requirements nodes are checked against.
+// Requirements 是检查节点所依据的要求。
+type Requirements struct {
+	// MinKernel is the minimum kernel version, e.g. "4.19".
+	// MinKernel 是最低内核版本，例如 "4.19"。
+	MinKernel string
+	// SupportedDistros lists the os-release IDs that are tested; other distributions only produce a warning.
+	// SupportedDistros 列出经过测试的 os-release ID；其他发行版只会产生警告。
+	SupportedDistros []string
+	// Tools lists the binaries every node must provide.
+	// Tools 列出了每个节点必须提供的二进制文件。
+	Tools []string
+	// RoleTools lists additional binaries per role.
+	// RoleTools 列出了每个角色额外需要的二进制文件。
+	RoleTools map[enum.NodeRole][]string
+	// KernelModules lists the kernel modules Kubernetes networking and the container runtime rely on.
+	// KernelModules 列出了 Kubernetes 网络和容器运行时所依赖的内核模块。
+	KernelModules []string
+	// Ports lists the ports that must be free per role.
+	// Ports 列出了每个角色必须空闲的端口。
+	Ports map[enum.NodeRole][]int
+	// Resources lists the minimum resources per role; a node with several roles must meet the largest of each.
+	// Resources 列出了每个角色的最低资源；具有多个角色的节点必须满足各项中的最大值。
+	Resources map[enum.NodeRole]Resources
+	// DataPath is the path whose file system must provide Resources.DiskGiB.
+	// DataPath 是其文件系统必须提供 Resources.DiskGiB 的路径。
+	DataPath string
+	// MaxClockDrift is the largest tolerated difference between the node clock and the local clock.
+	// MaxClockDrift 是节点时钟与本地时钟之间可容忍的最大差异。
+	MaxClockDrift time.Duration
+	// SSHTimeout bounds the SSH reachability probe.
+	// SSHTimeout 限制 SSH 可达性探测的时间。
+	SSHTimeout time.Duration
+}
+
+// DefaultRequirements returns the requirements of a kubeadm based Host Cluster.
+// DefaultRequirements 返回基于 kubeadm 的 Host Cluster 的要求。
+func DefaultRequirements() Requirements {
+	return Requirements{
+		MinKernel:        "4.19",
+		SupportedDistros: []string{"ubuntu", "debian", "centos", "rhel", "rocky", "almalinux", "openEuler", "kylin"},
+		Tools:            []string{"systemctl", "sysctl", "mount", "ip"},
+		KernelModules:    []string{"br_netfilter", "overlay"},
+		Ports: map[enum.NodeRole][]int{
+			enum.RoleMaster: {constants.DefaultKubeAPIServerPort, 2379, 2380, 10250, 10257, 10259},
+			enum.RoleWorker: {10250},
+		},
+		Resources: map[enum.NodeRole]Resources{
+			enum.RoleMaster: {CPUs: 2, MemoryMiB: 1700, DiskGiB: 20},
+			enum.RoleWorker: {CPUs: 1, MemoryMiB: 1024, DiskGiB: 20},
+		},
+		DataPath:      "/var/lib",
+		MaxClockDrift: 2 * time.Second,
+		SSHTimeout:    5 * time.Second,
+	}
+}
+
+// ports returns the sorted ports that must be free on a node with the given roles. Nodes without roles count as workers.
+// ports 返回具有给定角色的节点上必须空闲的端口（已排序）。没有角色的节点视为工作节点。
+func (r *Requirements) ports(roles []enum.NodeRole) []int {
+	seen := map[int]bool{}
+	var ports []int
+	for _, role := range effectiveRoles(roles) {
+		for _, port := range r.Ports[role] {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// tools returns the binaries a node with the given roles must provide.
+// tools 返回具有给定角色的节点必须提供的二进制文件。
+func (r *Requirements) tools(roles []enum.NodeRole) []string {
+	tools := append([]string(nil), r.Tools...)
+	for _, role := range effectiveRoles(roles) {
+		for _, tool := range r.RoleTools[role] {
+			if !slices.Contains(tools, tool) {
+				tools = append(tools, tool)
+			}
+		}
+	}
+	return tools
+}
+
+// resources returns the minimum resources of a node with the given roles.
+// resources 返回具有给定角色的节点的最低资源。
+func (r *Requirements) resources(roles []enum.NodeRole) Resources {
+	var min Resources
+	for _, role := range effectiveRoles(roles) {
+		res := r.Resources[role]
+		min.CPUs = maxInt(min.CPUs, res.CPUs)
+		min.MemoryMiB = maxInt(min.MemoryMiB, res.MemoryMiB)
+		min.DiskGiB = maxInt(min.DiskGiB, res.DiskGiB)
+	}
+	return min
+}
+
+// effectiveRoles treats nodes without roles as workers.
+// effectiveRoles 将没有角色的节点视为工作节点。
+func effectiveRoles(roles []enum.NodeRole) []enum.NodeRole {
+	if len(roles) == 0 {
+		return []enum.NodeRole{enum.RoleWorker}
+	}
+	return roles
+}
+
+// maxInt returns the larger of a and b.
+// maxInt 返回 a 和 b 中较大的一个。
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Checker runs the preflight checks against nodes.
+// Checker 对节点运行预检。
+type Checker struct {
+	newExecutor  executor.Factory
+	requirements Requirements
+	now          func() time.Time
+	clusterNodes []model.NodeConfig
+}
+
+// NewChecker creates a Checker.
+// NewChecker 创建一个 Checker。
+// newExecutor: Factory used to connect to nodes; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
+// requirements: The requirements to check against, usually DefaultRequirements(). / 检查所依据的要求，通常为 DefaultRequirements()。
+func NewChecker(newExecutor executor.Factory, requirements Requirements) *Checker {
+	if newExecutor == nil {
+		newExecutor = executor.NewSSHExecutor
+	}
+	return &Checker{newExecutor: newExecutor, requirements: requirements, now: time.Now}
+}
+
+// WithClusterNodes sets the nodes already in the cluster. Run reads only their hostnames, which the checked nodes
+// must not reuse.
+// WithClusterNodes 设置已在集群中的节点。Run 仅读取它们的主机名，被检查的节点不得重复使用这些主机名。
+// nodes: The nodes of the cluster, without the nodes to check. / 集群的节点，不含要检查的节点。
+func (c *Checker) WithClusterNodes(nodes []model.NodeConfig) *Checker {
+	c.clusterNodes = nodes
+	return c
+}
+
+// Run checks all nodes, at most constants.DefaultMaxParallel at a time, and returns their results in node order.
+// Run 检查所有节点（最多同时检查 constants.DefaultMaxParallel 个），并按节点顺序返回结果。
+// Checks never modify a node. Hostnames are compared across the given nodes and the cluster nodes (see WithClusterNodes).
+// 检查从不修改节点。主机名在给定节点和集群节点（参见 WithClusterNodes）之间进行比较。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// nodes: The nodes to check. / 要检查的节点。
+func (c *Checker) Run(ctx context.Context, nodes []model.NodeConfig) *Report {
+	all := append(append([]model.NodeConfig(nil), nodes...), c.clusterNodes...)
+	nodeResults := make([][]Result, len(all))
+	hostnames := make([]string, len(all))
+	reachable := make([]bool, len(all))
+	sem := make(chan struct{}, constants.DefaultMaxParallel)
+	var wg sync.WaitGroup
+	for i := range all {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if i < len(nodes) {
+				nodeResults[i], hostnames[i], reachable[i] = c.checkNode(ctx, &all[i])
+			} else {
+				hostnames[i], reachable[i] = c.readHostname(ctx, &all[i])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	report := NewReport()
+	for i := range nodes {
+		for _, result := range nodeResults[i] {
+			report.Add(result)
+		}
+		if reachable[i] {
+			report.Add(hostnameResult(all, hostnames, reachable, i))
+		}
+	}
+	for i := len(nodes); i < len(all); i++ {
+		if !reachable[i] {
+			report.Add(Result{Node: all[i].Address, Check: CheckHostname, Status: StatusWarn,
+				Message: "cannot read the hostname of this cluster node, so new nodes are not compared with it"})
+		}
+	}
+	return report
+}
+
+// readHostname reads the hostname of a cluster node, reporting whether it could be read.
+// readHostname 读取集群节点的主机名，并报告是否能读取。
+func (c *Checker) readHostname(ctx context.Context, nodeCfg *model.NodeConfig) (string, bool) {
+	exec, err := c.newExecutor(ctx, nodeCfg)
+	if err != nil {
+		return "", false
+	}
+	defer exec.Close()
+	hostname, err := executor.RunCommand(ctx, exec, "hostname")
+	return hostname, err == nil && hostname != ""
+}
+
+// checkNode runs the checks of a single node and returns its results, its hostname and whether the hostname could be read.
+// checkNode 运行单个节点的检查，并返回其结果、主机名以及是否能读取主机名。
+func (c *Checker) checkNode(ctx context.Context, nodeCfg *model.NodeConfig) ([]Result, string, bool) {
+	nc := &nodeChecks{node: nodeCfg.Address}
+
+	port := nodeCfg.Port
+	if port == 0 {
+		port = constants.DefaultSSHPort
+	}
+	if open, _ := utils.IsPortOpen(nodeCfg.Address, port, c.requirements.SSHTimeout); !open {
+		nc.add(CheckSSH, StatusFail, fmt.Sprintf("port %d is not reachable", port))
+		return nc.results, "", false
+	}
+	exec, err := c.newExecutor(ctx, nodeCfg)
+	if err != nil {
+		nc.add(CheckSSH, StatusFail, fmt.Sprintf("cannot log in: %v", err))
+		return nc.results, "", false
+	}
+	defer exec.Close()
+	nc.exec = exec
+	nc.add(CheckSSH, StatusPass, fmt.Sprintf("reachable on port %d", port))
+
+	hostname, ok, err := nc.run(ctx, "hostname")
+	hostnameRead := err == nil && ok
+	if !hostnameRead {
+		nc.add(CheckHostname, StatusFail, "cannot read the hostname")
+	}
+
+	c.checkOS(ctx, nc)
+	c.checkKernel(ctx, nc)
+	c.checkTools(ctx, nc, c.requirements.tools(nodeCfg.Roles))
+	c.checkSwap(ctx, nc)
+	c.checkKernelModules(ctx, nc)
+	c.checkPorts(ctx, nc, c.requirements.ports(nodeCfg.Roles))
+	c.checkResources(ctx, nc, c.requirements.resources(nodeCfg.Roles))
+	c.checkTimeDrift(ctx, nc)
+	c.checkCgroup(ctx, nc)
+	return nc.results, hostname, hostnameRead
+}
+
+// nodeChecks collects the results of one node.
+// nodeChecks 收集一个节点的结果。
+type nodeChecks struct {
+	node    string
+	exec    executor.NodeExecutor
+	results []Result
+}
+
+// add records the result of a check on the node.
+// add 记录节点上一项检查的结果。
+func (nc *nodeChecks) add(check string, status Status, message string) {
+	nc.results = append(nc.results, Result{Node: nc.node, Check: check, Status: status, Message: message})
+}
+
+// run runs a read-only command and returns its trimmed output and whether it succeeded.
+// run 运行只读命令，并返回去除首尾空白的输出以及是否成功。
+func (nc *nodeChecks) run(ctx context.Context, cmd string, opts ...executor.RunOption) (string, bool, error) {
+	result, err := nc.exec.Run(ctx, cmd, opts...)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(result.Stdout), result.Success(), nil
+}
+
+// checkOS warns when the distribution of the node is not one of the tested distributions.
+// checkOS 在节点的发行版不属于已测试的发行版时发出警告。
+func (c *Checker) checkOS(ctx context.Context, nc *nodeChecks) {
+	out, ok, err := nc.run(ctx, "cat /etc/os-release")
+	if err != nil || !ok {
+		nc.add(CheckOS, StatusWarn, "cannot read /etc/os-release")
+		return
+	}
+	release := executor.ParseOSRelease(out)
+	name := release["PRETTY_NAME"]
+	if name == "" {
+		name = strings.TrimSpace(release["ID"] + " " + release["VERSION_ID"])
+	}
+	for _, distro := range c.requirements.SupportedDistros {
+		if strings.EqualFold(release["ID"], distro) {
+			nc.add(CheckOS, StatusPass, name)
+			return
+		}
+	}
+	nc.add(CheckOS, StatusWarn, fmt.Sprintf("%s is not a tested distribution (tested: %s)", name, strings.Join(c.requirements.SupportedDistros, ", ")))
+}
+
+// checkKernel fails when the kernel of the node is older than the required version.
+// checkKernel 在节点内核早于要求的版本时失败。
+func (c *Checker) checkKernel(ctx context.Context, nc *nodeChecks) {
+	out, ok, err := nc.run(ctx, "uname -r")
+	if err != nil || !ok {
+		nc.add(CheckKernel, StatusFail, "cannot read the kernel version")
+		return
+	}
+	if compareVersions(out, c.requirements.MinKernel) < 0 {
+		nc.add(CheckKernel, StatusFail, fmt.Sprintf("kernel %s is older than the required %s", out, c.requirements.MinKernel))
+		return
+	}
+	nc.add(CheckKernel, StatusPass, out)
+}
+
+// checkTools fails when one of the tools the roles of the node need is not on its PATH.
+// checkTools 在节点角色所需的某个工具不在其 PATH 中时失败。
+func (c *Checker) checkTools(ctx context.Context, nc *nodeChecks, tools []string) {
+	var missing []string
+	for _, tool := range tools {
+		_, ok, err := nc.run(ctx, "command -v "+executor.ShellQuote(tool))
+		if err != nil || !ok {
+			missing = append(missing, tool)
+		}
+	}
+	if len(missing) > 0 {
+		nc.add(CheckTools, StatusFail, "missing required tools: "+strings.Join(missing, ", "))
+		return
+	}
+	nc.add(CheckTools, StatusPass, strings.Join(tools, ", "))
+}
+
+// checkSwap fails when swap is enabled, which the kubelet refuses by default.
+// checkSwap 在启用了 swap 时失败，kubelet 默认拒绝在此情况下运行。
+func (c *Checker) checkSwap(ctx context.Context, nc *nodeChecks) {
+	out, ok, err := nc.run(ctx, "cat /proc/swaps")
+	if err != nil || !ok {
+		nc.add(CheckSwap, StatusFail, "cannot read /proc/swaps")
+		return
+	}
+	// The first line of /proc/swaps is a header.
+	// /proc/swaps 的第一行是标题。
+	lines := strings.Split(out, "\n")
+	if len(lines) > 1 {
+		var devices []string
+		for _, line := range lines[1:] {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				devices = append(devices, fields[0])
+			}
+		}
+		nc.add(CheckSwap, StatusFail, fmt.Sprintf("swap is enabled on %s; disable it with swapoff -a and remove it from /etc/fstab", strings.Join(devices, ", ")))
+		return
+	}
+	nc.add(CheckSwap, StatusPass, "disabled")
+}
+
+// checkKernelModules fails when a required kernel module is not available, and warns when it is available but not loaded.
+// checkKernelModules 在所需内核模块不可用时失败，在模块可用但未加载时发出警告。
+func (c *Checker) checkKernelModules(ctx context.Context, nc *nodeChecks) {
+	var missing, unloaded []string
+	for _, module := range c.requirements.KernelModules {
+		quoted := executor.ShellQuote(module)
+		if _, ok, err := nc.run(ctx, "test -d /sys/module/"+quoted); err == nil && ok {
+			continue
+		}
+		if _, ok, err := nc.run(ctx, "modinfo -n "+quoted); err == nil && ok {
+			unloaded = append(unloaded, module)
+			continue
+		}
+		missing = append(missing, module)
+	}
+	switch {
+	case len(missing) > 0:
+		nc.add(CheckKernelModules, StatusFail, "not available: "+strings.Join(missing, ", "))
+	case len(unloaded) > 0:
+		nc.add(CheckKernelModules, StatusWarn, fmt.Sprintf("available but not loaded: %s; load them with modprobe and list them in /etc/modules-load.d", strings.Join(unloaded, ", ")))
+	default:
+		nc.add(CheckKernelModules, StatusPass, "loaded: "+strings.Join(c.requirements.KernelModules, ", "))
+	}
+}
+
+// checkPorts fails when a port the roles of the node need is already in use.
+// checkPorts 在节点角色所需的端口已被占用时失败。
+// A node that has already joined the cluster listens on these ports itself, so it is skipped.
+// 已加入集群的节点自身就在监听这些端口，因此会被跳过。
+func (c *Checker) checkPorts(ctx context.Context, nc *nodeChecks, ports []int) {
+	if len(ports) == 0 {
+		nc.add(CheckPorts, StatusSkip, "no ports required for the node's roles")
+		return
+	}
+	if _, joined, err := nc.run(ctx, "test -f "+kubeletConfigPath, executor.WithSudo()); err == nil && joined {
+		nc.add(CheckPorts, StatusSkip, "node has already joined the cluster")
+		return
+	}
+	out, ok, err := nc.run(ctx, "ss -Htln")
+	if err != nil || !ok {
+		nc.add(CheckPorts, StatusWarn, "cannot list listening ports (is ss installed?)")
+		return
+	}
+	listening := listeningPorts(out)
+	var busy []string
+	for _, port := range ports {
+		if listening[port] {
+			busy = append(busy, strconv.Itoa(port))
+		}
+	}
+	if len(busy) > 0 {
+		nc.add(CheckPorts, StatusFail, "already in use: "+strings.Join(busy, ", "))
+		return
+	}
+	nc.add(CheckPorts, StatusPass, "free: "+joinInts(ports))
+}
+
+// checkResources fails when the CPUs, memory or free disk space of the node are below the minimum of its roles.
+// checkResources 在节点的 CPU、内存或可用磁盘空间低于其角色的最低要求时失败。
+func (c *Checker) checkResources(ctx context.Context, nc *nodeChecks, min Resources) {
+	if out, ok, err := nc.run(ctx, "nproc"); err != nil || !ok {
+		nc.add(CheckCPU, StatusFail, "cannot read the number of CPUs")
+	} else if cpus, _ := strconv.Atoi(out); cpus < min.CPUs {
+		nc.add(CheckCPU, StatusFail, fmt.Sprintf("%d CPUs, at least %d required", cpus, min.CPUs))
+	} else {
+		nc.add(CheckCPU, StatusPass, fmt.Sprintf("%d CPUs", cpus))
+	}
+
+	if out, ok, err := nc.run(ctx, "awk '/^MemTotal:/ {print $2}' /proc/meminfo"); err != nil || !ok {
+		nc.add(CheckMemory, StatusFail, "cannot read the total memory")
+	} else if kib, _ := strconv.Atoi(out); kib/1024 < min.MemoryMiB {
+		nc.add(CheckMemory, StatusFail, fmt.Sprintf("%d MiB, at least %d MiB required", kib/1024, min.MemoryMiB))
+	} else {
+		nc.add(CheckMemory, StatusPass, fmt.Sprintf("%d MiB", kib/1024))
+	}
+
+	dataPath := executor.ShellQuote(c.requirements.DataPath)
+	if out, ok, err := nc.run(ctx, fmt.Sprintf("df -Pk %s | awk 'NR == 2 {print $4}'", dataPath)); err != nil || !ok {
+		nc.add(CheckDisk, StatusFail, "cannot read the free space of "+c.requirements.DataPath)
+	} else if kib, _ := strconv.Atoi(out); kib/(1024*1024) < min.DiskGiB {
+		nc.add(CheckDisk, StatusFail, fmt.Sprintf("%d GiB free on %s, at least %d GiB required", kib/(1024*1024), c.requirements.DataPath, min.DiskGiB))
+	} else {
+		nc.add(CheckDisk, StatusPass, fmt.Sprintf("%d GiB free on %s", kib/(1024*1024), c.requirements.DataPath))
+	}
+}
+
+// checkTimeDrift fails when the node clock differs from the local clock by more than MaxClockDrift.
+// checkTimeDrift 在节点时钟与本地时钟相差超过 MaxClockDrift 时失败。
+func (c *Checker) checkTimeDrift(ctx context.Context, nc *nodeChecks) {
+	// Compare the node clock with the midpoint of the round trip to cancel out the latency.
+	// 将节点时钟与往返的中点进行比较，以抵消延迟。
+	before := c.now()
+	out, ok, err := nc.run(ctx, "date +%s%N")
+	after := c.now()
+	nanos, parseErr := strconv.ParseInt(out, 10, 64)
+	if err != nil || !ok || parseErr != nil {
+		nc.add(CheckTimeDrift, StatusWarn, "cannot read the node clock")
+		return
+	}
+	local := before.Add(after.Sub(before) / 2)
+	drift := time.Unix(0, nanos).Sub(local)
+	if drift < 0 {
+		drift = -drift
+	}
+	drift = drift.Round(time.Millisecond)
+	if drift > c.requirements.MaxClockDrift {
+		nc.add(CheckTimeDrift, StatusFail, fmt.Sprintf("clock differs by %s (max %s); enable NTP (chrony or systemd-timesyncd)", drift, c.requirements.MaxClockDrift))
+		return
+	}
+	nc.add(CheckTimeDrift, StatusPass, fmt.Sprintf("clock differs by %s", drift))
+}
+
+// checkCgroup warns when the node does not use cgroup v2.
+// checkCgroup 在节点未使用 cgroup v2 时发出警告。
+func (c *Checker) checkCgroup(ctx context.Context, nc *nodeChecks) {
+	out, ok, err := nc.run(ctx, "stat -fc %T /sys/fs/cgroup")
+	switch {
+	case err != nil || !ok:
+		nc.add(CheckCgroup, StatusWarn, "cannot detect the cgroup version")
+	case out == "cgroup2fs":
+		nc.add(CheckCgroup, StatusPass, "v2")
+	case out == "tmpfs":
+		nc.add(CheckCgroup, StatusWarn, "v1; cgroup v2 is recommended and required by recent Kubernetes releases")
+	default:
+		nc.add(CheckCgroup, StatusWarn, fmt.Sprintf("unknown cgroup file system %q", out))
+	}
+}
+
+// dnsLabelPattern matches names Kubernetes accepts as node names (RFC 1123 subdomains).
+// dnsLabelPattern 匹配 Kubernetes 接受为节点名称的名称（RFC 1123 子域）。
+var dnsLabelPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// hostnameResult checks that the hostname of node i is a valid node name and not used by another node.
+// hostnameResult 检查节点 i 的主机名是否为有效的节点名称且未被其他节点使用。
+func hostnameResult(nodes []model.NodeConfig, hostnames []string, reachable []bool, i int) Result {
+	result := Result{Node: nodes[i].Address, Check: CheckHostname, Status: StatusPass, Message: hostnames[i]}
+	if !dnsLabelPattern.MatchString(hostnames[i]) || len(hostnames[i]) > 253 {
+		result.Status = StatusFail
+		result.Message = fmt.Sprintf("%q is not a valid Kubernetes node name (lowercase RFC 1123)", hostnames[i])
+		return result
+	}
+	var others []string
+	for j := range nodes {
+		if j != i && reachable[j] && hostnames[j] == hostnames[i] {
+			others = append(others, nodes[j].Address)
+		}
+	}
+	if len(others) > 0 {
+		result.Status = StatusFail
+		result.Message = fmt.Sprintf("%s is also the hostname of %s", hostnames[i], strings.Join(others, ", "))
+	}
+	return result
+}
+
+// compareVersions compares the leading numeric components of two versions, e.g. "5.15.0-91-generic" and "4.19".
+// compareVersions 比较两个版本的前导数字部分，例如 "5.15.0-91-generic" 和 "4.19"。
+// Returns -1, 0 or 1.
+// 返回 -1、0 或 1。
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionParts returns the dot-separated numbers at the start of a version string.
+// versionParts 返回版本字符串开头以点分隔的数字。
+func versionParts(version string) []int {
+	var parts []int
+	for _, field := range strings.Split(version, ".") {
+		end := 0
+		for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		n, _ := strconv.Atoi(field[:end])
+		parts = append(parts, n)
+		if end < len(field) {
+			break
+		}
+	}
+	return parts
+}
+
+// listeningPorts parses the local ports of `ss -Htln` output.
+// listeningPorts 解析 `ss -Htln` 输出中的本地端口。
+func listeningPorts(out string) map[int]bool {
+	ports := map[int]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		local := fields[3]
+		if idx := strings.LastIndex(local, ":"); idx >= 0 {
+			if port, err := strconv.Atoi(local[idx+1:]); err == nil {
+				ports[port] = true
+			}
+		}
+	}
+	return ports
+}
+
+// joinInts joins numbers with commas for messages.
+// joinInts 用逗号连接数字以用于消息。
+func joinInts(values []int) string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = strconv.Itoa(v)
+	}
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,191 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chasierrors "github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor/sshtest"
+)
+
+// newHealthyNode starts an sshtest server that answers like a node meeting the master requirements.
+func newHealthyNode(t *testing.T, hostname string) (*sshtest.Server, model.NodeConfig) {
+	t.Helper()
+	utils.InitLogger("info", 0)
+	t.Setenv("HOME", t.TempDir()) // Do not pick up the developer's known_hosts
+	server, err := sshtest.NewServer("root", "secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	server.HandleOutput("hostname", hostname+"\n", 0)
+	server.HandleOutput("cat /etc/os-release", "NAME=\"Ubuntu\"\nID=ubuntu\nVERSION_ID=\"22.04\"\nPRETTY_NAME=\"Ubuntu 22.04.4 LTS\"\n", 0)
+	server.HandleOutput("uname -r", "5.15.0-91-generic\n", 0)
+	server.HandleOutput("cat /proc/swaps", "Filename\tType\tSize\tUsed\tPriority\n", 0)
+	server.HandleOutput("test -f /etc/kubernetes/kubelet.conf", "", 1)
+	server.HandleOutput("ss -Htln", "LISTEN 0 4096 0.0.0.0:22 0.0.0.0:*\nLISTEN 0 4096 [::]:22 [::]:*\n", 0)
+	server.HandleOutput("nproc", "4\n", 0)
+	server.HandleOutput("/proc/meminfo", "8039652\n", 0)
+	server.HandleOutput("df -Pk", "52428800\n", 0)
+	server.Handle("date +%s%N", func(_ string, _ []byte, stdout, _ io.Writer) int {
+		io.WriteString(stdout, strconv.FormatInt(time.Now().UnixNano(), 10)+"\n")
+		return 0
+	})
+	server.HandleOutput("stat -fc %T /sys/fs/cgroup", "cgroup2fs\n", 0)
+
+	nodeCfg := server.NodeConfig()
+	nodeCfg.Roles = []enum.NodeRole{enum.RoleMaster}
+	return server, nodeCfg
+}
+
+// statuses maps the checks of a node to their status.
+func statuses(report *Report, node string) map[string]Status {
+	out := map[string]Status{}
+	for _, result := range report.Results() {
+		if result.Node == node {
+			out[result.Check] = result.Status
+		}
+	}
+	return out
+}
+
+func TestChecker_HealthyNodePasses(t *testing.T) {
+	server, nodeCfg := newHealthyNode(t, "master-1")
+
+	report := NewChecker(nil, DefaultRequirements()).Run(context.Background(), []model.NodeConfig{nodeCfg})
+	require.NoError(t, report.Err())
+	got := statuses(report, nodeCfg.Address)
+	for _, check := range []string{CheckSSH, CheckOS, CheckKernel, CheckTools, CheckSwap, CheckKernelModules, CheckPorts, CheckCPU, CheckMemory, CheckDisk, CheckTimeDrift, CheckCgroup, CheckHostname} {
+		assert.Equal(t, StatusPass, got[check], check)
+	}
+	for _, cmd := range server.Commands() {
+		assert.NotContains(t, cmd, "mkdir", "preflight checks are read-only")
+	}
+}
+
+func TestChecker_UnfitNodeFails(t *testing.T) {
+	server, nodeCfg := newHealthyNode(t, "master-1")
+	server.HandleOutput("uname -r", "4.15.0-20-generic\n", 0)
+	server.HandleOutput("cat /proc/swaps", "Filename\tType\tSize\tUsed\tPriority\n/swap.img file 2097148 0 -2\n", 0)
+	server.HandleOutput("test -d /sys/module/br_netfilter", "", 1)
+	server.HandleOutput("ss -Htln", "LISTEN 0 4096 *:6443 *:*\nLISTEN 0 4096 127.0.0.1:2379 0.0.0.0:*\n", 0)
+	server.HandleOutput("nproc", "1\n", 0)
+	server.Handle("date +%s%N", func(_ string, _ []byte, stdout, _ io.Writer) int {
+		io.WriteString(stdout, strconv.FormatInt(time.Now().Add(-time.Minute).UnixNano(), 10)+"\n")
+		return 0
+	})
+	server.HandleOutput("stat -fc %T /sys/fs/cgroup", "tmpfs\n", 0)
+
+	report := NewChecker(nil, DefaultRequirements()).Run(context.Background(), []model.NodeConfig{nodeCfg})
+	got := statuses(report, nodeCfg.Address)
+	assert.Equal(t, StatusFail, got[CheckKernel])
+	assert.Equal(t, StatusFail, got[CheckSwap])
+	assert.Equal(t, StatusWarn, got[CheckKernelModules], "br_netfilter is available but not loaded")
+	assert.Equal(t, StatusFail, got[CheckPorts])
+	assert.Equal(t, StatusFail, got[CheckCPU])
+	assert.Equal(t, StatusFail, got[CheckTimeDrift])
+	assert.Equal(t, StatusWarn, got[CheckCgroup])
+	assert.Equal(t, StatusPass, got[CheckMemory])
+
+	err := report.Err()
+	require.Error(t, err)
+	assert.True(t, chasierrors.IsChasiBodError(err, chasierrors.ErrTypeValidation))
+	assert.Contains(t, err.Error(), "already in use: 2379, 6443")
+	assert.Contains(t, err.Error(), "swap is enabled on /swap.img")
+}
+
+func TestChecker_JoinedNodeSkipsPortCheck(t *testing.T) {
+	server, nodeCfg := newHealthyNode(t, "master-1")
+	server.HandleOutput("test -f /etc/kubernetes/kubelet.conf", "", 0)
+	server.HandleOutput("ss -Htln", "LISTEN 0 4096 *:6443 *:*\n", 0)
+
+	report := NewChecker(nil, DefaultRequirements()).Run(context.Background(), []model.NodeConfig{nodeCfg})
+	require.NoError(t, report.Err(), "re-checking a deployed node must not block a re-deploy")
+	assert.Equal(t, StatusSkip, statuses(report, nodeCfg.Address)[CheckPorts])
+}
+
+func TestChecker_DuplicateHostnamesAndUnreachableNodes(t *testing.T) {
+	_, nodeCfg := newHealthyNode(t, "node-1")
+	twin := nodeCfg
+	twin.Address = "localhost" // Same server, so the same hostname / 同一服务器，因此主机名相同
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+	unreachable := model.NodeConfig{Address: "127.0.0.1", Port: closedPort, User: "root", Password: "secret"}
+
+	report := NewChecker(nil, DefaultRequirements()).Run(context.Background(), []model.NodeConfig{nodeCfg, twin, unreachable})
+	results := report.Results()
+	assert.Equal(t, nodeCfg.Address, results[0].Node, "results are in node order")
+	assert.Equal(t, StatusFail, statuses(report, nodeCfg.Address)[CheckHostname])
+	assert.Equal(t, StatusFail, statuses(report, "localhost")[CheckHostname])
+	assert.Contains(t, report.Err().Error(), "node-1 is also the hostname of localhost")
+
+	last := results[len(results)-1]
+	assert.Equal(t, Result{Node: "127.0.0.1", Check: CheckSSH, Status: StatusFail, Message: "port " + strconv.Itoa(closedPort) + " is not reachable"}, last)
+}
+
+func TestChecker_ClusterNodeHostnames(t *testing.T) {
+	_, nodeCfg := newHealthyNode(t, "node-1")
+	memberServer, member := newHealthyNode(t, "node-1")
+	member.Address = "localhost"
+	unreachable := model.NodeConfig{Address: "127.0.0.2", Port: 1, User: "root", Password: "secret"}
+
+	report := NewChecker(nil, DefaultRequirements()).WithClusterNodes([]model.NodeConfig{member, unreachable}).Run(context.Background(), []model.NodeConfig{nodeCfg})
+	assert.Equal(t, StatusFail, statuses(report, nodeCfg.Address)[CheckHostname])
+	assert.Contains(t, report.Err().Error(), "node-1 is also the hostname of localhost")
+	assert.Equal(t, map[string]Status{CheckHostname: StatusWarn}, statuses(report, "127.0.0.2"), "an unreachable cluster node is not checked")
+	assert.Empty(t, statuses(report, "localhost"), "cluster nodes are not checked themselves")
+	assert.Equal(t, []string{"hostname"}, memberServer.Commands(), "only the hostname of a cluster node is read")
+
+	memberServer.HandleOutput("hostname", "node-2\n", 0)
+	report = NewChecker(nil, DefaultRequirements()).WithClusterNodes([]model.NodeConfig{member}).Run(context.Background(), []model.NodeConfig{nodeCfg})
+	assert.NoError(t, report.Err())
+}
+
+func TestHostnameResult_RejectsInvalidNodeNames(t *testing.T) {
+	nodes := []model.NodeConfig{{Address: "10.0.0.1"}}
+	assert.Equal(t, StatusFail, hostnameResult(nodes, []string{"Node_1"}, []bool{true}, 0).Status)
+	assert.Equal(t, StatusPass, hostnameResult(nodes, []string{"node-1.example.com"}, []bool{true}, 0).Status)
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 1, compareVersions("5.15.0-91-generic", "4.19"))
+	assert.Equal(t, 0, compareVersions("4.19.0", "4.19"))
+	assert.Equal(t, -1, compareVersions("4.18.0-553.el8_10.x86_64", "4.19"))
+	assert.Equal(t, -1, compareVersions("3.10.0-1160.el7.x86_64", "4.19"))
+}
+
+func TestReport_Render(t *testing.T) {
+	report := NewReport()
+	report.Add(Result{Node: "10.0.0.1", Check: CheckSwap, Status: StatusPass, Message: "disabled"})
+	report.Add(Result{Node: "10.0.0.1", Check: CheckCgroup, Status: StatusWarn, Message: "v1"})
+
+	table, err := report.Render("table")
+	require.NoError(t, err)
+	assert.Equal(t, "NODE      CHECK   STATUS  MESSAGE\n10.0.0.1  swap    PASS    disabled\n10.0.0.1  cgroup  WARN    v1\n", string(table))
+
+	out, err := report.Render("json")
+	require.NoError(t, err)
+	var decoded struct {
+		Passed  bool     `json:"passed"`
+		Results []Result `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.True(t, decoded.Passed, "warnings do not fail the report")
+	assert.Equal(t, report.Results(), decoded.Results)
+
+	_, err = report.Render("xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+// Package preflight checks that target nodes meet the requirements of the platform before anything is changed on them.
+// 包 preflight 在对目标节点进行任何更改之前检查其是否满足平台的要求。
+package preflight
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+)
+
+// Status is the outcome of a single check.
+// Status 是单个检查的结果。
+type Status string
+
+const (
+	// StatusPass means the node meets the requirement.
+	// StatusPass 表示节点满足要求。
+	StatusPass Status = "pass"
+	// StatusWarn means the node works but deviates from the recommendation. Warnings do not block a deployment.
+	// StatusWarn 表示节点可以工作但偏离了建议。警告不会阻止部署。
+	StatusWarn Status = "warn"
+	// StatusFail means the node does not meet the requirement. Failures block a deployment.
+	// StatusFail 表示节点不满足要求。失败会阻止部署。
+	StatusFail Status = "fail"
+	// StatusSkip means the check does not apply to the node (e.g. free ports on a node that already runs Kubernetes).
+	// StatusSkip 表示检查不适用于该节点（例如，已运行 Kubernetes 的节点上的空闲端口检查）。
+	StatusSkip Status = "skip"
+)
+
+// Result is the outcome of one check on one node.
+// Result 是一个节点上一个检查的结果。
+type Result struct {
+	Node    string `json:"node"`
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+}
+
+// Report collects the results of a preflight run. It is safe for concurrent use.
+// Report 收集一次预检运行的结果。它可以安全地并发使用。
+type Report struct {
+	mu      sync.Mutex
+	results []Result
+}
+
+// NewReport creates an empty report.
+// NewReport 创建一个空报告。
+func NewReport() *Report {
+	return &Report{}
+}
+
+// Add appends a result.
+// Add 追加一个结果。
+func (r *Report) Add(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+// Merge appends all results of another report.
+// Merge 追加另一个报告的所有结果。
+func (r *Report) Merge(other *Report) {
+	results := other.Results()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, results...)
+}
+
+// Results returns a copy of the results in the order they were recorded.
+// Results 按记录顺序返回结果的副本。
+func (r *Report) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Result(nil), r.results...)
+}
+
+// Failures returns the blocking results.
+// Failures 返回阻止部署的结果。
+func (r *Report) Failures() []Result {
+	var failures []Result
+	for _, result := range r.Results() {
+		if result.Status == StatusFail {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// Passed reports whether no check failed. Warnings and skipped checks do not count as failures.
+// Passed 报告是否没有检查失败。警告和跳过的检查不算作失败。
+func (r *Report) Passed() bool {
+	return len(r.Failures()) == 0
+}
+
+// Err returns a validation error listing the failed checks, or nil if the report passed.
+// Err 返回列出失败检查的校验错误，如果报告通过则返回 nil。
+func (r *Report) Err() error {
+	failures := r.Failures()
+	if len(failures) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(failures))
+	for i, failure := range failures {
+		msgs[i] = fmt.Sprintf("%s %s: %s", failure.Node, failure.Check, failure.Message)
+	}
+	return errors.New(errors.ErrTypeValidation, fmt.Sprintf("preflight checks failed: %s", strings.Join(msgs, "; ")))
+}
+
+// WriteTable writes the results as an aligned table.
+// WriteTable 将结果写为对齐的表格。
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tCHECK\tSTATUS\tMESSAGE")
+	for _, result := range r.Results() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Node, result.Check, strings.ToUpper(string(result.Status)), result.Message)
+	}
+	return tw.Flush()
+}
+
+// jsonReport is the machine-readable form of a report.
+// jsonReport 是报告的机器可读形式。
+type jsonReport struct {
+	Passed  bool     `json:"passed"`
+	Results []Result `json:"results"`
+}
+
+// Render renders the report as "table" (the default) or "json".
+// Render 将报告渲染为 "table"（默认）或 "json"。
+func (r *Report) Render(format string) ([]byte, error) {
+	switch format {
+	case "", "table":
+		var buf bytes.Buffer
+		if err := r.WriteTable(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "json":
+		results := r.Results()
+		if results == nil {
+			results = []Result{}
+		}
+		out, err := json.MarshalIndent(jsonReport{Passed: r.Passed(), Results: results}, "", "  ")
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeInternal, "failed to render preflight report as JSON", err)
+		}
+		return append(out, '\n'), nil
+	default:
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported preflight output format %q (use table or json)", format))
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/turtacn/chasi-bod/common/errors"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger is here // 假设日志记录器在这里
 	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/phases"
 	"k8s.io/client-go/kubernetes"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -373,6 +374,22 @@ func (m *defaultManager) RunClusterChecks(ctx context.Context, hostK8sClient kub
 	// TODO: Add checks for DaemonSets similarly
 	utils.GetLogger().Println("Placeholder: Core Host Cluster deployments checked.")
 
+	// Step 3b: Check that the declared storage exists and matches the configuration
+	// 步骤 3b：检查声明的存储是否存在并与配置一致
+	if config != nil && phases.HasStorage(&config.Cluster.Storage) {
+		utils.GetLogger().Println("Checking Host Cluster storage...")
+		if hostK8sClient == nil {
+			clusterCheckErrors = append(clusterCheckErrors, fmt.Errorf("cannot check storage without a Host Cluster client"))
+		} else if drift, err := phases.StorageDrift(ctx, hostK8sClient, &config.Cluster); err != nil {
+			clusterCheckErrors = append(clusterCheckErrors, fmt.Errorf("failed to check storage: %w", err))
+		} else {
+			for _, item := range drift {
+				utils.GetLogger().Printf("Storage drift: %s", item)
+				clusterCheckErrors = append(clusterCheckErrors, fmt.Errorf("storage drift: %s", item))
+			}
+		}
+	}
+
 	// Step 4: Check vcluster health
 	// 步骤 4：检查 vcluster 健康状况
 	if len(config.VClusters) > 0 {
@@ -0,0 +1,340 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer"
+	"github.com/turtacn/chasi-bod/pkg/deployer/phases"
+)
+
+const (
+	// controlPlaneLabel marks control plane nodes in clusters set up by kubeadm.
+	// controlPlaneLabel 标记由 kubeadm 搭建的集群中的控制平面节点。
+	controlPlaneLabel = "node-role.kubernetes.io/control-plane"
+	// legacyMasterLabel is the control plane label of kubeadm releases before v1.24.
+	// legacyMasterLabel 是 v1.24 之前 kubeadm 版本的控制平面标签。
+	legacyMasterLabel = "node-role.kubernetes.io/master"
+)
+
+// NodeAction is what an apply does to one node.
+// NodeAction 是 apply 对某个节点执行的操作。
+type NodeAction string
+
+const (
+	// NodeActionAdd joins a node that is declared but not part of the cluster.
+	// NodeActionAdd 加入已声明但不属于集群的节点。
+	NodeActionAdd NodeAction = "add"
+	// NodeActionRemove drains and removes a recorded node that is no longer declared.
+	// NodeActionRemove 排空并移除已记录但不再声明的节点。
+	NodeActionRemove NodeAction = "remove"
+	// NodeActionUpdate brings the labels, annotations and taints of a node in line with the configuration.
+	// NodeActionUpdate 使节点的标签、注解和污点与配置保持一致。
+	NodeActionUpdate NodeAction = "update"
+	// NodeActionUnchanged means the node already matches the configuration.
+	// NodeActionUnchanged 表示节点已与配置一致。
+	NodeActionUnchanged NodeAction = "unchanged"
+	// NodeActionUnmanaged is a cluster node chasi-bod neither declares nor recorded; it is left untouched.
+	// NodeActionUnmanaged 是 chasi-bod 既未声明也未记录的集群节点；它保持不变。
+	NodeActionUnmanaged NodeAction = "unmanaged"
+)
+
+// NodeChange is the planned action for one node.
+// NodeChange 是某个节点的计划操作。
+type NodeChange struct {
+	Action   NodeAction       `json:"action"`             // What happens to the node / 节点上发生的操作
+	Address  string           `json:"address"`            // Node address from the configuration or the cluster / 来自配置或集群的节点地址
+	NodeName string           `json:"nodeName,omitempty"` // Name of the Kubernetes Node, if registered / Kubernetes Node 的名称（如果已注册）
+	Roles    []enum.NodeRole  `json:"roles"`              // Node roles / 节点角色
+	Details  []string         `json:"details,omitempty"`  // Why the action is needed / 需要该操作的原因
+	Config   model.NodeConfig `json:"-"`                  // Declared or recorded node configuration / 声明或记录的节点配置
+
+	live  bool // The node is registered in the cluster / 节点已在集群中注册
+	ready bool // The registered node is Ready / 已注册的节点处于 Ready 状态
+}
+
+// ScalePlan is the difference between the declared nodes and the Host Cluster.
+// ScalePlan 是声明的节点与 Host 集群之间的差异。
+type ScalePlan struct {
+	Changes       []NodeChange `json:"changes"`            // One entry per node / 每个节点一个条目
+	MastersBefore int          `json:"mastersBefore"`      // Control plane nodes registered in the cluster / 集群中注册的控制平面节点
+	MastersAfter  int          `json:"mastersAfter"`       // Control plane nodes after the apply / apply 之后的控制平面节点
+	Warnings      []string     `json:"warnings,omitempty"` // Non-blocking findings / 非阻塞的发现
+
+	// endpointMaster is the master other nodes joined through when no control plane endpoint is configured
+	// endpointMaster 是未配置控制平面端点时其他节点加入所通过的主节点
+	endpointMaster string
+}
+
+// ListHostNodes returns the Nodes registered in the Host Cluster.
+// ListHostNodes 返回 Host 集群中注册的 Node。
+// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// hostK8sClient: A Kubernetes client connected to the Host Cluster. / 连接到 Host 集群的 Kubernetes 客户端。
+func ListHostNodes(ctx context.Context, hostK8sClient kubernetes.Interface) ([]corev1.Node, error) {
+	list, err := hostK8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeSystem, "failed to list the Host Cluster nodes", err)
+	}
+	return list.Items, nil
+}
+
+// ComputeScalePlan works out which nodes an apply adds, removes or updates.
+// ComputeScalePlan 计算 apply 要添加、移除或更新哪些节点。
+// Nodes are matched by address against the Node names and addresses. A node is removed only if the inventory
+// recorded it, so nodes joined by other means are never touched.
+// 节点按地址与 Node 名称和地址进行匹配。只有清单中记录的节点才会被移除，因此通过其他方式加入的节点永远不会被触碰。
+// config: The desired platform configuration. / 期望的平台配置。
+// recorded: Nodes recorded by the last deploy or apply. / 上次部署或 apply 记录的节点。
+// liveNodes: Nodes registered in the Host Cluster. / Host 集群中注册的节点。
+// Returns an error if the configuration changes the role of an existing node.
+// 如果配置更改了现有节点的角色，则返回错误。
+func ComputeScalePlan(config *model.PlatformConfig, recorded []model.NodeConfig, liveNodes []corev1.Node) (*ScalePlan, error) {
+	p := &ScalePlan{}
+	matched := make(map[string]bool, len(liveNodes))
+
+	for _, node := range liveNodes {
+		if isControlPlaneNode(&node) {
+			p.MastersBefore++
+		}
+	}
+
+	for _, nodeCfg := range config.Cluster.Nodes {
+		change := NodeChange{Address: nodeCfg.Address, Roles: nodeCfg.Roles, Config: nodeCfg}
+		live := phases.MatchNode(nodeCfg.Address, liveNodes)
+		if live == nil {
+			change.Action = NodeActionAdd
+			if findRecordedNode(recorded, nodeCfg.Address) != nil {
+				change.Details = append(change.Details, "recorded but not registered in the cluster")
+			}
+			p.Changes = append(p.Changes, change)
+			continue
+		}
+		matched[live.Name] = true
+		change.NodeName, change.live, change.ready = live.Name, true, phases.NodeReady(live)
+		if isControlPlaneNode(live) != isMaster(&nodeCfg) {
+			return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("node %s (%s) is a %s in the cluster but a %s in the configuration; remove it and add it back to change its role",
+				nodeCfg.Address, live.Name, roleName(isControlPlaneNode(live)), roleName(isMaster(&nodeCfg))))
+		}
+		drift, err := deployer.NodeMetadataChanges(&nodeCfg, live)
+		if err != nil {
+			return nil, err
+		}
+		change.Action = NodeActionUnchanged
+		if len(drift) > 0 {
+			change.Action, change.Details = NodeActionUpdate, drift
+		}
+		p.Changes = append(p.Changes, change)
+	}
+
+	for _, nodeCfg := range recorded {
+		if findNodeConfig(config, nodeCfg.Address) != nil {
+			continue
+		}
+		change := NodeChange{Action: NodeActionRemove, Address: nodeCfg.Address, Roles: nodeCfg.Roles, Config: nodeCfg}
+		if live := phases.MatchNode(nodeCfg.Address, liveNodes); live != nil {
+			matched[live.Name] = true
+			change.NodeName, change.live, change.ready = live.Name, true, phases.NodeReady(live)
+		} else {
+			change.Details = append(change.Details, "not registered in the cluster; only the machine is cleaned up")
+		}
+		p.Changes = append(p.Changes, change)
+	}
+
+	for i := range liveNodes {
+		node := &liveNodes[i]
+		if matched[node.Name] {
+			continue
+		}
+		roles := []enum.NodeRole{enum.RoleWorker}
+		if isControlPlaneNode(node) {
+			roles = []enum.NodeRole{enum.RoleMaster}
+		}
+		p.Changes = append(p.Changes, NodeChange{Action: NodeActionUnmanaged, Address: nodeAddress(node), NodeName: node.Name, Roles: roles,
+			Details: []string{"not in the configuration or the inventory; left untouched"}, live: true, ready: phases.NodeReady(node)})
+	}
+
+	p.MastersAfter = p.MastersBefore
+	for _, change := range p.Changes {
+		switch {
+		case change.Action == NodeActionAdd && isMaster(&change.Config):
+			p.MastersAfter++
+		case change.Action == NodeActionRemove && change.live && isMaster(&change.Config):
+			p.MastersAfter--
+		}
+	}
+
+	// Without a control plane endpoint every node joined through the first master kubeadm initialized.
+	// 没有控制平面端点时，每个节点都通过 kubeadm 初始化的第一个主节点加入。
+	if config.Cluster.ControlPlaneEndpoint == nil {
+		for i := range recorded {
+			if isMaster(&recorded[i]) {
+				p.endpointMaster = recorded[i].Address
+				break
+			}
+		}
+	}
+	return p, nil
+}
+
+// NodesToAdd returns the nodes the apply joins to the cluster.
+// NodesToAdd 返回 apply 加入集群的节点。
+func (p *ScalePlan) NodesToAdd() []model.NodeConfig {
+	return p.nodes(NodeActionAdd)
+}
+
+// NodesToRemove returns the nodes the apply removes from the cluster.
+// NodesToRemove 返回 apply 从集群中移除的节点。
+func (p *ScalePlan) NodesToRemove() []model.NodeConfig {
+	return p.nodes(NodeActionRemove)
+}
+
+// HasChanges reports whether applying the plan changes anything.
+// HasChanges 报告应用该计划是否会更改任何内容。
+func (p *ScalePlan) HasChanges() bool {
+	for _, change := range p.Changes {
+		switch change.Action {
+		case NodeActionAdd, NodeActionRemove, NodeActionUpdate:
+			return true
+		}
+	}
+	return false
+}
+
+// nodes returns the configurations of the nodes with the given action.
+// nodes 返回具有给定操作的节点的配置。
+func (p *ScalePlan) nodes(action NodeAction) []model.NodeConfig {
+	var nodes []model.NodeConfig
+	for _, change := range p.Changes {
+		if change.Action == action {
+			nodes = append(nodes, change.Config)
+		}
+	}
+	return nodes
+}
+
+// WriteTable writes the plan as an aligned table followed by the master count and the warnings.
+// WriteTable 将计划写为对齐的表格，后跟主节点数量和警告。
+func (p *ScalePlan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tNODE\tNAME\tROLES\tDETAILS")
+	for _, change := range p.Changes {
+		roles := make([]string, len(change.Roles))
+		for i, role := range change.Roles {
+			roles[i] = string(role)
+		}
+		name := change.NodeName
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", strings.ToUpper(string(change.Action)), change.Address, name, strings.Join(roles, ","), strings.Join(change.Details, "; "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nMasters: %d -> %d\n", p.MastersBefore, p.MastersAfter)
+	for _, warning := range p.Warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+	return nil
+}
+
+// Render renders the plan as "table" (the default) or "json".
+// Render 将计划渲染为 "table"（默认）或 "json"。
+func (p *ScalePlan) Render(format string) ([]byte, error) {
+	switch format {
+	case "", "table":
+		var buf bytes.Buffer
+		if err := p.WriteTable(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "json":
+		out, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return nil, errors.NewWithCause(errors.ErrTypeInternal, "failed to render apply plan as JSON", err)
+		}
+		return append(out, '\n'), nil
+	default:
+		return nil, errors.New(errors.ErrTypeValidation, fmt.Sprintf("unsupported apply plan output format %q (use table or json)", format))
+	}
+}
+
+// reconcileNodeMetadata brings the labels, annotations and taints of every declared node in line with the configuration,
+// removing the ones chasi-bod managed before that are no longer declared.
+// reconcileNodeMetadata 使每个已声明节点的标签、注解和污点与配置保持一致，并移除 chasi-bod 之前管理但不再声明的项。
+// It lists the nodes again so that nodes joined by the apply are included.
+// 它会重新列出节点，以便包含 apply 加入的节点。
+func reconcileNodeMetadata(ctx context.Context, config *model.PlatformConfig, hostK8sClient kubernetes.Interface) error {
+	liveNodes, err := ListHostNodes(ctx, hostK8sClient)
+	if err != nil {
+		return err
+	}
+	for i := range config.Cluster.Nodes {
+		nodeCfg := &config.Cluster.Nodes[i]
+		live := phases.MatchNode(nodeCfg.Address, liveNodes)
+		if live == nil {
+			utils.GetLogger().Printf("Warning: Node %s is not registered in the cluster; skipping its labels and taints.", nodeCfg.Address)
+			continue
+		}
+		changes, err := deployer.ReconcileNodeMetadata(ctx, hostK8sClient, nodeCfg, live.Name)
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			utils.GetLogger().Printf("Updated node %s (%s): %s", nodeCfg.Address, live.Name, strings.Join(changes, ", "))
+		}
+	}
+	return nil
+}
+
+// findRecordedNode returns the recorded node with the given address, or nil.
+// findRecordedNode 返回具有给定地址的已记录节点，否则返回 nil。
+func findRecordedNode(recorded []model.NodeConfig, address string) *model.NodeConfig {
+	for i := range recorded {
+		if recorded[i].Address == address {
+			return &recorded[i]
+		}
+	}
+	return nil
+}
+
+// nodeAddress returns the internal IP of a Node, falling back to its name.
+// nodeAddress 返回 Node 的内部 IP，否则回退到其名称。
+func nodeAddress(node *corev1.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return node.Name
+}
+
+// isControlPlaneNode reports whether the Node carries a kubeadm control plane label.
+// isControlPlaneNode 报告 Node 是否带有 kubeadm 控制平面标签。
+func isControlPlaneNode(node *corev1.Node) bool {
+	_, controlPlane := node.Labels[controlPlaneLabel]
+	_, master := node.Labels[legacyMasterLabel]
+	return controlPlane || master
+}
+
+// roleName names the role of a node in messages.
+// roleName 在消息中命名节点的角色。
+func roleName(master bool) string {
+	if master {
+		return "master"
+	}
+	return "worker"
+}
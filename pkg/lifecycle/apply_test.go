@@ -0,0 +1,247 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/turtacn/chasi-bod/common/types/enum"
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer"
+)
+
+// liveNode returns a Ready Node registered under name with the given internal IP.
+// liveNode 返回以 name 注册、具有给定内部 IP 的 Ready Node。
+func liveNode(name, ip string, master bool) corev1.Node {
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+		Status: corev1.NodeStatus{
+			Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}, {Type: corev1.NodeHostName, Address: name}},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	if master {
+		node.Labels[controlPlaneLabel] = ""
+	}
+	return node
+}
+
+func notReady(node corev1.Node) corev1.Node {
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}}
+	return node
+}
+
+func testNode(address string, role enum.NodeRole) model.NodeConfig {
+	return model.NodeConfig{Address: address, User: "root", Roles: []enum.NodeRole{role}}
+}
+
+func testConfig(nodes ...model.NodeConfig) *model.PlatformConfig {
+	config := &model.PlatformConfig{}
+	config.Metadata.Name = "prod"
+	config.Cluster.Nodes = nodes
+	return config
+}
+
+// actions maps node addresses to their planned action.
+// actions 将节点地址映射到其计划操作。
+func actions(p *ScalePlan) map[string]NodeAction {
+	out := map[string]NodeAction{}
+	for _, change := range p.Changes {
+		out[change.Address] = change.Action
+	}
+	return out
+}
+
+func TestComputeScalePlan(t *testing.T) {
+	worker := testNode("10.0.0.3", enum.RoleWorker)
+	worker.Labels = map[string]string{"zone": "a"}
+	worker.Taints = []string{"dedicated=db:NoSchedule"}
+	config := testConfig(testNode("10.0.0.1", enum.RoleMaster), worker, testNode("10.0.0.4", enum.RoleWorker))
+	recorded := []model.NodeConfig{testNode("10.0.0.1", enum.RoleMaster), testNode("10.0.0.3", enum.RoleWorker), testNode("10.0.0.5", enum.RoleWorker), testNode("10.0.0.6", enum.RoleWorker)}
+	live := []corev1.Node{
+		liveNode("m1", "10.0.0.1", true),
+		liveNode("w3", "10.0.0.3", false),
+		liveNode("w5", "10.0.0.5", false),
+		liveNode("other", "10.0.0.9", false),
+	}
+
+	p, err := ComputeScalePlan(config, recorded, live)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]NodeAction{
+		"10.0.0.1": NodeActionUnchanged,
+		"10.0.0.3": NodeActionUpdate,
+		"10.0.0.4": NodeActionAdd,
+		"10.0.0.5": NodeActionRemove,
+		"10.0.0.6": NodeActionRemove,
+		"10.0.0.9": NodeActionUnmanaged,
+	}, actions(p))
+	assert.Equal(t, []string{"label zone=a", "taint dedicated=db:NoSchedule"}, p.Changes[1].Details)
+	assert.Equal(t, "w3", p.Changes[1].NodeName)
+	assert.Equal(t, []string{"10.0.0.4"}, addresses(p.NodesToAdd()))
+	assert.Equal(t, []string{"10.0.0.5", "10.0.0.6"}, addresses(p.NodesToRemove()), "only recorded nodes are removed")
+	assert.Equal(t, 1, p.MastersBefore)
+	assert.Equal(t, 1, p.MastersAfter)
+	assert.True(t, p.HasChanges())
+
+	table, err := p.Render("table")
+	require.NoError(t, err)
+	assert.Contains(t, string(table), "REMOVE     10.0.0.6  -")
+	assert.Contains(t, string(table), "Masters: 1 -> 1")
+	raw, err := p.Render("json")
+	require.NoError(t, err)
+	var decoded ScalePlan
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	assert.Len(t, decoded.Changes, 6)
+	_, err = p.Render("xml")
+	assert.Error(t, err)
+}
+
+func TestComputeScalePlan_RejectsRoleChange(t *testing.T) {
+	config := testConfig(testNode("10.0.0.1", enum.RoleMaster), testNode("w1", enum.RoleMaster))
+	live := []corev1.Node{liveNode("m1", "10.0.0.1", true), liveNode("w1", "10.0.0.2", false)}
+	_, err := ComputeScalePlan(config, nil, live)
+	assert.ErrorContains(t, err, "node w1 (w1) is a worker in the cluster but a master in the configuration")
+}
+
+func TestCheckQuorum(t *testing.T) {
+	masters := []model.NodeConfig{testNode("10.0.0.1", enum.RoleMaster), testNode("10.0.0.2", enum.RoleMaster), testNode("10.0.0.3", enum.RoleMaster)}
+	live := []corev1.Node{liveNode("m1", "10.0.0.1", true), liveNode("m2", "10.0.0.2", true), liveNode("m3", "10.0.0.3", true)}
+	controlPlaneEndpoint := &model.ControlPlaneEndpointConfig{Mode: enum.EndpointModeExternal, Address: "api.example.com"}
+
+	planFor := func(t *testing.T, config *model.PlatformConfig, live []corev1.Node) *ScalePlan {
+		config.Cluster.ControlPlaneEndpoint = controlPlaneEndpoint
+		p, err := ComputeScalePlan(config, masters, live)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("removing a master needs consent", func(t *testing.T) {
+		p := planFor(t, testConfig(masters[0], masters[1]), live)
+		assert.ErrorContains(t, p.CheckQuorum(QuorumOptions{}), "--allow-master-removal")
+		require.NoError(t, p.CheckQuorum(QuorumOptions{AllowMasterRemoval: true}))
+		assert.Contains(t, p.Warnings, "an even number of masters (2) tolerates no more failures than 1")
+	})
+
+	t.Run("every master cannot be removed", func(t *testing.T) {
+		p := planFor(t, testConfig(testNode("10.0.0.4", enum.RoleWorker)), live)
+		assert.ErrorContains(t, p.CheckQuorum(QuorumOptions{AllowMasterRemoval: true}), "at least one master")
+	})
+
+	t.Run("removal that breaks quorum", func(t *testing.T) {
+		degraded := []corev1.Node{live[0], notReady(live[1]), live[2]}
+		p := planFor(t, testConfig(masters[1], masters[2]), degraded)
+		assert.ErrorContains(t, p.CheckQuorum(QuorumOptions{AllowMasterRemoval: true}), "removing master 10.0.0.1 would leave 1 healthy of 2 etcd members")
+
+		p = planFor(t, testConfig(masters[0], masters[2]), degraded)
+		require.NoError(t, p.CheckQuorum(QuorumOptions{AllowMasterRemoval: true}), "removing the unhealthy member is safe")
+	})
+
+	t.Run("no change without quorum", func(t *testing.T) {
+		lost := []corev1.Node{live[0], notReady(live[1]), notReady(live[2])}
+		p := planFor(t, testConfig(append(masters, testNode("10.0.0.4", enum.RoleMaster))...), lost)
+		assert.ErrorContains(t, p.CheckQuorum(QuorumOptions{}), "only 1 of 3 masters are Ready")
+	})
+
+	t.Run("adding masters", func(t *testing.T) {
+		p := planFor(t, testConfig(append(masters, testNode("10.0.0.4", enum.RoleMaster), testNode("10.0.0.5", enum.RoleMaster))...), live)
+		require.NoError(t, p.CheckQuorum(QuorumOptions{}))
+		assert.Equal(t, 5, p.MastersAfter)
+		assert.Empty(t, p.Warnings)
+	})
+
+	t.Run("the implicit endpoint master is kept", func(t *testing.T) {
+		p, err := ComputeScalePlan(testConfig(masters[1], masters[2]), masters, live)
+		require.NoError(t, err)
+		assert.ErrorContains(t, p.CheckQuorum(QuorumOptions{AllowMasterRemoval: true}), "configure cluster.controlPlaneEndpoint before removing it")
+	})
+}
+
+// fakeDeployer records the nodes added and removed; the other Deployer methods are not used by scaling.
+// fakeDeployer 记录添加和移除的节点；扩缩容不使用其他 Deployer 方法。
+type fakeDeployer struct {
+	deployer.Deployer
+	client  *fake.Clientset
+	mu      sync.Mutex
+	added   []string
+	removed []string
+}
+
+func (d *fakeDeployer) AddNode(ctx context.Context, _ *model.PlatformConfig, nodeCfg *model.NodeConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.added = append(d.added, nodeCfg.Address)
+	node := liveNode("node-"+nodeCfg.Address, nodeCfg.Address, isMaster(nodeCfg))
+	_, err := d.client.CoreV1().Nodes().Create(ctx, &node, metav1.CreateOptions{})
+	return err
+}
+
+func (d *fakeDeployer) RemoveNode(_ context.Context, _ *model.PlatformConfig, nodeCfg *model.NodeConfig, _ kubernetes.Interface) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removed = append(d.removed, nodeCfg.Address)
+	return nil
+}
+
+func TestApplyScalePlan(t *testing.T) {
+	utils.InitLogger("info", 0)
+	m1, w1 := liveNode("m1", "10.0.0.1", true), liveNode("w1", "10.0.0.2", false)
+	client := fake.NewSimpleClientset(&m1, &w1)
+	dplr := &fakeDeployer{client: client}
+	inventoryDir := t.TempDir()
+	mgr := NewManager(dplr, nil, WithInventoryDir(inventoryDir))
+
+	added := testNode("10.0.0.3", enum.RoleWorker)
+	added.Labels = map[string]string{"zone": "b"}
+	existing := testNode("10.0.0.1", enum.RoleMaster)
+	existing.Annotations = map[string]string{"owner": "platform"}
+	existing.Taints = []string{"node-role.kubernetes.io/control-plane:NoSchedule"}
+	config := testConfig(existing, added)
+	recorded := []model.NodeConfig{testNode("10.0.0.1", enum.RoleMaster), testNode("10.0.0.2", enum.RoleWorker)}
+
+	ctx := context.Background()
+	liveNodes, err := ListHostNodes(ctx, client)
+	require.NoError(t, err)
+	p, err := ComputeScalePlan(config, recorded, liveNodes)
+	require.NoError(t, err)
+	require.NoError(t, p.CheckQuorum(QuorumOptions{}))
+	require.NoError(t, mgr.ApplyScalePlan(ctx, config, p, client))
+
+	assert.Equal(t, []string{"10.0.0.3"}, dplr.added)
+	assert.Equal(t, []string{"10.0.0.2"}, dplr.removed)
+
+	master, err := client.CoreV1().Nodes().Get(ctx, "m1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "platform", master.Annotations["owner"])
+	assert.Equal(t, []corev1.Taint{{Key: controlPlaneLabel, Effect: corev1.TaintEffectNoSchedule}}, master.Spec.Taints)
+	joined, err := client.CoreV1().Nodes().Get(ctx, "node-10.0.0.3", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "b", joined.Labels["zone"], "nodes joined by the apply get their labels too")
+
+	inventory, err := deployer.LoadInventory(deployer.InventoryPath(inventoryDir, "prod"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.3"}, addresses(inventory.Nodes))
+
+	liveNodes, err = ListHostNodes(ctx, client)
+	require.NoError(t, err)
+	p, err = ComputeScalePlan(config, inventory.Nodes, liveNodes)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]NodeAction{"10.0.0.1": NodeActionUnchanged, "10.0.0.3": NodeActionUnchanged, "10.0.0.2": NodeActionUnmanaged}, actions(p),
+		"the fake deployer leaves the removed Node registered, and it is no longer recorded")
+}
+
+func addresses(nodes []model.NodeConfig) []string {
+	var out []string
+	for _, node := range nodes {
+		out = append(out, node.Address)
+	}
+	return out
+}
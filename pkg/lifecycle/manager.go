@@ -7,6 +7,7 @@ package lifecycle
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"time" // Added for timeouts etc. // 添加用于超时等
 
 	"strings"
@@ -17,6 +18,8 @@ import (
 	// "github.com/turtacn/chasi-bod/pkg/builder"  // Assuming a builder orchestrator interface exists // 假设构建器协调器接口存在
 	"github.com/turtacn/chasi-bod/pkg/config/model"
 	"github.com/turtacn/chasi-bod/pkg/deployer" // Assuming deployer orchestrator interface exists // 假设部署器协调器接口存在
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+	"github.com/turtacn/chasi-bod/pkg/deployer/phases"
 	"github.com/turtacn/chasi-bod/pkg/storage"  // Import storage package for backup/restore utilities // 导入 storage 包用于备份/恢复工具
 	"github.com/turtacn/chasi-bod/pkg/vcluster" // Assuming vcluster manager interface exists // 假设 vcluster 管理器接口存在
 	// You might need application deployer as well for app lifecycle management
@@ -53,6 +56,17 @@ type Manager interface {
 	// 如果扩缩容失败则返回错误。
 	ScaleHostCluster(ctx context.Context, config *model.PlatformConfig, nodesToAdd []model.NodeConfig, nodesToRemove []model.NodeConfig, hostK8sClient kubernetes.Interface) error
 
+	// ApplyScalePlan makes the Host Cluster match the configuration: it scales the cluster as planned, then
+	// sets the declared labels, annotations and taints on every node and reconciles the declared storage.
+	// ApplyScalePlan 使 Host 集群与配置一致：按计划扩缩容集群，然后在每个节点上设置声明的标签、注解和污点，并协调声明的存储。
+	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+	// config: The desired platform configuration. / 期望的平台配置。
+	// scalePlan: The plan computed by ComputeScalePlan. / 由 ComputeScalePlan 计算的计划。
+	// hostK8sClient: A Kubernetes client connected to the Host Cluster. / 连接到 Host 集群的 Kubernetes 客户端。
+	// Returns an error if scaling or updating a node fails.
+	// 如果扩缩容或更新节点失败则返回错误。
+	ApplyScalePlan(ctx context.Context, config *model.PlatformConfig, scalePlan *ScalePlan, hostK8sClient kubernetes.Interface) error
+
 	// BackupPlatform backs up the platform state (e.g., etcd, configurations).
 	// BackupPlatform 备份平台状态（例如，etcd、配置）。
 	// ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
@@ -88,6 +102,49 @@ type defaultManager struct {
 	// appDeployer application.Deployer // The application deployer / 应用程序 deployer
 	// Add other dependencies like backup/restore tools
 	// 添加其他依赖项，例如备份/恢复工具
+
+	// parallel controls how node operations fan out during scaling and upgrades
+	// parallel 控制扩缩容和升级期间节点操作如何并行展开
+	parallel deployer.ParallelOptions
+
+	// inventoryDir is where the node inventory is recorded after scaling; empty disables it
+	// inventoryDir 是扩缩容后记录节点清单的位置；为空时禁用
+	inventoryDir string
+
+	// newExecutor creates the NodeExecutor used by backups and restores (SSH by default)
+	// newExecutor 创建备份和恢复使用的 NodeExecutor（默认为 SSH）
+	newExecutor executor.Factory
+}
+
+// Option configures the default Lifecycle Manager.
+// Option 配置默认的 Lifecycle Manager。
+type Option func(*defaultManager)
+
+// WithParallelOptions sets how many nodes are added or upgraded at once and what happens when one fails.
+// WithParallelOptions 设置同时添加或升级多少个节点以及某个节点失败时的处理方式。
+// opts: Parallelism settings. / 并行设置。
+func WithParallelOptions(opts deployer.ParallelOptions) Option {
+	return func(m *defaultManager) {
+		m.parallel = opts
+	}
+}
+
+// WithInventoryDir overrides where the node inventory is recorded (deployer.DefaultInventoryDir by default).
+// WithInventoryDir 覆盖节点清单的记录位置（默认为 deployer.DefaultInventoryDir）。
+// dir: Directory holding inventories. / 存放清单的目录。
+func WithInventoryDir(dir string) Option {
+	return func(m *defaultManager) {
+		m.inventoryDir = dir
+	}
+}
+
+// WithExecutorFactory replaces how backups and restores connect to nodes (executor.NewSSHExecutor by default).
+// WithExecutorFactory 替换备份和恢复连接节点的方式（默认为 executor.NewSSHExecutor）。
+// factory: Creates a NodeExecutor for a node. / 为节点创建 NodeExecutor。
+func WithExecutorFactory(factory executor.Factory) Option {
+	return func(m *defaultManager) {
+		m.newExecutor = factory
+	}
 }
 
 // NewManager creates a new Lifecycle Manager.
@@ -96,15 +153,23 @@ type defaultManager struct {
 // imageBuilder: The platform image builder orchestrator. / 平台镜像构建器协调器。
 // vclusterManager: The vcluster manager. / vcluster 管理器。
 // appDeployer: The application deployer (optional). / 应用程序 deployer（可选）。
+// opts: Optional settings such as WithParallelOptions. / 可选设置，例如 WithParallelOptions。
 // Returns a Lifecycle Manager implementation.
 // 返回 Lifecycle Manager 实现。
-func NewManager(platformDeployer deployer.Deployer, vclusterManager vcluster.Manager /*, appDeployer application.Deployer*/) Manager {
-	return &defaultManager{
+func NewManager(platformDeployer deployer.Deployer, vclusterManager vcluster.Manager /*, appDeployer application.Deployer*/, opts ...Option) Manager {
+	m := &defaultManager{
 		platformDeployer: platformDeployer,
 		// imageBuilder:     imageBuilder,
 		vclusterManager: vclusterManager,
 		// appDeployer: appDeployer,
+		parallel:     deployer.DefaultParallelOptions(),
+		inventoryDir: deployer.DefaultInventoryDir,
+		newExecutor:  executor.NewSSHExecutor,
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	return m
 }
 
 // UpgradePlatform upgrades the platform.
@@ -131,47 +196,55 @@ func (m *defaultManager) UpgradePlatform(ctx context.Context, currentConfig *mod
 	// 这涉及使用平台 deployer 更新节点以使用新镜像和配置。
 	utils.GetLogger().Println("Applying platform upgrade strategy...")
 
-	// Example: Rolling replace strategy for worker nodes (simplified)
-	// 示例：工作节点的滚动替换策略（简化）
-	// Identify nodes to upgrade (e.g., worker nodes that have config changes or need image update)
-	// 识别要升级的节点（例如，有配置更改或需要镜像更新的工作节点）
-	// This requires comparing currentConfig and newConfig to find nodes that need updating.
-	// 这需要比较 currentConfig 和 newConfig 以查找需要更新的节点。
-	// For simplicity, let's assume we upgrade all nodes in the new config that were also in the old config.
-	// 为简单起见，我们假设升级新配置中所有也在旧配置中的节点。
-
 	// TODO: Get Host K8s client using currentConfig (needed to drain/uncordon nodes during rolling upgrade)
 	// TODO: 使用 currentConfig 获取 Host K8s 客户端（滚动升级期间需要排空/取消封锁节点）
 	// hostK8sClient, err := getHostK8sClient(currentConfig) // Need to implement this function
 	// if err != nil { return errors.NewWithCause(errors.ErrTypeSystem, "failed to get host K8s client for upgrade", err) }
 	// var hostK8sClient interface{} // Placeholder - should be kubernetes.Interface
 
-	// Need logic to iterate through nodes, drain, reimage/reconfigure, uncordon/join
-	// 需要逻辑来遍历节点，排空，重新镜像/重新配置，取消封锁/加入
-
-	utils.GetLogger().Println("Placeholder: Implementing rolling upgrade for nodes.")
-	// for _, newNodeCfg := range newConfig.Cluster.Nodes {
-	// 	// Find the corresponding node in currentConfig
-	// 	currentNodeCfg := findNodeConfig(currentConfig, newNodeCfg.Address) // Need helper
-	//
-	// 	// Decide if the node needs upgrade (e.g., if config changed or image needs replacing)
-	// 	needsUpgrade := nodesNeedUpgrade(currentNodeCfg, &newNodeCfg) // Need helper to compare configs
-	//
-	// 	if needsUpgrade {
-	// 		utils.GetLogger().Printf("Upgrading node: %s", newNodeCfg.Address)
-	// 		// 1. Drain node using hostK8sClient
-	// 		// 2. Reimage/reconfigure node using the new image/config (requires deployer phases)
-	// 		// 3. Uncordon/Join node using hostK8sClient/deployer
-	// 	} else {
-	// 		utils.GetLogger().Printf("Node %s does not require upgrade.", newNodeCfg.Address)
-	// 	}
-	// }
-
-	// Handling master node upgrades is particularly complex and version-specific.
-	// 处理主节点升级尤其复杂且与版本相关。
-	utils.GetLogger().Println("Placeholder: Master node upgrade logic needs careful implementation.")
-
-	utils.GetLogger().Println("Platform upgrade completed successfully (placeholder).")
+	// Find the nodes present in both configurations whose effective configuration changed.
+	// 找出同时存在于两个配置中且有效配置发生变化的节点。
+	clusterChanged := clusterSettingsChanged(currentConfig, newConfig)
+	var mastersToUpgrade, workersToUpgrade []model.NodeConfig
+	for _, newNodeCfg := range newConfig.Cluster.Nodes {
+		currentNodeCfg := findNodeConfig(currentConfig, newNodeCfg.Address)
+		if currentNodeCfg == nil {
+			utils.GetLogger().Printf("Node %s is not part of the current config; use scale to add it.", newNodeCfg.Address)
+			continue
+		}
+		if !clusterChanged && !nodesNeedUpgrade(currentNodeCfg, &newNodeCfg) {
+			utils.GetLogger().Printf("Node %s does not require upgrade.", newNodeCfg.Address)
+			continue
+		}
+		if isMaster(&newNodeCfg) {
+			mastersToUpgrade = append(mastersToUpgrade, newNodeCfg)
+		} else {
+			workersToUpgrade = append(workersToUpgrade, newNodeCfg)
+		}
+	}
+
+	// Masters are upgraded one at a time and stop at the first failure to keep the control plane (and etcd quorum) available.
+	// 主节点逐个升级，并在第一次失败时停止，以保持控制平面（和 etcd 仲裁）可用。
+	// TODO: Drain/uncordon each node around the reconfiguration once a Host K8s client is available here.
+	// TODO: 在此处可用 Host K8s 客户端后，在重新配置前后对每个节点执行排空/取消封锁。
+	if len(mastersToUpgrade) > 0 {
+		utils.GetLogger().Printf("Upgrading %d master node(s) one at a time: %s", len(mastersToUpgrade), formatNodeAddresses(mastersToUpgrade))
+		masterOpts := deployer.ParallelOptions{MaxParallel: 1, FailurePolicy: enum.FailurePolicyFailFast}
+		if failed := m.upgradeNodes(ctx, newConfig, mastersToUpgrade, masterOpts); len(failed) > 0 {
+			return errors.NewWithCause(errors.ErrTypeSystem, "master node upgrade failed", failed)
+		}
+	}
+
+	// Workers are upgraded in batches using the configured parallelism and failure policy.
+	// 工作节点使用配置的并行度和失败策略分批升级。
+	if len(workersToUpgrade) > 0 {
+		utils.GetLogger().Printf("Upgrading %d worker node(s) (max parallel %d): %s", len(workersToUpgrade), m.parallel.MaxParallel, formatNodeAddresses(workersToUpgrade))
+		if failed := m.upgradeNodes(ctx, newConfig, workersToUpgrade, m.parallel); len(failed) > 0 {
+			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to upgrade %d of %d worker nodes", len(failed), len(workersToUpgrade)), failed)
+		}
+	}
+
+	utils.GetLogger().Println("Platform upgrade completed successfully.")
 	return nil
 }
 
@@ -184,15 +257,25 @@ func (m *defaultManager) ScaleHostCluster(ctx context.Context, config *model.Pla
 	// 步骤 1：使用平台 deployer 的 AddNode 方法添加新节点
 	if len(nodesToAdd) > 0 {
 		utils.GetLogger().Printf("Adding %d nodes: %s", len(nodesToAdd), formatNodeAddresses(nodesToAdd))
+		var masters, workers []model.NodeConfig
 		for _, nodeCfg := range nodesToAdd {
-			nodeCtx, cancel := context.WithTimeout(ctx, 20*time.Minute) // Context for adding a single node
-			defer cancel()
-			// The deployer's AddNode should handle the phases required for a new node.
-			// deployer 的 AddNode 应该处理新节点所需的阶段。
-			if err := m.platformDeployer.AddNode(nodeCtx, config, &nodeCfg); err != nil {
-				return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to add node %s", nodeCfg.Address), err)
+			if isMaster(&nodeCfg) {
+				masters = append(masters, nodeCfg)
+			} else {
+				workers = append(workers, nodeCfg)
 			}
 		}
+		// Masters join one at a time so that etcd never waits for more than one new member.
+		// 主节点逐个加入，以确保 etcd 永远不会同时等待多个新成员。
+		masterOpts := deployer.ParallelOptions{MaxParallel: 1, FailurePolicy: enum.FailurePolicyFailFast}
+		if failed := m.addNodes(ctx, config, masters, masterOpts); len(failed) > 0 {
+			return errors.NewWithCause(errors.ErrTypeSystem, "failed to add master node", failed)
+		}
+		// Workers are added in batches of at most parallel.MaxParallel; the deployer's AddNode handles the phases required for a new node.
+		// 工作节点按最多 parallel.MaxParallel 个一批添加；deployer 的 AddNode 处理新节点所需的阶段。
+		if failed := m.addNodes(ctx, config, workers, m.parallel); len(failed) > 0 {
+			return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to add %d of %d worker nodes", len(failed), len(workers)), failed)
+		}
 		utils.GetLogger().Println("New nodes added and joined to cluster successfully.")
 	} else {
 		utils.GetLogger().Println("No nodes to add.")
@@ -202,12 +285,15 @@ func (m *defaultManager) ScaleHostCluster(ctx context.Context, config *model.Pla
 	// 步骤 2：使用平台 deployer 的 RemoveNode 方法移除节点
 	if len(nodesToRemove) > 0 {
 		utils.GetLogger().Printf("Removing %d nodes: %s", len(nodesToRemove), formatNodeAddresses(nodesToRemove))
+		// Nodes are removed one at a time so evicted workloads always have somewhere to go.
+		// 节点逐个移除，以确保被驱逐的工作负载始终有地方可去。
 		for _, nodeCfg := range nodesToRemove {
-			nodeCtx, cancel := context.WithTimeout(ctx, 10*time.Minute) // Context for removing a single node
-			defer cancel()
-			// The deployer's RemoveNode should handle draining, deleting from K8s, and OS cleanup.
-			// deployer 的 RemoveNode 应该处理排空、从 K8s 删除以及操作系统清理。
-			if err := m.platformDeployer.RemoveNode(nodeCtx, &nodeCfg, hostK8sClient); err != nil { // Requires Host K8s client
+			nodeCtx, cancel := context.WithTimeout(ctx, 30*time.Minute) // Context for removing a single node, drain included
+			// The deployer's RemoveNode handles draining, etcd membership, deleting from K8s, and OS cleanup.
+			// deployer 的 RemoveNode 处理排空、etcd 成员关系、从 K8s 删除以及操作系统清理。
+			err := m.platformDeployer.RemoveNode(nodeCtx, config, &nodeCfg, hostK8sClient)
+			cancel()
+			if err != nil {
 				return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to remove node %s", nodeCfg.Address), err)
 			}
 		}
@@ -216,13 +302,41 @@ func (m *defaultManager) ScaleHostCluster(ctx context.Context, config *model.Pla
 		utils.GetLogger().Println("No nodes to remove.")
 	}
 
-	// TODO: Update platform configuration state to reflect the new node list after successful scaling
-	// TODO: 扩缩容成功后，更新平台配置状态以反映新的节点列表
+	// Record the new node list so that the next apply knows which nodes chasi-bod manages.
+	// 记录新的节点列表，以便下一次 apply 知道 chasi-bod 管理哪些节点。
+	if m.inventoryDir != "" {
+		path := deployer.InventoryPath(m.inventoryDir, config.Metadata.Name)
+		if err := deployer.SaveInventory(path, config.Metadata.Name, config.Cluster.Nodes); err != nil {
+			utils.GetLogger().Printf("Warning: Failed to record the node inventory: %v", err)
+		}
+	}
 
 	utils.GetLogger().Println("Host Cluster scaling completed successfully.")
 	return nil
 }
 
+// ApplyScalePlan scales the cluster as planned and reconciles the node metadata and the cluster storage.
+// ApplyScalePlan 按计划扩缩容集群并协调节点元数据和集群存储。
+func (m *defaultManager) ApplyScalePlan(ctx context.Context, config *model.PlatformConfig, scalePlan *ScalePlan, hostK8sClient kubernetes.Interface) error {
+	if err := m.ScaleHostCluster(ctx, config, scalePlan.NodesToAdd(), scalePlan.NodesToRemove(), hostK8sClient); err != nil {
+		return err
+	}
+	if err := reconcileNodeMetadata(ctx, config, hostK8sClient); err != nil {
+		return err
+	}
+	if phases.HasStorage(&config.Cluster.Storage) {
+		changes, err := phases.ReconcileStorage(ctx, hostK8sClient, &config.Cluster)
+		for _, change := range changes {
+			utils.GetLogger().Printf("Storage: %s", change)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	utils.GetLogger().Println("Host Cluster matches the configuration.")
+	return nil
+}
+
 // BackupPlatform backs up platform state.
 // BackupPlatform 备份平台状态。
 func (m *defaultManager) BackupPlatform(ctx context.Context, config *model.PlatformConfig, hostK8sClient kubernetes.Interface) error {
@@ -261,10 +375,10 @@ func (m *defaultManager) BackupPlatform(ctx context.Context, config *model.Platf
 			// The certs are usually in /etc/kubernetes/pki/etcd/ on master nodes.
 			// 需要确定正确的 etcdctl 命令，包括证书。
 			// 证书通常位于主节点上的 /etc/kubernetes/pki/etcd/。
-			etcdctlCmd := fmt.Sprintf("sudo etcdctl --endpoints=%s --cacert=/etc/kubernetes/pki/etcd/ca.crt --cert=/etc/kubernetes/pki/etcd/server.crt --key=/etc/kubernetes/pki/etcd/server.key", etcdEndpoints)
+			etcdctlCmd := fmt.Sprintf("etcdctl --endpoints=%s --cacert=/etc/kubernetes/pki/etcd/ca.crt --cert=/etc/kubernetes/pki/etcd/server.crt --key=/etc/kubernetes/pki/etcd/server.key", etcdEndpoints)
 			// Assuming cert paths are standard after kubeadm install // 假设 kubeadm 安装后证书路径是标准的
 
-			err := storage.BackupETCD(ctx, &masterNodeToBackup, etcdctlCmd, backupPathOnNode) // Use storage.BackupETCD
+			err := storage.BackupETCD(ctx, m.newExecutor, &masterNodeToBackup, etcdctlCmd, backupPathOnNode) // Use storage.BackupETCD
 			if err != nil {
 				return errors.NewWithCause(errors.ErrTypeReliability, "ETCD backup failed", err)
 			}
@@ -391,7 +505,7 @@ func (m *defaultManager) RestorePlatform(ctx context.Context, config *model.Plat
 			// 需要小心实现以处理停止/启动服务，并可能重建集群状态。
 			// This is a high-risk operation.
 			// 这是一个高风险操作。
-			err := storage.RestoreETCD(ctx, &masterNodeToRestore, etcdctlCmd, snapshotPathOnNode, etcdDataDirOnNode) // Use storage.RestoreETCD
+			err := storage.RestoreETCD(ctx, m.newExecutor, &masterNodeToRestore, etcdctlCmd, snapshotPathOnNode, etcdDataDirOnNode) // Use storage.RestoreETCD
 			if err != nil {
 				return errors.NewWithCause(errors.ErrTypeReliability, "ETCD restoration failed", err)
 			}
@@ -428,10 +542,6 @@ func (m *defaultManager) RestorePlatform(ctx context.Context, config *model.Plat
 // TODO: Implement helper functions to get Host K8s client (potentially using the restored kubeconfig)
 // TODO: 实现获取 Host K8s 客户端的辅助函数（可能使用恢复的 kubeconfig）
 // func getHostK8sClient(config *model.PlatformConfig) (kubernetes.Interface, error) { ... }
-// TODO: Implement helper functions to compare node configurations for upgrade needs
-// TODO: 实现比较节点配置以判断是否需要升级的辅助函数
-// func nodesNeedUpgrade(currentNode, newNode *model.NodeConfig) bool { ... }
-// func findNodeConfig(config *model.PlatformConfig, address string) *model.NodeConfig { ... }
 // TODO: Implement helper functions for transferring backup files (local, SSH/SFTP, S3, NFS)
 // TODO: 实现传输备份文件的辅助函数（本地、SSH/SFTP、S3、NFS）
 
@@ -442,3 +552,58 @@ func formatNodeAddresses(nodes []model.NodeConfig) string {
 	}
 	return strings.Join(addresses, ", ")
 }
+
+// addNodes joins the given nodes to the cluster.
+// addNodes 将给定节点加入集群。
+func (m *defaultManager) addNodes(ctx context.Context, config *model.PlatformConfig, nodes []model.NodeConfig, opts deployer.ParallelOptions) deployer.NodeErrors {
+	return deployer.RunOnNodes(ctx, "Add Node", nodes, 20*time.Minute, opts, func(nodeCtx context.Context, nodeCfg *model.NodeConfig) error {
+		return m.platformDeployer.AddNode(nodeCtx, config, nodeCfg)
+	})
+}
+
+// upgradeNodes re-applies the node-specific phases of newConfig to the given nodes.
+// upgradeNodes 将 newConfig 的节点特定阶段重新应用到给定节点。
+func (m *defaultManager) upgradeNodes(ctx context.Context, newConfig *model.PlatformConfig, nodes []model.NodeConfig, opts deployer.ParallelOptions) deployer.NodeErrors {
+	return deployer.RunOnNodes(ctx, "Upgrade Node", nodes, 30*time.Minute, opts, func(nodeCtx context.Context, nodeCfg *model.NodeConfig) error {
+		return m.platformDeployer.ReconfigureNode(nodeCtx, newConfig, nodeCfg)
+	})
+}
+
+// findNodeConfig returns the node with the given address, or nil if the config does not contain it.
+// findNodeConfig 返回具有给定地址的节点，如果配置中不包含该节点则返回 nil。
+func findNodeConfig(config *model.PlatformConfig, address string) *model.NodeConfig {
+	for i := range config.Cluster.Nodes {
+		if config.Cluster.Nodes[i].Address == address {
+			return &config.Cluster.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// nodesNeedUpgrade reports whether the node's own configuration changed.
+// nodesNeedUpgrade 报告节点自身的配置是否发生了变化。
+func nodesNeedUpgrade(currentNode, newNode *model.NodeConfig) bool {
+	return !reflect.DeepEqual(currentNode, newNode)
+}
+
+// clusterSettingsChanged reports whether a cluster-wide setting that every node consumes changed.
+// clusterSettingsChanged 报告所有节点都使用的集群范围设置是否发生了变化。
+func clusterSettingsChanged(currentConfig, newConfig *model.PlatformConfig) bool {
+	current, desired := currentConfig.Cluster, newConfig.Cluster
+	return current.KubernetesVersion != desired.KubernetesVersion ||
+		current.ContainerRuntime != desired.ContainerRuntime ||
+		!reflect.DeepEqual(current.Network, desired.Network) ||
+		!reflect.DeepEqual(current.Storage, desired.Storage) ||
+		!reflect.DeepEqual(currentConfig.SysctlConfig, newConfig.SysctlConfig)
+}
+
+// isMaster reports whether the node has the master role.
+// isMaster 报告节点是否具有 master 角色。
+func isMaster(nodeCfg *model.NodeConfig) bool {
+	for _, role := range nodeCfg.Roles {
+		if role == enum.RoleMaster {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package lifecycle
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/turtacn/chasi-bod/common/errors"
+	"github.com/turtacn/chasi-bod/common/types/enum"
+)
+
+// QuorumOptions relaxes the control plane safety checks of an apply.
+// QuorumOptions 放宽 apply 的控制平面安全检查。
+type QuorumOptions struct {
+	// AllowMasterRemoval permits removing masters. Quorum is still enforced.
+	// AllowMasterRemoval 允许移除主节点。仍会强制执行仲裁。
+	AllowMasterRemoval bool
+}
+
+// etcdQuorum returns how many members of an etcd cluster of the given size must be healthy.
+// etcdQuorum 返回给定规模的 etcd 集群中必须健康的成员数。
+func etcdQuorum(members int) int {
+	return members/2 + 1
+}
+
+// CheckQuorum verifies that every step of the plan keeps a majority of etcd members healthy.
+// CheckQuorum 验证计划的每一步都保持大多数 etcd 成员健康。
+// Each master runs a stacked etcd member. The apply adds masters one at a time before it removes any, so the check
+// walks the same order: a new member counts as unhealthy until it has joined, and unhealthy masters are removed first.
+// 每个主节点运行一个堆叠的 etcd 成员。apply 先逐个添加主节点再移除，因此检查按相同顺序进行：新成员在加入之前视为不健康，
+// 不健康的主节点最先被移除。
+// Warnings about an even master count or a lost high availability are added to the plan.
+// 关于主节点数量为偶数或失去高可用性的警告会被添加到计划中。
+// opts: Which changes are permitted. / 允许哪些更改。
+// Returns an error describing the first unsafe step.
+// 返回描述第一个不安全步骤的错误。
+func (p *ScalePlan) CheckQuorum(opts QuorumOptions) error {
+	if p.MastersAfter < 1 {
+		return errors.New(errors.ErrTypeValidation, "the configuration must keep at least one master")
+	}
+
+	members, healthy := 0, 0
+	var added, removed []NodeChange
+	for _, change := range p.Changes {
+		if !slices.Contains(change.Roles, enum.RoleMaster) {
+			continue
+		}
+		if change.Action == NodeActionAdd {
+			added = append(added, change)
+			continue
+		}
+		if !change.live {
+			continue
+		}
+		members++
+		if change.ready {
+			healthy++
+		}
+		if change.Action == NodeActionRemove {
+			removed = append(removed, change)
+		}
+	}
+
+	if len(removed) > 0 && !opts.AllowMasterRemoval {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("the plan removes master(s) %s, which changes the etcd membership; re-run with --allow-master-removal to proceed", changeAddresses(removed)))
+	}
+	for _, change := range removed {
+		if p.endpointMaster != "" && change.Address == p.endpointMaster {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("nodes joined the cluster through master %s; configure cluster.controlPlaneEndpoint before removing it", change.Address))
+		}
+	}
+
+	if members > 0 && healthy < etcdQuorum(members) {
+		return errors.New(errors.ErrTypeValidation, fmt.Sprintf("only %d of %d masters are Ready, below the etcd quorum of %d; restore the control plane before changing it", healthy, members, etcdQuorum(members)))
+	}
+	for _, change := range added {
+		// While the new member joins, the cluster is one member larger but not yet healthier.
+		// 新成员加入期间，集群多了一个成员，但健康成员并未增加。
+		if healthy < etcdQuorum(members+1) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("adding master %s would need %d healthy etcd members while only %d are Ready", change.Address, etcdQuorum(members+1), healthy))
+		}
+		members++
+		healthy++
+	}
+	sort.SliceStable(removed, func(i, j int) bool { return !removed[i].ready && removed[j].ready })
+	for _, change := range removed {
+		members--
+		if change.ready {
+			healthy--
+		}
+		if healthy < etcdQuorum(members) {
+			return errors.New(errors.ErrTypeValidation, fmt.Sprintf("removing master %s would leave %d healthy of %d etcd members, below the quorum of %d", change.Address, healthy, members, etcdQuorum(members)))
+		}
+	}
+
+	if p.MastersAfter != p.MastersBefore && p.MastersAfter%2 == 0 {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("an even number of masters (%d) tolerates no more failures than %d", p.MastersAfter, p.MastersAfter-1))
+	}
+	if p.MastersAfter == 1 && p.MastersBefore > 1 {
+		p.Warnings = append(p.Warnings, "a single master leaves the control plane without high availability")
+	}
+	return nil
+}
+
+// changeAddresses joins the addresses of the changes for messages.
+// changeAddresses 将变更的地址拼接起来用于消息。
+func changeAddresses(changes []NodeChange) string {
+	addresses := make([]string, len(changes))
+	for i, change := range changes {
+		addresses[i] = change.Address
+	}
+	return strings.Join(addresses, ", ")
+}
@@ -5,13 +5,14 @@ package storage
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath" // Added for path joining // 添加用于路径拼接
+	"strconv"
+
 	"github.com/turtacn/chasi-bod/common/errors"
 	"github.com/turtacn/chasi-bod/common/utils" // Assuming logger and file ops are here // 假设日志记录器和文件操作在这里
 	"github.com/turtacn/chasi-bod/pkg/config/model"
-	"path/filepath" // Added for path joining // 添加用于路径拼接
-	// Assuming you have an SSH client utility
-	// 假设您有一个 SSH 客户端工具
-	// "github.com/turtacn/chasi-bod/pkg/sshutil"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
 )
 
 // sysctlBaseDir is the base directory for sysctl entries in /proc.
@@ -21,54 +22,50 @@ const sysctlBaseDir = "/proc/sys" // This constant seems misplaced here, better
 // BackupETCD performs an ETCD snapshot backup on a master node.
 // BackupETCD 在主节点上执行 ETCD 快照备份。
 // ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// newExecutor: Factory used to connect to the node; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
 // nodeCfg: The configuration for the master node where ETCD is running. / 运行 ETCD 的主节点的配置。
-// etcdctlCmdPrefix: The command prefix to execute etcdctl (e.g., "sudo etcdctl --endpoints=<...> --cacert=<...> --cert=<...> --key=<...>").
-// etcdctlCmdPrefix: 执行 etcdctl 的命令前缀（例如，“sudo etcdctl --endpoints=<...> --cacert=<...> --cert=<...> --key=<...>”）。
+// etcdctlCmdPrefix: The command prefix to execute etcdctl, run with sudo (e.g., "etcdctl --endpoints=<...> --cacert=<...> --cert=<...> --key=<...>").
+// etcdctlCmdPrefix: 执行 etcdctl 的命令前缀，将通过 sudo 运行（例如，“etcdctl --endpoints=<...> --cacert=<...> --cert=<...> --key=<...>”）。
 // backupPathOnNode: The destination path on the remote node to save the snapshot. / 远程节点上保存快照的目标路径。
 // Returns an error if the backup fails.
 // 如果备份失败则返回错误。
-func BackupETCD(ctx context.Context, nodeCfg *model.NodeConfig, etcdctlCmdPrefix string, backupPathOnNode string) error {
+func BackupETCD(ctx context.Context, newExecutor executor.Factory, nodeCfg *model.NodeConfig, etcdctlCmdPrefix string, backupPathOnNode string) error {
 	utils.GetLogger().Printf("Performing ETCD backup on node %s to path %s...", nodeCfg.Address, backupPathOnNode)
 
-	// TODO: Get SSH client for the master node
-	// TODO: 获取主节点的 SSH 客户端
-	// sshClient, err := sshutil.NewClient(nodeCfg.Address, nodeCfg.Port, nodeCfg.User, nodeCfg.Password, nodeCfg.PrivateKey)
-	// if err != nil { return errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to create SSH client for %s", nodeCfg.Address), err) }
-	// defer sshClient.Close()
+	exec, err := connectNode(ctx, newExecutor, nodeCfg)
+	if err != nil {
+		return err
+	}
+	defer exec.Close()
 
 	// Ensure the backup directory exists on the remote node
 	// 确保远程节点上的备份目录存在
 	remoteBackupDir := filepath.Dir(backupPathOnNode)
 	utils.GetLogger().Printf("Ensuring remote backup directory %s exists on %s...", remoteBackupDir, nodeCfg.Address)
-	// TODO: sshClient.RunCommand(ctx, fmt.Sprintf("sudo mkdir -p %s && sudo chmod 0700 %s", remoteBackupDir, remoteBackupDir)) // Create directory with restricted permissions
-	// TODO: 在远程节点上执行 mkdir -p 并设置权限
-	utils.GetLogger().Printf("Placeholder: Remote backup directory %s ensured on %s.", remoteBackupDir, nodeCfg.Address)
+	mkdirCmd := fmt.Sprintf("mkdir -p %s && chmod 0700 %s", executor.ShellQuote(remoteBackupDir), executor.ShellQuote(remoteBackupDir)) // Create directory with restricted permissions
+	if _, err := executor.RunCommand(ctx, exec, mkdirCmd, executor.WithSudo()); err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to create backup directory %s on %s", remoteBackupDir, nodeCfg.Address), err)
+	}
 
 	// Construct the full backup command
 	// 构建完整的备份命令
-	backupCmd := fmt.Sprintf("%s snapshot save %s", etcdctlCmdPrefix, backupPathOnNode)
+	backupCmd := fmt.Sprintf("%s snapshot save %s", etcdctlCmdPrefix, executor.ShellQuote(backupPathOnNode))
 
 	utils.GetLogger().Printf("Executing ETCD backup command on %s: %s", nodeCfg.Address, backupCmd)
-	// TODO: Execute the command remotely via SSH
-	// TODO: 通过 SSH 远程执行命令
-	// output, err := sshClient.RunCommand(ctx, backupCmd)
-	// if err != nil {
-	// 	return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to execute ETCD backup command on %s", nodeCfg.Address), err)
-	// }
-	// utils.GetLogger().Printf("ETCD backup command output on %s: %s", nodeCfg.Address, output)
-	utils.GetLogger().Printf("Placeholder: Executed ETCD backup command on %s.", nodeCfg.Address)
+	output, err := executor.RunCommand(ctx, exec, backupCmd, executor.WithSudo())
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeSystem, fmt.Sprintf("failed to execute ETCD backup command on %s", nodeCfg.Address), err)
+	}
+	utils.GetLogger().Printf("ETCD backup command output on %s: %s", nodeCfg.Address, output)
 
 	// Verify the backup file exists and is not empty on the remote node
 	// 验证备份文件是否存在且不为空于远程节点上
-	checkCmd := fmt.Sprintf("sudo test -s %s", backupPathOnNode) // Use test -s to check if file exists and is not empty
+	checkCmd := fmt.Sprintf("test -s %s", executor.ShellQuote(backupPathOnNode)) // Use test -s to check if file exists and is not empty
 	utils.GetLogger().Printf("Verifying backup file existence and size on %s: %s", nodeCfg.Address, checkCmd)
-	// TODO: Execute the check command remotely via SSH
-	// TODO: 通过 SSH 远程执行检查命令
-	// _, err = sshClient.RunCommand(ctx, checkCmd) // test command exits with 0 on success, non-zero on failure
-	// if err != nil {
-	// 	return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("ETCD backup file %s not found or is empty on %s after backup", backupPathOnNode, nodeCfg.Address), err)
-	// }
-	utils.GetLogger().Printf("Placeholder: ETCD backup file %s verified on %s.", backupPathOnNode, nodeCfg.Address)
+	if _, err := executor.RunCommand(ctx, exec, checkCmd, executor.WithSudo()); err != nil { // test command exits with 0 on success, non-zero on failure
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("ETCD backup file %s not found or is empty on %s after backup", backupPathOnNode, nodeCfg.Address), err)
+	}
+	utils.GetLogger().Printf("ETCD backup file %s verified on %s.", backupPathOnNode, nodeCfg.Address)
 
 	utils.GetLogger().Printf("ETCD backup completed successfully on node %s to path %s.", nodeCfg.Address, backupPathOnNode)
 	return nil
@@ -77,23 +74,24 @@ func BackupETCD(ctx context.Context, nodeCfg *model.NodeConfig, etcdctlCmdPrefix
 // BackupConfigFiles backs up specified configuration files from a remote node.
 // BackupConfigFiles 从远程节点备份指定的配置文件。
 // ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// newExecutor: Factory used to connect to the node; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
 // nodeCfg: The configuration for the remote node. / 远程节点的配置。
 // filePaths: A list of file paths on the remote node to back up. / 要备份的远程节点上的文件路径列表。
 // backupDirLocal: The destination directory on the local machine to save the backups. / 本地机器上保存备份的目标目录。
 // Returns an error if the backup fails.
 // 如果备份失败则返回错误。
-func BackupConfigFiles(ctx context.Context, nodeCfg *model.NodeConfig, filePaths []string, backupDirLocal string) error {
+func BackupConfigFiles(ctx context.Context, newExecutor executor.Factory, nodeCfg *model.NodeConfig, filePaths []string, backupDirLocal string) error {
 	if len(filePaths) == 0 {
 		utils.GetLogger().Println("No configuration files specified for backup.")
 		return nil
 	}
 	utils.GetLogger().Printf("Backing up configuration files from node %s to local directory %s...", nodeCfg.Address, backupDirLocal)
 
-	// TODO: Get SSH client for the node
-	// TODO: 获取节点的 SSH 客户端
-	// sshClient, err := sshutil.NewClient(nodeCfg.Address, nodeCfg.Port, nodeCfg.User, nodeCfg.Password, nodeCfg.PrivateKey)
-	// if err != nil { return errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to create SSH client for %s", nodeCfg.Address), err) }
-	// defer sshClient.Close()
+	exec, err := connectNode(ctx, newExecutor, nodeCfg)
+	if err != nil {
+		return err
+	}
+	defer exec.Close()
 
 	// Ensure local backup directory exists
 	// 确保本地备份目录存在
@@ -114,17 +112,13 @@ func BackupConfigFiles(ctx context.Context, nodeCfg *model.NodeConfig, filePaths
 		}
 
 		utils.GetLogger().Printf("Copying file from %s:%s to local %s...", nodeCfg.Address, remotePath, localPath)
-		// TODO: Copy file from remote to local via SSH/SFTP
-		// TODO: 通过 SSH/SFTP 从远程复制文件到本地
-		// Example: err := sshClient.CopyFromRemote(ctx, remotePath, localPath) // Assuming CopyFromRemote exists
-		// if err != nil {
-		// 	utils.GetLogger().Printf("Warning: Failed to backup file %s from %s: %v", remotePath, nodeCfg.Address, err)
-		// 	// Decide if this is a fatal error or just a warning
-		// 	// 决定这是否是致命错误或只是警告
-		// } else {
-		// 	utils.GetLogger().Printf("File %s from %s backed up to local %s.", remotePath, nodeCfg.Address, localPath)
-		// }
-		utils.GetLogger().Printf("Placeholder: File %s from %s backed up to local %s.", remotePath, nodeCfg.Address, localPath)
+		if err := executor.DownloadFile(ctx, exec, remotePath, localPath); err != nil {
+			utils.GetLogger().Printf("Warning: Failed to backup file %s from %s: %v", remotePath, nodeCfg.Address, err)
+			// A missing file should not abort the backup of the others
+			// 缺失的文件不应中止其他文件的备份
+			continue
+		}
+		utils.GetLogger().Printf("File %s from %s backed up to local %s.", remotePath, nodeCfg.Address, localPath)
 	}
 
 	utils.GetLogger().Printf("Configuration file backup completed successfully from node %s.", nodeCfg.Address)
@@ -136,13 +130,14 @@ func BackupConfigFiles(ctx context.Context, nodeCfg *model.NodeConfig, filePaths
 // RestoreETCD 在主节点上从快照恢复 ETCD。
 // 这是一个破坏性操作，需要小心处理。
 // ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// newExecutor: Factory used to connect to the node; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
 // nodeCfg: The configuration for the master node. / 主节点的配置。
 // etcdctlCmdPrefix: The command prefix to execute etcdctl. / 执行 etcdctl 的命令前缀。
 // snapshotPathOnNode: The path to the snapshot file on the remote node. / 远程节点上的快照文件路径。
 // dataDirOnNode: The ETCD data directory on the remote node. / 远程节点上的 ETCD 数据目录。
 // Returns an error if the restoration fails.
 // 如果恢复失败则返回错误。
-func RestoreETCD(ctx context.Context, nodeCfg *model.NodeConfig, etcdctlCmdPrefix string, snapshotPathOnNode string, dataDirOnNode string) error {
+func RestoreETCD(ctx context.Context, newExecutor executor.Factory, nodeCfg *model.NodeConfig, etcdctlCmdPrefix string, snapshotPathOnNode string, dataDirOnNode string) error {
 	utils.GetLogger().Printf("Restoring ETCD on node %s from snapshot %s to data dir %s...", nodeCfg.Address, snapshotPathOnNode, dataDirOnNode)
 
 	// TODO: Implement ETCD restoration logic via SSH
@@ -165,24 +160,25 @@ func RestoreETCD(ctx context.Context, nodeCfg *model.NodeConfig, etcdctlCmdPrefi
 // RestoreConfigFiles restores configuration files to a remote node.
 // RestoreConfigFiles 将配置文件恢复到远程节点。
 // ctx: Context for cancellation and timeouts. / 用于取消和超时的上下文。
+// newExecutor: Factory used to connect to the node; nil means SSH. / 用于连接节点的工厂；nil 表示使用 SSH。
 // nodeCfg: The configuration for the remote node. / 远程节点的配置。
 // backupDirLocal: The local directory containing the backup files (structured by node/filename). / 包含备份文件的本地目录（按节点/文件名结构化）。
 // originalFilePaths: A list of original destination paths on the remote node for the files in backupDirLocal.
 // originalFilePaths: 远程节点上文件的原始目标路径列表（对应于 backupDirLocal 中的文件）。
 // Returns an error if the restoration fails.
 // 如果恢复失败则返回错误。
-func RestoreConfigFiles(ctx context.Context, nodeCfg *model.NodeConfig, backupDirLocal string, originalFilePaths []string) error {
+func RestoreConfigFiles(ctx context.Context, newExecutor executor.Factory, nodeCfg *model.NodeConfig, backupDirLocal string, originalFilePaths []string) error {
 	if len(originalFilePaths) == 0 {
 		utils.GetLogger().Println("No configuration files specified for restoration.")
 		return nil
 	}
 	utils.GetLogger().Printf("Restoring configuration files to node %s from local directory %s...", nodeCfg.Address, backupDirLocal)
 
-	// TODO: Get SSH client for the node
-	// TODO: 获取节点的 SSH 客户端
-	// sshClient, err := sshutil.NewClient(nodeCfg.Address, nodeCfg.Port, nodeCfg.User, nodeCfg.Password, nodeCfg.PrivateKey)
-	// if err != nil { return errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to create SSH client for %s", nodeCfg.Address), err) }
-	// defer sshClient.Close()
+	exec, err := connectNode(ctx, newExecutor, nodeCfg)
+	if err != nil {
+		return err
+	}
+	defer exec.Close()
 
 	nodeLocalBackupDir := filepath.Join(backupDirLocal, nodeCfg.Address)
 
@@ -203,29 +199,57 @@ func RestoreConfigFiles(ctx context.Context, nodeCfg *model.NodeConfig, backupDi
 		}
 
 		utils.GetLogger().Printf("Copying file from local %s to %s:%s...", localPath, nodeCfg.Address, remotePath)
-		// TODO: Copy file from local to remote via SSH/SFTP
-		// Need to handle permissions and ownership on the remote side after copying (sudo cp, chown, chmod)
-		// This often involves using `sudo cp` to overwrite the original file and then `sudo chown`/`sudo chmod`.
-		// 需要在复制后处理远程的权限和所有权（sudo cp, chown, chmod）
-		// 这通常涉及使用 `sudo cp` 覆盖原始文件，然后使用 `sudo chown`/`sudo chmod`。
-		// Example: err := sshClient.CopyToRemote(ctx, localPath, remotePath, "sudo cp -p %s %s && sudo chown --reference=%s %s && sudo chmod --reference=%s %s") // Use --reference to copy original ownership/permissions
-		// If original permissions/ownership are not known from backup, hardcode them or use a default.
-		// 如果备份不知道原始权限/所有权，则对其进行硬编码或使用默认值。
-		// Example simple copy and set permissions:
-		// err := sshClient.CopyToRemote(ctx, localPath, "/tmp/temp-file") // Copy to temp location first
-		// if err != nil { ... continue }
-		// chmodChownCmd := fmt.Sprintf("sudo mv /tmp/temp-file %s && sudo chown root:root %s && sudo chmod 0644 %s", remotePath, remotePath, remotePath) // Example: Set owner root:root and 0644
-		// _, err = sshClient.RunCommand(ctx, chmodChownCmd)
-		// if err != nil { utils.GetLogger().Printf("Warning: Failed to move/chown/chmod %s on %s: %v", remotePath, nodeCfg.Address, err) }
-
-		utils.GetLogger().Printf("Placeholder: File %s restored to %s.", remotePath, nodeCfg.Address)
+		// Keep the permissions of the file currently on the node; the local backup copy is always 0600.
+		// 保留节点上当前文件的权限；本地备份副本始终为 0600。
+		mode := remoteFileMode(ctx, exec, remotePath)
+		if err := restoreFile(ctx, exec, localPath, remotePath, mode); err != nil {
+			utils.GetLogger().Printf("Warning: Failed to restore %s on %s: %v", remotePath, nodeCfg.Address, err)
+			continue
+		}
+		utils.GetLogger().Printf("File %s restored to %s.", remotePath, nodeCfg.Address)
 	}
 
 	utils.GetLogger().Printf("Configuration file restoration completed successfully to node %s.", nodeCfg.Address)
 	return nil
 }
 
-// TODO: Implement sshutil package for remote command execution and file transfer
-// TODO: 实现 sshutil 包用于远程命令执行和文件传输
+// connectNode creates an executor for the node with newExecutor, or over SSH when it is nil.
+// connectNode 使用 newExecutor 为节点创建执行器，为 nil 时使用 SSH。
+func connectNode(ctx context.Context, newExecutor executor.Factory, nodeCfg *model.NodeConfig) (executor.NodeExecutor, error) {
+	if newExecutor == nil {
+		newExecutor = executor.NewSSHExecutor
+	}
+	exec, err := newExecutor(ctx, nodeCfg)
+	if err != nil {
+		return nil, errors.NewWithCause(errors.ErrTypeNetwork, fmt.Sprintf("failed to connect to node %s", nodeCfg.Address), err)
+	}
+	return exec, nil
+}
+
+// remoteFileMode returns the permission bits of a file on the node, or 0644 if it cannot be determined.
+// remoteFileMode 返回节点上文件的权限位，如果无法确定则返回 0644。
+func remoteFileMode(ctx context.Context, exec executor.NodeExecutor, remotePath string) os.FileMode {
+	output, err := executor.RunCommand(ctx, exec, "stat -c %a "+executor.ShellQuote(remotePath), executor.WithSudo())
+	if err != nil {
+		return 0644
+	}
+	mode, err := strconv.ParseUint(output, 8, 32)
+	if err != nil {
+		return 0644
+	}
+	return os.FileMode(mode)
+}
+
+// restoreFile uploads a local backup file to its original location on the node.
+// restoreFile 将本地备份文件上传到节点上的原始位置。
+func restoreFile(ctx context.Context, exec executor.NodeExecutor, localPath, remotePath string, mode os.FileMode) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return errors.NewWithCause(errors.ErrTypeIO, fmt.Sprintf("failed to open local backup file %s", localPath), err)
+	}
+	defer f.Close()
+	return exec.Upload(ctx, f, remotePath, mode)
+}
+
 // TODO: Implement helper functions for transferring backup files to/from remote storage (S3, NFS etc.)
 // TODO: 实现将备份文件传输到/从远程存储（S3、NFS 等）的辅助函数
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/turtacn/chasi-bod/common/utils"
+	"github.com/turtacn/chasi-bod/pkg/config/model"
+	"github.com/turtacn/chasi-bod/pkg/deployer/executor"
+)
+
+// fakeExecutor is a NodeExecutor over an in-memory filesystem that records the commands it runs.
+type fakeExecutor struct {
+	address  string
+	files    map[string]string
+	modes    map[string]os.FileMode
+	commands []string
+	failing  map[string]bool
+	closed   bool
+}
+
+func newFakeExecutor(address string) *fakeExecutor {
+	return &fakeExecutor{address: address, files: map[string]string{}, modes: map[string]os.FileMode{}, failing: map[string]bool{}}
+}
+
+// factory returns an executor.Factory handing out this executor for its address.
+func (e *fakeExecutor) factory() executor.Factory {
+	return func(_ context.Context, nodeCfg *model.NodeConfig) (executor.NodeExecutor, error) {
+		if nodeCfg.Address != e.address {
+			return nil, fmt.Errorf("unknown node %s", nodeCfg.Address)
+		}
+		return e, nil
+	}
+}
+
+func (e *fakeExecutor) Run(_ context.Context, cmd string, _ ...executor.RunOption) (*executor.CommandResult, error) {
+	e.commands = append(e.commands, cmd)
+	result := &executor.CommandResult{Node: e.address, Command: cmd}
+	for prefix, fail := range e.failing {
+		if fail && strings.HasPrefix(cmd, prefix) {
+			result.ExitCode = 1
+		}
+	}
+	if path, ok := strings.CutPrefix(cmd, "stat -c %a "); ok {
+		if mode, ok := e.modes[path]; ok {
+			result.Stdout = fmt.Sprintf("%o\n", mode)
+		} else {
+			result.ExitCode = 1
+		}
+	}
+	return result, nil
+}
+
+func (e *fakeExecutor) Upload(_ context.Context, content io.Reader, remotePath string, mode os.FileMode) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	e.files[remotePath], e.modes[remotePath] = string(data), mode
+	return nil
+}
+
+func (e *fakeExecutor) Download(_ context.Context, remotePath string, w io.Writer) error {
+	data, ok := e.files[remotePath]
+	if !ok {
+		return fmt.Errorf("%s does not exist", remotePath)
+	}
+	_, err := io.WriteString(w, data)
+	return err
+}
+
+func (e *fakeExecutor) Address() string { return e.address }
+func (e *fakeExecutor) Close() error    { e.closed = true; return nil }
+
+func TestBackupETCD(t *testing.T) {
+	utils.InitLogger("info", 0)
+	exec := newFakeExecutor("10.0.0.1")
+	node := &model.NodeConfig{Address: "10.0.0.1"}
+
+	require.NoError(t, BackupETCD(context.Background(), exec.factory(), node, "etcdctl --endpoints=https://127.0.0.1:2379", "/var/backups/etcd/snap.db"))
+	assert.Equal(t, []string{
+		"mkdir -p /var/backups/etcd && chmod 0700 /var/backups/etcd",
+		"etcdctl --endpoints=https://127.0.0.1:2379 snapshot save /var/backups/etcd/snap.db",
+		"test -s /var/backups/etcd/snap.db",
+	}, exec.commands)
+	assert.True(t, exec.closed)
+
+	exec.failing["test -s"] = true
+	err := BackupETCD(context.Background(), exec.factory(), node, "etcdctl", "/var/backups/etcd/snap.db")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found or is empty")
+
+	err = BackupETCD(context.Background(), exec.factory(), &model.NodeConfig{Address: "10.0.0.2"}, "etcdctl", "/tmp/snap.db")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to connect to node 10.0.0.2")
+}
+
+func TestBackupAndRestoreConfigFiles(t *testing.T) {
+	utils.InitLogger("info", 0)
+	exec := newFakeExecutor("10.0.0.1")
+	exec.files["/etc/kubernetes/admin.conf"] = "apiVersion: v1\n"
+	exec.modes["/etc/kubernetes/admin.conf"] = 0600
+	node := &model.NodeConfig{Address: "10.0.0.1"}
+	backupDir := t.TempDir()
+	paths := []string{"/etc/kubernetes/admin.conf", "/etc/missing.conf"}
+
+	require.NoError(t, BackupConfigFiles(context.Background(), exec.factory(), node, paths, backupDir), "a missing file does not abort the backup")
+	content, err := os.ReadFile(filepath.Join(backupDir, "10.0.0.1", "admin.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\n", string(content))
+	_, err = os.Stat(filepath.Join(backupDir, "10.0.0.1", "missing.conf"))
+	assert.True(t, os.IsNotExist(err))
+
+	exec.files["/etc/kubernetes/admin.conf"] = "changed\n"
+	require.NoError(t, RestoreConfigFiles(context.Background(), exec.factory(), node, backupDir, paths))
+	assert.Equal(t, "apiVersion: v1\n", exec.files["/etc/kubernetes/admin.conf"])
+	assert.Equal(t, os.FileMode(0600), exec.modes["/etc/kubernetes/admin.conf"], "the mode of the file on the node is kept")
+	assert.NotContains(t, exec.files, "/etc/missing.conf")
+}
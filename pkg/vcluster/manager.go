@@ -199,10 +199,16 @@ func (m *defaultManager) Create(ctx context.Context, config *model.VClusterConfi
 }
 
 func (m *defaultManager) getVClusterHelmValues(config *model.VClusterConfig) (map[string]interface{}, error) {
+	return HelmValues(config), nil
+}
+
+// HelmValues returns the values passed to the vcluster Helm chart for a vcluster configuration.
+// HelmValues 返回为 vcluster 配置传递给 vcluster Helm chart 的值。
+func HelmValues(config *model.VClusterConfig) map[string]interface{} {
 	// Here you can customize the values for the vcluster helm chart
 	// based on the provided VClusterConfig.
 	// For now, we will use a basic configuration.
-	values := map[string]interface{}{
+	return map[string]interface{}{
 		"syncer": map[string]interface{}{
 			"extraArgs": []string{
 				"--tls-san=" + config.Name + "." + config.Namespace + ".svc",
@@ -212,7 +218,6 @@ func (m *defaultManager) getVClusterHelmValues(config *model.VClusterConfig) (ma
 			"image": "rancher/k3s:" + config.KubernetesVersion,
 		},
 	}
-	return values, nil
 }
 
 // Delete deletes a vcluster instance.